@@ -0,0 +1,13 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the LGPLv3, see COPYING and COPYING.LESSER file for details.
+
+package goose
+
+import (
+	"regexp"
+)
+
+// APIVersionPattern matches the path segment of an endpoint URL giving
+// the API version of a service, such as "v2" or "v2.1". It is shared by
+// the clients and the service doubles.
+var APIVersionPattern = regexp.MustCompile(`^v[0-9]+(\.[0-9]+)*$`)
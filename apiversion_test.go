@@ -0,0 +1,22 @@
+// Copyright 2013 Canonical Ltd.
+// Licensed under the LGPLv3, see COPYING and COPYING.LESSER file for details.
+
+package goose
+
+import (
+	gc "gopkg.in/check.v1"
+)
+
+type APIVersionTestSuite struct {
+}
+
+var _ = gc.Suite(&APIVersionTestSuite{})
+
+func (s *APIVersionTestSuite) TestAPIVersionPattern(c *gc.C) {
+	for _, version := range []string{"v1", "v2", "v2.1", "v3.14.1"} {
+		c.Check(APIVersionPattern.MatchString(version), gc.Equals, true, gc.Commentf("%q", version))
+	}
+	for _, version := range []string{"", "v", "2.1", "v2.", "AUTH_v2", "volume"} {
+		c.Check(APIVersionPattern.MatchString(version), gc.Equals, false, gc.Commentf("%q", version))
+	}
+}
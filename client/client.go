@@ -49,6 +49,14 @@ type AuthenticatingClient interface {
 	EndpointsForRegion(string) identity.ServiceURLs
 }
 
+// TokenCacheSetter is implemented by AuthenticatingClients whose token
+// cache may be replaced, such as those returned by NewClient.
+type TokenCacheSetter interface {
+	// SetTokenCache sets the cache in which the client keeps its
+	// authentication details.
+	SetTokenCache(cache TokenCache)
+}
+
 // A single http client is shared between all Goose clients.
 var sharedHttpClient = goosehttp.New()
 
@@ -66,8 +74,9 @@ var _ Client = (*client)(nil)
 type authenticatingClient struct {
 	client
 
-	creds    *identity.Credentials
-	authMode identity.Authenticator
+	creds      *identity.Credentials
+	authMode   identity.Authenticator
+	tokenCache TokenCache
 
 	auth AuthenticatingClient
 
@@ -90,6 +99,7 @@ func (c *authenticatingClient) EndpointsForRegion(region string) identity.Servic
 }
 
 var _ AuthenticatingClient = (*authenticatingClient)(nil)
+var _ TokenCacheSetter = (*authenticatingClient)(nil)
 
 func NewPublicClient(baseURL string, logger *log.Logger) Client {
 	client := client{baseURL: baseURL, logger: logger, httpClient: sharedHttpClient}
@@ -112,6 +122,7 @@ func newClient(creds *identity.Credentials, auth_method identity.AuthMode, httpC
 	client := authenticatingClient{
 		creds:                &client_creds,
 		requiredServiceTypes: defaultRequiredServiceTypes,
+		tokenCache:           NewTokenCache(DefaultTokenExpirySkew),
 		client:               client{logger: logger, httpClient: httpClient},
 	}
 	client.auth = &client
@@ -156,10 +167,16 @@ func (c *authenticatingClient) SetRequiredServiceTypes(requiredServiceTypes []st
 	c.requiredServiceTypes = requiredServiceTypes
 }
 
+func (c *authenticatingClient) SetTokenCache(cache TokenCache) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokenCache = cache
+}
+
 func (c *authenticatingClient) SendRequest(method, svcType, apiCall string, requestData *goosehttp.RequestData) (err error) {
 	err = c.sendAuthRequest(method, svcType, apiCall, requestData)
 	if gooseerrors.IsUnauthorised(err) {
-		c.setToken("")
+		c.invalidateToken()
 		err = c.sendAuthRequest(method, svcType, apiCall, requestData)
 	}
 	return
@@ -346,10 +363,13 @@ func regionMatches(userRegion, endpointRegion string) bool {
 	return strings.HasSuffix(userRegion, endpointRegion)
 }
 
-func (c *authenticatingClient) setToken(tokenId string) {
+// invalidateToken discards the client's token, which has been
+// rejected, so that the client authenticates again.
+func (c *authenticatingClient) invalidateToken() {
 	c.mu.Lock()
-	c.tokenId = tokenId
-	c.mu.Unlock()
+	defer c.mu.Unlock()
+	c.tokenCache.Invalidate(c.tokenId)
+	c.tokenId = ""
 }
 
 func (c *authenticatingClient) Token() string {
@@ -385,24 +405,40 @@ func (c *authenticatingClient) Authenticate() (err error) {
 	return err
 }
 
+// doAuthenticate authenticates the client, unless its token cache
+// holds authentication details which are not about to expire. The
+// client is not locked while authenticating, so that concurrent
+// callers share the cache's authentication.
 func (c *authenticatingClient) doAuthenticate() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.creds == nil || c.tokenId != "" {
+	if c.creds == nil {
 		return nil
 	}
-	if c.authMode == nil {
-		return fmt.Errorf("Authentication method has not been specified")
+	c.mu.Lock()
+	tokenCache := c.tokenCache
+	c.mu.Unlock()
+	authDetails, err := tokenCache.AuthDetails(func() (*identity.AuthDetails, error) {
+		if c.authMode == nil {
+			return nil, fmt.Errorf("Authentication method has not been specified")
+		}
+		authDetails, err := c.authMode.Auth(c.creds)
+		if err != nil {
+			return nil, gooseerrors.Newf(err, "authentication failed")
+		}
+		return authDetails, nil
+	})
+	if err != nil {
+		return err
 	}
-	var (
-		authDetails *identity.AuthDetails
-		err         error
-	)
-	if authDetails, err = c.authMode.Auth(c.creds); err != nil {
-		return gooseerrors.Newf(err, "authentication failed")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokenId != "" && c.tokenId == authDetails.Token {
+		return nil
 	}
 	c.regionServiceURLs = authDetails.RegionServiceURLs
 	if err := c.createServiceURLs(); err != nil {
+		// Authenticating again may find the services.
+		tokenCache.Invalidate(authDetails.Token)
+		c.tokenId = ""
 		return gooseerrors.Newf(err, "cannot create service URLs")
 	}
 	c.tenantId = authDetails.TenantId
@@ -19,3 +19,9 @@ func SetAuthenticationTimeout(timeout time.Duration) AuthCleanup {
 func SetAuthenticator(client AuthenticatingClient, auth identity.Authenticator) {
 	client.(*authenticatingClient).authMode = auth
 }
+
+// SetTokenCacheClock sets the function a cache returned by
+// NewTokenCache calls to find the current time.
+func SetTokenCacheClock(cache TokenCache, now func() time.Time) {
+	cache.(*tokenCache).now = now
+}
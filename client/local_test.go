@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gc "gopkg.in/check.v1"
@@ -14,6 +15,7 @@ import (
 	"gopkg.in/goose.v1/errors"
 	"gopkg.in/goose.v1/identity"
 	"gopkg.in/goose.v1/swift"
+	"gopkg.in/goose.v1/testing/clock"
 	"gopkg.in/goose.v1/testing/httpsuite"
 	"gopkg.in/goose.v1/testservices"
 	"gopkg.in/goose.v1/testservices/identityservice"
@@ -271,6 +273,92 @@ func (s *localLiveSuite) TestAuthenticationForbidsMultipleCallers(c *gc.C) {
 	c.Assert(err2, gc.IsNil)
 }
 
+// expiringAuth issues tokens which expire an hour after the given
+// clock's time.
+type expiringAuth struct {
+	clock *clock.ManualClock
+	count int
+}
+
+func (auth *expiringAuth) Auth(creds *identity.Credentials) (*identity.AuthDetails, error) {
+	auth.count++
+	return &identity.AuthDetails{
+		Token:             fmt.Sprintf("token%d", auth.count),
+		TenantId:          "tenant",
+		UserId:            "1",
+		RegionServiceURLs: map[string]identity.ServiceURLs{creds.Region: {"compute": "http://localhost"}},
+		Expires:           auth.clock.Now().Add(time.Hour),
+	}, nil
+}
+
+func (s *localLiveSuite) TestTokenCacheRefresh(c *gc.C) {
+	cl := client.NewClient(s.cred, s.authMode, nil)
+	cl.SetRequiredServiceTypes([]string{"compute"})
+	clk := clock.NewManualClock(time.Now())
+	auth := &expiringAuth{clock: clk}
+	client.SetAuthenticator(cl, auth)
+	cache := client.NewTokenCache(time.Minute)
+	client.SetTokenCacheClock(cache, clk.Now)
+	cl.(client.TokenCacheSetter).SetTokenCache(cache)
+
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	c.Assert(cl.Token(), gc.Equals, "token1")
+	clk.Advance(30 * time.Minute)
+	err = cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	c.Assert(cl.Token(), gc.Equals, "token1")
+	// Once the token is about to expire, the client authenticates
+	// again.
+	clk.Advance(29 * time.Minute)
+	err = cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	c.Assert(cl.Token(), gc.Equals, "token2")
+	c.Assert(auth.count, gc.Equals, 2)
+}
+
+// blockingAuth authenticates once it is released.
+type blockingAuth struct {
+	started chan struct{}
+	release chan struct{}
+	count   int32
+}
+
+func (auth *blockingAuth) Auth(creds *identity.Credentials) (*identity.AuthDetails, error) {
+	atomic.AddInt32(&auth.count, 1)
+	auth.started <- struct{}{}
+	<-auth.release
+	return &identity.AuthDetails{
+		Token:             "token",
+		TenantId:          "tenant",
+		UserId:            "1",
+		RegionServiceURLs: map[string]identity.ServiceURLs{creds.Region: {"compute": "http://localhost"}},
+	}, nil
+}
+
+func (s *localLiveSuite) TestConcurrentAuthenticateShared(c *gc.C) {
+	cl := client.NewClient(s.cred, s.authMode, nil)
+	cl.SetRequiredServiceTypes([]string{"compute"})
+	auth := &blockingAuth{started: make(chan struct{}, 1), release: make(chan struct{})}
+	client.SetAuthenticator(cl, auth)
+	const n = 5
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errs <- cl.Authenticate()
+		}()
+	}
+	<-auth.started
+	// The client is not locked while it authenticates.
+	c.Assert(cl.IsAuthenticated(), gc.Equals, false)
+	close(auth.release)
+	for i := 0; i < n; i++ {
+		c.Assert(<-errs, gc.IsNil)
+	}
+	c.Assert(cl.Token(), gc.Equals, "token")
+	c.Assert(atomic.LoadInt32(&auth.count), gc.Equals, int32(1))
+}
+
 type configurableAuth struct {
 	regionsURLs map[string]identity.ServiceURLs
 }
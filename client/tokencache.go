@@ -0,0 +1,98 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/goose.v1/identity"
+)
+
+// DefaultTokenExpirySkew is how long before its token expires that an
+// authentication is refreshed by the cache a client is created with.
+const DefaultTokenExpirySkew = time.Minute
+
+// TokenCache caches the details of an authentication, so that a
+// client may reuse its token until shortly before it expires. A cache
+// may only be shared by clients with the same credentials.
+type TokenCache interface {
+	// AuthDetails returns the cached authentication details, calling
+	// authenticate to replace them if there are none or they are
+	// about to expire. Callers which arrive while authenticate is
+	// running wait for, and share, its result. Details are not cached
+	// if authenticate returns an error.
+	AuthDetails(authenticate func() (*identity.AuthDetails, error)) (*identity.AuthDetails, error)
+
+	// Invalidate discards the cached details if they hold the given
+	// token, for instance because the token has been rejected.
+	Invalidate(token string)
+}
+
+// NewTokenCache returns a TokenCache which holds authentication
+// details in memory, refreshing them once their token is due to expire
+// within skew. Details which do not report an expiry time are kept
+// until they are invalidated.
+func NewTokenCache(skew time.Duration) TokenCache {
+	return &tokenCache{skew: skew, now: time.Now}
+}
+
+type tokenCache struct {
+	skew time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex // protects details and refresh
+	details *identity.AuthDetails
+	refresh *tokenRefresh
+}
+
+// tokenRefresh holds the result of an authentication shared by all
+// callers which asked for it while it was running.
+type tokenRefresh struct {
+	done    chan struct{}
+	details *identity.AuthDetails
+	err     error
+}
+
+func (c *tokenCache) AuthDetails(authenticate func() (*identity.AuthDetails, error)) (*identity.AuthDetails, error) {
+	c.mu.Lock()
+	if c.details != nil && !c.stale(c.details) {
+		details := c.details
+		c.mu.Unlock()
+		return details, nil
+	}
+	refresh := c.refresh
+	if refresh != nil {
+		c.mu.Unlock()
+		<-refresh.done
+		return refresh.details, refresh.err
+	}
+	refresh = &tokenRefresh{done: make(chan struct{})}
+	c.refresh = refresh
+	c.mu.Unlock()
+
+	refresh.details, refresh.err = authenticate()
+	c.mu.Lock()
+	c.refresh = nil
+	if refresh.err == nil {
+		c.details = refresh.details
+	}
+	c.mu.Unlock()
+	close(refresh.done)
+	return refresh.details, refresh.err
+}
+
+func (c *tokenCache) Invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.details != nil && c.details.Token == token {
+		c.details = nil
+	}
+}
+
+// stale reports whether the token of details is due to expire within
+// the cache's skew.
+func (c *tokenCache) stale(details *identity.AuthDetails) bool {
+	if details.Expires.IsZero() {
+		return false
+	}
+	return !c.now().Before(details.Expires.Add(-c.skew))
+}
@@ -0,0 +1,125 @@
+package client_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/client"
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testing/clock"
+)
+
+type tokenCacheSuite struct {
+	clock *clock.ManualClock
+	cache client.TokenCache
+	// authCount counts the calls to authenticate.
+	authCount int
+	// expiry is how long after they are issued the tokens returned
+	// by authenticate expire. Tokens never expire if it is zero.
+	expiry time.Duration
+}
+
+var _ = gc.Suite(&tokenCacheSuite{})
+
+func (s *tokenCacheSuite) SetUpTest(c *gc.C) {
+	s.clock = clock.NewManualClock(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.cache = client.NewTokenCache(time.Minute)
+	client.SetTokenCacheClock(s.cache, s.clock.Now)
+	s.authCount = 0
+	s.expiry = time.Hour
+}
+
+func (s *tokenCacheSuite) authenticate() (*identity.AuthDetails, error) {
+	s.authCount++
+	details := &identity.AuthDetails{Token: fmt.Sprintf("token%d", s.authCount)}
+	if s.expiry != 0 {
+		details.Expires = s.clock.Now().Add(s.expiry)
+	}
+	return details, nil
+}
+
+func (s *tokenCacheSuite) assertToken(c *gc.C, token string) {
+	details, err := s.cache.AuthDetails(s.authenticate)
+	c.Assert(err, gc.IsNil)
+	c.Assert(details.Token, gc.Equals, token)
+}
+
+func (s *tokenCacheSuite) TestReusesToken(c *gc.C) {
+	s.assertToken(c, "token1")
+	s.clock.Advance(58 * time.Minute)
+	s.assertToken(c, "token1")
+	c.Assert(s.authCount, gc.Equals, 1)
+}
+
+func (s *tokenCacheSuite) TestRefreshesTokenWithinSkew(c *gc.C) {
+	s.assertToken(c, "token1")
+	s.clock.Advance(59 * time.Minute)
+	s.assertToken(c, "token2")
+	s.assertToken(c, "token2")
+	c.Assert(s.authCount, gc.Equals, 2)
+}
+
+func (s *tokenCacheSuite) TestTokenWithoutExpiry(c *gc.C) {
+	s.expiry = 0
+	s.assertToken(c, "token1")
+	s.clock.Advance(1000 * time.Hour)
+	s.assertToken(c, "token1")
+}
+
+func (s *tokenCacheSuite) TestErrorNotCached(c *gc.C) {
+	_, err := s.cache.AuthDetails(func() (*identity.AuthDetails, error) {
+		return nil, fmt.Errorf("no luck")
+	})
+	c.Assert(err, gc.ErrorMatches, "no luck")
+	s.assertToken(c, "token1")
+}
+
+func (s *tokenCacheSuite) TestInvalidate(c *gc.C) {
+	s.assertToken(c, "token1")
+	// Invalidating a token which is no longer cached has no effect.
+	s.cache.Invalidate("token0")
+	s.assertToken(c, "token1")
+	s.cache.Invalidate("token1")
+	s.assertToken(c, "token2")
+}
+
+func (s *tokenCacheSuite) TestConcurrentCallersShareRefresh(c *gc.C) {
+	var mu sync.Mutex
+	authCount := 0
+	authStart := make(chan struct{})
+	authenticate := func() (*identity.AuthDetails, error) {
+		mu.Lock()
+		authCount++
+		mu.Unlock()
+		<-authStart
+		return &identity.AuthDetails{Token: "token"}, nil
+	}
+	const callers = 5
+	var started, done sync.WaitGroup
+	started.Add(callers)
+	done.Add(callers)
+	tokens := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		go func(i int) {
+			defer done.Done()
+			started.Done()
+			details, err := s.cache.AuthDetails(authenticate)
+			c.Check(err, gc.IsNil)
+			if details != nil {
+				tokens[i] = details.Token
+			}
+		}(i)
+	}
+	started.Wait()
+	// Give the callers a chance to wait for the first authentication.
+	time.Sleep(10 * time.Millisecond)
+	close(authStart)
+	done.Wait()
+	c.Assert(authCount, gc.Equals, 1)
+	for i, token := range tokens {
+		c.Check(token, gc.Equals, "token", gc.Commentf("caller %d", i))
+	}
+}
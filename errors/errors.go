@@ -49,6 +49,12 @@ func (err *gooseError) Cause() error {
 	return err.cause
 }
 
+// Unwrap returns the error cause, allowing the standard errors.Is and
+// errors.As functions to inspect it.
+func (err *gooseError) Unwrap() error {
+	return err.cause
+}
+
 // CausedBy returns true if this error or its cause are of the specified error code.
 func (err *gooseError) causedBy(code Code) bool {
 	if err.code() == code {
@@ -4,6 +4,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -81,6 +82,8 @@ type RequestData struct {
 	ReqLength      int
 	RespReader     io.ReadCloser
 	RespHeaders    http.Header
+	// Context, if set, is used to cancel the request.
+	Context context.Context
 }
 
 const (
@@ -153,7 +156,7 @@ func (c *Client) JsonRequest(method, url, token string, reqData *RequestData, lo
 		}
 	}
 	headers := createHeaders(reqData.ReqHeaders, contentTypeJSON, token)
-	resp, err := c.sendRequest(
+	resp, err := c.sendRequest(reqData.Context,
 		method, url, bytes.NewReader(body), len(body), headers, reqData.ExpectedStatus, logger)
 	if err != nil {
 		return
@@ -191,7 +194,7 @@ func (c *Client) BinaryRequest(method, url, token string, reqData *RequestData,
 		url += "?" + reqData.Params.Encode()
 	}
 	headers := createHeaders(reqData.ReqHeaders, contentTypeOctetStream, token)
-	resp, err := c.sendRequest(
+	resp, err := c.sendRequest(reqData.Context,
 		method, url, reqData.ReqReader, reqData.ReqLength, headers, reqData.ExpectedStatus, logger)
 	if err != nil {
 		return
@@ -206,12 +209,16 @@ func (c *Client) BinaryRequest(method, url, token string, reqData *RequestData,
 }
 
 // Sends the specified request to URL and checks that the HTTP response status is as expected.
+// ctx: used to cancel the request; may be nil.
 // reqReader: a reader returning the data to send.
 // length: the number of bytes to send.
 // headers: HTTP headers to include with the request.
 // expectedStatus: a slice of allowed response status codes.
-func (c *Client) sendRequest(method, URL string, reqReader io.Reader, length int, headers http.Header,
+func (c *Client) sendRequest(ctx context.Context, method, URL string, reqReader io.Reader, length int, headers http.Header,
 	expectedStatus []int, logger *log.Logger) (*http.Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	reqData := make([]byte, length)
 	if reqReader != nil {
 		nrRead, err := io.ReadFull(reqReader, reqData)
@@ -220,7 +227,7 @@ func (c *Client) sendRequest(method, URL string, reqReader io.Reader, length int
 			return nil, err
 		}
 	}
-	rawResp, err := c.sendRateLimitedRequest(method, URL, headers, reqData, logger)
+	rawResp, err := c.sendRateLimitedRequest(ctx, method, URL, headers, reqData, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -242,7 +249,7 @@ func (c *Client) sendRequest(method, URL string, reqReader io.Reader, length int
 	return rawResp, err
 }
 
-func (c *Client) sendRateLimitedRequest(method, URL string, headers http.Header, reqData []byte,
+func (c *Client) sendRateLimitedRequest(ctx context.Context, method, URL string, headers http.Header, reqData []byte,
 	logger *log.Logger) (resp *http.Response, err error) {
 	for i := 0; i < c.maxSendAttempts; i++ {
 		var reqReader io.Reader
@@ -260,11 +267,16 @@ func (c *Client) sendRateLimitedRequest(method, URL string, headers http.Header,
 			}
 		}
 		req.ContentLength = int64(len(reqData))
-		resp, err = c.Do(req)
+		resp, err = c.Do(req.WithContext(ctx))
 		if err != nil {
+			if ctx.Err() != nil {
+				// Return the context's error as the cause so that callers
+				// can tell cancellation apart from other failures.
+				return nil, errors.Newf(ctx.Err(), "request %s cancelled", URL)
+			}
 			return nil, errors.Newf(err, "failed executing the request %s", URL)
 		}
-		if resp.StatusCode != http.StatusRequestEntityTooLarge || resp.Header.Get("Retry-After") == "" {
+		if !isRateLimited(resp) {
 			return resp, nil
 		}
 		resp.Body.Close()
@@ -278,14 +290,31 @@ func (c *Client) sendRateLimitedRequest(method, URL string, headers http.Header,
 		if logger != nil {
 			logger.Printf("Too many requests, retrying in %dms.", int(retryAfter*1000))
 		}
-		time.Sleep(time.Duration(retryAfter) * time.Second)
+		select {
+		case <-time.After(time.Duration(retryAfter * float64(time.Second))):
+		case <-ctx.Done():
+			return nil, errors.Newf(ctx.Err(), "request %s cancelled", URL)
+		}
 	}
 	return nil, errors.Newf(err, "Maximum number of attempts (%d) reached sending request to %s", c.maxSendAttempts, URL)
 }
 
+// isRateLimited reports whether the response asks the client to retry
+// the request later. Older versions of Nova report this with a 413
+// rather than a 429.
+func isRateLimited(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
 type HttpError struct {
-	StatusCode      int
-	Data            map[string][]string
+	StatusCode int
+	Data       map[string][]string
+	// ErrorResponse holds the decoded JSON error body, if there was one.
+	ErrorResponse   *ErrorResponse
 	url             string
 	responseMessage string
 }
@@ -304,16 +333,22 @@ func (e *HttpError) Error() string {
 func handleError(URL string, resp *http.Response) error {
 	errBytes, _ := ioutil.ReadAll(resp.Body)
 	errInfo := string(errBytes)
+	var errorResponse *ErrorResponse
 	// Check if we have a JSON representation of the failure, if so decode it.
 	if resp.Header.Get("Content-Type") == contentTypeJSON {
-		errorResponse, err := unmarshallError(errBytes)
+		var err error
+		errorResponse, err = unmarshallError(errBytes)
 		//TODO (hduran-8): Obtain a logger and log the error
 		if err == nil {
 			errInfo = errorResponse.Error()
 		}
 	}
 	httpError := &HttpError{
-		resp.StatusCode, map[string][]string(resp.Header), URL, errInfo,
+		StatusCode:      resp.StatusCode,
+		Data:            map[string][]string(resp.Header),
+		ErrorResponse:   errorResponse,
+		url:             URL,
+		responseMessage: errInfo,
 	}
 	switch resp.StatusCode {
 	case http.StatusNotFound:
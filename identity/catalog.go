@@ -0,0 +1,136 @@
+package identity
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"gopkg.in/goose.v1"
+	"gopkg.in/goose.v1/errors"
+)
+
+// Endpoint interfaces, which select the URL of an endpoint returned by
+// ServiceCatalog.EndpointURL.
+const (
+	PublicInterface   = "public"
+	InternalInterface = "internal"
+	AdminInterface    = "admin"
+)
+
+// ServiceCatalog holds the services, and their endpoints, listed in the
+// catalog returned by Keystone on authentication.
+type ServiceCatalog struct {
+	services []serviceResponse
+}
+
+// ParseServiceCatalog returns the service catalog of a Keystone v2
+// access response, the body returned by a successful POST /tokens
+// request.
+func ParseServiceCatalog(data []byte) (*ServiceCatalog, error) {
+	var accessWrapper accessWrapper
+	if err := json.Unmarshal(data, &accessWrapper); err != nil {
+		return nil, errors.Newf(err, "cannot parse access response")
+	}
+	return newServiceCatalog(accessWrapper.Access), nil
+}
+
+func newServiceCatalog(access accessResponse) *ServiceCatalog {
+	return &ServiceCatalog{services: access.ServiceCatalog}
+}
+
+// EndpointURL returns the URL of the given interface, one of
+// PublicInterface, InternalInterface or AdminInterface, of an endpoint
+// of the service of type serviceType in region. An empty iface selects
+// the public URL, and an empty region matches the first endpoint of the
+// service in any region. A NotFound error is returned if there is no
+// such endpoint.
+func (c *ServiceCatalog) EndpointURL(serviceType, region, iface string) (string, error) {
+	if iface == "" {
+		iface = PublicInterface
+	}
+	switch iface {
+	case PublicInterface, InternalInterface, AdminInterface:
+	default:
+		return "", errors.Newf(nil, "unknown endpoint interface %q", iface)
+	}
+	foundService := false
+	for _, service := range c.services {
+		if service.Type != serviceType {
+			continue
+		}
+		foundService = true
+		for _, e := range service.Endpoints {
+			if region != "" && e.Region != region {
+				continue
+			}
+			if url := e.url(iface); url != "" {
+				return url, nil
+			}
+		}
+	}
+	if !foundService {
+		return "", errors.NewNotFoundf(nil, serviceType, "no service of type %q in the catalog", serviceType)
+	}
+	if region == "" {
+		return "", errors.NewNotFoundf(nil, serviceType, "no %s endpoint for service %q", iface, serviceType)
+	}
+	return "", errors.NewNotFoundf(nil, serviceType, "no %s endpoint for service %q in region %q", iface, serviceType, region)
+}
+
+// EndpointURLForVersion returns the URL of an endpoint, as EndpointURL
+// does, normalized to the given API version with VersionedURL.
+func (c *ServiceCatalog) EndpointURLForVersion(serviceType, region, iface, version string) (string, error) {
+	url, err := c.EndpointURL(serviceType, region, iface)
+	if err != nil {
+		return "", err
+	}
+	return VersionedURL(url, version)
+}
+
+// VersionedURL returns endpointURL, an endpoint URL from a service
+// catalog, for the given API version, such as "v2.1". Some catalogs
+// list the base URLs of services, to which the version is appended;
+// others list versioned URLs, the version segment of which is replaced,
+// keeping any segments following it, such as a tenant id. The result
+// has no trailing slash.
+func VersionedURL(endpointURL, version string) (string, error) {
+	if !goose.APIVersionPattern.MatchString(version) {
+		return "", errors.Newf(nil, "invalid API version %q", version)
+	}
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		return "", errors.Newf(err, "invalid endpoint URL %q", endpointURL)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", errors.Newf(nil, "invalid endpoint URL %q", endpointURL)
+	}
+	var segments []string
+	if path := strings.Trim(u.Path, "/"); path != "" {
+		segments = strings.Split(path, "/")
+	}
+	versioned := false
+	for i, segment := range segments {
+		if goose.APIVersionPattern.MatchString(segment) {
+			segments[i] = version
+			versioned = true
+			break
+		}
+	}
+	if !versioned {
+		segments = append(segments, version)
+	}
+	u.Path = "/" + strings.Join(segments, "/")
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// url returns the URL of the given interface of the endpoint.
+func (e endpoint) url(iface string) string {
+	switch iface {
+	case InternalInterface:
+		return e.InternalURL
+	case AdminInterface:
+		return e.AdminURL
+	}
+	return e.PublicURL
+}
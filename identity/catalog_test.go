@@ -0,0 +1,143 @@
+package identity
+
+import (
+	gc "gopkg.in/check.v1"
+
+	gooseerrors "gopkg.in/goose.v1/errors"
+)
+
+type ServiceCatalogSuite struct{}
+
+var _ = gc.Suite(&ServiceCatalogSuite{})
+
+var testAccessResponse = `{"access": {
+	"token": {"id": "token"},
+	"serviceCatalog": [{
+		"name": "nova",
+		"type": "compute",
+		"endpoints": [{
+			"region": "RegionOne",
+			"publicURL": "http://nova1",
+			"internalURL": "http://nova1.internal",
+			"adminURL": "http://nova1.admin"
+		}, {
+			"region": "RegionTwo",
+			"publicURL": "http://nova2"
+		}]
+	}, {
+		"name": "swift",
+		"type": "object-store",
+		"endpoints": [{"region": "RegionTwo", "publicURL": "http://swift2"}]
+	}]
+}}`
+
+func (s *ServiceCatalogSuite) TestEndpointURL(c *gc.C) {
+	catalog, err := ParseServiceCatalog([]byte(testAccessResponse))
+	c.Assert(err, gc.IsNil)
+	for i, t := range []struct {
+		serviceType string
+		region      string
+		iface       string
+		url         string
+	}{
+		{"compute", "RegionOne", PublicInterface, "http://nova1"},
+		{"compute", "RegionOne", InternalInterface, "http://nova1.internal"},
+		{"compute", "RegionOne", AdminInterface, "http://nova1.admin"},
+		{"compute", "RegionOne", "", "http://nova1"},
+		{"compute", "RegionTwo", PublicInterface, "http://nova2"},
+		{"compute", "", PublicInterface, "http://nova1"},
+		{"object-store", "", PublicInterface, "http://swift2"},
+	} {
+		c.Logf("test %d: %s %s %s", i, t.serviceType, t.region, t.iface)
+		url, err := catalog.EndpointURL(t.serviceType, t.region, t.iface)
+		c.Check(err, gc.IsNil)
+		c.Check(url, gc.Equals, t.url)
+	}
+}
+
+func (s *ServiceCatalogSuite) TestEndpointURLNotFound(c *gc.C) {
+	catalog, err := ParseServiceCatalog([]byte(testAccessResponse))
+	c.Assert(err, gc.IsNil)
+	for i, t := range []struct {
+		serviceType string
+		region      string
+		iface       string
+		err         string
+	}{
+		{"volume", "RegionOne", PublicInterface, `no service of type "volume" in the catalog`},
+		{"object-store", "RegionOne", PublicInterface, `no public endpoint for service "object-store" in region "RegionOne"`},
+		{"compute", "RegionTwo", InternalInterface, `no internal endpoint for service "compute" in region "RegionTwo"`},
+		{"object-store", "", AdminInterface, `no admin endpoint for service "object-store"`},
+	} {
+		c.Logf("test %d: %s %s %s", i, t.serviceType, t.region, t.iface)
+		_, err := catalog.EndpointURL(t.serviceType, t.region, t.iface)
+		c.Check(err, gc.ErrorMatches, t.err)
+		c.Check(gooseerrors.IsNotFound(err), gc.Equals, true)
+	}
+}
+
+func (s *ServiceCatalogSuite) TestEndpointURLUnknownInterface(c *gc.C) {
+	catalog, err := ParseServiceCatalog([]byte(testAccessResponse))
+	c.Assert(err, gc.IsNil)
+	_, err = catalog.EndpointURL("compute", "RegionOne", "private")
+	c.Assert(err, gc.ErrorMatches, `unknown endpoint interface "private"`)
+	c.Assert(gooseerrors.IsNotFound(err), gc.Equals, false)
+}
+
+func (s *ServiceCatalogSuite) TestParseServiceCatalogInvalid(c *gc.C) {
+	_, err := ParseServiceCatalog([]byte("not json"))
+	c.Assert(err, gc.ErrorMatches, "cannot parse access response\n.*")
+}
+
+func (s *ServiceCatalogSuite) TestVersionedURL(c *gc.C) {
+	for i, t := range []struct {
+		url     string
+		version string
+		result  string
+	}{
+		// Unversioned base URLs have the version appended.
+		{"https://nova", "v2.1", "https://nova/v2.1"},
+		{"https://nova/", "v2.1", "https://nova/v2.1"},
+		{"https://cloud/compute/", "v2", "https://cloud/compute/v2"},
+		// Versioned URLs have the version replaced.
+		{"https://nova/v2", "v2.1", "https://nova/v2.1"},
+		{"https://nova/v2.1/", "v2.1", "https://nova/v2.1"},
+		{"https://nova:8774/v2/tenant", "v2.1", "https://nova:8774/v2.1/tenant"},
+		{"https://cloud/compute/v2/tenant/", "v2.1", "https://cloud/compute/v2.1/tenant"},
+		{"https://swift/v1/AUTH_tenant", "v1", "https://swift/v1/AUTH_tenant"},
+	} {
+		c.Logf("test %d: %s %s", i, t.url, t.version)
+		result, err := VersionedURL(t.url, t.version)
+		c.Check(err, gc.IsNil)
+		c.Check(result, gc.Equals, t.result)
+	}
+}
+
+func (s *ServiceCatalogSuite) TestVersionedURLInvalid(c *gc.C) {
+	_, err := VersionedURL("https://nova/", "2.1")
+	c.Check(err, gc.ErrorMatches, `invalid API version "2.1"`)
+	_, err = VersionedURL("nova", "v2")
+	c.Check(err, gc.ErrorMatches, `invalid endpoint URL "nova"`)
+	_, err = VersionedURL("://nova", "v2")
+	c.Check(err, gc.ErrorMatches, `invalid endpoint URL "://nova"\n.*`)
+}
+
+func (s *ServiceCatalogSuite) TestEndpointURLForVersion(c *gc.C) {
+	catalog, err := ParseServiceCatalog([]byte(`{"access": {"serviceCatalog": [{
+		"name": "nova",
+		"type": "compute",
+		"endpoints": [
+			{"region": "RegionOne", "publicURL": "http://nova1/"},
+			{"region": "RegionTwo", "publicURL": "http://nova2/v2/tenant"}
+		]
+	}]}}`))
+	c.Assert(err, gc.IsNil)
+	url, err := catalog.EndpointURLForVersion("compute", "RegionOne", "", "v2.1")
+	c.Check(err, gc.IsNil)
+	c.Check(url, gc.Equals, "http://nova1/v2.1")
+	url, err = catalog.EndpointURLForVersion("compute", "RegionTwo", "", "v2.1")
+	c.Check(err, gc.IsNil)
+	c.Check(url, gc.Equals, "http://nova2/v2.1/tenant")
+	_, err = catalog.EndpointURLForVersion("volume", "", "", "v2")
+	c.Check(gooseerrors.IsNotFound(err), gc.Equals, true)
+}
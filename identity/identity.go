@@ -3,9 +3,11 @@
 package identity
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
+	"time"
 
 	goosehttp "gopkg.in/goose.v1/http"
 )
@@ -41,6 +43,12 @@ type AuthDetails struct {
 	TenantId          string
 	UserId            string
 	RegionServiceURLs map[string]ServiceURLs // Service type to endpoint URLs for each region
+	// Expires holds the time at which Token expires. It is zero if
+	// the identity service did not report an expiry time.
+	Expires time.Time
+	// ServiceCatalog holds the catalog returned by the identity
+	// service, if it returned one.
+	ServiceCatalog *ServiceCatalog
 }
 
 // Credentials defines necessary parameters for authentication.
@@ -52,11 +60,36 @@ type Credentials struct {
 	TenantName string // The tenant information for this connection
 }
 
+// AuthError is returned when the identity service rejects an
+// authentication request. StatusCode is the HTTP status of the response,
+// and Code and Message are taken from the Keystone error body, if any.
+type AuthError struct {
+	StatusCode int
+	Code       int
+	Message    string
+}
+
+func (e *AuthError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("authentication failed: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("authentication failed: status %d (code %d): %s", e.StatusCode, e.Code, e.Message)
+}
+
 // Authenticator is implemented by each authentication method.
 type Authenticator interface {
 	Auth(creds *Credentials) (*AuthDetails, error)
 }
 
+// ContextAuthenticator is implemented by authentication methods which
+// can be cancelled. If the context is cancelled or its deadline passes
+// before authentication completes, the returned error's cause is the
+// context's error.
+type ContextAuthenticator interface {
+	Authenticator
+	AuthContext(ctx context.Context, creds *Credentials) (*AuthDetails, error)
+}
+
 // getConfig returns the value of the first available environment
 // variable, among the given ones.
 func getConfig(envVars ...string) (value string) {
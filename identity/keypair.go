@@ -1,6 +1,8 @@
 package identity
 
 import (
+	"context"
+
 	goosehttp "gopkg.in/goose.v1/http"
 )
 
@@ -27,6 +29,10 @@ type authKeypairWrapper struct {
 }
 
 func (u *KeyPair) Auth(creds *Credentials) (*AuthDetails, error) {
+	return u.AuthContext(context.Background(), creds)
+}
+
+func (u *KeyPair) AuthContext(ctx context.Context, creds *Credentials) (*AuthDetails, error) {
 	if u.client == nil {
 		u.client = goosehttp.New()
 	}
@@ -37,5 +43,5 @@ func (u *KeyPair) Auth(creds *Credentials) (*AuthDetails, error) {
 		},
 		TenantName: creds.TenantName}}
 
-	return keystoneAuth(u.client, auth, creds.URL)
+	return keystoneAuth(ctx, u.client, auth, creds.URL)
 }
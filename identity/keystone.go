@@ -1,8 +1,13 @@
 package identity
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
+	"net/http"
+	"time"
 
+	"gopkg.in/goose.v1/errors"
 	goosehttp "gopkg.in/goose.v1/http"
 )
 
@@ -56,14 +61,14 @@ type accessResponse struct {
 // keystoneAuth authenticates to OpenStack cloud using keystone v2 authentication.
 //
 // Uses `client` to submit HTTP requests to `URL`
-// and posts `auth_data` as JSON.
-func keystoneAuth(client *goosehttp.Client, auth_data interface{}, URL string) (*AuthDetails, error) {
+// and posts `auth_data` as JSON. The request is cancelled if `ctx` is.
+func keystoneAuth(ctx context.Context, client *goosehttp.Client, auth_data interface{}, URL string) (*AuthDetails, error) {
 
 	var accessWrapper accessWrapper
-	requestData := goosehttp.RequestData{ReqValue: auth_data, RespValue: &accessWrapper}
+	requestData := goosehttp.RequestData{ReqValue: auth_data, RespValue: &accessWrapper, Context: ctx}
 	err := client.JsonRequest("POST", URL, "", &requestData, nil)
 	if err != nil {
-		return nil, err
+		return nil, authError(err, URL)
 	}
 
 	details := &AuthDetails{}
@@ -73,6 +78,9 @@ func keystoneAuth(client *goosehttp.Client, auth_data interface{}, URL string) (
 		return nil, fmt.Errorf("authentication failed")
 	}
 	details.Token = respToken.Id
+	if expires, err := time.Parse(time.RFC3339, respToken.Expires); err == nil {
+		details.Expires = expires
+	}
 	details.TenantId = respToken.Tenant.Id
 	details.UserId = access.User.Id
 	details.RegionServiceURLs = make(map[string]ServiceURLs, len(access.ServiceCatalog))
@@ -86,5 +94,27 @@ func keystoneAuth(client *goosehttp.Client, auth_data interface{}, URL string) (
 			endpointURLs[service.Type] = service.Endpoints[i].PublicURL
 		}
 	}
+	details.ServiceCatalog = newServiceCatalog(access)
 	return details, nil
 }
+
+// authError converts an error response from the identity service into
+// an *AuthError. Unauthorised responses remain Unauthorised errors, with
+// the *AuthError as their cause. Errors which did not come from an HTTP
+// response are returned unchanged.
+func authError(err error, URL string) error {
+	var httpError *goosehttp.HttpError
+	if !stderrors.As(err, &httpError) {
+		return err
+	}
+	authErr := &AuthError{StatusCode: httpError.StatusCode}
+	if resp := httpError.ErrorResponse; resp != nil {
+		authErr.Code = resp.Code
+		authErr.Message = resp.Message
+	}
+	switch httpError.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return errors.NewUnauthorisedf(authErr, "", "Unauthorised URL %s", URL)
+	}
+	return authErr
+}
@@ -1,10 +1,13 @@
 package identity
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
 
+	"gopkg.in/goose.v1/errors"
 	goosehttp "gopkg.in/goose.v1/http"
 )
 
@@ -13,6 +16,10 @@ type Legacy struct {
 }
 
 func (l *Legacy) Auth(creds *Credentials) (*AuthDetails, error) {
+	return l.AuthContext(context.Background(), creds)
+}
+
+func (l *Legacy) AuthContext(ctx context.Context, creds *Credentials) (*AuthDetails, error) {
 	if l.client == nil {
 		l.client = goosehttp.New()
 	}
@@ -22,15 +29,26 @@ func (l *Legacy) Auth(creds *Credentials) (*AuthDetails, error) {
 	}
 	request.Header.Set("X-Auth-User", creds.User)
 	request.Header.Set("X-Auth-Key", creds.Secrets)
-	response, err := l.client.Do(request)
-	defer response.Body.Close()
+	response, err := l.client.Do(request.WithContext(ctx))
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Newf(ctx.Err(), "authentication request cancelled")
+		}
 		return nil, err
 	}
+	defer response.Body.Close()
 	if response.StatusCode != http.StatusNoContent {
 		content, _ := ioutil.ReadAll(response.Body)
-		return nil, fmt.Errorf("Failed to Authenticate (code %d %s): %s",
-			response.StatusCode, response.Status, content)
+		authErr := &AuthError{
+			StatusCode: response.StatusCode,
+			Code:       response.StatusCode,
+			Message:    strings.TrimSpace(string(content)),
+		}
+		switch response.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return nil, errors.NewUnauthorisedf(authErr, "", "Unauthorised URL %s", creds.URL)
+		}
+		return nil, authErr
 	}
 	details := &AuthDetails{}
 	details.Token = response.Header.Get("X-Auth-Token")
@@ -1,8 +1,12 @@
 package identity
 
 import (
+	"errors"
+	"net/http"
+
 	gc "gopkg.in/check.v1"
 
+	gooseerrors "gopkg.in/goose.v1/errors"
 	"gopkg.in/goose.v1/testing/httpsuite"
 	"gopkg.in/goose.v1/testservices/identityservice"
 )
@@ -38,4 +42,24 @@ func (s *LegacyTestSuite) TestBadAuth(c *gc.C) {
 	auth, err := l.Auth(&creds)
 	c.Assert(err, gc.NotNil)
 	c.Assert(auth, gc.IsNil)
+	c.Assert(gooseerrors.IsUnauthorised(err), gc.Equals, true)
+	var authErr *AuthError
+	c.Assert(errors.As(err, &authErr), gc.Equals, true)
+	c.Assert(authErr.StatusCode, gc.Equals, http.StatusUnauthorized)
+}
+
+func (s *LegacyTestSuite) TestAuthErrorServerError(c *gc.C) {
+	s.Mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("identity backend unavailable\n"))
+	})
+	var l Authenticator = &Legacy{}
+	creds := Credentials{User: "joe-user", URL: s.Server.URL, Secrets: "secrets"}
+	_, err := l.Auth(&creds)
+	c.Assert(gooseerrors.IsUnauthorised(err), gc.Equals, false)
+	var authErr *AuthError
+	c.Assert(errors.As(err, &authErr), gc.Equals, true)
+	c.Assert(authErr.StatusCode, gc.Equals, http.StatusInternalServerError)
+	c.Assert(authErr.Code, gc.Equals, http.StatusInternalServerError)
+	c.Assert(authErr.Message, gc.Equals, "identity backend unavailable")
 }
@@ -1,6 +1,8 @@
 package identity
 
 import (
+	"context"
+
 	goosehttp "gopkg.in/goose.v1/http"
 )
 
@@ -23,6 +25,10 @@ type UserPass struct {
 }
 
 func (u *UserPass) Auth(creds *Credentials) (*AuthDetails, error) {
+	return u.AuthContext(context.Background(), creds)
+}
+
+func (u *UserPass) AuthContext(ctx context.Context, creds *Credentials) (*AuthDetails, error) {
 	if u.client == nil {
 		u.client = goosehttp.New()
 	}
@@ -33,5 +39,5 @@ func (u *UserPass) Auth(creds *Credentials) (*AuthDetails, error) {
 		},
 		TenantName: creds.TenantName}}
 
-	return keystoneAuth(u.client, auth, creds.URL)
+	return keystoneAuth(ctx, u.client, auth, creds.URL)
 }
@@ -1,9 +1,18 @@
 package identity
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
 	gc "gopkg.in/check.v1"
 
+	gooseerrors "gopkg.in/goose.v1/errors"
 	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices/hook"
 	"gopkg.in/goose.v1/testservices/identityservice"
 )
 
@@ -64,6 +73,67 @@ func (s *UserPassTestSuite) TestRegionMatch(c *gc.C) {
 	c.Assert(auth.RegionServiceURLs["RegionOne"]["object-store"], gc.Equals, "http://swift")
 	c.Assert(auth.RegionServiceURLs["zone1.RegionOne"]["compute"], gc.Equals, "http://nova")
 	c.Assert(auth.RegionServiceURLs["zone2.RegionOne"]["compute"], gc.Equals, "http://nova2")
+	url, err := auth.ServiceCatalog.EndpointURL("compute", "zone2.RegionOne", PublicInterface)
+	c.Assert(err, gc.IsNil)
+	c.Assert(url, gc.Equals, "http://nova2")
 	c.Assert(auth.Token, gc.Equals, userInfo.Token)
 	c.Assert(auth.TenantId, gc.Equals, userInfo.TenantId)
 }
+
+func (s *UserPassTestSuite) TestAuthContextCancelled(c *gc.C) {
+	service := identityservice.NewUserPass()
+	service.SetupHTTP(s.Mux)
+	service.AddUser("joe-user", "secrets", "tenant")
+	// The hook blocks until the test is done, and the test waits for
+	// it to return, so that it doesn't outlive the test.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	release := make(chan struct{})
+	defer close(release)
+	wg.Add(1)
+	service.RegisterControlPoint("authorisation", func(sc hook.ServiceControl, args ...interface{}) error {
+		defer wg.Done()
+		<-release
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var l ContextAuthenticator = &UserPass{}
+	creds := Credentials{User: "joe-user", URL: s.Server.URL + "/tokens", Secrets: "secrets"}
+	_, err := l.AuthContext(ctx, &creds)
+	c.Assert(err, gc.NotNil)
+	c.Assert(errors.Is(err, context.DeadlineExceeded), gc.Equals, true)
+}
+
+func (s *UserPassTestSuite) TestAuthErrorBadPassword(c *gc.C) {
+	service := identityservice.NewUserPass()
+	service.SetupHTTP(s.Mux)
+	service.AddUser("joe-user", "secrets", "tenant")
+	var l Authenticator = &UserPass{}
+	creds := Credentials{User: "joe-user", URL: s.Server.URL + "/tokens", Secrets: "wrong"}
+	_, err := l.Auth(&creds)
+	c.Assert(gooseerrors.IsUnauthorised(err), gc.Equals, true)
+	var authErr *AuthError
+	c.Assert(errors.As(err, &authErr), gc.Equals, true)
+	c.Assert(authErr.StatusCode, gc.Equals, http.StatusUnauthorized)
+	c.Assert(authErr.Code, gc.Equals, http.StatusUnauthorized)
+	c.Assert(authErr.Message, gc.Equals, "Invalid user / password")
+}
+
+func (s *UserPassTestSuite) TestAuthErrorServerError(c *gc.C) {
+	service := identityservice.NewUserPass()
+	service.SetupHTTP(s.Mux)
+	service.AddUser("joe-user", "secrets", "tenant")
+	service.RegisterControlPoint("authorisation", func(sc hook.ServiceControl, args ...interface{}) error {
+		return fmt.Errorf("identity backend unavailable")
+	})
+	var l Authenticator = &UserPass{}
+	creds := Credentials{User: "joe-user", URL: s.Server.URL + "/tokens", Secrets: "secrets"}
+	_, err := l.Auth(&creds)
+	c.Assert(gooseerrors.IsUnauthorised(err), gc.Equals, false)
+	var authErr *AuthError
+	c.Assert(errors.As(err, &authErr), gc.Equals, true)
+	c.Assert(authErr.StatusCode, gc.Equals, http.StatusInternalServerError)
+	c.Assert(authErr.Code, gc.Equals, http.StatusInternalServerError)
+	c.Assert(authErr.Message, gc.Equals, "identity backend unavailable")
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"time"
 
 	gc "gopkg.in/check.v1"
 
@@ -74,6 +75,9 @@ func (s *localLiveSuite) SetUpSuite(c *gc.C) {
 	}
 	s.openstack = openstackservice.New(s.cred, identity.AuthUserPass)
 	s.openstack.SetupHTTP(s.Mux)
+	// The live tests wait for servers to become active, so start
+	// them immediately rather than leaving them in BUILD.
+	s.openstack.Nova.RegisterControlPoint("addServer", startServerHook)
 
 	s.testFlavor = "m1.small"
 	s.testImageId = "1"
@@ -157,6 +161,54 @@ func (s *localLiveSuite) TestRateLimitRetryExceeded(c *gc.C) {
 	c.Assert(err.Error(), gc.Matches, "(.|\n)*Maximum number of attempts.*")
 }
 
+// TestTooManyRequestsRetry checks that requests rejected with a 429
+// are retried after the Retry-After delay, and ultimately succeed.
+func (s *localLiveSuite) TestTooManyRequestsRetry(c *gc.C) {
+	var logout bytes.Buffer
+	logger := log.New(&logout, "", log.LstdFlags)
+	novaClient, testGroup := s.setupRetryErrorTest(c, logger)
+	cleanup := s.openstack.Nova.RateLimit("/os-security-groups/", goosehttp.MaxSendAttempts-1, time.Millisecond)
+	defer cleanup()
+	err := novaClient.DeleteSecurityGroup(testGroup.Id)
+	c.Assert(err, gc.IsNil)
+	output := logout.String()
+	c.Assert(strings.Count(output, "Too many requests, retrying in 1ms."), gc.Equals, goosehttp.MaxSendAttempts-1)
+}
+
+// TestTooManyRequestsRetryExceeded checks that an error is raised if
+// every attempt is rejected with a 429.
+func (s *localLiveSuite) TestTooManyRequestsRetryExceeded(c *gc.C) {
+	novaClient, testGroup := s.setupRetryErrorTest(c, nil)
+	cleanup := s.openstack.Nova.RateLimit("/os-security-groups/", goosehttp.MaxSendAttempts, time.Millisecond)
+	defer cleanup()
+	err := novaClient.DeleteSecurityGroup(testGroup.Id)
+	c.Assert(err, gc.ErrorMatches, "(.|\n)*Maximum number of attempts.*")
+}
+
+// TestTokenExpiryReauthentication checks that when a token expires
+// between requests, the client re-authenticates and retries the
+// request with a fresh token.
+func (s *localLiveSuite) TestTokenExpiryReauthentication(c *gc.C) {
+	authClient := client.NewClient(s.cred, identity.AuthUserPass, nil)
+	novaClient := nova.New(authClient)
+	_, err := novaClient.ListFlavors()
+	c.Assert(err, gc.IsNil)
+	oldToken := authClient.Token()
+
+	cleanup := s.openstack.Nova.ExpireTokenOnce(oldToken)
+	defer cleanup()
+	_, err = novaClient.ListFlavors()
+	c.Assert(err, gc.IsNil)
+	c.Assert(authClient.Token(), gc.Not(gc.Equals), oldToken)
+	c.Assert(s.openstack.Nova.TokenExpired(oldToken), gc.Equals, false)
+}
+
+// startServerHook makes newly created servers active.
+func startServerHook(sc hook.ServiceControl, args ...interface{}) error {
+	args[0].(*nova.ServerDetail).Status = nova.StatusActive
+	return nil
+}
+
 func (s *localLiveSuite) addFloatingIPHook(sc hook.ServiceControl) hook.ControlProcessor {
 	return func(sc hook.ServiceControl, args ...interface{}) error {
 		if s.noMoreIPs {
@@ -271,6 +323,24 @@ func (s *localLiveSuite) TestRunServerAvailabilityZoneNotAvailable(c *gc.C) {
 	c.Assert(err, gc.ErrorMatches, "(.|\n)*The requested availability zone is not available(.|\n)*")
 }
 
+func (s *localLiveSuite) TestRunServerUserDataAndConfigDrive(c *gc.C) {
+	inst, err := s.nova.RunServer(nova.RunServerOpts{
+		Name:        testImageName,
+		FlavorId:    s.testFlavorId,
+		ImageId:     s.testImageId,
+		UserData:    []byte("#!/bin/sh\necho hello\n"),
+		ConfigDrive: true,
+	})
+	c.Assert(err, gc.IsNil)
+	defer s.nova.DeleteServer(inst.Id)
+	userData, err := s.openstack.Nova.ServerUserData(inst.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(userData), gc.Equals, "#!/bin/sh\necho hello\n")
+	server, err := s.nova.GetServer(inst.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(server.ConfigDrive, gc.Equals, "True")
+}
+
 func (s *localLiveSuite) TestVolumeAttachments(c *gc.C) {
 
 	instance, err := s.createInstance("test-instance")
@@ -26,6 +26,8 @@ type Network struct {
 	Id    string `json:"id"`    // UUID of the resource
 	Label string `json:"label"` // User-provided name for the network range
 	Cidr  string `json:"cidr"`  // IP range covered by the network
+	// CidrV6 is the IPv6 range covered by a dual-stack network.
+	CidrV6 string `json:"cidr_v6,omitempty"`
 }
 
 // ListNetworks gives details on available networks
@@ -55,6 +55,10 @@ const (
 	FilterMarker       = "marker"        // The ID of the last item in the previous list.
 	FilterLimit        = "limit"         // The page size.
 	FilterChangesSince = "changes-since" // The changes-since time. The list contains servers that have been deleted since the changes-since time.
+	FilterAllTenants   = "all_tenants"   // List the servers of all tenants, rather than only the caller's. Admin only.
+	FilterTenantId     = "tenant_id"     // The tenant which owns the server, when used with FilterAllTenants.
+	FilterTags         = "tags"          // A comma separated list of tags, all of which the server has.
+	FilterNotTags      = "not-tags"      // A comma separated list of tags, not all of which the server has.
 )
 
 // Client provides a means to access the OpenStack Compute Service.
@@ -149,6 +153,9 @@ type FlavorDetail struct {
 	Disk  int    // Available root partition space, in GB
 	Id    string `json:"-"`
 	Links []Link
+	// ExtraSpecs holds the key/value pairs used to give the
+	// scheduler hints about the flavor's requirements.
+	ExtraSpecs map[string]string `json:"extra_specs,omitempty"`
 }
 
 // Allow FlavorDetail slices to be sorted by named attribute.
@@ -203,6 +210,9 @@ func (c *Client) ListServers(filter *Filter) ([]Entity, error) {
 type IPAddress struct {
 	Version int    `json:"version"`
 	Address string `json:"addr"`
+	// Type is "fixed" for an address on one of the server's networks
+	// or "floating" for an associated floating IP, where reported.
+	Type string `json:"OS-EXT-IPS:type,omitempty"`
 }
 
 // ServerDetail describes a server in more detail.
@@ -250,6 +260,25 @@ type ServerDetail struct {
 	UserId string `json:"user_id"`
 
 	AvailabilityZone string `json:"OS-EXT-AZ:availability_zone"`
+
+	// ConfigDrive holds "True" if the server was created with a
+	// config drive, or is empty otherwise.
+	ConfigDrive string `json:"config_drive"`
+
+	// Fault describes why the server failed, if its status is
+	// StatusError.
+	Fault *ServerFault `json:"fault,omitempty"`
+}
+
+// ServerFault describes the failure of a server.
+type ServerFault struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	// Details holds further information, such as a traceback, which
+	// is usually only given to admins.
+	Details string `json:"details,omitempty"`
+	// Created holds the time of the failure in RFC3339 format.
+	Created string `json:"created"`
 }
 
 // ListServersDetail lists all details for available servers.
@@ -316,6 +345,7 @@ type RunServerOpts struct {
 	FlavorId           string              `json:"flavorRef"`                   // Required
 	ImageId            string              `json:"imageRef"`                    // Required
 	UserData           []byte              `json:"user_data"`                   // Optional
+	ConfigDrive        bool                `json:"config_drive,omitempty"`      // Optional
 	SecurityGroupNames []SecurityGroupName `json:"security_groups"`             // Optional
 	Networks           []ServerNetworks    `json:"networks"`                    // Optional
 	AvailabilityZone   string              `json:"availability_zone,omitempty"` // Optional
@@ -677,6 +707,10 @@ func (c *Client) RemoveServerFloatingIP(serverId, address string) error {
 type AvailabilityZone struct {
 	Name  string                `json:"zoneName"`
 	State AvailabilityZoneState `json:"zoneState"`
+	// Hosts maps the names of the hosts in the zone to the state of
+	// the services running on them, keyed by service name. It is
+	// only reported by the detailed availability zone listing.
+	Hosts map[string]map[string]AvailabilityZoneService `json:"hosts,omitempty"`
 }
 
 // AvailabilityZoneState describes an availability zone's state.
@@ -684,6 +718,14 @@ type AvailabilityZoneState struct {
 	Available bool
 }
 
+// AvailabilityZoneService describes the state of a service running on
+// a host in an availability zone.
+type AvailabilityZoneService struct {
+	Available bool   `json:"available"`
+	Active    bool   `json:"active"`
+	UpdatedAt string `json:"updated_at,omitempty"`
+}
+
 // ListAvailabilityZones lists all availability zones.
 //
 // Availability zones are an OpenStack extension; if the server does not
@@ -136,6 +136,9 @@ type ContainerContents struct {
 	LengthBytes  int    `json:"bytes"`
 	ContentType  string `json:"content_type"`
 	LastModified string `json:"last_modified"`
+	// Subdir is set instead of the other fields when the entry is a
+	// pseudo-directory, as returned by a listing with a delimiter.
+	Subdir string `json:"subdir,omitempty"`
 }
 
 // GetObject retrieves the specified object's data.
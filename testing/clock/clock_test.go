@@ -0,0 +1,26 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+type ClockSuite struct{}
+
+func Test(t *testing.T) {
+	gc.TestingT(t)
+}
+
+var _ = gc.Suite(&ClockSuite{})
+
+func (s *ClockSuite) TestManualClock(c *gc.C) {
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	c.Assert(clock.Now(), gc.Equals, start)
+	clock.Advance(time.Hour)
+	c.Assert(clock.Now(), gc.Equals, start.Add(time.Hour))
+	clock.Set(start)
+	c.Assert(clock.Now(), gc.Equals, start)
+}
@@ -0,0 +1,82 @@
+package testservices
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+// LimitRequestBody returns an error if the request has a body larger
+// than MaxBodyBytes, as an API gateway in front of a real service
+// would. The body is read through an http.MaxBytesReader before the
+// request is handled, so that it is rejected before any attempt is
+// made to parse it, and replaced with what was read. The returned
+// error is an http.Handler which serves a 413 response, or a 400
+// response if the body cannot be read at all.
+func (s *ServiceInstance) LimitRequestBody(w http.ResponseWriter, r *http.Request) error {
+	if s.MaxBodyBytes <= 0 || r.Body == nil {
+		return nil
+	}
+	if r.ContentLength > s.MaxBodyBytes {
+		return &bodyTooLargeError{s.MaxBodyBytes}
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.MaxBodyBytes))
+	r.Body.Close()
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &bodyTooLargeError{s.MaxBodyBytes}
+	}
+	if err != nil {
+		return &bodyReadError{err}
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// bodyTooLargeError is returned by LimitRequestBody when a request
+// body is over the limit.
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("Request body is larger than the limit of %d bytes.", e.limit)
+}
+
+func (e *bodyTooLargeError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		OverLimit struct {
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"overLimit"`
+	}
+	resp.OverLimit.Message = e.Error()
+	resp.OverLimit.Code = http.StatusRequestEntityTooLarge
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	// The rest of the body is not read, so the connection cannot be
+	// reused.
+	w.Header().Set("Connection", "close")
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write(body)
+}
+
+// bodyReadError is returned by LimitRequestBody when a request body
+// cannot be read.
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string {
+	return "cannot read request body: " + e.err.Error()
+}
+
+func (e *bodyReadError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, e.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,84 @@
+package testservices
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+)
+
+type BodyLimitSuite struct{}
+
+var _ = gc.Suite(&BodyLimitSuite{})
+
+func newBodyRequest(c *gc.C, body string, contentLength int64) *http.Request {
+	req, err := http.NewRequest("POST", "http://example.com/servers", strings.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	req.ContentLength = contentLength
+	return req
+}
+
+func (s *BodyLimitSuite) TestNoLimit(c *gc.C) {
+	var service ServiceInstance
+	req := newBodyRequest(c, "some data", -1)
+	c.Assert(service.LimitRequestBody(httptest.NewRecorder(), req), gc.IsNil)
+	body, err := ioutil.ReadAll(req.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(body), gc.Equals, "some data")
+}
+
+func (s *BodyLimitSuite) TestBodyWithinLimit(c *gc.C) {
+	service := ServiceInstance{MaxBodyBytes: 9}
+	for _, contentLength := range []int64{9, -1} {
+		req := newBodyRequest(c, "some data", contentLength)
+		c.Assert(service.LimitRequestBody(httptest.NewRecorder(), req), gc.IsNil)
+		body, err := ioutil.ReadAll(req.Body)
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(body), gc.Equals, "some data")
+	}
+}
+
+func (s *BodyLimitSuite) TestBodyTooLarge(c *gc.C) {
+	service := ServiceInstance{MaxBodyBytes: 8}
+	// The body is rejected whether or not its length is given.
+	for _, contentLength := range []int64{9, -1} {
+		c.Logf("Content-Length %d", contentLength)
+		w := httptest.NewRecorder()
+		err := service.LimitRequestBody(w, newBodyRequest(c, "some data", contentLength))
+		c.Assert(err, gc.ErrorMatches, "Request body is larger than the limit of 8 bytes.")
+		err.(http.Handler).ServeHTTP(w, nil)
+		c.Assert(w.Code, gc.Equals, http.StatusRequestEntityTooLarge)
+		c.Assert(w.Body.String(), gc.Equals, `{"overLimit":{"message":"Request body is larger than the limit of 8 bytes.","code":413}}`)
+	}
+}
+
+func (s *BodyLimitSuite) TestNoBody(c *gc.C) {
+	service := ServiceInstance{MaxBodyBytes: 8}
+	req, err := http.NewRequest("GET", "http://example.com/servers", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(service.LimitRequestBody(httptest.NewRecorder(), req), gc.IsNil)
+}
+
+// Check that the limit is applied by a service's handler.
+func (s *BodyLimitSuite) TestHTTPServer(c *gc.C) {
+	service := ServiceInstance{MaxBodyBytes: 8}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := service.LimitRequestBody(w, r); err != nil {
+			err.(http.Handler).ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	resp, err := http.Post(server.URL, "text/plain", bytes.NewReader([]byte("some data")))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusRequestEntityTooLarge)
+	resp, err = http.Post(server.URL, "text/plain", bytes.NewReader([]byte("data")))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+}
@@ -0,0 +1,91 @@
+package testservices
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy describes the cross-origin requests a service double
+// allows.
+type CORSPolicy struct {
+	// AllowedOrigins holds the origins from which requests are
+	// allowed. The origin "*" allows requests from any origin.
+	AllowedOrigins []string
+	// AllowedMethods holds the methods allowed in cross-origin
+	// requests. If empty, all the methods the service supports are
+	// allowed.
+	AllowedMethods []string
+	// AllowedHeaders holds the request headers allowed in
+	// cross-origin requests. If empty, any headers requested in a
+	// preflight request are allowed.
+	AllowedHeaders []string
+	// MaxAge holds how long clients may cache the result of a
+	// preflight request. It is not reported if zero.
+	MaxAge time.Duration
+}
+
+// SetCORSPolicy sets the policy which determines the cross-origin
+// requests the service allows. If policy is nil, no CORS headers are
+// sent.
+func (s *ServiceInstance) SetCORSPolicy(policy *CORSPolicy) {
+	s.corsMu.Lock()
+	defer s.corsMu.Unlock()
+	s.corsPolicy = policy
+}
+
+// allowsOrigin reports whether the policy allows requests from the
+// given origin.
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleOptions responds to OPTIONS requests, including CORS
+// preflight requests, on behalf of a service supporting the given
+// methods, and reports whether it did so. Such requests succeed with
+// an Allow header listing the methods, and the Access-Control-Allow-*
+// headers required by the CORS policy, if any. For other requests
+// from an allowed origin, the Access-Control-Allow-Origin header is
+// set and false is returned, so the request may be handled as usual.
+//
+// OPTIONS requests need no authentication, so this should be called
+// before the request's token is checked.
+func (s *ServiceInstance) HandleOptions(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	s.corsMu.Lock()
+	policy := s.corsPolicy
+	s.corsMu.Unlock()
+	origin := r.Header.Get("Origin")
+	corsAllowed := policy != nil && origin != "" && policy.allowsOrigin(origin)
+	if corsAllowed {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	if r.Method != "OPTIONS" {
+		return false
+	}
+	w.Header().Set("Allow", strings.Join(append(methods, "OPTIONS"), ", "))
+	if corsAllowed && r.Header.Get("Access-Control-Request-Method") != "" {
+		allowedMethods := policy.AllowedMethods
+		if len(allowedMethods) == 0 {
+			allowedMethods = methods
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+		if len(policy.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(policy.AllowedHeaders, ", "))
+		} else if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge/time.Second)))
+		}
+	}
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusOK)
+	return true
+}
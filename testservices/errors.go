@@ -55,6 +55,10 @@ func NewNotFoundError(message string) *ServerError {
 	return serverErrorf(404, message)
 }
 
+func NewMethodNotAllowedError(method string) *ServerError {
+	return serverErrorf(405, "The method %s is not allowed for this resource.", method)
+}
+
 func NewNoMoreFloatingIpsError() *ServerError {
 	return serverErrorf(404, "Zero floating ips available")
 }
@@ -80,6 +84,22 @@ func NewNoSuchFlavorError(id string) *ServerError {
 	return serverErrorf(404, "No such flavor %q", id)
 }
 
+func NewFlavorExtraSpecNotFoundError(flavorId, key string) *ServerError {
+	return serverErrorf(404, "Flavor %s has no extra specs with key %s.", flavorId, key)
+}
+
+func NewInvalidExtraSpecError(key string) *ServerError {
+	return serverErrorf(400, "Invalid extra spec key %q: keys must be between 1 and 255 characters long", key)
+}
+
+func NewNoValidHostError() *ServerError {
+	return serverErrorf(400, "No valid host was found. There are not enough hosts available.")
+}
+
+func NewNoSuchImageError(id string) *ServerError {
+	return serverErrorf(404, "No such image %q", id)
+}
+
 func NewServerByIDNotFoundError(id string) *ServerError {
 	return serverErrorf(404, "No such server %q", id)
 }
@@ -116,6 +136,18 @@ func NewUnknownSecurityGroupError(groupId string) *ServerError {
 	return serverErrorf(409, "Unknown source security group %s", groupId)
 }
 
+func NewSecurityGroupInUseError(groupId string) *ServerError {
+	return serverErrorf(409, "Security group %s is in use", groupId)
+}
+
+func NewInvalidIPProtocolError(protocol string) *ServerError {
+	return serverErrorf(400, "Invalid IP protocol %s", protocol)
+}
+
+func NewInvalidPortRangeError(fromPort, toPort int, reason string) *ServerError {
+	return serverErrorf(400, "Invalid port range %d:%d. %s", fromPort, toPort, reason)
+}
+
 func NewSecurityGroupRuleNotFoundError(ruleId string) *ServerError {
 	return serverErrorf(404, "No such security group rule %s", ruleId)
 }
@@ -132,6 +164,86 @@ func NewServerDoesNotBelongToGroupError(serverId, groupId string) *ServerError {
 	return serverErrorf(400, "Server %q does not belong to group %s", serverId, groupId)
 }
 
+func NewMetadataItemNotFoundError(key string) *ServerError {
+	return serverErrorf(404, "Metadata item %q was not found", key)
+}
+
+func NewMetadataKeyBlankError() *ServerError {
+	return serverErrorf(400, "Metadata property key blank")
+}
+
+func NewMetadataTooLongError(property string, limit int) *ServerError {
+	return serverErrorf(400, "Metadata property %s greater than %d characters", property, limit)
+}
+
+func NewMetadataKeyMismatchError() *ServerError {
+	return serverErrorf(400, "Request body and URI mismatch")
+}
+
+func NewMetadataQuotaExceededError(quota int) *ServerError {
+	return serverErrorf(403, "Quota exceeded for metadata_items: maximum number of metadata items is %d", quota)
+}
+
+func NewInvalidTagError(tag string, maxLength int) *ServerError {
+	return serverErrorf(400, "Invalid tag %q: tags must be 1 to %d characters long and must not contain '/' or ','", tag, maxLength)
+}
+
+func NewTooManyTagsError(limit int) *ServerError {
+	return serverErrorf(400, "The number of tags exceeded the per-server limit %d", limit)
+}
+
+func NewTagNotFoundError(serverId, tag string) *ServerError {
+	return serverErrorf(404, "Instance %s has no tag '%s'", serverId, tag)
+}
+
+func NewKeyPairExistsError(name string) *ServerError {
+	return serverErrorf(409, "Key pair '%s' already exists.", name)
+}
+
+func NewKeyPairNotFoundError(name string) *ServerError {
+	return serverErrorf(404, "Keypair %s not found", name)
+}
+
+func NewKeyPairInUseError(name, serverId string) *ServerError {
+	return serverErrorf(409, "Key pair '%s' is in use by server %s", name, serverId)
+}
+
+func NewInvalidKeyNameError() *ServerError {
+	return serverErrorf(400, "Invalid key_name provided.")
+}
+
+func NewInvalidKeyPairNameError() *ServerError {
+	return serverErrorf(400, "Keypair name must be string and between 1 and 255 characters long")
+}
+
+func NewInvalidPublicKeyError() *ServerError {
+	return serverErrorf(400, "Keypair data is invalid: failed to generate fingerprint")
+}
+
+func NewNoSuchActionError(action string) *ServerError {
+	return serverErrorf(400, "There is no such action: %s", action)
+}
+
+func NewInvalidRebootTypeError(rebootType string) *ServerError {
+	return serverErrorf(400, "Argument 'type' for reboot must be a string with value 'SOFT' or 'HARD', got %q", rebootType)
+}
+
+func NewResizeSameFlavorError() *ServerError {
+	return serverErrorf(400, "When resizing, instances must change flavor!")
+}
+
+func NewServerStateConflictError(action, serverId, status string) *ServerError {
+	return serverErrorf(409, "Cannot '%s' instance %s while it is in status %s", action, serverId, status)
+}
+
+func NewInvalidConsoleLengthError(length interface{}) *ServerError {
+	return serverErrorf(400, "Invalid input for field/attribute length. Value: %v", length)
+}
+
+func NewInvalidConsoleTypeError(consoleType string) *ServerError {
+	return serverErrorf(400, "Invalid console type %s", consoleType)
+}
+
 func NewFloatingIPExistsError(ipID string) *ServerError {
 	return serverErrorf(409, "A floating IP with id %s already exists", ipID)
 }
@@ -140,10 +252,18 @@ func NewFloatingIPNotFoundError(address string) *ServerError {
 	return serverErrorf(404, "No such floating IP %q", address)
 }
 
+func NewFloatingIPPoolNotFoundError(pool string) *ServerError {
+	return serverErrorf(404, "Floating IP pool not found: %s", pool)
+}
+
 func NewServerHasFloatingIPError(serverId, ipId string) *ServerError {
 	return serverErrorf(409, "Server %q already has floating IP %s", serverId, ipId)
 }
 
+func NewFloatingIPAssociatedError(address string) *ServerError {
+	return serverErrorf(400, "Floating IP %s is associated.", address)
+}
+
 func NewNoFloatingIPsToRemoveError(serverId string) *ServerError {
 	return serverErrorf(409, "Server %q does not have any floating IPs to remove", serverId)
 }
@@ -151,3 +271,135 @@ func NewNoFloatingIPsToRemoveError(serverId string) *ServerError {
 func NewNoFloatingIPsError(serverId, ipId string) *ServerError {
 	return serverErrorf(404, "Server %q does not have floating IP %s", serverId, ipId)
 }
+
+func NewNoMoreFixedIPsError(networkId string) *ServerError {
+	return serverErrorf(400, "No fixed IP addresses available for network: %s", networkId)
+}
+
+func NewFixedIPNotInNetworkError(address, networkId string) *ServerError {
+	return serverErrorf(400, "Fixed IP address (%s) does not exist in network (%s).", address, networkId)
+}
+
+func NewFixedIPInUseError(address, serverId string) *ServerError {
+	return serverErrorf(400, "Fixed IP address %s is already in use on instance %s.", address, serverId)
+}
+
+func NewNetworkNotFoundError(networkId string) *ServerError {
+	return serverErrorf(404, "Network %s could not be found.", networkId)
+}
+
+func NewPortExistsError(portId string) *ServerError {
+	return serverErrorf(409, "A port with id %s already exists", portId)
+}
+
+func NewPortNotFoundError(portId string) *ServerError {
+	return serverErrorf(404, "Port id %s could not be found.", portId)
+}
+
+func NewPortInUseError(portId string) *ServerError {
+	return serverErrorf(409, "Port %s is still in use.", portId)
+}
+
+func NewPortNotAttachedError(portId, serverId string) *ServerError {
+	return serverErrorf(404, "Port %s is not attached to server %s", portId, serverId)
+}
+
+func NewBadRequestError(message string) *ServerError {
+	return serverErrorf(400, "%s", message)
+}
+
+func NewVolumeAlreadyExistsError(id string) *ServerError {
+	return serverErrorf(409, "A volume with id %q already exists", id)
+}
+
+func NewVolumeNotFoundError(id string) *ServerError {
+	return serverErrorf(404, "Volume %s could not be found", id)
+}
+
+func NewVolumeNotAvailableError(id, status string) *ServerError {
+	return serverErrorf(409, "Invalid volume: volume %s status must be available, but current status is: %s", id, status)
+}
+
+func NewVolumeAttachmentNotFoundError(serverId, attachmentId string) *ServerError {
+	return serverErrorf(404, "Server %s has no volume attachment %s", serverId, attachmentId)
+}
+
+func NewInvalidVolumeStatusError(id, status, expected string) *ServerError {
+	return serverErrorf(400, "Invalid volume: volume %s has status %q, expected %q", id, status, expected)
+}
+
+func NewSnapshotAlreadyExistsError(id string) *ServerError {
+	return serverErrorf(409, "A snapshot with id %q already exists", id)
+}
+
+func NewSnapshotNotFoundError(id string) *ServerError {
+	return serverErrorf(404, "Snapshot %s could not be found.", id)
+}
+
+func NewInvalidSnapshotStatusError(id, status string) *ServerError {
+	return serverErrorf(400, "Invalid snapshot: Snapshot %s status must be available or error, but current status is: %s", id, status)
+}
+
+func NewVolumeHasSnapshotsError(id string, count int) *ServerError {
+	return serverErrorf(409, "Invalid volume: Volume %s still has %d dependent snapshots.", id, count)
+}
+
+func NewInvalidBlockDeviceMappingError(reason string) *ServerError {
+	return serverErrorf(400, "Block Device Mapping is Invalid: %s", reason)
+}
+
+func NewInvalidBlockDeviceSourceTypeError(sourceType string) *ServerError {
+	return serverErrorf(400, "Invalid input for field/attribute source_type. Value: %s. '%s' is not one of ['volume', 'image', 'snapshot', 'blank']", sourceType, sourceType)
+}
+
+func NewInvalidUserDataError(userData string) *ServerError {
+	return serverErrorf(400, "Invalid input for field/attribute user_data. Value: %s. '%s' is not a 'base64'", userData, userData)
+}
+
+func NewUserDataTooLongError(userData string) *ServerError {
+	return serverErrorf(400, "Invalid input for field/attribute user_data. Value: %s. '%s' is too long", userData, userData)
+}
+
+func NewInvalidConfigDriveError(value interface{}) *ServerError {
+	return serverErrorf(400, "Invalid input for field/attribute config_drive. Value: %v. %v is not of type 'boolean', 'string'", value, value)
+}
+
+func NewMarkerNotFoundError(marker string) *ServerError {
+	return serverErrorf(400, "marker [%s] not found", marker)
+}
+
+func NewInvalidLimitError(limit string) *ServerError {
+	return serverErrorf(400, "limit param must be a non-negative integer, got %q", limit)
+}
+
+func NewInvalidRegexFilterError(filter, value string) *ServerError {
+	return serverErrorf(400, "Invalid filter %s: %s is not a valid regular expression", filter, value)
+}
+
+func NewInvalidMicroversionError(version string) *ServerError {
+	return serverErrorf(400, "API Version String %s is of invalid format. Must be of format MajorNum.MinorNum.", version)
+}
+
+func NewUnsupportedMicroversionError(version, min, max string) *ServerError {
+	return serverErrorf(406, "Version %s is not supported by the API. Minimum is %s and maximum is %s.", version, min, max)
+}
+
+func NewServerGroupNotFoundError(id string) *ServerError {
+	return serverErrorf(404, "Instance group %s could not be found.", id)
+}
+
+func NewInvalidServerGroupPolicyError(policies []string) *ServerError {
+	return serverErrorf(400, "Invalid input for field/attribute policies. Value: %v. Exactly one of affinity, anti-affinity, soft-affinity or soft-anti-affinity is required", policies)
+}
+
+func NewInvalidServerGroupError(group string) *ServerError {
+	return serverErrorf(400, "Invalid server group %s: no such group", group)
+}
+
+func NewServerGroupFullError(id string) *ServerError {
+	return serverErrorf(409, "Cannot add server to anti-affinity server group %s: every host is already in use by the group", id)
+}
+
+func NewHypervisorNotFoundError(id int) *ServerError {
+	return serverErrorf(404, "Hypervisor with ID %d could not be found.", id)
+}
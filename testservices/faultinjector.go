@@ -0,0 +1,144 @@
+package testservices
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Fault describes a response injected by a FaultInjector in place
+// of the one the service double would send.
+type Fault struct {
+	// Delay is how long to wait before responding. If no other
+	// fields are set, the request is then passed on to the service,
+	// which simulates a slow response.
+	Delay time.Duration
+	// StatusCode is the status of the response. If it is zero, the
+	// request is handled by the service after the delay.
+	StatusCode int
+	// Body holds the body of the response.
+	Body string
+	// Header holds any additional response headers.
+	Header http.Header
+	// Reset causes the connection to be closed without any response,
+	// as if it had been reset by the server.
+	Reset bool
+}
+
+// faultSequence holds the faults still to be injected for requests
+// matching a method and URL path pattern.
+type faultSequence struct {
+	method  string
+	pattern *regexp.Regexp
+	faults  []Fault
+}
+
+// FaultInjector is an http.Handler which allows tests to replace the
+// responses of the handler it wraps with errors, dropped connections
+// and slow responses. Wrapping the mux shared by the service doubles
+// lets the same faults be injected into any of them.
+type FaultInjector struct {
+	handler http.Handler
+
+	mu        sync.Mutex // protects sequences
+	sequences []*faultSequence
+}
+
+// NewFaultInjector returns a FaultInjector which passes requests on
+// to handler unless a fault has been injected for them.
+func NewFaultInjector(handler http.Handler) *FaultInjector {
+	return &FaultInjector{handler: handler}
+}
+
+// Inject registers a sequence of faults for requests with the given
+// method whose URL path matches the regular expression pattern. An
+// empty method matches any method. Each matching request consumes the
+// next fault in the sequence; once they are all used, requests are
+// handled as usual. Sequences are consumed in the order they were
+// registered. The returned function discards any faults not yet used.
+func (f *FaultInjector) Inject(method, pattern string, faults ...Fault) func() {
+	seq := &faultSequence{
+		method:  method,
+		pattern: regexp.MustCompile(pattern),
+		faults:  faults,
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sequences = append(f.sequences, seq)
+	return func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		seq.faults = nil
+	}
+}
+
+// nextFault returns the fault to inject for the request, if any.
+func (f *FaultInjector) nextFault(r *http.Request) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var fault Fault
+	found := false
+	sequences := f.sequences[:0]
+	for _, seq := range f.sequences {
+		if !found && (seq.method == "" || seq.method == r.Method) && len(seq.faults) > 0 && seq.pattern.MatchString(r.URL.Path) {
+			fault, seq.faults = seq.faults[0], seq.faults[1:]
+			found = true
+		}
+		if len(seq.faults) > 0 {
+			sequences = append(sequences, seq)
+		}
+	}
+	f.sequences = sequences
+	return fault, found
+}
+
+func (f *FaultInjector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fault, ok := f.nextFault(r)
+	if !ok {
+		f.handler.ServeHTTP(w, r)
+		return
+	}
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	switch {
+	case fault.Reset:
+		resetConnection(w)
+	case fault.StatusCode == 0:
+		f.handler.ServeHTTP(w, r)
+	default:
+		for header, values := range fault.Header {
+			w.Header()[header] = values
+		}
+		// workaround for https://code.google.com/p/go/issues/detail?id=4454
+		w.Header().Set("Content-Length", strconv.Itoa(len(fault.Body)))
+		w.WriteHeader(fault.StatusCode)
+		w.Write([]byte(fault.Body))
+	}
+}
+
+// resetConnection closes the connection underlying w without sending
+// a response. TCP connections are reset rather than closed cleanly.
+func resetConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "cannot reset connection", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
@@ -0,0 +1,119 @@
+package testservices
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+type FaultInjectorSuite struct {
+	server   *httptest.Server
+	injector *FaultInjector
+}
+
+var _ = gc.Suite(&FaultInjectorSuite{})
+
+func (s *FaultInjectorSuite) SetUpTest(c *gc.C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	s.injector = NewFaultInjector(handler)
+	s.server = httptest.NewServer(s.injector)
+}
+
+func (s *FaultInjectorSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+}
+
+func (s *FaultInjectorSuite) do(c *gc.C, client *http.Client, method, path string) (*http.Response, string, error) {
+	req, err := http.NewRequest(method, s.server.URL+path, nil)
+	c.Assert(err, gc.IsNil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	return resp, string(body), nil
+}
+
+func (s *FaultInjectorSuite) assertResponse(c *gc.C, method, path string, code int, body string) *http.Response {
+	resp, content, err := s.do(c, http.DefaultClient, method, path)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, code)
+	c.Assert(content, gc.Equals, body)
+	return resp
+}
+
+func (s *FaultInjectorSuite) TestNoFaults(c *gc.C) {
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
+
+func (s *FaultInjectorSuite) TestSequenceConsumedInOrder(c *gc.C) {
+	s.injector.Inject("GET", "/servers$",
+		Fault{StatusCode: http.StatusInternalServerError, Body: "first"},
+		Fault{
+			StatusCode: http.StatusServiceUnavailable,
+			Body:       `{"error": "second"}`,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+		},
+	)
+	// Requests which do not match are not affected.
+	s.assertResponse(c, "GET", "/v2/flavors", http.StatusOK, "ok")
+	s.assertResponse(c, "POST", "/v2/servers", http.StatusOK, "ok")
+
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusInternalServerError, "first")
+	resp := s.assertResponse(c, "GET", "/v2/servers", http.StatusServiceUnavailable, `{"error": "second"}`)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json")
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
+
+func (s *FaultInjectorSuite) TestAnyMethod(c *gc.C) {
+	s.injector.Inject("", "/servers", Fault{StatusCode: http.StatusInternalServerError}, Fault{StatusCode: http.StatusBadGateway})
+	s.assertResponse(c, "POST", "/v2/servers", http.StatusInternalServerError, "")
+	s.assertResponse(c, "DELETE", "/v2/servers/1", http.StatusBadGateway, "")
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
+
+func (s *FaultInjectorSuite) TestSequencesConsumedInRegistrationOrder(c *gc.C) {
+	s.injector.Inject("GET", "/servers", Fault{StatusCode: http.StatusInternalServerError})
+	s.injector.Inject("GET", "/", Fault{StatusCode: http.StatusBadGateway})
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusInternalServerError, "")
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusBadGateway, "")
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
+
+func (s *FaultInjectorSuite) TestCleanup(c *gc.C) {
+	cleanup := s.injector.Inject("GET", "/servers", Fault{StatusCode: http.StatusInternalServerError}, Fault{StatusCode: http.StatusInternalServerError})
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusInternalServerError, "")
+	cleanup()
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
+
+func (s *FaultInjectorSuite) TestDelay(c *gc.C) {
+	s.injector.Inject("GET", "/servers", Fault{Delay: 50 * time.Millisecond})
+	start := time.Now()
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+	c.Assert(time.Since(start) >= 50*time.Millisecond, gc.Equals, true)
+}
+
+func (s *FaultInjectorSuite) TestDelayTimeout(c *gc.C) {
+	s.injector.Inject("GET", "/servers", Fault{Delay: time.Second})
+	client := &http.Client{Timeout: 10 * time.Millisecond}
+	_, _, err := s.do(c, client, "GET", "/v2/servers")
+	c.Assert(err, gc.NotNil)
+	c.Assert(strings.Contains(err.Error(), "Client.Timeout exceeded"), gc.Equals, true)
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
+
+func (s *FaultInjectorSuite) TestReset(c *gc.C) {
+	s.injector.Inject("GET", "/servers", Fault{Reset: true})
+	_, _, err := s.do(c, http.DefaultClient, "GET", "/v2/servers")
+	c.Assert(err, gc.NotNil)
+	s.assertResponse(c, "GET", "/v2/servers", http.StatusOK, "ok")
+}
@@ -0,0 +1,137 @@
+package testservices
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// NewGzipHandler returns an http.Handler which lets handler accept and
+// send gzip-encoded bodies, as OpenStack endpoints do. Request bodies
+// sent with "Content-Encoding: gzip" are decompressed before handler
+// sees them, and responses to requests which accept gzip encoding are
+// compressed. Like a FaultInjector, it may wrap the mux shared by the
+// service doubles so that all of them support compression.
+func NewGzipHandler(handler http.Handler) http.Handler {
+	return &gzipHandler{handler}
+}
+
+type gzipHandler struct {
+	handler http.Handler
+}
+
+func (h *gzipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		body, err := gunzip(r)
+		if err != nil {
+			http.Error(w, "cannot decompress request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Del("Content-Encoding")
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if !acceptsGzip(r) {
+		h.handler.ServeHTTP(w, r)
+		return
+	}
+	// The whole response is buffered, so that its compressed length
+	// can be given.
+	gw := &gzipResponseWriter{ResponseWriter: w, code: http.StatusOK}
+	h.handler.ServeHTTP(gw, r)
+	if gw.hijacked {
+		return
+	}
+	gw.finish(r)
+}
+
+// gunzip returns the decompressed body of r.
+func gunzip(r *http.Request) ([]byte, error) {
+	defer r.Body.Close()
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// acceptsGzip reports whether the client sending r accepts gzip
+// encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, coding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(coding, ";")
+		if !strings.EqualFold(strings.TrimSpace(parts[0]), "gzip") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(param, "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				// A quality of zero means gzip is not acceptable.
+				q, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter buffers a response so that it can be compressed
+// once it is complete.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+	hijacked    bool
+	body        bytes.Buffer
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.code = code
+	w.wroteHeader = true
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(data)
+}
+
+// Hijack allows handlers which reset connections, such as a
+// FaultInjector, to be wrapped.
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
+
+// finish sends the buffered response, compressing any body which has
+// not already been encoded by the handler.
+func (w *gzipResponseWriter) finish(r *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Accept-Encoding")
+	body := w.body.Bytes()
+	if len(body) > 0 && r.Method != "HEAD" && header.Get("Content-Encoding") == "" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(body)
+		zw.Close()
+		body = buf.Bytes()
+		header.Set("Content-Encoding", "gzip")
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(body)
+}
@@ -0,0 +1,137 @@
+package testservices
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	gc "gopkg.in/check.v1"
+)
+
+type GzipSuite struct {
+	server *httptest.Server
+	// received holds the body of the last request the wrapped
+	// handler received.
+	received string
+}
+
+var _ = gc.Suite(&GzipSuite{})
+
+func (s *GzipSuite) SetUpTest(c *gc.C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		c.Check(err, gc.IsNil)
+		c.Check(r.ContentLength, gc.Equals, int64(len(body)))
+		c.Check(r.Header.Get("Content-Encoding"), gc.Equals, "")
+		s.received = string(body)
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("response body"))
+	})
+	s.server = httptest.NewServer(NewGzipHandler(handler))
+	s.received = ""
+}
+
+func (s *GzipSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+}
+
+func compress(c *gc.C, data string) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	c.Assert(err, gc.IsNil)
+	c.Assert(zw.Close(), gc.IsNil)
+	return buf.Bytes()
+}
+
+// do sends a request, without the transparent decompression the
+// default client would otherwise do.
+func (s *GzipSuite) do(c *gc.C, method string, body []byte, header http.Header) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, s.server.URL+"/path", bytes.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	transport := &http.Transport{DisableCompression: true}
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	return resp, content
+}
+
+func (s *GzipSuite) TestPlain(c *gc.C) {
+	resp, content := s.do(c, "POST", []byte("request body"), nil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	c.Assert(resp.Header.Get("Content-Encoding"), gc.Equals, "")
+	c.Assert(string(content), gc.Equals, "response body")
+	c.Assert(s.received, gc.Equals, "request body")
+}
+
+func (s *GzipSuite) TestCompressedRequest(c *gc.C) {
+	header := http.Header{"Content-Encoding": {"gzip"}}
+	resp, content := s.do(c, "POST", compress(c, "request body"), header)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	c.Assert(string(content), gc.Equals, "response body")
+	c.Assert(s.received, gc.Equals, "request body")
+}
+
+func (s *GzipSuite) TestBadCompressedRequest(c *gc.C) {
+	header := http.Header{"Content-Encoding": {"gzip"}}
+	resp, _ := s.do(c, "POST", []byte("not gzip"), header)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	c.Assert(s.received, gc.Equals, "")
+}
+
+func (s *GzipSuite) TestCompressedResponse(c *gc.C) {
+	header := http.Header{"Accept-Encoding": {"deflate, gzip"}}
+	resp, content := s.do(c, "GET", nil, header)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	c.Assert(resp.Header.Get("Content-Encoding"), gc.Equals, "gzip")
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain")
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, strconv.Itoa(len(content)))
+	c.Assert(resp.Header.Get("Vary"), gc.Equals, "Accept-Encoding")
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	c.Assert(err, gc.IsNil)
+	data, err := ioutil.ReadAll(zr)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "response body")
+}
+
+func (s *GzipSuite) TestGzipNotAcceptable(c *gc.C) {
+	header := http.Header{"Accept-Encoding": {"gzip;q=0"}}
+	resp, content := s.do(c, "GET", nil, header)
+	c.Assert(resp.Header.Get("Content-Encoding"), gc.Equals, "")
+	c.Assert(string(content), gc.Equals, "response body")
+}
+
+func (s *GzipSuite) TestDefaultClient(c *gc.C) {
+	// Go's client asks for gzip encoding and decompresses the
+	// response itself.
+	resp, err := http.Get(s.server.URL + "/path")
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.Uncompressed, gc.Equals, true)
+	content, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(content), gc.Equals, "response body")
+}
+
+func (s *GzipSuite) TestWrapFaultInjector(c *gc.C) {
+	injector := NewFaultInjector(http.NotFoundHandler())
+	server := httptest.NewServer(NewGzipHandler(injector))
+	defer server.Close()
+	injector.Inject("GET", "", Fault{Reset: true})
+	_, err := http.Get(server.URL + "/path")
+	c.Assert(err, gc.NotNil)
+	resp, err := http.Get(server.URL + "/path")
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+}
@@ -0,0 +1,115 @@
+package hook
+
+import (
+	"math/rand"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// ResponseDelay describes how long a service double waits before
+// handling a request, simulating network and server latency. If Max
+// is greater than Min, each delay is drawn uniformly from the range
+// [Min, Max); otherwise every delay is Min.
+type ResponseDelay struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// FixedDelay returns a ResponseDelay which always waits for d.
+func FixedDelay(d time.Duration) ResponseDelay {
+	return ResponseDelay{Min: d}
+}
+
+// duration returns the time to wait for a single request.
+func (d ResponseDelay) duration() time.Duration {
+	if d.Max <= d.Min {
+		return d.Min
+	}
+	return d.Min + time.Duration(rand.Int63n(int64(d.Max-d.Min)))
+}
+
+// endpointDelay overrides the response delay for requests whose URL
+// path matches a pattern.
+type endpointDelay struct {
+	pattern *regexp.Regexp
+	delay   ResponseDelay
+}
+
+// SetResponseDelay sets the delay applied to all requests which do
+// not match an endpoint delay declared with SetEndpointDelay. The
+// returned function restores the previous delay.
+func (s *TestService) SetResponseDelay(delay ResponseDelay) func() {
+	s.delayMu.Lock()
+	defer s.delayMu.Unlock()
+	old := s.responseDelay
+	s.responseDelay = delay
+	return func() {
+		s.delayMu.Lock()
+		defer s.delayMu.Unlock()
+		s.responseDelay = old
+	}
+}
+
+// SetEndpointDelay overrides the response delay for requests whose URL
+// path matches the regular expression pattern. If several overrides
+// match a request, the first registered is used. The returned function
+// removes the override.
+func (s *TestService) SetEndpointDelay(pattern string, delay ResponseDelay) func() {
+	override := &endpointDelay{
+		pattern: regexp.MustCompile(pattern),
+		delay:   delay,
+	}
+	s.delayMu.Lock()
+	defer s.delayMu.Unlock()
+	s.endpointDelays = append(s.endpointDelays, override)
+	return func() {
+		s.delayMu.Lock()
+		defer s.delayMu.Unlock()
+		for i, d := range s.endpointDelays {
+			if d == override {
+				s.endpointDelays = append(s.endpointDelays[:i], s.endpointDelays[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// ClearResponseDelays removes the response delay and any endpoint
+// delays, so that requests are handled without delay.
+func (s *TestService) ClearResponseDelays() {
+	s.delayMu.Lock()
+	defer s.delayMu.Unlock()
+	s.responseDelay = ResponseDelay{}
+	s.endpointDelays = nil
+}
+
+// responseDelayFor returns the delay which applies to the request.
+func (s *TestService) responseDelayFor(r *http.Request) ResponseDelay {
+	s.delayMu.Lock()
+	defer s.delayMu.Unlock()
+	for _, d := range s.endpointDelays {
+		if d.pattern.MatchString(r.URL.Path) {
+			return d.delay
+		}
+	}
+	return s.responseDelay
+}
+
+// DelayResponse waits for the response delay which applies to the
+// request. It returns early with the context's error if the request
+// is cancelled, in which case no response should be sent.
+func (s *TestService) DelayResponse(r *http.Request) error {
+	d := s.responseDelayFor(r).duration()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
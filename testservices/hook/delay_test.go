@@ -0,0 +1,73 @@
+package hook
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	gc "gopkg.in/check.v1"
+)
+
+type DelaySuite struct{}
+
+var _ = gc.Suite(&DelaySuite{})
+
+func newRequest(c *gc.C, path string) *http.Request {
+	req, err := http.NewRequest("GET", "http://example.com"+path, nil)
+	c.Assert(err, gc.IsNil)
+	return req
+}
+
+// timeDelay returns how long DelayResponse waited for the request.
+func timeDelay(c *gc.C, service *TestService, r *http.Request) time.Duration {
+	start := time.Now()
+	c.Assert(service.DelayResponse(r), gc.IsNil)
+	return time.Since(start)
+}
+
+func (s *DelaySuite) TestNoDelay(c *gc.C) {
+	var service TestService
+	c.Assert(timeDelay(c, &service, newRequest(c, "/servers")) < 10*time.Millisecond, gc.Equals, true)
+}
+
+func (s *DelaySuite) TestFixedDelay(c *gc.C) {
+	var service TestService
+	cleanup := service.SetResponseDelay(FixedDelay(50 * time.Millisecond))
+	c.Assert(timeDelay(c, &service, newRequest(c, "/servers")) >= 50*time.Millisecond, gc.Equals, true)
+	cleanup()
+	c.Assert(service.responseDelayFor(newRequest(c, "/servers")), gc.Equals, ResponseDelay{})
+}
+
+func (s *DelaySuite) TestDelayRange(c *gc.C) {
+	delay := ResponseDelay{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		d := delay.duration()
+		c.Assert(d >= delay.Min && d < delay.Max, gc.Equals, true, gc.Commentf("delay %v", d))
+	}
+	// A range which is empty is a fixed delay.
+	delay.Max = delay.Min
+	c.Assert(delay.duration(), gc.Equals, delay.Min)
+}
+
+func (s *DelaySuite) TestEndpointDelay(c *gc.C) {
+	var service TestService
+	service.SetResponseDelay(FixedDelay(time.Second))
+	cleanup := service.SetEndpointDelay("/servers/.*", FixedDelay(time.Millisecond))
+	service.SetEndpointDelay("/servers/1", FixedDelay(time.Minute))
+	c.Assert(service.responseDelayFor(newRequest(c, "/servers/1")), gc.Equals, FixedDelay(time.Millisecond))
+	c.Assert(service.responseDelayFor(newRequest(c, "/flavors")), gc.Equals, FixedDelay(time.Second))
+	cleanup()
+	c.Assert(service.responseDelayFor(newRequest(c, "/servers/1")), gc.Equals, FixedDelay(time.Minute))
+	c.Assert(service.responseDelayFor(newRequest(c, "/servers/2")), gc.Equals, FixedDelay(time.Second))
+}
+
+func (s *DelaySuite) TestDelayCancelled(c *gc.C) {
+	var service TestService
+	service.SetResponseDelay(FixedDelay(time.Minute))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := service.DelayResponse(newRequest(c, "/servers").WithContext(ctx))
+	c.Assert(err, gc.Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < time.Second, gc.Equals, true)
+}
@@ -0,0 +1,43 @@
+package hook
+
+import "net/http"
+
+// SetResponseHeader declares that every response the service sends
+// has the named header, with the given value, so that tests can
+// exercise clients which read vendor-specific headers. The header
+// replaces any the service would otherwise send, such as the request
+// ids Nova and Swift send by default. If value is empty, the header is
+// removed from responses instead, so that tests can exercise clients
+// which must cope without it.
+func (s *TestService) SetResponseHeader(name, value string) {
+	s.headerMu.Lock()
+	defer s.headerMu.Unlock()
+	if s.responseHeaders == nil {
+		s.responseHeaders = make(map[string]string)
+	}
+	s.responseHeaders[http.CanonicalHeaderKey(name)] = value
+}
+
+// ClearResponseHeaders discards the headers declared with
+// SetResponseHeader, so that the service sends only its own.
+func (s *TestService) ClearResponseHeaders() {
+	s.headerMu.Lock()
+	defer s.headerMu.Unlock()
+	s.responseHeaders = nil
+}
+
+// AddResponseHeaders sets the headers declared with SetResponseHeader
+// on the response, removing those declared with an empty value. It
+// should be called before the response is written, and after the
+// service sets any default headers which tests may wish to replace.
+func (s *TestService) AddResponseHeaders(w http.ResponseWriter) {
+	s.headerMu.Lock()
+	defer s.headerMu.Unlock()
+	for name, value := range s.responseHeaders {
+		if value == "" {
+			w.Header().Del(name)
+		} else {
+			w.Header().Set(name, value)
+		}
+	}
+}
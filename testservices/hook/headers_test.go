@@ -0,0 +1,50 @@
+package hook
+
+import (
+	"net/http/httptest"
+
+	gc "gopkg.in/check.v1"
+)
+
+type HeadersSuite struct{}
+
+var _ = gc.Suite(&HeadersSuite{})
+
+func (s *HeadersSuite) TestAddResponseHeaders(c *gc.C) {
+	var service TestService
+	w := httptest.NewRecorder()
+	service.AddResponseHeaders(w)
+	c.Assert(w.Header(), gc.HasLen, 0)
+
+	service.SetResponseHeader("x-timestamp", "1500000000.00000")
+	service.SetResponseHeader("X-Trans-Id", "tx1")
+	w = httptest.NewRecorder()
+	w.Header().Set("X-Trans-Id", "tx-default")
+	service.AddResponseHeaders(w)
+	c.Assert(w.Header().Get("X-Timestamp"), gc.Equals, "1500000000.00000")
+	c.Assert(w.Header().Get("X-Trans-Id"), gc.Equals, "tx1")
+}
+
+func (s *HeadersSuite) TestEmptyValueSuppressesHeader(c *gc.C) {
+	var service TestService
+	service.SetResponseHeader("X-Trans-Id", "")
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Trans-Id", "tx-default")
+	w.Header().Set("Content-Type", "application/json")
+	service.AddResponseHeaders(w)
+	_, ok := w.Header()["X-Trans-Id"]
+	c.Assert(ok, gc.Equals, false)
+	c.Assert(w.Header().Get("Content-Type"), gc.Equals, "application/json")
+}
+
+func (s *HeadersSuite) TestClearResponseHeaders(c *gc.C) {
+	var service TestService
+	service.SetResponseHeader("X-Timestamp", "1500000000.00000")
+	service.SetResponseHeader("X-Trans-Id", "")
+	service.ClearResponseHeaders()
+	w := httptest.NewRecorder()
+	w.Header().Set("X-Trans-Id", "tx-default")
+	service.AddResponseHeaders(w)
+	c.Assert(w.Header().Get("X-Timestamp"), gc.Equals, "")
+	c.Assert(w.Header().Get("X-Trans-Id"), gc.Equals, "tx-default")
+}
@@ -1,9 +1,18 @@
 package hook
 
+import "sync"
+
 type TestService struct {
 	ServiceControl
 	// Hooks to run when specified control points are reached in the service business logic.
 	ControlHooks map[string]ControlProcessor
+
+	delayMu        sync.Mutex // protects responseDelay and endpointDelays
+	responseDelay  ResponseDelay
+	endpointDelays []*endpointDelay
+
+	headerMu        sync.Mutex // protects responseHeaders
+	responseHeaders map[string]string
 }
 
 // ControlProcessor defines a function that is run when a specified control point is reached in the service
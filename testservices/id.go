@@ -0,0 +1,65 @@
+package testservices
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The types of resource whose ids are made by a ServiceInstance's
+// IDFactory.
+const (
+	ResourceServer            = "server"
+	ResourceServerGroup       = "server-group"
+	ResourceSecurityGroup     = "security-group"
+	ResourceSecurityGroupRule = "security-group-rule"
+	ResourceFloatingIP        = "floating-ip"
+	ResourcePort              = "port"
+	ResourceImage             = "image"
+	ResourceVolume            = "volume"
+	ResourceSnapshot          = "snapshot"
+	ResourceVolumeAttachment  = "volume-attachment"
+	ResourceNetwork           = "network"
+	ResourceSubnet            = "subnet"
+	ResourceStack             = "stack"
+)
+
+// NewUUID returns a random UUID conforming to RFC 4122.
+func NewUUID() (string, error) {
+	uuid := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, uuid); err != nil {
+		return "", err
+	}
+	return formatUUID(uuid), nil
+}
+
+// formatUUID returns the version 4 UUID made from 16 random bytes.
+func formatUUID(uuid []byte) string {
+	uuid[8] = uuid[8]&^0xc0 | 0x80 // variant bits; see section 4.1.1.
+	uuid[6] = uuid[6]&^0xf0 | 0x40 // version 4; see section 4.1.3.
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// NewID returns the id of a newly created resource of the given type,
+// which is made by IDFactory if it is set, and is a random UUID
+// otherwise.
+func (s *ServiceInstance) NewID(resourceType string) (string, error) {
+	if s.IDFactory != nil {
+		return s.IDFactory(resourceType), nil
+	}
+	return NewUUID()
+}
+
+// NewSequentialID returns the id of a newly created resource of a
+// type that the service numbers in sequence, incrementing counter. The
+// id is made by IDFactory if it is set, and is the new value of
+// counter otherwise. The counter is incremented either way, as
+// services may derive other attributes, such as addresses, from it.
+func (s *ServiceInstance) NewSequentialID(resourceType string, counter *int) string {
+	*counter++
+	if s.IDFactory != nil {
+		return s.IDFactory(resourceType)
+	}
+	return strconv.Itoa(*counter)
+}
@@ -0,0 +1,55 @@
+package testservices
+
+import (
+	gc "gopkg.in/check.v1"
+)
+
+type IDSuite struct{}
+
+var _ = gc.Suite(&IDSuite{})
+
+func (s *IDSuite) TestNewUUID(c *gc.C) {
+	uuid, err := NewUUID()
+	c.Assert(err, gc.IsNil)
+	c.Assert(uuid, gc.Matches, "[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}")
+	other, err := NewUUID()
+	c.Assert(err, gc.IsNil)
+	c.Assert(other, gc.Not(gc.Equals), uuid)
+}
+
+func (s *IDSuite) TestNewIDDefaultsToUUID(c *gc.C) {
+	var service ServiceInstance
+	id, err := service.NewID(ResourceImage)
+	c.Assert(err, gc.IsNil)
+	c.Assert(id, gc.Matches, "[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}")
+	other, err := service.NewID(ResourceImage)
+	c.Assert(err, gc.IsNil)
+	c.Assert(other, gc.Not(gc.Equals), id)
+}
+
+func (s *IDSuite) TestNewIDUsesFactory(c *gc.C) {
+	var types []string
+	service := ServiceInstance{
+		IDFactory: func(resourceType string) string {
+			types = append(types, resourceType)
+			return "id-" + resourceType
+		},
+	}
+	id, err := service.NewID(ResourceNetwork)
+	c.Assert(err, gc.IsNil)
+	c.Assert(id, gc.Equals, "id-network")
+	c.Assert(types, gc.DeepEquals, []string{ResourceNetwork})
+}
+
+func (s *IDSuite) TestNewSequentialID(c *gc.C) {
+	var service ServiceInstance
+	counter := 0
+	c.Assert(service.NewSequentialID(ResourceVolume, &counter), gc.Equals, "1")
+	c.Assert(service.NewSequentialID(ResourceVolume, &counter), gc.Equals, "2")
+	c.Assert(counter, gc.Equals, 2)
+	service.IDFactory = func(resourceType string) string {
+		return "id-" + resourceType
+	}
+	c.Assert(service.NewSequentialID(ResourceVolume, &counter), gc.Equals, "id-volume")
+	c.Assert(counter, gc.Equals, 3)
+}
@@ -0,0 +1,48 @@
+package identityservice
+
+import (
+	"net/http"
+
+	"gopkg.in/goose.v1/testservices/hook"
+)
+
+// headerWriter adds the response headers declared on a service with
+// SetResponseHeader once the handler writing the response has set its
+// own, so that the declared headers replace them.
+type headerWriter struct {
+	http.ResponseWriter
+	service     *hook.TestService
+	wroteHeader bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.service.AddResponseHeaders(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// controlled returns a handler which serves requests with h once the
+// response delay set on service has passed, adding the response
+// headers declared on service to its responses.
+func controlled(service *hook.TestService, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := service.DelayResponse(r); err != nil {
+			// The client has given up on the request.
+			return
+		}
+		hw := &headerWriter{ResponseWriter: w, service: service}
+		h.ServeHTTP(hw, r)
+		if !hw.wroteHeader {
+			service.AddResponseHeaders(w)
+		}
+	})
+}
@@ -11,7 +11,36 @@ type IdentityService interface {
 	SetupHTTP(mux *http.ServeMux)
 }
 
+// A Resetter is an IdentityService which can discard the tokens it
+// has issued, as the identity services in this package can.
+type Resetter interface {
+	// Reset discards all issued tokens, preserving users and services.
+	Reset()
+}
+
+// Service types which identify common services in the catalog. The
+// identity services place no restriction on the types of the services
+// they list, and report them exactly as they are registered.
+const (
+	ServiceTypeCompute       = "compute"
+	ServiceTypeObjectStore   = "object-store"
+	ServiceTypeIdentity      = "identity"
+	ServiceTypeImage         = "image"
+	ServiceTypeNetwork       = "network"
+	ServiceTypeVolumeV2      = "volumev2"
+	ServiceTypeVolumeV3      = "volumev3"
+	ServiceTypeOrchestration = "orchestration"
+	ServiceTypeDNS           = "dns"
+	ServiceTypeShareV2       = "sharev2"
+	ServiceTypeMetric        = "metric"
+)
+
 // A ServiceProvider is an Openstack module which has service endpoints.
 type ServiceProvider interface {
 	Endpoints() []Endpoint
 }
+
+// A Logger receives diagnostic messages from an identity service.
+type Logger interface {
+	Logf(format string, args ...interface{})
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"gopkg.in/goose.v1/testservices/hook"
 )
@@ -25,6 +26,9 @@ type KeyPair struct {
 	hook.TestService
 	Users
 	services []Service
+	// PathPrefix, if set, is the path under which SetupHTTP mounts
+	// the service, as for UserPass.
+	PathPrefix string
 }
 
 func NewKeyPair() *KeyPair {
@@ -32,17 +36,33 @@ func NewKeyPair() *KeyPair {
 		Users: Users{
 			users:   make(map[string]UserInfo),
 			tenants: make(map[string]string),
+			tokens:  make(map[string]scopedToken),
 		},
 	}
 }
 
+// Reset discards all issued tokens and control hooks. Users, tenants
+// and services are preserved.
+func (u *KeyPair) Reset() {
+	u.Users.Reset()
+	u.ControlHooks = nil
+	u.ClearResponseDelays()
+	u.ClearResponseHeaders()
+}
+
 func (u *KeyPair) RegisterServiceProvider(name, serviceType string, serviceProvider ServiceProvider) {
 	service := Service{name, serviceType, serviceProvider.Endpoints()}
 	u.AddService(service)
 }
 
 func (u *KeyPair) AddService(service Service) {
-	u.services = append(u.services, service)
+	u.services = addService(u.services, service)
+}
+
+// SetEndpoints replaces the endpoints of the registered service with
+// the given type, as UserPass.SetEndpoints does.
+func (u *KeyPair) SetEndpoints(serviceType string, endpoints []Endpoint) {
+	u.services = setEndpoints(u.services, serviceType, endpoints)
 }
 
 func (u *KeyPair) ReturnFailure(w http.ResponseWriter, status int, message string) {
@@ -68,7 +88,11 @@ func (u *KeyPair) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var req KeyPairRequest
 	// Testing against Canonistack, all responses are application/json, even failures
 	w.Header().Set("Content-Type", "application/json")
-	if r.Header.Get("Content-Type") != "application/json" {
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r.Method, "POST")
+		return
+	}
+	if !isJSON(r.Header.Get("Content-Type")) {
 		u.ReturnFailure(w, http.StatusBadRequest, notJSON)
 		return
 	}
@@ -108,8 +132,18 @@ func (u *KeyPair) generateAccessResponse(userInfo *UserInfo) (*AccessResponse, e
 	}
 	res.Access.ServiceCatalog = u.services
 	res.Access.Token.Id = userInfo.Token
+	res.Access.Token.Expires = userInfo.Expires.Format(time.RFC3339)
 	res.Access.Token.Tenant.Id = userInfo.TenantId
+	res.Access.Token.Tenant.Name = u.tenants[userInfo.TenantId]
 	res.Access.User.Id = userInfo.Id
+	res.Access.User.Name = userInfo.Name
+	if len(userInfo.Roles) > 0 {
+		res.Access.User.Roles = userInfo.Roles
+	} else {
+		for i := range res.Access.User.Roles {
+			res.Access.User.Roles[i].TenantId = userInfo.TenantId
+		}
+	}
 	if err := u.ProcessControlHook("authorisation", u, &res, userInfo); err != nil {
 		return nil, err
 	}
@@ -118,5 +152,5 @@ func (u *KeyPair) generateAccessResponse(userInfo *UserInfo) (*AccessResponse, e
 
 // setupHTTP attaches all the needed handlers to provide the HTTP API.
 func (u *KeyPair) SetupHTTP(mux *http.ServeMux) {
-	mux.Handle("/tokens", u)
+	mountHandler(mux, u.PathPrefix, "/tokens", controlled(&u.TestService, u))
 }
@@ -0,0 +1,494 @@
+package identityservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Implement the v2 admin API, with the OS-KSADM extension, for managing
+// the service catalog, users, tenants and role grants. The services
+// and endpoints it manages are those returned in the catalog of each
+// token, and the users those who can authenticate.
+
+// adminRole is the role required to use the admin API.
+const adminRole = "admin"
+
+// predefinedRoles maps the ids of the roles which may be granted
+// without being added by AddRole to their names.
+var predefinedRoles = map[string]string{
+	"1": adminRole,
+	"2": defaultRole,
+}
+
+type KSADMService struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+type KSADMEndpoint struct {
+	Id          string `json:"id"`
+	Region      string `json:"region"`
+	ServiceId   string `json:"service_id"`
+	PublicURL   string `json:"publicurl"`
+	AdminURL    string `json:"adminurl"`
+	InternalURL string `json:"internalurl"`
+}
+
+type KSADMUser struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"password,omitempty"`
+	TenantId string `json:"tenantId"`
+	Enabled  bool   `json:"enabled"`
+}
+
+type KSADMRole struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// catalogId returns the id by which the admin API refers to the
+// catalog entry with the given key, assigning one if it has none.
+// Catalog entries added by Go calls are only given ids once the admin
+// API sees them.
+func (u *UserPass) catalogId(key string) string {
+	if u.catalogIds == nil {
+		u.catalogIds = make(map[string]string)
+	}
+	id, ok := u.catalogIds[key]
+	if !ok {
+		u.nextCatalogId++
+		id = strconv.Itoa(u.nextCatalogId)
+		u.catalogIds[key] = id
+	}
+	return id
+}
+
+// A service is identified by its name and type, as services with the
+// same name and type are merged into a single catalog entry. Each
+// service has a single endpoint per region.
+
+func serviceKey(service Service) string {
+	return "service " + service.Name + " " + service.Type
+}
+
+func endpointKey(service Service, ep Endpoint) string {
+	return "endpoint " + service.Name + " " + service.Type + " " + ep.Region
+}
+
+func (u *UserPass) adminService(service Service) KSADMService {
+	id := u.catalogId(serviceKey(service))
+	return KSADMService{
+		Id:          id,
+		Name:        service.Name,
+		Type:        service.Type,
+		Description: u.serviceDescriptions[id],
+	}
+}
+
+func (u *UserPass) adminEndpoint(service Service, ep Endpoint) KSADMEndpoint {
+	return KSADMEndpoint{
+		Id:          u.catalogId(endpointKey(service, ep)),
+		Region:      ep.Region,
+		ServiceId:   u.catalogId(serviceKey(service)),
+		PublicURL:   ep.PublicURL,
+		AdminURL:    ep.AdminURL,
+		InternalURL: ep.InternalURL,
+	}
+}
+
+// findService returns the index in the catalog of the service with the
+// given id, or -1 if there is none.
+func (u *UserPass) findService(id string) int {
+	for i, service := range u.services {
+		if u.catalogId(serviceKey(service)) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// checkAdmin writes a failure unless the request's token belongs to a
+// user holding the admin role, reporting whether it did so.
+func (u *UserPass) checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	userInfo, err := u.FindUser(r.Header.Get("X-Auth-Token"))
+	if err != nil {
+		u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+		return true
+	}
+	if !userInfo.HasRole(adminRole) {
+		u.ReturnFailure(w, http.StatusForbidden, "You are not authorized to perform the requested action: admin_required")
+		return true
+	}
+	return false
+}
+
+// readAdminRequest decodes the JSON body of an admin API request into
+// req, writing a failure and returning false if it cannot.
+func (u *UserPass) readAdminRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	if !isJSON(r.Header.Get("Content-Type")) {
+		u.ReturnFailure(w, http.StatusBadRequest, notJSON)
+		return false
+	}
+	content, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(content, req)
+	}
+	if err != nil {
+		u.ReturnFailure(w, http.StatusBadRequest, notJSON)
+		return false
+	}
+	return true
+}
+
+// writeJSON writes a successful response holding resp.
+func (u *UserPass) writeJSON(w http.ResponseWriter, resp interface{}) {
+	content, err := json.Marshal(resp)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, content)
+}
+
+// handleAdminServices handles the /OS-KSADM/services API, which
+// creates, lists, shows and deletes catalog services.
+func (u *UserPass) handleAdminServices(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if u.checkAdmin(w, r) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/OS-KSADM/services"), "/")
+	switch {
+	case id == "" && r.Method == "GET":
+		services := []KSADMService{}
+		for _, service := range u.services {
+			services = append(services, u.adminService(service))
+		}
+		u.writeJSON(w, map[string][]KSADMService{"OS-KSADM:services": services})
+	case id == "" && r.Method == "POST":
+		var req struct {
+			Service *KSADMService `json:"OS-KSADM:service"`
+		}
+		if !u.readAdminRequest(w, r, &req) {
+			return
+		}
+		if req.Service == nil || req.Service.Type == "" {
+			u.ReturnFailure(w, http.StatusBadRequest, "Expecting to find type in OS-KSADM:service.")
+			return
+		}
+		service := Service{Name: req.Service.Name, Type: req.Service.Type, Endpoints: []Endpoint{}}
+		for _, existing := range u.services {
+			if existing.Name == service.Name && existing.Type == service.Type {
+				u.ReturnFailure(w, http.StatusConflict, fmt.Sprintf("Conflict occurred attempting to store service - Duplicate entry %s.", service.Name))
+				return
+			}
+		}
+		u.AddService(service)
+		created := u.adminService(service)
+		if req.Service.Description != "" {
+			if u.serviceDescriptions == nil {
+				u.serviceDescriptions = make(map[string]string)
+			}
+			u.serviceDescriptions[created.Id] = req.Service.Description
+			created.Description = req.Service.Description
+		}
+		u.writeJSON(w, map[string]KSADMService{"OS-KSADM:service": created})
+	case id != "" && r.Method == "GET":
+		i := u.findService(id)
+		if i < 0 {
+			u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find service, %s.", id))
+			return
+		}
+		u.writeJSON(w, map[string]KSADMService{"OS-KSADM:service": u.adminService(u.services[i])})
+	case id != "" && r.Method == "DELETE":
+		i := u.findService(id)
+		if i < 0 {
+			u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find service, %s.", id))
+			return
+		}
+		service := u.services[i]
+		u.services = append(u.services[:i], u.services[i+1:]...)
+		for _, ep := range service.Endpoints {
+			delete(u.catalogIds, endpointKey(service, ep))
+		}
+		delete(u.catalogIds, serviceKey(service))
+		delete(u.serviceDescriptions, id)
+		w.WriteHeader(http.StatusNoContent)
+	case id == "":
+		writeMethodNotAllowed(w, r.Method, "GET", "POST")
+	default:
+		writeMethodNotAllowed(w, r.Method, "GET", "DELETE")
+	}
+}
+
+// handleAdminEndpoints handles the /endpoints API, which creates,
+// lists and deletes the endpoints of catalog services. As the catalog
+// holds one endpoint per service and region, creating an endpoint
+// replaces any the service has in the same region.
+func (u *UserPass) handleAdminEndpoints(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if u.checkAdmin(w, r) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/endpoints"), "/")
+	switch {
+	case id == "" && r.Method == "GET":
+		endpoints := []KSADMEndpoint{}
+		for _, service := range u.services {
+			for _, ep := range service.Endpoints {
+				endpoints = append(endpoints, u.adminEndpoint(service, ep))
+			}
+		}
+		u.writeJSON(w, map[string][]KSADMEndpoint{"endpoints": endpoints})
+	case id == "" && r.Method == "POST":
+		var req struct {
+			Endpoint *KSADMEndpoint `json:"endpoint"`
+		}
+		if !u.readAdminRequest(w, r, &req) {
+			return
+		}
+		if req.Endpoint == nil || req.Endpoint.ServiceId == "" {
+			u.ReturnFailure(w, http.StatusBadRequest, "Expecting to find service_id in endpoint.")
+			return
+		}
+		i := u.findService(req.Endpoint.ServiceId)
+		if i < 0 {
+			u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find service, %s.", req.Endpoint.ServiceId))
+			return
+		}
+		ep := Endpoint{
+			AdminURL:    req.Endpoint.AdminURL,
+			InternalURL: req.Endpoint.InternalURL,
+			PublicURL:   req.Endpoint.PublicURL,
+			Region:      req.Endpoint.Region,
+		}
+		service := u.services[i]
+		u.services = addService(u.services, Service{service.Name, service.Type, []Endpoint{ep}})
+		u.writeJSON(w, map[string]KSADMEndpoint{"endpoint": u.adminEndpoint(service, ep)})
+	case id != "" && r.Method == "DELETE":
+		for i, service := range u.services {
+			for j, ep := range service.Endpoints {
+				if u.catalogId(endpointKey(service, ep)) != id {
+					continue
+				}
+				endpoints := append([]Endpoint(nil), service.Endpoints[:j]...)
+				u.services[i].Endpoints = append(endpoints, service.Endpoints[j+1:]...)
+				delete(u.catalogIds, endpointKey(service, ep))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find endpoint, %s.", id))
+	case id == "":
+		writeMethodNotAllowed(w, r.Method, "GET", "POST")
+	default:
+		writeMethodNotAllowed(w, r.Method, "DELETE")
+	}
+}
+
+// AddRole defines a role which may be granted to users with the admin
+// API, in addition to the predefined admin and Member roles, which
+// have ids "1" and "2".
+// Note: this is implemented as a public method rather than as an HTTP
+// API for convenience in tests.
+func (u *UserPass) AddRole(id, name string) {
+	if u.roles == nil {
+		u.roles = make(map[string]string)
+	}
+	u.roles[id] = name
+}
+
+// roleName returns the name of the role with the given id.
+func (u *UserPass) roleName(id string) (string, bool) {
+	if name, ok := u.roles[id]; ok {
+		return name, true
+	}
+	name, ok := predefinedRoles[id]
+	return name, ok
+}
+
+// findUserById returns the name of the user with the given id.
+func (u *UserPass) findUserById(id string) (string, bool) {
+	for name, userInfo := range u.users {
+		if userInfo.Id == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func adminUser(userInfo UserInfo) KSADMUser {
+	return KSADMUser{
+		Id:       userInfo.Id,
+		Name:     userInfo.Name,
+		TenantId: userInfo.TenantId,
+		Enabled:  true,
+	}
+}
+
+// handleAdminUsers handles the /users API, which creates, lists and
+// shows users. Created users can authenticate with the password given.
+func (u *UserPass) handleAdminUsers(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if u.checkAdmin(w, r) {
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/users"), "/")
+	switch {
+	case id == "" && r.Method == "GET":
+		users := []KSADMUser{}
+		for _, userInfo := range u.users {
+			users = append(users, adminUser(userInfo))
+		}
+		sort.Sort(usersById(users))
+		u.writeJSON(w, map[string][]KSADMUser{"users": users})
+	case id == "" && r.Method == "POST":
+		var req struct {
+			User *KSADMUser `json:"user"`
+		}
+		if !u.readAdminRequest(w, r, &req) {
+			return
+		}
+		if req.User == nil || req.User.Name == "" {
+			u.ReturnFailure(w, http.StatusBadRequest, "Expecting to find name in user.")
+			return
+		}
+		if _, ok := u.users[req.User.Name]; ok {
+			u.ReturnFailure(w, http.StatusConflict, fmt.Sprintf("Conflict occurred attempting to store user - Duplicate Entry (%s).", req.User.Name))
+			return
+		}
+		tenantName, ok := u.tenants[req.User.TenantId]
+		if !ok {
+			u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find tenant, %s.", req.User.TenantId))
+			return
+		}
+		userInfo := u.AddUser(req.User.Name, req.User.Password, tenantName)
+		u.writeJSON(w, map[string]KSADMUser{"user": adminUser(*userInfo)})
+	case id != "" && r.Method == "GET":
+		name, ok := u.findUserById(id)
+		if !ok {
+			u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find user, %s.", id))
+			return
+		}
+		u.writeJSON(w, map[string]KSADMUser{"user": adminUser(u.users[name])})
+	case id == "":
+		writeMethodNotAllowed(w, r.Method, "GET", "POST")
+	default:
+		writeMethodNotAllowed(w, r.Method, "GET")
+	}
+}
+
+type usersById []KSADMUser
+
+func (s usersById) Len() int      { return len(s) }
+func (s usersById) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s usersById) Less(i, j int) bool {
+	a, _ := strconv.Atoi(s[i].Id)
+	b, _ := strconv.Atoi(s[j].Id)
+	return a < b
+}
+
+// createTenant handles POST /tenants, which creates a tenant.
+func (u *UserPass) createTenant(w http.ResponseWriter, r *http.Request) {
+	if u.checkAdmin(w, r) {
+		return
+	}
+	var req struct {
+		Tenant *TenantResponse `json:"tenant"`
+	}
+	if !u.readAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Tenant == nil || req.Tenant.Name == "" {
+		u.ReturnFailure(w, http.StatusBadRequest, "Expecting to find name in tenant.")
+		return
+	}
+	for _, name := range u.tenants {
+		if name == req.Tenant.Name {
+			u.ReturnFailure(w, http.StatusConflict, fmt.Sprintf("Conflict occurred attempting to store tenant - Duplicate Entry (%s).", name))
+			return
+		}
+	}
+	created := TenantResponse{
+		Id:          u.addTenant(req.Tenant.Name),
+		Name:        req.Tenant.Name,
+		Description: req.Tenant.Description,
+		Enabled:     true,
+	}
+	u.writeJSON(w, map[string]TenantResponse{"tenant": created})
+}
+
+// handleRoleGrants handles PUT and DELETE on
+// /tenants/<tenant>/users/<user>/roles/OS-KSADM/<role>, which grant
+// and revoke a user's role in a tenant. Granting a role in a tenant
+// makes the user a member of it.
+func (u *UserPass) handleRoleGrants(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if u.checkAdmin(w, r) {
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 7 || parts[0] != "tenants" || parts[2] != "users" || parts[4] != "roles" || parts[5] != "OS-KSADM" {
+		u.ReturnFailure(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+	tenantId, userId, roleId := parts[1], parts[3], parts[6]
+	if r.Method != "PUT" && r.Method != "DELETE" {
+		writeMethodNotAllowed(w, r.Method, "PUT", "DELETE")
+		return
+	}
+	if _, ok := u.tenants[tenantId]; !ok {
+		u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find tenant, %s.", tenantId))
+		return
+	}
+	name, ok := u.findUserById(userId)
+	if !ok {
+		u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find user, %s.", userId))
+		return
+	}
+	roleName, ok := u.roleName(roleId)
+	if !ok {
+		u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find role, %s.", roleId))
+		return
+	}
+	userInfo := u.users[name]
+	granted := -1
+	for i, role := range userInfo.Roles {
+		if role.Id == roleId && role.TenantId == tenantId {
+			granted = i
+		}
+	}
+	if r.Method == "DELETE" {
+		if granted < 0 {
+			u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find role assignment, %s.", roleId))
+			return
+		}
+		roles := append([]RoleResponse(nil), userInfo.Roles[:granted]...)
+		userInfo.Roles = append(roles, userInfo.Roles[granted+1:]...)
+		u.users[name] = userInfo
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if granted >= 0 {
+		u.ReturnFailure(w, http.StatusConflict, fmt.Sprintf("Conflict occurred attempting to store role grant - Duplicate Entry (%s).", roleId))
+		return
+	}
+	member := false
+	for _, id := range userInfo.tenantIds() {
+		member = member || id == tenantId
+	}
+	if !member {
+		userInfo.otherTenantIds = append(userInfo.otherTenantIds, tenantId)
+	}
+	userInfo.Roles = append(userInfo.Roles, RoleResponse{Id: roleId, Name: roleName, TenantId: tenantId})
+	u.users[name] = userInfo
+	u.writeJSON(w, map[string]KSADMRole{"role": {Id: roleId, Name: roleName}})
+}
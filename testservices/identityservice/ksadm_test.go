@@ -0,0 +1,310 @@
+package identityservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/testing/httpsuite"
+)
+
+type KSADMSuite struct {
+	httpsuite.HTTPSuite
+	identity   *UserPass
+	adminToken string
+	userToken  string
+}
+
+var _ = gc.Suite(&KSADMSuite{})
+
+func (s *KSADMSuite) SetUpTest(c *gc.C) {
+	s.HTTPSuite.SetUpTest(c)
+	s.identity = NewUserPass()
+	s.userToken = s.identity.AddUser("user", "secret", "tenant").Token
+	admin := s.identity.AddUser("admin", "secret", "tenant")
+	err := s.identity.SetUserTenant("admin", admin.TenantId, "tenant", []RoleResponse{{Id: "1", Name: "admin"}})
+	c.Assert(err, gc.IsNil)
+	s.adminToken = admin.Token
+	s.identity.SetupHTTP(s.Mux)
+}
+
+// request sends an admin API request with the given token, decoding
+// any response body into result.
+func (s *KSADMSuite) request(c *gc.C, token, method, path string, body interface{}, code int, result interface{}) {
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		c.Assert(err, gc.IsNil)
+	}
+	req, err := http.NewRequest(method, s.Server.URL+path, bytes.NewReader(content))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Auth-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, code)
+	if result != nil {
+		content, err := ioutil.ReadAll(resp.Body)
+		c.Assert(err, gc.IsNil)
+		c.Assert(json.Unmarshal(content, result), gc.IsNil)
+	}
+}
+
+func (s *KSADMSuite) createService(c *gc.C, name, serviceType string) KSADMService {
+	var resp struct {
+		Service KSADMService `json:"OS-KSADM:service"`
+	}
+	body := map[string]interface{}{"OS-KSADM:service": map[string]string{
+		"name":        name,
+		"type":        serviceType,
+		"description": name + " service",
+	}}
+	s.request(c, s.adminToken, "POST", "/OS-KSADM/services", body, http.StatusOK, &resp)
+	return resp.Service
+}
+
+func (s *KSADMSuite) createEndpoint(c *gc.C, serviceId, region, URL string) KSADMEndpoint {
+	var resp struct {
+		Endpoint KSADMEndpoint `json:"endpoint"`
+	}
+	body := map[string]interface{}{"endpoint": map[string]string{
+		"service_id":  serviceId,
+		"region":      region,
+		"publicurl":   URL,
+		"adminurl":    URL,
+		"internalurl": URL,
+	}}
+	s.request(c, s.adminToken, "POST", "/endpoints", body, http.StatusOK, &resp)
+	return resp.Endpoint
+}
+
+func (s *KSADMSuite) TestCreateServicesAndEndpoints(c *gc.C) {
+	nova := s.createService(c, "nova", "compute")
+	c.Assert(nova.Id, gc.Not(gc.Equals), "")
+	c.Assert(nova.Name, gc.Equals, "nova")
+	c.Assert(nova.Type, gc.Equals, "compute")
+	c.Assert(nova.Description, gc.Equals, "nova service")
+	swift := s.createService(c, "swift", "object-store")
+	ep := s.createEndpoint(c, nova.Id, "region-a", "http://nova.invalid/a")
+	c.Assert(ep, gc.DeepEquals, KSADMEndpoint{
+		Id:          ep.Id,
+		Region:      "region-a",
+		ServiceId:   nova.Id,
+		PublicURL:   "http://nova.invalid/a",
+		AdminURL:    "http://nova.invalid/a",
+		InternalURL: "http://nova.invalid/a",
+	})
+	s.createEndpoint(c, nova.Id, "region-b", "http://nova.invalid/b")
+	s.createEndpoint(c, swift.Id, "region-a", "http://swift.invalid/a")
+
+	var services struct {
+		Services []KSADMService `json:"OS-KSADM:services"`
+	}
+	s.request(c, s.adminToken, "GET", "/OS-KSADM/services", nil, http.StatusOK, &services)
+	c.Assert(services.Services, gc.DeepEquals, []KSADMService{nova, swift})
+	var shown struct {
+		Service KSADMService `json:"OS-KSADM:service"`
+	}
+	s.request(c, s.adminToken, "GET", "/OS-KSADM/services/"+swift.Id, nil, http.StatusOK, &shown)
+	c.Assert(shown.Service, gc.DeepEquals, swift)
+	var endpoints struct {
+		Endpoints []KSADMEndpoint `json:"endpoints"`
+	}
+	s.request(c, s.adminToken, "GET", "/endpoints", nil, http.StatusOK, &endpoints)
+	c.Assert(endpoints.Endpoints, gc.HasLen, 3)
+
+	// The catalog given to users is the one provisioned.
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	var access AccessResponse
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(json.Unmarshal(content, &access), gc.IsNil)
+	c.Assert(access.Access.ServiceCatalog, gc.DeepEquals, []Service{
+		{"nova", "compute", []Endpoint{
+			{AdminURL: "http://nova.invalid/a", InternalURL: "http://nova.invalid/a", PublicURL: "http://nova.invalid/a", Region: "region-a"},
+			{AdminURL: "http://nova.invalid/b", InternalURL: "http://nova.invalid/b", PublicURL: "http://nova.invalid/b", Region: "region-b"},
+		}},
+		{"swift", "object-store", []Endpoint{
+			{AdminURL: "http://swift.invalid/a", InternalURL: "http://swift.invalid/a", PublicURL: "http://swift.invalid/a", Region: "region-a"},
+		}},
+	})
+}
+
+func (s *KSADMSuite) TestServicesAddedInGo(c *gc.C) {
+	s.identity.AddService(Service{"nova", "compute", []Endpoint{{PublicURL: "http://nova.invalid", Region: "region"}}})
+	var services struct {
+		Services []KSADMService `json:"OS-KSADM:services"`
+	}
+	s.request(c, s.adminToken, "GET", "/OS-KSADM/services", nil, http.StatusOK, &services)
+	c.Assert(services.Services, gc.HasLen, 1)
+	c.Assert(services.Services[0].Name, gc.Equals, "nova")
+	var endpoints struct {
+		Endpoints []KSADMEndpoint `json:"endpoints"`
+	}
+	s.request(c, s.adminToken, "GET", "/endpoints", nil, http.StatusOK, &endpoints)
+	c.Assert(endpoints.Endpoints, gc.HasLen, 1)
+	c.Assert(endpoints.Endpoints[0].ServiceId, gc.Equals, services.Services[0].Id)
+	c.Assert(endpoints.Endpoints[0].PublicURL, gc.Equals, "http://nova.invalid")
+}
+
+func (s *KSADMSuite) TestDeleteEndpointAndService(c *gc.C) {
+	nova := s.createService(c, "nova", "compute")
+	a := s.createEndpoint(c, nova.Id, "region-a", "http://nova.invalid/a")
+	s.createEndpoint(c, nova.Id, "region-b", "http://nova.invalid/b")
+
+	s.request(c, s.adminToken, "DELETE", "/endpoints/"+a.Id, nil, http.StatusNoContent, nil)
+	s.request(c, s.adminToken, "DELETE", "/endpoints/"+a.Id, nil, http.StatusNotFound, nil)
+	c.Assert(s.identity.services, gc.DeepEquals, []Service{
+		{"nova", "compute", []Endpoint{
+			{AdminURL: "http://nova.invalid/b", InternalURL: "http://nova.invalid/b", PublicURL: "http://nova.invalid/b", Region: "region-b"},
+		}},
+	})
+
+	s.request(c, s.adminToken, "DELETE", "/OS-KSADM/services/"+nova.Id, nil, http.StatusNoContent, nil)
+	s.request(c, s.adminToken, "GET", "/OS-KSADM/services/"+nova.Id, nil, http.StatusNotFound, nil)
+	c.Assert(s.identity.services, gc.HasLen, 0)
+	var endpoints struct {
+		Endpoints []KSADMEndpoint `json:"endpoints"`
+	}
+	s.request(c, s.adminToken, "GET", "/endpoints", nil, http.StatusOK, &endpoints)
+	c.Assert(endpoints.Endpoints, gc.HasLen, 0)
+}
+
+func (s *KSADMSuite) TestCreateErrors(c *gc.C) {
+	nova := s.createService(c, "nova", "compute")
+	body := map[string]interface{}{"OS-KSADM:service": map[string]string{"name": "nova", "type": "compute"}}
+	s.request(c, s.adminToken, "POST", "/OS-KSADM/services", body, http.StatusConflict, nil)
+	body = map[string]interface{}{"OS-KSADM:service": map[string]string{"name": "nova"}}
+	s.request(c, s.adminToken, "POST", "/OS-KSADM/services", body, http.StatusBadRequest, nil)
+	body = map[string]interface{}{"endpoint": map[string]string{"service_id": nova.Id + "0"}}
+	s.request(c, s.adminToken, "POST", "/endpoints", body, http.StatusNotFound, nil)
+	body = map[string]interface{}{"endpoint": map[string]string{"region": "region"}}
+	s.request(c, s.adminToken, "POST", "/endpoints", body, http.StatusBadRequest, nil)
+}
+
+func (s *KSADMSuite) TestRequiresAdmin(c *gc.C) {
+	var failure ErrorWrapper
+	s.request(c, s.userToken, "GET", "/OS-KSADM/services", nil, http.StatusForbidden, &failure)
+	c.Assert(failure.Error.Message, gc.Equals, "You are not authorized to perform the requested action: admin_required")
+	s.request(c, s.userToken, "POST", "/endpoints", nil, http.StatusForbidden, nil)
+	s.request(c, "invalid", "GET", "/OS-KSADM/services", nil, http.StatusUnauthorized, nil)
+	s.request(c, "", "GET", "/endpoints", nil, http.StatusUnauthorized, nil)
+}
+
+func (s *KSADMSuite) TestCreateTenantAndUser(c *gc.C) {
+	var tenant struct {
+		Tenant TenantResponse `json:"tenant"`
+	}
+	body := map[string]interface{}{"tenant": map[string]string{"name": "project"}}
+	s.request(c, s.adminToken, "POST", "/tenants", body, http.StatusOK, &tenant)
+	c.Assert(tenant.Tenant.Id, gc.Not(gc.Equals), "")
+	c.Assert(tenant.Tenant.Name, gc.Equals, "project")
+	c.Assert(tenant.Tenant.Enabled, gc.Equals, true)
+	s.request(c, s.adminToken, "POST", "/tenants", body, http.StatusConflict, nil)
+
+	var user struct {
+		User KSADMUser `json:"user"`
+	}
+	body = map[string]interface{}{"user": map[string]string{
+		"name":     "bob",
+		"password": "bobsecret",
+		"tenantId": tenant.Tenant.Id,
+	}}
+	s.request(c, s.adminToken, "POST", "/users", body, http.StatusOK, &user)
+	c.Assert(user.User, gc.DeepEquals, KSADMUser{
+		Id:       user.User.Id,
+		Name:     "bob",
+		TenantId: tenant.Tenant.Id,
+		Enabled:  true,
+	})
+	s.request(c, s.adminToken, "POST", "/users", body, http.StatusConflict, nil)
+
+	var shown struct {
+		User KSADMUser `json:"user"`
+	}
+	s.request(c, s.adminToken, "GET", "/users/"+user.User.Id, nil, http.StatusOK, &shown)
+	c.Assert(shown.User, gc.DeepEquals, user.User)
+	var users struct {
+		Users []KSADMUser `json:"users"`
+	}
+	s.request(c, s.adminToken, "GET", "/users", nil, http.StatusOK, &users)
+	c.Assert(users.Users, gc.HasLen, 3)
+	c.Assert(users.Users[2], gc.DeepEquals, user.User)
+
+	// The new user can authenticate.
+	res, err := tenantAuthRequest(s.Server.URL, "project", "bob", "bobsecret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *KSADMSuite) TestCreateUserErrors(c *gc.C) {
+	body := map[string]interface{}{"user": map[string]string{"name": "user", "tenantId": "1"}}
+	s.request(c, s.adminToken, "POST", "/users", body, http.StatusConflict, nil)
+	body = map[string]interface{}{"user": map[string]string{"name": "bob", "tenantId": "99"}}
+	s.request(c, s.adminToken, "POST", "/users", body, http.StatusNotFound, nil)
+	body = map[string]interface{}{"user": map[string]string{"tenantId": "1"}}
+	s.request(c, s.adminToken, "POST", "/users", body, http.StatusBadRequest, nil)
+	s.request(c, s.adminToken, "GET", "/users/99", nil, http.StatusNotFound, nil)
+	s.request(c, s.userToken, "POST", "/tenants", map[string]interface{}{"tenant": map[string]string{"name": "project"}}, http.StatusForbidden, nil)
+	s.request(c, s.userToken, "GET", "/users", nil, http.StatusForbidden, nil)
+}
+
+func (s *KSADMSuite) TestGrantRole(c *gc.C) {
+	user := s.identity.users["user"]
+	tenantId := s.identity.addTenant("project")
+	s.identity.AddRole("9", "operator")
+	path := "/tenants/" + tenantId + "/users/" + user.Id + "/roles/OS-KSADM/"
+	var role struct {
+		Role KSADMRole `json:"role"`
+	}
+	s.request(c, s.adminToken, "PUT", path+"9", nil, http.StatusOK, &role)
+	c.Assert(role.Role, gc.DeepEquals, KSADMRole{Id: "9", Name: "operator"})
+	s.request(c, s.adminToken, "PUT", path+"9", nil, http.StatusConflict, nil)
+	s.request(c, s.adminToken, "PUT", path+"1", nil, http.StatusOK, nil)
+
+	// The user is now a member of the tenant, holding the roles.
+	c.Assert(s.identity.users["user"].Roles, gc.DeepEquals, []RoleResponse{
+		{Id: "9", Name: "operator", TenantId: tenantId},
+		{Id: "1", Name: "admin", TenantId: tenantId},
+	})
+	res, err := tenantAuthRequest(s.Server.URL, "project", "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	// The roles are not granted to tokens scoped to other tenants.
+	userInfo, err := s.identity.FindUser(s.userToken)
+	c.Assert(err, gc.IsNil)
+	c.Assert(userInfo.Roles, gc.HasLen, 0)
+
+	s.request(c, s.adminToken, "DELETE", path+"9", nil, http.StatusNoContent, nil)
+	s.request(c, s.adminToken, "DELETE", path+"9", nil, http.StatusNotFound, nil)
+	c.Assert(s.identity.users["user"].Roles, gc.DeepEquals, []RoleResponse{
+		{Id: "1", Name: "admin", TenantId: tenantId},
+	})
+}
+
+func (s *KSADMSuite) TestAdminInOtherTenantForbidden(c *gc.C) {
+	err := s.identity.AddUserTenant("user", "42", "other-tenant", []RoleResponse{{Id: "1", Name: "admin"}})
+	c.Assert(err, gc.IsNil)
+	// The user's token is scoped to their own tenant, where they are
+	// not an admin.
+	s.request(c, s.userToken, "GET", "/users", nil, http.StatusForbidden, nil)
+}
+
+func (s *KSADMSuite) TestGrantRoleErrors(c *gc.C) {
+	user := s.identity.users["user"]
+	s.request(c, s.adminToken, "PUT", "/tenants/99/users/"+user.Id+"/roles/OS-KSADM/1", nil, http.StatusNotFound, nil)
+	s.request(c, s.adminToken, "PUT", "/tenants/"+user.TenantId+"/users/99/roles/OS-KSADM/1", nil, http.StatusNotFound, nil)
+	s.request(c, s.adminToken, "PUT", "/tenants/"+user.TenantId+"/users/"+user.Id+"/roles/OS-KSADM/99", nil, http.StatusNotFound, nil)
+	s.request(c, s.adminToken, "GET", "/tenants/"+user.TenantId+"/users/"+user.Id+"/roles/OS-KSADM/1", nil, http.StatusMethodNotAllowed, nil)
+	s.request(c, s.userToken, "PUT", "/tenants/"+user.TenantId+"/users/"+user.Id+"/roles/OS-KSADM/1", nil, http.StatusForbidden, nil)
+}
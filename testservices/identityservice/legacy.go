@@ -2,9 +2,12 @@ package identityservice
 
 import (
 	"net/http"
+
+	"gopkg.in/goose.v1/testservices/hook"
 )
 
 type Legacy struct {
+	hook.TestService
 	Users
 	managementURL string
 }
@@ -13,9 +16,17 @@ func NewLegacy() *Legacy {
 	service := &Legacy{}
 	service.users = make(map[string]UserInfo)
 	service.tenants = make(map[string]string)
+	service.tokens = make(map[string]scopedToken)
 	return service
 }
 
+func (lis *Legacy) Reset() {
+	lis.Users.Reset()
+	lis.ControlHooks = nil
+	lis.ClearResponseDelays()
+	lis.ClearResponseHeaders()
+}
+
 func (lis *Legacy) RegisterServiceProvider(name, serviceType string, serviceProvider ServiceProvider) {
 	// NOOP for legacy identity service.
 }
@@ -30,25 +41,21 @@ func (lis *Legacy) SetManagementURL(URL string) {
 
 // setupHTTP attaches all the needed handlers to provide the HTTP API.
 func (lis *Legacy) SetupHTTP(mux *http.ServeMux) {
-	mux.Handle("/", lis)
+	mux.Handle("/", controlled(&lis.TestService, lis))
 }
 
 func (lis *Legacy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	username := r.Header.Get("X-Auth-User")
-	userInfo, ok := lis.users[username]
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r.Method, "GET")
 		return
 	}
+	username := r.Header.Get("X-Auth-User")
 	auth_key := r.Header.Get("X-Auth-Key")
-	if auth_key != userInfo.secret {
+	userInfo, errmsg := lis.authenticate(username, auth_key)
+	if errmsg != "" {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	if userInfo.Token == "" {
-		userInfo.Token = randomHexToken()
-		lis.users[username] = userInfo
-	}
 	header := w.Header()
 	header.Set("X-Auth-Token", userInfo.Token)
 	header.Set("X-Server-Management-Url", lis.managementURL+"/compute")
@@ -20,6 +20,7 @@ func (s *LegacySuite) setupLegacy(user, secret string) (token, managementURL str
 	identity := NewLegacy()
 	// Ensure that it conforms to the interface
 	var _ IdentityService = identity
+	var _ Resetter = identity
 	identity.SetManagementURL(managementURL)
 	identity.SetupHTTP(s.Mux)
 	if user != "" {
@@ -30,7 +31,7 @@ func (s *LegacySuite) setupLegacy(user, secret string) (token, managementURL str
 }
 
 func LegacyAuthRequest(URL, user, key string) (*http.Response, error) {
-	client := &http.DefaultClient
+	client := http.DefaultClient
 	request, err := http.NewRequest("GET", URL, nil)
 	if err != nil {
 		return nil, err
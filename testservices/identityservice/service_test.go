@@ -1,6 +1,8 @@
 package identityservice
 
 import (
+	"fmt"
+
 	gc "gopkg.in/check.v1"
 
 	"gopkg.in/goose.v1/testing/httpsuite"
@@ -16,9 +18,52 @@ type IdentityServiceSuite struct {
 
 var _ = gc.Suite(&IdentityServiceSuite{service: NewUserPass()})
 var _ = gc.Suite(&IdentityServiceSuite{service: NewLegacy()})
+var _ = gc.Suite(&IdentityServiceSuite{service: NewV3UserPass()})
 
 func (s *IdentityServiceSuite) TestAddUserGivesNewToken(c *gc.C) {
 	userInfo1 := s.service.AddUser("user-1", "password-1", "tenant")
 	userInfo2 := s.service.AddUser("user-2", "password-2", "tenant")
 	c.Assert(userInfo1.Token, gc.Not(gc.Equals), userInfo2.Token)
 }
+
+func (s *IdentityServiceSuite) TestManyUsersHaveUniqueTokens(c *gc.C) {
+	// With single byte tokens collisions are all but certain, so
+	// this exercises regeneration of colliding tokens.
+	defer func(old int) { TokenLength = old }(TokenLength)
+	TokenLength = 1
+	tokens := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		userInfo := s.service.AddUser(fmt.Sprintf("many-user-%d", i), "password", "tenant")
+		c.Assert(tokens[userInfo.Token], gc.Equals, false, gc.Commentf("duplicate token %q", userInfo.Token))
+		tokens[userInfo.Token] = true
+	}
+}
+
+func (s *IdentityServiceSuite) TestTokenFactory(c *gc.C) {
+	defer func(old func(string) string) { TokenFactory = old }(TokenFactory)
+	TokenFactory = func(user string) string {
+		return "token-for-" + user
+	}
+	userInfo := s.service.AddUser("factory-user", "password", "tenant")
+	c.Assert(userInfo.Token, gc.Equals, "token-for-factory-user")
+	found, err := s.service.FindUser("token-for-factory-user")
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Name, gc.Equals, "factory-user")
+	// Re-adding the user reissues the same token.
+	userInfo = s.service.AddUser("factory-user", "new-password", "tenant")
+	c.Assert(userInfo.Token, gc.Equals, "token-for-factory-user")
+}
+
+func (s *IdentityServiceSuite) TestTokenFactoryDuplicates(c *gc.C) {
+	defer func(old func(string) string) { TokenFactory = old }(TokenFactory)
+	TokenFactory = func(user string) string {
+		return "same-token"
+	}
+	s.service.AddUser("dup-user-1", "password", "tenant")
+	c.Assert(func() {
+		s.service.AddUser("dup-user-2", "password", "tenant")
+	}, gc.PanicMatches, `cannot generate unused token for user "dup-user-2"`)
+	found, err := s.service.FindUser("same-token")
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Name, gc.Equals, "dup-user-1")
+}
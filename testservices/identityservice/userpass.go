@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/goose.v1/testservices/hook"
 )
@@ -12,9 +16,9 @@ import (
 // Implement the v2 User Pass form of identity (Keystone)
 
 type ErrorResponse struct {
-	Message string `json:"message"`
-	Code    int    `json:"code"`
-	Title   string `json:"title"`
+	Message string `json:"message" xml:"message"`
+	Code    int    `json:"code" xml:"code,attr"`
+	Title   string `json:"title" xml:"title,attr"`
 }
 
 type ErrorWrapper struct {
@@ -27,50 +31,55 @@ type UserPassRequest struct {
 			Username string `json:"username"`
 			Password string `json:"password"`
 		} `json:"passwordCredentials"`
+		// Token is set instead of PasswordCredentials when an
+		// existing token is being rescoped.
+		Token *struct {
+			Id string `json:"id"`
+		} `json:"token,omitempty"`
 		TenantName string `json:"tenantName"`
 	} `json:"auth"`
 }
 
 type Endpoint struct {
-	AdminURL    string `json:"adminURL"`
-	InternalURL string `json:"internalURL"`
-	PublicURL   string `json:"publicURL"`
-	Region      string `json:"region"`
+	AdminURL    string `json:"adminURL" xml:"adminURL,attr"`
+	InternalURL string `json:"internalURL" xml:"internalURL,attr"`
+	PublicURL   string `json:"publicURL" xml:"publicURL,attr"`
+	Region      string `json:"region" xml:"region,attr"`
 }
 
 type Service struct {
-	Name      string `json:"name"`
-	Type      string `json:"type"`
-	Endpoints []Endpoint
+	Name      string     `json:"name" xml:"name,attr"`
+	Type      string     `json:"type" xml:"type,attr"`
+	Endpoints []Endpoint `xml:"endpoint"`
 }
 
 type TokenResponse struct {
-	Expires string `json:"expires"` // should this be a date object?
-	Id      string `json:"id"`      // Actual token string
+	Expires string `json:"expires" xml:"expires,attr"` // should this be a date object?
+	Id      string `json:"id" xml:"id,attr"`           // Actual token string
 	Tenant  struct {
-		Id          string  `json:"id"`
-		Name        string  `json:"name"`
-		Description *string `json:"description"`
-	} `json:"tenant"`
+		Id          string  `json:"id" xml:"id,attr"`
+		Name        string  `json:"name" xml:"name,attr"`
+		Description *string `json:"description" xml:"description,omitempty"`
+	} `json:"tenant" xml:"tenant"`
 }
 
 type RoleResponse struct {
-	Id       string `json:"id"`
-	Name     string `json:"name"`
-	TenantId string `json:"tenantId"`
+	Id       string `json:"id" xml:"id,attr"`
+	Name     string `json:"name" xml:"name,attr"`
+	TenantId string `json:"tenantId" xml:"tenantId,attr"`
 }
 
 type UserResponse struct {
-	Id    string         `json:"id"`
-	Name  string         `json:"name"`
-	Roles []RoleResponse `json:"roles"`
+	Id    string         `json:"id" xml:"id,attr"`
+	Name  string         `json:"name" xml:"name,attr"`
+	Roles []RoleResponse `json:"roles" xml:"roles>role"`
 }
 
 type AccessResponse struct {
 	Access struct {
-		ServiceCatalog []Service     `json:"serviceCatalog"`
-		Token          TokenResponse `json:"token"`
-		User           UserResponse  `json:"user"`
+		ServiceCatalog []Service     `json:"serviceCatalog" xml:"serviceCatalog>service"`
+		Token          TokenResponse `json:"token" xml:"token"`
+		User           UserResponse  `json:"user" xml:"user"`
 	} `json:"access"`
 }
 
@@ -142,6 +151,39 @@ type UserPass struct {
 	hook.TestService
 	Users
 	services []Service
+	// Logger, if set, is told about each authentication attempt.
+	Logger Logger
+	// AuthURI, if set, is reported as the Keystone URI in the
+	// WWW-Authenticate header of 401 responses.
+	AuthURI string
+	// UnscopedTokens, if set, causes authentication requests which do
+	// not name a tenant to be issued an unscoped token, as Keystone
+	// does. Otherwise such tokens are scoped to the user's tenant.
+	UnscopedTokens bool
+	// PathPrefix, if set, is the path under which SetupHTTP mounts
+	// the service, such as "/identity" for a Keystone which is not
+	// hosted at the root of its server.
+	PathPrefix string
+
+	// failures holds the failures set with FailUser, keyed by user
+	// name.
+	failures map[string]userFailure
+
+	// catalogIds holds the ids by which the admin API refers to
+	// services and endpoints, and serviceDescriptions the
+	// descriptions given to services it creates, keyed by id.
+	catalogIds          map[string]string
+	nextCatalogId       int
+	serviceDescriptions map[string]string
+	// roles holds the roles added with AddRole, keyed by id.
+	roles map[string]string
+}
+
+// userFailure is a failure response to give to a user's
+// authentication requests.
+type userFailure struct {
+	status  int
+	message string
 }
 
 func NewUserPass() *UserPass {
@@ -150,27 +192,100 @@ func NewUserPass() *UserPass {
 	}
 	userpass.users = make(map[string]UserInfo)
 	userpass.tenants = make(map[string]string)
+	userpass.tokens = make(map[string]scopedToken)
 	return userpass
 }
 
+// FailUser makes all authentication requests for the given user fail
+// with the given status and message, regardless of the credentials
+// given, until ClearUserFailure is called. This lets tests simulate
+// accounts which are locked or disabled.
+func (u *UserPass) FailUser(user string, status int, message string) {
+	if u.failures == nil {
+		u.failures = make(map[string]userFailure)
+	}
+	u.failures[user] = userFailure{status, message}
+}
+
+// ClearUserFailure restores normal authentication for a user given to
+// FailUser.
+func (u *UserPass) ClearUserFailure(user string) {
+	delete(u.failures, user)
+}
+
+// Reset discards all issued tokens, control hooks and failures set
+// with FailUser. Users, tenants, services and the entries added with
+// the admin API are preserved.
+func (u *UserPass) Reset() {
+	u.Users.Reset()
+	u.ControlHooks = nil
+	u.ClearResponseDelays()
+	u.ClearResponseHeaders()
+	u.failures = nil
+}
+
+// checkUserFailure writes the failure set for the user with FailUser,
+// if any, reporting whether it did so.
+func (u *UserPass) checkUserFailure(w http.ResponseWriter, user, tenant string) bool {
+	failure, ok := u.failures[user]
+	if !ok {
+		return false
+	}
+	u.logf("userpass: rejected auth request: user %q, tenant %q: injected failure; status %d", user, tenant, failure.status)
+	u.ReturnFailure(w, failure.status, failure.message)
+	return true
+}
+
 func (u *UserPass) RegisterServiceProvider(name, serviceType string, serviceProvider ServiceProvider) {
 	service := Service{name, serviceType, serviceProvider.Endpoints()}
 	u.AddService(service)
 }
 
 func (u *UserPass) AddService(service Service) {
-	u.services = append(u.services, service)
+	u.services = addService(u.services, service)
+}
+
+// SetEndpoints replaces the endpoints of the registered service with
+// the given type. If there is no such service, one is added, named
+// after its type. The endpoints may be in any number of regions, all
+// of which are listed in the catalog.
+func (u *UserPass) SetEndpoints(serviceType string, endpoints []Endpoint) {
+	u.services = setEndpoints(u.services, serviceType, endpoints)
+}
+
+// SetUnversionedEndpoints lists the endpoints of the registered service
+// with the given type by the service's base URL, to which clients
+// append the API version, as some clouds do: versioned URLs are listed
+// without their version segment and anything following it. Endpoints
+// registered for the service afterwards are listed as they are given.
+func (u *UserPass) SetUnversionedEndpoints(serviceType string) {
+	unversionEndpoints(u.services, serviceType)
 }
 
 var internalError = []byte(`{
     "error": {
         "message": "Internal failure",
-	"code": 500,
-	"title": Internal Server Error"
+        "code": 500,
+        "title": "Internal Server Error"
     }
 }`)
 
 func (u *UserPass) ReturnFailure(w http.ResponseWriter, status int, message string) {
+	u.setAuthenticateHeader(w, status)
+	writeFailure(w, status, message)
+}
+
+// setAuthenticateHeader reports the Keystone URI, if set, in the
+// WWW-Authenticate header of a 401 response.
+func (u *UserPass) setAuthenticateHeader(w http.ResponseWriter, status int) {
+	if status == http.StatusUnauthorized && u.AuthURI != "" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Keystone uri=%q", u.AuthURI))
+	}
+}
+
+// writeFailure writes a Keystone error response with the given status,
+// in XML if that has been negotiated for the response.
+func writeFailure(w http.ResponseWriter, status int, message string) {
 	e := ErrorWrapper{
 		Error: ErrorResponse{
 			Message: message,
@@ -178,7 +293,11 @@ func (u *UserPass) ReturnFailure(w http.ResponseWriter, status int, message stri
 			Title:   http.StatusText(status),
 		},
 	}
-	if content, err := json.Marshal(e); err != nil {
+	content, err := json.Marshal(e)
+	if isXMLResponse(w) {
+		content, err = marshalXML("error", e.Error)
+	}
+	if err != nil {
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(internalError)))
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(internalError)
@@ -189,6 +308,39 @@ func (u *UserPass) ReturnFailure(w http.ResponseWriter, status int, message stri
 	}
 }
 
+// writeMethodNotAllowed writes a Keystone error response to a request
+// whose method is not one of those allowed, which are listed in the
+// Allow header.
+func writeMethodNotAllowed(w http.ResponseWriter, method string, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeFailure(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not allowed", method))
+}
+
+// writeResponse writes a successful response with the given JSON
+// content. Like Keystone, the content length is always given, rather
+// than the response being chunked.
+func writeResponse(w http.ResponseWriter, content []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// writeStatus writes a response with the given status and no body, as
+// Keystone does for HEAD requests.
+func writeStatus(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(status)
+}
+
+// setHeaders sets the headers common to all responses. Responses
+// depend on the token given, so caches are told they vary with it, as
+// Keystone does.
+func setHeaders(w http.ResponseWriter) {
+	// Testing against Canonistack, all responses are application/json, even failures
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "X-Auth-Token")
+}
+
 // Taken from an actual responses, however it may vary based on actual Openstack implementation
 const (
 	notJSON = ("Expecting to find application/json in Content-Type header." +
@@ -196,11 +348,23 @@ const (
 		" or otherwise incorrect. The client is assumed to be in error.")
 )
 
+// logf logs a message if a Logger has been set.
+func (u *UserPass) logf(format string, args ...interface{}) {
+	if u.Logger != nil {
+		u.Logger.Logf(format, args...)
+	}
+}
+
 func (u *UserPass) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var req UserPassRequest
-	// Testing against Canonistack, all responses are application/json, even failures
-	w.Header().Set("Content-Type", "application/json")
-	if r.Header.Get("Content-Type") != "application/json" {
+	setHeaders(w)
+	negotiateFormat(w, r)
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r.Method, "POST")
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); !isJSON(contentType) {
+		u.logf("userpass: rejected auth request: bad content type %q; status %d", contentType, http.StatusBadRequest)
 		u.ReturnFailure(w, http.StatusBadRequest, notJSON)
 		return
 	}
@@ -213,44 +377,227 @@ func (u *UserPass) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	userInfo, errmsg := u.authenticate(req.Auth.PasswordCredentials.Username, req.Auth.PasswordCredentials.Password)
-	if errmsg != "" {
-		u.ReturnFailure(w, http.StatusUnauthorized, errmsg)
-		return
+	var userInfo *UserInfo
+	var username string
+	if req.Auth.Token != nil {
+		var err error
+		userInfo, err = u.FindUser(req.Auth.Token.Id)
+		if err != nil {
+			u.logf("userpass: rejected auth request: tenant %q: %v; status %d", req.Auth.TenantName, err, http.StatusUnauthorized)
+			u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+			return
+		}
+		username = userInfo.Name
+		if u.checkUserFailure(w, username, req.Auth.TenantName) {
+			return
+		}
+	} else {
+		username = req.Auth.PasswordCredentials.Username
+		if u.checkUserFailure(w, username, req.Auth.TenantName) {
+			return
+		}
+		errmsg := u.checkPassword(username, req.Auth.PasswordCredentials.Password)
+		switch errmsg {
+		case "":
+		case invalidUser:
+			u.logf("userpass: rejected auth request: user %q, tenant %q: bad password; status %d", username, req.Auth.TenantName, http.StatusUnauthorized)
+		default:
+			u.logf("userpass: rejected auth request: user %q, tenant %q: unknown user; status %d", username, req.Auth.TenantName, http.StatusUnauthorized)
+		}
+		if errmsg != "" {
+			u.ReturnFailure(w, http.StatusUnauthorized, errmsg)
+			return
+		}
+	}
+	if tenantName := req.Auth.TenantName; tenantName != "" {
+		tenantId, ok := u.memberTenantId(username, tenantName)
+		if !ok {
+			u.logf("userpass: rejected auth request: user %q, tenant %q: unknown tenant; status %d", username, tenantName, http.StatusUnauthorized)
+			u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+			return
+		}
+		if req.Auth.Token != nil {
+			// A rescoped token is always a new one, even for the
+			// tenant the given token is scoped to.
+			_, userInfo, _ = u.userForToken(u.newScopedToken(username, tenantId))
+		} else {
+			userInfo = u.issueScopedToken(username, tenantId)
+		}
+	} else if userInfo == nil {
+		if u.UnscopedTokens {
+			// An unscoped token can be used to discover the user's
+			// tenants before authenticating again with one of them.
+			userInfo = u.issueScopedToken(username, "")
+		} else {
+			userInfo = u.issueToken(username)
+		}
 	}
 	res, err := u.generateAccessResponse(userInfo)
 	if err != nil {
 		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	content, err := json.Marshal(res)
+	content, err := marshalAccess(w, res)
 	if err != nil {
 		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(content)
+	u.logf("userpass: accepted auth request: user %q, tenant %q; status %d", userInfo.Name, u.tenants[userInfo.TenantId], http.StatusOK)
+	// Some clients read the issued token from the response headers.
+	w.Header().Set("X-Auth-Token", userInfo.Token)
+	writeResponse(w, content)
 }
 
 func (u *UserPass) generateAccessResponse(userInfo *UserInfo) (*AccessResponse, error) {
 	res := AccessResponse{}
 	// We pre-populate the response with genuine entries so that it looks sane.
-	// XXX: We should really build up valid state for this instead, at the
-	//	very least, we should manage the URLs better.
+	// XXX: We should really build up valid state for this instead.
 	if err := json.Unmarshal([]byte(exampleResponse), &res); err != nil {
 		return nil, err
 	}
-	res.Access.ServiceCatalog = u.services
+	// The example catalog is only used if no services have been registered.
+	if len(u.services) > 0 {
+		res.Access.ServiceCatalog = u.services
+	}
 	res.Access.Token.Id = userInfo.Token
+	res.Access.Token.Expires = userInfo.Expires.Format(time.RFC3339)
 	res.Access.Token.Tenant.Id = userInfo.TenantId
+	res.Access.Token.Tenant.Name = u.tenants[userInfo.TenantId]
 	res.Access.User.Id = userInfo.Id
+	res.Access.User.Name = userInfo.Name
+	switch {
+	case userInfo.TenantId == "":
+		// Unscoped tokens grant no roles, and access to no services.
+		res.Access.ServiceCatalog = []Service{}
+		res.Access.User.Roles = []RoleResponse{}
+	case len(userInfo.Roles) > 0:
+		res.Access.User.Roles = userInfo.Roles
+	default:
+		for i := range res.Access.User.Roles {
+			res.Access.User.Roles[i].TenantId = userInfo.TenantId
+		}
+	}
 	if err := u.ProcessControlHook("authorisation", u, &res, userInfo); err != nil {
 		return nil, err
 	}
 	return &res, nil
 }
 
+// handleValidateToken handles GET /tokens/<token>, returning the
+// access details for the user holding the token. A HEAD request
+// checks the token without returning them.
+func (u *UserPass) handleValidateToken(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	negotiateFormat(w, r)
+	token := path.Base(r.URL.Path)
+	if r.Method == "HEAD" {
+		status := u.checkTokenStatus(r.Header.Get("X-Auth-Token"), token)
+		u.setAuthenticateHeader(w, status)
+		writeStatus(w, status)
+		return
+	}
+	if r.Method == "DELETE" {
+		switch status := u.revokeSubjectToken(r.Header.Get("X-Auth-Token"), token); status {
+		case http.StatusUnauthorized:
+			u.ReturnFailure(w, status, notAuthorized)
+		case http.StatusForbidden:
+			u.ReturnFailure(w, status, revokeForbidden)
+		case http.StatusNotFound:
+			u.ReturnFailure(w, status, fmt.Sprintf("Could not find token, %s.", token))
+		default:
+			writeStatus(w, status)
+		}
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r.Method, "GET", "HEAD", "DELETE")
+		return
+	}
+	_, userInfo, ok := u.userForToken(token)
+	if !ok {
+		u.ReturnFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find token, %s.", token))
+		return
+	}
+	if userInfo.expired(u.now()) {
+		u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+		return
+	}
+	res, err := u.generateAccessResponse(userInfo)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	content, err := marshalAccess(w, res)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, content)
+}
+
+type TenantResponse struct {
+	Id          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	Enabled     bool    `json:"enabled"`
+}
+
+type TenantsResponse struct {
+	Tenants []TenantResponse `json:"tenants"`
+}
+
+// handleTenants handles GET /tenants, returning the tenants available
+// to the user holding the token in the X-Auth-Token header. The token
+// may be unscoped. Admin users may also create tenants.
+func (u *UserPass) handleTenants(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method == "POST" {
+		u.createTenant(w, r)
+		return
+	}
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r.Method, "GET", "POST")
+		return
+	}
+	userInfo, err := u.FindUser(r.Header.Get("X-Auth-Token"))
+	if err != nil {
+		u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+		return
+	}
+	res := TenantsResponse{Tenants: []TenantResponse{}}
+	// The token's user is listed as a member of all their tenants,
+	// whichever the token is scoped to.
+	member := u.users[userInfo.Name]
+	for _, id := range member.tenantIds() {
+		res.Tenants = append(res.Tenants, TenantResponse{
+			Id:      id,
+			Name:    u.tenants[id],
+			Enabled: true,
+		})
+	}
+	content, err := json.Marshal(res)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, content)
+}
+
 // setupHTTP attaches all the needed handlers to provide the HTTP API.
 func (u *UserPass) SetupHTTP(mux *http.ServeMux) {
-	mux.Handle("/tokens", u)
+	handlers := map[string]http.HandlerFunc{
+		"/tokens":             u.ServeHTTP,
+		"/tokens/":            u.handleValidateToken,
+		"/tenants":            u.handleTenants,
+		"/OS-KSADM/services":  u.handleAdminServices,
+		"/OS-KSADM/services/": u.handleAdminServices,
+		"/endpoints":          u.handleAdminEndpoints,
+		"/endpoints/":         u.handleAdminEndpoints,
+		"/users":              u.handleAdminUsers,
+		"/users/":             u.handleAdminUsers,
+		"/tenants/":           u.handleRoleGrants,
+	}
+	for pattern, h := range handlers {
+		mountHandler(mux, u.PathPrefix, pattern, controlled(&u.TestService, h))
+	}
 }
@@ -2,14 +2,19 @@ package identityservice
 
 import (
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	gc "gopkg.in/check.v1"
 
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testing/clock"
 	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices/hook"
 )
 
 type UserPassSuite struct {
@@ -22,6 +27,7 @@ func makeUserPass(user, secret string) (identity *UserPass) {
 	identity = NewUserPass()
 	// Ensure that it conforms to the interface
 	var _ IdentityService = identity
+	var _ Resetter = identity
 	if user != "" {
 		identity.AddUser(user, secret, "tenant")
 	}
@@ -47,7 +53,7 @@ func (s *UserPassSuite) setupUserPassWithServices(user, secret string, services
 
 var authTemplate = `{
     "auth": {
-        "tenantName": "tenant-something", 
+        "tenantName": "tenant", 
         "passwordCredentials": {
             "username": "%s", 
             "password": "%s"
@@ -56,7 +62,7 @@ var authTemplate = `{
 }`
 
 func userPassAuthRequest(URL, user, key string) (*http.Response, error) {
-	client := &http.DefaultClient
+	client := http.DefaultClient
 	body := strings.NewReader(fmt.Sprintf(authTemplate, user, key))
 	request, err := http.NewRequest("POST", URL+"/tokens", body)
 	request.Header.Set("Content-Type", "application/json")
@@ -84,7 +90,7 @@ func CheckErrorResponse(c *gc.C, r *http.Response, status int, msg string) {
 func (s *UserPassSuite) TestNotJSON(c *gc.C) {
 	// We do everything in userPassAuthRequest, except set the Content-Type
 	s.setupUserPass("user", "secret")
-	client := &http.DefaultClient
+	client := http.DefaultClient
 	body := strings.NewReader(fmt.Sprintf(authTemplate, "user", "secret"))
 	request, err := http.NewRequest("POST", s.Server.URL+"/tokens", body)
 	c.Assert(err, gc.IsNil)
@@ -94,6 +100,121 @@ func (s *UserPassSuite) TestNotJSON(c *gc.C) {
 	CheckErrorResponse(c, res, http.StatusBadRequest, notJSON)
 }
 
+func (s *UserPassSuite) TestMethodNotAllowed(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	for i, t := range []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"GET", "/tokens", "POST"},
+		{"PUT", "/tokens/token", "GET, HEAD, DELETE"},
+		{"PUT", "/tenants", "GET, POST"},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.path)
+		request, err := http.NewRequest(t.method, s.Server.URL+t.path, nil)
+		c.Assert(err, gc.IsNil)
+		res, err := http.DefaultClient.Do(request)
+		c.Assert(err, gc.IsNil)
+		c.Check(res.Header.Get("Allow"), gc.Equals, t.allow)
+		CheckErrorResponse(c, res, http.StatusMethodNotAllowed, "Method "+t.method+" is not allowed")
+		res.Body.Close()
+	}
+}
+
+func (s *UserPassSuite) TestXMLResponses(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", []Service{
+		{"nova", "compute", []Endpoint{
+			{PublicURL: "http://nova.invalid", Region: "region"},
+		}}})
+	body := strings.NewReader(fmt.Sprintf(authTemplate, "user", "secret"))
+	request, err := http.NewRequest("POST", s.Server.URL+"/tokens", body)
+	c.Assert(err, gc.IsNil)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/xml")
+	res, err := http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), gc.Equals, "application/xml")
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(content), gc.Matches, `(?s)<\?xml .*\?>\n<access xmlns="http://docs.openstack.org/identity/api/v2.0">.*`)
+	var access AccessResponse
+	err = xml.Unmarshal(content, &access.Access)
+	c.Assert(err, gc.IsNil)
+	c.Check(access.Access.Token.Id, gc.Equals, res.Header.Get("X-Auth-Token"))
+	c.Check(access.Access.Token.Tenant.Name, gc.Equals, "tenant")
+	c.Check(access.Access.User.Name, gc.Equals, "user")
+	c.Check(access.Access.User.Roles, gc.HasLen, 1)
+	c.Check(access.Access.ServiceCatalog, gc.DeepEquals, []Service{
+		{"nova", "compute", []Endpoint{
+			{PublicURL: "http://nova.invalid", Region: "region"},
+		}}})
+
+	// Failures are XML too.
+	request, err = http.NewRequest("GET", s.Server.URL+"/tokens/invalid", nil)
+	c.Assert(err, gc.IsNil)
+	request.Header.Set("Accept", "application/xml")
+	res, err = http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusNotFound)
+	c.Assert(res.Header.Get("Content-Type"), gc.Equals, "application/xml")
+	content, err = ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var failure ErrorResponse
+	err = xml.Unmarshal(content, &failure)
+	c.Assert(err, gc.IsNil)
+	c.Check(failure, gc.DeepEquals, ErrorResponse{
+		Message: "Could not find token, invalid.",
+		Code:    http.StatusNotFound,
+		Title:   "Not Found",
+	})
+}
+
+func (s *UserPassSuite) TestAcceptsXML(c *gc.C) {
+	for i, t := range []struct {
+		accept string
+		xml    bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"application/xml", true},
+		{"text/html, application/xml;q=0.9", true},
+		{"application/json, application/xml", false},
+		{"application/xml, application/json", true},
+	} {
+		c.Logf("test %d: %q", i, t.accept)
+		request, err := http.NewRequest("GET", "http://example.com/tokens", nil)
+		c.Assert(err, gc.IsNil)
+		request.Header.Set("Accept", t.accept)
+		c.Check(acceptsXML(request), gc.Equals, t.xml)
+	}
+}
+
+func (s *UserPassSuite) TestJSONWithCharset(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	body := strings.NewReader(fmt.Sprintf(authTemplate, "user", "secret"))
+	request, err := http.NewRequest("POST", s.Server.URL+"/tokens", body)
+	c.Assert(err, gc.IsNil)
+	request.Header.Set("Content-Type", "Application/JSON; charset=UTF-8")
+	res, err := http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *UserPassSuite) TestWrongContentType(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	body := strings.NewReader(fmt.Sprintf(authTemplate, "user", "secret"))
+	res, err := http.Post(s.Server.URL+"/tokens", "text/plain", body)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusBadRequest, notJSON)
+}
+
 func (s *UserPassSuite) TestBadJSON(c *gc.C) {
 	// We do everything in userPassAuthRequest, except set the Content-Type
 	s.setupUserPass("user", "secret")
@@ -145,3 +266,974 @@ func (s *UserPassSuite) TestValidAuthorization(c *gc.C) {
 	}
 	c.Assert(novaURL, gc.Equals, compute_url)
 }
+
+func (s *UserPassSuite) TestPathPrefix(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.PathPrefix = "/identity/"
+	identity.SetupHTTP(s.Mux)
+	res, err := userPassAuthRequest(s.Server.URL+"/identity", "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusOK)
+	var response AccessResponse
+	err = json.NewDecoder(res.Body).Decode(&response)
+	c.Assert(err, gc.IsNil)
+
+	// The token is validated under the prefix too.
+	req, err := http.NewRequest("GET", s.Server.URL+"/identity/tokens/"+response.Access.Token.Id, nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("X-Auth-Token", response.Access.Token.Id)
+	validated, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	validated.Body.Close()
+	c.Check(validated.StatusCode, gc.Equals, http.StatusOK)
+
+	unprefixed, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	unprefixed.Body.Close()
+	c.Check(unprefixed.StatusCode, gc.Equals, http.StatusNotFound)
+}
+
+func (s *UserPassSuite) TestTokenExpiry(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response AccessResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	expires, err := time.Parse(time.RFC3339, response.Access.Token.Expires)
+	c.Assert(err, gc.IsNil)
+	remaining := expires.Sub(time.Now())
+	c.Check(remaining > 59*time.Minute && remaining <= time.Hour, gc.Equals, true)
+}
+
+func (s *UserPassSuite) TestExpiredTokenRejected(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUserWithExpiry("user", "secret", -time.Minute)
+	_, err := identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.ErrorMatches, "Token .* has expired")
+}
+
+func (s *UserPassSuite) TestReauthenticateAfterExpiry(c *gc.C) {
+	identity := NewUserPass()
+	clk := clock.NewManualClock(time.Now())
+	identity.Clock = clk
+	userInfo := identity.AddUserWithExpiry("user", "secret", time.Minute)
+	oldToken := userInfo.Token
+	clk.Advance(time.Minute)
+	identity.SetupHTTP(s.Mux)
+	// The user's tenant is named after them.
+	response := s.authenticatedAccess(c, "user", "user", "secret")
+	c.Check(response.Access.Token.Id, gc.Not(gc.Equals), oldToken)
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, userInfo.TenantId)
+	_, err := identity.FindUser(oldToken)
+	c.Check(err, gc.NotNil)
+}
+
+func (s *UserPassSuite) TestClockExpiresTokens(c *gc.C) {
+	identity := NewUserPass()
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewManualClock(start)
+	identity.Clock = clk
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	c.Assert(userInfo.Expires, gc.Equals, start.Add(time.Hour))
+	identity.SetupHTTP(s.Mux)
+
+	clk.Advance(time.Hour - time.Second)
+	_, err := identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	c.Assert(identity.Tokens(), gc.DeepEquals, map[string]string{"user": userInfo.Token})
+	res, err := validateTokenRequest(s.Server.URL, userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+
+	clk.Advance(time.Second)
+	_, err = identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.ErrorMatches, "Token .* has expired")
+	c.Assert(identity.Tokens(), gc.HasLen, 0)
+	res, err = validateTokenRequest(s.Server.URL, userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func validateTokenRequest(URL, token string) (*http.Response, error) {
+	return http.Get(URL + "/tokens/" + token)
+}
+
+func (s *UserPassSuite) TestValidateToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	identity.SetupHTTP(s.Mux)
+	res, err := validateTokenRequest(s.Server.URL, userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response AccessResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Check(response.Access.Token.Id, gc.Equals, userInfo.Token)
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, userInfo.TenantId)
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "tenant")
+	c.Check(response.Access.User.Id, gc.Equals, userInfo.Id)
+	c.Assert(response.Access.User.Roles, gc.HasLen, 1)
+	c.Check(response.Access.User.Roles[0].TenantId, gc.Equals, userInfo.TenantId)
+}
+
+func (s *UserPassSuite) TestFernetTokens(c *gc.C) {
+	identity := NewUserPass()
+	uuidUser := identity.AddUser("uuid-user", "secret", "tenant")
+	identity.TokenFormat = FernetTokens
+	fernetUser := identity.AddUser("fernet-user", "secret", "tenant")
+	identity.SetupHTTP(s.Mux)
+	c.Assert(uuidUser.Token, gc.HasLen, 32)
+	c.Assert(len(fernetUser.Token) > 180, gc.Equals, true, gc.Commentf("%q", fernetUser.Token))
+	c.Assert(strings.HasPrefix(fernetUser.Token, "gAAAAA"), gc.Equals, true, gc.Commentf("%q", fernetUser.Token))
+	for _, userInfo := range []*UserInfo{uuidUser, fernetUser} {
+		found, err := identity.FindUser(userInfo.Token)
+		c.Assert(err, gc.IsNil)
+		c.Check(found.Name, gc.Equals, userInfo.Name)
+		res, err := validateTokenRequest(s.Server.URL, userInfo.Token)
+		c.Assert(err, gc.IsNil)
+		res.Body.Close()
+		c.Check(res.StatusCode, gc.Equals, http.StatusOK)
+	}
+	res, err := userPassAuthRequest(s.Server.URL, "fernet-user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	var response AccessResponse
+	err = json.NewDecoder(res.Body).Decode(&response)
+	c.Assert(err, gc.IsNil)
+	c.Check(response.Access.Token.Id, gc.Equals, fernetUser.Token)
+}
+
+func (s *UserPassSuite) TestValidateUnknownToken(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res, err := validateTokenRequest(s.Server.URL, "no-such-token")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusNotFound, "Could not find token, no-such-token.")
+}
+
+func (s *UserPassSuite) TestValidateExpiredToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUserWithExpiry("user", "secret", -time.Minute)
+	identity.SetupHTTP(s.Mux)
+	res, err := validateTokenRequest(s.Server.URL, userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *UserPassSuite) TestCheckToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	expired := identity.AddUserWithExpiry("expired", "secret", -time.Minute)
+	revoked := identity.AddUser("revoked", "secret", "tenant")
+	identity.RevokeToken(revoked.Token)
+	identity.SetupHTTP(s.Mux)
+	for i, t := range []struct {
+		authToken    string
+		subjectToken string
+		status       int
+	}{
+		{userInfo.Token, userInfo.Token, http.StatusOK},
+		{userInfo.Token, "no-such-token", http.StatusNotFound},
+		{userInfo.Token, expired.Token, http.StatusNotFound},
+		{userInfo.Token, revoked.Token, http.StatusNotFound},
+		{"", userInfo.Token, http.StatusUnauthorized},
+		{expired.Token, userInfo.Token, http.StatusUnauthorized},
+	} {
+		c.Logf("test %d: %q checking %q", i, t.authToken, t.subjectToken)
+		request, err := http.NewRequest("HEAD", s.Server.URL+"/tokens/"+t.subjectToken, nil)
+		c.Assert(err, gc.IsNil)
+		request.Header.Set("X-Auth-Token", t.authToken)
+		res, err := http.DefaultClient.Do(request)
+		c.Assert(err, gc.IsNil)
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Check(res.StatusCode, gc.Equals, t.status)
+		c.Check(res.Header.Get("Content-Length"), gc.Equals, "0")
+		c.Check(body, gc.HasLen, 0)
+	}
+}
+
+func (s *UserPassSuite) TestDeleteToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	other := identity.AddUser("other", "secret", "tenant")
+	admin := identity.AddUser("admin", "secret", "tenant")
+	err := identity.SetUserTenant("admin", admin.TenantId, "tenant", []RoleResponse{{Id: "1", Name: adminRole}})
+	c.Assert(err, gc.IsNil)
+	expired := identity.AddUserWithExpiry("expired", "secret", -time.Minute)
+	identity.SetupHTTP(s.Mux)
+	for i, t := range []struct {
+		authToken    string
+		subjectToken string
+		status       int
+	}{
+		{"", other.Token, http.StatusUnauthorized},
+		{userInfo.Token, "no-such-token", http.StatusNotFound},
+		{userInfo.Token, expired.Token, http.StatusNotFound},
+		// Only admins may revoke other users' tokens.
+		{userInfo.Token, other.Token, http.StatusForbidden},
+		{admin.Token, other.Token, http.StatusNoContent},
+		{admin.Token, other.Token, http.StatusNotFound},
+		// A client logs out by revoking its own token.
+		{userInfo.Token, userInfo.Token, http.StatusNoContent},
+		{userInfo.Token, userInfo.Token, http.StatusUnauthorized},
+	} {
+		c.Logf("test %d: %q revoking %q", i, t.authToken, t.subjectToken)
+		request, err := http.NewRequest("DELETE", s.Server.URL+"/tokens/"+t.subjectToken, nil)
+		c.Assert(err, gc.IsNil)
+		request.Header.Set("X-Auth-Token", t.authToken)
+		res, err := http.DefaultClient.Do(request)
+		c.Assert(err, gc.IsNil)
+		res.Body.Close()
+		c.Check(res.StatusCode, gc.Equals, t.status)
+	}
+	_, err = identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.NotNil)
+	_, err = identity.FindUser(other.Token)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *UserPassSuite) TestDeleteTokenForbiddenMessage(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	other := identity.AddUser("other", "secret", "tenant")
+	identity.SetupHTTP(s.Mux)
+	request, err := http.NewRequest("DELETE", s.Server.URL+"/tokens/"+other.Token, nil)
+	c.Assert(err, gc.IsNil)
+	request.Header.Set("X-Auth-Token", userInfo.Token)
+	res, err := http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusForbidden, revokeForbidden)
+	_, err = identity.FindUser(other.Token)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *UserPassSuite) TestTokens(c *gc.C) {
+	identity := NewUserPass()
+	c.Assert(identity.Tokens(), gc.HasLen, 0)
+	user1 := identity.AddUser("user1", "secret", "tenant")
+	user2 := identity.AddUser("user2", "secret", "tenant")
+	identity.AddUserWithExpiry("expired", "secret", -time.Minute)
+	tokens := identity.Tokens()
+	c.Assert(tokens, gc.DeepEquals, map[string]string{
+		"user1": user1.Token,
+		"user2": user2.Token,
+	})
+	// The returned map is a copy.
+	delete(tokens, "user1")
+	c.Assert(identity.Tokens(), gc.HasLen, 2)
+}
+
+func (s *UserPassSuite) TestRevokeToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	identity.SetupHTTP(s.Mux)
+	identity.RevokeToken(userInfo.Token)
+	c.Assert(identity.Tokens(), gc.HasLen, 0)
+	_, err := identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.NotNil)
+	res, err := validateTokenRequest(s.Server.URL, userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusNotFound, "Could not find token, "+userInfo.Token+".")
+
+	// Authenticating again issues a new token.
+	response := s.authenticatedAccess(c, "tenant", "user", "secret")
+	c.Assert(response.Access.Token.Id, gc.Not(gc.Equals), "")
+	c.Assert(response.Access.Token.Id, gc.Not(gc.Equals), userInfo.Token)
+	c.Assert(identity.Tokens(), gc.DeepEquals, map[string]string{"user": response.Access.Token.Id})
+	found, err := identity.FindUser(response.Access.Token.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Name, gc.Equals, "user")
+}
+
+func (s *UserPassSuite) TestRevokeUnknownToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	identity.RevokeToken("no-such-token")
+	c.Assert(identity.Tokens(), gc.DeepEquals, map[string]string{"user": userInfo.Token})
+}
+
+func (s *UserPassSuite) TestReset(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	identity.AddService(Service{Name: "nova", Type: "compute"})
+	identity.FailUser("user", http.StatusForbidden, "locked")
+	identity.SetupHTTP(s.Mux)
+	identity.Reset()
+	c.Assert(identity.Tokens(), gc.HasLen, 0)
+	_, err := identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.NotNil)
+
+	// The user can authenticate again, and is issued a new token.
+	response := s.authenticatedAccess(c, "tenant", "user", "secret")
+	c.Assert(response.Access.Token.Id, gc.Not(gc.Equals), userInfo.Token)
+	c.Assert(response.Access.Token.Tenant.Id, gc.Equals, userInfo.TenantId)
+	c.Assert(response.Access.ServiceCatalog, gc.HasLen, 1)
+	c.Assert(response.Access.ServiceCatalog[0].Name, gc.Equals, "nova")
+}
+
+func (s *UserPassSuite) TestResponseHeaders(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	identity.SetResponseHeader("X-Openstack-Request-Id", "req-fixed")
+	identity.SetResponseHeader("Vary", "")
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(res.Header.Get("X-Openstack-Request-Id"), gc.Equals, "req-fixed")
+	_, ok := res.Header["Vary"]
+	c.Assert(ok, gc.Equals, false)
+
+	identity.Reset()
+	res, err = userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.Header.Get("X-Openstack-Request-Id"), gc.Equals, "")
+}
+
+func (s *UserPassSuite) TestResponseDelay(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	identity.SetEndpointDelay("^/tokens$", hook.FixedDelay(50*time.Millisecond))
+	start := time.Now()
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(time.Since(start) >= 50*time.Millisecond, gc.Equals, true)
+
+	identity.Reset()
+	start = time.Now()
+	res, err = userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(time.Since(start) < 50*time.Millisecond, gc.Equals, true)
+}
+
+func (s *UserPassSuite) authenticatedCatalog(c *gc.C) []Service {
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response AccessResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	return response.Access.ServiceCatalog
+}
+
+func (s *UserPassSuite) TestDefaultCatalog(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	catalog := s.authenticatedCatalog(c)
+	c.Assert(catalog, gc.HasLen, 3)
+	c.Check(catalog[0].Type, gc.Equals, "compute")
+	c.Check(catalog[0].Endpoints[0].PublicURL, gc.Equals, "https://nova-api.trystack.org:9774/v1.1/1")
+}
+
+func (s *UserPassSuite) TestSetEndpoints(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.AddService(Service{"nova", "compute", []Endpoint{
+		{PublicURL: "http://testing.invalid/old"},
+	}})
+	identity.SetEndpoints("compute", []Endpoint{{PublicURL: "http://testing.invalid/compute"}})
+	identity.SetEndpoints("object-store", []Endpoint{{PublicURL: "http://testing.invalid/swift"}})
+	identity.SetupHTTP(s.Mux)
+	catalog := s.authenticatedCatalog(c)
+	c.Assert(catalog, gc.DeepEquals, []Service{
+		{"nova", "compute", []Endpoint{{PublicURL: "http://testing.invalid/compute"}}},
+		{"object-store", "object-store", []Endpoint{{PublicURL: "http://testing.invalid/swift"}}},
+	})
+}
+
+func (s *UserPassSuite) TestUnversionedEndpoints(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.AddService(Service{"nova", "compute", []Endpoint{
+		{PublicURL: "http://testing.invalid/v2/tenant", Region: "versioned"},
+	}})
+	identity.SetEndpoints("image", []Endpoint{
+		{PublicURL: "http://testing.invalid/glance/v1", InternalURL: "http://internal.invalid/glance/"},
+	})
+	identity.SetUnversionedEndpoints("image")
+	identity.SetupHTTP(s.Mux)
+	catalog := s.authenticatedCatalog(c)
+	c.Assert(catalog, gc.DeepEquals, []Service{
+		{"nova", "compute", []Endpoint{
+			{PublicURL: "http://testing.invalid/v2/tenant", Region: "versioned"},
+		}},
+		{"image", "image", []Endpoint{
+			{PublicURL: "http://testing.invalid/glance/", InternalURL: "http://internal.invalid/glance/"},
+		}},
+	})
+}
+
+func (s *UserPassSuite) TestNewerServiceTypes(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.AddService(Service{"cinderv3", ServiceTypeVolumeV3, []Endpoint{{PublicURL: "http://testing.invalid/volume"}}})
+	identity.AddService(Service{"heat", ServiceTypeOrchestration, []Endpoint{{PublicURL: "http://testing.invalid/heat"}}})
+	identity.SetEndpoints(ServiceTypeDNS, []Endpoint{{PublicURL: "http://testing.invalid/dns"}})
+	identity.SetEndpoints(ServiceTypeShareV2, []Endpoint{{PublicURL: "http://testing.invalid/share"}})
+	identity.SetEndpoints(ServiceTypeMetric, []Endpoint{{PublicURL: "http://testing.invalid/metric"}})
+	identity.SetupHTTP(s.Mux)
+	catalog := s.authenticatedCatalog(c)
+	c.Assert(catalog, gc.DeepEquals, []Service{
+		{"cinderv3", "volumev3", []Endpoint{{PublicURL: "http://testing.invalid/volume"}}},
+		{"heat", "orchestration", []Endpoint{{PublicURL: "http://testing.invalid/heat"}}},
+		{"dns", "dns", []Endpoint{{PublicURL: "http://testing.invalid/dns"}}},
+		{"sharev2", "sharev2", []Endpoint{{PublicURL: "http://testing.invalid/share"}}},
+		{"metric", "metric", []Endpoint{{PublicURL: "http://testing.invalid/metric"}}},
+	})
+}
+
+func (s *UserPassSuite) TestAddServiceInRegions(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.AddService(Service{"nova", "compute", []Endpoint{
+		{PublicURL: "http://testing.invalid/region1", Region: "region1"},
+	}})
+	identity.AddService(Service{"nova", "compute", []Endpoint{
+		{PublicURL: "http://testing.invalid/region2", Region: "region2"},
+	}})
+	identity.AddService(Service{"nova", "compute", []Endpoint{
+		{PublicURL: "http://testing.invalid/region1-new", Region: "region1"},
+	}})
+	identity.AddService(Service{"swift", "object-store", []Endpoint{
+		{PublicURL: "http://testing.invalid/swift", Region: "region1"},
+	}})
+	identity.SetupHTTP(s.Mux)
+	catalog := s.authenticatedCatalog(c)
+	c.Assert(catalog, gc.DeepEquals, []Service{
+		{"nova", "compute", []Endpoint{
+			{PublicURL: "http://testing.invalid/region1-new", Region: "region1"},
+			{PublicURL: "http://testing.invalid/region2", Region: "region2"},
+		}},
+		{"swift", "object-store", []Endpoint{
+			{PublicURL: "http://testing.invalid/swift", Region: "region1"},
+		}},
+	})
+}
+
+func (s *UserPassSuite) TestRegionServiceURLs(c *gc.C) {
+	userPass := makeUserPass("user", "secret")
+	userPass.SetEndpoints("compute", []Endpoint{
+		{PublicURL: "http://testing.invalid/region1", Region: "region1"},
+		{PublicURL: "http://testing.invalid/region2", Region: "region2"},
+	})
+	userPass.SetupHTTP(s.Mux)
+	creds := identity.Credentials{
+		URL:        s.Server.URL + "/tokens",
+		User:       "user",
+		Secrets:    "secret",
+		TenantName: "tenant",
+	}
+	auth, err := (&identity.UserPass{}).Auth(&creds)
+	c.Assert(err, gc.IsNil)
+	c.Assert(auth.RegionServiceURLs["region1"]["compute"], gc.Equals, "http://testing.invalid/region1")
+	c.Assert(auth.RegionServiceURLs["region2"]["compute"], gc.Equals, "http://testing.invalid/region2")
+	c.Assert(auth.RegionServiceURLs["region3"], gc.HasLen, 0)
+}
+
+func (s *UserPassSuite) authenticatedAccess(c *gc.C, tenant, user, secret string) AccessResponse {
+	res, err := tenantAuthRequest(s.Server.URL, tenant, user, secret)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response AccessResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	return response
+}
+
+func (s *UserPassSuite) TestDefaultTenantAndRoles(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	response := s.authenticatedAccess(c, "tenant", "user", "secret")
+	c.Check(response.Access.User.Name, gc.Equals, "user")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "tenant")
+	c.Check(response.Access.User.Roles, gc.DeepEquals, []RoleResponse{
+		{Id: "2", Name: "Member", TenantId: response.Access.Token.Tenant.Id},
+	})
+}
+
+func (s *UserPassSuite) TestPerUserTenantAndRoles(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.AddUser("other", "secret2", "tenant")
+	err := identity.SetUserTenant("other", "42", "other-tenant", []RoleResponse{
+		{Id: "1", Name: "admin"},
+		{Id: "3", Name: "Member", TenantId: "43"},
+	})
+	c.Assert(err, gc.IsNil)
+	identity.SetupHTTP(s.Mux)
+
+	response := s.authenticatedAccess(c, "other-tenant", "other", "secret2")
+	c.Check(response.Access.User.Name, gc.Equals, "other")
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, "42")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "other-tenant")
+	c.Check(response.Access.User.Roles, gc.DeepEquals, []RoleResponse{
+		{Id: "1", Name: "admin", TenantId: "42"},
+	})
+
+	response = s.authenticatedAccess(c, "tenant", "user", "secret")
+	c.Check(response.Access.User.Name, gc.Equals, "user")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "tenant")
+}
+
+func (s *UserPassSuite) TestSetUserTenantUnknownUser(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	err := identity.SetUserTenant("other", "42", "other-tenant", nil)
+	c.Assert(err, gc.ErrorMatches, `No such user "other"`)
+}
+
+var tenantAuthTemplate = `{
+    "auth": {
+        "tenantName": "%s",
+        "passwordCredentials": {
+            "username": "%s",
+            "password": "%s"
+        }
+    }
+}`
+
+func tenantAuthRequest(URL, tenant, user, key string) (*http.Response, error) {
+	body := strings.NewReader(fmt.Sprintf(tenantAuthTemplate, tenant, user, key))
+	request, err := http.NewRequest("POST", URL+"/tokens", body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return http.DefaultClient.Do(request)
+}
+
+func (s *UserPassSuite) TestUnknownTenant(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res, err := tenantAuthRequest(s.Server.URL, "not-my-tenant", "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *UserPassSuite) TestTenantScopedToken(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	err := identity.AddUserTenant("user", "42", "other-tenant", nil)
+	c.Assert(err, gc.IsNil)
+	identity.AddUser("other", "secret", "private-tenant")
+	identity.SetupHTTP(s.Mux)
+	tokens := make(map[string]bool)
+	for _, test := range []struct {
+		tenantName string
+		tenantId   string
+	}{
+		{"tenant", "1"},
+		{"other-tenant", "42"},
+	} {
+		res, err := tenantAuthRequest(s.Server.URL, test.tenantName, "user", "secret")
+		c.Assert(err, gc.IsNil)
+		c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+		content, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, gc.IsNil)
+		var response AccessResponse
+		err = json.Unmarshal(content, &response)
+		c.Assert(err, gc.IsNil)
+		c.Check(response.Access.Token.Tenant.Id, gc.Equals, test.tenantId)
+		c.Check(response.Access.Token.Tenant.Name, gc.Equals, test.tenantName)
+		// Each tenant gets its own token, bound to that tenant.
+		c.Check(tokens[response.Access.Token.Id], gc.Equals, false)
+		tokens[response.Access.Token.Id] = true
+		userInfo, err := identity.FindUser(response.Access.Token.Id)
+		c.Assert(err, gc.IsNil)
+		c.Check(userInfo.TenantId, gc.Equals, test.tenantId)
+	}
+	// Tenants of other users are not available.
+	res, err := tenantAuthRequest(s.Server.URL, "private-tenant", "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *UserPassSuite) TestNoTenantScopedToDefaultTenant(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	response := s.authenticatedAccess(c, "", "user", "secret")
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, "1")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "tenant")
+	c.Check(response.Access.ServiceCatalog, gc.Not(gc.HasLen), 0)
+}
+
+func (s *UserPassSuite) TestUnscopedToken(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.UnscopedTokens = true
+	identity.AddService(Service{"nova", "compute", []Endpoint{{PublicURL: "http://testing.invalid/nova"}}})
+	identity.SetupHTTP(s.Mux)
+	response := s.authenticatedAccess(c, "", "user", "secret")
+	c.Check(response.Access.Token.Id, gc.Not(gc.Equals), "")
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, "")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "")
+	c.Check(response.Access.ServiceCatalog, gc.HasLen, 0)
+	c.Check(response.Access.User.Name, gc.Equals, "user")
+	c.Check(response.Access.User.Roles, gc.HasLen, 0)
+	// Naming a tenant still gives a scoped token.
+	response = s.authenticatedAccess(c, "tenant", "user", "secret")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "tenant")
+	c.Check(response.Access.ServiceCatalog, gc.HasLen, 1)
+}
+
+func (s *UserPassSuite) listTenants(c *gc.C, token string) *http.Response {
+	request, err := http.NewRequest("GET", s.Server.URL+"/tenants", nil)
+	c.Assert(err, gc.IsNil)
+	request.Header.Set("X-Auth-Token", token)
+	res, err := http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	return res
+}
+
+func (s *UserPassSuite) TestListTenants(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	err := identity.AddUserTenant("user", "42", "other-tenant", nil)
+	c.Assert(err, gc.IsNil)
+	identity.AddUser("other", "secret", "private-tenant")
+	identity.SetupHTTP(s.Mux)
+	res := s.listTenants(c, userInfo.Token)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), gc.Equals, "application/json")
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response TenantsResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Assert(response.Tenants, gc.DeepEquals, []TenantResponse{
+		{Id: userInfo.TenantId, Name: "tenant", Enabled: true},
+		{Id: "42", Name: "other-tenant", Enabled: true},
+	})
+}
+
+func (s *UserPassSuite) TestListTenantsBadToken(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res := s.listTenants(c, "no-such-token")
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *UserPassSuite) TestTenantDiscovery(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.UnscopedTokens = true
+	err := identity.AddUserTenant("user", "42", "other-tenant", nil)
+	c.Assert(err, gc.IsNil)
+	identity.SetupHTTP(s.Mux)
+	unscoped := s.authenticatedAccess(c, "", "user", "secret")
+	res := s.listTenants(c, unscoped.Access.Token.Id)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	var tenants TenantsResponse
+	err = json.NewDecoder(res.Body).Decode(&tenants)
+	c.Assert(err, gc.IsNil)
+	c.Assert(tenants.Tenants, gc.HasLen, 2)
+	scoped := s.authenticatedAccess(c, tenants.Tenants[1].Name, "user", "secret")
+	c.Check(scoped.Access.Token.Tenant.Id, gc.Equals, "42")
+	c.Check(scoped.Access.ServiceCatalog, gc.Not(gc.HasLen), 0)
+}
+
+var tokenAuthTemplate = `{
+    "auth": {
+        "tenantName": "%s",
+        "token": {
+            "id": "%s"
+        }
+    }
+}`
+
+func tokenAuthRequest(URL, tenant, token string) (*http.Response, error) {
+	body := strings.NewReader(fmt.Sprintf(tokenAuthTemplate, tenant, token))
+	request, err := http.NewRequest("POST", URL+"/tokens", body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return http.DefaultClient.Do(request)
+}
+
+func (s *UserPassSuite) TestRescopeToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	err := identity.AddUserTenant("user", "42", "other-tenant", nil)
+	c.Assert(err, gc.IsNil)
+	identity.SetupHTTP(s.Mux)
+	res, err := tokenAuthRequest(s.Server.URL, "other-tenant", userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response AccessResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Check(response.Access.Token.Id, gc.Not(gc.Equals), "")
+	c.Check(response.Access.Token.Id, gc.Not(gc.Equals), userInfo.Token)
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, "42")
+	c.Check(response.Access.Token.Tenant.Name, gc.Equals, "other-tenant")
+	c.Check(response.Access.User.Name, gc.Equals, "user")
+	// The new token carries the new tenant, and the original one is
+	// still scoped to the user's own tenant.
+	rescoped, err := identity.FindUser(response.Access.Token.Id)
+	c.Assert(err, gc.IsNil)
+	c.Check(rescoped.TenantId, gc.Equals, "42")
+	original, err := identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	c.Check(original.TenantId, gc.Equals, userInfo.TenantId)
+}
+
+func (s *UserPassSuite) TestRescopeTokenToSameTenant(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	identity.SetupHTTP(s.Mux)
+	res, err := tokenAuthRequest(s.Server.URL, "tenant", userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	var response AccessResponse
+	err = json.NewDecoder(res.Body).Decode(&response)
+	c.Assert(err, gc.IsNil)
+	c.Check(response.Access.Token.Id, gc.Not(gc.Equals), userInfo.Token)
+	c.Check(response.Access.Token.Tenant.Id, gc.Equals, userInfo.TenantId)
+}
+
+func (s *UserPassSuite) TestRescopeUnknownToken(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res, err := tokenAuthRequest(s.Server.URL, "tenant", "no-such-token")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *UserPassSuite) TestRescopeExpiredToken(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUserWithExpiry("user", "secret", -time.Minute)
+	identity.SetupHTTP(s.Mux)
+	res, err := tokenAuthRequest(s.Server.URL, "user", userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *UserPassSuite) TestInternalErrorIsValidJSON(c *gc.C) {
+	var errmsg ErrorWrapper
+	err := json.Unmarshal(internalError, &errmsg)
+	c.Assert(err, gc.IsNil)
+	c.Check(errmsg.Error.Code, gc.Equals, http.StatusInternalServerError)
+	c.Check(errmsg.Error.Title, gc.Equals, http.StatusText(http.StatusInternalServerError))
+	c.Check(errmsg.Error.Message, gc.Equals, "Internal failure")
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Logf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (s *UserPassSuite) setupLoggingUserPass() *recordingLogger {
+	logger := &recordingLogger{}
+	identity := makeUserPass("user", "secret")
+	identity.Logger = logger
+	identity.SetupHTTP(s.Mux)
+	return logger
+}
+
+func (s *UserPassSuite) TestLogBadContentType(c *gc.C) {
+	logger := s.setupLoggingUserPass()
+	body := strings.NewReader(fmt.Sprintf(authTemplate, "user", "secret"))
+	res, err := http.Post(s.Server.URL+"/tokens", "text/plain", body)
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(logger.messages, gc.DeepEquals, []string{
+		`userpass: rejected auth request: bad content type "text/plain"; status 400`,
+	})
+}
+
+func (s *UserPassSuite) TestLogUnknownUser(c *gc.C) {
+	logger := s.setupLoggingUserPass()
+	res, err := userPassAuthRequest(s.Server.URL, "not-user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(logger.messages, gc.DeepEquals, []string{
+		`userpass: rejected auth request: user "not-user", tenant "tenant": unknown user; status 401`,
+	})
+}
+
+func (s *UserPassSuite) TestLogBadPassword(c *gc.C) {
+	logger := s.setupLoggingUserPass()
+	res, err := userPassAuthRequest(s.Server.URL, "user", "not-secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(logger.messages, gc.DeepEquals, []string{
+		`userpass: rejected auth request: user "user", tenant "tenant": bad password; status 401`,
+	})
+}
+
+func (s *UserPassSuite) TestLogSuccess(c *gc.C) {
+	logger := s.setupLoggingUserPass()
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(logger.messages, gc.DeepEquals, []string{
+		`userpass: accepted auth request: user "user", tenant "tenant"; status 200`,
+	})
+}
+
+func (s *UserPassSuite) TestWWWAuthenticate(c *gc.C) {
+	identity := makeUserPass("user", "secret")
+	identity.AuthURI = "http://keystone.invalid:5000"
+	identity.SetupHTTP(s.Mux)
+	res, err := userPassAuthRequest(s.Server.URL, "user", "not-secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.Header.Get("WWW-Authenticate"), gc.Equals, `Keystone uri="http://keystone.invalid:5000"`)
+	CheckErrorResponse(c, res, http.StatusUnauthorized, invalidUser)
+}
+
+func (s *UserPassSuite) TestNoWWWAuthenticateWithoutAuthURI(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res, err := userPassAuthRequest(s.Server.URL, "user", "not-secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.Header.Get("WWW-Authenticate"), gc.Equals, "")
+}
+
+func (s *UserPassSuite) TestTokenHeader(c *gc.C) {
+	s.setupUserPass("user", "secret")
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	var response AccessResponse
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Assert(res.Header.Get("X-Auth-Token"), gc.Equals, response.Access.Token.Id)
+}
+
+func (s *UserPassSuite) TestContentLengthAndVary(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	// Make the catalog large enough that the response would
+	// otherwise be chunked.
+	for i := 0; i < 50; i++ {
+		identity.AddService(Service{fmt.Sprintf("service%d", i), "compute", []Endpoint{
+			{PublicURL: fmt.Sprintf("http://testing.invalid/%d", i), Region: "region"},
+		}})
+	}
+	identity.SetupHTTP(s.Mux)
+	for i, t := range []struct {
+		about string
+		send  func() (*http.Response, error)
+		code  int
+	}{{
+		about: "authenticate",
+		send: func() (*http.Response, error) {
+			return userPassAuthRequest(s.Server.URL, "user", "secret")
+		},
+		code: http.StatusOK,
+	}, {
+		about: "bad password",
+		send: func() (*http.Response, error) {
+			return userPassAuthRequest(s.Server.URL, "user", "wrong")
+		},
+		code: http.StatusUnauthorized,
+	}, {
+		about: "validate token",
+		send: func() (*http.Response, error) {
+			return validateTokenRequest(s.Server.URL, userInfo.Token)
+		},
+		code: http.StatusOK,
+	}, {
+		about: "validate unknown token",
+		send: func() (*http.Response, error) {
+			return validateTokenRequest(s.Server.URL, "no-such-token")
+		},
+		code: http.StatusNotFound,
+	}, {
+		about: "list tenants",
+		send: func() (*http.Response, error) {
+			req, err := http.NewRequest("GET", s.Server.URL+"/tenants", nil)
+			c.Assert(err, gc.IsNil)
+			req.Header.Set("X-Auth-Token", userInfo.Token)
+			return http.DefaultClient.Do(req)
+		},
+		code: http.StatusOK,
+	}} {
+		c.Logf("test %d: %s", i, t.about)
+		res, err := t.send()
+		c.Assert(err, gc.IsNil)
+		content, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Check(res.StatusCode, gc.Equals, t.code)
+		c.Check(res.Header.Get("Content-Length"), gc.Equals, fmt.Sprint(len(content)))
+		c.Check(res.Header.Get("Vary"), gc.Equals, "X-Auth-Token")
+	}
+}
+
+func (s *UserPassSuite) TestFailUser(c *gc.C) {
+	identity := NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	identity.AddUser("other", "secret", "tenant")
+	identity.SetupHTTP(s.Mux)
+	identity.FailUser("user", http.StatusForbidden, "The account is disabled for user: user")
+	// The failure is given regardless of the password.
+	for _, password := range []string{"secret", "wrong"} {
+		res, err := userPassAuthRequest(s.Server.URL, "user", password)
+		c.Assert(err, gc.IsNil)
+		CheckErrorResponse(c, res, http.StatusForbidden, "The account is disabled for user: user")
+		res.Body.Close()
+	}
+	// Rescoping an existing token fails too.
+	res, err := tokenAuthRequest(s.Server.URL, "tenant", userInfo.Token)
+	c.Assert(err, gc.IsNil)
+	CheckErrorResponse(c, res, http.StatusForbidden, "The account is disabled for user: user")
+	res.Body.Close()
+	// Other users are unaffected.
+	res, err = userPassAuthRequest(s.Server.URL, "other", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+
+	identity.ClearUserFailure("user")
+	res, err = userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *UserPassSuite) TestLogUserFailure(c *gc.C) {
+	logger := &recordingLogger{}
+	identity := makeUserPass("user", "secret")
+	identity.Logger = logger
+	identity.FailUser("user", http.StatusForbidden, "locked")
+	identity.SetupHTTP(s.Mux)
+	res, err := userPassAuthRequest(s.Server.URL, "user", "secret")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Assert(logger.messages, gc.DeepEquals, []string{
+		`userpass: rejected auth request: user "user", tenant "tenant": injected failure; status 403`,
+	})
+}
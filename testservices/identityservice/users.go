@@ -2,14 +2,68 @@ package identityservice
 
 import (
 	"fmt"
+	"net/http"
 	"strconv"
+	"time"
+)
+
+// defaultTokenDuration is how long issued tokens remain valid unless
+// otherwise specified.
+const defaultTokenDuration = time.Hour
+
+// A Clock tells the time. Tests may give an identity service a fake
+// clock so they can control when tokens expire.
+type Clock interface {
+	Now() time.Time
+}
+
+// A TokenFormat determines the shape of the tokens issued by an
+// identity service.
+type TokenFormat int
+
+const (
+	// UUIDTokens are short hex strings, as issued by Keystone's UUID
+	// token provider. This is the default.
+	UUIDTokens TokenFormat = iota
+	// FernetTokens are long URL-safe base64 strings, as issued by
+	// Keystone's Fernet token provider.
+	FernetTokens
 )
 
 type Users struct {
+	// Clock, if set, is used whenever a token's expiry time is
+	// computed or checked. It defaults to the system clock.
+	Clock Clock
+	// TokenFormat is the format of tokens issued from now on.
+	// Tokens already issued remain valid whatever their format.
+	TokenFormat TokenFormat
+
 	nextUserId   int
 	nextTenantId int
 	users        map[string]UserInfo
 	tenants      map[string]string
+	// tokens maps issued tokens to the user and tenant they were
+	// issued for.
+	tokens map[string]scopedToken
+}
+
+// scopedToken records what an issued token is for. As in Keystone, a
+// token is scoped to a single tenant, and grants only the roles its
+// user holds in that tenant.
+type scopedToken struct {
+	user string
+	// tenantId holds the tenant the token is scoped to, or is empty
+	// for an unscoped token.
+	tenantId string
+	expires  time.Time
+}
+
+// now returns the current time according to the service's clock.
+func (u *Users) now() time.Time {
+	if u.Clock == nil {
+		return time.Now()
+	}
+	return u.Clock.Now()
 }
 
 func (u *Users) addTenant(tenant string) string {
@@ -25,39 +79,316 @@ func (u *Users) addTenant(tenant string) string {
 }
 
 func (u *Users) AddUser(user, secret, tenant string) *UserInfo {
+	return u.addUser(user, secret, tenant, defaultTokenDuration)
+}
+
+// AddUserWithExpiry adds a user whose tokens expire the given duration
+// after they are issued. Authenticating after the token has expired
+// issues a new token. The user is a member of a tenant with the same
+// name as the user, as though they had been added with AddUser(user,
+// secret, user); SetUserTenant moves them to another.
+func (u *Users) AddUserWithExpiry(user, secret string, d time.Duration) *UserInfo {
+	return u.addUser(user, secret, user, d)
+}
+
+// addUser adds a user, a member of the named tenant, whose tokens
+// expire the given duration after they are issued.
+func (u *Users) addUser(user, secret, tenant string, d time.Duration) *UserInfo {
 	tenantId := u.addTenant(tenant)
 	u.nextUserId++
-	userInfo := &UserInfo{secret: secret, Id: strconv.Itoa(u.nextUserId), TenantId: tenantId}
+	userInfo := &UserInfo{
+		secret:        secret,
+		Id:            strconv.Itoa(u.nextUserId),
+		Name:          user,
+		TenantId:      tenantId,
+		tokenDuration: d,
+	}
+	if _, ok := u.users[user]; ok {
+		for token, scoped := range u.tokens {
+			if scoped.user == user {
+				delete(u.tokens, token)
+			}
+		}
+	}
 	u.users[user] = *userInfo
 	userInfo, _ = u.authenticate(user, secret)
 	return userInfo
 }
 
+// SetUserTenant sets the tenant of an existing user, along with the
+// roles the user holds in that tenant. Roles which do not specify a
+// tenant are assigned to the given tenant. The user's current token,
+// as returned by AddUser, is scoped to the new tenant.
+func (u *Users) SetUserTenant(user, tenantId, tenantName string, roles []RoleResponse) error {
+	userInfo, ok := u.users[user]
+	if !ok {
+		return fmt.Errorf("No such user %q", user)
+	}
+	u.tenants[tenantId] = tenantName
+	userInfo.TenantId = tenantId
+	if scoped, ok := u.tokens[userInfo.Token]; ok {
+		scoped.tenantId = tenantId
+		u.tokens[userInfo.Token] = scoped
+	}
+	userInfo.Roles = make([]RoleResponse, len(roles))
+	for i, role := range roles {
+		if role.TenantId == "" {
+			role.TenantId = tenantId
+		}
+		userInfo.Roles[i] = role
+	}
+	u.users[user] = userInfo
+	return nil
+}
+
+// AddUserTenant makes an existing user a member of an additional
+// tenant, holding the given roles in that tenant. The user can then
+// request tokens scoped to the new tenant by name.
+func (u *Users) AddUserTenant(user, tenantId, tenantName string, roles []RoleResponse) error {
+	userInfo, ok := u.users[user]
+	if !ok {
+		return fmt.Errorf("No such user %q", user)
+	}
+	u.tenants[tenantId] = tenantName
+	userInfo.otherTenantIds = append(userInfo.otherTenantIds, tenantId)
+	for _, role := range roles {
+		if role.TenantId == "" {
+			role.TenantId = tenantId
+		}
+		userInfo.Roles = append(userInfo.Roles, role)
+	}
+	u.users[user] = userInfo
+	return nil
+}
+
+// memberTenantId returns the id of the named tenant if the named
+// user is a member of it, or false otherwise.
+func (u *Users) memberTenantId(user, tenantName string) (string, bool) {
+	userInfo := u.users[user]
+	for _, id := range userInfo.tenantIds() {
+		if u.tenants[id] == tenantName {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// FindUser returns the details of the user holding the given token,
+// as seen through the token: TenantId is the tenant the token is
+// scoped to, and Roles holds only the roles granted in that tenant.
 func (u *Users) FindUser(token string) (*UserInfo, error) {
-	for _, userInfo := range u.users {
-		if userInfo.Token == token {
-			return &userInfo, nil
+	_, userInfo, ok := u.userForToken(token)
+	if !ok {
+		return nil, fmt.Errorf("No user with token %v exists", token)
+	}
+	if userInfo.expired(u.now()) {
+		return nil, fmt.Errorf("Token %v has expired", token)
+	}
+	return userInfo, nil
+}
+
+// Tokens returns a map from the name of each user holding a valid
+// token scoped to their own tenant to that token.
+func (u *Users) Tokens() map[string]string {
+	tokens := make(map[string]string)
+	for name, userInfo := range u.users {
+		if _, ok := u.tokens[userInfo.Token]; ok && !userInfo.expired(u.now()) {
+			tokens[name] = userInfo.Token
+		}
+	}
+	return tokens
+}
+
+// RevokeToken invalidates the given token, so that requests made with
+// it are rejected as unauthorised. The user holding the token is
+// issued a new one when they next authenticate.
+func (u *Users) RevokeToken(token string) {
+	scoped, ok := u.tokens[token]
+	if !ok {
+		return
+	}
+	delete(u.tokens, token)
+	if userInfo, ok := u.users[scoped.user]; ok && userInfo.Token == token {
+		userInfo.Token = ""
+		u.users[scoped.user] = userInfo
+	}
+}
+
+// Reset discards all the tokens issued so far, as if they had been
+// revoked, so that previously issued tokens are rejected and users are
+// issued new ones when they next authenticate. Users and tenants are
+// preserved.
+func (u *Users) Reset() {
+	u.tokens = make(map[string]scopedToken)
+	for name, userInfo := range u.users {
+		userInfo.Token = ""
+		u.users[name] = userInfo
+	}
+}
+
+// checkTokenStatus returns the status of Keystone's response to a
+// HEAD request, made with authToken, which checks subjectToken: 401
+// if the auth token is not valid, 404 if the subject token is
+// unknown, revoked or expired, and 200 otherwise. Keystone does not
+// distinguish expired subject tokens from unknown ones.
+func (u *Users) checkTokenStatus(authToken, subjectToken string) int {
+	if _, err := u.FindUser(authToken); err != nil {
+		return http.StatusUnauthorized
+	}
+	if _, err := u.FindUser(subjectToken); err != nil {
+		return http.StatusNotFound
+	}
+	return http.StatusOK
+}
+
+// revokeSubjectToken handles Keystone's DELETE request, made with
+// authToken, which revokes subjectToken. It returns the status of the
+// response: 401 if the auth token is not valid, 404 if the subject
+// token is unknown, revoked or expired, 403 if the subject token
+// belongs to another user and the auth token is not an admin's, and
+// 204 once it is revoked. A client logs out by revoking its own token.
+func (u *Users) revokeSubjectToken(authToken, subjectToken string) int {
+	if status := u.checkTokenStatus(authToken, subjectToken); status != http.StatusOK {
+		return status
+	}
+	authUser, _ := u.FindUser(authToken)
+	subjectUser, _ := u.FindUser(subjectToken)
+	if authUser.Name != subjectUser.Name && !authUser.HasRole(adminRole) {
+		return http.StatusForbidden
+	}
+	u.RevokeToken(subjectToken)
+	return http.StatusNoContent
+}
+
+// userForToken returns the name and details of the user holding the
+// given token, as FindUser does, regardless of whether the token has
+// expired.
+func (u *Users) userForToken(token string) (string, *UserInfo, bool) {
+	scoped, ok := u.tokens[token]
+	if !ok {
+		return "", nil, false
+	}
+	userInfo, ok := u.users[scoped.user]
+	if !ok {
+		return "", nil, false
+	}
+	userInfo.TenantId = scoped.tenantId
+	userInfo.Token = token
+	userInfo.Expires = scoped.expires
+	userInfo.Roles = tenantRoles(userInfo.Roles, scoped.tenantId)
+	return scoped.user, &userInfo, true
+}
+
+// tenantRoles returns the roles which are granted in the given tenant.
+func tenantRoles(roles []RoleResponse, tenantId string) []RoleResponse {
+	var result []RoleResponse
+	for _, role := range roles {
+		if role.TenantId == "" || role.TenantId == tenantId {
+			result = append(result, role)
 		}
 	}
-	return nil, fmt.Errorf("No user with token %v exists", token)
+	return result
 }
 
 const (
-	notAuthorized = "The request you have made requires authentication."
-	invalidUser   = "Invalid user / password"
+	notAuthorized   = "The request you have made requires authentication."
+	invalidUser     = "Invalid user / password"
+	revokeForbidden = "You are not authorized to perform the requested action: identity:revoke_token"
 )
 
-func (u *Users) authenticate(username, password string) (*UserInfo, string) {
+// TokenFactory generates the UUID format tokens issued to users. It
+// defaults to generating random tokens of TokenLength bytes; tests may
+// replace it with a deterministic function so token values can be
+// predicted.
+// A factory which returns a token already held by another user is
+// called again, and if it keeps doing so the service panics, so
+// avoiding duplicates is the responsibility of the factory.
+var TokenFactory = func(user string) string {
+	return randomHexToken()
+}
+
+// maxTokenAttempts is the number of times TokenFactory is called
+// before giving up on finding an unused token.
+const maxTokenAttempts = 100
+
+// newToken returns a new token for the given user which is not held
+// by any other user.
+func (u *Users) newToken(user string) string {
+	for i := 0; i < maxTokenAttempts; i++ {
+		var token string
+		if u.TokenFormat == FernetTokens {
+			token = randomFernetToken(u.now())
+		} else {
+			token = TokenFactory(user)
+		}
+		if _, ok := u.tokens[token]; !ok {
+			return token
+		}
+	}
+	panic(fmt.Sprintf("cannot generate unused token for user %q", user))
+}
+
+// checkPassword returns a message explaining why the given password
+// does not authenticate the named user, or "" if it does.
+func (u *Users) checkPassword(username, password string) string {
 	userInfo, ok := u.users[username]
 	if !ok {
-		return nil, notAuthorized
+		return notAuthorized
 	}
 	if userInfo.secret != password {
-		return nil, invalidUser
+		return invalidUser
+	}
+	return ""
+}
+
+func (u *Users) authenticate(username, password string) (*UserInfo, string) {
+	if errmsg := u.checkPassword(username, password); errmsg != "" {
+		return nil, errmsg
 	}
-	if userInfo.Token == "" {
-		userInfo.Token = randomHexToken()
+	return u.issueToken(username), ""
+}
+
+// issueToken returns the details of the named user, who must exist,
+// first issuing them a new token scoped to their own tenant if they
+// hold none that is valid.
+func (u *Users) issueToken(username string) *UserInfo {
+	userInfo := u.users[username]
+	if userInfo.Token == "" || userInfo.expired(u.now()) {
+		delete(u.tokens, userInfo.Token)
+		userInfo.Token = u.newScopedToken(username, userInfo.TenantId)
+		userInfo.Expires = u.tokens[userInfo.Token].expires
 		u.users[username] = userInfo
 	}
-	return &userInfo, ""
+	_, scoped, _ := u.userForToken(userInfo.Token)
+	return scoped
+}
+
+// issueScopedToken returns the details of the named user, who must
+// exist, as seen through a valid token scoped to the given tenant,
+// which is issued if the user holds none. An empty tenantId gives an
+// unscoped token.
+func (u *Users) issueScopedToken(username, tenantId string) *UserInfo {
+	if tenantId == u.users[username].TenantId {
+		return u.issueToken(username)
+	}
+	for token, scoped := range u.tokens {
+		if scoped.user == username && scoped.tenantId == tenantId && scoped.expires.After(u.now()) {
+			_, userInfo, _ := u.userForToken(token)
+			return userInfo
+		}
+	}
+	_, userInfo, _ := u.userForToken(u.newScopedToken(username, tenantId))
+	return userInfo
+}
+
+// newScopedToken issues the named user, who must exist, a new token
+// scoped to the given tenant, and returns it.
+func (u *Users) newScopedToken(username, tenantId string) string {
+	token := u.newToken(username)
+	u.tokens[token] = scopedToken{
+		user:     username,
+		tenantId: tenantId,
+		expires:  u.now().Add(u.users[username].tokenDuration),
+	}
+	return token
 }
@@ -2,31 +2,219 @@ package identityservice
 
 import (
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/goose.v1"
 )
 
 type UserInfo struct {
 	Id       string
+	Name     string
 	TenantId string
 	Token    string
-	secret   string
+	// Roles holds the roles granted to the user. If empty, the user
+	// is reported as having the example "Member" role.
+	Roles []RoleResponse
+	// Expires holds the time after which Token is no longer valid.
+	Expires       time.Time
+	secret        string
+	tokenDuration time.Duration
+	// otherTenantIds holds the tenants other than TenantId which
+	// the user is a member of.
+	otherTenantIds []string
+}
+
+// defaultRole is the role users without any explicitly granted roles
+// are reported as having.
+const defaultRole = "Member"
+
+// HasRole reports whether the user has been granted the named role in
+// their tenant, TenantId. Roles granted in other tenants are ignored.
+// Users without any roles granted in the tenant have only the default
+// "Member" role.
+func (u *UserInfo) HasRole(name string) bool {
+	roles := tenantRoles(u.Roles, u.TenantId)
+	if len(roles) == 0 {
+		return name == defaultRole
+	}
+	for _, role := range roles {
+		if role.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantIds returns the ids of all the tenants the user is a member of.
+func (u *UserInfo) tenantIds() []string {
+	return append([]string{u.TenantId}, u.otherTenantIds...)
+}
+
+// expired reports whether the user's token has expired at the given
+// time.
+func (u *UserInfo) expired(now time.Time) bool {
+	return !u.Expires.After(now)
 }
 
 var randReader = rand.Reader
 
+// TokenLength is the number of random bytes used to generate each
+// token; tokens are hex encoded, so are twice this many characters
+// long. The default of 16 bytes gives 128 bits of entropy, so the
+// chance of any two of n tokens being equal is about n²/2¹²⁹, which
+// is negligible for any realistic number of users. Tokens are
+// regenerated on collision regardless, but TokenLength must allow
+// more distinct values than there are users.
+var TokenLength = 16
+
 // Generate a bit of random hex data for
 func randomHexToken() string {
-	raw_bytes := make([]byte, 16)
+	raw_bytes := make([]byte, TokenLength)
 	n, err := io.ReadFull(randReader, raw_bytes)
 	if err != nil {
 		panic(fmt.Sprintf(
-			"failed to read 16 random bytes (read %d bytes): %s",
-			n, err.Error()))
+			"failed to read %d random bytes (read %d bytes): %s",
+			TokenLength, n, err.Error()))
 	}
-	hex_bytes := make([]byte, 32)
+	hex_bytes := make([]byte, hex.EncodedLen(len(raw_bytes)))
 	// hex.Encode can't fail, no error checking needed.
 	hex.Encode(hex_bytes, raw_bytes)
 	return string(hex_bytes)
 }
+
+// fernetPayloadLength is the number of random bytes standing in for
+// the IV, encrypted payload and HMAC of a Fernet token, which is
+// typical of a project scoped token.
+const fernetPayloadLength = 16 + 96 + 32
+
+// randomFernetToken returns a random token shaped like a Fernet token
+// issued at the given time: a version byte and timestamp followed by
+// opaque data, encoded as URL-safe base64 without padding, as Keystone
+// does.
+func randomFernetToken(now time.Time) string {
+	raw := make([]byte, 1+8+fernetPayloadLength)
+	raw[0] = 0x80
+	binary.BigEndian.PutUint64(raw[1:9], uint64(now.Unix()))
+	n, err := io.ReadFull(randReader, raw[9:])
+	if err != nil {
+		panic(fmt.Sprintf(
+			"failed to read %d random bytes (read %d bytes): %s",
+			fernetPayloadLength, n, err.Error()))
+	}
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(raw), "=")
+}
+
+// isJSON reports whether contentType, the value of a Content-Type
+// header, denotes JSON. Case and any parameters, such as the charset,
+// are ignored.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// baseURL returns endpointURL without its version segment and anything
+// following it, keeping the trailing slash. URLs without a version
+// segment are returned unchanged.
+func baseURL(endpointURL string) string {
+	u, err := url.Parse(endpointURL)
+	if err != nil {
+		return endpointURL
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		if goose.APIVersionPattern.MatchString(segment) {
+			u.Path = strings.Join(segments[:i], "/") + "/"
+			u.RawPath = ""
+			return u.String()
+		}
+	}
+	return endpointURL
+}
+
+// unversionEndpoints replaces the URLs of the endpoints of the service
+// in catalog with the given type by their base URLs.
+func unversionEndpoints(catalog []Service, serviceType string) {
+	for _, service := range catalog {
+		if service.Type != serviceType {
+			continue
+		}
+		for i, ep := range service.Endpoints {
+			ep.AdminURL = baseURL(ep.AdminURL)
+			ep.InternalURL = baseURL(ep.InternalURL)
+			ep.PublicURL = baseURL(ep.PublicURL)
+			service.Endpoints[i] = ep
+		}
+	}
+}
+
+// addService adds service to a catalog. As in Keystone, a service has
+// a single catalog entry, so adding the endpoints of a service which
+// is already in the catalog, such as those of another region, merges
+// them into its existing entry. An endpoint replaces any the service
+// already has in the same region.
+func addService(catalog []Service, service Service) []Service {
+	for i, existing := range catalog {
+		if existing.Name != service.Name || existing.Type != service.Type {
+			continue
+		}
+		endpoints := append([]Endpoint(nil), existing.Endpoints...)
+	next:
+		for _, ep := range service.Endpoints {
+			for j := range endpoints {
+				if endpoints[j].Region == ep.Region {
+					endpoints[j] = ep
+					continue next
+				}
+			}
+			endpoints = append(endpoints, ep)
+		}
+		catalog[i].Endpoints = endpoints
+		return catalog
+	}
+	return append(catalog, service)
+}
+
+// setEndpoints replaces the endpoints of the service in catalog with
+// the given type, adding a service named after the type if there is
+// none.
+func setEndpoints(catalog []Service, serviceType string, endpoints []Endpoint) []Service {
+	for i, service := range catalog {
+		if service.Type == serviceType {
+			catalog[i].Endpoints = endpoints
+			return catalog
+		}
+	}
+	return append(catalog, Service{Name: serviceType, Type: serviceType, Endpoints: endpoints})
+}
+
+// cleanPathPrefix returns prefix, a path under which a service is
+// mounted, with a leading slash and without a trailing one, so that
+// the root gives "".
+func cleanPathPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// mountHandler registers h with mux for the pattern under the given
+// path prefix. The prefix is stripped from the requests h is given, so
+// that its handlers see the same paths wherever it is mounted.
+func mountHandler(mux *http.ServeMux, prefix, pattern string, h http.Handler) {
+	prefix = cleanPathPrefix(prefix)
+	if prefix == "" {
+		mux.Handle(pattern, h)
+		return
+	}
+	mux.Handle(prefix+pattern, http.StripPrefix(prefix, h))
+}
@@ -3,9 +3,13 @@ package identityservice
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"strings"
 	"testing/iotest"
+	"time"
 
 	gc "gopkg.in/check.v1"
 )
@@ -19,6 +23,12 @@ func (s *UtilSuite) TestRandomHexTokenHasLength(c *gc.C) {
 	c.Assert(val, gc.HasLen, 32)
 }
 
+func (s *UtilSuite) TestRandomHexTokenLength(c *gc.C) {
+	defer func(old int) { TokenLength = old }(TokenLength)
+	TokenLength = 4
+	c.Assert(randomHexToken(), gc.HasLen, 8)
+}
+
 func (s *UtilSuite) TestRandomHexTokenIsHex(c *gc.C) {
 	val := randomHexToken()
 	for i, b := range val {
@@ -33,6 +43,18 @@ func (s *UtilSuite) TestRandomHexTokenIsHex(c *gc.C) {
 	}
 }
 
+func (s *UtilSuite) TestRandomFernetToken(c *gc.C) {
+	now := time.Unix(1500000000, 0)
+	val := randomFernetToken(now)
+	c.Assert(strings.ContainsAny(val, "+/="), gc.Equals, false, gc.Commentf("%q", val))
+	raw, err := base64.URLEncoding.DecodeString(val + strings.Repeat("=", (4-len(val)%4)%4))
+	c.Assert(err, gc.IsNil)
+	c.Assert(raw, gc.HasLen, 1+8+fernetPayloadLength)
+	c.Assert(raw[0], gc.Equals, byte(0x80))
+	c.Assert(binary.BigEndian.Uint64(raw[1:9]), gc.Equals, uint64(now.Unix()))
+	c.Assert(randomFernetToken(now), gc.Not(gc.Equals), val)
+}
+
 func (s *UtilSuite) TestDefaultReader(c *gc.C) {
 	raw := make([]byte, 6)
 	c.Assert(string(raw), gc.Equals, "\x00\x00\x00\x00\x00\x00")
@@ -104,3 +126,40 @@ func (s *UtilSuite) TestSlowBytes(c *gc.C) {
 	val := randomHexToken()
 	c.Assert(val, gc.HasLen, 32)
 }
+
+func (s *UtilSuite) TestIsJSON(c *gc.C) {
+	for _, t := range []struct {
+		contentType string
+		isJSON      bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=UTF-8", true},
+		{"Application/JSON", true},
+		{"text/plain", false},
+		{"application/jsonp", false},
+		{"", false},
+	} {
+		c.Check(isJSON(t.contentType), gc.Equals, t.isJSON, gc.Commentf("content type %q", t.contentType))
+	}
+}
+
+func (s *UtilSuite) TestHasRole(c *gc.C) {
+	user := &UserInfo{}
+	c.Check(user.HasRole("Member"), gc.Equals, true)
+	c.Check(user.HasRole("admin"), gc.Equals, false)
+	user.Roles = []RoleResponse{{Id: "1", Name: "admin"}}
+	c.Check(user.HasRole("Member"), gc.Equals, false)
+	c.Check(user.HasRole("admin"), gc.Equals, true)
+}
+
+func (s *UtilSuite) TestHasRoleInTenant(c *gc.C) {
+	user := &UserInfo{
+		TenantId: "1",
+		Roles:    []RoleResponse{{Id: "1", Name: "admin", TenantId: "2"}},
+	}
+	c.Check(user.HasRole("admin"), gc.Equals, false)
+	c.Check(user.HasRole("Member"), gc.Equals, true)
+	user.TenantId = "2"
+	c.Check(user.HasRole("admin"), gc.Equals, true)
+	c.Check(user.HasRole("Member"), gc.Equals, false)
+}
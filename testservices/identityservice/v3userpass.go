@@ -0,0 +1,611 @@
+package identityservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/goose.v1/testservices/hook"
+)
+
+// Implement the v3 User Pass form of identity (Keystone)
+
+type V3Domain struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// V3DomainResponse describes a domain listed by GET /v3/domains.
+type V3DomainResponse struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+type V3DomainsResponse struct {
+	Domains []V3DomainResponse `json:"domains"`
+}
+
+type V3UserPassRequest struct {
+	Auth struct {
+		Identity struct {
+			Methods  []string `json:"methods"`
+			Password struct {
+				User struct {
+					Id       string   `json:"id,omitempty"`
+					Name     string   `json:"name"`
+					Password string   `json:"password"`
+					Domain   V3Domain `json:"domain"`
+				} `json:"user"`
+			} `json:"password"`
+			// ApplicationCredential is set instead of Password
+			// when authenticating with an application
+			// credential.
+			ApplicationCredential *struct {
+				Id     string `json:"id"`
+				Secret string `json:"secret"`
+			} `json:"application_credential,omitempty"`
+		} `json:"identity"`
+		Scope struct {
+			Project *struct {
+				Id     string   `json:"id,omitempty"`
+				Name   string   `json:"name,omitempty"`
+				Domain V3Domain `json:"domain"`
+			} `json:"project,omitempty"`
+			Domain *V3Domain `json:"domain,omitempty"`
+		} `json:"scope"`
+	} `json:"auth"`
+}
+
+type V3Endpoint struct {
+	Id        string `json:"id"`
+	Interface string `json:"interface"`
+	Region    string `json:"region"`
+	RegionId  string `json:"region_id"`
+	URL       string `json:"url"`
+}
+
+type V3Service struct {
+	Id        string       `json:"id"`
+	Name      string       `json:"name"`
+	Type      string       `json:"type"`
+	Endpoints []V3Endpoint `json:"endpoints"`
+}
+
+type V3ProjectResponse struct {
+	Id     string   `json:"id"`
+	Name   string   `json:"name"`
+	Domain V3Domain `json:"domain"`
+}
+
+type V3UserResponse struct {
+	Id         string                `json:"id"`
+	Name       string                `json:"name"`
+	Domain     V3Domain              `json:"domain"`
+	Federation *V3FederationResponse `json:"OS-FEDERATION,omitempty"`
+}
+
+// V3FederationResponse describes how a federated user authenticated.
+type V3FederationResponse struct {
+	IdentityProvider V3Ref   `json:"identity_provider"`
+	Protocol         V3Ref   `json:"protocol"`
+	Groups           []V3Ref `json:"groups"`
+}
+
+// V3Ref refers to an entity by id.
+type V3Ref struct {
+	Id string `json:"id"`
+}
+
+type V3RoleResponse struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type V3TokenResponse struct {
+	Token struct {
+		ExpiresAt string             `json:"expires_at"`
+		IssuedAt  string             `json:"issued_at"`
+		Methods   []string           `json:"methods"`
+		Catalog   []V3Service        `json:"catalog"`
+		Project   *V3ProjectResponse `json:"project,omitempty"`
+		Domain    *V3Domain          `json:"domain,omitempty"`
+		User      V3UserResponse     `json:"user"`
+		Roles     []V3RoleResponse   `json:"roles"`
+	} `json:"token"`
+}
+
+// The domain Keystone creates out of the box, and which users are
+// assumed to belong to.
+var defaultDomain = V3Domain{Id: "default", Name: "Default"}
+
+// federatedDomain is the domain Keystone reports federated users as
+// belonging to.
+var federatedDomain = V3Domain{Id: "Federated", Name: "Federated"}
+
+type V3UserPass struct {
+	hook.TestService
+	Users
+	services []Service
+	// PathPrefix, if set, is the path under which SetupHTTP mounts
+	// the service, as for UserPass.
+	PathPrefix string
+	// domains maps the ids of the registered domains to their names.
+	domains      map[string]string
+	nextDomainId int
+	// appCredentials holds the application credentials added with
+	// AddAppCredential, keyed by id.
+	appCredentials map[string]appCredential
+	// federatedTokens maps the assertions added with
+	// AddFederatedToken to the names of the users they authenticate.
+	federatedTokens map[string]string
+}
+
+// appCredential is an application credential, which lets its holder
+// act as a user without knowing their password.
+type appCredential struct {
+	secret string
+	user   string
+}
+
+func NewV3UserPass() *V3UserPass {
+	userpass := &V3UserPass{
+		services: make([]Service, 0),
+		domains:  map[string]string{defaultDomain.Id: defaultDomain.Name},
+	}
+	userpass.users = make(map[string]UserInfo)
+	userpass.tenants = make(map[string]string)
+	userpass.tokens = make(map[string]scopedToken)
+	return userpass
+}
+
+// AddAppCredential registers an application credential with the given
+// id and secret, which authenticates as the named user. Tokens issued
+// for the credential are scoped to the user's project, whatever scope
+// is requested.
+func (u *V3UserPass) AddAppCredential(id, secret, user string) error {
+	if _, ok := u.users[user]; !ok {
+		return fmt.Errorf("No such user %q", user)
+	}
+	if u.appCredentials == nil {
+		u.appCredentials = make(map[string]appCredential)
+	}
+	u.appCredentials[id] = appCredential{secret: secret, user: user}
+	return nil
+}
+
+// authenticateAppCredential returns the details of the user for whom
+// the application credential with the given id and secret acts, or a
+// message explaining why it is not valid.
+func (u *V3UserPass) authenticateAppCredential(id, secret string) (*UserInfo, string) {
+	cred, ok := u.appCredentials[id]
+	if !ok || cred.secret != secret {
+		return nil, notAuthorized
+	}
+	if _, ok := u.users[cred.user]; !ok {
+		return nil, notAuthorized
+	}
+	return u.issueToken(cred.user), ""
+}
+
+// AddFederatedToken registers an assertion, such as the bearer token
+// an OpenID Connect provider issues, which authenticates as the named
+// user when exchanged at the OS-FEDERATION auth endpoint of any
+// identity provider and protocol. The double does not check the
+// assertion, so full SAML or OpenID Connect flows are not needed to
+// test clients using federated authentication. Tokens issued for the
+// assertion are scoped to the user's project.
+func (u *V3UserPass) AddFederatedToken(assertion, user string) error {
+	if _, ok := u.users[user]; !ok {
+		return fmt.Errorf("No such user %q", user)
+	}
+	if u.federatedTokens == nil {
+		u.federatedTokens = make(map[string]string)
+	}
+	u.federatedTokens[assertion] = user
+	return nil
+}
+
+// Reset discards all issued tokens and control hooks. Users, tenants,
+// domains, services, application credentials and federated assertions
+// are preserved.
+func (u *V3UserPass) Reset() {
+	u.Users.Reset()
+	u.ControlHooks = nil
+	u.ClearResponseDelays()
+	u.ClearResponseHeaders()
+}
+
+func (u *V3UserPass) RegisterServiceProvider(name, serviceType string, serviceProvider ServiceProvider) {
+	service := Service{name, serviceType, serviceProvider.Endpoints()}
+	u.AddService(service)
+}
+
+func (u *V3UserPass) AddService(service Service) {
+	u.services = addService(u.services, service)
+}
+
+// SetEndpoints replaces the endpoints of the registered service with
+// the given type, as UserPass.SetEndpoints does.
+func (u *V3UserPass) SetEndpoints(serviceType string, endpoints []Endpoint) {
+	u.services = setEndpoints(u.services, serviceType, endpoints)
+}
+
+// SetUnversionedEndpoints lists the endpoints of the registered service
+// with the given type by their base URLs, as
+// UserPass.SetUnversionedEndpoints does.
+func (u *V3UserPass) SetUnversionedEndpoints(serviceType string) {
+	unversionEndpoints(u.services, serviceType)
+}
+
+// ReturnFailure writes an error response. The v3 error envelope is
+// the same as the v2 one.
+func (u *V3UserPass) ReturnFailure(w http.ResponseWriter, status int, message string) {
+	e := ErrorWrapper{
+		Error: ErrorResponse{
+			Message: message,
+			Code:    status,
+			Title:   http.StatusText(status),
+		},
+	}
+	if content, err := json.Marshal(e); err != nil {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(internalError)))
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(internalError)
+	} else {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		w.WriteHeader(status)
+		w.Write(content)
+	}
+}
+
+// AddDomain registers a domain with the given name, to which tokens
+// may then be scoped, and returns its id. If a domain with the name is
+// already registered, its id is returned.
+func (u *V3UserPass) AddDomain(name string) string {
+	for id, existing := range u.domains {
+		if existing == name {
+			return id
+		}
+	}
+	u.nextDomainId++
+	id := strconv.Itoa(u.nextDomainId)
+	u.domains[id] = name
+	return id
+}
+
+// handleDomains handles GET /v3/domains, which lists the registered
+// domains, sorted by name.
+func (u *V3UserPass) handleDomains(w http.ResponseWriter, r *http.Request) {
+	setHeaders(w)
+	if r.Method != "GET" {
+		writeMethodNotAllowed(w, r.Method, "GET")
+		return
+	}
+	if _, err := u.FindUser(r.Header.Get("X-Auth-Token")); err != nil {
+		u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+		return
+	}
+	res := V3DomainsResponse{Domains: []V3DomainResponse{}}
+	for id, name := range u.domains {
+		res.Domains = append(res.Domains, V3DomainResponse{
+			Id:      id,
+			Name:    name,
+			Enabled: true,
+		})
+	}
+	sort.Sort(domainsByName(res.Domains))
+	content, err := json.Marshal(res)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, content)
+}
+
+type domainsByName []V3DomainResponse
+
+func (d domainsByName) Len() int           { return len(d) }
+func (d domainsByName) Less(i, j int) bool { return d[i].Name < d[j].Name }
+func (d domainsByName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// findDomain returns the registered domain referenced by d, matching
+// on id if one is given and on name otherwise. An empty reference
+// refers to the default domain.
+func (u *V3UserPass) findDomain(d V3Domain) (V3Domain, bool) {
+	if d.Id == "" && d.Name == "" {
+		return defaultDomain, true
+	}
+	for id, name := range u.domains {
+		if d.Id == id || (d.Id == "" && d.Name == name) {
+			return V3Domain{Id: id, Name: name}, true
+		}
+	}
+	return V3Domain{}, false
+}
+
+func (u *V3UserPass) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req V3UserPassRequest
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "HEAD" {
+		u.handleCheckToken(w, r)
+		return
+	}
+	if r.Method == "DELETE" {
+		u.handleRevokeToken(w, r)
+		return
+	}
+	if r.Method != "POST" {
+		writeMethodNotAllowed(w, r.Method, "POST", "HEAD", "DELETE")
+		return
+	}
+	if !isJSON(r.Header.Get("Content-Type")) {
+		u.ReturnFailure(w, http.StatusBadRequest, notJSON)
+		return
+	}
+	if content, err := ioutil.ReadAll(r.Body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else {
+		if err := json.Unmarshal(content, &req); err != nil {
+			u.ReturnFailure(w, http.StatusBadRequest, notJSON)
+			return
+		}
+	}
+	identity := req.Auth.Identity
+	var userInfo *UserInfo
+	var errmsg string
+	if identity.ApplicationCredential != nil {
+		cred := identity.ApplicationCredential
+		userInfo, errmsg = u.authenticateAppCredential(cred.Id, cred.Secret)
+	} else {
+		user := identity.Password.User
+		if _, ok := u.findDomain(user.Domain); !ok {
+			u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+			return
+		}
+		errmsg = u.checkPassword(user.Name, user.Password)
+		if errmsg == "" {
+			// The whole scope is checked before any token is issued,
+			// so a rejected request leaves none behind.
+			tenantId, ok := u.scopeTenantId(user.Name, &req)
+			if !ok {
+				u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+				return
+			}
+			userInfo = u.issueScopedToken(user.Name, tenantId)
+		}
+	}
+	if errmsg != "" {
+		u.ReturnFailure(w, http.StatusUnauthorized, errmsg)
+		return
+	}
+	res, err := u.generateTokenResponse(userInfo)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	scope := req.Auth.Scope
+	switch {
+	case identity.ApplicationCredential != nil:
+		// Application credentials are bound to a project.
+		res.Token.Methods = []string{"application_credential"}
+		res.Token.Project = &V3ProjectResponse{
+			Id:     userInfo.TenantId,
+			Name:   u.tenants[userInfo.TenantId],
+			Domain: defaultDomain,
+		}
+	case scope.Project != nil:
+		domain, _ := u.findDomain(scope.Project.Domain)
+		res.Token.Project = &V3ProjectResponse{
+			Id:     userInfo.TenantId,
+			Name:   u.tenants[userInfo.TenantId],
+			Domain: domain,
+		}
+	case scope.Domain != nil:
+		domain, _ := u.findDomain(*scope.Domain)
+		res.Token.Domain = &domain
+	}
+	content, err := json.Marshal(res)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	// Unlike v2, the token is returned in a header rather than the body.
+	w.Header().Set("X-Subject-Token", userInfo.Token)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(content)
+}
+
+// scopeTenantId returns the id of the tenant to which a token issued
+// to the named user for the given request is bound, reporting whether
+// the request's scope is valid for the user. Domain-scoped tokens are
+// bound to no tenant, and unscoped ones to the user's own tenant.
+func (u *V3UserPass) scopeTenantId(username string, req *V3UserPassRequest) (string, bool) {
+	scope := req.Auth.Scope
+	switch {
+	case scope.Project != nil:
+		if _, ok := u.findDomain(scope.Project.Domain); !ok {
+			return "", false
+		}
+		return u.projectTenantId(username, scope.Project.Id, scope.Project.Name)
+	case scope.Domain != nil:
+		_, ok := u.findDomain(*scope.Domain)
+		return "", ok
+	}
+	return u.users[username].TenantId, true
+}
+
+// projectTenantId returns the id of the tenant named by a project
+// scope, given by id or, failing that, by name, reporting whether the
+// named user is a member of it.
+func (u *V3UserPass) projectTenantId(username, id, name string) (string, bool) {
+	if id == "" {
+		return u.memberTenantId(username, name)
+	}
+	userInfo := u.users[username]
+	for _, tenantId := range userInfo.tenantIds() {
+		if tenantId == id {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// handleCheckToken handles HEAD /v3/auth/tokens, which checks the
+// token in the X-Subject-Token header on behalf of the holder of the
+// one in X-Auth-Token.
+func (u *V3UserPass) handleCheckToken(w http.ResponseWriter, r *http.Request) {
+	subjectToken := r.Header.Get("X-Subject-Token")
+	status := u.checkTokenStatus(r.Header.Get("X-Auth-Token"), subjectToken)
+	if status == http.StatusOK {
+		w.Header().Set("X-Subject-Token", subjectToken)
+	}
+	writeStatus(w, status)
+}
+
+// handleRevokeToken handles a DELETE request, which revokes the token
+// given in the X-Subject-Token header.
+func (u *V3UserPass) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	subjectToken := r.Header.Get("X-Subject-Token")
+	switch status := u.revokeSubjectToken(r.Header.Get("X-Auth-Token"), subjectToken); status {
+	case http.StatusUnauthorized:
+		u.ReturnFailure(w, status, notAuthorized)
+	case http.StatusForbidden:
+		u.ReturnFailure(w, status, revokeForbidden)
+	case http.StatusNotFound:
+		u.ReturnFailure(w, status, fmt.Sprintf("Could not find token: %s.", subjectToken))
+	default:
+		writeStatus(w, status)
+	}
+}
+
+// federationAuthPath matches the path of the endpoint at which
+// federated assertions are exchanged for tokens, capturing the
+// identity provider and protocol.
+var federationAuthPath = regexp.MustCompile(`^/v3/OS-FEDERATION/identity_providers/([^/]+)/protocols/([^/]+)/auth$`)
+
+// handleFederatedAuth handles requests to exchange an assertion, given
+// as a bearer token in the Authorization header, for a token. Keystone
+// accepts both GET and POST, as identity providers redirect browsers
+// with either.
+func (u *V3UserPass) handleFederatedAuth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	match := federationAuthPath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		u.ReturnFailure(w, http.StatusNotFound, "The resource could not be found.")
+		return
+	}
+	if r.Method != "GET" && r.Method != "POST" {
+		writeMethodNotAllowed(w, r.Method, "GET", "POST")
+		return
+	}
+	idp, protocol := match[1], match[2]
+	var user string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		user = u.federatedTokens[strings.TrimPrefix(auth, "Bearer ")]
+	}
+	if _, ok := u.users[user]; !ok {
+		u.ReturnFailure(w, http.StatusUnauthorized, notAuthorized)
+		return
+	}
+	userInfo := u.issueToken(user)
+	res, err := u.generateTokenResponse(userInfo)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	res.Token.Methods = []string{protocol}
+	res.Token.User.Domain = federatedDomain
+	res.Token.User.Federation = &V3FederationResponse{
+		IdentityProvider: V3Ref{Id: idp},
+		Protocol:         V3Ref{Id: protocol},
+		Groups:           []V3Ref{},
+	}
+	res.Token.Project = &V3ProjectResponse{
+		Id:     userInfo.TenantId,
+		Name:   u.tenants[userInfo.TenantId],
+		Domain: defaultDomain,
+	}
+	content, err := json.Marshal(res)
+	if err != nil {
+		u.ReturnFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("X-Subject-Token", userInfo.Token)
+	w.WriteHeader(http.StatusCreated)
+	w.Write(content)
+}
+
+// v3Catalog converts the registered v2 style services into the v3
+// catalog format, with an endpoint for each interface.
+func (u *V3UserPass) v3Catalog() []V3Service {
+	catalog := make([]V3Service, 0, len(u.services))
+	for _, service := range u.services {
+		v3service := V3Service{
+			Id:        service.Name,
+			Name:      service.Name,
+			Type:      service.Type,
+			Endpoints: []V3Endpoint{},
+		}
+		for _, ep := range service.Endpoints {
+			urls := []struct{ iface, url string }{
+				{"public", ep.PublicURL},
+				{"internal", ep.InternalURL},
+				{"admin", ep.AdminURL},
+			}
+			for _, e := range urls {
+				if e.url == "" {
+					continue
+				}
+				v3service.Endpoints = append(v3service.Endpoints, V3Endpoint{
+					Id:        service.Name + "-" + e.iface,
+					Interface: e.iface,
+					Region:    ep.Region,
+					RegionId:  ep.Region,
+					URL:       e.url,
+				})
+			}
+		}
+		catalog = append(catalog, v3service)
+	}
+	return catalog
+}
+
+func (u *V3UserPass) generateTokenResponse(userInfo *UserInfo) (*V3TokenResponse, error) {
+	res := V3TokenResponse{}
+	now := u.now().UTC()
+	res.Token.IssuedAt = now.Format(time.RFC3339)
+	res.Token.ExpiresAt = userInfo.Expires.UTC().Format(time.RFC3339)
+	res.Token.Methods = []string{"password"}
+	res.Token.Catalog = u.v3Catalog()
+	res.Token.User = V3UserResponse{
+		Id:     userInfo.Id,
+		Name:   userInfo.Name,
+		Domain: defaultDomain,
+	}
+	res.Token.Roles = []V3RoleResponse{{Id: "2", Name: "Member"}}
+	if len(userInfo.Roles) > 0 {
+		res.Token.Roles = make([]V3RoleResponse, len(userInfo.Roles))
+		for i, role := range userInfo.Roles {
+			res.Token.Roles[i] = V3RoleResponse{Id: role.Id, Name: role.Name}
+		}
+	}
+	if err := u.ProcessControlHook("authorisation", u, &res, userInfo); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// setupHTTP attaches all the needed handlers to provide the HTTP API.
+func (u *V3UserPass) SetupHTTP(mux *http.ServeMux) {
+	mountHandler(mux, u.PathPrefix, "/v3/auth/tokens", controlled(&u.TestService, u))
+	mountHandler(mux, u.PathPrefix, "/v3/domains", controlled(&u.TestService, http.HandlerFunc(u.handleDomains)))
+	mountHandler(mux, u.PathPrefix, "/v3/OS-FEDERATION/", controlled(&u.TestService, http.HandlerFunc(u.handleFederatedAuth)))
+}
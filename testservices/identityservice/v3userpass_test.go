@@ -0,0 +1,497 @@
+package identityservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/testing/httpsuite"
+)
+
+type V3UserPassSuite struct {
+	httpsuite.HTTPSuite
+}
+
+var _ = gc.Suite(&V3UserPassSuite{})
+
+func makeV3UserPass(user, secret string) (identity *V3UserPass) {
+	identity = NewV3UserPass()
+	// Ensure that it conforms to the interface
+	var _ IdentityService = identity
+	var _ Resetter = identity
+	if user != "" {
+		identity.AddUser(user, secret, "tenant")
+	}
+	return
+}
+
+func (s *V3UserPassSuite) setupUserPassWithServices(user, secret string, services []Service) {
+	var identity *V3UserPass
+	identity = makeV3UserPass(user, secret)
+	for _, service := range services {
+		identity.AddService(service)
+	}
+	identity.SetupHTTP(s.Mux)
+	return
+}
+
+var v3AuthTemplate = `{
+    "auth": {
+        "identity": {
+            "methods": ["password"],
+            "password": {
+                "user": {
+                    "name": "%s",
+                    "domain": {"name": "%s"},
+                    "password": "%s"
+                }
+            }
+        },
+        "scope": %s
+    }
+}`
+
+func v3UserPassAuthRequest(URL, user, domain, key, scope string) (*http.Response, error) {
+	client := http.DefaultClient
+	body := strings.NewReader(fmt.Sprintf(v3AuthTemplate, user, domain, key, scope))
+	request, err := http.NewRequest("POST", URL+"/v3/auth/tokens", body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return client.Do(request)
+}
+
+func (s *V3UserPassSuite) TestNotJSON(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", nil)
+	body := strings.NewReader(fmt.Sprintf(v3AuthTemplate, "user", "Default", "secret", "{}"))
+	request, err := http.NewRequest("POST", s.Server.URL+"/v3/auth/tokens", body)
+	c.Assert(err, gc.IsNil)
+	res, err := http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusBadRequest, notJSON)
+}
+
+func (s *V3UserPassSuite) TestPathPrefix(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.PathPrefix = "identity"
+	identity.SetupHTTP(s.Mux)
+	scope := `{"project": {"name": "tenant", "domain": {"id": "default"}}}`
+	res, err := v3UserPassAuthRequest(s.Server.URL+"/identity", "user", "Default", "secret", scope)
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusCreated)
+	res, err = v3UserPassAuthRequest(s.Server.URL, "user", "Default", "secret", scope)
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusNotFound)
+}
+
+func (s *V3UserPassSuite) TestBadPassword(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", nil)
+	res, err := v3UserPassAuthRequest(s.Server.URL, "user", "Default", "not-secret", "{}")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, invalidUser)
+}
+
+func (s *V3UserPassSuite) TestUnknownDomain(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", nil)
+	res, err := v3UserPassAuthRequest(s.Server.URL, "user", "no-such-domain", "secret", "{}")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *V3UserPassSuite) TestUnknownScopeDomain(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", nil)
+	scope := `{"domain": {"name": "no-such-domain"}}`
+	res, err := v3UserPassAuthRequest(s.Server.URL, "user", "Default", "secret", scope)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+func (s *V3UserPassSuite) authenticate(c *gc.C, scope string) (*http.Response, V3TokenResponse) {
+	res, err := v3UserPassAuthRequest(s.Server.URL, "user", "Default", "secret", scope)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusCreated)
+	c.Check(res.Header.Get("Content-Type"), gc.Equals, "application/json")
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response V3TokenResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	return res, response
+}
+
+func (s *V3UserPassSuite) TestProjectScoped(c *gc.C) {
+	computeURL := "http://testing.invalid/compute"
+	s.setupUserPassWithServices("user", "secret", []Service{
+		{"nova", "compute", []Endpoint{
+			{PublicURL: computeURL, Region: "RegionOne"},
+		}}})
+	scope := `{"project": {"name": "tenant", "domain": {"id": "default"}}}`
+	res, response := s.authenticate(c, scope)
+	c.Check(res.Header.Get("X-Subject-Token"), gc.Not(gc.Equals), "")
+	c.Assert(response.Token.Project, gc.NotNil)
+	c.Check(response.Token.Project.Name, gc.Equals, "tenant")
+	c.Check(response.Token.Project.Domain, gc.Equals, defaultDomain)
+	c.Check(response.Token.Domain, gc.IsNil)
+	c.Assert(response.Token.Catalog, gc.HasLen, 1)
+	service := response.Token.Catalog[0]
+	c.Check(service.Type, gc.Equals, "compute")
+	c.Assert(service.Endpoints, gc.HasLen, 1)
+	c.Check(service.Endpoints[0].Interface, gc.Equals, "public")
+	c.Check(service.Endpoints[0].Region, gc.Equals, "RegionOne")
+	c.Check(service.Endpoints[0].URL, gc.Equals, computeURL)
+}
+
+func (s *V3UserPassSuite) TestProjectScopedToOtherProject(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	err := identity.AddUserTenant("user", "42", "other-tenant", []RoleResponse{{Id: "2", Name: "Member"}})
+	c.Assert(err, gc.IsNil)
+	identity.SetupHTTP(s.Mux)
+	for _, scope := range []string{
+		`{"project": {"name": "other-tenant", "domain": {"id": "default"}}}`,
+		`{"project": {"id": "42"}}`,
+	} {
+		c.Logf("scope %s", scope)
+		res, response := s.authenticate(c, scope)
+		c.Assert(response.Token.Project, gc.NotNil)
+		c.Check(response.Token.Project.Id, gc.Equals, "42")
+		c.Check(response.Token.Project.Name, gc.Equals, "other-tenant")
+		userInfo, err := identity.FindUser(res.Header.Get("X-Subject-Token"))
+		c.Assert(err, gc.IsNil)
+		c.Check(userInfo.TenantId, gc.Equals, "42")
+	}
+}
+
+func (s *V3UserPassSuite) TestProjectScopedNotMember(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.AddUser("other", "secret", "other-tenant")
+	identity.SetupHTTP(s.Mux)
+	for _, scope := range []string{
+		`{"project": {"name": "other-tenant", "domain": {"id": "default"}}}`,
+		`{"project": {"name": "no-such-tenant", "domain": {"id": "default"}}}`,
+		`{"project": {"id": "no-such-id"}}`,
+	} {
+		c.Logf("scope %s", scope)
+		res, err := v3UserPassAuthRequest(s.Server.URL, "user", "Default", "secret", scope)
+		c.Assert(err, gc.IsNil)
+		CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+		res.Body.Close()
+	}
+}
+
+func (s *V3UserPassSuite) TestNewerServiceTypes(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.AddService(Service{"heat", ServiceTypeOrchestration, []Endpoint{
+		{PublicURL: "http://testing.invalid/heat", Region: "RegionOne"},
+	}})
+	identity.SetEndpoints(ServiceTypeVolumeV3, []Endpoint{
+		{PublicURL: "http://testing.invalid/volume", Region: "RegionOne"},
+	})
+	identity.SetEndpoints(ServiceTypeDNS, []Endpoint{
+		{PublicURL: "http://testing.invalid/dns", Region: "RegionOne"},
+	})
+	identity.SetupHTTP(s.Mux)
+	scope := `{"project": {"name": "tenant", "domain": {"id": "default"}}}`
+	_, response := s.authenticate(c, scope)
+	var types []string
+	for _, service := range response.Token.Catalog {
+		types = append(types, service.Type)
+	}
+	c.Assert(types, gc.DeepEquals, []string{"orchestration", "volumev3", "dns"})
+	c.Assert(response.Token.Catalog[2].Endpoints, gc.HasLen, 1)
+	c.Assert(response.Token.Catalog[2].Endpoints[0].URL, gc.Equals, "http://testing.invalid/dns")
+}
+
+func (s *V3UserPassSuite) TestDomainScoped(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", nil)
+	scope := `{"domain": {"name": "Default"}}`
+	res, response := s.authenticate(c, scope)
+	c.Check(res.Header.Get("X-Subject-Token"), gc.Not(gc.Equals), "")
+	c.Check(response.Token.Project, gc.IsNil)
+	c.Assert(response.Token.Domain, gc.NotNil)
+	c.Check(*response.Token.Domain, gc.Equals, defaultDomain)
+}
+
+func (s *V3UserPassSuite) TestDomainScopedTokenHasNoTenant(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	res, _ := s.authenticate(c, `{"domain": {"name": "Default"}}`)
+	userInfo, err := identity.FindUser(res.Header.Get("X-Subject-Token"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(userInfo.TenantId, gc.Equals, "")
+}
+
+func (s *V3UserPassSuite) TestRejectedScopeIssuesNoToken(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	tokens := len(identity.tokens)
+	for _, scope := range []string{
+		`{"domain": {"name": "no-such-domain"}}`,
+		`{"project": {"name": "tenant", "domain": {"name": "no-such-domain"}}}`,
+		`{"project": {"name": "no-such-project", "domain": {"id": "default"}}}`,
+	} {
+		res, err := v3UserPassAuthRequest(s.Server.URL, "user", "Default", "secret", scope)
+		c.Assert(err, gc.IsNil)
+		res.Body.Close()
+		c.Check(res.StatusCode, gc.Equals, http.StatusUnauthorized)
+	}
+	c.Assert(identity.tokens, gc.HasLen, tokens)
+}
+
+func (s *V3UserPassSuite) TestAddedDomainScoped(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	id := identity.AddDomain("admin-domain")
+	c.Assert(identity.AddDomain("admin-domain"), gc.Equals, id)
+	identity.SetupHTTP(s.Mux)
+	for _, scope := range []string{
+		`{"domain": {"name": "admin-domain"}}`,
+		`{"domain": {"id": "` + id + `"}}`,
+	} {
+		_, response := s.authenticate(c, scope)
+		c.Check(response.Token.Project, gc.IsNil)
+		c.Assert(response.Token.Domain, gc.NotNil)
+		c.Check(*response.Token.Domain, gc.Equals, V3Domain{Id: id, Name: "admin-domain"})
+	}
+}
+
+func (s *V3UserPassSuite) listDomains(c *gc.C, token string) *http.Response {
+	request, err := http.NewRequest("GET", s.Server.URL+"/v3/domains", nil)
+	c.Assert(err, gc.IsNil)
+	request.Header.Set("X-Auth-Token", token)
+	res, err := http.DefaultClient.Do(request)
+	c.Assert(err, gc.IsNil)
+	return res
+}
+
+func (s *V3UserPassSuite) TestListDomains(c *gc.C) {
+	identity := makeV3UserPass("", "")
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	id := identity.AddDomain("admin-domain")
+	identity.SetupHTTP(s.Mux)
+	res := s.listDomains(c, userInfo.Token)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusOK)
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	var response V3DomainsResponse
+	err = json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Assert(response.Domains, gc.DeepEquals, []V3DomainResponse{
+		{Id: "default", Name: "Default", Enabled: true},
+		{Id: id, Name: "admin-domain", Enabled: true},
+	})
+}
+
+func (s *V3UserPassSuite) TestListDomainsUnauthorized(c *gc.C) {
+	s.setupUserPassWithServices("user", "secret", nil)
+	res := s.listDomains(c, "bad-token")
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
+
+var v3AppCredentialTemplate = `{
+    "auth": {
+        "identity": {
+            "methods": ["application_credential"],
+            "application_credential": {
+                "id": "%s",
+                "secret": "%s"
+            }
+        }
+    }
+}`
+
+func v3AppCredentialAuthRequest(URL, id, secret string) (*http.Response, error) {
+	body := strings.NewReader(fmt.Sprintf(v3AppCredentialTemplate, id, secret))
+	request, err := http.NewRequest("POST", URL+"/v3/auth/tokens", body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	return http.DefaultClient.Do(request)
+}
+
+func (s *V3UserPassSuite) TestAppCredential(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	err := identity.AddAppCredential("cred-id", "cred-secret", "user")
+	c.Assert(err, gc.IsNil)
+	res, err := v3AppCredentialAuthRequest(s.Server.URL, "cred-id", "cred-secret")
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	c.Assert(res.StatusCode, gc.Equals, http.StatusCreated)
+	token := res.Header.Get("X-Subject-Token")
+	userInfo, err := identity.FindUser(token)
+	c.Assert(err, gc.IsNil)
+	c.Check(userInfo.Name, gc.Equals, "user")
+	var response V3TokenResponse
+	err = json.NewDecoder(res.Body).Decode(&response)
+	c.Assert(err, gc.IsNil)
+	c.Check(response.Token.Methods, gc.DeepEquals, []string{"application_credential"})
+	c.Check(response.Token.User.Name, gc.Equals, "user")
+	c.Assert(response.Token.Project, gc.NotNil)
+	c.Check(response.Token.Project.Name, gc.Equals, "tenant")
+}
+
+func (s *V3UserPassSuite) TestAppCredentialInvalid(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	err := identity.AddAppCredential("cred-id", "cred-secret", "user")
+	c.Assert(err, gc.IsNil)
+	for _, cred := range []struct{ id, secret string }{
+		{"cred-id", "wrong"},
+		{"unknown", "cred-secret"},
+		{"user", "secret"},
+	} {
+		res, err := v3AppCredentialAuthRequest(s.Server.URL, cred.id, cred.secret)
+		c.Assert(err, gc.IsNil)
+		CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+		res.Body.Close()
+	}
+}
+
+func (s *V3UserPassSuite) TestAddAppCredentialUnknownUser(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	err := identity.AddAppCredential("cred-id", "cred-secret", "nobody")
+	c.Assert(err, gc.ErrorMatches, `No such user "nobody"`)
+}
+
+func federatedAuthRequest(URL, method, assertion string) (*http.Response, error) {
+	path := "/v3/OS-FEDERATION/identity_providers/myidp/protocols/openid/auth"
+	request, err := http.NewRequest(method, URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if assertion != "" {
+		request.Header.Set("Authorization", "Bearer "+assertion)
+	}
+	return http.DefaultClient.Do(request)
+}
+
+func (s *V3UserPassSuite) TestFederatedToken(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	err := identity.AddFederatedToken("assertion", "user")
+	c.Assert(err, gc.IsNil)
+	for _, method := range []string{"GET", "POST"} {
+		c.Logf("method %s", method)
+		res, err := federatedAuthRequest(s.Server.URL, method, "assertion")
+		c.Assert(err, gc.IsNil)
+		defer res.Body.Close()
+		c.Assert(res.StatusCode, gc.Equals, http.StatusCreated)
+		token := res.Header.Get("X-Subject-Token")
+		userInfo, err := identity.FindUser(token)
+		c.Assert(err, gc.IsNil)
+		c.Check(userInfo.Name, gc.Equals, "user")
+		var response V3TokenResponse
+		err = json.NewDecoder(res.Body).Decode(&response)
+		c.Assert(err, gc.IsNil)
+		c.Check(response.Token.Methods, gc.DeepEquals, []string{"openid"})
+		c.Check(response.Token.User.Name, gc.Equals, "user")
+		c.Check(response.Token.User.Domain, gc.Equals, federatedDomain)
+		c.Check(response.Token.User.Federation, gc.DeepEquals, &V3FederationResponse{
+			IdentityProvider: V3Ref{Id: "myidp"},
+			Protocol:         V3Ref{Id: "openid"},
+			Groups:           []V3Ref{},
+		})
+		c.Assert(response.Token.Project, gc.NotNil)
+		c.Check(response.Token.Project.Name, gc.Equals, "tenant")
+	}
+}
+
+func (s *V3UserPassSuite) TestFederatedTokenInvalid(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	err := identity.AddFederatedToken("assertion", "user")
+	c.Assert(err, gc.IsNil)
+	for _, assertion := range []string{"", "unknown", "secret"} {
+		res, err := federatedAuthRequest(s.Server.URL, "POST", assertion)
+		c.Assert(err, gc.IsNil)
+		CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+		res.Body.Close()
+	}
+	res, err := federatedAuthRequest(s.Server.URL, "DELETE", "assertion")
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusMethodNotAllowed)
+	c.Check(res.Header.Get("Allow"), gc.Equals, "GET, POST")
+	res, err = http.Get(s.Server.URL + "/v3/OS-FEDERATION/identity_providers/myidp")
+	c.Assert(err, gc.IsNil)
+	CheckErrorResponse(c, res, http.StatusNotFound, "The resource could not be found.")
+	res.Body.Close()
+	err = identity.AddFederatedToken("assertion", "nobody")
+	c.Assert(err, gc.ErrorMatches, `No such user "nobody"`)
+}
+
+func (s *V3UserPassSuite) TestCheckToken(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	userInfo, err := identity.FindUser(identity.Tokens()["user"])
+	c.Assert(err, gc.IsNil)
+	expired := identity.AddUserWithExpiry("expired", "secret", -time.Minute)
+	for i, t := range []struct {
+		authToken    string
+		subjectToken string
+		status       int
+	}{
+		{userInfo.Token, userInfo.Token, http.StatusOK},
+		{userInfo.Token, "no-such-token", http.StatusNotFound},
+		{userInfo.Token, expired.Token, http.StatusNotFound},
+		{"no-such-token", userInfo.Token, http.StatusUnauthorized},
+	} {
+		c.Logf("test %d: %q checking %q", i, t.authToken, t.subjectToken)
+		request, err := http.NewRequest("HEAD", s.Server.URL+"/v3/auth/tokens", nil)
+		c.Assert(err, gc.IsNil)
+		request.Header.Set("X-Auth-Token", t.authToken)
+		request.Header.Set("X-Subject-Token", t.subjectToken)
+		res, err := http.DefaultClient.Do(request)
+		c.Assert(err, gc.IsNil)
+		res.Body.Close()
+		c.Check(res.StatusCode, gc.Equals, t.status)
+		c.Check(res.Header.Get("Content-Length"), gc.Equals, "0")
+		if t.status == http.StatusOK {
+			c.Check(res.Header.Get("X-Subject-Token"), gc.Equals, t.subjectToken)
+		}
+	}
+}
+
+func (s *V3UserPassSuite) TestDeleteToken(c *gc.C) {
+	identity := makeV3UserPass("user", "secret")
+	identity.SetupHTTP(s.Mux)
+	userInfo, err := identity.FindUser(identity.Tokens()["user"])
+	c.Assert(err, gc.IsNil)
+	revoke := func(subjectToken string) *http.Response {
+		request, err := http.NewRequest("DELETE", s.Server.URL+"/v3/auth/tokens", nil)
+		c.Assert(err, gc.IsNil)
+		request.Header.Set("X-Auth-Token", userInfo.Token)
+		request.Header.Set("X-Subject-Token", subjectToken)
+		res, err := http.DefaultClient.Do(request)
+		c.Assert(err, gc.IsNil)
+		return res
+	}
+	res := revoke("no-such-token")
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusNotFound, "Could not find token: no-such-token.")
+
+	res = revoke(userInfo.Token)
+	res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusNoContent)
+	_, err = identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.NotNil)
+
+	res = revoke(userInfo.Token)
+	defer res.Body.Close()
+	CheckErrorResponse(c, res, http.StatusUnauthorized, notAuthorized)
+}
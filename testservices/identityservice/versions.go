@@ -0,0 +1,132 @@
+package identityservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Implement Keystone version discovery, served from the root of the
+// identity endpoint.
+
+// Version describes an identity API version advertised by version
+// discovery.
+type Version struct {
+	Id      string
+	Status  string
+	Updated string
+	// Path is the root of the version's API on the server. It is
+	// reported as the version's "self" link.
+	Path       string
+	MediaTypes []VersionMediaType
+}
+
+type VersionMediaType struct {
+	Base string `json:"base"`
+	Type string `json:"type"`
+}
+
+type VersionLink struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+type VersionResponse struct {
+	Id         string             `json:"id"`
+	Status     string             `json:"status"`
+	Updated    string             `json:"updated"`
+	Links      []VersionLink      `json:"links"`
+	MediaTypes []VersionMediaType `json:"media-types"`
+}
+
+type VersionsResponse struct {
+	Versions struct {
+		Values []VersionResponse `json:"values"`
+	} `json:"versions"`
+}
+
+// DefaultVersions returns the versions advertised by a new
+// VersionDiscovery: v2.0, served by UserPass and KeyPair, and v3.0,
+// served by V3UserPass.
+func DefaultVersions() []Version {
+	return []Version{{
+		Id:      "v3.0",
+		Status:  "stable",
+		Updated: "2013-03-06T00:00:00Z",
+		Path:    "/v3/",
+		MediaTypes: []VersionMediaType{{
+			Base: "application/json",
+			Type: "application/vnd.openstack.identity-v3+json",
+		}},
+	}, {
+		Id:      "v2.0",
+		Status:  "stable",
+		Updated: "2014-04-17T00:00:00Z",
+		Path:    "/",
+		MediaTypes: []VersionMediaType{{
+			Base: "application/json",
+			Type: "application/vnd.openstack.identity-v2.0+json",
+		}},
+	}}
+}
+
+// VersionDiscovery serves the multiple choices document Keystone
+// returns from its root, which lists the available API versions.
+type VersionDiscovery struct {
+	// Versions holds the advertised versions.
+	Versions []Version
+	// PathPrefix, if set, is the path under which SetupHTTP mounts
+	// the service, as for UserPass. The version paths are relative
+	// to it.
+	PathPrefix string
+}
+
+func NewVersionDiscovery() *VersionDiscovery {
+	return &VersionDiscovery{Versions: DefaultVersions()}
+}
+
+func (v *VersionDiscovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path != "/" {
+		writeFailure(w, http.StatusNotFound, fmt.Sprintf("Could not find %s.", r.URL.Path))
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		writeMethodNotAllowed(w, r.Method, "GET", "HEAD")
+		return
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	var res VersionsResponse
+	res.Versions.Values = []VersionResponse{}
+	for _, version := range v.Versions {
+		res.Versions.Values = append(res.Versions.Values, VersionResponse{
+			Id:      version.Id,
+			Status:  version.Status,
+			Updated: version.Updated,
+			Links: []VersionLink{{
+				Href: scheme + "://" + r.Host + cleanPathPrefix(v.PathPrefix) + "/" + strings.TrimLeft(version.Path, "/"),
+				Rel:  "self",
+			}},
+			MediaTypes: version.MediaTypes,
+		})
+	}
+	content, err := json.Marshal(res)
+	if err != nil {
+		writeFailure(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+	w.WriteHeader(http.StatusMultipleChoices)
+	w.Write(content)
+}
+
+// SetupHTTP attaches the version discovery handler to the root of the
+// given mux, or to PathPrefix if set. It cannot share a root with
+// Legacy, which is also served from the root.
+func (v *VersionDiscovery) SetupHTTP(mux *http.ServeMux) {
+	mountHandler(mux, v.PathPrefix, "/", v)
+}
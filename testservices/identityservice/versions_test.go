@@ -0,0 +1,114 @@
+package identityservice
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/testing/httpsuite"
+)
+
+type VersionDiscoverySuite struct {
+	httpsuite.HTTPSuite
+}
+
+var _ = gc.Suite(&VersionDiscoverySuite{})
+
+func (s *VersionDiscoverySuite) getVersions(c *gc.C, path string) (*http.Response, []byte) {
+	res, err := http.Get(s.Server.URL + path)
+	c.Assert(err, gc.IsNil)
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(res.Body)
+	c.Assert(err, gc.IsNil)
+	return res, content
+}
+
+func (s *VersionDiscoverySuite) TestDefaultVersions(c *gc.C) {
+	NewVersionDiscovery().SetupHTTP(s.Mux)
+	NewUserPass().SetupHTTP(s.Mux)
+	NewV3UserPass().SetupHTTP(s.Mux)
+	res, content := s.getVersions(c, "/")
+	c.Check(res.StatusCode, gc.Equals, http.StatusMultipleChoices)
+	c.Check(res.Header.Get("Content-Type"), gc.Equals, "application/json")
+	var response VersionsResponse
+	err := json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Assert(response.Versions.Values, gc.HasLen, 2)
+	v3, v2 := response.Versions.Values[0], response.Versions.Values[1]
+	c.Check(v3.Id, gc.Equals, "v3.0")
+	c.Check(v3.Status, gc.Equals, "stable")
+	c.Check(v3.Links, gc.DeepEquals, []VersionLink{{Href: s.Server.URL + "/v3/", Rel: "self"}})
+	c.Check(v3.MediaTypes, gc.DeepEquals, []VersionMediaType{{
+		Base: "application/json",
+		Type: "application/vnd.openstack.identity-v3+json",
+	}})
+	c.Check(v2.Id, gc.Equals, "v2.0")
+	c.Check(v2.Links, gc.DeepEquals, []VersionLink{{Href: s.Server.URL + "/", Rel: "self"}})
+}
+
+func (s *VersionDiscoverySuite) TestConfiguredVersions(c *gc.C) {
+	versions := NewVersionDiscovery()
+	versions.Versions = []Version{{
+		Id:     "v3.6",
+		Status: "current",
+		Path:   "identity/v3",
+	}}
+	versions.SetupHTTP(s.Mux)
+	res, content := s.getVersions(c, "/")
+	c.Check(res.StatusCode, gc.Equals, http.StatusMultipleChoices)
+	var response VersionsResponse
+	err := json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Assert(response.Versions.Values, gc.HasLen, 1)
+	version := response.Versions.Values[0]
+	c.Check(version.Id, gc.Equals, "v3.6")
+	c.Check(version.Status, gc.Equals, "current")
+	c.Check(version.Links, gc.DeepEquals, []VersionLink{{Href: s.Server.URL + "/identity/v3", Rel: "self"}})
+}
+
+func (s *VersionDiscoverySuite) TestPathPrefix(c *gc.C) {
+	versions := NewVersionDiscovery()
+	versions.PathPrefix = "/identity"
+	versions.SetupHTTP(s.Mux)
+	res, content := s.getVersions(c, "/identity/")
+	c.Check(res.StatusCode, gc.Equals, http.StatusMultipleChoices)
+	var response VersionsResponse
+	err := json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Assert(response.Versions.Values, gc.HasLen, 2)
+	c.Check(response.Versions.Values[0].Links, gc.DeepEquals, []VersionLink{{Href: s.Server.URL + "/identity/v3/", Rel: "self"}})
+	c.Check(response.Versions.Values[1].Links, gc.DeepEquals, []VersionLink{{Href: s.Server.URL + "/identity/", Rel: "self"}})
+	res, _ = s.getVersions(c, "/")
+	c.Check(res.StatusCode, gc.Equals, http.StatusNotFound)
+}
+
+func (s *VersionDiscoverySuite) TestNoVersions(c *gc.C) {
+	versions := NewVersionDiscovery()
+	versions.Versions = nil
+	versions.SetupHTTP(s.Mux)
+	res, content := s.getVersions(c, "/")
+	c.Check(res.StatusCode, gc.Equals, http.StatusMultipleChoices)
+	c.Check(string(content), gc.Equals, `{"versions":{"values":[]}}`)
+}
+
+func (s *VersionDiscoverySuite) TestNotFound(c *gc.C) {
+	NewVersionDiscovery().SetupHTTP(s.Mux)
+	res, content := s.getVersions(c, "/v2.1/")
+	c.Check(res.StatusCode, gc.Equals, http.StatusNotFound)
+	var response ErrorWrapper
+	err := json.Unmarshal(content, &response)
+	c.Assert(err, gc.IsNil)
+	c.Check(response.Error.Code, gc.Equals, http.StatusNotFound)
+}
+
+func (s *VersionDiscoverySuite) TestMethodNotAllowed(c *gc.C) {
+	NewVersionDiscovery().SetupHTTP(s.Mux)
+	res, err := http.Post(s.Server.URL+"/", "application/json", strings.NewReader("{}"))
+	c.Assert(err, gc.IsNil)
+	res.Body.Close()
+	c.Check(res.StatusCode, gc.Equals, http.StatusMethodNotAllowed)
+	c.Check(res.Header.Get("Allow"), gc.Equals, "GET, HEAD")
+}
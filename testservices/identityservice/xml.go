@@ -0,0 +1,74 @@
+package identityservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Older clients of the v2 API ask for XML rather than JSON by sending
+// "Accept: application/xml". Token responses and errors are then sent
+// as XML, with the element names given by the xml struct tags.
+
+// xmlNamespace is the namespace of v2 identity XML documents.
+const xmlNamespace = "http://docs.openstack.org/identity/api/v2.0"
+
+// acceptsXML reports whether the client sending r prefers XML to JSON
+// responses. Whichever of the two media types the Accept header lists
+// first is preferred; JSON is the default.
+func acceptsXML(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return false
+		case "application/xml":
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateFormat sets the Content-Type of the response to r, which
+// determines whether it is written as XML or JSON.
+func negotiateFormat(w http.ResponseWriter, r *http.Request) {
+	if acceptsXML(r) {
+		w.Header().Set("Content-Type", "application/xml")
+	}
+}
+
+// isXMLResponse reports whether the response being written to w is
+// XML.
+func isXMLResponse(w http.ResponseWriter) bool {
+	return w.Header().Get("Content-Type") == "application/xml"
+}
+
+// marshalXML returns v as a v2 identity XML document whose root
+// element has the given name.
+func marshalXML(name string, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	start := xml.StartElement{
+		Name: xml.Name{Local: name},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: xmlNamespace}},
+	}
+	if err := xml.NewEncoder(&buf).EncodeElement(v, start); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// marshalAccess returns the encoding of res in the format negotiated
+// for the response being written to w.
+func marshalAccess(w http.ResponseWriter, res *AccessResponse) ([]byte, error) {
+	if isXMLResponse(w) {
+		return marshalXML("access", res.Access)
+	}
+	return json.Marshal(res)
+}
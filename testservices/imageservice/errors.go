@@ -0,0 +1,81 @@
+// Glance double testing service - error responses
+
+package imageservice
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// glanceError is an error which is reported to clients in the plain
+// text format used by Glance.
+type glanceError struct {
+	code    int
+	message string
+	// allowed holds the methods listed in the Allow header of a 405
+	// response.
+	allowed []string
+}
+
+func (e *glanceError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.message)
+}
+
+func (e *glanceError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := fmt.Sprintf("%d %s\n\n%s\n\n   ", e.code, http.StatusText(e.code), e.message)
+	if len(e.allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(e.allowed, ", "))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(e.code)
+	w.Write([]byte(body))
+}
+
+func glanceErrorf(code int, message string, args ...interface{}) *glanceError {
+	return &glanceError{code: code, message: fmt.Sprintf(message, args...)}
+}
+
+func errBadRequest(message string) error {
+	return glanceErrorf(http.StatusBadRequest, "%s", message)
+}
+
+func errNotFound(path string) error {
+	return glanceErrorf(http.StatusNotFound, "The resource could not be found: %s", path)
+}
+
+func errMethodNotAllowed(method, path string, allowed ...string) error {
+	err := glanceErrorf(http.StatusMethodNotAllowed, "Method %s is not allowed for %s", method, path)
+	err.allowed = allowed
+	return err
+}
+
+func errImageExists(id string) error {
+	return glanceErrorf(http.StatusConflict, "Image with identifier %s already exists!", id)
+}
+
+func errImageNotFound(id string) error {
+	return glanceErrorf(http.StatusNotFound, "No image found with ID %s", id)
+}
+
+func errImageProtected(id string) error {
+	return glanceErrorf(http.StatusForbidden, "Image %s is protected and cannot be deleted.", id)
+}
+
+func errImageForbidden(action, id string) error {
+	return glanceErrorf(http.StatusForbidden, "You are not permitted to %s image %s.", action, id)
+}
+
+func errInvalidVisibility(visibility string) error {
+	return glanceErrorf(http.StatusBadRequest, "Invalid visibility value: %s", visibility)
+}
+
+func errInvalidStatusTransition(from, to string) error {
+	return glanceErrorf(http.StatusConflict, "Image status transition from %s to %s is not allowed", from, to)
+}
+
+func errUnsupportedContentType(contentType string) error {
+	return glanceErrorf(http.StatusUnsupportedMediaType, "Content-Type %q is not supported for image data, use application/octet-stream", contentType)
+}
@@ -0,0 +1,340 @@
+// Glance double testing service - internal direct API implementation
+
+package imageservice
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+var _ testservices.HttpService = (*Glance)(nil)
+var _ identityservice.ServiceProvider = (*Glance)(nil)
+
+// The statuses an image passes through. An image is queued until its
+// data is uploaded, saving while the upload is in progress, and
+// active once it is complete.
+const (
+	StatusQueued = "queued"
+	StatusSaving = "saving"
+	StatusActive = "active"
+)
+
+// The visibilities an image may have.
+const (
+	VisibilityPublic    = "public"
+	VisibilityPrivate   = "private"
+	VisibilityShared    = "shared"
+	VisibilityCommunity = "community"
+)
+
+// timeFormat is the format of the timestamps Glance reports.
+const timeFormat = "2006-01-02T15:04:05Z"
+
+// Image describes a Glance v2 image.
+type Image struct {
+	Id              string   `json:"id"`
+	Name            string   `json:"name"`
+	Status          string   `json:"status"`
+	Visibility      string   `json:"visibility"`
+	Owner           string   `json:"owner"`
+	Protected       bool     `json:"protected"`
+	Tags            []string `json:"tags"`
+	DiskFormat      string   `json:"disk_format,omitempty"`
+	ContainerFormat string   `json:"container_format,omitempty"`
+	MinDisk         int      `json:"min_disk"`
+	MinRam          int      `json:"min_ram"`
+	// Size and Checksum are nil until the image data is uploaded.
+	Size      *int64  `json:"size"`
+	Checksum  *string `json:"checksum"`
+	CreatedAt string  `json:"created_at"`
+	UpdatedAt string  `json:"updated_at"`
+	Self      string  `json:"self"`
+	File      string  `json:"file"`
+	Schema    string  `json:"schema"`
+}
+
+// Glance implements a OpenStack Glance (v2) testing service and
+// contains the service double's internal state.
+type Glance struct {
+	testservices.ServiceInstance
+
+	mu     sync.Mutex // protects the remaining fields
+	images map[string]Image
+	data   map[string][]byte
+}
+
+// New creates an instance of the Glance object, given the parameters.
+func New(hostURL, versionPath, tenantId, region string, identityService identityservice.IdentityService) *Glance {
+	URL, err := url.Parse(hostURL)
+	if err != nil {
+		panic(err)
+	}
+	hostname := URL.Host
+	if !strings.HasSuffix(hostname, "/") {
+		hostname += "/"
+	}
+	glance := &Glance{
+		images: make(map[string]Image),
+		data:   make(map[string][]byte),
+		ServiceInstance: testservices.ServiceInstance{
+			IdentityService: identityService,
+			Scheme:          URL.Scheme,
+			Hostname:        hostname,
+			VersionPath:     versionPath,
+			TenantId:        tenantId,
+			Region:          region,
+		},
+	}
+	if identityService != nil {
+		identityService.RegisterServiceProvider("glance", "image", glance)
+	}
+	return glance
+}
+
+// endpointURL returns the unversioned service endpoint URL. Like
+// Neutron, Glance's catalog entry includes neither the API version
+// nor the tenant.
+func (g *Glance) endpointURL() string {
+	return g.Scheme + "://" + g.Hostname
+}
+
+// Reset removes all images and their data, along with everything set
+// with the ServiceInstance methods (see ServiceInstance.Reset). The
+// exported fields are preserved.
+func (g *Glance) Reset() {
+	g.ServiceInstance.Reset()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.images = make(map[string]Image)
+	g.data = make(map[string][]byte)
+}
+
+func (g *Glance) Endpoints() []identityservice.Endpoint {
+	ep := identityservice.Endpoint{
+		AdminURL:    g.endpointURL(),
+		InternalURL: g.endpointURL(),
+		PublicURL:   g.endpointURL(),
+		Region:      g.Region,
+	}
+	return []identityservice.Endpoint{ep}
+}
+
+func now() string {
+	return time.Now().UTC().Format(timeFormat)
+}
+
+// validVisibility reports whether visibility is one Glance accepts.
+func validVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityPrivate, VisibilityShared, VisibilityCommunity:
+		return true
+	}
+	return false
+}
+
+// addImage stores a new image. The image is queued, awaiting its data,
+// regardless of the status given. Unset fields are given the defaults
+// Glance uses.
+func (g *Glance) addImage(image Image) error {
+	if err := g.ProcessFunctionHook(g, &image); err != nil {
+		return err
+	}
+	if image.Visibility == "" {
+		image.Visibility = VisibilityPrivate
+	} else if !validVisibility(image.Visibility) {
+		return errInvalidVisibility(image.Visibility)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.images[image.Id]; ok {
+		return errImageExists(image.Id)
+	}
+	if image.Owner == "" {
+		image.Owner = g.TenantId
+	}
+	if image.Tags == nil {
+		image.Tags = []string{}
+	}
+	image.Status = StatusQueued
+	image.Size = nil
+	image.Checksum = nil
+	image.CreatedAt = now()
+	image.UpdatedAt = image.CreatedAt
+	image.Self = fmt.Sprintf("/%s/images/%s", g.VersionPath, image.Id)
+	image.File = image.Self + "/file"
+	image.Schema = fmt.Sprintf("/%s/schemas/image", g.VersionPath)
+	g.images[image.Id] = image
+	return nil
+}
+
+// image retrieves an existing image by id.
+func (g *Glance) image(imageId string) (*Image, error) {
+	if err := g.ProcessFunctionHook(g, imageId); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	image, ok := g.images[imageId]
+	g.mu.Unlock()
+	if !ok {
+		return nil, errImageNotFound(imageId)
+	}
+	return &image, nil
+}
+
+type imagesById []Image
+
+func (s imagesById) Len() int {
+	return len(s)
+}
+
+func (s imagesById) Less(i, j int) bool {
+	return s[i].Id < s[j].Id
+}
+
+func (s imagesById) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// imageFilter restricts the images returned by matchImages. Empty
+// fields match any image.
+type imageFilter struct {
+	name       string
+	status     string
+	visibility string
+	// tenantId, if set, restricts the images to those the tenant can
+	// see. As in Glance, community images which the tenant does not
+	// own are only listed if asked for by visibility.
+	tenantId string
+}
+
+// imageVisible reports whether the given tenant can see an image. All
+// images are visible if tenantId is empty.
+func imageVisible(image Image, tenantId string) bool {
+	switch {
+	case tenantId == "" || image.Owner == tenantId:
+		return true
+	case image.Visibility == VisibilityPublic || image.Visibility == VisibilityCommunity:
+		return true
+	}
+	return false
+}
+
+func (f imageFilter) matches(image Image) bool {
+	if !imageVisible(image, f.tenantId) {
+		return false
+	}
+	unlisted := f.tenantId != "" && f.tenantId != image.Owner && image.Visibility == VisibilityCommunity
+	if unlisted && f.visibility != VisibilityCommunity {
+		return false
+	}
+	return (f.name == "" || f.name == image.Name) &&
+		(f.status == "" || f.status == image.Status) &&
+		(f.visibility == "" || f.visibility == image.Visibility)
+}
+
+// matchImages returns a list of the images matching the filter,
+// ordered by id.
+func (g *Glance) matchImages(filter imageFilter) []Image {
+	g.mu.Lock()
+	images := make([]Image, 0, len(g.images))
+	for _, image := range g.images {
+		if filter.matches(image) {
+			images = append(images, image)
+		}
+	}
+	g.mu.Unlock()
+	sort.Sort(imagesById(images))
+	return images
+}
+
+// removeImage deletes an existing image, along with its data.
+// Protected images cannot be deleted.
+func (g *Glance) removeImage(imageId string) error {
+	if err := g.ProcessFunctionHook(g, imageId); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	image, ok := g.images[imageId]
+	if !ok {
+		return errImageNotFound(imageId)
+	}
+	if image.Protected {
+		return errImageProtected(imageId)
+	}
+	delete(g.images, imageId)
+	delete(g.data, imageId)
+	return nil
+}
+
+// setImageStatus changes the status of an image, which must currently
+// have the status from.
+func (g *Glance) setImageStatus(imageId, from, to string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	image, ok := g.images[imageId]
+	if !ok {
+		return errImageNotFound(imageId)
+	}
+	if image.Status != from {
+		return errInvalidStatusTransition(image.Status, to)
+	}
+	image.Status = to
+	image.UpdatedAt = now()
+	g.images[imageId] = image
+	return nil
+}
+
+// uploadImageData stores the data of a queued image, which becomes
+// active. The image is saving while the hook for this method runs, so
+// tests can observe it mid-upload, or fail the upload, in which case
+// the image is queued again.
+func (g *Glance) uploadImageData(imageId string, data []byte) error {
+	if err := g.setImageStatus(imageId, StatusQueued, StatusSaving); err != nil {
+		return err
+	}
+	if err := g.ProcessFunctionHook(g, imageId, data); err != nil {
+		g.setImageStatus(imageId, StatusSaving, StatusQueued)
+		return err
+	}
+	hash := md5.Sum(data)
+	checksum := hex.EncodeToString(hash[:])
+	size := int64(len(data))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	image, ok := g.images[imageId]
+	if !ok {
+		// The image was deleted during the upload.
+		return errImageNotFound(imageId)
+	}
+	image.Status = StatusActive
+	image.Size = &size
+	image.Checksum = &checksum
+	image.UpdatedAt = now()
+	g.images[imageId] = image
+	g.data[imageId] = append([]byte(nil), data...)
+	return nil
+}
+
+// imageData returns the data of an image, or nil if it has not been
+// uploaded.
+func (g *Glance) imageData(imageId string) ([]byte, error) {
+	if err := g.ProcessFunctionHook(g, imageId); err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.images[imageId]; !ok {
+		return nil, errImageNotFound(imageId)
+	}
+	return g.data[imageId], nil
+}
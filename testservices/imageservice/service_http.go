@@ -0,0 +1,271 @@
+// Glance double testing service - HTTP API implementation
+
+package imageservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/goose.v1/testservices"
+)
+
+const authToken = "X-Auth-Token"
+
+// unauthorizedResponse is the verbatim body of a real Glance 401.
+const unauthorizedResponse = "401 Unauthorized\n\nThis server could not verify that you are authorized to access the document you requested. Either you supplied the wrong credentials (e.g., bad password), or your browser does not understand how to supply the credentials required.\n\n   "
+
+type glanceHandler struct {
+	g      *Glance
+	method func(g *Glance, w http.ResponseWriter, r *http.Request) error
+	// methods holds the HTTP methods supported by the route, as
+	// reported in response to OPTIONS requests.
+	methods []string
+}
+
+func (h *glanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.g.DelayResponse(r); err != nil {
+		// The client has given up on the request.
+		return
+	}
+	h.g.AddResponseHeaders(w)
+	if h.g.HandleOptions(w, r, h.methods...) {
+		return
+	}
+	// handle invalid X-Auth-Token header
+	token := r.Header.Get(authToken)
+	user, err := h.g.IdentityService.FindUser(token)
+	if err != nil || h.g.TokenExpired(token) {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		writeResponse(w, http.StatusUnauthorized, []byte(unauthorizedResponse))
+		return
+	}
+	if err := h.g.CheckRole(r, user); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.g.CheckRateLimit(r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.g.LimitRequestBody(w, r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	err = h.method(h.g, w, r)
+	if err == nil {
+		return
+	}
+	resp, ok := err.(http.Handler)
+	if !ok {
+		resp = glanceErrorf(http.StatusInternalServerError, "%s", err.Error())
+	}
+	resp.ServeHTTP(w, r)
+}
+
+func writeResponse(w http.ResponseWriter, code int, body []byte) {
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// sendJSON sends the specified response serialized as JSON.
+func sendJSON(code int, resp interface{}, w http.ResponseWriter, r *http.Request) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, code, data)
+	return nil
+}
+
+// handler returns an http.Handler for a route supporting the given
+// HTTP methods, served by the given method.
+func (g *Glance) handler(method func(g *Glance, w http.ResponseWriter, r *http.Request) error, methods ...string) http.Handler {
+	return &glanceHandler{g, method, methods}
+}
+
+// visibleImage retrieves an existing image which is visible to
+// requests scoped to the given tenant. If action is set, the image
+// must also be owned by the tenant, as only owners may change images.
+func (g *Glance) visibleImage(imageId, scope, action string) (*Image, error) {
+	image, err := g.image(imageId)
+	if err != nil {
+		return nil, err
+	}
+	if !imageVisible(*image, scope) {
+		return nil, errImageNotFound(imageId)
+	}
+	if action != "" && scope != "" && image.Owner != scope {
+		return nil, errImageForbidden(action, imageId)
+	}
+	return image, nil
+}
+
+// handleImages handles the images HTTP API, including the image data
+// at /images/<id>/file. Each tenant sees its own images, and the public
+// and community images of other tenants.
+func (g *Glance) handleImages(w http.ResponseWriter, r *http.Request) error {
+	prefix := fmt.Sprintf("/%s/images", g.VersionPath)
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/")
+	imageId := parts[0]
+	user, err := g.IdentityService.FindUser(r.Header.Get(authToken))
+	if err != nil {
+		return err
+	}
+	scope, err := testservices.TenantScope(r, user)
+	if err != nil {
+		return errBadRequest(err.Error())
+	}
+	switch {
+	case len(parts) == 2 && parts[1] == "file":
+		return g.handleImageData(imageId, scope, w, r)
+	case len(parts) > 1:
+		return errNotFound(r.URL.Path)
+	case imageId == "" && r.Method == "GET":
+		query := r.URL.Query()
+		resp := struct {
+			Images []Image `json:"images"`
+			Schema string  `json:"schema"`
+			First  string  `json:"first"`
+		}{
+			Images: g.matchImages(imageFilter{
+				name:       query.Get("name"),
+				status:     query.Get("status"),
+				visibility: query.Get("visibility"),
+				tenantId:   scope,
+			}),
+			Schema: fmt.Sprintf("/%s/schemas/images", g.VersionPath),
+			First:  prefix,
+		}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case imageId == "" && r.Method == "POST":
+		return g.createImage(user.TenantId, w, r)
+	case imageId != "" && r.Method == "GET":
+		image, err := g.visibleImage(imageId, scope, "")
+		if err != nil {
+			return err
+		}
+		return sendJSON(http.StatusOK, image, w, r)
+	case imageId != "" && r.Method == "DELETE":
+		if _, err := g.visibleImage(imageId, scope, "delete"); err != nil {
+			return err
+		}
+		if err := g.removeImage(imageId); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	if imageId == "" {
+		return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "POST")
+	}
+	return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "DELETE")
+}
+
+// createImage handles a request to create an image, which is owned by
+// the given tenant. Only the image metadata is given; the data is
+// uploaded separately.
+func (g *Glance) createImage(tenantId string, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Id              string   `json:"id"`
+		Name            string   `json:"name"`
+		Visibility      string   `json:"visibility"`
+		Protected       bool     `json:"protected"`
+		Tags            []string `json:"tags"`
+		DiskFormat      string   `json:"disk_format"`
+		ContainerFormat string   `json:"container_format"`
+		MinDisk         int      `json:"min_disk"`
+		MinRam          int      `json:"min_ram"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errBadRequest("Malformed request body")
+	}
+	if req.Id == "" {
+		if req.Id, err = g.NewID(testservices.ResourceImage); err != nil {
+			return err
+		}
+	}
+	image := Image{
+		Id:              req.Id,
+		Name:            req.Name,
+		Visibility:      req.Visibility,
+		Protected:       req.Protected,
+		Tags:            req.Tags,
+		DiskFormat:      req.DiskFormat,
+		ContainerFormat: req.ContainerFormat,
+		MinDisk:         req.MinDisk,
+		MinRam:          req.MinRam,
+		Owner:           tenantId,
+	}
+	if err := g.addImage(image); err != nil {
+		return err
+	}
+	created, err := g.image(req.Id)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Location", g.endpointURL()+strings.TrimPrefix(created.Self, "/"))
+	return sendJSON(http.StatusCreated, created, w, r)
+}
+
+// handleImageData handles uploading and downloading image data, for
+// requests scoped to the given tenant.
+func (g *Glance) handleImageData(imageId, scope string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "PUT":
+		if _, err := g.visibleImage(imageId, scope, "upload data to"); err != nil {
+			return err
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/octet-stream" {
+			return errUnsupportedContentType(contentType)
+		}
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		if err := g.uploadImageData(imageId, data); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	case "GET":
+		if _, err := g.visibleImage(imageId, scope, ""); err != nil {
+			return err
+		}
+		data, err := g.imageData(imageId)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			writeResponse(w, http.StatusNoContent, nil)
+			return nil
+		}
+		image, err := g.image(imageId)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-MD5", *image.Checksum)
+		writeResponse(w, http.StatusOK, data)
+		return nil
+	}
+	return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "PUT")
+}
+
+// SetupHTTP attaches all the needed handlers to provide the HTTP API.
+func (g *Glance) SetupHTTP(mux *http.ServeMux) {
+	path := fmt.Sprintf("/%s/images", g.VersionPath)
+	h := g.handler((*Glance).handleImages, "GET", "POST", "PUT", "DELETE")
+	mux.Handle(path, h)
+	mux.Handle(path+"/", h)
+}
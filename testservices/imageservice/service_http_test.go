@@ -0,0 +1,292 @@
+// Glance double testing service - HTTP API tests
+
+package imageservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices/hook"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+type GlanceHTTPSuite struct {
+	httpsuite.HTTPSuite
+	service *Glance
+	token   string
+}
+
+var _ = gc.Suite(&GlanceHTTPSuite{})
+
+func (s *GlanceHTTPSuite) SetUpTest(c *gc.C) {
+	s.HTTPSuite.SetUpTest(c)
+	identityDouble := identityservice.NewUserPass()
+	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
+	s.token = userInfo.Token
+	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
+	identityDouble.SetupHTTP(s.Mux)
+	s.service.SetupHTTP(s.Mux)
+}
+
+// request sends body to path, relative to the versioned service
+// endpoint, using the suite's token.
+func (s *GlanceHTTPSuite) request(c *gc.C, method, path, contentType string, body []byte) *http.Response {
+	URL := s.service.endpointURL() + versionPath + path
+	req, err := http.NewRequest(method, URL, bytes.NewReader(body))
+	c.Assert(err, gc.IsNil)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set(authToken, s.token)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	return resp
+}
+
+// jsonRequest sends the given body, if any, as JSON.
+func (s *GlanceHTTPSuite) jsonRequest(c *gc.C, method, path string, body interface{}) *http.Response {
+	var jsonBody []byte
+	if body != nil {
+		var err error
+		jsonBody, err = json.Marshal(body)
+		c.Assert(err, gc.IsNil)
+	}
+	return s.request(c, method, path, "application/json", jsonBody)
+}
+
+func readBody(c *gc.C, resp *http.Response, code int) []byte {
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, code)
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	return body
+}
+
+func assertJSON(c *gc.C, resp *http.Response, code int, result interface{}) {
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json")
+	err := json.Unmarshal(readBody(c, resp, code), result)
+	c.Assert(err, gc.IsNil)
+}
+
+func assertGlanceError(c *gc.C, resp *http.Response, code int, message string) {
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain; charset=UTF-8")
+	body := readBody(c, resp, code)
+	c.Assert(string(body), gc.Matches, "(?s)[0-9]{3} .*\n\n"+message+"\n\n   ")
+}
+
+func (s *GlanceHTTPSuite) createImage(c *gc.C, body map[string]interface{}) Image {
+	var image Image
+	assertJSON(c, s.jsonRequest(c, "POST", "/images", body), http.StatusCreated, &image)
+	return image
+}
+
+func (s *GlanceHTTPSuite) listImages(c *gc.C, query string) []Image {
+	var result struct {
+		Images []Image `json:"images"`
+	}
+	assertJSON(c, s.request(c, "GET", "/images"+query, "", nil), http.StatusOK, &result)
+	return result.Images
+}
+
+func (s *GlanceHTTPSuite) TestUnauthorized(c *gc.C) {
+	s.token = "bad-token"
+	resp := s.request(c, "GET", "/images", "", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+}
+
+func (s *GlanceHTTPSuite) TestCatalog(c *gc.C) {
+	creds := identity.Credentials{User: "fred", Secrets: "secret", URL: s.Server.URL + "/tokens"}
+	auth, err := (&identity.UserPass{}).Auth(&creds)
+	c.Assert(err, gc.IsNil)
+	c.Assert(auth.RegionServiceURLs[region]["image"], gc.Equals, s.Server.URL+"/")
+}
+
+func (s *GlanceHTTPSuite) TestCreateShowDeleteImage(c *gc.C) {
+	image := s.createImage(c, map[string]interface{}{
+		"name":             "trusty",
+		"disk_format":      "qcow2",
+		"container_format": "bare",
+		"tags":             []string{"ubuntu"},
+	})
+	c.Assert(image.Id, gc.Not(gc.Equals), "")
+	c.Assert(image.Name, gc.Equals, "trusty")
+	c.Assert(image.Status, gc.Equals, StatusQueued)
+	c.Assert(image.Visibility, gc.Equals, VisibilityPrivate)
+	c.Assert(image.DiskFormat, gc.Equals, "qcow2")
+	c.Assert(image.ContainerFormat, gc.Equals, "bare")
+	c.Assert(image.Tags, gc.DeepEquals, []string{"ubuntu"})
+	c.Assert(image.Owner, gc.Equals, s.service.TenantId)
+	c.Assert(image.Size, gc.IsNil)
+
+	var shown Image
+	assertJSON(c, s.request(c, "GET", "/images/"+image.Id, "", nil), http.StatusOK, &shown)
+	c.Assert(shown, gc.DeepEquals, image)
+
+	resp := s.request(c, "DELETE", "/images/"+image.Id, "", nil)
+	readBody(c, resp, http.StatusNoContent)
+	resp = s.request(c, "GET", "/images/"+image.Id, "", nil)
+	assertGlanceError(c, resp, http.StatusNotFound, "No image found with ID "+image.Id)
+}
+
+func (s *GlanceHTTPSuite) TestCreateImageWithId(c *gc.C) {
+	body := map[string]interface{}{"id": "image-1", "name": "trusty"}
+	image := s.createImage(c, body)
+	c.Assert(image.Id, gc.Equals, "image-1")
+	resp := s.jsonRequest(c, "POST", "/images", body)
+	assertGlanceError(c, resp, http.StatusConflict, "Image with identifier image-1 already exists!")
+}
+
+func (s *GlanceHTTPSuite) TestCreateImageBadRequest(c *gc.C) {
+	resp := s.request(c, "POST", "/images", "application/json", []byte("not json"))
+	assertGlanceError(c, resp, http.StatusBadRequest, "Malformed request body")
+	resp = s.jsonRequest(c, "POST", "/images", map[string]interface{}{"visibility": "everyone"})
+	assertGlanceError(c, resp, http.StatusBadRequest, "Invalid visibility value: everyone")
+}
+
+func (s *GlanceHTTPSuite) TestUploadDownloadImageData(c *gc.C) {
+	image := s.createImage(c, map[string]interface{}{"name": "trusty"})
+	resp := s.request(c, "GET", "/images/"+image.Id+"/file", "", nil)
+	readBody(c, resp, http.StatusNoContent)
+
+	resp = s.request(c, "PUT", "/images/"+image.Id+"/file", "application/octet-stream", []byte("test data"))
+	readBody(c, resp, http.StatusNoContent)
+	var shown Image
+	assertJSON(c, s.request(c, "GET", "/images/"+image.Id, "", nil), http.StatusOK, &shown)
+	c.Assert(shown.Status, gc.Equals, StatusActive)
+	c.Assert(*shown.Size, gc.Equals, int64(9))
+	c.Assert(*shown.Checksum, gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+
+	resp = s.request(c, "GET", "/images/"+image.Id+"/file", "", nil)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/octet-stream")
+	c.Assert(resp.Header.Get("Content-MD5"), gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	c.Assert(string(readBody(c, resp, http.StatusOK)), gc.Equals, "test data")
+
+	resp = s.request(c, "PUT", "/images/"+image.Id+"/file", "application/octet-stream", []byte("test data"))
+	assertGlanceError(c, resp, http.StatusConflict, "Image status transition from active to saving is not allowed")
+}
+
+func (s *GlanceHTTPSuite) TestUploadImageDataBadContentType(c *gc.C) {
+	image := s.createImage(c, map[string]interface{}{"name": "trusty"})
+	resp := s.request(c, "PUT", "/images/"+image.Id+"/file", "application/json", []byte("test data"))
+	c.Assert(readBody(c, resp, http.StatusUnsupportedMediaType), gc.Not(gc.HasLen), 0)
+}
+
+func (s *GlanceHTTPSuite) TestListImagesFiltered(c *gc.C) {
+	s.createImage(c, map[string]interface{}{"id": "1", "name": "trusty"})
+	s.createImage(c, map[string]interface{}{"id": "2", "name": "xenial", "visibility": "public"})
+	s.createImage(c, map[string]interface{}{"id": "3", "name": "trusty", "visibility": "public"})
+	resp := s.request(c, "PUT", "/images/3/file", "application/octet-stream", []byte("data"))
+	readBody(c, resp, http.StatusNoContent)
+	for i, t := range []struct {
+		query string
+		ids   []string
+	}{
+		{"", []string{"1", "2", "3"}},
+		{"?name=trusty", []string{"1", "3"}},
+		{"?visibility=public", []string{"2", "3"}},
+		{"?status=active", []string{"3"}},
+		{"?status=queued&visibility=public", []string{"2"}},
+		{"?name=precise", []string{}},
+	} {
+		c.Logf("test %d: %q", i, t.query)
+		ids := []string{}
+		for _, image := range s.listImages(c, t.query) {
+			ids = append(ids, image.Id)
+		}
+		c.Check(ids, gc.DeepEquals, t.ids)
+	}
+}
+
+// imageIds returns the ids of the images listed with the given query.
+func (s *GlanceHTTPSuite) imageIds(c *gc.C, query string) []string {
+	ids := []string{}
+	for _, image := range s.listImages(c, query) {
+		ids = append(ids, image.Id)
+	}
+	return ids
+}
+
+func (s *GlanceHTTPSuite) TestTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("other", "secret", "other-tenant")
+	admin := identityDouble.AddUser("admin", "secret", "admin-tenant")
+	err := identityDouble.SetUserTenant("admin", admin.TenantId, "admin-tenant", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	s.createImage(c, map[string]interface{}{"id": "1", "name": "private"})
+	s.createImage(c, map[string]interface{}{"id": "2", "name": "public", "visibility": "public"})
+	s.createImage(c, map[string]interface{}{"id": "3", "name": "community", "visibility": "community"})
+	owner := s.token
+
+	s.token = other.Token
+	c.Assert(s.imageIds(c, ""), gc.DeepEquals, []string{"2"})
+	c.Assert(s.imageIds(c, "?visibility=community"), gc.DeepEquals, []string{"3"})
+	c.Assert(s.imageIds(c, "?all_tenants=1"), gc.DeepEquals, []string{"2"})
+	resp := s.request(c, "GET", "/images/1", "", nil)
+	assertGlanceError(c, resp, http.StatusNotFound, "No image found with ID 1")
+	resp = s.request(c, "GET", "/images/1/file", "", nil)
+	assertGlanceError(c, resp, http.StatusNotFound, "No image found with ID 1")
+	resp = s.request(c, "DELETE", "/images/1", "", nil)
+	assertGlanceError(c, resp, http.StatusNotFound, "No image found with ID 1")
+	var shown Image
+	assertJSON(c, s.request(c, "GET", "/images/3", "", nil), http.StatusOK, &shown)
+	c.Assert(shown.Owner, gc.Equals, s.service.TenantId)
+	resp = s.request(c, "DELETE", "/images/2", "", nil)
+	assertGlanceError(c, resp, http.StatusForbidden, "You are not permitted to delete image 2.")
+	resp = s.request(c, "PUT", "/images/2/file", "application/octet-stream", []byte("data"))
+	assertGlanceError(c, resp, http.StatusForbidden, "You are not permitted to upload data to image 2.")
+	mine := s.createImage(c, map[string]interface{}{"id": "4", "name": "mine"})
+	c.Assert(mine.Owner, gc.Equals, other.TenantId)
+
+	s.token = owner
+	c.Assert(s.imageIds(c, ""), gc.DeepEquals, []string{"1", "2", "3"})
+
+	s.token = admin.Token
+	c.Assert(s.imageIds(c, "?all_tenants=1"), gc.DeepEquals, []string{"1", "2", "3", "4"})
+	resp = s.request(c, "DELETE", "/images/4?all_tenants=1", "", nil)
+	readBody(c, resp, http.StatusNoContent)
+	resp = s.request(c, "GET", "/images?all_tenants=maybe", "", nil)
+	assertGlanceError(c, resp, http.StatusBadRequest, "Invalid value 'maybe' for all_tenants")
+}
+
+func (s *GlanceHTTPSuite) TestDeleteProtectedImage(c *gc.C) {
+	image := s.createImage(c, map[string]interface{}{"name": "trusty", "protected": true})
+	resp := s.request(c, "DELETE", "/images/"+image.Id, "", nil)
+	assertGlanceError(c, resp, http.StatusForbidden, "Image "+image.Id+" is protected and cannot be deleted.")
+}
+
+func (s *GlanceHTTPSuite) TestBadPaths(c *gc.C) {
+	resp := s.request(c, "GET", "/images/1/file/extra", "", nil)
+	assertGlanceError(c, resp, http.StatusNotFound, "The resource could not be found: /v2/images/1/file/extra")
+	resp = s.request(c, "PUT", "/images", "", nil)
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, POST")
+	assertGlanceError(c, resp, http.StatusMethodNotAllowed, "Method PUT is not allowed for /v2/images")
+	resp = s.request(c, "POST", "/images/1", "", nil)
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, DELETE")
+	assertGlanceError(c, resp, http.StatusMethodNotAllowed, "Method POST is not allowed for /v2/images/1")
+	resp = s.request(c, "DELETE", "/images/1/file", "", nil)
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, PUT")
+	assertGlanceError(c, resp, http.StatusMethodNotAllowed, "Method DELETE is not allowed for /v2/images/1/file")
+}
+
+func (s *GlanceHTTPSuite) TestResponseDelay(c *gc.C) {
+	s.service.SetEndpointDelay("/images$", hook.FixedDelay(time.Second))
+	req, err := http.NewRequest("GET", s.service.endpointURL()+versionPath+"/images", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set(authToken, s.token)
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	_, err = client.Do(req)
+	c.Assert(err, gc.ErrorMatches, ".*Client.Timeout exceeded.*")
+	// Other endpoints are unaffected.
+	resp := s.request(c, "GET", "/images/1", "", nil)
+	readBody(c, resp, http.StatusNotFound)
+}
@@ -0,0 +1,176 @@
+// Glance double testing service - internal direct API tests
+
+package imageservice
+
+import (
+	"fmt"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/testservices/hook"
+)
+
+type GlanceSuite struct {
+	service *Glance
+}
+
+const (
+	versionPath = "v2"
+	hostname    = "http://example.com"
+	region      = "region"
+)
+
+var _ = gc.Suite(&GlanceSuite{})
+
+func (s *GlanceSuite) SetUpTest(c *gc.C) {
+	s.service = New(hostname, versionPath, "tenant", region, nil)
+}
+
+func (s *GlanceSuite) addImage(c *gc.C, image Image) {
+	err := s.service.addImage(image)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *GlanceSuite) TestEndpoints(c *gc.C) {
+	endpoints := s.service.Endpoints()
+	c.Assert(endpoints, gc.HasLen, 1)
+	c.Assert(endpoints[0].PublicURL, gc.Equals, "http://example.com/")
+	c.Assert(endpoints[0].Region, gc.Equals, region)
+}
+
+func (s *GlanceSuite) TestAddGetRemoveImage(c *gc.C) {
+	s.addImage(c, Image{Id: "1", Name: "image-1", Status: StatusActive})
+	image, err := s.service.image("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(image.Name, gc.Equals, "image-1")
+	c.Assert(image.Status, gc.Equals, StatusQueued)
+	c.Assert(image.Visibility, gc.Equals, VisibilityPrivate)
+	c.Assert(image.Owner, gc.Equals, "tenant")
+	c.Assert(image.Tags, gc.DeepEquals, []string{})
+	c.Assert(image.Size, gc.IsNil)
+	c.Assert(image.Checksum, gc.IsNil)
+	c.Assert(image.Self, gc.Equals, "/v2/images/1")
+	c.Assert(image.File, gc.Equals, "/v2/images/1/file")
+	err = s.service.addImage(Image{Id: "1"})
+	c.Assert(err, gc.ErrorMatches, "409 Conflict: Image with identifier 1 already exists!")
+	err = s.service.removeImage("1")
+	c.Assert(err, gc.IsNil)
+	_, err = s.service.image("1")
+	c.Assert(err, gc.ErrorMatches, "404 Not Found: No image found with ID 1")
+	err = s.service.removeImage("1")
+	c.Assert(err, gc.ErrorMatches, "404 Not Found: No image found with ID 1")
+}
+
+func (s *GlanceSuite) TestAddImageInvalidVisibility(c *gc.C) {
+	err := s.service.addImage(Image{Id: "1", Visibility: "everyone"})
+	c.Assert(err, gc.ErrorMatches, "400 Bad Request: Invalid visibility value: everyone")
+	_, err = s.service.image("1")
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *GlanceSuite) TestRemoveProtectedImage(c *gc.C) {
+	s.addImage(c, Image{Id: "1", Protected: true})
+	err := s.service.removeImage("1")
+	c.Assert(err, gc.ErrorMatches, "403 Forbidden: Image 1 is protected and cannot be deleted.")
+	_, err = s.service.image("1")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *GlanceSuite) TestUploadImageData(c *gc.C) {
+	s.addImage(c, Image{Id: "1"})
+	data, err := s.service.imageData("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, gc.IsNil)
+	var during string
+	cleanup := s.service.RegisterControlPoint("uploadImageData", func(sc hook.ServiceControl, args ...interface{}) error {
+		image, err := s.service.image(args[0].(string))
+		c.Assert(err, gc.IsNil)
+		during = image.Status
+		return nil
+	})
+	defer cleanup()
+	err = s.service.uploadImageData("1", []byte("test data"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(during, gc.Equals, StatusSaving)
+	image, err := s.service.image("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(image.Status, gc.Equals, StatusActive)
+	c.Assert(*image.Size, gc.Equals, int64(9))
+	c.Assert(*image.Checksum, gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	data, err = s.service.imageData("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "test data")
+
+	// Active images cannot be uploaded again.
+	err = s.service.uploadImageData("1", []byte("other data"))
+	c.Assert(err, gc.ErrorMatches, "409 Conflict: Image status transition from active to saving is not allowed")
+}
+
+func (s *GlanceSuite) TestUploadImageDataFails(c *gc.C) {
+	s.addImage(c, Image{Id: "1"})
+	cleanup := s.service.RegisterControlPoint("uploadImageData", func(sc hook.ServiceControl, args ...interface{}) error {
+		return fmt.Errorf("upload failed")
+	})
+	err := s.service.uploadImageData("1", []byte("test data"))
+	c.Assert(err, gc.ErrorMatches, "upload failed")
+	cleanup()
+	image, err := s.service.image("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(image.Status, gc.Equals, StatusQueued)
+	// The upload can be retried.
+	err = s.service.uploadImageData("1", []byte("test data"))
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *GlanceSuite) TestUploadUnknownImage(c *gc.C) {
+	err := s.service.uploadImageData("1", []byte("test data"))
+	c.Assert(err, gc.ErrorMatches, "404 Not Found: No image found with ID 1")
+}
+
+func (s *GlanceSuite) TestMatchImages(c *gc.C) {
+	s.addImage(c, Image{Id: "3", Name: "trusty", Visibility: VisibilityPublic})
+	s.addImage(c, Image{Id: "1", Name: "trusty"})
+	s.addImage(c, Image{Id: "2", Name: "xenial", Visibility: VisibilityPublic})
+	err := s.service.uploadImageData("2", []byte("data"))
+	c.Assert(err, gc.IsNil)
+	for i, t := range []struct {
+		filter imageFilter
+		ids    []string
+	}{{
+		filter: imageFilter{},
+		ids:    []string{"1", "2", "3"},
+	}, {
+		filter: imageFilter{name: "trusty"},
+		ids:    []string{"1", "3"},
+	}, {
+		filter: imageFilter{visibility: VisibilityPublic},
+		ids:    []string{"2", "3"},
+	}, {
+		filter: imageFilter{status: StatusActive},
+		ids:    []string{"2"},
+	}, {
+		filter: imageFilter{name: "trusty", visibility: VisibilityPrivate},
+		ids:    []string{"1"},
+	}, {
+		filter: imageFilter{name: "precise"},
+		ids:    []string{},
+	}} {
+		c.Logf("test %d: %+v", i, t.filter)
+		ids := []string{}
+		for _, image := range s.service.matchImages(t.filter) {
+			ids = append(ids, image.Id)
+		}
+		c.Check(ids, gc.DeepEquals, t.ids)
+	}
+}
+
+func (s *GlanceSuite) TestReset(c *gc.C) {
+	s.addImage(c, Image{Id: "1", Name: "image-1"})
+	err := s.service.uploadImageData("1", []byte("data"))
+	c.Assert(err, gc.IsNil)
+	s.service.Reset()
+	_, err = s.service.image("1")
+	c.Assert(err, gc.ErrorMatches, "404 Not Found: No image found with ID 1")
+	c.Assert(s.service.data, gc.HasLen, 0)
+	s.addImage(c, Image{Id: "1", Name: "image-1"})
+}
@@ -0,0 +1,109 @@
+// Neutron double testing service - error responses
+
+package networkservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// neutronError is an error which is reported to clients in the
+// format used by Neutron.
+type neutronError struct {
+	code    int
+	kind    string
+	message string
+	// allowed holds the methods listed in the Allow header of a 405
+	// response.
+	allowed []string
+}
+
+func (e *neutronError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.message)
+}
+
+func (e *neutronError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		NeutronError struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			Detail  string `json:"detail"`
+		}
+	}{}
+	resp.NeutronError.Type = e.kind
+	resp.NeutronError.Message = e.message
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(e.allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(e.allowed, ", "))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(e.code)
+	w.Write(body)
+}
+
+func neutronErrorf(code int, kind, message string, args ...interface{}) *neutronError {
+	return &neutronError{code: code, kind: kind, message: fmt.Sprintf(message, args...)}
+}
+
+func errBadRequest(message string) error {
+	return neutronErrorf(http.StatusBadRequest, "HTTPBadRequest", "%s", message)
+}
+
+func errNotFound(path string) error {
+	return neutronErrorf(http.StatusNotFound, "HTTPNotFound", "The resource could not be found: %s", path)
+}
+
+func errMethodNotAllowed(method, path string, allowed ...string) error {
+	err := neutronErrorf(http.StatusMethodNotAllowed, "HTTPMethodNotAllowed", "Method %s is not allowed for %s", method, path)
+	err.allowed = allowed
+	return err
+}
+
+func errNetworkExists(id string) error {
+	return neutronErrorf(http.StatusConflict, "Conflict", "A network with id %s already exists.", id)
+}
+
+func errNetworkNotFound(id string) error {
+	return neutronErrorf(http.StatusNotFound, "NetworkNotFound", "Network %s could not be found.", id)
+}
+
+func errNetworkInUse(id string) error {
+	return neutronErrorf(http.StatusConflict, "NetworkInUse",
+		"Unable to complete operation on network %s. There are one or more subnets still in use on the network.", id)
+}
+
+func errSubnetExists(id string) error {
+	return neutronErrorf(http.StatusConflict, "Conflict", "A subnet with id %s already exists.", id)
+}
+
+func errSubnetNotFound(id string) error {
+	return neutronErrorf(http.StatusNotFound, "SubnetNotFound", "Subnet %s could not be found.", id)
+}
+
+func errInvalidCIDR(cidr string) error {
+	return neutronErrorf(http.StatusBadRequest, "HTTPBadRequest",
+		"Invalid input for cidr. Reason: '%s' is not a valid IP subnet.", cidr)
+}
+
+func errNonCanonicalCIDR(cidr, recommended string) error {
+	return neutronErrorf(http.StatusBadRequest, "HTTPBadRequest",
+		"Invalid input for cidr. Reason: '%s' isn't a recognized IP subnet cidr, '%s' is recommended.", cidr, recommended)
+}
+
+func errOverlappingCIDR(cidr, networkId string) error {
+	return neutronErrorf(http.StatusBadRequest, "BadRequest",
+		"Invalid input for operation: Requested subnet with cidr: %s for network: %s overlaps with another subnet.", cidr, networkId)
+}
+
+func errIPVersionMismatch(cidr string, ipVersion int) error {
+	return neutronErrorf(http.StatusBadRequest, "BadRequest",
+		"Invalid input for operation: Cidr %s is not valid for IP version %d.", cidr, ipVersion)
+}
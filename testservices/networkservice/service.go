@@ -0,0 +1,343 @@
+// Neutron double testing service - internal direct API implementation
+
+package networkservice
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+var _ testservices.HttpService = (*Neutron)(nil)
+var _ identityservice.ServiceProvider = (*Neutron)(nil)
+
+// StatusActive is the status of all networks created by the double.
+const StatusActive = "ACTIVE"
+
+// Network describes a Neutron network.
+type Network struct {
+	Id           string   `json:"id"`
+	Name         string   `json:"name"`
+	TenantId     string   `json:"tenant_id"`
+	Status       string   `json:"status"`
+	AdminStateUp bool     `json:"admin_state_up"`
+	Shared       bool     `json:"shared"`
+	External     bool     `json:"router:external"`
+	Subnets      []string `json:"subnets"`
+}
+
+// Subnet describes a Neutron subnet.
+type Subnet struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	TenantId   string `json:"tenant_id"`
+	NetworkId  string `json:"network_id"`
+	Cidr       string `json:"cidr"`
+	IPVersion  int    `json:"ip_version"`
+	GatewayIP  string `json:"gateway_ip"`
+	EnableDHCP bool   `json:"enable_dhcp"`
+}
+
+// Neutron implements a OpenStack Neutron (v2) testing service and
+// contains the service double's internal state.
+type Neutron struct {
+	testservices.ServiceInstance
+
+	mu       sync.Mutex // protects the remaining fields
+	networks map[string]Network
+	subnets  map[string]Subnet
+}
+
+// New creates an instance of the Neutron object, given the parameters.
+func New(hostURL, versionPath, tenantId, region string, identityService identityservice.IdentityService) *Neutron {
+	URL, err := url.Parse(hostURL)
+	if err != nil {
+		panic(err)
+	}
+	hostname := URL.Host
+	if !strings.HasSuffix(hostname, "/") {
+		hostname += "/"
+	}
+	neutron := &Neutron{
+		networks: make(map[string]Network),
+		subnets:  make(map[string]Subnet),
+		ServiceInstance: testservices.ServiceInstance{
+			IdentityService: identityService,
+			Scheme:          URL.Scheme,
+			Hostname:        hostname,
+			VersionPath:     versionPath,
+			TenantId:        tenantId,
+			Region:          region,
+		},
+	}
+	if identityService != nil {
+		identityService.RegisterServiceProvider("neutron", "network", neutron)
+	}
+	return neutron
+}
+
+// endpointURL returns the unversioned service endpoint URL. Unlike
+// most services, Neutron's catalog entry does not include the API
+// version or the tenant; both are implied by the request path.
+func (n *Neutron) endpointURL() string {
+	return n.Scheme + "://" + n.Hostname
+}
+
+// Reset removes all networks and subnets, along with everything set
+// with the ServiceInstance methods (see ServiceInstance.Reset). The
+// exported fields are preserved.
+func (n *Neutron) Reset() {
+	n.ServiceInstance.Reset()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.networks = make(map[string]Network)
+	n.subnets = make(map[string]Subnet)
+}
+
+func (n *Neutron) Endpoints() []identityservice.Endpoint {
+	ep := identityservice.Endpoint{
+		AdminURL:    n.endpointURL(),
+		InternalURL: n.endpointURL(),
+		PublicURL:   n.endpointURL(),
+		Region:      n.Region,
+	}
+	return []identityservice.Endpoint{ep}
+}
+
+// addNetwork stores a new network.
+func (n *Neutron) addNetwork(network Network) error {
+	if err := n.ProcessFunctionHook(n, &network); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.networks[network.Id]; ok {
+		return errNetworkExists(network.Id)
+	}
+	if network.TenantId == "" {
+		network.TenantId = n.TenantId
+	}
+	if network.Subnets == nil {
+		network.Subnets = []string{}
+	}
+	n.networks[network.Id] = network
+	return nil
+}
+
+// network retrieves an existing network by id.
+func (n *Neutron) network(networkId string) (*Network, error) {
+	if err := n.ProcessFunctionHook(n, networkId); err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	network, ok := n.networks[networkId]
+	n.mu.Unlock()
+	if !ok {
+		return nil, errNetworkNotFound(networkId)
+	}
+	return &network, nil
+}
+
+type networksById []Network
+
+func (s networksById) Len() int {
+	return len(s)
+}
+
+func (s networksById) Less(i, j int) bool {
+	return s[i].Id < s[j].Id
+}
+
+func (s networksById) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// networkVisible reports whether a network may be seen by requests
+// scoped to the given tenant, as returned by testservices.TenantScope.
+// Shared networks are visible to all tenants.
+func networkVisible(network Network, tenantId string) bool {
+	return tenantId == "" || network.TenantId == tenantId || network.Shared
+}
+
+// allNetworks returns a list of all the existing networks visible to
+// the given tenant, or of all networks if it is empty, ordered by id.
+func (n *Neutron) allNetworks(tenantId string) []Network {
+	n.mu.Lock()
+	networks := make([]Network, 0, len(n.networks))
+	for _, network := range n.networks {
+		if networkVisible(network, tenantId) {
+			networks = append(networks, network)
+		}
+	}
+	n.mu.Unlock()
+	sort.Sort(networksById(networks))
+	return networks
+}
+
+// removeNetwork deletes an existing network. Networks which still
+// have subnets cannot be removed.
+func (n *Neutron) removeNetwork(networkId string) error {
+	if err := n.ProcessFunctionHook(n, networkId); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	network, ok := n.networks[networkId]
+	if !ok {
+		return errNetworkNotFound(networkId)
+	}
+	if len(network.Subnets) > 0 {
+		return errNetworkInUse(networkId)
+	}
+	delete(n.networks, networkId)
+	return nil
+}
+
+// validateCIDR checks that cidr is a valid subnet address, which does
+// not overlap with any existing subnet of the given network. It returns
+// the parsed subnet.
+func (n *Neutron) validateCIDR(networkId, cidr string) (*net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, errInvalidCIDR(cidr)
+	}
+	if !ip.Equal(ipNet.IP) {
+		return nil, errNonCanonicalCIDR(cidr, ipNet.String())
+	}
+	for _, subnetId := range n.networks[networkId].Subnets {
+		_, other, err := net.ParseCIDR(n.subnets[subnetId].Cidr)
+		if err != nil {
+			continue
+		}
+		if other.Contains(ipNet.IP) || ipNet.Contains(other.IP) {
+			return nil, errOverlappingCIDR(cidr, networkId)
+		}
+	}
+	return ipNet, nil
+}
+
+// addSubnet stores a new subnet, adding it to its network. The
+// subnet's CIDR must be valid and must not overlap with any other
+// subnet of the network. If the subnet's IP version or gateway are
+// not set, they are derived from the CIDR.
+func (n *Neutron) addSubnet(subnet Subnet) error {
+	if err := n.ProcessFunctionHook(n, &subnet); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.subnets[subnet.Id]; ok {
+		return errSubnetExists(subnet.Id)
+	}
+	if subnet.TenantId == "" {
+		subnet.TenantId = n.TenantId
+	}
+	network, ok := n.networks[subnet.NetworkId]
+	if !ok {
+		return errNetworkNotFound(subnet.NetworkId)
+	}
+	ipNet, err := n.validateCIDR(subnet.NetworkId, subnet.Cidr)
+	if err != nil {
+		return err
+	}
+	ipVersion := 6
+	if ipNet.IP.To4() != nil {
+		ipVersion = 4
+	}
+	if subnet.IPVersion == 0 {
+		subnet.IPVersion = ipVersion
+	} else if subnet.IPVersion != ipVersion {
+		return errIPVersionMismatch(subnet.Cidr, subnet.IPVersion)
+	}
+	if subnet.GatewayIP == "" {
+		gateway := make(net.IP, len(ipNet.IP))
+		copy(gateway, ipNet.IP)
+		gateway[len(gateway)-1]++
+		subnet.GatewayIP = gateway.String()
+	}
+	network.Subnets = append(network.Subnets, subnet.Id)
+	n.networks[network.Id] = network
+	n.subnets[subnet.Id] = subnet
+	return nil
+}
+
+// subnet retrieves an existing subnet by id.
+func (n *Neutron) subnet(subnetId string) (*Subnet, error) {
+	if err := n.ProcessFunctionHook(n, subnetId); err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	subnet, ok := n.subnets[subnetId]
+	n.mu.Unlock()
+	if !ok {
+		return nil, errSubnetNotFound(subnetId)
+	}
+	return &subnet, nil
+}
+
+type subnetsById []Subnet
+
+func (s subnetsById) Len() int {
+	return len(s)
+}
+
+func (s subnetsById) Less(i, j int) bool {
+	return s[i].Id < s[j].Id
+}
+
+func (s subnetsById) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// subnetVisible reports whether a subnet may be seen by requests
+// scoped to the given tenant. The subnets of shared networks are
+// visible to all tenants. It must be called with n.mu held.
+func (n *Neutron) subnetVisible(subnet Subnet, tenantId string) bool {
+	return tenantId == "" || subnet.TenantId == tenantId || n.networks[subnet.NetworkId].Shared
+}
+
+// allSubnets returns a list of all the existing subnets visible to
+// the given tenant, or of all subnets if it is empty, ordered by id.
+func (n *Neutron) allSubnets(tenantId string) []Subnet {
+	n.mu.Lock()
+	subnets := make([]Subnet, 0, len(n.subnets))
+	for _, subnet := range n.subnets {
+		if n.subnetVisible(subnet, tenantId) {
+			subnets = append(subnets, subnet)
+		}
+	}
+	n.mu.Unlock()
+	sort.Sort(subnetsById(subnets))
+	return subnets
+}
+
+// removeSubnet deletes an existing subnet, removing it from its
+// network.
+func (n *Neutron) removeSubnet(subnetId string) error {
+	if err := n.ProcessFunctionHook(n, subnetId); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	subnet, ok := n.subnets[subnetId]
+	if !ok {
+		return errSubnetNotFound(subnetId)
+	}
+	if network, ok := n.networks[subnet.NetworkId]; ok {
+		subnets := make([]string, 0, len(network.Subnets))
+		for _, id := range network.Subnets {
+			if id != subnetId {
+				subnets = append(subnets, id)
+			}
+		}
+		network.Subnets = subnets
+		n.networks[network.Id] = network
+	}
+	delete(n.subnets, subnetId)
+	return nil
+}
@@ -0,0 +1,354 @@
+// Neutron double testing service - HTTP API implementation
+
+package networkservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/goose.v1/testservices"
+)
+
+const authToken = "X-Auth-Token"
+
+// unauthorizedResponse is the verbatim body of a real Neutron 401.
+const unauthorizedResponse = `{"error": {"message": "The request you have made requires authentication.", "code": 401, "title": "Unauthorized"}}`
+
+type neutronHandler struct {
+	n      *Neutron
+	method func(n *Neutron, w http.ResponseWriter, r *http.Request) error
+	// methods holds the HTTP methods supported by the route, as
+	// reported in response to OPTIONS requests.
+	methods []string
+}
+
+func (h *neutronHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.n.DelayResponse(r); err != nil {
+		// The client has given up on the request.
+		return
+	}
+	h.n.AddResponseHeaders(w)
+	if h.n.HandleOptions(w, r, h.methods...) {
+		return
+	}
+	// handle invalid X-Auth-Token header
+	token := r.Header.Get(authToken)
+	user, err := h.n.IdentityService.FindUser(token)
+	if err != nil || h.n.TokenExpired(token) {
+		w.Header().Set("Content-Type", "application/json")
+		writeResponse(w, http.StatusUnauthorized, []byte(unauthorizedResponse))
+		return
+	}
+	if err := h.n.CheckRole(r, user); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.n.CheckRateLimit(r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.n.LimitRequestBody(w, r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	err = h.method(h.n, w, r)
+	if err == nil {
+		return
+	}
+	resp, ok := err.(http.Handler)
+	if !ok {
+		resp = neutronErrorf(http.StatusInternalServerError, "HTTPInternalServerError", "%s", err.Error())
+	}
+	resp.ServeHTTP(w, r)
+}
+
+func writeResponse(w http.ResponseWriter, code int, body []byte) {
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// sendJSON sends the specified response serialized as JSON.
+func sendJSON(code int, resp interface{}, w http.ResponseWriter, r *http.Request) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, code, data)
+	return nil
+}
+
+// handler returns an http.Handler for a route supporting the given
+// HTTP methods, served by the given method.
+func (n *Neutron) handler(method func(n *Neutron, w http.ResponseWriter, r *http.Request) error, methods ...string) http.Handler {
+	return &neutronHandler{n, method, methods}
+}
+
+// resourceId returns the id of the resource addressed by the request,
+// e.g. "/v2.0/networks/1" gives "1", or "" if the request is for the
+// collection itself. An error is returned for paths with more
+// components.
+func (n *Neutron) resourceId(r *http.Request, collection string) (string, error) {
+	prefix := fmt.Sprintf("/%s/%s", n.VersionPath, collection)
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if strings.Contains(rest, "/") {
+		return "", errNotFound(r.URL.Path)
+	}
+	return rest, nil
+}
+
+// tenantScope returns the tenant of the caller's token, and the tenant
+// whose resources the request may see, as returned by
+// testservices.TenantScope.
+func (n *Neutron) tenantScope(r *http.Request) (tenantId, scope string, err error) {
+	user, err := n.IdentityService.FindUser(r.Header.Get(authToken))
+	if err != nil {
+		return "", "", err
+	}
+	scope, err = testservices.TenantScope(r, user)
+	if err != nil {
+		return "", "", errBadRequest(err.Error())
+	}
+	return user.TenantId, scope, nil
+}
+
+// ownedNetwork retrieves an existing network which may be modified by
+// requests scoped to the given tenant. Other tenants' networks are
+// reported as not found, even if they are shared.
+func (n *Neutron) ownedNetwork(networkId, scope string) (*Network, error) {
+	network, err := n.network(networkId)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" && network.TenantId != scope {
+		return nil, errNetworkNotFound(networkId)
+	}
+	return network, nil
+}
+
+// handleNetworks handles the networks HTTP API. As in Neutron, each
+// tenant sees only its own networks and those which are shared.
+func (n *Neutron) handleNetworks(w http.ResponseWriter, r *http.Request) error {
+	networkId, err := n.resourceId(r, "networks")
+	if err != nil {
+		return err
+	}
+	tenantId, scope, err := n.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	switch {
+	case networkId == "" && r.Method == "GET":
+		resp := struct {
+			Networks []Network `json:"networks"`
+		}{n.allNetworks(scope)}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case networkId == "" && r.Method == "POST":
+		return n.createNetwork(tenantId, w, r)
+	case networkId != "" && r.Method == "GET":
+		network, err := n.network(networkId)
+		if err != nil {
+			return err
+		}
+		if !networkVisible(*network, scope) {
+			return errNetworkNotFound(networkId)
+		}
+		resp := struct {
+			Network Network `json:"network"`
+		}{*network}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case networkId != "" && r.Method == "DELETE":
+		if _, err := n.ownedNetwork(networkId, scope); err != nil {
+			return err
+		}
+		if err := n.removeNetwork(networkId); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	if networkId == "" {
+		return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "POST")
+	}
+	return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "DELETE")
+}
+
+// createNetwork handles a request to create a network, which belongs
+// to the given tenant.
+func (n *Neutron) createNetwork(tenantId string, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Network *struct {
+			Name         string `json:"name"`
+			AdminStateUp *bool  `json:"admin_state_up"`
+			Shared       bool   `json:"shared"`
+			External     bool   `json:"router:external"`
+		} `json:"network"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Network == nil {
+		return errBadRequest("Malformed request body")
+	}
+	id, err := n.NewID(testservices.ResourceNetwork)
+	if err != nil {
+		return err
+	}
+	network := Network{
+		Id:           id,
+		Name:         req.Network.Name,
+		TenantId:     tenantId,
+		Status:       StatusActive,
+		AdminStateUp: req.Network.AdminStateUp == nil || *req.Network.AdminStateUp,
+		Shared:       req.Network.Shared,
+		External:     req.Network.External,
+	}
+	if err := n.addNetwork(network); err != nil {
+		return err
+	}
+	created, err := n.network(id)
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		Network Network `json:"network"`
+	}{*created}
+	return sendJSON(http.StatusCreated, resp, w, r)
+}
+
+// visibleSubnet retrieves an existing subnet, which must be visible
+// to requests scoped to the given tenant. If owned is set, it must
+// belong to the tenant, even if its network is shared.
+func (n *Neutron) visibleSubnet(subnetId, scope string, owned bool) (*Subnet, error) {
+	subnet, err := n.subnet(subnetId)
+	if err != nil {
+		return nil, err
+	}
+	n.mu.Lock()
+	visible := n.subnetVisible(*subnet, scope)
+	n.mu.Unlock()
+	if !visible || owned && scope != "" && subnet.TenantId != scope {
+		return nil, errSubnetNotFound(subnetId)
+	}
+	return subnet, nil
+}
+
+// handleSubnets handles the subnets HTTP API. Subnets are isolated by
+// tenant as networks are.
+func (n *Neutron) handleSubnets(w http.ResponseWriter, r *http.Request) error {
+	subnetId, err := n.resourceId(r, "subnets")
+	if err != nil {
+		return err
+	}
+	tenantId, scope, err := n.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	switch {
+	case subnetId == "" && r.Method == "GET":
+		resp := struct {
+			Subnets []Subnet `json:"subnets"`
+		}{n.allSubnets(scope)}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case subnetId == "" && r.Method == "POST":
+		return n.createSubnet(tenantId, scope, w, r)
+	case subnetId != "" && r.Method == "GET":
+		subnet, err := n.visibleSubnet(subnetId, scope, false)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			Subnet Subnet `json:"subnet"`
+		}{*subnet}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case subnetId != "" && r.Method == "DELETE":
+		if _, err := n.visibleSubnet(subnetId, scope, true); err != nil {
+			return err
+		}
+		if err := n.removeSubnet(subnetId); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	if subnetId == "" {
+		return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "POST")
+	}
+	return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "DELETE")
+}
+
+// createSubnet handles a request to create a subnet, which belongs to
+// the given tenant, on a network within the scope of the request.
+func (n *Neutron) createSubnet(tenantId, scope string, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Subnet *struct {
+			Name       string `json:"name"`
+			NetworkId  string `json:"network_id"`
+			Cidr       string `json:"cidr"`
+			IPVersion  int    `json:"ip_version"`
+			GatewayIP  string `json:"gateway_ip"`
+			EnableDHCP *bool  `json:"enable_dhcp"`
+		} `json:"subnet"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Subnet == nil {
+		return errBadRequest("Malformed request body")
+	}
+	if req.Subnet.NetworkId == "" {
+		return errBadRequest("Failed to parse request. Required attribute 'network_id' not specified")
+	}
+	if req.Subnet.Cidr == "" {
+		return errBadRequest("Failed to parse request. Required attribute 'cidr' not specified")
+	}
+	if _, err := n.ownedNetwork(req.Subnet.NetworkId, scope); err != nil {
+		return err
+	}
+	id, err := n.NewID(testservices.ResourceSubnet)
+	if err != nil {
+		return err
+	}
+	subnet := Subnet{
+		Id:         id,
+		Name:       req.Subnet.Name,
+		TenantId:   tenantId,
+		NetworkId:  req.Subnet.NetworkId,
+		Cidr:       req.Subnet.Cidr,
+		IPVersion:  req.Subnet.IPVersion,
+		GatewayIP:  req.Subnet.GatewayIP,
+		EnableDHCP: req.Subnet.EnableDHCP == nil || *req.Subnet.EnableDHCP,
+	}
+	if err := n.addSubnet(subnet); err != nil {
+		return err
+	}
+	created, err := n.subnet(id)
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		Subnet Subnet `json:"subnet"`
+	}{*created}
+	return sendJSON(http.StatusCreated, resp, w, r)
+}
+
+// SetupHTTP attaches all the needed handlers to provide the HTTP API.
+func (n *Neutron) SetupHTTP(mux *http.ServeMux) {
+	handlers := map[string]http.Handler{
+		"/$v/networks": n.handler((*Neutron).handleNetworks, "GET", "POST", "DELETE"),
+		"/$v/subnets":  n.handler((*Neutron).handleSubnets, "GET", "POST", "DELETE"),
+	}
+	for path, h := range handlers {
+		path = strings.Replace(path, "$v", n.VersionPath, 1)
+		mux.Handle(path, h)
+		mux.Handle(path+"/", h)
+	}
+}
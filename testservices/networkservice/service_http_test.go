@@ -0,0 +1,298 @@
+// Neutron double testing service - HTTP API tests
+
+package networkservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+type NeutronHTTPSuite struct {
+	httpsuite.HTTPSuite
+	service *Neutron
+	token   string
+}
+
+var _ = gc.Suite(&NeutronHTTPSuite{})
+
+func (s *NeutronHTTPSuite) SetUpTest(c *gc.C) {
+	s.HTTPSuite.SetUpTest(c)
+	identityDouble := identityservice.NewUserPass()
+	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
+	s.token = userInfo.Token
+	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
+	identityDouble.SetupHTTP(s.Mux)
+	s.service.SetupHTTP(s.Mux)
+}
+
+// jsonRequest sends the given body, if any, as JSON to path, relative
+// to the versioned service endpoint, using the suite's token.
+func (s *NeutronHTTPSuite) jsonRequest(c *gc.C, method, path string, body interface{}) *http.Response {
+	var jsonBody []byte
+	if body != nil {
+		var err error
+		jsonBody, err = json.Marshal(body)
+		c.Assert(err, gc.IsNil)
+	}
+	URL := s.service.endpointURL() + versionPath + path
+	req, err := http.NewRequest(method, URL, bytes.NewReader(jsonBody))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(authToken, s.token)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	return resp
+}
+
+func assertJSON(c *gc.C, resp *http.Response, code int, result interface{}) {
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, code)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json")
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	err = json.Unmarshal(body, result)
+	c.Assert(err, gc.IsNil)
+}
+
+func assertNeutronError(c *gc.C, resp *http.Response, code int, kind, message string) {
+	var result struct {
+		NeutronError struct {
+			Type    string
+			Message string
+		}
+	}
+	assertJSON(c, resp, code, &result)
+	c.Assert(result.NeutronError.Type, gc.Equals, kind)
+	c.Assert(result.NeutronError.Message, gc.Matches, message)
+}
+
+func (s *NeutronHTTPSuite) createNetwork(c *gc.C, name string) Network {
+	var result struct {
+		Network Network `json:"network"`
+	}
+	body := map[string]interface{}{"network": map[string]interface{}{"name": name}}
+	assertJSON(c, s.jsonRequest(c, "POST", "/networks", body), http.StatusCreated, &result)
+	return result.Network
+}
+
+func (s *NeutronHTTPSuite) createSubnet(c *gc.C, networkId, cidr string) *http.Response {
+	body := map[string]interface{}{"subnet": map[string]interface{}{
+		"network_id": networkId,
+		"cidr":       cidr,
+	}}
+	return s.jsonRequest(c, "POST", "/subnets", body)
+}
+
+func (s *NeutronHTTPSuite) TestUnauthorized(c *gc.C) {
+	s.token = "bad-token"
+	resp := s.jsonRequest(c, "GET", "/networks", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+}
+
+func (s *NeutronHTTPSuite) TestCatalog(c *gc.C) {
+	creds := identity.Credentials{User: "fred", Secrets: "secret", URL: s.Server.URL + "/tokens"}
+	auth, err := (&identity.UserPass{}).Auth(&creds)
+	c.Assert(err, gc.IsNil)
+	c.Assert(auth.RegionServiceURLs[region]["network"], gc.Equals, s.Server.URL+"/")
+}
+
+func (s *NeutronHTTPSuite) TestCreateListShowDeleteNetwork(c *gc.C) {
+	network := s.createNetwork(c, "private")
+	c.Assert(network.Id, gc.Not(gc.Equals), "")
+	c.Assert(network.Name, gc.Equals, "private")
+	c.Assert(network.Status, gc.Equals, StatusActive)
+	c.Assert(network.AdminStateUp, gc.Equals, true)
+	c.Assert(network.TenantId, gc.Equals, s.service.TenantId)
+	c.Assert(network.Subnets, gc.DeepEquals, []string{})
+
+	var list struct {
+		Networks []Network `json:"networks"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/networks", nil), http.StatusOK, &list)
+	c.Assert(list.Networks, gc.DeepEquals, []Network{network})
+
+	var show struct {
+		Network Network `json:"network"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/networks/"+network.Id, nil), http.StatusOK, &show)
+	c.Assert(show.Network, gc.DeepEquals, network)
+
+	resp := s.jsonRequest(c, "DELETE", "/networks/"+network.Id, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp = s.jsonRequest(c, "GET", "/networks/"+network.Id, nil)
+	assertNeutronError(c, resp, http.StatusNotFound, "NetworkNotFound", "Network .* could not be found.")
+}
+
+func (s *NeutronHTTPSuite) TestCreateNetworkBadBody(c *gc.C) {
+	resp := s.jsonRequest(c, "POST", "/networks", map[string]string{"foo": "bar"})
+	assertNeutronError(c, resp, http.StatusBadRequest, "HTTPBadRequest", "Malformed request body")
+}
+
+func (s *NeutronHTTPSuite) TestCreateListShowDeleteSubnet(c *gc.C) {
+	network := s.createNetwork(c, "private")
+	var created struct {
+		Subnet Subnet `json:"subnet"`
+	}
+	assertJSON(c, s.createSubnet(c, network.Id, "192.168.0.0/24"), http.StatusCreated, &created)
+	subnet := created.Subnet
+	c.Assert(subnet.Id, gc.Not(gc.Equals), "")
+	c.Assert(subnet.NetworkId, gc.Equals, network.Id)
+	c.Assert(subnet.Cidr, gc.Equals, "192.168.0.0/24")
+	c.Assert(subnet.IPVersion, gc.Equals, 4)
+	c.Assert(subnet.GatewayIP, gc.Equals, "192.168.0.1")
+	c.Assert(subnet.EnableDHCP, gc.Equals, true)
+
+	var show struct {
+		Network Network `json:"network"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/networks/"+network.Id, nil), http.StatusOK, &show)
+	c.Assert(show.Network.Subnets, gc.DeepEquals, []string{subnet.Id})
+
+	var list struct {
+		Subnets []Subnet `json:"subnets"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/subnets", nil), http.StatusOK, &list)
+	c.Assert(list.Subnets, gc.DeepEquals, []Subnet{subnet})
+
+	var showSubnet struct {
+		Subnet Subnet `json:"subnet"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/subnets/"+subnet.Id, nil), http.StatusOK, &showSubnet)
+	c.Assert(showSubnet.Subnet, gc.DeepEquals, subnet)
+
+	resp := s.jsonRequest(c, "DELETE", "/subnets/"+subnet.Id, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp = s.jsonRequest(c, "GET", "/subnets/"+subnet.Id, nil)
+	assertNeutronError(c, resp, http.StatusNotFound, "SubnetNotFound", "Subnet .* could not be found.")
+}
+
+func (s *NeutronHTTPSuite) TestDeleteNetworkWithSubnets(c *gc.C) {
+	network := s.createNetwork(c, "private")
+	var created struct {
+		Subnet Subnet `json:"subnet"`
+	}
+	assertJSON(c, s.createSubnet(c, network.Id, "192.168.0.0/24"), http.StatusCreated, &created)
+	resp := s.jsonRequest(c, "DELETE", "/networks/"+network.Id, nil)
+	assertNeutronError(c, resp, http.StatusConflict, "NetworkInUse",
+		"Unable to complete operation on network "+network.Id+". There are one or more subnets still in use on the network.")
+
+	resp = s.jsonRequest(c, "DELETE", "/subnets/"+created.Subnet.Id, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp = s.jsonRequest(c, "DELETE", "/networks/"+network.Id, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+}
+
+func (s *NeutronHTTPSuite) TestCreateSubnetBadCIDR(c *gc.C) {
+	network := s.createNetwork(c, "private")
+	resp := s.createSubnet(c, network.Id, "192.168.0.0/24")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+
+	resp = s.createSubnet(c, network.Id, "192.168.0.0/33")
+	assertNeutronError(c, resp, http.StatusBadRequest, "HTTPBadRequest", "Invalid input for cidr.*")
+	resp = s.createSubnet(c, network.Id, "192.168.0.0/23")
+	assertNeutronError(c, resp, http.StatusBadRequest, "BadRequest", ".*overlaps with another subnet.")
+	resp = s.createSubnet(c, network.Id, "")
+	assertNeutronError(c, resp, http.StatusBadRequest, "HTTPBadRequest", ".*Required attribute 'cidr' not specified")
+	resp = s.createSubnet(c, "no-such-network", "10.0.0.0/8")
+	assertNeutronError(c, resp, http.StatusNotFound, "NetworkNotFound", "Network no-such-network could not be found.")
+}
+
+func (s *NeutronHTTPSuite) TestBadPaths(c *gc.C) {
+	resp := s.jsonRequest(c, "GET", "/networks/1/foo", nil)
+	assertNeutronError(c, resp, http.StatusNotFound, "HTTPNotFound", ".*")
+	resp = s.jsonRequest(c, "PUT", "/networks", map[string]string{})
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, POST")
+	assertNeutronError(c, resp, http.StatusMethodNotAllowed, "HTTPMethodNotAllowed", ".*")
+	resp = s.jsonRequest(c, "PUT", "/subnets/1", map[string]string{})
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, DELETE")
+	assertNeutronError(c, resp, http.StatusMethodNotAllowed, "HTTPMethodNotAllowed", "Method PUT is not allowed for /v2.0/subnets/1")
+}
+
+// addTenantUsers adds a user of another tenant, and an admin of a third
+// tenant, to the identity double.
+func (s *NeutronHTTPSuite) addTenantUsers(c *gc.C) (other, admin *identityservice.UserInfo) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other = identityDouble.AddUser("other", "secret", "other-tenant")
+	admin = identityDouble.AddUser("admin", "secret", "admin-tenant")
+	err := identityDouble.SetUserTenant("admin", admin.TenantId, "admin-tenant", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	return other, admin
+}
+
+// assertNetworkIds checks that the networks listed with the given
+// query are those with the given ids, in any order.
+func (s *NeutronHTTPSuite) assertNetworkIds(c *gc.C, query string, expected ...string) {
+	var list struct {
+		Networks []Network `json:"networks"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/networks"+query, nil), http.StatusOK, &list)
+	var ids []string
+	for _, network := range list.Networks {
+		ids = append(ids, network.Id)
+	}
+	sort.Strings(ids)
+	sort.Strings(expected)
+	c.Assert(ids, gc.DeepEquals, expected)
+}
+
+func (s *NeutronHTTPSuite) TestTenantIsolation(c *gc.C) {
+	other, admin := s.addTenantUsers(c)
+	private := s.createNetwork(c, "private")
+	var result struct {
+		Network Network `json:"network"`
+	}
+	body := map[string]interface{}{"network": map[string]interface{}{"name": "public", "shared": true}}
+	assertJSON(c, s.jsonRequest(c, "POST", "/networks", body), http.StatusCreated, &result)
+	shared := result.Network
+	resp := s.createSubnet(c, private.Id, "10.0.0.0/24")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	owner := s.token
+
+	s.token = other.Token
+	s.assertNetworkIds(c, "", shared.Id)
+	s.assertNetworkIds(c, "?all_tenants=1", shared.Id)
+	var subnets struct {
+		Subnets []Subnet `json:"subnets"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/subnets", nil), http.StatusOK, &subnets)
+	c.Assert(subnets.Subnets, gc.HasLen, 0)
+	resp = s.jsonRequest(c, "GET", "/networks/"+private.Id, nil)
+	assertNeutronError(c, resp, http.StatusNotFound, "NetworkNotFound", "Network .* could not be found.")
+	resp = s.jsonRequest(c, "DELETE", "/networks/"+shared.Id, nil)
+	assertNeutronError(c, resp, http.StatusNotFound, "NetworkNotFound", "Network .* could not be found.")
+	resp = s.createSubnet(c, shared.Id, "10.0.1.0/24")
+	assertNeutronError(c, resp, http.StatusNotFound, "NetworkNotFound", "Network .* could not be found.")
+
+	// The other tenant's networks, when created, are its own.
+	mine := s.createNetwork(c, "mine")
+	c.Assert(mine.TenantId, gc.Equals, other.TenantId)
+	s.token = owner
+	s.assertNetworkIds(c, "", private.Id, shared.Id)
+
+	s.token = admin.Token
+	s.assertNetworkIds(c, "", shared.Id)
+	s.assertNetworkIds(c, "?all_tenants=1", private.Id, shared.Id, mine.Id)
+	resp = s.jsonRequest(c, "DELETE", "/networks/"+mine.Id+"?all_tenants=1", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp = s.jsonRequest(c, "GET", "/networks?all_tenants=maybe", nil)
+	assertNeutronError(c, resp, http.StatusBadRequest, "HTTPBadRequest", "Invalid value 'maybe' for all_tenants")
+}
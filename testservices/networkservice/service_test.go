@@ -0,0 +1,174 @@
+// Neutron double testing service - internal direct API tests
+
+package networkservice
+
+import (
+	gc "gopkg.in/check.v1"
+)
+
+type NeutronSuite struct {
+	service *Neutron
+}
+
+const (
+	versionPath = "v2.0"
+	hostname    = "http://example.com"
+	region      = "region"
+)
+
+var _ = gc.Suite(&NeutronSuite{})
+
+func (s *NeutronSuite) SetUpTest(c *gc.C) {
+	s.service = New(hostname, versionPath, "tenant", region, nil)
+}
+
+func (s *NeutronSuite) addNetwork(c *gc.C, id string) {
+	err := s.service.addNetwork(Network{Id: id, Name: "net-" + id})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NeutronSuite) TestEndpoints(c *gc.C) {
+	endpoints := s.service.Endpoints()
+	c.Assert(endpoints, gc.HasLen, 1)
+	c.Assert(endpoints[0].PublicURL, gc.Equals, "http://example.com/")
+	c.Assert(endpoints[0].Region, gc.Equals, region)
+}
+
+func (s *NeutronSuite) TestAddGetRemoveNetwork(c *gc.C) {
+	s.addNetwork(c, "1")
+	network, err := s.service.network("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(network.Name, gc.Equals, "net-1")
+	c.Assert(network.Subnets, gc.DeepEquals, []string{})
+	err = s.service.addNetwork(Network{Id: "1"})
+	c.Assert(err, gc.ErrorMatches, "Conflict: A network with id 1 already exists.")
+	err = s.service.removeNetwork("1")
+	c.Assert(err, gc.IsNil)
+	_, err = s.service.network("1")
+	c.Assert(err, gc.ErrorMatches, "NetworkNotFound: Network 1 could not be found.")
+	err = s.service.removeNetwork("1")
+	c.Assert(err, gc.ErrorMatches, "NetworkNotFound: Network 1 could not be found.")
+}
+
+func (s *NeutronSuite) TestAllNetworksSorted(c *gc.C) {
+	s.addNetwork(c, "2")
+	s.addNetwork(c, "3")
+	s.addNetwork(c, "1")
+	networks := s.service.allNetworks("")
+	c.Assert(networks, gc.HasLen, 3)
+	for i, network := range networks {
+		c.Assert(network.Name, gc.Equals, []string{"net-1", "net-2", "net-3"}[i])
+	}
+}
+
+func (s *NeutronSuite) TestAddSubnet(c *gc.C) {
+	s.addNetwork(c, "1")
+	err := s.service.addSubnet(Subnet{Id: "sub", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+	subnet, err := s.service.subnet("sub")
+	c.Assert(err, gc.IsNil)
+	c.Assert(subnet.IPVersion, gc.Equals, 4)
+	c.Assert(subnet.GatewayIP, gc.Equals, "10.0.0.1")
+	network, err := s.service.network("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(network.Subnets, gc.DeepEquals, []string{"sub"})
+	err = s.service.addSubnet(Subnet{Id: "sub6", NetworkId: "1", Cidr: "2001:db8::/64"})
+	c.Assert(err, gc.IsNil)
+	subnet, err = s.service.subnet("sub6")
+	c.Assert(err, gc.IsNil)
+	c.Assert(subnet.IPVersion, gc.Equals, 6)
+	c.Assert(subnet.GatewayIP, gc.Equals, "2001:db8::1")
+}
+
+var badSubnetTests = []struct {
+	subnet Subnet
+	err    string
+}{{
+	subnet: Subnet{Id: "x", NetworkId: "2", Cidr: "10.1.0.0/24"},
+	err:    "NetworkNotFound: Network 2 could not be found.",
+}, {
+	subnet: Subnet{Id: "x", NetworkId: "1", Cidr: "10.1.0.0"},
+	err:    "HTTPBadRequest: .* '10.1.0.0' is not a valid IP subnet.",
+}, {
+	subnet: Subnet{Id: "x", NetworkId: "1", Cidr: "10.1.0.300/24"},
+	err:    "HTTPBadRequest: .* '10.1.0.300/24' is not a valid IP subnet.",
+}, {
+	subnet: Subnet{Id: "x", NetworkId: "1", Cidr: "10.1.0.1/24"},
+	err:    "HTTPBadRequest: .* '10.1.0.1/24' isn't a recognized IP subnet cidr, '10.1.0.0/24' is recommended.",
+}, {
+	subnet: Subnet{Id: "x", NetworkId: "1", Cidr: "10.0.0.128/25"},
+	err:    "BadRequest: .* cidr: 10.0.0.128/25 for network: 1 overlaps with another subnet.",
+}, {
+	subnet: Subnet{Id: "x", NetworkId: "1", Cidr: "10.0.0.0/16"},
+	err:    "BadRequest: .* cidr: 10.0.0.0/16 for network: 1 overlaps with another subnet.",
+}, {
+	subnet: Subnet{Id: "x", NetworkId: "1", Cidr: "10.1.0.0/24", IPVersion: 6},
+	err:    "BadRequest: .* Cidr 10.1.0.0/24 is not valid for IP version 6.",
+}, {
+	subnet: Subnet{Id: "sub", NetworkId: "1", Cidr: "10.1.0.0/24"},
+	err:    "Conflict: A subnet with id sub already exists.",
+}}
+
+func (s *NeutronSuite) TestAddBadSubnet(c *gc.C) {
+	s.addNetwork(c, "1")
+	err := s.service.addSubnet(Subnet{Id: "sub", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+	for i, t := range badSubnetTests {
+		c.Logf("test %d: %s", i, t.subnet.Cidr)
+		err := s.service.addSubnet(t.subnet)
+		c.Check(err, gc.ErrorMatches, t.err)
+	}
+	c.Assert(s.service.allSubnets(""), gc.HasLen, 1)
+}
+
+func (s *NeutronSuite) TestOverlapIsPerNetwork(c *gc.C) {
+	s.addNetwork(c, "1")
+	s.addNetwork(c, "2")
+	err := s.service.addSubnet(Subnet{Id: "sub1", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+	err = s.service.addSubnet(Subnet{Id: "sub2", NetworkId: "2", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NeutronSuite) TestRemoveSubnet(c *gc.C) {
+	s.addNetwork(c, "1")
+	err := s.service.addSubnet(Subnet{Id: "sub1", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+	err = s.service.addSubnet(Subnet{Id: "sub2", NetworkId: "1", Cidr: "10.0.1.0/24"})
+	c.Assert(err, gc.IsNil)
+	err = s.service.removeSubnet("sub1")
+	c.Assert(err, gc.IsNil)
+	network, err := s.service.network("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(network.Subnets, gc.DeepEquals, []string{"sub2"})
+	_, err = s.service.subnet("sub1")
+	c.Assert(err, gc.ErrorMatches, "SubnetNotFound: Subnet sub1 could not be found.")
+	// The CIDR of a removed subnet can be reused.
+	err = s.service.addSubnet(Subnet{Id: "sub3", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NeutronSuite) TestRemoveNetworkWithSubnetsFails(c *gc.C) {
+	s.addNetwork(c, "1")
+	err := s.service.addSubnet(Subnet{Id: "sub", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+	err = s.service.removeNetwork("1")
+	c.Assert(err, gc.ErrorMatches, "NetworkInUse: Unable to complete operation on network 1. There are one or more subnets still in use on the network.")
+	_, err = s.service.network("1")
+	c.Assert(err, gc.IsNil)
+
+	err = s.service.removeSubnet("sub")
+	c.Assert(err, gc.IsNil)
+	err = s.service.removeNetwork("1")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NeutronSuite) TestReset(c *gc.C) {
+	s.addNetwork(c, "1")
+	err := s.service.addSubnet(Subnet{Id: "sub", NetworkId: "1", Cidr: "10.0.0.0/24"})
+	c.Assert(err, gc.IsNil)
+	s.service.Reset()
+	c.Assert(s.service.allNetworks(""), gc.HasLen, 0)
+	c.Assert(s.service.allSubnets(""), gc.HasLen, 0)
+	s.addNetwork(c, "1")
+}
@@ -3,18 +3,18 @@
 package novaservice
 
 import (
-	"crypto/rand"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"gopkg.in/goose.v1/errors"
 	"gopkg.in/goose.v1/nova"
 	"gopkg.in/goose.v1/testservices"
 	"gopkg.in/goose.v1/testservices/identityservice"
@@ -116,6 +116,30 @@ This server could not verify that you are authorized to access the ` +
 		nil,
 		nil,
 	}
+	errBadRequestSrvFlavorNotFound = &errorResponse{
+		http.StatusBadRequest,
+		`{"badRequest": {"message": "Invalid flavorRef provided.", "code": 400}}`,
+		"application/json; charset=UTF-8",
+		"bad request - unknown flavorRef",
+		nil,
+		nil,
+	}
+	errBadRequestSrvImageNotFound = &errorResponse{
+		http.StatusBadRequest,
+		`{"badRequest": {"message": "Can not find requested image", "code": 400}}`,
+		"application/json; charset=UTF-8",
+		"bad request - unknown imageRef",
+		nil,
+		nil,
+	}
+	errBadRequestBootSequence = &errorResponse{
+		http.StatusBadRequest,
+		`{"badRequest": {"message": "Block Device Mapping is Invalid: Boot sequence for the instance and image/block device mapping combination is not valid.", "code": 400}}`,
+		"application/json; charset=UTF-8",
+		"bad request - invalid boot sequence",
+		nil,
+		nil,
+	}
 	errNotFound = &errorResponse{
 		http.StatusNotFound,
 		`404 Not Found
@@ -272,9 +296,134 @@ func (e *errorResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// errMethodNotAllowed returns the response to a request whose method
+// is not one of those allowed, which are listed in the Allow header.
+func errMethodNotAllowed(allowed ...string) *errorResponse {
+	return &errorResponse{
+		http.StatusMethodNotAllowed,
+		`{"badMethod": {"message": "The method specified is not allowed for this resource.", "code": 405}}`,
+		"application/json; charset=UTF-8",
+		"method not allowed",
+		map[string]string{"Allow": strings.Join(allowed, ", ")},
+		nil,
+	}
+}
+
 type novaHandler struct {
 	n      *Nova
 	method func(n *Nova, w http.ResponseWriter, r *http.Request) error
+	// methods holds the HTTP methods supported by the route, as
+	// reported in response to OPTIONS requests.
+	methods []string
+}
+
+// A microversion is a version of the compute API, as negotiated with
+// the OpenStack-API-Version header.
+type microversion struct {
+	major, minor int
+}
+
+func (v microversion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+// less reports whether v is older than other.
+func (v microversion) less(other microversion) bool {
+	return v.major < other.major || v.major == other.major && v.minor < other.minor
+}
+
+// The range of microversions the double supports, and the
+// microversions at which the features gated on them were introduced.
+// Requests which do not ask for a version get the minimum, as in Nova.
+var (
+	minMicroversion = microversion{2, 1}
+	maxMicroversion = microversion{2, 60}
+
+	// Server groups with the soft-affinity and soft-anti-affinity
+	// policies.
+	softAffinityMicroversion = microversion{2, 15}
+	// Server tags, and the tag filters of the server list.
+	serverTagsMicroversion = microversion{2, 26}
+	// The structured form of server diagnostics.
+	diagnosticsMicroversion = microversion{2, 48}
+)
+
+// The headers with which clients ask for a microversion. The legacy
+// header is only used if the other is not given.
+const (
+	apiVersionHeader       = "OpenStack-API-Version"
+	legacyAPIVersionHeader = "X-OpenStack-Nova-API-Version"
+)
+
+// microversionKey is the key of the negotiated microversion in a
+// request's context.
+type microversionKey struct{}
+
+// parseMicroversion parses a requested microversion, which may be
+// "latest" to ask for the newest version supported.
+func parseMicroversion(s string) (microversion, error) {
+	if s == "latest" {
+		return maxMicroversion, nil
+	}
+	var v microversion
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return v, testservices.NewInvalidMicroversionError(s)
+	}
+	var err1, err2 error
+	v.major, err1 = strconv.Atoi(parts[0])
+	v.minor, err2 = strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || v.major < 0 || v.minor < 0 {
+		return v, testservices.NewInvalidMicroversionError(s)
+	}
+	if v.less(minMicroversion) || maxMicroversion.less(v) {
+		return v, testservices.NewUnsupportedMicroversionError(s, minMicroversion.String(), maxMicroversion.String())
+	}
+	return v, nil
+}
+
+// requestedMicroversion returns the compute microversion asked for in
+// the request's headers, or "" if none is.
+func requestedMicroversion(r *http.Request) string {
+	for _, value := range strings.Split(r.Header.Get(apiVersionHeader), ",") {
+		fields := strings.Fields(value)
+		if len(fields) == 2 && strings.EqualFold(fields[0], "compute") {
+			return fields[1]
+		}
+	}
+	return strings.TrimSpace(r.Header.Get(legacyAPIVersionHeader))
+}
+
+// negotiateMicroversion returns the request with the microversion it
+// asks for stored in its context, and reports that version in the
+// response headers.
+func negotiateMicroversion(w http.ResponseWriter, r *http.Request) (*http.Request, error) {
+	v := minMicroversion
+	if requested := requestedMicroversion(r); requested != "" {
+		var err error
+		if v, err = parseMicroversion(requested); err != nil {
+			return r, err
+		}
+	}
+	w.Header().Set(apiVersionHeader, "compute "+v.String())
+	w.Header().Set(legacyAPIVersionHeader, v.String())
+	w.Header().Add("Vary", apiVersionHeader+", "+legacyAPIVersionHeader)
+	return r.WithContext(context.WithValue(r.Context(), microversionKey{}, v)), nil
+}
+
+// requestMicroversion returns the microversion negotiated for the
+// request.
+func requestMicroversion(r *http.Request) microversion {
+	if v, ok := r.Context().Value(microversionKey{}).(microversion); ok {
+		return v
+	}
+	return minMicroversion
+}
+
+// atLeastMicroversion reports whether the microversion negotiated for
+// the request is v or newer.
+func atLeastMicroversion(r *http.Request, v microversion) bool {
+	return !requestMicroversion(r).less(v)
 }
 
 func userInfo(i identityservice.IdentityService, r *http.Request) (*identityservice.UserInfo, error) {
@@ -283,18 +432,48 @@ func userInfo(i identityservice.IdentityService, r *http.Request) (*identityserv
 
 func (h *novaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Path
+	if err := h.n.DelayResponse(r); err != nil {
+		// The client has given up on the request.
+		return
+	}
+	// Nova reports the request's id in its own header too, which
+	// is the one given by a Tracer when there is one.
+	requestId := r.Header.Get(testservices.RequestIdHeader)
+	if requestId == "" {
+		requestId = testservices.NewRequestID()
+	}
+	w.Header().Set("X-Compute-Request-Id", requestId)
+	h.n.AddResponseHeaders(w)
+	if h.n.HandleOptions(w, r, h.methods...) {
+		return
+	}
 	// handle invalid X-Auth-Token header
-	_, err := userInfo(h.n.IdentityService, r)
-	if err != nil {
+	user, err := userInfo(h.n.IdentityService, r)
+	if err != nil || h.n.TokenExpired(r.Header.Get(authToken)) {
 		errUnauthorized.ServeHTTP(w, r)
 		return
 	}
+	if err := h.n.CheckRole(r, user); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.n.CheckRateLimit(r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.n.LimitRequestBody(w, r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
 	// handle trailing slash in the path
 	if strings.HasSuffix(path, "/") && path != "/" {
 		errNotFound.ServeHTTP(w, r)
 		return
 	}
-	err = h.method(h.n, w, r)
+	r, err = negotiateMicroversion(w, r)
+	if err == nil {
+		err = h.method(h.n, w, r)
+	}
 	if err == nil {
 		return
 	}
@@ -340,8 +519,61 @@ func sendJSON(code int, resp interface{}, w http.ResponseWriter, r *http.Request
 	return nil
 }
 
-func (n *Nova) handler(method func(n *Nova, w http.ResponseWriter, r *http.Request) error) http.Handler {
-	return &novaHandler{n, method}
+// pageParams returns the limit and marker query parameters of a list
+// request. A zero limit means the page size is unbounded.
+func pageParams(r *http.Request) (limit int, marker string, err error) {
+	if err := r.ParseForm(); err != nil {
+		return 0, "", err
+	}
+	if s := r.Form.Get(nova.FilterLimit); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 0 {
+			return 0, "", testservices.NewInvalidLimitError(s)
+		}
+	}
+	return limit, r.Form.Get(nova.FilterMarker), nil
+}
+
+// page returns the bounds of the requested page of a list of count
+// items sorted by id, where id(i) returns the id of the i'th item. The
+// page starts after the item with the marker id, if any, and holds at
+// most limit items.
+func page(count int, id func(i int) string, limit int, marker string) (start, end int, err error) {
+	if marker != "" {
+		start = -1
+		for i := 0; i < count; i++ {
+			if id(i) == marker {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return 0, 0, testservices.NewMarkerNotFoundError(marker)
+		}
+	}
+	end = count
+	if limit > 0 && start+limit < count {
+		end = start + limit
+	}
+	return start, end, nil
+}
+
+// nextPageLinks returns the links to the page of a list following the
+// one ending with the item with the given id. The other query
+// parameters of the request are preserved.
+func (n *Nova) nextPageLinks(r *http.Request, listPath, lastId string) []nova.Link {
+	query := r.URL.Query()
+	query.Set(nova.FilterMarker, lastId)
+	return []nova.Link{{
+		Href: n.endpointURL(true, listPath) + "?" + query.Encode(),
+		Rel:  "next",
+	}}
+}
+
+// handler returns an http.Handler for a route supporting the given
+// HTTP methods, served by the given method.
+func (n *Nova) handler(method func(n *Nova, w http.ResponseWriter, r *http.Request) error, methods ...string) http.Handler {
+	return &novaHandler{n, method, methods}
 }
 
 func (n *Nova) handleRoot(w http.ResponseWriter, r *http.Request) error {
@@ -353,6 +585,9 @@ func (n *Nova) handleRoot(w http.ResponseWriter, r *http.Request) error {
 
 // handleFlavors handles the flavors HTTP API.
 func (n *Nova) handleFlavors(w http.ResponseWriter, r *http.Request) error {
+	if strings.Contains(r.URL.Path, "/os-extra_specs") {
+		return n.handleFlavorExtraSpecs(w, r)
+	}
 	switch r.Method {
 	case "GET":
 		if flavorId := path.Base(r.URL.Path); flavorId != "flavors" {
@@ -365,13 +600,27 @@ func (n *Nova) handleFlavors(w http.ResponseWriter, r *http.Request) error {
 			}{*flavor}
 			return sendJSON(http.StatusOK, resp, w, r)
 		}
-		entities := n.allFlavorsAsEntities()
+		limit, marker, err := pageParams(r)
+		if err != nil {
+			return err
+		}
+		entities := flavorsAsEntities(n.matchFlavors(r.Form.Get("name")))
+		start, end, err := page(len(entities), func(i int) string { return entities[i].Id }, limit, marker)
+		if err != nil {
+			return err
+		}
+		var links []nova.Link
+		if end < len(entities) {
+			links = n.nextPageLinks(r, "flavors", entities[end-1].Id)
+		}
+		entities = entities[start:end]
 		if len(entities) == 0 {
 			entities = []nova.Entity{}
 		}
 		resp := struct {
 			Flavors []nova.Entity `json:"flavors"`
-		}{entities}
+			Links   []nova.Link   `json:"flavors_links,omitempty"`
+		}{entities, links}
 		return sendJSON(http.StatusOK, resp, w, r)
 	case "POST":
 		if flavorId := path.Base(r.URL.Path); flavorId != "flavors" {
@@ -396,7 +645,91 @@ func (n *Nova) handleFlavors(w http.ResponseWriter, r *http.Request) error {
 		}
 		return errNotFound
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
+}
+
+// handleFlavorExtraSpecs handles the os-extra_specs HTTP API of a
+// flavor.
+func (n *Nova) handleFlavorExtraSpecs(w http.ResponseWriter, r *http.Request) error {
+	prefix := fmt.Sprintf("/%s/%s/flavors/", n.VersionPath, n.TenantId)
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "os-extra_specs" {
+		return errNotFound
+	}
+	flavorId := parts[0]
+	if len(parts) == 3 {
+		return n.handleFlavorExtraSpec(flavorId, parts[2], w, r)
+	}
+	switch r.Method {
+	case "GET":
+		specs, err := n.flavorExtraSpecs(flavorId)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			ExtraSpecs map[string]string `json:"extra_specs"`
+		}{specs}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "POST":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			ExtraSpecs map[string]string `json:"extra_specs"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.ExtraSpecs == nil {
+			return errBadRequest3
+		}
+		if err := n.setFlavorExtraSpecs(flavorId, req.ExtraSpecs); err != nil {
+			return err
+		}
+		// Nova responds with the extra specs which were set.
+		return sendJSON(http.StatusOK, req, w, r)
+	}
+	return errMethodNotAllowed("GET", "POST")
+}
+
+// handleFlavorExtraSpec handles a single extra spec of a flavor.
+func (n *Nova) handleFlavorExtraSpec(flavorId, key string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		specs, err := n.flavorExtraSpecs(flavorId)
+		if err != nil {
+			return err
+		}
+		value, ok := specs[key]
+		if !ok {
+			return testservices.NewFlavorExtraSpecNotFoundError(flavorId, key)
+		}
+		return sendJSON(http.StatusOK, map[string]string{key: value}, w, r)
+	case "PUT":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req map[string]string
+		if err := json.Unmarshal(body, &req); err != nil || req == nil {
+			return errBadRequest3
+		}
+		if len(req) > 1 {
+			return testservices.NewBadRequestError("Request body contains too many items")
+		}
+		if _, ok := req[key]; !ok {
+			return testservices.NewBadRequestError("Request body and URI mismatch")
+		}
+		if err := n.setFlavorExtraSpecs(flavorId, req); err != nil {
+			return err
+		}
+		return sendJSON(http.StatusOK, req, w, r)
+	case "DELETE":
+		if err := n.removeFlavorExtraSpec(flavorId, key); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "PUT", "DELETE")
 }
 
 // handleFlavorsDetail handles the flavors/detail HTTP API.
@@ -406,13 +739,27 @@ func (n *Nova) handleFlavorsDetail(w http.ResponseWriter, r *http.Request) error
 		if flavorId := path.Base(r.URL.Path); flavorId != "detail" {
 			return errNotFound
 		}
-		flavors := n.allFlavors()
+		limit, marker, err := pageParams(r)
+		if err != nil {
+			return err
+		}
+		flavors := n.matchFlavors(r.Form.Get("name"))
+		start, end, err := page(len(flavors), func(i int) string { return flavors[i].Id }, limit, marker)
+		if err != nil {
+			return err
+		}
+		var links []nova.Link
+		if end < len(flavors) {
+			links = n.nextPageLinks(r, "flavors/detail", flavors[end-1].Id)
+		}
+		flavors = flavors[start:end]
 		if len(flavors) == 0 {
 			flavors = []nova.FlavorDetail{}
 		}
 		resp := struct {
 			Flavors []nova.FlavorDetail `json:"flavors"`
-		}{flavors}
+			Links   []nova.Link         `json:"flavors_links,omitempty"`
+		}{flavors, links}
 		return sendJSON(http.StatusOK, resp, w, r)
 	case "POST":
 		return errNotFound
@@ -427,7 +774,34 @@ func (n *Nova) handleFlavorsDetail(w http.ResponseWriter, r *http.Request) error
 		}
 		return errForbidden
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
+}
+
+// consoleLength returns the number of console log lines requested by
+// an os-getConsoleOutput action. Like Nova, it accepts the length as a
+// number or a string, with a missing length or -1 meaning all lines.
+func consoleLength(length interface{}) (int, error) {
+	var n int
+	switch length := length.(type) {
+	case nil:
+		return -1, nil
+	case float64:
+		n = int(length)
+		if float64(n) != length {
+			return 0, testservices.NewInvalidConsoleLengthError(length)
+		}
+	case string:
+		var err error
+		if n, err = strconv.Atoi(length); err != nil {
+			return 0, testservices.NewInvalidConsoleLengthError(length)
+		}
+	default:
+		return 0, testservices.NewInvalidConsoleLengthError(length)
+	}
+	if n < -1 {
+		return 0, testservices.NewInvalidConsoleLengthError(length)
+	}
+	return n, nil
 }
 
 // handleServerActions handles the servers/<id>/action HTTP API.
@@ -452,6 +826,28 @@ func (n *Nova) handleServerActions(server *nova.ServerDetail, w http.ResponseWri
 		RemoveFloatingIP *struct {
 			Address string
 		}
+		Reboot *struct {
+			Type string
+		}
+		Rebuild *struct {
+			ImageRef string
+		}
+		Resize *struct {
+			FlavorRef string
+		}
+		// These actions take no arguments, and are usually given
+		// as null, so they are decoded as raw JSON to detect them.
+		ConfirmResize json.RawMessage
+		RevertResize  json.RawMessage
+		CreateImage   *struct {
+			Name string
+		}
+		GetConsoleOutput *struct {
+			Length interface{}
+		} `json:"os-getConsoleOutput"`
+		GetVNCConsole *struct {
+			Type string
+		} `json:"os-getVNCConsole"`
 	}
 	if err := json.Unmarshal(body, &action); err != nil {
 		return err
@@ -459,7 +855,7 @@ func (n *Nova) handleServerActions(server *nova.ServerDetail, w http.ResponseWri
 	switch {
 	case action.AddSecurityGroup != nil:
 		name := action.AddSecurityGroup.Name
-		group, err := n.securityGroupByName(name)
+		group, err := n.securityGroupByName(n.serverTenant(*server), name)
 		if err != nil || n.hasServerSecurityGroup(server.Id, group.Id) {
 			return errNotFound
 		}
@@ -470,7 +866,7 @@ func (n *Nova) handleServerActions(server *nova.ServerDetail, w http.ResponseWri
 		return nil
 	case action.RemoveSecurityGroup != nil:
 		name := action.RemoveSecurityGroup.Name
-		group, err := n.securityGroupByName(name)
+		group, err := n.securityGroupByName(n.serverTenant(*server), name)
 		if err != nil || !n.hasServerSecurityGroup(server.Id, group.Id) {
 			return errNotFound
 		}
@@ -488,6 +884,11 @@ func (n *Nova) handleServerActions(server *nova.ServerDetail, w http.ResponseWri
 		if err != nil {
 			return errNotFound
 		}
+		if visible, err := n.floatingIPVisible(r, fip.Id); err != nil {
+			return err
+		} else if !visible {
+			return errNotFound
+		}
 		if err := n.addServerFloatingIP(server.Id, fip.Id); err != nil {
 			return err
 		}
@@ -502,24 +903,122 @@ func (n *Nova) handleServerActions(server *nova.ServerDetail, w http.ResponseWri
 		if err != nil {
 			return errNotFound
 		}
+		if visible, err := n.floatingIPVisible(r, fip.Id); err != nil {
+			return err
+		} else if !visible {
+			return errNotFound
+		}
 		if err := n.removeServerFloatingIP(server.Id, fip.Id); err != nil {
 			return err
 		}
 		writeResponse(w, http.StatusAccepted, nil)
 		return nil
+	case action.Reboot != nil:
+		if err := n.rebootServer(server.Id, action.Reboot.Type); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	case action.Rebuild != nil:
+		imageId := path.Base(action.Rebuild.ImageRef)
+		if _, err := n.image(imageId); err != nil {
+			return errBadRequestSrvImageNotFound
+		}
+		if err := n.rebuildServer(server.Id, imageId); err != nil {
+			return err
+		}
+		server, err := n.server(server.Id)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			Server nova.ServerDetail `json:"server"`
+		}{*server}
+		return sendJSON(http.StatusAccepted, resp, w, r)
+	case action.Resize != nil:
+		flavorId := path.Base(action.Resize.FlavorRef)
+		if _, err := n.flavor(flavorId); err != nil {
+			return errBadRequestSrvFlavorNotFound
+		}
+		if err := n.resizeServer(server.Id, flavorId); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	case action.ConfirmResize != nil:
+		if err := n.confirmServerResize(server.Id); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	case action.RevertResize != nil:
+		if err := n.revertServerResize(server.Id); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	case action.CreateImage != nil:
+		if action.CreateImage.Name == "" {
+			return testservices.NewBadRequestError("Image name is not defined")
+		}
+		image, err := n.createServerImage(server.Id, action.CreateImage.Name)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Location", n.endpointURL(true, "/images/"+image.Id))
+		resp := struct {
+			ImageId string `json:"image_id"`
+		}{image.Id}
+		return sendJSON(http.StatusAccepted, resp, w, r)
+	case action.GetConsoleOutput != nil:
+		length, err := consoleLength(action.GetConsoleOutput.Length)
+		if err != nil {
+			return err
+		}
+		output, err := n.serverConsoleOutput(server.Id, length)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			Output string `json:"output"`
+		}{output}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case action.GetVNCConsole != nil:
+		consoleType := action.GetVNCConsole.Type
+		url, err := n.serverVNCConsole(server.Id, consoleType)
+		if err != nil {
+			return err
+		}
+		var resp struct {
+			Console struct {
+				Type string `json:"type"`
+				URL  string `json:"url"`
+			} `json:"console"`
+		}
+		resp.Console.Type = consoleType
+		resp.Console.URL = url
+		return sendJSON(http.StatusOK, resp, w, r)
 	}
-	return fmt.Errorf("unknown server action: %q", string(body))
+	var actions map[string]json.RawMessage
+	if err := json.Unmarshal(body, &actions); err == nil {
+		for name := range actions {
+			return testservices.NewNoSuchActionError(name)
+		}
+	}
+	return errBadRequest2
 }
 
-// newUUID generates a random UUID conforming to RFC 4122.
-func newUUID() (string, error) {
-	uuid := make([]byte, 16)
-	if _, err := io.ReadFull(rand.Reader, uuid); err != nil {
-		return "", err
-	}
-	uuid[8] = uuid[8]&^0xc0 | 0x80 // variant bits; see section 4.1.1.
-	uuid[6] = uuid[6]&^0xf0 | 0x40 // version 4; see section 4.1.3.
-	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:]), nil
+// ServeHTTP writes the overLimit fault Nova reports when a quota
+// would be exceeded.
+func (e *quotaExceededError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"overLimit": map[string]interface{}{
+			"message": e.Error(),
+			"code":    http.StatusForbidden,
+		},
+	})
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	writeResponse(w, http.StatusForbidden, body)
 }
 
 // noGroupError constructs a bad request response for an invalid group.
@@ -534,6 +1033,192 @@ func noGroupError(groupName, tenantId string) error {
 	}
 }
 
+// blockDeviceMapping is one of the block_device_mapping_v2 entries of
+// a server create request.
+type blockDeviceMapping struct {
+	BootIndex       interface{} `json:"boot_index"`
+	UUID            string      `json:"uuid"`
+	SourceType      string      `json:"source_type"`
+	DestinationType string      `json:"destination_type"`
+	VolumeSize      int         `json:"volume_size"`
+	DeviceName      string      `json:"device_name"`
+}
+
+// bootIndex returns the mapping's boot index, which may be given as a
+// number or a string, or -1 if the device is not bootable.
+func (m *blockDeviceMapping) bootIndex() (int, error) {
+	switch index := m.BootIndex.(type) {
+	case nil:
+		return -1, nil
+	case float64:
+		return int(index), nil
+	case string:
+		if index == "" || strings.EqualFold(index, "none") {
+			return -1, nil
+		}
+		if i, err := strconv.Atoi(index); err == nil {
+			return i, nil
+		}
+	}
+	return 0, testservices.NewBadRequestError(fmt.Sprintf("Invalid input for field/attribute boot_index. Value: %v.", m.BootIndex))
+}
+
+// destination returns the mapping's destination type, which defaults
+// to a volume for volume and snapshot sources.
+func (m *blockDeviceMapping) destination() string {
+	if m.DestinationType != "" {
+		return m.DestinationType
+	}
+	if m.SourceType == "volume" || m.SourceType == "snapshot" {
+		return "volume"
+	}
+	return "local"
+}
+
+// checkBlockDeviceMappings validates the block device mappings of a
+// server create request against the images and the volume service,
+// returning the mapping with boot index 0, if any. Volumes must be
+// available, and snapshots may only be used with a SnapshotService.
+// The double cannot create volumes from images or blank ones, so
+// only volume and snapshot sources may be mapped to volumes.
+func (n *Nova) checkBlockDeviceMappings(mappings []blockDeviceMapping) (*blockDeviceMapping, error) {
+	var boot *blockDeviceMapping
+	volumes := make(map[string]bool)
+	for i := range mappings {
+		m := &mappings[i]
+		switch m.SourceType {
+		case "image", "volume", "snapshot", "blank":
+		default:
+			return nil, testservices.NewInvalidBlockDeviceSourceTypeError(m.SourceType)
+		}
+		destination := m.destination()
+		switch {
+		case destination != "local" && destination != "volume":
+			return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("Invalid destination_type %s.", destination))
+		case m.SourceType != "blank" && m.UUID == "":
+			return nil, testservices.NewInvalidBlockDeviceMappingError("Missing device UUID.")
+		case (m.SourceType == "volume" || m.SourceType == "snapshot") != (destination == "volume"):
+			return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("Mapping %s to %s is not supported.", m.SourceType, destination))
+		}
+		switch m.SourceType {
+		case "image":
+			if _, err := n.image(path.Base(m.UUID)); err != nil {
+				return nil, errBadRequestSrvImageNotFound
+			}
+		case "volume":
+			// A volume can only be attached once, so may only be
+			// mapped once.
+			if volumes[m.UUID] {
+				return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("Volume %s is mapped more than once.", m.UUID))
+			}
+			volumes[m.UUID] = true
+			if n.volumeService == nil {
+				break
+			}
+			status, err := n.volumeService.VolumeStatus(m.UUID)
+			if err != nil {
+				return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("failed to get volume %s.", m.UUID))
+			}
+			if status != volumeAvailable {
+				return nil, testservices.NewInvalidVolumeStatusError(m.UUID, status, volumeAvailable)
+			}
+		case "snapshot":
+			snapshots, ok := n.volumeService.(SnapshotService)
+			if !ok {
+				return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("failed to get snapshot %s.", m.UUID))
+			}
+			status, err := snapshots.SnapshotStatus(m.UUID)
+			if err != nil {
+				return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("failed to get snapshot %s.", m.UUID))
+			}
+			if status != volumeAvailable {
+				return nil, testservices.NewInvalidBlockDeviceMappingError(fmt.Sprintf("snapshot %s status must be available, but current status is: %s", m.UUID, status))
+			}
+		}
+		index, err := m.bootIndex()
+		if err != nil {
+			return nil, err
+		}
+		if index == 0 {
+			if boot != nil {
+				return nil, errBadRequestBootSequence
+			}
+			boot = m
+		}
+	}
+	return boot, nil
+}
+
+// attachBlockDevices attaches the volumes of a new server's block
+// device mappings, first creating those to be made from snapshots.
+// Unless a mapping names its device, the boot volume is attached as
+// /dev/vda and the others follow it.
+func (n *Nova) attachBlockDevices(serverId string, mappings []blockDeviceMapping) error {
+	next := 'b'
+	for _, m := range mappings {
+		if m.destination() != "volume" {
+			continue
+		}
+		volumeId := m.UUID
+		if m.SourceType == "snapshot" {
+			var err error
+			volumeId, err = n.volumeService.(SnapshotService).CreateVolumeFromSnapshot(m.UUID, m.VolumeSize)
+			if err != nil {
+				return err
+			}
+		}
+		device := m.DeviceName
+		if index, _ := m.bootIndex(); device == "" && index == 0 {
+			device = "/dev/vda"
+		} else if device == "" {
+			device = fmt.Sprintf("/dev/vd%c", next)
+			next++
+		}
+		attachment := nova.VolumeAttachment{VolumeId: volumeId, Device: device}
+		if _, err := n.attachVolume(serverId, attachment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxUserDataLength is the longest base64 encoded user data Nova
+// accepts for a server.
+const maxUserDataLength = 65535
+
+// decodeUserData decodes the base64 encoded user data given when
+// creating a server, which Nova requires to be valid.
+func decodeUserData(encoded string) ([]byte, error) {
+	if len(encoded) > maxUserDataLength {
+		return nil, testservices.NewUserDataTooLongError(encoded)
+	}
+	userData, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, testservices.NewInvalidUserDataError(encoded)
+	}
+	return userData, nil
+}
+
+// parseConfigDrive returns whether a server is to be created with a
+// config drive, given the config_drive field of the request. As in
+// Nova, the field may be a boolean or a string spelling one.
+func parseConfigDrive(value interface{}) (bool, error) {
+	switch value := value.(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return value, nil
+	case string:
+		switch strings.ToLower(value) {
+		case "true", "1", "on", "yes":
+			return true, nil
+		case "false", "0", "off", "no":
+			return false, nil
+		}
+	}
+	return false, testservices.NewInvalidConfigDriveError(value)
+}
+
 // handleRunServer handles creating and running a server.
 func (n *Nova) handleRunServer(body []byte, w http.ResponseWriter, r *http.Request) error {
 	var req struct {
@@ -544,8 +1229,16 @@ func (n *Nova) handleRunServer(body []byte, w http.ResponseWriter, r *http.Reque
 			Metadata         map[string]string
 			SecurityGroups   []map[string]string `json:"security_groups"`
 			Networks         []map[string]string
-			AvailabilityZone string `json:"availability_zone"`
+			AvailabilityZone string               `json:"availability_zone"`
+			KeyName          string               `json:"key_name"`
+			BlockDevices     []blockDeviceMapping `json:"block_device_mapping_v2"`
+			UserData         *string              `json:"user_data"`
+			ConfigDrive      interface{}          `json:"config_drive"`
 		}
+		SchedulerHints struct {
+			Group       string `json:"group"`
+			ServerGroup string `json:"server_group"`
+		} `json:"os:scheduler_hints"`
 	}
 	if err := json.Unmarshal(body, &req); err != nil {
 		return errBadRequest3
@@ -553,7 +1246,21 @@ func (n *Nova) handleRunServer(body []byte, w http.ResponseWriter, r *http.Reque
 	if req.Server.Name == "" {
 		return errBadRequestSrvName
 	}
-	if req.Server.ImageRef == "" {
+	bootDevice, err := n.checkBlockDeviceMappings(req.Server.BlockDevices)
+	if err != nil {
+		return err
+	}
+	// A server may instead boot from the image or volume of its
+	// block device mapping with boot index 0.
+	imageRef := req.Server.ImageRef
+	if imageRef == "" && bootDevice != nil && bootDevice.SourceType == "image" {
+		imageRef = bootDevice.UUID
+	}
+	bootFromVolume := bootDevice != nil && bootDevice.destination() == "volume"
+	if imageRef == "" && !bootFromVolume {
+		if len(req.Server.BlockDevices) > 0 {
+			return errBadRequestBootSequence
+		}
 		return errBadRequestSrvImage
 	}
 	if req.Server.FlavorRef == "" {
@@ -564,64 +1271,171 @@ func (n *Nova) handleRunServer(body []byte, w http.ResponseWriter, r *http.Reque
 			return testservices.AvailabilityZoneIsNotAvailable
 		}
 	}
-	n.nextServerId++
-	id := strconv.Itoa(n.nextServerId)
-	uuid, err := newUUID()
+	// References may be given as either an id or a URL.
+	flavor, err := n.flavor(path.Base(req.Server.FlavorRef))
 	if err != nil {
-		return err
+		return errBadRequestSrvFlavorNotFound
 	}
-	var groups []string
-	if len(req.Server.SecurityGroups) > 0 {
-		for _, group := range req.Server.SecurityGroups {
-			groupName := group["name"]
-			if sg, err := n.securityGroupByName(groupName); err != nil {
-				return noGroupError(groupName, n.TenantId)
-			} else {
-				groups = append(groups, sg.Id)
-			}
+	// Like Nova, a server booted from a volume has no image.
+	var image nova.Entity
+	if imageRef != "" {
+		found, err := n.image(path.Base(imageRef))
+		if err != nil {
+			return errBadRequestSrvImageNotFound
 		}
+		image = nova.Entity{Id: found.Id}
 	}
-	// TODO(gz) some kind of sane handling of networks
-	for _, network := range req.Server.Networks {
-		networkId := network["uuid"]
-		_, ok := n.networks[networkId]
-		if !ok {
-			return errNotFoundJSON
+	if err := n.checkHostCapabilities(flavor); err != nil {
+		return err
+	}
+	if err := n.validateMetadata(req.Server.Metadata, len(req.Server.Metadata)); err != nil {
+		return err
+	}
+	var userData []byte
+	if req.Server.UserData != nil {
+		if userData, err = decodeUserData(*req.Server.UserData); err != nil {
+			return err
 		}
 	}
-	// TODO(dimitern) - 2013-02-11 bug=1121684
-	// make sure flavor/image exist (if needed)
-	flavor := nova.FlavorDetail{Id: req.Server.FlavorRef}
-	n.buildFlavorLinks(&flavor)
-	flavorEnt := nova.Entity{Id: flavor.Id, Links: flavor.Links}
-	image := nova.Entity{Id: req.Server.ImageRef}
-	timestr := time.Now().Format(time.RFC3339)
+	configDrive, err := parseConfigDrive(req.Server.ConfigDrive)
+	if err != nil {
+		return err
+	}
 	userInfo, _ := userInfo(n.IdentityService, r)
+	if name := req.Server.KeyName; name != "" {
+		if _, err := n.keyPair(userInfo.TenantId, name); err != nil {
+			return testservices.NewInvalidKeyNameError()
+		}
+	}
+	// Nova's hint names the group by id; the server_group hint may
+	// also name it by name.
+	// Groups of other tenants are not accepted.
+	var serverGroup *ServerGroup
+	if ref := req.SchedulerHints.Group; ref != "" {
+		if serverGroup, err = n.serverGroup(ref); err != nil || serverGroup.TenantId != userInfo.TenantId {
+			return testservices.NewInvalidServerGroupError(ref)
+		}
+	} else if ref := req.SchedulerHints.ServerGroup; ref != "" {
+		if serverGroup, err = n.serverGroupByRef(userInfo.TenantId, ref); err != nil {
+			return testservices.NewInvalidServerGroupError(ref)
+		}
+	}
+	if serverGroup != nil {
+		if err := n.checkServerGroupCapacity(serverGroup); err != nil {
+			return err
+		}
+	}
+	requested := Quotas{Instances: 1, Cores: flavor.VCPUs, RAM: flavor.RAM}
+	if err := n.checkQuotas(userInfo.TenantId, requested); err != nil {
+		return err
+	}
+	id := n.NewSequentialID(testservices.ResourceServer, &n.nextServerId)
+	uuid, err := testservices.NewUUID()
+	if err != nil {
+		return err
+	}
+	var groups []string
+	if len(req.Server.SecurityGroups) > 0 {
+		for _, group := range req.Server.SecurityGroups {
+			groupName := group["name"]
+			if sg, err := n.securityGroupByName(userInfo.TenantId, groupName); err != nil {
+				return noGroupError(groupName, userInfo.TenantId)
+			} else {
+				groups = append(groups, sg.Id)
+			}
+		}
+	}
+	var networks []nova.Network
+	for _, requested := range req.Server.Networks {
+		network, ok := n.networks[requested["uuid"]]
+		if !ok {
+			return errNotFoundJSON
+		}
+		networks = append(networks, network)
+	}
+	timestr := n.now().Format(time.RFC3339)
 	server := nova.ServerDetail{
 		Id:               id,
 		UUID:             uuid,
 		Name:             req.Server.Name,
-		TenantId:         n.TenantId,
+		TenantId:         userInfo.TenantId,
 		UserId:           userInfo.Id,
 		HostId:           "1",
 		Image:            image,
-		Flavor:           flavorEnt,
-		Status:           nova.StatusActive,
+		Flavor:           nova.Entity{Id: flavor.Id, Links: flavor.Links},
+		Status:           nova.StatusBuild,
 		Created:          timestr,
 		Updated:          timestr,
 		Addresses:        make(map[string][]nova.IPAddress),
 		AvailabilityZone: req.Server.AvailabilityZone,
 	}
+	if configDrive {
+		server.ConfigDrive = "True"
+	}
 	nextServer := len(n.allServers(nil)) + 1
 	n.buildServerLinks(&server)
-	// set some IP addresses
-	addr := fmt.Sprintf("127.10.0.%d", nextServer)
-	server.Addresses["public"] = []nova.IPAddress{{4, addr}, {6, "::dead:beef:f00d"}}
-	addr = fmt.Sprintf("127.0.0.%d", nextServer)
-	server.Addresses["private"] = []nova.IPAddress{{4, addr}, {6, "::face::000f"}}
+	if len(networks) > 0 {
+		// Give the server a fixed IP on each of the requested
+		// networks, which may name the address to use, and an
+		// IPv6 one too on dual-stack networks.
+		used := make(map[string]map[string]string)
+		for i, network := range networks {
+			if used[network.Label] == nil {
+				used[network.Label] = n.usedFixedIPs(network.Label)
+			}
+			addrs, err := n.allocateFixedIPs(network, req.Server.Networks[i]["fixed_ip"], uuid, used[network.Label])
+			if err != nil {
+				return err
+			}
+			for _, addr := range addrs {
+				server.Addresses[network.Label] = append(server.Addresses[network.Label], nova.IPAddress{
+					Version: ipVersion(addr),
+					Address: addr,
+					Type:    fixedIPType,
+				})
+			}
+		}
+	} else {
+		// set some IP addresses
+		addr := fmt.Sprintf("127.10.0.%d", nextServer)
+		server.Addresses["public"] = []nova.IPAddress{
+			{Version: 4, Address: addr, Type: fixedIPType},
+			{Version: 6, Address: "::dead:beef:f00d", Type: fixedIPType},
+		}
+		addr = fmt.Sprintf("127.0.0.%d", nextServer)
+		server.Addresses["private"] = []nova.IPAddress{
+			{Version: 4, Address: addr, Type: fixedIPType},
+			{Version: 6, Address: "::face::000f", Type: fixedIPType},
+		}
+	}
 	if err := n.addServer(server); err != nil {
 		return err
 	}
+	if len(req.Server.Metadata) > 0 {
+		if _, err := n.setServerMetadata(id, req.Server.Metadata, true); err != nil {
+			return err
+		}
+	}
+	if serverGroup != nil {
+		if err := n.addServerGroupMember(serverGroup.Id, id); err != nil {
+			return err
+		}
+	}
+	if name := req.Server.KeyName; name != "" {
+		if err := n.setServerKeyPair(id, userInfo.TenantId, name); err != nil {
+			return err
+		}
+	}
+	if userData != nil {
+		if err := n.setServerUserData(id, userData); err != nil {
+			return err
+		}
+	}
+	if err := n.attachBlockDevices(id, req.Server.BlockDevices); err != nil {
+		// Don't leave a half created server behind.
+		n.removeServer(id)
+		return err
+	}
 	var resp struct {
 		Server struct {
 			SecurityGroups []map[string]string `json:"security_groups"`
@@ -640,16 +1454,63 @@ func (n *Nova) handleRunServer(body []byte, w http.ResponseWriter, r *http.Reque
 	} else {
 		resp.Server.SecurityGroups = []map[string]string{{"name": "default"}}
 	}
+	n.failServerCreate(id)
 	resp.Server.Id = id
 	resp.Server.Links = server.Links
 	resp.Server.AdminPass = "secret"
 	return sendJSON(http.StatusAccepted, resp, w, r)
 }
 
+// checkServerTenant returns an error if the request is for a server,
+// or one of its sub-resources, which belongs to a tenant other than
+// the caller's. As in Nova, such servers are reported as not found,
+// unless an admin asks for all tenants.
+func (n *Nova) checkServerTenant(r *http.Request) error {
+	parts := n.serverPathParts(r)
+	if parts == nil {
+		return nil
+	}
+	serverId := parts[0]
+	server, ok := n.servers[serverId]
+	if !ok {
+		// Unknown servers are reported by the handlers.
+		return nil
+	}
+	tenantId, err := n.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	if tenantId != "" && n.serverTenant(server) != tenantId {
+		return testservices.NewServerByIDNotFoundError(serverId)
+	}
+	return nil
+}
+
+// serverPathParts returns the segments of the request's path which
+// follow /servers/, the first being the server id, or nil if the
+// request is for the servers collection itself.
+func (n *Nova) serverPathParts(r *http.Request) []string {
+	prefix := fmt.Sprintf("/%s/%s/servers/", n.VersionPath, n.TenantId)
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return nil
+	}
+	return strings.Split(strings.TrimPrefix(r.URL.Path, prefix), "/")
+}
+
 // handleServers handles the servers HTTP API.
 func (n *Nova) handleServers(w http.ResponseWriter, r *http.Request) error {
-
-	if strings.Contains(r.URL.Path, "os-volume_attachments") {
+	if err := n.checkServerTenant(r); err != nil {
+		return err
+	}
+	// Sub-resources are routed on the segment following the server
+	// id, so that names used within them, such as a tag called
+	// "metadata", are not mistaken for other sub-resources.
+	var subResource string
+	if parts := n.serverPathParts(r); len(parts) > 1 {
+		subResource = parts[1]
+	}
+	switch subResource {
+	case "os-volume_attachments":
 		switch r.Method {
 		case "GET":
 			return n.handleListVolumes(w, r)
@@ -658,6 +1519,17 @@ func (n *Nova) handleServers(w http.ResponseWriter, r *http.Request) error {
 		case "DELETE":
 			return n.handleDetachVolumes(w, r)
 		}
+	case "metadata":
+		return n.handleServerMetadata(w, r)
+	case "tags":
+		if !atLeastMicroversion(r, serverTagsMicroversion) {
+			return errNotFound
+		}
+		return n.handleServerTags(w, r)
+	case "os-interface":
+		return n.handleServerInterfaces(w, r)
+	case "diagnostics":
+		return n.handleServerDiagnostics(w, r)
 	}
 
 	switch r.Method {
@@ -692,21 +1564,31 @@ func (n *Nova) handleServers(w http.ResponseWriter, r *http.Request) error {
 			}{*server}
 			return sendJSON(http.StatusOK, resp, w, r)
 		}
-		f := make(filter)
-		if err := r.ParseForm(); err == nil && len(r.Form) > 0 {
-			for filterKey, filterValues := range r.Form {
-				for _, value := range filterValues {
-					f[filterKey] = value
-				}
-			}
+		f, err := n.serverFilter(r)
+		if err != nil {
+			return err
+		}
+		limit, marker, err := pageParams(r)
+		if err != nil {
+			return err
 		}
 		entities := n.allServersAsEntities(f)
+		start, end, err := page(len(entities), func(i int) string { return entities[i].Id }, limit, marker)
+		if err != nil {
+			return err
+		}
+		var links []nova.Link
+		if end < len(entities) {
+			links = n.nextPageLinks(r, "servers", entities[end-1].Id)
+		}
+		entities = entities[start:end]
 		if len(entities) == 0 {
 			entities = []nova.Entity{}
 		}
 		resp := struct {
 			Servers []nova.Entity `json:"servers"`
-		}{entities}
+			Links   []nova.Link   `json:"servers_links,omitempty"`
+		}{entities, links}
 		return sendJSON(http.StatusOK, resp, w, r)
 	case "POST":
 		if suffix := path.Base(r.URL.Path); suffix != "servers" {
@@ -747,7 +1629,57 @@ func (n *Nova) handleServers(w http.ResponseWriter, r *http.Request) error {
 		}
 		return errNotFound
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
+}
+
+// tenantScope returns the id of the tenant whose resources may be seen
+// by the request, or "" for all tenants, as determined by
+// testservices.TenantScope for the request's token.
+func (n *Nova) tenantScope(r *http.Request) (string, error) {
+	user, err := userInfo(n.IdentityService, r)
+	if err != nil {
+		return "", err
+	}
+	tenantId, err := testservices.TenantScope(r, user)
+	if err != nil {
+		return "", testservices.NewBadRequestError(err.Error())
+	}
+	return tenantId, nil
+}
+
+// serverFilter returns the filter given in the query of a request to
+// list servers. As in Nova, a name filter must be a valid regular
+// expression, and the servers listed are those of the caller's tenant
+// unless an admin asks for those of all tenants.
+func (n *Nova) serverFilter(r *http.Request) (filter, error) {
+	f := make(filter)
+	if err := r.ParseForm(); err == nil && len(r.Form) > 0 {
+		for filterKey, filterValues := range r.Form {
+			for _, value := range filterValues {
+				f[filterKey] = value
+			}
+		}
+	}
+	delete(f, nova.FilterAllTenants)
+	tenantId, err := n.tenantScope(r)
+	if err != nil {
+		return nil, err
+	}
+	if tenantId != "" {
+		// Other tenants' servers are never listed, even if asked for.
+		f[nova.FilterTenantId] = tenantId
+	}
+	if !atLeastMicroversion(r, serverTagsMicroversion) {
+		// As in Nova, unknown filters are ignored.
+		delete(f, nova.FilterTags)
+		delete(f, nova.FilterNotTags)
+	}
+	if nameRex := f[nova.FilterServer]; nameRex != "" {
+		if _, err := regexp.Compile(nameRex); err != nil {
+			return nil, testservices.NewInvalidRegexFilterError(nova.FilterServer, nameRex)
+		}
+	}
+	return f, nil
 }
 
 // handleServersDetail handles the servers/detail HTTP API.
@@ -757,21 +1689,31 @@ func (n *Nova) handleServersDetail(w http.ResponseWriter, r *http.Request) error
 		if serverId := path.Base(r.URL.Path); serverId != "detail" {
 			return errNotFound
 		}
-		f := make(filter)
-		if err := r.ParseForm(); err == nil && len(r.Form) > 0 {
-			for filterKey, filterValues := range r.Form {
-				for _, value := range filterValues {
-					f[filterKey] = value
-				}
-			}
+		f, err := n.serverFilter(r)
+		if err != nil {
+			return err
+		}
+		limit, marker, err := pageParams(r)
+		if err != nil {
+			return err
 		}
 		servers := n.allServers(f)
+		start, end, err := page(len(servers), func(i int) string { return servers[i].Id }, limit, marker)
+		if err != nil {
+			return err
+		}
+		var links []nova.Link
+		if end < len(servers) {
+			links = n.nextPageLinks(r, "servers/detail", servers[end-1].Id)
+		}
+		servers = servers[start:end]
 		if len(servers) == 0 {
 			servers = []nova.ServerDetail{}
 		}
 		resp := struct {
 			Servers []nova.ServerDetail `json:"servers"`
-		}{servers}
+			Links   []nova.Link         `json:"servers_links,omitempty"`
+		}{servers, links}
 		return sendJSON(http.StatusOK, resp, w, r)
 	case "POST":
 		return errNotFound
@@ -786,31 +1728,53 @@ func (n *Nova) handleServersDetail(w http.ResponseWriter, r *http.Request) error
 		}
 		return errNotFoundJSON
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
 }
 
 // processGroupId returns the group id from the given request.
-// If there was no group id specified in the path, it returns errNoGroupId
+// If there was no group id specified in the path, it returns errNoGroupId.
+// Groups of other tenants are not found.
 func (n *Nova) processGroupId(w http.ResponseWriter, r *http.Request) (*nova.SecurityGroup, error) {
 	if groupId := path.Base(r.URL.Path); groupId != "os-security-groups" {
 		group, err := n.securityGroup(groupId)
 		if err != nil {
 			return nil, errNotFoundJSONSG
 		}
+		if visible, err := n.securityGroupVisible(r, group); err != nil {
+			return nil, err
+		} else if !visible {
+			return nil, errNotFoundJSONSG
+		}
 		return group, nil
 	}
 	return nil, errNoGroupId
 }
 
+// securityGroupVisible reports whether the given group may be seen by
+// the request: groups of other tenants may not.
+func (n *Nova) securityGroupVisible(r *http.Request, group *nova.SecurityGroup) (bool, error) {
+	tenantId, err := n.tenantScope(r)
+	if err != nil {
+		return false, err
+	}
+	return tenantId == "" || group.TenantId == tenantId, nil
+}
+
 // handleSecurityGroups handles the os-security-groups HTTP API.
 func (n *Nova) handleSecurityGroups(w http.ResponseWriter, r *http.Request) error {
 	switch r.Method {
 	case "GET":
 		group, err := n.processGroupId(w, r)
 		if err == errNoGroupId {
-			groups := n.allSecurityGroups()
-			if len(groups) == 0 {
-				groups = []nova.SecurityGroup{}
+			tenantId, err := n.tenantScope(r)
+			if err != nil {
+				return err
+			}
+			groups := []nova.SecurityGroup{}
+			for _, group := range n.allSecurityGroups() {
+				if tenantId == "" || group.TenantId == tenantId {
+					groups = append(groups, group)
+				}
 			}
 			resp := struct {
 				Groups []nova.SecurityGroup `json:"security_groups"`
@@ -841,17 +1805,19 @@ func (n *Nova) handleSecurityGroups(w http.ResponseWriter, r *http.Request) erro
 		if err := json.Unmarshal(body, &req); err != nil {
 			return err
 		} else {
-			_, err := n.securityGroupByName(req.Group.Name)
-			if err == nil {
+			user, err := userInfo(n.IdentityService, r)
+			if err != nil {
+				return err
+			}
+			if _, err := n.securityGroupByName(user.TenantId, req.Group.Name); err == nil {
 				return errBadRequestDuplicateValue
 			}
-			n.nextGroupId++
-			nextId := strconv.Itoa(n.nextGroupId)
+			nextId := n.NewSequentialID(testservices.ResourceSecurityGroup, &n.nextGroupId)
 			err = n.addSecurityGroup(nova.SecurityGroup{
 				Id:          nextId,
 				Name:        req.Group.Name,
 				Description: req.Group.Description,
-				TenantId:    n.TenantId,
+				TenantId:    user.TenantId,
 			})
 			if err != nil {
 				return err
@@ -884,7 +1850,7 @@ func (n *Nova) handleSecurityGroups(w http.ResponseWriter, r *http.Request) erro
 			return err
 		}
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
 }
 
 // handleSecurityGroupRules handles the os-security-group-rules HTTP API.
@@ -911,6 +1877,11 @@ func (n *Nova) handleSecurityGroupRules(w http.ResponseWriter, r *http.Request)
 		if err != nil {
 			return err // TODO: should be a 4XX error with details
 		}
+		if visible, err := n.securityGroupVisible(r, group); err != nil {
+			return err
+		} else if !visible {
+			return testservices.NewSecurityGroupByIDNotFoundError(group.Id)
+		}
 		for _, r := range group.Rules {
 			// TODO: this logic is actually wrong, not what nova does at all
 			// why are we reimplementing half of nova/api/openstack in go again?
@@ -928,8 +1899,7 @@ func (n *Nova) handleSecurityGroupRules(w http.ResponseWriter, r *http.Request)
 				}
 			}
 		}
-		n.nextRuleId++
-		nextId := strconv.Itoa(n.nextRuleId)
+		nextId := n.NewSequentialID(testservices.ResourceSecurityGroupRule, &n.nextRuleId)
 		err = n.addSecurityGroupRule(nextId, req.Rule)
 		if err != nil {
 			return err
@@ -950,7 +1920,17 @@ func (n *Nova) handleSecurityGroupRules(w http.ResponseWriter, r *http.Request)
 		return errNotFound
 	case "DELETE":
 		if ruleId := path.Base(r.URL.Path); ruleId != "os-security-group-rules" {
-			if _, err := n.securityGroupRule(ruleId); err != nil {
+			rule, err := n.securityGroupRule(ruleId)
+			if err != nil {
+				return errNotFoundJSONSGR
+			}
+			group, err := n.securityGroup(rule.ParentGroupId)
+			if err != nil {
+				return errNotFoundJSONSGR
+			}
+			if visible, err := n.securityGroupVisible(r, group); err != nil {
+				return err
+			} else if !visible {
 				return errNotFoundJSONSGR
 			}
 			if err := n.removeSecurityGroupRule(ruleId); err != nil {
@@ -961,16 +1941,34 @@ func (n *Nova) handleSecurityGroupRules(w http.ResponseWriter, r *http.Request)
 		}
 		return errNotFound
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
 }
 
-// handleFloatingIPs handles the os-floating-ips HTTP API.
+// floatingIPVisible reports whether the given floating IP may be seen
+// by the request: those of other tenants may not.
+func (n *Nova) floatingIPVisible(r *http.Request, ipId string) (bool, error) {
+	tenantId, err := n.tenantScope(r)
+	if err != nil {
+		return false, err
+	}
+	return tenantId == "" || n.floatingIPTenant(ipId) == tenantId, nil
+}
+
+// handleFloatingIPs handles the os-floating-ips HTTP API. As with
+// servers, the floating IPs of other tenants are not found.
 func (n *Nova) handleFloatingIPs(w http.ResponseWriter, r *http.Request) error {
+	tenantId, err := n.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	visible := func(ipId string) bool {
+		return tenantId == "" || n.floatingIPTenant(ipId) == tenantId
+	}
 	switch r.Method {
 	case "GET":
 		if ipId := path.Base(r.URL.Path); ipId != "os-floating-ips" {
 			fip, err := n.floatingIP(ipId)
-			if err != nil {
+			if err != nil || !visible(ipId) {
 				return errNotFoundJSON
 			}
 			resp := struct {
@@ -978,9 +1976,11 @@ func (n *Nova) handleFloatingIPs(w http.ResponseWriter, r *http.Request) error {
 			}{*fip}
 			return sendJSON(http.StatusOK, resp, w, r)
 		}
-		fips := n.allFloatingIPs()
-		if len(fips) == 0 {
-			fips = []nova.FloatingIP{}
+		fips := []nova.FloatingIP{}
+		for _, fip := range n.allFloatingIPs() {
+			if visible(fip.Id) {
+				fips = append(fips, fip)
+			}
 		}
 		resp := struct {
 			IPs []nova.FloatingIP `json:"floating_ips"`
@@ -990,17 +1990,33 @@ func (n *Nova) handleFloatingIPs(w http.ResponseWriter, r *http.Request) error {
 		if ipId := path.Base(r.URL.Path); ipId != "os-floating-ips" {
 			return errNotFound
 		}
-		n.nextIPId++
-		addr := fmt.Sprintf("10.0.0.%d", n.nextIPId)
-		nextId := strconv.Itoa(n.nextIPId)
-		fip := nova.FloatingIP{Id: nextId, IP: addr, Pool: "nova"}
-		err := n.addFloatingIP(fip)
+		var req struct {
+			Pool string `json:"pool"`
+		}
+		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			return err
 		}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				return errBadRequest2
+			}
+		}
+		user, err := userInfo(n.IdentityService, r)
+		if err != nil {
+			return err
+		}
+		if err := n.checkQuotas(user.TenantId, Quotas{FloatingIPs: 1}); err != nil {
+			return err
+		}
+		fip, err := n.allocateFloatingIP(req.Pool)
+		if err != nil {
+			return err
+		}
+		n.floatingIPTenants[fip.Id] = user.TenantId
 		resp := struct {
 			IP nova.FloatingIP `json:"floating_ip"`
-		}{fip}
+		}{*fip}
 		return sendJSON(http.StatusOK, resp, w, r)
 	case "PUT":
 		if ipId := path.Base(r.URL.Path); ipId != "os-floating-ips" {
@@ -1009,6 +2025,9 @@ func (n *Nova) handleFloatingIPs(w http.ResponseWriter, r *http.Request) error {
 		return errNotFound
 	case "DELETE":
 		if ipId := path.Base(r.URL.Path); ipId != "os-floating-ips" {
+			if !visible(ipId) {
+				return errNotFoundJSON
+			}
 			if err := n.removeFloatingIP(ipId); err == nil {
 				writeResponse(w, http.StatusAccepted, nil)
 				return nil
@@ -1017,7 +2036,7 @@ func (n *Nova) handleFloatingIPs(w http.ResponseWriter, r *http.Request) error {
 		}
 		return errNotFound
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET", "POST", "PUT", "DELETE")
 }
 
 // handleNetworks handles the os-networks HTTP API.
@@ -1038,14 +2057,21 @@ func (n *Nova) handleNetworks(w http.ResponseWriter, r *http.Request) error {
 		return sendJSON(http.StatusOK, resp, w, r)
 		// TODO(gz): proper handling of other methods
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET")
 }
 
-// handleAvailabilityZones handles the os-availability-zone HTTP API.
+// handleAvailabilityZones handles the os-availability-zone HTTP API,
+// including the detailed listing at os-availability-zone/detail.
 func (n *Nova) handleAvailabilityZones(w http.ResponseWriter, r *http.Request) error {
 	switch r.Method {
 	case "GET":
-		if ipId := path.Base(r.URL.Path); ipId != "os-availability-zone" {
+		prefix := fmt.Sprintf("/%s/%s/os-availability-zone", n.VersionPath, n.TenantId)
+		var detail bool
+		switch strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/") {
+		case "":
+		case "/detail":
+			detail = true
+		default:
 			return errNotFoundJSON
 		}
 		zones := n.allAvailabilityZones()
@@ -1054,12 +2080,708 @@ func (n *Nova) handleAvailabilityZones(w http.ResponseWriter, r *http.Request) e
 			// if we don't support the availability zones extension.
 			return errNotFoundJSON
 		}
+		if !detail {
+			// Hosts are only reported in detail.
+			for i := range zones {
+				zones[i].Hosts = nil
+			}
+		}
 		resp := struct {
 			Zones []nova.AvailabilityZone `json:"availabilityZoneInfo"`
 		}{zones}
 		return sendJSON(http.StatusOK, resp, w, r)
 	}
-	return fmt.Errorf("unknown request method %q for %s", r.Method, r.URL.Path)
+	return errMethodNotAllowed("GET")
+}
+
+// handleServerMetadata handles the servers/<id>/metadata HTTP API,
+// including the individual items at servers/<id>/metadata/<key>.
+func (n *Nova) handleServerMetadata(w http.ResponseWriter, r *http.Request) error {
+	parts := n.serverPathParts(r)
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "metadata" {
+		return errNotFound
+	}
+	serverId := parts[0]
+	if len(parts) == 3 {
+		return n.handleServerMetadataItem(serverId, parts[2], w, r)
+	}
+	switch r.Method {
+	case "GET":
+		metadata, err := n.serverMetadataItems(serverId)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			Metadata map[string]string `json:"metadata"`
+		}{metadata}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "PUT", "POST":
+		// PUT replaces all the metadata, POST merges with it.
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Metadata == nil {
+			return errBadRequest2
+		}
+		metadata, err := n.setServerMetadata(serverId, req.Metadata, r.Method == "PUT")
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			Metadata map[string]string `json:"metadata"`
+		}{metadata}
+		return sendJSON(http.StatusOK, resp, w, r)
+	}
+	return errMethodNotAllowed("GET", "PUT", "POST")
+}
+
+// handleServerMetadataItem handles a single server metadata item.
+func (n *Nova) handleServerMetadataItem(serverId, key string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		metadata, err := n.serverMetadataItems(serverId)
+		if err != nil {
+			return err
+		}
+		value, ok := metadata[key]
+		if !ok {
+			return testservices.NewMetadataItemNotFoundError(key)
+		}
+		resp := struct {
+			Meta map[string]string `json:"meta"`
+		}{map[string]string{key: value}}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "PUT":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			Meta map[string]string `json:"meta"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Meta == nil {
+			return errBadRequest2
+		}
+		value, ok := req.Meta[key]
+		if !ok || len(req.Meta) != 1 {
+			return testservices.NewMetadataKeyMismatchError()
+		}
+		if _, err := n.setServerMetadata(serverId, req.Meta, false); err != nil {
+			return err
+		}
+		resp := struct {
+			Meta map[string]string `json:"meta"`
+		}{map[string]string{key: value}}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "DELETE":
+		if err := n.removeServerMetadataItem(serverId, key); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "PUT", "DELETE")
+}
+
+// handleServerTags handles the servers/<id>/tags HTTP API, including
+// the individual tags at servers/<id>/tags/<tag>.
+func (n *Nova) handleServerTags(w http.ResponseWriter, r *http.Request) error {
+	parts := n.serverPathParts(r)
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "tags" {
+		return errNotFound
+	}
+	serverId := parts[0]
+	if len(parts) == 3 {
+		return n.handleServerTag(serverId, parts[2], w, r)
+	}
+	type tagsResponse struct {
+		Tags []string `json:"tags"`
+	}
+	switch r.Method {
+	case "GET":
+		tags, err := n.allServerTags(serverId)
+		if err != nil {
+			return err
+		}
+		return sendJSON(http.StatusOK, tagsResponse{tags}, w, r)
+	case "PUT":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Tags == nil {
+			return errBadRequest2
+		}
+		tags, err := n.setServerTags(serverId, req.Tags)
+		if err != nil {
+			return err
+		}
+		return sendJSON(http.StatusOK, tagsResponse{tags}, w, r)
+	case "DELETE":
+		if _, err := n.setServerTags(serverId, nil); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "PUT", "DELETE")
+}
+
+// handleServerTag handles a single server tag. As in Nova, GET checks
+// whether the server has the tag, responding with no content if so.
+func (n *Nova) handleServerTag(serverId, tag string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		if _, err := n.allServerTags(serverId); err != nil {
+			return err
+		}
+		if !n.hasServerTags(serverId, []string{tag}) {
+			return testservices.NewTagNotFoundError(serverId, tag)
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	case "PUT":
+		added, err := n.addServerTag(serverId, tag)
+		if err != nil {
+			return err
+		}
+		if !added {
+			writeResponse(w, http.StatusNoContent, nil)
+			return nil
+		}
+		w.Header().Set("Location", n.endpointURL(true, "/servers/"+serverId+"/tags/"+tag))
+		writeResponse(w, http.StatusCreated, nil)
+		return nil
+	case "DELETE":
+		if err := n.removeServerTag(serverId, tag); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "PUT", "DELETE")
+}
+
+// handleServerDiagnostics handles the servers/<id>/diagnostics HTTP
+// API. Before microversion 2.48 the metrics are returned as a flat
+// map, in the format of the libvirt driver.
+func (n *Nova) handleServerDiagnostics(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed("GET")
+	}
+	parts := n.serverPathParts(r)
+	if len(parts) != 2 || parts[1] != "diagnostics" {
+		return errNotFound
+	}
+	diagnostics, err := n.serverDiagnostics(parts[0])
+	if err != nil {
+		return err
+	}
+	if atLeastMicroversion(r, diagnosticsMicroversion) {
+		return sendJSON(http.StatusOK, diagnostics, w, r)
+	}
+	return sendJSON(http.StatusOK, legacyDiagnostics(diagnostics), w, r)
+}
+
+// legacyDiagnostics returns diagnostics in the flat format of
+// microversions before 2.48. Disks are named vda onwards and network
+// interfaces vnet0 onwards, and memory is given in KiB.
+func legacyDiagnostics(d *ServerDiagnostics) map[string]int {
+	metrics := map[string]int{
+		"memory": d.MemoryDetails.Maximum * 1024,
+	}
+	for i, cpu := range d.CPUDetails {
+		metrics[fmt.Sprintf("cpu%d_time", i)] = cpu.Time
+	}
+	for i, disk := range d.DiskDetails {
+		name := fmt.Sprintf("vd%c", 'a'+i)
+		metrics[name+"_read"] = disk.ReadBytes
+		metrics[name+"_read_req"] = disk.ReadRequests
+		metrics[name+"_write"] = disk.WriteBytes
+		metrics[name+"_write_req"] = disk.WriteRequests
+		metrics[name+"_errors"] = disk.ErrorsCount
+	}
+	for i, nic := range d.NICDetails {
+		name := fmt.Sprintf("vnet%d", i)
+		metrics[name+"_rx"] = nic.RxOctets
+		metrics[name+"_rx_drop"] = nic.RxDrop
+		metrics[name+"_rx_errors"] = nic.RxErrors
+		metrics[name+"_rx_packets"] = nic.RxPackets
+		metrics[name+"_tx"] = nic.TxOctets
+		metrics[name+"_tx_drop"] = nic.TxDrop
+		metrics[name+"_tx_errors"] = nic.TxErrors
+		metrics[name+"_tx_packets"] = nic.TxPackets
+	}
+	return metrics
+}
+
+// handleServerInterfaces handles the os-interface HTTP API of a
+// server.
+func (n *Nova) handleServerInterfaces(w http.ResponseWriter, r *http.Request) error {
+	parts := n.serverPathParts(r)
+	if len(parts) < 2 || len(parts) > 3 || parts[1] != "os-interface" {
+		return errNotFound
+	}
+	serverId := parts[0]
+	if len(parts) == 3 {
+		return n.handleServerInterface(serverId, parts[2], w, r)
+	}
+	switch r.Method {
+	case "GET":
+		attachments, err := n.serverInterfaces(serverId)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			InterfaceAttachments []InterfaceAttachment `json:"interfaceAttachments"`
+		}{attachments}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "POST":
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			InterfaceAttachment struct {
+				NetId    string    `json:"net_id"`
+				PortId   string    `json:"port_id"`
+				FixedIPs []FixedIP `json:"fixed_ips"`
+			} `json:"interfaceAttachment"`
+		}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &req); err != nil {
+				return errBadRequest3
+			}
+		}
+		var fixedIP string
+		if fixedIPs := req.InterfaceAttachment.FixedIPs; len(fixedIPs) > 0 {
+			fixedIP = fixedIPs[0].IPAddress
+		}
+		attachment, err := n.attachServerInterface(serverId, req.InterfaceAttachment.NetId, req.InterfaceAttachment.PortId, fixedIP)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			InterfaceAttachment InterfaceAttachment `json:"interfaceAttachment"`
+		}{*attachment}
+		return sendJSON(http.StatusOK, resp, w, r)
+	}
+	return errMethodNotAllowed("GET", "POST")
+}
+
+// handleServerInterface handles a single network interface of a
+// server, identified by the id of its port.
+func (n *Nova) handleServerInterface(serverId, portId string, w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case "GET":
+		attachment, err := n.serverInterface(serverId, portId)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			InterfaceAttachment InterfaceAttachment `json:"interfaceAttachment"`
+		}{*attachment}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "DELETE":
+		if err := n.detachServerInterface(serverId, portId); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "DELETE")
+}
+
+// handleKeyPairs handles the os-keypairs HTTP API. Key pairs belong
+// to the tenant of the authenticated user.
+func (n *Nova) handleKeyPairs(w http.ResponseWriter, r *http.Request) error {
+	user, err := userInfo(n.IdentityService, r)
+	if err != nil {
+		return err
+	}
+	name := path.Base(r.URL.Path)
+	switch r.Method {
+	case "GET":
+		if name != "os-keypairs" {
+			keyPair, err := n.keyPair(user.TenantId, name)
+			if err != nil {
+				return err
+			}
+			resp := struct {
+				KeyPair KeyPair `json:"keypair"`
+			}{*keyPair}
+			return sendJSON(http.StatusOK, resp, w, r)
+		}
+		type keyPairEntry struct {
+			KeyPair KeyPair `json:"keypair"`
+		}
+		entries := []keyPairEntry{}
+		for _, keyPair := range n.allKeyPairs(user.TenantId) {
+			entries = append(entries, keyPairEntry{keyPair})
+		}
+		resp := struct {
+			KeyPairs []keyPairEntry `json:"keypairs"`
+		}{entries}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "POST":
+		if name != "os-keypairs" {
+			return errNotFound
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			KeyPair *struct {
+				Name      string `json:"name"`
+				PublicKey string `json:"public_key"`
+			} `json:"keypair"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.KeyPair == nil {
+			return errBadRequest2
+		}
+		keyPair, err := n.addKeyPair(user.TenantId, KeyPair{
+			Name:      req.KeyPair.Name,
+			PublicKey: req.KeyPair.PublicKey,
+			UserId:    user.Id,
+		})
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			KeyPair KeyPair `json:"keypair"`
+		}{*keyPair}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "DELETE":
+		if name == "os-keypairs" {
+			return errNotFound
+		}
+		if err := n.removeKeyPair(user.TenantId, name); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "POST", "DELETE")
+}
+
+// handleServerGroups handles the os-server-groups HTTP API. As with
+// security groups, the server groups of other tenants are not found.
+func (n *Nova) handleServerGroups(w http.ResponseWriter, r *http.Request) error {
+	tenantId, err := n.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	visibleGroup := func(groupId string) (*ServerGroup, error) {
+		group, err := n.serverGroup(groupId)
+		if err != nil {
+			return nil, err
+		}
+		if tenantId != "" && group.TenantId != tenantId {
+			return nil, testservices.NewServerGroupNotFoundError(groupId)
+		}
+		return group, nil
+	}
+	groupId := path.Base(r.URL.Path)
+	switch r.Method {
+	case "GET":
+		if groupId != "os-server-groups" {
+			group, err := visibleGroup(groupId)
+			if err != nil {
+				return err
+			}
+			resp := struct {
+				ServerGroup ServerGroup `json:"server_group"`
+			}{*group}
+			return sendJSON(http.StatusOK, resp, w, r)
+		}
+		groups := []ServerGroup{}
+		for _, group := range n.allServerGroups() {
+			if tenantId == "" || group.TenantId == tenantId {
+				groups = append(groups, group)
+			}
+		}
+		resp := struct {
+			ServerGroups []ServerGroup `json:"server_groups"`
+		}{groups}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "POST":
+		if groupId != "os-server-groups" {
+			return errNotFound
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var req struct {
+			ServerGroup *struct {
+				Name     string   `json:"name"`
+				Policies []string `json:"policies"`
+			} `json:"server_group"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.ServerGroup == nil {
+			return errBadRequest2
+		}
+		if !atLeastMicroversion(r, softAffinityMicroversion) {
+			for _, policy := range req.ServerGroup.Policies {
+				if policy == softAffinityPolicy || policy == softAntiAffinityPolicy {
+					return testservices.NewInvalidServerGroupPolicyError(req.ServerGroup.Policies)
+				}
+			}
+		}
+		user, err := userInfo(n.IdentityService, r)
+		if err != nil {
+			return err
+		}
+		group, err := n.addServerGroup(user.TenantId, req.ServerGroup.Name, req.ServerGroup.Policies)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			ServerGroup ServerGroup `json:"server_group"`
+		}{*group}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "DELETE":
+		if groupId == "os-server-groups" {
+			return errNotFound
+		}
+		if _, err := visibleGroup(groupId); err != nil {
+			return err
+		}
+		if err := n.removeServerGroup(groupId); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	return errMethodNotAllowed("GET", "POST", "DELETE")
+}
+
+// handleQuotaSets handles the os-quota-sets HTTP API, which reports
+// the quotas of the tenant given in the URL.
+func (n *Nova) handleQuotaSets(w http.ResponseWriter, r *http.Request) error {
+	tenantId := path.Base(r.URL.Path)
+	if tenantId == "os-quota-sets" {
+		return errNotFound
+	}
+	if r.Method != "GET" {
+		return errMethodNotAllowed("GET")
+	}
+	quotas := n.tenantQuotas(tenantId)
+	resp := struct {
+		QuotaSet struct {
+			Id            string `json:"id"`
+			Instances     int    `json:"instances"`
+			Cores         int    `json:"cores"`
+			RAM           int    `json:"ram"`
+			FloatingIPs   int    `json:"floating_ips"`
+			MetadataItems int    `json:"metadata_items"`
+		} `json:"quota_set"`
+	}{}
+	resp.QuotaSet.Id = tenantId
+	resp.QuotaSet.Instances = quotas.Instances
+	resp.QuotaSet.Cores = quotas.Cores
+	resp.QuotaSet.RAM = quotas.RAM
+	resp.QuotaSet.FloatingIPs = quotas.FloatingIPs
+	resp.QuotaSet.MetadataItems = n.metadataQuota
+	return sendJSON(http.StatusOK, resp, w, r)
+}
+
+// handleLimits handles the limits HTTP API, which reports the quotas
+// and usage of the authenticated user's tenant. No rate limits are
+// reported.
+func (n *Nova) handleLimits(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed("GET")
+	}
+	user, err := userInfo(n.IdentityService, r)
+	if err != nil {
+		return err
+	}
+	quotas := n.tenantQuotas(user.TenantId)
+	usage := n.tenantUsage(user.TenantId)
+	resp := struct {
+		Limits struct {
+			Rate     []interface{}  `json:"rate"`
+			Absolute map[string]int `json:"absolute"`
+		} `json:"limits"`
+	}{}
+	resp.Limits.Rate = []interface{}{}
+	resp.Limits.Absolute = map[string]int{
+		"maxTotalInstances":    quotas.Instances,
+		"maxTotalCores":        quotas.Cores,
+		"maxTotalRAMSize":      quotas.RAM,
+		"maxTotalFloatingIps":  quotas.FloatingIPs,
+		"maxServerMeta":        n.metadataQuota,
+		"totalInstancesUsed":   usage.Instances,
+		"totalCoresUsed":       usage.Cores,
+		"totalRAMUsed":         usage.RAM,
+		"totalFloatingIpsUsed": usage.FloatingIPs,
+	}
+	return sendJSON(http.StatusOK, resp, w, r)
+}
+
+// hypervisorSummary is a hypervisor as listed by os-hypervisors.
+type hypervisorSummary struct {
+	Id       int    `json:"id"`
+	HostName string `json:"hypervisor_hostname"`
+	State    string `json:"state"`
+	Status   string `json:"status"`
+}
+
+// hypervisorDetail is a hypervisor as shown by os-hypervisors/detail.
+type hypervisorDetail struct {
+	hypervisorSummary
+	Type               string `json:"hypervisor_type"`
+	HostIP             string `json:"host_ip"`
+	VCPUs              int    `json:"vcpus"`
+	VCPUsUsed          int    `json:"vcpus_used"`
+	MemoryMB           int    `json:"memory_mb"`
+	MemoryMBUsed       int    `json:"memory_mb_used"`
+	FreeRAMMB          int    `json:"free_ram_mb"`
+	LocalGB            int    `json:"local_gb"`
+	LocalGBUsed        int    `json:"local_gb_used"`
+	FreeDiskGB         int    `json:"free_disk_gb"`
+	DiskAvailableLeast int    `json:"disk_available_least"`
+	RunningVMs         int    `json:"running_vms"`
+	CurrentWorkload    int    `json:"current_workload"`
+	Service            struct {
+		Host           string  `json:"host"`
+		Id             int     `json:"id"`
+		DisabledReason *string `json:"disabled_reason"`
+	} `json:"service"`
+}
+
+// hypervisorDetails returns the details of a hypervisor, including the
+// resources used on it.
+func (n *Nova) hypervisorDetails(h Hypervisor) hypervisorDetail {
+	usage := n.hypervisorUsage(h.Id)
+	detail := hypervisorDetail{
+		hypervisorSummary:  hypervisorSummary{h.Id, h.HostName, h.State, h.Status},
+		Type:               h.Type,
+		HostIP:             h.HostIP,
+		VCPUs:              h.VCPUs,
+		VCPUsUsed:          usage.VCPUs,
+		MemoryMB:           h.MemoryMB,
+		MemoryMBUsed:       usage.MemoryMB,
+		FreeRAMMB:          h.MemoryMB - usage.MemoryMB,
+		LocalGB:            h.LocalGB,
+		LocalGBUsed:        usage.LocalGB,
+		FreeDiskGB:         h.LocalGB - usage.LocalGB,
+		DiskAvailableLeast: h.LocalGB - usage.LocalGB,
+		RunningVMs:         usage.RunningVMs,
+	}
+	detail.Service.Host = h.HostName
+	detail.Service.Id = h.Id
+	return detail
+}
+
+// handleHypervisors handles the os-hypervisors HTTP API, which lists
+// the hypervisors, shows their details and sums their capacity and
+// usage, for admins only.
+func (n *Nova) handleHypervisors(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed("GET")
+	}
+	prefix := fmt.Sprintf("/%s/%s/os-hypervisors", n.VersionPath, n.TenantId)
+	switch rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/"); rest {
+	case "":
+		hypervisors := []hypervisorSummary{}
+		for _, h := range n.allHypervisors() {
+			hypervisors = append(hypervisors, hypervisorSummary{h.Id, h.HostName, h.State, h.Status})
+		}
+		resp := struct {
+			Hypervisors []hypervisorSummary `json:"hypervisors"`
+		}{hypervisors}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "detail":
+		hypervisors := []hypervisorDetail{}
+		for _, h := range n.allHypervisors() {
+			hypervisors = append(hypervisors, n.hypervisorDetails(h))
+		}
+		resp := struct {
+			Hypervisors []hypervisorDetail `json:"hypervisors"`
+		}{hypervisors}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "statistics":
+		var stats struct {
+			Count              int `json:"count"`
+			VCPUs              int `json:"vcpus"`
+			VCPUsUsed          int `json:"vcpus_used"`
+			MemoryMB           int `json:"memory_mb"`
+			MemoryMBUsed       int `json:"memory_mb_used"`
+			FreeRAMMB          int `json:"free_ram_mb"`
+			LocalGB            int `json:"local_gb"`
+			LocalGBUsed        int `json:"local_gb_used"`
+			FreeDiskGB         int `json:"free_disk_gb"`
+			DiskAvailableLeast int `json:"disk_available_least"`
+			RunningVMs         int `json:"running_vms"`
+			CurrentWorkload    int `json:"current_workload"`
+		}
+		for _, h := range n.allHypervisors() {
+			detail := n.hypervisorDetails(h)
+			stats.Count++
+			stats.VCPUs += detail.VCPUs
+			stats.VCPUsUsed += detail.VCPUsUsed
+			stats.MemoryMB += detail.MemoryMB
+			stats.MemoryMBUsed += detail.MemoryMBUsed
+			stats.FreeRAMMB += detail.FreeRAMMB
+			stats.LocalGB += detail.LocalGB
+			stats.LocalGBUsed += detail.LocalGBUsed
+			stats.FreeDiskGB += detail.FreeDiskGB
+			stats.DiskAvailableLeast += detail.DiskAvailableLeast
+			stats.RunningVMs += detail.RunningVMs
+		}
+		resp := struct {
+			Statistics interface{} `json:"hypervisor_statistics"`
+		}{stats}
+		return sendJSON(http.StatusOK, resp, w, r)
+	default:
+		id, err := strconv.Atoi(rest)
+		if err != nil {
+			return errNotFoundJSON
+		}
+		h, err := n.hypervisor(id)
+		if err != nil {
+			return err
+		}
+		resp := struct {
+			Hypervisor hypervisorDetail `json:"hypervisor"`
+		}{n.hypervisorDetails(*h)}
+		return sendJSON(http.StatusOK, resp, w, r)
+	}
+}
+
+// handleHosts handles the os-hosts HTTP API, which lists the compute
+// hosts, for admins only. Each hypervisor has a host of the same name,
+// in the default availability zone.
+func (n *Nova) handleHosts(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return errMethodNotAllowed("GET")
+	}
+	if host := path.Base(r.URL.Path); host != "os-hosts" {
+		return errNotFoundJSON
+	}
+	type host struct {
+		HostName string `json:"host_name"`
+		Service  string `json:"service"`
+		Zone     string `json:"zone"`
+	}
+	hosts := []host{}
+	for _, h := range n.allHypervisors() {
+		hosts = append(hosts, host{h.HostName, "compute", "nova"})
+	}
+	resp := struct {
+		Hosts []host `json:"hosts"`
+	}{hosts}
+	return sendJSON(http.StatusOK, resp, w, r)
 }
 
 func (n *Nova) handleAttachVolumes(w http.ResponseWriter, r *http.Request) error {
@@ -1074,15 +2796,13 @@ func (n *Nova) handleAttachVolumes(w http.ResponseWriter, r *http.Request) error
 	if err := json.Unmarshal(bodyBytes, &attachment); err != nil {
 		return err
 	}
-	n.nextAttachmentId++
-	attachment.Id = fmt.Sprintf("%d", n.nextAttachmentId)
-
-	serverVols := n.serverIdToAttachedVolumes[serverId]
-	serverVols = append(serverVols, attachment)
-	n.serverIdToAttachedVolumes[serverId] = serverVols
+	added, err := n.attachVolume(serverId, attachment)
+	if err != nil {
+		return err
+	}
 
 	// Echo the request back with an attachment ID.
-	resp, err := json.Marshal(attachment)
+	resp, err := json.Marshal(added)
 	if err != nil {
 		return err
 	}
@@ -1093,17 +2813,7 @@ func (n *Nova) handleAttachVolumes(w http.ResponseWriter, r *http.Request) error
 func (n *Nova) handleDetachVolumes(w http.ResponseWriter, r *http.Request) error {
 	attachId := path.Base(r.URL.Path)
 	serverId := path.Base(strings.Replace(r.URL.Path, "/os-volume_attachments/"+attachId, "", 1))
-	serverVols := n.serverIdToAttachedVolumes[serverId]
-
-	for volIdx, vol := range serverVols {
-		if vol.Id == attachId {
-			serverVols = append(serverVols[:volIdx], serverVols[volIdx+1:]...)
-			n.serverIdToAttachedVolumes[serverId] = serverVols
-			return nil
-		}
-	}
-
-	return errors.NewNotFoundf(nil, nil, "no such attachment id: %v", attachId)
+	return n.detachVolume(serverId, attachId)
 }
 
 func (n *Nova) handleListVolumes(w http.ResponseWriter, r *http.Request) error {
@@ -1122,18 +2832,24 @@ func (n *Nova) handleListVolumes(w http.ResponseWriter, r *http.Request) error {
 // setupHTTP attaches all the needed handlers to provide the HTTP API.
 func (n *Nova) SetupHTTP(mux *http.ServeMux) {
 	handlers := map[string]http.Handler{
-		"/":                              n.handler((*Nova).handleRoot),
+		"/":                              n.handler((*Nova).handleRoot, "GET"),
 		"/$v/":                           errBadRequest,
 		"/$v/$t/":                        errNotFound,
-		"/$v/$t/flavors":                 n.handler((*Nova).handleFlavors),
-		"/$v/$t/flavors/detail":          n.handler((*Nova).handleFlavorsDetail),
-		"/$v/$t/servers":                 n.handler((*Nova).handleServers),
-		"/$v/$t/servers/detail":          n.handler((*Nova).handleServersDetail),
-		"/$v/$t/os-security-groups":      n.handler((*Nova).handleSecurityGroups),
-		"/$v/$t/os-security-group-rules": n.handler((*Nova).handleSecurityGroupRules),
-		"/$v/$t/os-floating-ips":         n.handler((*Nova).handleFloatingIPs),
-		"/$v/$t/os-networks":             n.handler((*Nova).handleNetworks),
-		"/$v/$t/os-availability-zone":    n.handler((*Nova).handleAvailabilityZones),
+		"/$v/$t/flavors":                 n.handler((*Nova).handleFlavors, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/flavors/detail":          n.handler((*Nova).handleFlavorsDetail, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/servers":                 n.handler((*Nova).handleServers, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/servers/detail":          n.handler((*Nova).handleServersDetail, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/os-security-groups":      n.handler((*Nova).handleSecurityGroups, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/os-security-group-rules": n.handler((*Nova).handleSecurityGroupRules, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/os-floating-ips":         n.handler((*Nova).handleFloatingIPs, "GET", "POST", "PUT", "DELETE"),
+		"/$v/$t/os-networks":             n.handler((*Nova).handleNetworks, "GET"),
+		"/$v/$t/os-availability-zone":    n.handler((*Nova).handleAvailabilityZones, "GET"),
+		"/$v/$t/os-keypairs":             n.handler((*Nova).handleKeyPairs, "GET", "POST", "DELETE"),
+		"/$v/$t/os-server-groups":        n.handler((*Nova).handleServerGroups, "GET", "POST", "DELETE"),
+		"/$v/$t/os-quota-sets":           n.handler((*Nova).handleQuotaSets, "GET"),
+		"/$v/$t/limits":                  n.handler((*Nova).handleLimits, "GET"),
+		"/$v/$t/os-hypervisors":          n.handler((*Nova).handleHypervisors, "GET"),
+		"/$v/$t/os-hosts":                n.handler((*Nova).handleHosts, "GET"),
 	}
 	for path, h := range handlers {
 		path = strings.Replace(path, "$v", n.VersionPath, 1)
@@ -8,15 +8,22 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	gc "gopkg.in/check.v1"
 
+	"gopkg.in/goose.v1/cinder"
 	"gopkg.in/goose.v1/nova"
+	"gopkg.in/goose.v1/testing/clock"
 	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/hook"
 	"gopkg.in/goose.v1/testservices/identityservice"
+	"gopkg.in/goose.v1/testservices/volumeservice"
 )
 
 type NovaHTTPSuite struct {
@@ -468,6 +475,27 @@ func (s *NovaHTTPSuite) TestSimpleRequestTests(c *gc.C) {
 	fmt.Printf("total: %d\n", len(simpleTests))
 }
 
+func (s *NovaHTTPSuite) TestMethodNotAllowed(c *gc.C) {
+	for i, t := range []struct {
+		method string
+		url    string
+		allow  string
+	}{
+		{"PATCH", "/flavors", "GET, POST, PUT, DELETE"},
+		{"PATCH", "/servers/1", "GET, POST, PUT, DELETE"},
+		{"POST", "/os-networks", "GET"},
+		{"DELETE", "/limits", "GET"},
+		{"PATCH", "/os-keypairs", "GET, POST, DELETE"},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.url)
+		resp, err := s.authRequest(t.method, t.url, nil, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusMethodNotAllowed)
+		c.Assert(resp.Header.Get("Allow"), gc.Equals, t.allow)
+		assertBody(c, resp, errMethodNotAllowed())
+	}
+}
+
 func (s *NovaHTTPSuite) TestGetFlavors(c *gc.C) {
 	// The test service has 3 default flavours.
 	var expected struct {
@@ -493,6 +521,23 @@ func (s *NovaHTTPSuite) TestGetFlavors(c *gc.C) {
 	c.Assert(expectedFlavor.Flavor.Name, gc.Equals, "m1.tiny")
 }
 
+func (s *NovaHTTPSuite) TestRequiredRole(c *gc.C) {
+	cleanup := s.service.RequireRole("/"+versionPath+"/"+s.service.TenantId+"/flavors", "admin")
+	defer cleanup()
+	resp, err := s.authRequest("GET", "/flavors", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusForbidden)
+	var expected identityservice.ErrorWrapper
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Error.Code, gc.Equals, http.StatusForbidden)
+	c.Assert(expected.Error.Message, gc.Matches, `.*requires role "admin".*`)
+	// Other paths are unaffected.
+	resp, err = s.authRequest("GET", "/servers", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
 func (s *NovaHTTPSuite) TestGetFlavorsDetail(c *gc.C) {
 	// The test service has 3 default flavours.
 	flavors := s.service.allFlavors()
@@ -513,6 +558,125 @@ func (s *NovaHTTPSuite) TestGetFlavorsDetail(c *gc.C) {
 	assertBody(c, resp, errNotFound)
 }
 
+func (s *NovaHTTPSuite) TestGetFlavorsByName(c *gc.C) {
+	for _, path := range []string{"/flavors", "/flavors/detail"} {
+		var flavors struct {
+			Flavors []nova.Entity
+		}
+		resp, err := s.authRequest("GET", path+"?name=m1.small", nil, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		assertJSON(c, resp, &flavors)
+		c.Assert(flavors.Flavors, gc.HasLen, 1, gc.Commentf("%s", path))
+		c.Assert(flavors.Flavors[0].Id, gc.Equals, "2")
+		c.Assert(flavors.Flavors[0].Name, gc.Equals, "m1.small")
+
+		// Only exact matches are returned, and an empty list is
+		// not an error.
+		resp, err = s.authRequest("GET", path+"?name=m1", nil, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(string(body), gc.Equals, `{"flavors":[]}`)
+	}
+}
+
+func (s *NovaHTTPSuite) TestFlavorExtraSpecs(c *gc.C) {
+	defer s.service.removeFlavorExtraSpec("1", "hw:cpu_policy")
+	var specs struct {
+		ExtraSpecs map[string]string `json:"extra_specs"`
+	}
+	resp, err := s.authRequest("GET", "/flavors/1/os-extra_specs", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &specs)
+	c.Assert(specs.ExtraSpecs, gc.DeepEquals, map[string]string{})
+
+	req := map[string]map[string]string{"extra_specs": {"hw:cpu_policy": "dedicated", "a": "1"}}
+	resp, err = s.jsonRequest("POST", "/flavors/1/os-extra_specs", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &specs)
+	c.Assert(specs.ExtraSpecs, gc.DeepEquals, req["extra_specs"])
+
+	resp, err = s.jsonRequest("PUT", "/flavors/1/os-extra_specs/a", map[string]string{"a": "2"}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var item map[string]string
+	resp, err = s.authRequest("GET", "/flavors/1/os-extra_specs/a", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &item)
+	c.Assert(item, gc.DeepEquals, map[string]string{"a": "2"})
+
+	resp, err = s.authRequest("DELETE", "/flavors/1/os-extra_specs/a", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+
+	// The flavor's details include its extra specs.
+	var detail struct {
+		Flavor nova.FlavorDetail
+	}
+	resp, err = s.authRequest("GET", "/flavors/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Flavor.ExtraSpecs, gc.DeepEquals, map[string]string{"hw:cpu_policy": "dedicated"})
+	var details struct {
+		Flavors []nova.FlavorDetail
+	}
+	resp, err = s.authRequest("GET", "/flavors/detail", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &details)
+	c.Assert(details.Flavors[0].ExtraSpecs, gc.DeepEquals, map[string]string{"hw:cpu_policy": "dedicated"})
+	c.Assert(details.Flavors[1].ExtraSpecs, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestFlavorExtraSpecsErrors(c *gc.C) {
+	for i, t := range []struct {
+		method string
+		url    string
+		body   interface{}
+		code   int
+	}{
+		{"GET", "/flavors/99/os-extra_specs", nil, http.StatusNotFound},
+		{"GET", "/flavors/1/os-extra_specs/unknown", nil, http.StatusNotFound},
+		{"DELETE", "/flavors/1/os-extra_specs/unknown", nil, http.StatusNotFound},
+		{"POST", "/flavors/1/os-extra_specs", map[string]interface{}{}, http.StatusBadRequest},
+		{"POST", "/flavors/1/os-extra_specs", map[string]map[string]int{"extra_specs": {"a": 1}}, http.StatusBadRequest},
+		{"PUT", "/flavors/1/os-extra_specs/a", map[string]string{"b": "1"}, http.StatusBadRequest},
+		{"PUT", "/flavors/1/os-extra_specs/a", map[string]string{"a": "1", "b": "1"}, http.StatusBadRequest},
+		{"PUT", "/flavors/1/os-extra_specs", map[string]string{"a": "1"}, http.StatusMethodNotAllowed},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.url)
+		resp, err := s.jsonRequest(t.method, t.url, t.body, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(resp.StatusCode, gc.Equals, t.code)
+		resp.Body.Close()
+	}
+	specs, err := s.service.flavorExtraSpecs("1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(specs, gc.DeepEquals, map[string]string{})
+}
+
+func (s *NovaHTTPSuite) TestRunServerUnsatisfiableExtraSpecs(c *gc.C) {
+	err := s.service.setFlavorExtraSpecs("1", map[string]string{"capabilities:cpu_arch": "arm64"})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFlavorExtraSpec("1", "capabilities:cpu_arch")
+	s.service.SetHostCapabilities(map[string]string{"cpu_arch": "x86_64"})
+	defer s.service.SetHostCapabilities(nil)
+	resp := s.runServerOnNetworks(c)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"No valid host was found. There are not enough hosts available.", "code":400}}`,
+	})
+	c.Assert(s.service.allServers(nil), gc.HasLen, 0)
+}
+
 func (s *NovaHTTPSuite) TestGetServers(c *gc.C) {
 	entities := s.service.allServersAsEntities(nil)
 	c.Assert(entities, gc.HasLen, 0)
@@ -588,18 +752,6 @@ func (s *NovaHTTPSuite) TestGetServersWithFilters(c *gc.C) {
 	c.Assert(expected.Servers[0].Name, gc.Equals, servers[0].Name)
 }
 
-func (s *NovaHTTPSuite) TestNewUUID(c *gc.C) {
-	uuid, err := newUUID()
-	c.Assert(err, gc.IsNil)
-	var p1, p2, p3, p4, p5 string
-	num, err := fmt.Sscanf(uuid, "%8x-%4x-%4x-%4x-%12x", &p1, &p2, &p3, &p4, &p5)
-	c.Assert(err, gc.IsNil)
-	c.Assert(num, gc.Equals, 5)
-	uuid2, err := newUUID()
-	c.Assert(err, gc.IsNil)
-	c.Assert(uuid2, gc.Not(gc.Equals), uuid)
-}
-
 func (s *NovaHTTPSuite) assertAddresses(c *gc.C, serverId string) {
 	server, err := s.service.server(serverId)
 	c.Assert(err, gc.IsNil)
@@ -618,6 +770,17 @@ func (s *NovaHTTPSuite) assertAddresses(c *gc.C, serverId string) {
 	}
 }
 
+func (s *NovaHTTPSuite) TestRequestBodyTooLarge(c *gc.C) {
+	s.service.MaxBodyBytes = 16
+	defer func() { s.service.MaxBodyBytes = 0 }()
+	body := []byte(`{"server": {"name": "a server with a long name"}}`)
+	resp, err := s.authRequest("POST", "/servers", body, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusRequestEntityTooLarge)
+	c.Assert(s.service.allServers(nil), gc.HasLen, 0)
+}
+
 func (s *NovaHTTPSuite) TestRunServer(c *gc.C) {
 	entities := s.service.allServersAsEntities(nil)
 	c.Assert(entities, gc.HasLen, 0)
@@ -644,6 +807,16 @@ func (s *NovaHTTPSuite) TestRunServer(c *gc.C) {
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
 	assertBody(c, resp, errBadRequestSrvFlavor)
 	req.Server.FlavorRef = "flavor"
+	resp, err = s.jsonRequest("POST", "/servers", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	assertBody(c, resp, errBadRequestSrvFlavorNotFound)
+	req.Server.FlavorRef = "1"
+	resp, err = s.jsonRequest("POST", "/servers", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	assertBody(c, resp, errBadRequestSrvImageNotFound)
+	req.Server.ImageRef = "1"
 	var expected struct {
 		Server struct {
 			SecurityGroups []map[string]string `json:"security_groups"`
@@ -665,6 +838,9 @@ func (s *NovaHTTPSuite) TestRunServer(c *gc.C) {
 	srv, err := s.service.server(expected.Server.Id)
 	c.Assert(err, gc.IsNil)
 	c.Assert(srv.Links, gc.DeepEquals, expected.Server.Links)
+	c.Assert(srv.Status, gc.Equals, nova.StatusBuild)
+	c.Assert(srv.Flavor.Id, gc.Equals, "1")
+	c.Assert(srv.Image.Id, gc.Equals, "1")
 	s.service.removeServer(srv.Id)
 	req.Server.Name = "test2"
 	req.Server.SecurityGroups = []map[string]string{
@@ -697,530 +873,3445 @@ func (s *NovaHTTPSuite) TestRunServer(c *gc.C) {
 	s.service.removeServer(srv.Id)
 }
 
-func (s *NovaHTTPSuite) TestDeleteServer(c *gc.C) {
-	server := nova.ServerDetail{Id: "sr1"}
-	_, err := s.service.server(server.Id)
-	c.Assert(err, gc.NotNil)
-	err = s.service.addServer(server)
-	c.Assert(err, gc.IsNil)
-	defer s.service.removeServer(server.Id)
-	resp, err := s.authRequest("DELETE", "/servers/sr1", nil, nil)
-	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
-	_, err = s.service.server(server.Id)
-	c.Assert(err, gc.NotNil)
-}
-
-func (s *NovaHTTPSuite) TestGetServersDetail(c *gc.C) {
-	servers := s.service.allServers(nil)
-	c.Assert(servers, gc.HasLen, 0)
+func (s *NovaHTTPSuite) TestRunServerWithRefURLs(c *gc.C) {
+	var req struct {
+		Server struct {
+			FlavorRef string `json:"flavorRef"`
+			ImageRef  string `json:"imageRef"`
+			Name      string `json:"name"`
+		} `json:"server"`
+	}
+	req.Server.Name = "srv1"
+	req.Server.FlavorRef = s.service.endpointURL(true, "/flavors/2")
+	req.Server.ImageRef = s.service.endpointURL(true, "/images/1")
 	var expected struct {
-		Servers []nova.ServerDetail `json:"servers"`
+		Server struct {
+			Id string
+		}
 	}
-	resp, err := s.authRequest("GET", "/servers/detail", nil, nil)
+	resp, err := s.jsonRequest("POST", "/servers", req, nil)
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
 	assertJSON(c, resp, &expected)
-	c.Assert(expected.Servers, gc.HasLen, 0)
-	servers = []nova.ServerDetail{
-		{Id: "sr1", Name: "server 1"},
-		{Id: "sr2", Name: "server 2"},
-	}
-	for i, server := range servers {
-		s.service.buildServerLinks(&server)
-		servers[i] = server
-		err := s.service.addServer(server)
-		c.Assert(err, gc.IsNil)
-		defer s.service.removeServer(server.Id)
+	defer s.service.removeServer(expected.Server.Id)
+	srv, err := s.service.server(expected.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(srv.Flavor.Id, gc.Equals, "2")
+	c.Assert(srv.Image.Id, gc.Equals, "1")
+
+	err = s.service.SetServerStatus(srv.Id, nova.StatusActive)
+	c.Assert(err, gc.IsNil)
+	var detail struct {
+		Server nova.ServerDetail
 	}
-	resp, err = s.authRequest("GET", "/servers/detail", nil, nil)
+	resp, err = s.authRequest("GET", "/servers/"+srv.Id, nil, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Servers, gc.HasLen, 2)
-	if expected.Servers[0].Id != servers[0].Id {
-		expected.Servers[0], expected.Servers[1] = expected.Servers[1], expected.Servers[0]
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Server.Status, gc.Equals, nova.StatusActive)
+}
+
+func (s *NovaHTTPSuite) TestRunServerUserDataAndConfigDrive(c *gc.C) {
+	// The client encodes the user data as base64.
+	req := struct {
+		Server nova.RunServerOpts `json:"server"`
+	}{nova.RunServerOpts{
+		Name:        "srv1",
+		FlavorId:    "1",
+		ImageId:     "1",
+		UserData:    []byte("#cloud-config\npackages: [git]\n"),
+		ConfigDrive: true,
+	}}
+	var created struct {
+		Server struct {
+			Id string
+		}
 	}
-	c.Assert(expected.Servers, gc.DeepEquals, servers)
-	resp, err = s.authRequest("GET", "/servers/detail/sr1", nil, nil)
+	resp, err := s.jsonRequest("POST", "/servers", req, nil)
 	c.Assert(err, gc.IsNil)
-	assertBody(c, resp, errNotFound)
-}
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, resp, &created)
+	defer s.service.removeServer(created.Server.Id)
+	userData, err := s.service.ServerUserData(created.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(userData), gc.Equals, "#cloud-config\npackages: [git]\n")
 
-func (s *NovaHTTPSuite) TestGetServersDetailWithFilters(c *gc.C) {
-	servers := s.service.allServers(nil)
-	c.Assert(servers, gc.HasLen, 0)
-	var expected struct {
-		Servers []nova.ServerDetail `json:"servers"`
+	var detail struct {
+		Server nova.ServerDetail
 	}
-	url := "/servers/detail?status=RESCUE&status=BUILD&name=srv2&name=srv1"
-	resp, err := s.authRequest("GET", url, nil, nil)
+	resp, err = s.authRequest("GET", "/servers/"+created.Server.Id, nil, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Servers, gc.HasLen, 0)
-	servers = []nova.ServerDetail{
-		{Id: "sr1", Name: "srv1", Status: nova.StatusBuild},
-		{Id: "sr2", Name: "srv2", Status: nova.StatusRescue},
-		{Id: "sr3", Name: "srv3", Status: nova.StatusActive},
-	}
-	for i, server := range servers {
-		s.service.buildServerLinks(&server)
-		servers[i] = server
-		err := s.service.addServer(server)
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Server.ConfigDrive, gc.Equals, "True")
+	err = s.service.SetServerStatus(created.Server.Id, nova.StatusActive)
+	c.Assert(err, gc.IsNil)
+	diagnostics, err := s.service.serverDiagnostics(created.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(diagnostics.ConfigDrive, gc.Equals, true)
+
+	// Servers have neither unless they are asked for; config_drive
+	// may also be given as a string.
+	body := map[string]map[string]interface{}{"server": {
+		"name": "srv2", "flavorRef": "1", "imageRef": "1", "config_drive": "false",
+	}}
+	resp, err = s.jsonRequest("POST", "/servers", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, resp, &created)
+	defer s.service.removeServer(created.Server.Id)
+	userData, err = s.service.ServerUserData(created.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(userData, gc.IsNil)
+	srv, err := s.service.server(created.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(srv.ConfigDrive, gc.Equals, "")
+
+	_, err = s.service.ServerUserData("missing")
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: No such server \"missing\"")
+}
+
+func (s *NovaHTTPSuite) TestRunServerInvalidUserDataAndConfigDrive(c *gc.C) {
+	long := strings.Repeat("A", 65536)
+	for i, t := range []struct {
+		field   string
+		value   interface{}
+		message string
+	}{{
+		field:   "user_data",
+		value:   "not base64!",
+		message: `Invalid input for field/attribute user_data. Value: not base64!. 'not base64!' is not a 'base64'`,
+	}, {
+		field:   "user_data",
+		value:   "YWJj=",
+		message: `Invalid input for field/attribute user_data. Value: YWJj=. 'YWJj=' is not a 'base64'`,
+	}, {
+		field:   "user_data",
+		value:   long,
+		message: `Invalid input for field/attribute user_data. Value: ` + long + `. '` + long + `' is too long`,
+	}, {
+		field:   "config_drive",
+		value:   "maybe",
+		message: `Invalid input for field/attribute config_drive. Value: maybe. maybe is not of type 'boolean', 'string'`,
+	}, {
+		field:   "config_drive",
+		value:   1,
+		message: `Invalid input for field/attribute config_drive. Value: 1. 1 is not of type 'boolean', 'string'`,
+	}} {
+		c.Logf("test %d: %s %v", i, t.field, t.value)
+		body := map[string]map[string]interface{}{"server": {
+			"name": "srv", "flavorRef": "1", "imageRef": "1", t.field: t.value,
+		}}
+		resp, err := s.jsonRequest("POST", "/servers", body, nil)
 		c.Assert(err, gc.IsNil)
-		defer s.service.removeServer(server.Id)
+		c.Check(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+		var errBody struct {
+			BadRequest struct {
+				Message string
+			} `json:"badRequest"`
+		}
+		assertJSON(c, resp, &errBody)
+		c.Check(errBody.BadRequest.Message, gc.Equals, t.message)
 	}
-	resp, err = s.authRequest("GET", url, nil, nil)
+	c.Assert(s.service.allServers(nil), gc.HasLen, 0)
+}
+
+// runServerOnNetworks creates a server attached to the given
+// networks, returning the response.
+func (s *NovaHTTPSuite) runServerOnNetworks(c *gc.C, networks ...map[string]string) *http.Response {
+	var req struct {
+		Server struct {
+			FlavorRef string              `json:"flavorRef"`
+			ImageRef  string              `json:"imageRef"`
+			Name      string              `json:"name"`
+			Networks  []map[string]string `json:"networks"`
+		} `json:"server"`
+	}
+	req.Server.Name = "srv"
+	req.Server.FlavorRef = "1"
+	req.Server.ImageRef = "1"
+	req.Server.Networks = networks
+	resp, err := s.jsonRequest("POST", "/servers", req, nil)
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Servers, gc.HasLen, 1)
-	c.Assert(expected.Servers[0], gc.DeepEquals, servers[0])
+	return resp
 }
 
-func (s *NovaHTTPSuite) TestGetSecurityGroups(c *gc.C) {
-	// There is always a default security group.
-	groups := s.service.allSecurityGroups()
-	c.Assert(groups, gc.HasLen, 1)
+func (s *NovaHTTPSuite) TestRunServerNetworkAddresses(c *gc.C) {
 	var expected struct {
-		Groups []nova.SecurityGroup `json:"security_groups"`
+		Server struct {
+			Id string
+		}
 	}
-	resp, err := s.authRequest("GET", "/os-security-groups", nil, nil)
-	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	resp := s.runServerOnNetworks(c, map[string]string{"uuid": "1"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
 	assertJSON(c, resp, &expected)
-	c.Assert(expected.Groups, gc.HasLen, 1)
-	groups = []nova.SecurityGroup{
-		{
-			Id:       "1",
-			Name:     "group 1",
-			TenantId: s.service.TenantId,
-			Rules:    []nova.SecurityGroupRule{},
-		},
-		{
-			Id:       "2",
-			Name:     "group 2",
-			TenantId: s.service.TenantId,
-			Rules:    []nova.SecurityGroupRule{},
-		},
-	}
-	for _, group := range groups {
-		err := s.service.addSecurityGroup(group)
-		c.Assert(err, gc.IsNil)
-		defer s.service.removeSecurityGroup(group.Id)
-	}
-	resp, err = s.authRequest("GET", "/os-security-groups", nil, nil)
-	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	defer s.service.removeServer(expected.Server.Id)
+	first := expected.Server.Id
+
+	resp = s.runServerOnNetworks(c, map[string]string{"uuid": "1"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
 	assertJSON(c, resp, &expected)
-	c.Assert(expected.Groups, gc.HasLen, len(groups)+1)
-	checkGroupsInList(c, groups, expected.Groups)
-	var expectedGroup struct {
-		Group nova.SecurityGroup `json:"security_group"`
+	defer s.service.removeServer(expected.Server.Id)
+
+	var detail struct {
+		Server nova.ServerDetail
 	}
-	resp, err = s.authRequest("GET", "/os-security-groups/1", nil, nil)
+	resp, err := s.authRequest("GET", "/servers/"+first, nil, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expectedGroup)
-	c.Assert(expectedGroup.Group, gc.DeepEquals, groups[0])
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Server.Addresses, gc.DeepEquals, map[string][]nova.IPAddress{
+		"net": {{Version: 4, Address: "10.0.0.2", Type: "fixed"}},
+	})
+	srv, err := s.service.server(expected.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(srv.Addresses, gc.DeepEquals, map[string][]nova.IPAddress{
+		"net": {{Version: 4, Address: "10.0.0.3", Type: "fixed"}},
+	})
 }
 
-func (s *NovaHTTPSuite) TestAddSecurityGroup(c *gc.C) {
-	group := nova.SecurityGroup{
-		Id:          "1",
-		Name:        "group 1",
-		Description: "desc",
-		TenantId:    s.service.TenantId,
-		Rules:       []nova.SecurityGroupRule{},
-	}
-	_, err := s.service.securityGroup(group.Id)
-	c.Assert(err, gc.NotNil)
-	var req struct {
-		Group struct {
-			Name        string `json:"name"`
-			Description string `json:"description"`
-		} `json:"security_group"`
-	}
-	req.Group.Name = group.Name
-	req.Group.Description = group.Description
+func (s *NovaHTTPSuite) TestRunServerFixedIP(c *gc.C) {
 	var expected struct {
-		Group nova.SecurityGroup `json:"security_group"`
+		Server struct {
+			Id string
+		}
 	}
-	resp, err := s.jsonRequest("POST", "/os-security-groups", req, nil)
-	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	resp := s.runServerOnNetworks(c, map[string]string{"uuid": "1", "fixed_ip": "10.0.0.42"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
 	assertJSON(c, resp, &expected)
-	c.Assert(expected.Group, gc.DeepEquals, group)
-	err = s.service.removeSecurityGroup(group.Id)
+	defer s.service.removeServer(expected.Server.Id)
+	srv, err := s.service.server(expected.Server.Id)
 	c.Assert(err, gc.IsNil)
+	c.Assert(srv.Addresses["net"], gc.DeepEquals, []nova.IPAddress{{Version: 4, Address: "10.0.0.42", Type: "fixed"}})
+
+	resp = s.runServerOnNetworks(c, map[string]string{"uuid": "1", "fixed_ip": "10.0.0.42"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"Fixed IP address 10.0.0.42 is already in use on instance ` + srv.UUID + `.", "code":400}}`,
+	})
+	resp = s.runServerOnNetworks(c, map[string]string{"uuid": "1", "fixed_ip": "10.1.0.1"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"Fixed IP address (10.1.0.1) does not exist in network (1).", "code":400}}`,
+	})
+	c.Assert(s.service.allServers(nil), gc.HasLen, 1)
 }
 
-func (s *NovaHTTPSuite) TestDeleteSecurityGroup(c *gc.C) {
-	group := nova.SecurityGroup{Id: "1", Name: "group 1"}
-	_, err := s.service.securityGroup(group.Id)
-	c.Assert(err, gc.NotNil)
-	err = s.service.addSecurityGroup(group)
+func (s *NovaHTTPSuite) TestRunServerDualStack(c *gc.C) {
+	s.service.AddNetwork(nova.Network{Id: "2", Label: "dual", Cidr: "10.2.0.0/24", CidrV6: "fd00:2::/64"})
+	defer delete(s.service.networks, "2")
+	var created struct {
+		Server struct {
+			Id string
+		}
+	}
+	resp := s.runServerOnNetworks(c, map[string]string{"uuid": "2"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, resp, &created)
+	defer s.service.removeServer(created.Server.Id)
+	resp, err := s.authRequest("GET", "/servers/"+created.Server.Id, nil, nil)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group.Id)
-	resp, err := s.authRequest("DELETE", "/os-security-groups/1", nil, nil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var shown struct {
+		Server struct {
+			Addresses map[string][]map[string]interface{}
+		}
+	}
+	assertJSON(c, resp, &shown)
+	c.Assert(shown.Server.Addresses, gc.DeepEquals, map[string][]map[string]interface{}{
+		"dual": {
+			{"version": 4.0, "addr": "10.2.0.2", "OS-EXT-IPS:type": "fixed"},
+			{"version": 6.0, "addr": "fd00:2::2", "OS-EXT-IPS:type": "fixed"},
+		},
+	})
+
+	// A requested address is used for its IP version.
+	resp = s.runServerOnNetworks(c, map[string]string{"uuid": "2", "fixed_ip": "fd00:2::42"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, resp, &created)
+	defer s.service.removeServer(created.Server.Id)
+	srv, err := s.service.server(created.Server.Id)
 	c.Assert(err, gc.IsNil)
+	c.Assert(srv.Addresses["dual"], gc.DeepEquals, []nova.IPAddress{
+		{Version: 4, Address: "10.2.0.3", Type: "fixed"},
+		{Version: 6, Address: "fd00:2::42", Type: "fixed"},
+	})
+}
+
+func (s *NovaHTTPSuite) TestRunServerNoMoreFixedIPs(c *gc.C) {
+	s.service.networks["2"] = nova.Network{Id: "2", Label: "tiny", Cidr: "10.2.0.0/30"}
+	defer delete(s.service.networks, "2")
+	var expected struct {
+		Server struct {
+			Id string
+		}
+	}
+	resp := s.runServerOnNetworks(c, map[string]string{"uuid": "2"})
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	_, err = s.service.securityGroup(group.Id)
-	c.Assert(err, gc.NotNil)
+	assertJSON(c, resp, &expected)
+	defer s.service.removeServer(expected.Server.Id)
+	resp = s.runServerOnNetworks(c, map[string]string{"uuid": "2"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"No fixed IP addresses available for network: 2", "code":400}}`,
+	})
 }
 
-func (s *NovaHTTPSuite) TestAddSecurityGroupRule(c *gc.C) {
-	group1 := nova.SecurityGroup{Id: "1", Name: "src"}
-	group2 := nova.SecurityGroup{Id: "2", Name: "tgt"}
-	err := s.service.addSecurityGroup(group1)
+func (s *NovaHTTPSuite) TestFloatingIPOnServerNetwork(c *gc.C) {
+	var expected struct {
+		Server struct {
+			Id string
+		}
+	}
+	resp := s.runServerOnNetworks(c, map[string]string{"uuid": "1"})
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, resp, &expected)
+	serverId := expected.Server.Id
+	defer s.service.removeServer(serverId)
+	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
+	err := s.service.addFloatingIP(fip)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group1.Id)
-	err = s.service.addSecurityGroup(group2)
+	defer s.service.removeFloatingIP(fip.Id)
+	err = s.service.addServerFloatingIP(serverId, fip.Id)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group2.Id)
-	riIngress := nova.RuleInfo{
-		ParentGroupId: "1",
-		FromPort:      1234,
-		ToPort:        4321,
-		IPProtocol:    "tcp",
-		Cidr:          "1.2.3.4/5",
-	}
-	riGroup := nova.RuleInfo{
-		ParentGroupId: group2.Id,
-		GroupId:       &group1.Id,
-	}
-	iprange := make(map[string]string)
-	iprange["cidr"] = riIngress.Cidr
-	rule1 := nova.SecurityGroupRule{
-		Id:            "1",
-		ParentGroupId: group1.Id,
-		FromPort:      &riIngress.FromPort,
-		ToPort:        &riIngress.ToPort,
-		IPProtocol:    &riIngress.IPProtocol,
-		IPRange:       iprange,
+
+	var detail struct {
+		Server nova.ServerDetail
 	}
-	rule2 := nova.SecurityGroupRule{
-		Id:            "2",
-		ParentGroupId: group2.Id,
-		Group: nova.SecurityGroupRef{
-			Name:     group1.Name,
-			TenantId: s.service.TenantId,
+	resp, err = s.authRequest("GET", "/servers/"+serverId, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Server.Addresses, gc.DeepEquals, map[string][]nova.IPAddress{
+		"net": {
+			{Version: 4, Address: "10.0.0.2", Type: "fixed"},
+			{Version: 4, Address: "1.2.3.4", Type: "floating"},
 		},
+	})
+	ip, err := s.service.floatingIP(fip.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(*ip.FixedIP, gc.Equals, "10.0.0.2")
+
+	err = s.service.removeServerFloatingIP(serverId, fip.Id)
+	c.Assert(err, gc.IsNil)
+	srv, err := s.service.server(serverId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(srv.Addresses, gc.DeepEquals, map[string][]nova.IPAddress{
+		"net": {{Version: 4, Address: "10.0.0.2", Type: "fixed"}},
+	})
+}
+
+func (s *NovaHTTPSuite) TestServerInterfaces(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", UUID: "uuid1", Status: nova.StatusActive}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+
+	req := map[string]map[string]string{"interfaceAttachment": {"net_id": "1"}}
+	resp, err := s.jsonRequest("POST", "/servers/sr1/os-interface", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var attached struct {
+		InterfaceAttachment InterfaceAttachment `json:"interfaceAttachment"`
 	}
-	ok := s.service.hasSecurityGroupRule(group1.Id, rule1.Id)
-	c.Assert(ok, gc.Equals, false)
-	ok = s.service.hasSecurityGroupRule(group2.Id, rule2.Id)
-	c.Assert(ok, gc.Equals, false)
-	var req struct {
-		Rule nova.RuleInfo `json:"security_group_rule"`
-	}
-	req.Rule = riIngress
-	var expected struct {
-		Rule nova.SecurityGroupRule `json:"security_group_rule"`
+	assertJSON(c, resp, &attached)
+	attachment := attached.InterfaceAttachment
+	c.Assert(attachment.PortId, gc.Not(gc.Equals), "")
+	c.Assert(attachment.NetId, gc.Equals, "1")
+	c.Assert(attachment.PortState, gc.Equals, "ACTIVE")
+	c.Assert(attachment.MACAddr, gc.Matches, "fa:16:3e:..:..:..")
+	c.Assert(attachment.FixedIPs, gc.DeepEquals, []FixedIP{{IPAddress: "10.0.0.2"}})
+
+	var list struct {
+		InterfaceAttachments []InterfaceAttachment `json:"interfaceAttachments"`
 	}
-	resp, err := s.jsonRequest("POST", "/os-security-group-rules", req, nil)
+	resp, err = s.authRequest("GET", "/servers/sr1/os-interface", nil, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Rule.Id, gc.Equals, rule1.Id)
-	c.Assert(expected.Rule.ParentGroupId, gc.Equals, rule1.ParentGroupId)
-	c.Assert(expected.Rule.Group, gc.Equals, nova.SecurityGroupRef{})
-	c.Assert(*expected.Rule.FromPort, gc.Equals, *rule1.FromPort)
-	c.Assert(*expected.Rule.ToPort, gc.Equals, *rule1.ToPort)
-	c.Assert(*expected.Rule.IPProtocol, gc.Equals, *rule1.IPProtocol)
-	c.Assert(expected.Rule.IPRange, gc.DeepEquals, rule1.IPRange)
-	defer s.service.removeSecurityGroupRule(rule1.Id)
-	req.Rule = riGroup
-	resp, err = s.jsonRequest("POST", "/os-security-group-rules", req, nil)
+	assertJSON(c, resp, &list)
+	c.Assert(list.InterfaceAttachments, gc.DeepEquals, []InterfaceAttachment{attachment})
+	resp, err = s.authRequest("GET", "/servers/sr1/os-interface/"+attachment.PortId, nil, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Rule.Id, gc.Equals, rule2.Id)
-	c.Assert(expected.Rule.ParentGroupId, gc.Equals, rule2.ParentGroupId)
-	c.Assert(expected.Rule.Group, gc.DeepEquals, rule2.Group)
-	err = s.service.removeSecurityGroupRule(rule2.Id)
+	assertJSON(c, resp, &attached)
+	c.Assert(attached.InterfaceAttachment, gc.DeepEquals, attachment)
+
+	var detail struct {
+		Server nova.ServerDetail
+	}
+	resp, err = s.authRequest("GET", "/servers/sr1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Server.Addresses, gc.DeepEquals, map[string][]nova.IPAddress{
+		"net": {{Version: 4, Address: "10.0.0.2", Type: "fixed"}},
+	})
+
+	resp, err = s.authRequest("DELETE", "/servers/sr1/os-interface/"+attachment.PortId, nil, nil)
 	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	srv, err := s.service.server(server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(srv.Addresses, gc.HasLen, 0)
+	attachments, err := s.service.serverInterfaces(server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(attachments, gc.HasLen, 0)
+	// The port was created for the interface, so it has gone.
+	_, ok := s.service.ports[attachment.PortId]
+	c.Assert(ok, gc.Equals, false)
 }
 
-func (s *NovaHTTPSuite) TestDeleteSecurityGroupRule(c *gc.C) {
-	group1 := nova.SecurityGroup{Id: "1", Name: "src"}
-	group2 := nova.SecurityGroup{Id: "2", Name: "tgt"}
-	err := s.service.addSecurityGroup(group1)
+func (s *NovaHTTPSuite) TestServerInterfacePort(c *gc.C) {
+	for _, server := range []nova.ServerDetail{
+		{Id: "sr1", Status: nova.StatusActive},
+		{Id: "sr2", Status: nova.StatusActive},
+	} {
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	err := s.service.AddPort(Port{Id: "port1", NetworkId: "1", FixedIP: "10.0.0.10"})
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group1.Id)
-	err = s.service.addSecurityGroup(group2)
+	defer delete(s.service.ports, "port1")
+
+	req := map[string]map[string]string{"interfaceAttachment": {"port_id": "port1"}}
+	resp, err := s.jsonRequest("POST", "/servers/sr1/os-interface", req, nil)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group2.Id)
-	riGroup := nova.RuleInfo{
-		ParentGroupId: group2.Id,
-		GroupId:       &group1.Id,
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var attached struct {
+		InterfaceAttachment InterfaceAttachment `json:"interfaceAttachment"`
 	}
-	rule := nova.SecurityGroupRule{
-		Id:            "1",
-		ParentGroupId: group2.Id,
-		Group: nova.SecurityGroupRef{
-			Name:     group1.Name,
-			TenantId: group1.TenantId,
-		},
+	assertJSON(c, resp, &attached)
+	c.Assert(attached.InterfaceAttachment.PortId, gc.Equals, "port1")
+	c.Assert(attached.InterfaceAttachment.FixedIPs, gc.DeepEquals, []FixedIP{{IPAddress: "10.0.0.10"}})
+
+	resp, err = s.jsonRequest("POST", "/servers/sr2/os-interface", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusConflict)
+	resp.Body.Close()
+
+	err = s.service.detachServerInterface("sr1", "port1")
+	c.Assert(err, gc.IsNil)
+	// Ports which were added remain once detached, and may be reused.
+	c.Assert(s.service.ports["port1"].ServerId, gc.Equals, "")
+	resp, err = s.jsonRequest("POST", "/servers/sr2/os-interface", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	resp.Body.Close()
+	err = s.service.removeServer("sr2")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.service.ports["port1"].ServerId, gc.Equals, "")
+}
+
+func (s *NovaHTTPSuite) TestServerInterfaceErrors(c *gc.C) {
+	for _, server := range []nova.ServerDetail{
+		{Id: "sr1", Status: nova.StatusActive},
+		{Id: "sr2", Status: nova.StatusBuild},
+	} {
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
 	}
-	err = s.service.addSecurityGroupRule(rule.Id, riGroup)
+	for i, t := range []struct {
+		method string
+		url    string
+		body   interface{}
+		code   int
+	}{
+		{"POST", "/servers/sr1/os-interface", map[string]map[string]string{"interfaceAttachment": {"net_id": "99"}}, http.StatusNotFound},
+		{"POST", "/servers/sr1/os-interface", map[string]map[string]string{"interfaceAttachment": {"port_id": "99"}}, http.StatusNotFound},
+		{"POST", "/servers/sr1/os-interface", map[string]map[string]string{"interfaceAttachment": {}}, http.StatusBadRequest},
+		{"POST", "/servers/sr1/os-interface", map[string]interface{}{"interfaceAttachment": map[string]interface{}{
+			"net_id": "1", "fixed_ips": []map[string]string{{"ip_address": "192.168.0.1"}},
+		}}, http.StatusBadRequest},
+		{"POST", "/servers/sr2/os-interface", map[string]map[string]string{"interfaceAttachment": {"net_id": "1"}}, http.StatusConflict},
+		{"POST", "/servers/sr3/os-interface", map[string]map[string]string{"interfaceAttachment": {"net_id": "1"}}, http.StatusNotFound},
+		{"GET", "/servers/sr3/os-interface", nil, http.StatusNotFound},
+		{"GET", "/servers/sr1/os-interface/unknown", nil, http.StatusNotFound},
+		{"DELETE", "/servers/sr1/os-interface/unknown", nil, http.StatusNotFound},
+		{"PUT", "/servers/sr1/os-interface", nil, http.StatusMethodNotAllowed},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.url)
+		resp, err := s.jsonRequest(t.method, t.url, t.body, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(resp.StatusCode, gc.Equals, t.code)
+		resp.Body.Close()
+	}
+	attachments, err := s.service.serverInterfaces("sr1")
 	c.Assert(err, gc.IsNil)
-	resp, err := s.authRequest("DELETE", "/os-security-group-rules/1", nil, nil)
+	c.Assert(attachments, gc.HasLen, 0)
+}
+
+// setUpCinder starts a Cinder double alongside the Nova double and
+// makes it Nova's volume service, returning it with the id of a new
+// available volume.
+func (s *NovaHTTPSuite) setUpCinder(c *gc.C) (*volumeservice.Cinder, string) {
+	cinder := volumeservice.New(s.Server.URL, "v2", s.service.TenantId, region, s.service.IdentityService)
+	cinder.SetupHTTP(s.Mux)
+	s.service.SetVolumeService(cinder)
+	body := []byte(`{"volume": {"size": 1}}`)
+	resp, err := s.sendRequest("POST", s.Server.URL+"/v2/"+s.service.TenantId+"/volumes", body, setHeader(authToken, s.token))
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	ok := s.service.hasSecurityGroupRule(group2.Id, rule.Id)
-	c.Assert(ok, gc.Equals, false)
+	var created struct {
+		Volume struct {
+			Id string `json:"id"`
+		} `json:"volume"`
+	}
+	assertJSON(c, resp, &created)
+	err = cinder.SetVolumeStatus(created.Volume.Id, volumeservice.StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	return cinder, created.Volume.Id
 }
 
-func (s *NovaHTTPSuite) TestAddServerSecurityGroup(c *gc.C) {
-	group := nova.SecurityGroup{Id: "1", Name: "group"}
-	err := s.service.addSecurityGroup(group)
-	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group.Id)
+func (s *NovaHTTPSuite) TestAttachDetachCinderVolume(c *gc.C) {
+	cinder, volumeId := s.setUpCinder(c)
+	defer s.service.SetVolumeService(nil)
 	server := nova.ServerDetail{Id: "sr1"}
-	err = s.service.addServer(server)
+	err := s.service.addServer(server)
 	c.Assert(err, gc.IsNil)
 	defer s.service.removeServer(server.Id)
-	ok := s.service.hasServerSecurityGroup(server.Id, group.Id)
-	c.Assert(ok, gc.Equals, false)
-	var req struct {
-		Group struct {
-			Name string `json:"name"`
-		} `json:"addSecurityGroup"`
+
+	req := nova.VolumeAttachment{VolumeId: volumeId, Device: "/dev/vdb"}
+	resp, err := s.jsonRequest("POST", "/servers/sr1/os-volume_attachments", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var attachment nova.VolumeAttachment
+	assertJSON(c, resp, &attachment)
+	c.Assert(attachment.Id, gc.Not(gc.Equals), "")
+	c.Assert(attachment.ServerId, gc.Equals, "sr1")
+	status, err := cinder.VolumeStatus(volumeId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, volumeservice.StatusInUse)
+
+	var attachments []nova.VolumeAttachment
+	resp, err = s.authRequest("GET", "/servers/sr1/os-volume_attachments", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &attachments)
+	c.Assert(attachments, gc.DeepEquals, []nova.VolumeAttachment{attachment})
+
+	// The volume cannot be attached again while it is in use.
+	resp, err = s.jsonRequest("POST", "/servers/sr1/os-volume_attachments", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusConflict)
+	resp.Body.Close()
+
+	resp, err = s.authRequest("DELETE", "/servers/sr1/os-volume_attachments/"+attachment.Id, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	status, err = cinder.VolumeStatus(volumeId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, volumeservice.StatusAvailable)
+	resp, err = s.authRequest("DELETE", "/servers/sr1/os-volume_attachments/"+attachment.Id, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp.Body.Close()
+
+	// Unknown volumes cannot be attached.
+	req.VolumeId = "unknown"
+	resp, err = s.jsonRequest("POST", "/servers/sr1/os-volume_attachments", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp.Body.Close()
+}
+
+// runServerRequest returns the body of a request to create a server
+// with the given image and block device mappings.
+func runServerRequest(imageRef string, mappings ...map[string]interface{}) map[string]interface{} {
+	server := map[string]interface{}{
+		"name":      "srv",
+		"flavorRef": "1",
+		"imageRef":  imageRef,
 	}
-	req.Group.Name = group.Name
-	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	if len(mappings) > 0 {
+		server["block_device_mapping_v2"] = mappings
+	}
+	return map[string]interface{}{"server": server}
+}
+
+// runServer creates a server with the given request, returning its id.
+func (s *NovaHTTPSuite) runServer(c *gc.C, req interface{}) string {
+	resp, err := s.jsonRequest("POST", "/servers", req, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	ok = s.service.hasServerSecurityGroup(server.Id, group.Id)
-	c.Assert(ok, gc.Equals, true)
-	err = s.service.removeServerSecurityGroup(server.Id, group.Id)
+	var created struct {
+		Server struct {
+			Id string `json:"id"`
+		} `json:"server"`
+	}
+	assertJSON(c, resp, &created)
+	return created.Server.Id
+}
+
+func (s *NovaHTTPSuite) serverVolumeAttachments(c *gc.C, serverId string) []nova.VolumeAttachment {
+	resp, err := s.authRequest("GET", "/servers/"+serverId+"/os-volume_attachments", nil, nil)
 	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var attachments []nova.VolumeAttachment
+	assertJSON(c, resp, &attachments)
+	return attachments
 }
 
-func (s *NovaHTTPSuite) TestGetServerSecurityGroups(c *gc.C) {
-	server := nova.ServerDetail{Id: "sr1"}
-	groups := []nova.SecurityGroup{
-		{
-			Id:       "1",
-			Name:     "group1",
-			TenantId: s.service.TenantId,
-			Rules:    []nova.SecurityGroupRule{},
+func (s *NovaHTTPSuite) TestRunServerBootFromVolume(c *gc.C) {
+	volumes, volumeId := s.setUpCinder(c)
+	defer s.service.SetVolumeService(nil)
+	id := s.runServer(c, runServerRequest("", map[string]interface{}{
+		"boot_index":       0,
+		"uuid":             volumeId,
+		"source_type":      "volume",
+		"destination_type": "volume",
+	}))
+	defer s.service.removeServer(id)
+	server, err := s.service.server(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(server.Image, gc.DeepEquals, nova.Entity{})
+	status, err := volumes.VolumeStatus(volumeId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, volumeservice.StatusInUse)
+	attachments := s.serverVolumeAttachments(c, id)
+	c.Assert(attachments, gc.HasLen, 1)
+	c.Assert(attachments[0].VolumeId, gc.Equals, volumeId)
+	c.Assert(attachments[0].Device, gc.Equals, "/dev/vda")
+
+	// The volume is in use, so another server cannot boot from it.
+	resp, err := s.jsonRequest("POST", "/servers", runServerRequest("", map[string]interface{}{
+		"boot_index":  "0",
+		"uuid":        volumeId,
+		"source_type": "volume",
+	}), nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	resp.Body.Close()
+}
+
+func (s *NovaHTTPSuite) TestRunServerBootFromSnapshot(c *gc.C) {
+	volumes, volumeId := s.setUpCinder(c)
+	defer s.service.SetVolumeService(nil)
+	err := volumes.AddSnapshot(cinder.Snapshot{ID: "snap", Size: 2})
+	c.Assert(err, gc.IsNil)
+	id := s.runServer(c, runServerRequest("",
+		map[string]interface{}{
+			"boot_index":  0,
+			"uuid":        "snap",
+			"source_type": "snapshot",
+			"volume_size": 5,
 		},
-		{
-			Id:       "2",
-			Name:     "group2",
-			TenantId: s.service.TenantId,
-			Rules:    []nova.SecurityGroupRule{},
+		map[string]interface{}{
+			"uuid":        volumeId,
+			"source_type": "volume",
 		},
+	))
+	defer s.service.removeServer(id)
+	attachments := s.serverVolumeAttachments(c, id)
+	c.Assert(attachments, gc.HasLen, 2)
+	c.Assert(attachments[0].Device, gc.Equals, "/dev/vda")
+	c.Assert(attachments[0].VolumeId, gc.Not(gc.Equals), volumeId)
+	c.Assert(attachments[1].Device, gc.Equals, "/dev/vdb")
+	c.Assert(attachments[1].VolumeId, gc.Equals, volumeId)
+	for _, attachment := range attachments {
+		status, err := volumes.VolumeStatus(attachment.VolumeId)
+		c.Assert(err, gc.IsNil)
+		c.Assert(status, gc.Equals, volumeservice.StatusInUse)
 	}
-	srvGroups := s.service.allServerSecurityGroups(server.Id)
-	c.Assert(srvGroups, gc.HasLen, 0)
+}
+
+func (s *NovaHTTPSuite) TestRunServerBootFromImageMapping(c *gc.C) {
+	id := s.runServer(c, runServerRequest("", map[string]interface{}{
+		"boot_index":  0,
+		"uuid":        "1",
+		"source_type": "image",
+	}))
+	defer s.service.removeServer(id)
+	server, err := s.service.server(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(server.Image.Id, gc.Equals, "1")
+}
+
+func (s *NovaHTTPSuite) TestRunServerInvalidBlockDeviceMapping(c *gc.C) {
+	volumes, volumeId := s.setUpCinder(c)
+	defer s.service.SetVolumeService(nil)
+	for i, t := range []struct {
+		imageRef string
+		mappings []map[string]interface{}
+		message  string
+	}{{
+		mappings: []map[string]interface{}{{"uuid": volumeId, "source_type": "disk"}},
+		message:  "Invalid input for field/attribute source_type. Value: disk. 'disk' is not one of ['volume', 'image', 'snapshot', 'blank']",
+	}, {
+		mappings: []map[string]interface{}{{"boot_index": 0, "source_type": "volume"}},
+		message:  "Block Device Mapping is Invalid: Missing device UUID.",
+	}, {
+		mappings: []map[string]interface{}{{"boot_index": 0, "uuid": "missing", "source_type": "volume"}},
+		message:  "Block Device Mapping is Invalid: failed to get volume missing.",
+	}, {
+		mappings: []map[string]interface{}{{"boot_index": 0, "uuid": "missing", "source_type": "snapshot"}},
+		message:  "Block Device Mapping is Invalid: failed to get snapshot missing.",
+	}, {
+		mappings: []map[string]interface{}{{"boot_index": 0, "uuid": "missing", "source_type": "image"}},
+		message:  "Can not find requested image",
+	}, {
+		mappings: []map[string]interface{}{{"boot_index": 0, "uuid": volumeId, "source_type": "volume", "destination_type": "local"}},
+		message:  "Block Device Mapping is Invalid: Mapping volume to local is not supported.",
+	}, {
+		mappings: []map[string]interface{}{{"boot_index": "first", "uuid": volumeId, "source_type": "volume"}},
+		message:  "Invalid input for field/attribute boot_index. Value: first.",
+	}, {
+		// Without a boot device there is nothing to boot from.
+		mappings: []map[string]interface{}{{"uuid": volumeId, "source_type": "volume"}},
+		message:  "Block Device Mapping is Invalid: Boot sequence for the instance and image/block device mapping combination is not valid.",
+	}, {
+		imageRef: "1",
+		mappings: []map[string]interface{}{
+			{"boot_index": 0, "uuid": "1", "source_type": "image"},
+			{"boot_index": 0, "uuid": volumeId, "source_type": "volume"},
+		},
+		message: "Block Device Mapping is Invalid: Boot sequence for the instance and image/block device mapping combination is not valid.",
+	}, {
+		mappings: []map[string]interface{}{
+			{"boot_index": 0, "uuid": volumeId, "source_type": "volume"},
+			{"uuid": volumeId, "source_type": "volume"},
+		},
+		message: "Block Device Mapping is Invalid: Volume " + volumeId + " is mapped more than once.",
+	}} {
+		c.Logf("test %d: %s", i, t.message)
+		resp, err := s.jsonRequest("POST", "/servers", runServerRequest(t.imageRef, t.mappings...), nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+		var body struct {
+			BadRequest struct {
+				Message string `json:"message"`
+			} `json:"badRequest"`
+		}
+		assertJSON(c, resp, &body)
+		c.Assert(body.BadRequest.Message, gc.Equals, t.message)
+	}
+	entities := s.service.allServersAsEntities(nil)
+	c.Assert(entities, gc.HasLen, 0)
+	status, err := volumes.VolumeStatus(volumeId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, volumeservice.StatusAvailable)
+}
+
+func (s *NovaHTTPSuite) TestRunServerAttachFailureRemovesServer(c *gc.C) {
+	volumes, volumeId := s.setUpCinder(c)
+	defer s.service.SetVolumeService(nil)
+	cleanup := s.service.RegisterControlPoint(
+		"attachVolume",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("attach failed")
+		},
+	)
+	defer cleanup()
+	resp, err := s.jsonRequest("POST", "/servers", runServerRequest("", map[string]interface{}{
+		"boot_index":  0,
+		"uuid":        volumeId,
+		"source_type": "volume",
+	}), nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusInternalServerError)
+	c.Assert(s.service.allServersAsEntities(nil), gc.HasLen, 0)
+	status, err := volumes.VolumeStatus(volumeId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, volumeservice.StatusAvailable)
+}
+
+func (s *NovaHTTPSuite) TestDeleteServerDetachesCinderVolume(c *gc.C) {
+	cinder, volumeId := s.setUpCinder(c)
+	defer s.service.SetVolumeService(nil)
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	_, err = s.service.attachVolume(server.Id, nova.VolumeAttachment{VolumeId: volumeId})
+	c.Assert(err, gc.IsNil)
+	err = s.service.removeServer(server.Id)
+	c.Assert(err, gc.IsNil)
+	status, err := cinder.VolumeStatus(volumeId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, volumeservice.StatusAvailable)
+}
+
+func (s *NovaHTTPSuite) TestDeleteServer(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	_, err := s.service.server(server.Id)
+	c.Assert(err, gc.NotNil)
+	err = s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	resp, err := s.authRequest("DELETE", "/servers/sr1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	_, err = s.service.server(server.Id)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *NovaHTTPSuite) TestServerMetadata(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	var result struct {
+		Metadata map[string]string `json:"metadata"`
+	}
+	assertMetadata := func(resp *http.Response, expected map[string]string) {
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		result.Metadata = nil
+		assertJSON(c, resp, &result)
+		c.Assert(result.Metadata, gc.DeepEquals, expected)
+	}
+	resp, err := s.authRequest("GET", "/servers/sr1/metadata", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertMetadata(resp, map[string]string{})
+	body := map[string]map[string]string{"metadata": {"a": "1", "b": "2"}}
+	resp, err = s.jsonRequest("PUT", "/servers/sr1/metadata", body, nil)
+	c.Assert(err, gc.IsNil)
+	assertMetadata(resp, map[string]string{"a": "1", "b": "2"})
+	body = map[string]map[string]string{"metadata": {"b": "3", "c": "4"}}
+	resp, err = s.jsonRequest("POST", "/servers/sr1/metadata", body, nil)
+	c.Assert(err, gc.IsNil)
+	assertMetadata(resp, map[string]string{"a": "1", "b": "3", "c": "4"})
+	body = map[string]map[string]string{"metadata": {"d": "5"}}
+	resp, err = s.jsonRequest("PUT", "/servers/sr1/metadata", body, nil)
+	c.Assert(err, gc.IsNil)
+	assertMetadata(resp, map[string]string{"d": "5"})
+	resp, err = s.authRequest("GET", "/servers/sr1/metadata", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertMetadata(resp, map[string]string{"d": "5"})
+}
+
+// tagsHeader returns a header asking for the microversion which
+// introduced server tags.
+func tagsHeader() http.Header {
+	return setHeader("OpenStack-API-Version", "compute 2.26")
+}
+
+func (s *NovaHTTPSuite) TestServerTags(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	var result struct {
+		Tags []string `json:"tags"`
+	}
+	assertTags := func(resp *http.Response, expected []string) {
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		result.Tags = nil
+		assertJSON(c, resp, &result)
+		c.Assert(result.Tags, gc.DeepEquals, expected)
+	}
+	resp, err := s.authRequest("GET", "/servers/sr1/tags", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertTags(resp, []string{})
+	body := map[string][]string{"tags": {"web", "prod"}}
+	resp, err = s.jsonRequest("PUT", "/servers/sr1/tags", body, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertTags(resp, []string{"web", "prod"})
+
+	resp, err = s.authRequest("PUT", "/servers/sr1/tags/eu", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	c.Assert(resp.Header.Get("Location"), gc.Equals, s.service.endpointURL(true, "/servers/sr1/tags/eu"))
+	resp, err = s.authRequest("PUT", "/servers/sr1/tags/eu", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp, err = s.authRequest("GET", "/servers/sr1/tags/eu", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp, err = s.authRequest("DELETE", "/servers/sr1/tags/prod", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp, err = s.authRequest("GET", "/servers/sr1/tags/prod", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusNotFound,
+		body: `{"itemNotFound":{"message":"Instance sr1 has no tag 'prod'", "code":404}}`,
+	})
+	resp, err = s.authRequest("GET", "/servers/sr1/tags", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertTags(resp, []string{"web", "eu"})
+
+	// Tags named after other sub-resources are still tags.
+	for _, tag := range []string{"metadata", "os-interface", "diagnostics"} {
+		resp, err = s.authRequest("PUT", "/servers/sr1/tags/"+tag, nil, tagsHeader())
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	}
+	resp, err = s.authRequest("GET", "/servers/sr1/tags", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertTags(resp, []string{"web", "eu", "metadata", "os-interface", "diagnostics"})
+
+	resp, err = s.authRequest("DELETE", "/servers/sr1/tags", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp, err = s.authRequest("GET", "/servers/sr1/tags", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertTags(resp, []string{})
+}
+
+func (s *NovaHTTPSuite) TestServerTagsInvalid(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	resp, err := s.authRequest("PUT", "/servers/sr1/tags/a,b", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusBadRequest,
+		body: `{"badRequest":{"message":"Invalid tag \"a,b\": tags must be 1 to 60 characters long and must not contain '/' or ','", "code":400}}`,
+	})
+	body := map[string][]string{"tags": {strings.Repeat("x", 61)}}
+	resp, err = s.jsonRequest("PUT", "/servers/sr1/tags", body, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	resp, err = s.authRequest("PUT", "/servers/sr1/tags", []byte(`{}`), tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	resp, err = s.authRequest("GET", "/servers/sr2/tags", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+}
+
+func (s *NovaHTTPSuite) TestGetServersWithTagFilters(c *gc.C) {
+	for _, id := range []string{"sr1", "sr2", "sr3"} {
+		server := nova.ServerDetail{Id: id, Name: "server " + id}
+		s.service.buildServerLinks(&server)
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	_, err := s.service.setServerTags("sr1", []string{"web", "prod"})
+	c.Assert(err, gc.IsNil)
+	_, err = s.service.setServerTags("sr2", []string{"web"})
+	c.Assert(err, gc.IsNil)
+	var expected struct {
+		Servers []nova.Entity `json:"servers"`
+	}
+	resp, err := s.authRequest("GET", "/servers?tags=web&not-tags=prod", nil, tagsHeader())
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 1)
+	c.Assert(expected.Servers[0].Id, gc.Equals, "sr2")
+}
+
+func (s *NovaHTTPSuite) TestMicroversionNegotiation(c *gc.C) {
+	for i, t := range []struct {
+		header  http.Header
+		version string
+	}{
+		{nil, "2.1"},
+		{setHeader("OpenStack-API-Version", "compute 2.26"), "2.26"},
+		{setHeader("OpenStack-API-Version", "volume 3.0, compute 2.15"), "2.15"},
+		{setHeader("OpenStack-API-Version", "compute latest"), "2.60"},
+		{setHeader("X-OpenStack-Nova-API-Version", "2.3"), "2.3"},
+		{http.Header{
+			"Openstack-Api-Version":        {"compute 2.10"},
+			"X-Openstack-Nova-Api-Version": {"2.3"},
+		}, "2.10"},
+	} {
+		c.Logf("test %d: %v", i, t.header)
+		resp, err := s.authRequest("GET", "/flavors", nil, t.header)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		c.Check(resp.Header.Get("OpenStack-API-Version"), gc.Equals, "compute "+t.version)
+		c.Check(resp.Header.Get("X-OpenStack-Nova-API-Version"), gc.Equals, t.version)
+	}
+}
+
+func (s *NovaHTTPSuite) TestMicroversionInvalid(c *gc.C) {
+	resp, err := s.authRequest("GET", "/flavors", nil, setHeader("OpenStack-API-Version", "compute two"))
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusBadRequest,
+		body: `{"badRequest":{"message":"API Version String two is of invalid format. Must be of format MajorNum.MinorNum.", "code":400}}`,
+	})
+	for _, version := range []string{"2.0", "2.61", "3.0"} {
+		resp, err := s.authRequest("GET", "/flavors", nil, setHeader("OpenStack-API-Version", "compute "+version))
+		c.Assert(err, gc.IsNil)
+		assertBody(c, resp, &errorResponse{
+			code: http.StatusNotAcceptable,
+			body: `{"computeFault":{"message":"Version ` + version + ` is not supported by the API. Minimum is 2.1 and maximum is 2.60.", "code":406}}`,
+		})
+	}
+}
+
+func (s *NovaHTTPSuite) TestServerTagsMicroversion(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Name: "srv1"}
+	s.service.buildServerLinks(&server)
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	_, err = s.service.setServerTags("sr1", []string{"web"})
+	c.Assert(err, gc.IsNil)
+	resp, err := s.authRequest("GET", "/servers/sr1/tags", nil, setHeader("OpenStack-API-Version", "compute 2.25"))
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, errNotFound)
+	// Below the microversion, the tag filters are ignored.
+	var expected struct {
+		Servers []nova.Entity `json:"servers"`
+	}
+	resp, err = s.authRequest("GET", "/servers?not-tags=web", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 1)
+}
+
+func (s *NovaHTTPSuite) TestSoftAffinityMicroversion(c *gc.C) {
+	body := map[string]interface{}{"server_group": map[string]interface{}{
+		"name":     "group",
+		"policies": []string{"soft-affinity"},
+	}}
+	resp, err := s.jsonRequest("POST", "/os-server-groups", body, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	resp, err = s.jsonRequest("POST", "/os-server-groups", body, setHeader("OpenStack-API-Version", "compute 2.15"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var result struct {
+		ServerGroup ServerGroup `json:"server_group"`
+	}
+	assertJSON(c, resp, &result)
+	defer s.service.removeServerGroup(result.ServerGroup.Id)
+	c.Assert(result.ServerGroup.Policies, gc.DeepEquals, []string{"soft-affinity"})
+}
+
+func (s *NovaHTTPSuite) TestServerMetadataItem(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	var result struct {
+		Meta map[string]string `json:"meta"`
+	}
+	body := map[string]map[string]string{"meta": {"a": "1"}}
+	resp, err := s.jsonRequest("PUT", "/servers/sr1/metadata/a", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &result)
+	c.Assert(result.Meta, gc.DeepEquals, map[string]string{"a": "1"})
+	resp, err = s.authRequest("GET", "/servers/sr1/metadata/a", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	result.Meta = nil
+	assertJSON(c, resp, &result)
+	c.Assert(result.Meta, gc.DeepEquals, map[string]string{"a": "1"})
+	// The key in the body must match the URL.
+	body = map[string]map[string]string{"meta": {"b": "2"}}
+	resp, err = s.jsonRequest("PUT", "/servers/sr1/metadata/a", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	resp, err = s.authRequest("DELETE", "/servers/sr1/metadata/a", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp, err = s.authRequest("GET", "/servers/sr1/metadata/a", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp, err = s.authRequest("DELETE", "/servers/sr1/metadata/a", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+}
+
+func (s *NovaHTTPSuite) TestServerMetadataErrors(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	s.service.SetMetadataQuota(1)
+	defer s.service.SetMetadataQuota(DefaultMetadataQuota)
+	long := strings.Repeat("x", 256)
+	for i, t := range []struct {
+		method string
+		url    string
+		body   interface{}
+		code   int
+	}{
+		{"GET", "/servers/sr2/metadata", nil, http.StatusNotFound},
+		{"POST", "/servers/sr2/metadata", map[string]map[string]string{"metadata": {"a": "1"}}, http.StatusNotFound},
+		{"PUT", "/servers/sr1/metadata", map[string]interface{}{}, http.StatusBadRequest},
+		{"PUT", "/servers/sr1/metadata", map[string]map[string]string{"metadata": {long: "1"}}, http.StatusBadRequest},
+		{"POST", "/servers/sr1/metadata", map[string]map[string]string{"metadata": {"a": long}}, http.StatusBadRequest},
+		{"PUT", "/servers/sr1/metadata/" + long, map[string]map[string]string{"meta": {long: "1"}}, http.StatusBadRequest},
+		{"POST", "/servers/sr1/metadata", map[string]map[string]string{"metadata": {"a": "1", "b": "2"}}, http.StatusForbidden},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.url)
+		resp, err := s.jsonRequest(t.method, t.url, t.body, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(resp.StatusCode, gc.Equals, t.code)
+		resp.Body.Close()
+	}
+	metadata, err := s.service.serverMetadataItems(server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(metadata, gc.DeepEquals, map[string]string{})
+}
+
+func (s *NovaHTTPSuite) TestRunServerWithMetadata(c *gc.C) {
+	var req struct {
+		Server struct {
+			FlavorRef string            `json:"flavorRef"`
+			ImageRef  string            `json:"imageRef"`
+			Name      string            `json:"name"`
+			Metadata  map[string]string `json:"metadata"`
+		} `json:"server"`
+	}
+	req.Server.Name = "srv1"
+	req.Server.FlavorRef = "1"
+	req.Server.ImageRef = "1"
+	req.Server.Metadata = map[string]string{"a": strings.Repeat("x", 256)}
+	resp, err := s.jsonRequest("POST", "/servers", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	c.Assert(s.service.allServers(nil), gc.HasLen, 0)
+	req.Server.Metadata = map[string]string{"a": "1"}
+	var expected struct {
+		Server struct {
+			Id string
+		}
+	}
+	resp, err = s.jsonRequest("POST", "/servers", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, resp, &expected)
+	defer s.service.removeServer(expected.Server.Id)
+	metadata, err := s.service.serverMetadataItems(expected.Server.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(metadata, gc.DeepEquals, map[string]string{"a": "1"})
+}
+
+func (s *NovaHTTPSuite) TestGetServersDetail(c *gc.C) {
+	servers := s.service.allServers(nil)
+	c.Assert(servers, gc.HasLen, 0)
+	var expected struct {
+		Servers []nova.ServerDetail `json:"servers"`
+	}
+	resp, err := s.authRequest("GET", "/servers/detail", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 0)
+	servers = []nova.ServerDetail{
+		{Id: "sr1", Name: "server 1"},
+		{Id: "sr2", Name: "server 2"},
+	}
+	for i, server := range servers {
+		s.service.buildServerLinks(&server)
+		servers[i] = server
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	resp, err = s.authRequest("GET", "/servers/detail", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 2)
+	if expected.Servers[0].Id != servers[0].Id {
+		expected.Servers[0], expected.Servers[1] = expected.Servers[1], expected.Servers[0]
+	}
+	c.Assert(expected.Servers, gc.DeepEquals, servers)
+	resp, err = s.authRequest("GET", "/servers/detail/sr1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, errNotFound)
+}
+
+func (s *NovaHTTPSuite) TestGetServersDetailWithFilters(c *gc.C) {
+	servers := s.service.allServers(nil)
+	c.Assert(servers, gc.HasLen, 0)
+	var expected struct {
+		Servers []nova.ServerDetail `json:"servers"`
+	}
+	url := "/servers/detail?status=RESCUE&status=BUILD&name=srv2&name=srv1"
+	resp, err := s.authRequest("GET", url, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 0)
+	servers = []nova.ServerDetail{
+		{Id: "sr1", Name: "srv1", Status: nova.StatusBuild},
+		{Id: "sr2", Name: "srv2", Status: nova.StatusRescue},
+		{Id: "sr3", Name: "srv3", Status: nova.StatusActive},
+	}
+	for i, server := range servers {
+		s.service.buildServerLinks(&server)
+		servers[i] = server
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	resp, err = s.authRequest("GET", url, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 1)
+	c.Assert(expected.Servers[0], gc.DeepEquals, servers[0])
+}
+
+func (s *NovaHTTPSuite) TestGetServersWithFlavorAndNameFilters(c *gc.C) {
+	servers := []nova.ServerDetail{
+		{Id: "sr1", Name: "web-1", Status: nova.StatusActive, Flavor: nova.Entity{Id: "fl1"}},
+		{Id: "sr2", Name: "web-2", Status: nova.StatusActive, Flavor: nova.Entity{Id: "fl2"}},
+		{Id: "sr3", Name: "db-1", Status: nova.StatusActive, Flavor: nova.Entity{Id: "fl1"}},
+	}
+	for i, server := range servers {
+		s.service.buildServerLinks(&server)
+		servers[i] = server
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	var expected struct {
+		Servers []nova.ServerDetail `json:"servers"`
+	}
+	resp, err := s.authRequest("GET", "/servers/detail?status=ACTIVE&flavor=fl1&name=^web-", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 1)
+	c.Assert(expected.Servers[0], gc.DeepEquals, servers[0])
+}
+
+func (s *NovaHTTPSuite) TestGetServersWithInvalidNameFilter(c *gc.C) {
+	for _, path := range []string{"/servers", "/servers/detail"} {
+		resp, err := s.authRequest("GET", path+"?name=web(", nil, nil)
+		c.Assert(err, gc.IsNil)
+		assertBody(c, resp, &errorResponse{
+			code: http.StatusBadRequest,
+			body: `{"badRequest":{"message":"Invalid filter name: web( is not a valid regular expression", "code":400}}`,
+		})
+	}
+}
+
+func (s *NovaHTTPSuite) TestGetServersAllTenants(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("all-tenants-user", "secret", "all-tenants-other")
+	admin := identityDouble.AddUser("all-tenants-admin", "secret", "all-tenants-admin")
+	err := identityDouble.SetUserTenant("all-tenants-admin", admin.TenantId, "all-tenants-admin", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	servers := []nova.ServerDetail{
+		{Id: "sr1", Name: "srv1"},
+		{Id: "sr2", Name: "srv2", TenantId: other.TenantId},
+		{Id: "sr3", Name: "srv3", TenantId: admin.TenantId},
+	}
+	for _, server := range servers {
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	for i, t := range []struct {
+		token string
+		query string
+		ids   []string
+	}{
+		{s.token, "", []string{"sr1"}},
+		{other.Token, "", []string{"sr2"}},
+		// Only admins may list the servers of other tenants.
+		{other.Token, "?all_tenants=1", []string{"sr2"}},
+		{admin.Token, "", []string{"sr3"}},
+		{admin.Token, "?all_tenants=1", []string{"sr1", "sr2", "sr3"}},
+		{admin.Token, "?all_tenants", []string{"sr1", "sr2", "sr3"}},
+		{admin.Token, "?all_tenants=0", []string{"sr3"}},
+		{admin.Token, "?all_tenants=1&tenant_id=" + other.TenantId, []string{"sr2"}},
+	} {
+		c.Logf("test %d: %s", i, t.query)
+		for _, path := range []string{"/servers", "/servers/detail"} {
+			url := s.service.endpointURL(true, path) + t.query
+			resp, err := s.sendRequest("GET", url, nil, setHeader(authToken, t.token))
+			c.Assert(err, gc.IsNil)
+			c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+			var listed struct {
+				Servers []nova.Entity `json:"servers"`
+			}
+			assertJSON(c, resp, &listed)
+			var ids []string
+			for _, server := range listed.Servers {
+				ids = append(ids, server.Id)
+			}
+			c.Check(ids, gc.DeepEquals, t.ids)
+		}
+	}
+	resp, err := s.sendRequest("GET", s.service.endpointURL(true, "/servers")+"?all_tenants=maybe", nil, setHeader(authToken, admin.Token))
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusBadRequest,
+		body: `{"badRequest":{"message":"Invalid value 'maybe' for all_tenants", "code":400}}`,
+	})
+}
+
+func (s *NovaHTTPSuite) TestResponseHeaders(c *gc.C) {
+	resp, err := s.authRequest("GET", "/flavors", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Compute-Request-Id"), gc.Matches, "req-[0-9a-f-]{36}")
+	resp, err = s.authRequest("GET", "/flavors", nil, setHeader(testservices.RequestIdHeader, "req-traced"))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Compute-Request-Id"), gc.Equals, "req-traced")
+
+	defer s.service.ClearResponseHeaders()
+	s.service.SetResponseHeader("X-Compute-Request-Id", "req-fixed")
+	resp, err = s.authRequest("GET", "/servers/missing", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	c.Assert(resp.Header.Get("X-Compute-Request-Id"), gc.Equals, "req-fixed")
+
+	s.service.SetResponseHeader("X-Compute-Request-Id", "")
+	resp, err = s.authRequest("GET", "/flavors", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	_, ok := resp.Header["X-Compute-Request-Id"]
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *NovaHTTPSuite) TestServerTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	admin := identityDouble.AddUser("isolated-admin", "secret", "isolated-admin")
+	err := identityDouble.SetUserTenant("isolated-admin", admin.TenantId, "isolated-admin", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	err = s.service.addServer(nova.ServerDetail{Id: "sr1", Name: "srv1", Status: nova.StatusActive})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer("sr1")
+	for i, t := range []struct {
+		method string
+		path   string
+	}{
+		{"GET", "/servers/sr1"},
+		{"DELETE", "/servers/sr1"},
+		{"GET", "/servers/sr1/os-volume_attachments"},
+		{"GET", "/servers/sr1/os-security-groups"},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.path)
+		resp, err := s.sendRequest(t.method, s.service.endpointURL(true, t.path), nil, setHeader(authToken, other.Token))
+		c.Assert(err, gc.IsNil)
+		assertBody(c, resp, &errorResponse{
+			code: http.StatusNotFound,
+			body: `{"itemNotFound":{"message":"No such server \"sr1\"", "code":404}}`,
+		})
+	}
+	url := s.service.endpointURL(true, "/servers/sr1")
+	resp, err := s.sendRequest("GET", url, nil, setHeader(authToken, admin.Token))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp, err = s.sendRequest("GET", url+"?all_tenants=1", nil, setHeader(authToken, admin.Token))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	_, err = s.service.server("sr1")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestServerIsolationByTokenScope(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	err := identityDouble.AddUserTenant("fred", "scoped-tenant-id", "scoped-tenant", nil)
+	c.Assert(err, gc.IsNil)
+	err = s.service.addServer(nova.ServerDetail{Id: "sr1", Name: "srv1", TenantId: "scoped-tenant-id"})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer("sr1")
+	// The server belongs to one of fred's tenants, but is only seen
+	// through a token scoped to it.
+	url := s.service.endpointURL(true, "/servers/sr1")
+	resp, err := s.sendRequest("GET", url, nil, setHeader(authToken, s.token))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+
+	identityDouble.SetupHTTP(s.Mux)
+	body := `{"auth": {"tenantName": "scoped-tenant", "passwordCredentials": {"username": "fred", "password": "secret"}}}`
+	resp, err = s.sendRequest("POST", s.Server.URL+"/tokens", []byte(body), setHeader("Content-Type", "application/json"))
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var access identityservice.AccessResponse
+	assertJSON(c, resp, &access)
+	scopedToken := access.Access.Token.Id
+	c.Assert(scopedToken, gc.Not(gc.Equals), s.token)
+	resp, err = s.sendRequest("GET", url, nil, setHeader(authToken, scopedToken))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+}
+
+func (s *NovaHTTPSuite) TestSecurityGroupTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	var req struct {
+		Group struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"security_group"`
+	}
+	req.Group.Name = "private"
+	req.Group.Description = "other tenant's group"
+	body, err := json.Marshal(req)
+	c.Assert(err, gc.IsNil)
+	groupsURL := s.service.endpointURL(true, "/os-security-groups")
+	resp, err := s.sendRequest("POST", groupsURL, body, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var created struct {
+		Group nova.SecurityGroup `json:"security_group"`
+	}
+	assertJSON(c, resp, &created)
+	defer s.service.removeSecurityGroup(created.Group.Id)
+	c.Assert(created.Group.TenantId, gc.Equals, other.TenantId)
+
+	// The group is neither listed nor found for other tenants.
+	var groups struct {
+		Groups []nova.SecurityGroup `json:"security_groups"`
+	}
+	resp, err = s.authRequest("GET", "/os-security-groups", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &groups)
+	for _, group := range groups.Groups {
+		c.Check(group.Id, gc.Not(gc.Equals), created.Group.Id)
+	}
+	for _, method := range []string{"GET", "DELETE"} {
+		resp, err = s.authRequest(method, "/os-security-groups/"+created.Group.Id, nil, nil)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	}
+	resp, err = s.sendRequest("GET", groupsURL, nil, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &groups)
+	c.Assert(groups.Groups, gc.HasLen, 1)
+	c.Assert(groups.Groups[0].Id, gc.Equals, created.Group.Id)
+}
+
+func (s *NovaHTTPSuite) TestFloatingIPTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	// Other tests expect the floating IPs they allocate to be numbered
+	// from the start.
+	defer func(next int) { s.service.nextIPId = next }(s.service.nextIPId)
+	ipsURL := s.service.endpointURL(true, "/os-floating-ips")
+	resp, err := s.sendRequest("POST", ipsURL, nil, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var created struct {
+		IP struct {
+			Id string `json:"id"`
+		} `json:"floating_ip"`
+	}
+	assertJSON(c, resp, &created)
+	c.Assert(created.IP.Id, gc.Not(gc.Equals), "")
+	defer s.service.removeFloatingIP(created.IP.Id)
+
+	// The floating IP is neither listed nor found for other tenants.
+	var ips struct {
+		IPs []struct {
+			Id string `json:"id"`
+		} `json:"floating_ips"`
+	}
+	resp, err = s.authRequest("GET", "/os-floating-ips", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &ips)
+	c.Assert(ips.IPs, gc.HasLen, 0)
+	for _, method := range []string{"GET", "DELETE"} {
+		resp, err = s.authRequest(method, "/os-floating-ips/"+created.IP.Id, nil, nil)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	}
+	resp, err = s.sendRequest("GET", ipsURL, nil, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &ips)
+	c.Assert(ips.IPs, gc.HasLen, 1)
+}
+
+func (s *NovaHTTPSuite) TestFloatingIPActionsTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	defer func(next int) { s.service.nextIPId = next }(s.service.nextIPId)
+	fip, err := s.service.allocateFloatingIP("")
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFloatingIP(fip.Id)
+	s.service.floatingIPTenants[fip.Id] = other.TenantId
+	err = s.service.addServer(nova.ServerDetail{Id: "sr1", Name: "srv1"})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer("sr1")
+
+	// Another tenant's floating IP can be neither added nor removed.
+	for _, action := range []string{"addFloatingIp", "removeFloatingIp"} {
+		body := map[string]interface{}{action: map[string]string{"address": fip.IP}}
+		resp, err := s.jsonRequest("POST", "/servers/sr1/action", body, nil)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	}
+	c.Assert(s.service.hasServerFloatingIP("sr1", fip.IP), gc.Equals, false)
+}
+
+func (s *NovaHTTPSuite) TestAllocateFloatingIPChargesCaller(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	defer func(next int) { s.service.nextIPId = next }(s.service.nextIPId)
+	s.service.SetQuotas(other.TenantId, Quotas{Instances: -1, Cores: -1, RAM: -1, FloatingIPs: 1})
+	defer delete(s.service.quotas, other.TenantId)
+	ipsURL := s.service.endpointURL(true, "/os-floating-ips")
+	resp, err := s.sendRequest("POST", ipsURL, nil, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var created struct {
+		IP struct {
+			Id string `json:"id"`
+		} `json:"floating_ip"`
+	}
+	assertJSON(c, resp, &created)
+	defer s.service.removeFloatingIP(created.IP.Id)
+	c.Assert(s.service.tenantUsage(other.TenantId).FloatingIPs, gc.Equals, 1)
+	c.Assert(s.service.tenantUsage(s.service.TenantId).FloatingIPs, gc.Equals, 0)
+
+	// The other tenant's quota is used up, but not the service's.
+	resp, err = s.sendRequest("POST", ipsURL, nil, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusForbidden)
+	s.service.SetQuotas(s.service.TenantId, Quotas{Instances: -1, Cores: -1, RAM: -1, FloatingIPs: 1})
+	defer delete(s.service.quotas, s.service.TenantId)
+	resp, err = s.authRequest("POST", "/os-floating-ips", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &created)
+	defer s.service.removeFloatingIP(created.IP.Id)
+}
+
+func (s *NovaHTTPSuite) TestSecurityGroupRulesTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	group := nova.SecurityGroup{Id: "99", Name: "private", TenantId: other.TenantId}
+	err := s.service.addSecurityGroup(group)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group.Id)
+	err = s.service.addSecurityGroupRule("10", nova.RuleInfo{
+		ParentGroupId: group.Id,
+		IPProtocol:    "tcp",
+		FromPort:      22,
+		ToPort:        22,
+		Cidr:          "0.0.0.0/0",
+	})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroupRule("10")
+
+	body := map[string]interface{}{"security_group_rule": nova.RuleInfo{
+		ParentGroupId: group.Id,
+		IPProtocol:    "tcp",
+		FromPort:      80,
+		ToPort:        80,
+		Cidr:          "0.0.0.0/0",
+	}}
+	resp, err := s.jsonRequest("POST", "/os-security-group-rules", body, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp, err = s.authRequest("DELETE", "/os-security-group-rules/10", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	found, err := s.service.securityGroup(group.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Rules, gc.HasLen, 1)
+
+	// The owning tenant may delete the rule.
+	url := s.service.endpointURL(true, "/os-security-group-rules/10")
+	resp, err = s.sendRequest("DELETE", url, nil, setHeader(authToken, other.Token))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+}
+
+func (s *NovaHTTPSuite) TestSecurityGroupNamesPerTenant(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	err := s.service.addSecurityGroup(nova.SecurityGroup{Id: "99", Name: "private", TenantId: other.TenantId})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup("99")
+
+	// Booting with another tenant's group fails, naming the caller's tenant.
+	body := map[string]interface{}{"server": map[string]interface{}{
+		"name":            "srv",
+		"flavorRef":       "1",
+		"imageRef":        "1",
+		"security_groups": []map[string]string{{"name": "private"}},
+	}}
+	resp, err := s.jsonRequest("POST", "/servers", body, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusBadRequest,
+		body: `{"badRequest": {"message": "Security group private not found for project ` + s.service.TenantId + `.", "code": 400}}`,
+	})
+
+	// The same name may be used by another tenant.
+	body = map[string]interface{}{"security_group": map[string]string{
+		"name":        "private",
+		"description": "my group",
+	}}
+	resp, err = s.jsonRequest("POST", "/os-security-groups", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var created struct {
+		Group nova.SecurityGroup `json:"security_group"`
+	}
+	assertJSON(c, resp, &created)
+	defer s.service.removeSecurityGroup(created.Group.Id)
+	c.Assert(created.Group.TenantId, gc.Equals, s.service.TenantId)
+	resp, err = s.jsonRequest("POST", "/os-security-groups", body, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+}
+
+func (s *NovaHTTPSuite) TestGetServersPaginated(c *gc.C) {
+	for _, id := range []string{"sr3", "sr1", "sr5", "sr2", "sr4"} {
+		server := nova.ServerDetail{Id: id, Name: "server " + id}
+		s.service.buildServerLinks(&server)
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	type page struct {
+		Servers []nova.Entity `json:"servers"`
+		Links   []nova.Link   `json:"servers_links"`
+	}
+	var ids []string
+	var pages int
+	url := s.service.endpointURL(true, "/servers?limit=2")
+	for url != "" {
+		resp, err := s.sendRequest("GET", url, nil, setHeader(authToken, s.token))
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		var expected page
+		assertJSON(c, resp, &expected)
+		c.Assert(len(expected.Servers) <= 2, gc.Equals, true)
+		for _, server := range expected.Servers {
+			ids = append(ids, server.Id)
+		}
+		url = ""
+		if len(expected.Links) > 0 {
+			c.Assert(expected.Links, gc.HasLen, 1)
+			c.Assert(expected.Links[0].Rel, gc.Equals, "next")
+			url = expected.Links[0].Href
+		}
+		pages++
+	}
+	c.Assert(pages, gc.Equals, 3)
+	c.Assert(ids, gc.DeepEquals, []string{"sr1", "sr2", "sr3", "sr4", "sr5"})
+}
+
+func (s *NovaHTTPSuite) TestGetServersDetailPaginated(c *gc.C) {
+	for _, id := range []string{"sr1", "sr2", "sr3"} {
+		server := nova.ServerDetail{Id: id, Name: "server " + id, Status: nova.StatusActive}
+		s.service.buildServerLinks(&server)
+		err := s.service.addServer(server)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(server.Id)
+	}
+	var expected struct {
+		Servers []nova.ServerDetail `json:"servers"`
+		Links   []nova.Link         `json:"servers_links"`
+	}
+	resp, err := s.authRequest("GET", "/servers/detail?status=ACTIVE&marker=sr1&limit=1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 1)
+	c.Assert(expected.Servers[0].Id, gc.Equals, "sr2")
+	c.Assert(expected.Links, gc.HasLen, 1)
+	next := expected.Links[0].Href
+	c.Assert(strings.HasPrefix(next, s.service.endpointURL(true, "/servers/detail?")), gc.Equals, true)
+	c.Assert(next, gc.Matches, ".*marker=sr2.*")
+	c.Assert(next, gc.Matches, ".*limit=1.*")
+	c.Assert(next, gc.Matches, ".*status=ACTIVE.*")
+	// The last page has no next link.
+	expected.Links = nil
+	resp, err = s.authRequest("GET", "/servers/detail?marker=sr2&limit=1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Servers, gc.HasLen, 1)
+	c.Assert(expected.Servers[0].Id, gc.Equals, "sr3")
+	c.Assert(expected.Links, gc.HasLen, 0)
+}
+
+func (s *NovaHTTPSuite) TestGetFlavorsPaginated(c *gc.C) {
+	// The test service has 3 default flavours.
+	flavors := s.service.allFlavors()
+	c.Assert(flavors, gc.HasLen, 3)
+	var expected struct {
+		Flavors []nova.Entity `json:"flavors"`
+		Links   []nova.Link   `json:"flavors_links"`
+	}
+	resp, err := s.authRequest("GET", "/flavors?limit=2", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Flavors, gc.HasLen, 2)
+	c.Assert(expected.Flavors[0].Id, gc.Equals, flavors[0].Id)
+	c.Assert(expected.Flavors[1].Id, gc.Equals, flavors[1].Id)
+	c.Assert(expected.Links, gc.HasLen, 1)
+	c.Assert(expected.Links[0].Href, gc.Equals, s.service.endpointURL(true, "/flavors?limit=2&marker="+flavors[1].Id))
+	var detail struct {
+		Flavors []nova.FlavorDetail `json:"flavors"`
+		Links   []nova.Link         `json:"flavors_links"`
+	}
+	resp, err = s.authRequest("GET", "/flavors/detail?marker="+flavors[1].Id, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &detail)
+	c.Assert(detail.Flavors, gc.DeepEquals, flavors[2:])
+	c.Assert(detail.Links, gc.HasLen, 0)
+}
+
+func (s *NovaHTTPSuite) TestBadPaginationParams(c *gc.C) {
+	for i, t := range []struct {
+		url     string
+		message string
+	}{{
+		url:     "/servers?limit=foo",
+		message: `limit param must be a non-negative integer, got "foo"`,
+	}, {
+		url:     "/servers/detail?limit=-1",
+		message: `limit param must be a non-negative integer, got "-1"`,
+	}, {
+		url:     "/flavors?marker=no-such-flavor",
+		message: "marker [no-such-flavor] not found",
+	}, {
+		url:     "/flavors/detail?marker=no-such-flavor",
+		message: "marker [no-such-flavor] not found",
+	}} {
+		c.Logf("test %d: %s", i, t.url)
+		resp, err := s.authRequest("GET", t.url, nil, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+		var expected struct {
+			BadRequest struct {
+				Message string
+				Code    int
+			}
+		}
+		assertJSON(c, resp, &expected)
+		c.Assert(expected.BadRequest.Message, gc.Equals, t.message)
+		c.Assert(expected.BadRequest.Code, gc.Equals, http.StatusBadRequest)
+	}
+}
+
+func (s *NovaHTTPSuite) TestGetSecurityGroups(c *gc.C) {
+	// There is always a default security group.
+	groups := s.service.allSecurityGroups()
+	c.Assert(groups, gc.HasLen, 1)
+	var expected struct {
+		Groups []nova.SecurityGroup `json:"security_groups"`
+	}
+	resp, err := s.authRequest("GET", "/os-security-groups", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Groups, gc.HasLen, 1)
+	groups = []nova.SecurityGroup{
+		{
+			Id:       "1",
+			Name:     "group 1",
+			TenantId: s.service.TenantId,
+			Rules:    []nova.SecurityGroupRule{},
+		},
+		{
+			Id:       "2",
+			Name:     "group 2",
+			TenantId: s.service.TenantId,
+			Rules:    []nova.SecurityGroupRule{},
+		},
+	}
+	for _, group := range groups {
+		err := s.service.addSecurityGroup(group)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeSecurityGroup(group.Id)
+	}
+	resp, err = s.authRequest("GET", "/os-security-groups", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Groups, gc.HasLen, len(groups)+1)
+	checkGroupsInList(c, groups, expected.Groups)
+	var expectedGroup struct {
+		Group nova.SecurityGroup `json:"security_group"`
+	}
+	resp, err = s.authRequest("GET", "/os-security-groups/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expectedGroup)
+	c.Assert(expectedGroup.Group, gc.DeepEquals, groups[0])
+}
+
+func (s *NovaHTTPSuite) TestAddSecurityGroup(c *gc.C) {
+	group := nova.SecurityGroup{
+		Id:          "1",
+		Name:        "group 1",
+		Description: "desc",
+		TenantId:    s.service.TenantId,
+		Rules:       []nova.SecurityGroupRule{},
+	}
+	_, err := s.service.securityGroup(group.Id)
+	c.Assert(err, gc.NotNil)
+	var req struct {
+		Group struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"security_group"`
+	}
+	req.Group.Name = group.Name
+	req.Group.Description = group.Description
+	var expected struct {
+		Group nova.SecurityGroup `json:"security_group"`
+	}
+	resp, err := s.jsonRequest("POST", "/os-security-groups", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Group, gc.DeepEquals, group)
+	err = s.service.removeSecurityGroup(group.Id)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestAddSecurityGroupIDFactory(c *gc.C) {
+	s.service.IDFactory = func(resourceType string) string {
+		return "my-" + resourceType
+	}
+	defer func() { s.service.IDFactory = nil }()
+	var req struct {
+		Group struct {
+			Name        string `json:"name"`
+			Description string `json:"description"`
+		} `json:"security_group"`
+	}
+	req.Group.Name = "group 1"
+	var expected struct {
+		Group nova.SecurityGroup `json:"security_group"`
+	}
+	resp, err := s.jsonRequest("POST", "/os-security-groups", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Group.Id, gc.Equals, "my-security-group")
+	_, err = s.service.securityGroup("my-security-group")
+	c.Assert(err, gc.IsNil)
+	err = s.service.removeSecurityGroup("my-security-group")
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestDeleteSecurityGroup(c *gc.C) {
+	group := nova.SecurityGroup{Id: "1", Name: "group 1"}
+	_, err := s.service.securityGroup(group.Id)
+	c.Assert(err, gc.NotNil)
+	err = s.service.addSecurityGroup(group)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group.Id)
+	resp, err := s.authRequest("DELETE", "/os-security-groups/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	_, err = s.service.securityGroup(group.Id)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *NovaHTTPSuite) TestDeleteSecurityGroupInUse(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	group := nova.SecurityGroup{Id: "1", Name: "group 1"}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	err = s.service.addSecurityGroup(group)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group.Id)
+	err = s.service.addServerSecurityGroup(server.Id, group.Id)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServerSecurityGroup(server.Id, group.Id)
+	resp, err := s.authRequest("DELETE", "/os-security-groups/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusConflict)
+	_, err = s.service.securityGroup(group.Id)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestAddSecurityGroupRule(c *gc.C) {
+	group1 := nova.SecurityGroup{Id: "1", Name: "src"}
+	group2 := nova.SecurityGroup{Id: "2", Name: "tgt"}
+	err := s.service.addSecurityGroup(group1)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group1.Id)
+	err = s.service.addSecurityGroup(group2)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group2.Id)
+	riIngress := nova.RuleInfo{
+		ParentGroupId: "1",
+		FromPort:      1234,
+		ToPort:        4321,
+		IPProtocol:    "tcp",
+		Cidr:          "1.2.3.4/5",
+	}
+	riGroup := nova.RuleInfo{
+		ParentGroupId: group2.Id,
+		GroupId:       &group1.Id,
+	}
+	iprange := make(map[string]string)
+	iprange["cidr"] = riIngress.Cidr
+	rule1 := nova.SecurityGroupRule{
+		Id:            "1",
+		ParentGroupId: group1.Id,
+		FromPort:      &riIngress.FromPort,
+		ToPort:        &riIngress.ToPort,
+		IPProtocol:    &riIngress.IPProtocol,
+		IPRange:       iprange,
+	}
+	rule2 := nova.SecurityGroupRule{
+		Id:            "2",
+		ParentGroupId: group2.Id,
+		Group: nova.SecurityGroupRef{
+			Name:     group1.Name,
+			TenantId: s.service.TenantId,
+		},
+	}
+	ok := s.service.hasSecurityGroupRule(group1.Id, rule1.Id)
+	c.Assert(ok, gc.Equals, false)
+	ok = s.service.hasSecurityGroupRule(group2.Id, rule2.Id)
+	c.Assert(ok, gc.Equals, false)
+	var req struct {
+		Rule nova.RuleInfo `json:"security_group_rule"`
+	}
+	req.Rule = riIngress
+	var expected struct {
+		Rule nova.SecurityGroupRule `json:"security_group_rule"`
+	}
+	resp, err := s.jsonRequest("POST", "/os-security-group-rules", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Rule.Id, gc.Equals, rule1.Id)
+	c.Assert(expected.Rule.ParentGroupId, gc.Equals, rule1.ParentGroupId)
+	c.Assert(expected.Rule.Group, gc.Equals, nova.SecurityGroupRef{})
+	c.Assert(*expected.Rule.FromPort, gc.Equals, *rule1.FromPort)
+	c.Assert(*expected.Rule.ToPort, gc.Equals, *rule1.ToPort)
+	c.Assert(*expected.Rule.IPProtocol, gc.Equals, *rule1.IPProtocol)
+	c.Assert(expected.Rule.IPRange, gc.DeepEquals, rule1.IPRange)
+	defer s.service.removeSecurityGroupRule(rule1.Id)
+	req.Rule = riGroup
+	resp, err = s.jsonRequest("POST", "/os-security-group-rules", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Rule.Id, gc.Equals, rule2.Id)
+	c.Assert(expected.Rule.ParentGroupId, gc.Equals, rule2.ParentGroupId)
+	c.Assert(expected.Rule.Group, gc.DeepEquals, rule2.Group)
+	err = s.service.removeSecurityGroupRule(rule2.Id)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestAddSecurityGroupRuleInvalidPortRange(c *gc.C) {
+	group := nova.SecurityGroup{Id: "1", Name: "group 1"}
+	err := s.service.addSecurityGroup(group)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group.Id)
+	var req struct {
+		Rule nova.RuleInfo `json:"security_group_rule"`
+	}
+	req.Rule = nova.RuleInfo{
+		ParentGroupId: group.Id,
+		FromPort:      8080,
+		ToPort:        80,
+		IPProtocol:    "tcp",
+		Cidr:          "0.0.0.0/0",
+	}
+	resp, err := s.jsonRequest("POST", "/os-security-group-rules", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	gr, err := s.service.securityGroup(group.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(gr.Rules, gc.HasLen, 0)
+}
+
+func (s *NovaHTTPSuite) TestDeleteSecurityGroupRule(c *gc.C) {
+	group1 := nova.SecurityGroup{Id: "1", Name: "src"}
+	group2 := nova.SecurityGroup{Id: "2", Name: "tgt"}
+	err := s.service.addSecurityGroup(group1)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group1.Id)
+	err = s.service.addSecurityGroup(group2)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group2.Id)
+	riGroup := nova.RuleInfo{
+		ParentGroupId: group2.Id,
+		GroupId:       &group1.Id,
+	}
+	rule := nova.SecurityGroupRule{
+		Id:            "1",
+		ParentGroupId: group2.Id,
+		Group: nova.SecurityGroupRef{
+			Name:     group1.Name,
+			TenantId: group1.TenantId,
+		},
+	}
+	err = s.service.addSecurityGroupRule(rule.Id, riGroup)
+	c.Assert(err, gc.IsNil)
+	resp, err := s.authRequest("DELETE", "/os-security-group-rules/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	ok := s.service.hasSecurityGroupRule(group2.Id, rule.Id)
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *NovaHTTPSuite) TestAddServerSecurityGroup(c *gc.C) {
+	group := nova.SecurityGroup{Id: "1", Name: "group"}
+	err := s.service.addSecurityGroup(group)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group.Id)
+	server := nova.ServerDetail{Id: "sr1"}
+	err = s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	ok := s.service.hasServerSecurityGroup(server.Id, group.Id)
+	c.Assert(ok, gc.Equals, false)
+	var req struct {
+		Group struct {
+			Name string `json:"name"`
+		} `json:"addSecurityGroup"`
+	}
+	req.Group.Name = group.Name
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	ok = s.service.hasServerSecurityGroup(server.Id, group.Id)
+	c.Assert(ok, gc.Equals, true)
+	err = s.service.removeServerSecurityGroup(server.Id, group.Id)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestGetServerSecurityGroups(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1"}
+	groups := []nova.SecurityGroup{
+		{
+			Id:       "1",
+			Name:     "group1",
+			TenantId: s.service.TenantId,
+			Rules:    []nova.SecurityGroupRule{},
+		},
+		{
+			Id:       "2",
+			Name:     "group2",
+			TenantId: s.service.TenantId,
+			Rules:    []nova.SecurityGroupRule{},
+		},
+	}
+	srvGroups := s.service.allServerSecurityGroups(server.Id)
+	c.Assert(srvGroups, gc.HasLen, 0)
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	for _, group := range groups {
+		err = s.service.addSecurityGroup(group)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeSecurityGroup(group.Id)
+		err = s.service.addServerSecurityGroup(server.Id, group.Id)
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServerSecurityGroup(server.Id, group.Id)
+	}
+	srvGroups = s.service.allServerSecurityGroups(server.Id)
+	var expected struct {
+		Groups []nova.SecurityGroup `json:"security_groups"`
+	}
+	resp, err := s.authRequest("GET", "/servers/"+server.Id+"/os-security-groups", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Groups, gc.DeepEquals, groups)
+}
+
+func (s *NovaHTTPSuite) TestDeleteServerSecurityGroup(c *gc.C) {
+	group := nova.SecurityGroup{Id: "1", Name: "group"}
+	err := s.service.addSecurityGroup(group)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeSecurityGroup(group.Id)
+	server := nova.ServerDetail{Id: "sr1"}
+	err = s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	ok := s.service.hasServerSecurityGroup(server.Id, group.Id)
+	c.Assert(ok, gc.Equals, false)
+	err = s.service.addServerSecurityGroup(server.Id, group.Id)
+	c.Assert(err, gc.IsNil)
+	var req struct {
+		Group struct {
+			Name string `json:"name"`
+		} `json:"removeSecurityGroup"`
+	}
+	req.Group.Name = group.Name
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	ok = s.service.hasServerSecurityGroup(server.Id, group.Id)
+	c.Assert(ok, gc.Equals, false)
+}
+
+func (s *NovaHTTPSuite) TestPostFloatingIP(c *gc.C) {
+	fip := nova.FloatingIP{Id: "1", IP: "10.0.0.1", Pool: "nova"}
+	c.Assert(s.service.allFloatingIPs(), gc.HasLen, 0)
+	var expected struct {
+		IP nova.FloatingIP `json:"floating_ip"`
+	}
+	resp, err := s.authRequest("POST", "/os-floating-ips", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.IP, gc.DeepEquals, fip)
+	err = s.service.removeFloatingIP(fip.Id)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestPostFloatingIPFromPool(c *gc.C) {
+	s.service.SetFloatingIPPool("public", "203.0.113.1")
+	defer s.service.SetFloatingIPPool("public")
+	var expected struct {
+		IP nova.FloatingIP `json:"floating_ip"`
+	}
+	req := map[string]string{"pool": "public"}
+	resp, err := s.jsonRequest("POST", "/os-floating-ips", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.IP.IP, gc.Equals, "203.0.113.1")
+	c.Assert(expected.IP.Pool, gc.Equals, "public")
+	defer s.service.removeFloatingIP(expected.IP.Id)
+	// The pool is now exhausted.
+	resp, err = s.jsonRequest("POST", "/os-floating-ips", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	assertBody(c, resp, errNoMoreFloatingIPs)
+	resp, err = s.jsonRequest("POST", "/os-floating-ips", map[string]string{"pool": "no-such-pool"}, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp.Body.Close()
+}
+
+func (s *NovaHTTPSuite) TestGetFloatingIPs(c *gc.C) {
+	c.Assert(s.service.allFloatingIPs(), gc.HasLen, 0)
+	var expected struct {
+		IPs []nova.FloatingIP `json:"floating_ips"`
+	}
+	resp, err := s.authRequest("GET", "/os-floating-ips", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.IPs, gc.HasLen, 0)
+	fips := []nova.FloatingIP{
+		{Id: "1", IP: "1.2.3.4", Pool: "nova"},
+		{Id: "2", IP: "4.3.2.1", Pool: "nova"},
+	}
+	for _, fip := range fips {
+		err := s.service.addFloatingIP(fip)
+		defer s.service.removeFloatingIP(fip.Id)
+		c.Assert(err, gc.IsNil)
+	}
+	resp, err = s.authRequest("GET", "/os-floating-ips", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	if expected.IPs[0].Id != fips[0].Id {
+		expected.IPs[0], expected.IPs[1] = expected.IPs[1], expected.IPs[0]
+	}
+	c.Assert(expected.IPs, gc.DeepEquals, fips)
+	var expectedIP struct {
+		IP nova.FloatingIP `json:"floating_ip"`
+	}
+	resp, err = s.authRequest("GET", "/os-floating-ips/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expectedIP)
+	c.Assert(expectedIP.IP, gc.DeepEquals, fips[0])
+}
+
+func (s *NovaHTTPSuite) TestDeleteFloatingIP(c *gc.C) {
+	fip := nova.FloatingIP{Id: "1", IP: "10.0.0.1", Pool: "nova"}
+	err := s.service.addFloatingIP(fip)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFloatingIP(fip.Id)
+	resp, err := s.authRequest("DELETE", "/os-floating-ips/1", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	_, err = s.service.floatingIP(fip.Id)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *NovaHTTPSuite) TestAddServerFloatingIP(c *gc.C) {
+	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addFloatingIP(fip)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFloatingIP(fip.Id)
+	err = s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, false)
+	var req struct {
+		AddFloatingIP struct {
+			Address string `json:"address"`
+		} `json:"addFloatingIp"`
+	}
+	req.AddFloatingIP.Address = fip.IP
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, true)
+	err = s.service.removeServerFloatingIP(server.Id, fip.Id)
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestAddFloatingIPToUnknownServer(c *gc.C) {
+	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
+	err := s.service.addFloatingIP(fip)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFloatingIP(fip.Id)
+	var req struct {
+		AddFloatingIP struct {
+			Address string `json:"address"`
+		} `json:"addFloatingIp"`
+	}
+	req.AddFloatingIP.Address = fip.IP
+	resp, err := s.jsonRequest("POST", "/servers/no-such-server/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp.Body.Close()
+	ip, err := s.service.floatingIP(fip.Id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(ip.InstanceId, gc.IsNil)
+}
+
+func (s *NovaHTTPSuite) TestServerShowsFloatingIP(c *gc.C) {
+	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addFloatingIP(fip)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFloatingIP(fip.Id)
+	err = s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	var req struct {
+		AddFloatingIP struct {
+			Address string `json:"address"`
+		} `json:"addFloatingIp"`
+	}
+	req.AddFloatingIP.Address = fip.IP
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	var expected struct {
+		Server nova.ServerDetail
+	}
+	resp, err = s.authRequest("GET", "/servers/"+server.Id, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Server.Addresses["private"], gc.DeepEquals, []nova.IPAddress{{Version: 4, Address: "1.2.3.4", Type: "floating"}})
+}
+
+func (s *NovaHTTPSuite) TestRemoveServerFloatingIP(c *gc.C) {
+	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
+	server := nova.ServerDetail{Id: "sr1"}
+	err := s.service.addFloatingIP(fip)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFloatingIP(fip.Id)
+	err = s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	err = s.service.addServerFloatingIP(server.Id, fip.Id)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServerFloatingIP(server.Id, fip.Id)
+	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, true)
+	var req struct {
+		RemoveFloatingIP struct {
+			Address string `json:"address"`
+		} `json:"removeFloatingIp"`
+	}
+	req.RemoveFloatingIP.Address = fip.IP
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, false)
+}
+
+func (s *NovaHTTPSuite) TestKeyPairs(c *gc.C) {
+	var result struct {
+		KeyPair KeyPair `json:"keypair"`
+	}
+	body := map[string]map[string]string{"keypair": {"name": "generated"}}
+	resp, err := s.jsonRequest("POST", "/os-keypairs", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &result)
+	defer s.service.removeKeyPair(s.service.TenantId, "generated")
+	publicKey, privateKey := generateKeyPair(s.service.TenantId, "generated")
+	c.Assert(result.KeyPair.PublicKey, gc.Equals, publicKey)
+	c.Assert(result.KeyPair.PrivateKey, gc.Equals, privateKey)
+	c.Assert(result.KeyPair.UserId, gc.Not(gc.Equals), "")
+
+	body = map[string]map[string]string{"keypair": {"name": "imported", "public_key": testPublicKey}}
+	resp, err = s.jsonRequest("POST", "/os-keypairs", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	result.KeyPair = KeyPair{}
+	assertJSON(c, resp, &result)
+	c.Assert(result.KeyPair.PublicKey, gc.Equals, testPublicKey)
+	c.Assert(result.KeyPair.PrivateKey, gc.Equals, "")
+	c.Assert(result.KeyPair.Fingerprint, gc.Equals, "0f:a2:0a:d7:38:3e:65:45:08:6b:63:84:1c:ff:dc:ba")
+
+	resp, err = s.authRequest("GET", "/os-keypairs/imported", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	result.KeyPair = KeyPair{}
+	assertJSON(c, resp, &result)
+	c.Assert(result.KeyPair.Name, gc.Equals, "imported")
+	c.Assert(result.KeyPair.PublicKey, gc.Equals, testPublicKey)
+
+	var list struct {
+		KeyPairs []struct {
+			KeyPair KeyPair `json:"keypair"`
+		} `json:"keypairs"`
+	}
+	resp, err = s.authRequest("GET", "/os-keypairs", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &list)
+	c.Assert(list.KeyPairs, gc.HasLen, 2)
+	c.Assert(list.KeyPairs[0].KeyPair.Name, gc.Equals, "generated")
+	c.Assert(list.KeyPairs[0].KeyPair.PrivateKey, gc.Equals, "")
+	c.Assert(list.KeyPairs[1].KeyPair.Name, gc.Equals, "imported")
+
+	resp, err = s.authRequest("DELETE", "/os-keypairs/imported", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	resp, err = s.authRequest("GET", "/os-keypairs/imported", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp, err = s.authRequest("DELETE", "/os-keypairs/imported", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+}
+
+func (s *NovaHTTPSuite) TestKeyPairErrors(c *gc.C) {
+	for i, t := range []struct {
+		body interface{}
+		code int
+	}{
+		{map[string]interface{}{}, http.StatusBadRequest},
+		{map[string]map[string]string{"keypair": {"name": "kp", "public_key": "ssh-rsa not-a-key"}}, http.StatusBadRequest},
+		{map[string]map[string]string{"keypair": {"name": ""}}, http.StatusBadRequest},
+	} {
+		c.Logf("test %d", i)
+		resp, err := s.jsonRequest("POST", "/os-keypairs", t.body, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(resp.StatusCode, gc.Equals, t.code)
+		resp.Body.Close()
+	}
+	c.Assert(s.service.allKeyPairs(s.service.TenantId), gc.HasLen, 0)
+	body := map[string]map[string]string{"keypair": {"name": "kp"}}
+	resp, err := s.jsonRequest("POST", "/os-keypairs", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	defer s.service.removeKeyPair(s.service.TenantId, "kp")
+	resp, err = s.jsonRequest("POST", "/os-keypairs", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusConflict)
+}
+
+func (s *NovaHTTPSuite) TestKeyPairsPerTenant(c *gc.C) {
+	body := map[string]map[string]string{"keypair": {"name": "kp"}}
+	resp, err := s.jsonRequest("POST", "/os-keypairs", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	defer s.service.removeKeyPair(s.service.TenantId, "kp")
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("jim", "secret", "other")
+	defer func(token string) {
+		s.token = token
+	}(s.token)
+	s.token = other.Token
+	resp, err = s.authRequest("GET", "/os-keypairs/kp", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp, err = s.jsonRequest("POST", "/os-keypairs", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	defer s.service.removeKeyPair(other.TenantId, "kp")
+	c.Assert(s.service.allKeyPairs(other.TenantId), gc.HasLen, 1)
+	c.Assert(s.service.allKeyPairs(s.service.TenantId), gc.HasLen, 1)
+}
+
+func (s *NovaHTTPSuite) TestRunServerWithKeyPair(c *gc.C) {
+	body := map[string]interface{}{
+		"server": map[string]string{
+			"name":      "srv",
+			"flavorRef": "1",
+			"imageRef":  "1",
+			"key_name":  "kp",
+		},
+	}
+	resp, err := s.jsonRequest("POST", "/servers", body, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"Invalid key_name provided.", "code":400}}`,
+	})
+	c.Assert(s.service.allServers(nil), gc.HasLen, 0)
+
+	_, err = s.service.addKeyPair(s.service.TenantId, KeyPair{Name: "kp", PublicKey: testPublicKey})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeKeyPair(s.service.TenantId, "kp")
+	resp, err = s.jsonRequest("POST", "/servers", body, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	var created struct {
+		Server struct {
+			Id string `json:"id"`
+		} `json:"server"`
+	}
+	assertJSON(c, resp, &created)
+
+	resp, err = s.authRequest("DELETE", "/os-keypairs/kp", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: 409,
+		body: `{"conflictingRequest":{"message":"Key pair 'kp' is in use by server ` + created.Server.Id + `", "code":409}}`,
+	})
+
+	err = s.service.removeServer(created.Server.Id)
+	c.Assert(err, gc.IsNil)
+	resp, err = s.authRequest("DELETE", "/os-keypairs/kp", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+}
+
+func (s *NovaHTTPSuite) TestGetQuotaSet(c *gc.C) {
+	s.service.SetQuotas("other", Quotas{Instances: 1, Cores: 2, RAM: 3, FloatingIPs: 4})
+	defer delete(s.service.quotas, "other")
+	var result struct {
+		QuotaSet map[string]interface{} `json:"quota_set"`
+	}
+	resp, err := s.authRequest("GET", "/os-quota-sets/other", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &result)
+	c.Assert(result.QuotaSet, gc.DeepEquals, map[string]interface{}{
+		"id":             "other",
+		"instances":      1.0,
+		"cores":          2.0,
+		"ram":            3.0,
+		"floating_ips":   4.0,
+		"metadata_items": float64(DefaultMetadataQuota),
+	})
+	resp, err = s.authRequest("GET", "/os-quota-sets/"+s.service.TenantId, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	result.QuotaSet = nil
+	assertJSON(c, resp, &result)
+	c.Assert(result.QuotaSet["instances"], gc.Equals, -1.0)
+}
+
+func (s *NovaHTTPSuite) TestGetLimits(c *gc.C) {
+	s.service.SetQuotas(s.service.TenantId, Quotas{Instances: 5, Cores: 10, RAM: 8192, FloatingIPs: 2})
+	defer delete(s.service.quotas, s.service.TenantId)
+	server := nova.ServerDetail{Id: "sr1", TenantId: s.service.TenantId, Flavor: nova.Entity{Id: "2"}}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	usage := s.service.tenantUsage(s.service.TenantId)
+	var result struct {
+		Limits struct {
+			Rate     []interface{}  `json:"rate"`
+			Absolute map[string]int `json:"absolute"`
+		} `json:"limits"`
+	}
+	resp, err := s.authRequest("GET", "/limits", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &result)
+	c.Assert(result.Limits.Rate, gc.HasLen, 0)
+	c.Assert(result.Limits.Absolute, gc.DeepEquals, map[string]int{
+		"maxTotalInstances":    5,
+		"maxTotalCores":        10,
+		"maxTotalRAMSize":      8192,
+		"maxTotalFloatingIps":  2,
+		"maxServerMeta":        DefaultMetadataQuota,
+		"totalInstancesUsed":   usage.Instances,
+		"totalCoresUsed":       usage.Cores,
+		"totalRAMUsed":         usage.RAM,
+		"totalFloatingIpsUsed": usage.FloatingIPs,
+	})
+	c.Assert(usage.Instances > 0, gc.Equals, true)
+	c.Assert(usage.RAM >= 2048, gc.Equals, true)
+}
+
+func (s *NovaHTTPSuite) TestRunServerQuotaExceeded(c *gc.C) {
+	usage := s.service.tenantUsage(s.service.TenantId)
+	s.service.SetQuotas(s.service.TenantId, Quotas{Instances: usage.Instances, Cores: -1, RAM: -1, FloatingIPs: -1})
+	defer delete(s.service.quotas, s.service.TenantId)
+	var req struct {
+		Server struct {
+			FlavorRef string `json:"flavorRef"`
+			ImageRef  string `json:"imageRef"`
+			Name      string `json:"name"`
+		} `json:"server"`
+	}
+	req.Server.Name = "srv1"
+	req.Server.FlavorRef = "1"
+	req.Server.ImageRef = "1"
+	resp, err := s.jsonRequest("POST", "/servers", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusForbidden)
+	var fault struct {
+		OverLimit struct {
+			Message string
+			Code    int
+		} `json:"overLimit"`
+	}
+	assertJSON(c, resp, &fault)
+	c.Assert(fault.OverLimit.Code, gc.Equals, http.StatusForbidden)
+	c.Assert(fault.OverLimit.Message, gc.Matches, "Quota exceeded for instances: Requested 1, but already used .*")
+	c.Assert(s.service.tenantUsage(s.service.TenantId), gc.DeepEquals, usage)
+}
+
+func (s *NovaHTTPSuite) TestAllocateFloatingIPQuotaExceeded(c *gc.C) {
+	usage := s.service.tenantUsage(s.service.TenantId)
+	s.service.SetQuotas(s.service.TenantId, Quotas{Instances: -1, Cores: -1, RAM: -1, FloatingIPs: usage.FloatingIPs})
+	defer delete(s.service.quotas, s.service.TenantId)
+	resp, err := s.authRequest("POST", "/os-floating-ips", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusForbidden)
+	c.Assert(s.service.allFloatingIPs(), gc.HasLen, usage.FloatingIPs)
+}
+
+func (s *NovaHTTPSuite) TestOptions(c *gc.C) {
+	// No token is needed for OPTIONS requests.
+	url := s.service.endpointURL(true, "/servers")
+	resp, err := s.sendRequest("OPTIONS", url, nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, POST, PUT, DELETE, OPTIONS")
+	c.Assert(resp.Header.Get("Access-Control-Allow-Origin"), gc.Equals, "")
+
+	s.service.SetCORSPolicy(&testservices.CORSPolicy{AllowedOrigins: []string{"*"}})
+	defer s.service.SetCORSPolicy(nil)
+	headers := http.Header{
+		"Origin":                        {"http://app.example.com"},
+		"Access-Control-Request-Method": {"GET"},
+	}
+	resp, err = s.sendRequest("OPTIONS", url, nil, headers)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Access-Control-Allow-Origin"), gc.Equals, "http://app.example.com")
+	c.Assert(resp.Header.Get("Access-Control-Allow-Methods"), gc.Equals, "GET, POST, PUT, DELETE")
+
+	// Other requests are still authenticated.
+	resp, err = s.sendRequest("GET", url, nil, headers)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+}
+
+func (s *NovaHTTPSuite) TestOptionsPerRoute(c *gc.C) {
+	for i, t := range []struct {
+		path  string
+		allow string
+	}{
+		{"/os-hypervisors", "GET, OPTIONS"},
+		{"/os-hypervisors/detail", "GET, OPTIONS"},
+		{"/os-keypairs", "GET, POST, DELETE, OPTIONS"},
+		{"/os-server-groups/1", "GET, POST, DELETE, OPTIONS"},
+		{"/limits", "GET, OPTIONS"},
+	} {
+		c.Logf("test %d: %s", i, t.path)
+		resp, err := s.sendRequest("OPTIONS", s.service.endpointURL(true, t.path), nil, nil)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		c.Assert(resp.Header.Get("Allow"), gc.Equals, t.allow)
+	}
+}
+
+func (s *NovaHTTPSuite) TestListAvailabilityZones(c *gc.C) {
+	resp, err := s.jsonRequest("GET", "/os-availability-zone", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, errNotFoundJSON)
+
+	zones := []nova.AvailabilityZone{
+		{Name: "az1"},
+		{
+			Name: "az2", State: nova.AvailabilityZoneState{Available: true},
+		},
+	}
+	s.service.SetAvailabilityZones(zones...)
+	resp, err = s.jsonRequest("GET", "/os-availability-zone", nil, nil)
+	c.Assert(err, gc.IsNil)
+	var expected struct {
+		Zones []nova.AvailabilityZone `json:"availabilityZoneInfo"`
+	}
+	assertJSON(c, resp, &expected)
+	c.Assert(expected.Zones, gc.DeepEquals, zones)
+}
+
+func (s *NovaHTTPSSuite) SetUpSuite(c *gc.C) {
+	s.HTTPSuite.SetUpSuite(c)
+	identityDouble := identityservice.NewUserPass()
+	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
+	s.token = userInfo.Token
+	c.Assert(s.Server.URL[:8], gc.Equals, "https://")
+	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
+}
+
+func (s *NovaHTTPSSuite) TearDownSuite(c *gc.C) {
+	s.HTTPSuite.TearDownSuite(c)
+}
+
+func (s *NovaHTTPSSuite) SetUpTest(c *gc.C) {
+	s.HTTPSuite.SetUpTest(c)
+	s.service.SetupHTTP(s.Mux)
+}
+
+func (s *NovaHTTPSSuite) TearDownTest(c *gc.C) {
+	s.HTTPSuite.TearDownTest(c)
+}
+
+func (s *NovaHTTPSSuite) TestHasHTTPSServiceURL(c *gc.C) {
+	endpoints := s.service.Endpoints()
+	c.Assert(endpoints[0].PublicURL[:8], gc.Equals, "https://")
+}
+
+func (s *NovaHTTPSuite) TestServerLifecycleActions(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusActive, Flavor: nova.Entity{Id: "1"}, Image: nova.Entity{Id: "1"}}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	assertStatus := func(status string) *nova.ServerDetail {
+		sr, err := s.service.server(server.Id)
+		c.Assert(err, gc.IsNil)
+		c.Assert(sr.Status, gc.Equals, status)
+		return sr
+	}
+	doAction := func(action interface{}, code int) *http.Response {
+		resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", action, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, code)
+		return resp
+	}
+	reboot := map[string]interface{}{"reboot": map[string]string{"type": "HARD"}}
+	doAction(reboot, http.StatusAccepted).Body.Close()
+	assertStatus(nova.StatusHardReboot)
+	doAction(reboot, http.StatusConflict).Body.Close()
+	s.service.SetServerStatus(server.Id, nova.StatusActive)
+
+	rebuild := map[string]interface{}{"rebuild": map[string]string{"imageRef": "no-such-image"}}
+	doAction(rebuild, http.StatusBadRequest).Body.Close()
+	rebuild = map[string]interface{}{"rebuild": map[string]string{"imageRef": "1"}}
+	var rebuilt struct {
+		Server nova.ServerDetail `json:"server"`
+	}
+	assertJSON(c, doAction(rebuild, http.StatusAccepted), &rebuilt)
+	c.Assert(rebuilt.Server.Status, gc.Equals, nova.StatusRebuild)
+	assertStatus(nova.StatusRebuild)
+	s.service.SetServerStatus(server.Id, nova.StatusActive)
+
+	resize := map[string]interface{}{"resize": map[string]string{"flavorRef": "no-such-flavor"}}
+	doAction(resize, http.StatusBadRequest).Body.Close()
+	resize = map[string]interface{}{"resize": map[string]string{"flavorRef": "2"}}
+	doAction(resize, http.StatusAccepted).Body.Close()
+	c.Assert(assertStatus(nova.StatusResize).Flavor.Id, gc.Equals, "2")
+	s.service.SetServerStatus(server.Id, nova.StatusVerifyResize)
+	doAction(map[string]interface{}{"revertResize": nil}, http.StatusAccepted).Body.Close()
+	c.Assert(assertStatus(nova.StatusActive).Flavor.Id, gc.Equals, "1")
+	doAction(resize, http.StatusAccepted).Body.Close()
+	s.service.SetServerStatus(server.Id, nova.StatusVerifyResize)
+	doAction(map[string]interface{}{"confirmResize": nil}, http.StatusNoContent).Body.Close()
+	c.Assert(assertStatus(nova.StatusActive).Flavor.Id, gc.Equals, "2")
+}
+
+func (s *NovaHTTPSuite) TestCreateServerImage(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusActive}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	req := map[string]interface{}{"createImage": map[string]string{"name": "snapshot"}}
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	var result struct {
+		ImageId string `json:"image_id"`
+	}
+	assertJSON(c, resp, &result)
+	c.Assert(resp.Header.Get("Location"), gc.Matches, ".*/images/"+result.ImageId)
+	image, err := s.service.image(result.ImageId)
+	c.Assert(err, gc.IsNil)
+	c.Assert(image.Name, gc.Equals, "snapshot")
+	req = map[string]interface{}{"createImage": map[string]string{}}
+	resp, err = s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	resp.Body.Close()
+}
+
+func (s *NovaHTTPSuite) TestUnknownServerAction(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusActive}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	req := map[string]interface{}{"levitate": nil}
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"There is no such action: levitate", "code":400}}`,
+	})
+}
+
+func (s *NovaHTTPSuite) TestGetConsoleOutput(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusActive}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	err = s.service.SetServerConsoleOutput(server.Id, "Booting\ncloud-init: done\nlogin: ")
+	c.Assert(err, gc.IsNil)
+	for i, t := range []struct {
+		length interface{}
+		code   int
+		output string
+	}{
+		{nil, http.StatusOK, "Booting\ncloud-init: done\nlogin: "},
+		{2, http.StatusOK, "cloud-init: done\nlogin: "},
+		{"1", http.StatusOK, "login: "},
+		{-1, http.StatusOK, "Booting\ncloud-init: done\nlogin: "},
+		{"lots", http.StatusBadRequest, ""},
+		{-2, http.StatusBadRequest, ""},
+	} {
+		c.Logf("test %d: length %v", i, t.length)
+		req := map[string]interface{}{"os-getConsoleOutput": map[string]interface{}{"length": t.length}}
+		resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(resp.StatusCode, gc.Equals, t.code)
+		if t.code != http.StatusOK {
+			resp.Body.Close()
+			continue
+		}
+		var result struct {
+			Output string `json:"output"`
+		}
+		assertJSON(c, resp, &result)
+		c.Check(result.Output, gc.Equals, t.output)
+	}
+}
+
+func diagnosticsHeader() http.Header {
+	return setHeader("OpenStack-API-Version", "compute 2.48")
+}
+
+func (s *NovaHTTPSuite) TestGetServerDiagnostics(c *gc.C) {
+	server := nova.ServerDetail{
+		Id:     "sr1",
+		Status: nova.StatusActive,
+		Flavor: nova.Entity{Id: "3"},
+	}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+
+	resp, err := s.authRequest("GET", "/servers/sr1/diagnostics", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var legacy map[string]int
+	assertJSON(c, resp, &legacy)
+	c.Assert(legacy["memory"], gc.Equals, 4096*1024)
+	for _, key := range []string{"cpu0_time", "cpu1_time", "vda_read", "vda_errors", "vnet0_rx", "vnet0_tx_packets"} {
+		_, ok := legacy[key]
+		c.Check(ok, gc.Equals, true, gc.Commentf("missing %s", key))
+	}
+	_, ok := legacy["cpu2_time"]
+	c.Assert(ok, gc.Equals, false)
+
+	resp, err = s.authRequest("GET", "/servers/sr1/diagnostics", nil, diagnosticsHeader())
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var structured ServerDiagnostics
+	assertJSON(c, resp, &structured)
+	c.Assert(structured.State, gc.Equals, "running")
+	c.Assert(structured.NumCPUs, gc.Equals, 2)
+	c.Assert(structured.NumDisks, gc.Equals, 1)
+	c.Assert(structured.NumNICs, gc.Equals, 1)
+	c.Assert(structured.NICDetails[0].MACAddress, gc.Equals, defaultMACAddress)
+	c.Assert(structured.MemoryDetails.Maximum, gc.Equals, 4096)
+}
+
+func (s *NovaHTTPSuite) TestGetServerDiagnosticsBadPath(c *gc.C) {
+	err := s.service.addServer(nova.ServerDetail{Id: "sr1", Status: nova.StatusActive, Flavor: nova.Entity{Id: "3"}})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer("sr1")
+	for _, path := range []string{"/servers/sr1/foo/diagnostics", "/servers/sr1/diagnostics/foo"} {
+		c.Logf("path %s", path)
+		resp, err := s.authRequest("GET", path, nil, nil)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	}
+}
+
+func (s *NovaHTTPSuite) TestSetServerDiagnostics(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusActive}
+	err := s.service.addServer(server)
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer(server.Id)
+	err = s.service.SetServerDiagnostics(server.Id, ServerDiagnostics{
+		State:         "running",
+		CPUDetails:    []CPUDiagnostics{{Id: 0, Time: 100, Utilisation: 15}},
+		NICDetails:    []NICDiagnostics{{MACAddress: "fa:16:3e:aa:bb:cc", RxOctets: 10}, {TxOctets: 20}},
+		MemoryDetails: MemoryDiagnostics{Maximum: 64, Used: 16},
+	})
+	c.Assert(err, gc.IsNil)
+
+	resp, err := s.authRequest("GET", "/servers/sr1/diagnostics", nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var legacy map[string]int
+	assertJSON(c, resp, &legacy)
+	c.Assert(legacy, gc.DeepEquals, map[string]int{
+		"memory":           64 * 1024,
+		"cpu0_time":        100,
+		"vnet0_rx":         10,
+		"vnet0_rx_drop":    0,
+		"vnet0_rx_errors":  0,
+		"vnet0_rx_packets": 0,
+		"vnet0_tx":         0,
+		"vnet0_tx_drop":    0,
+		"vnet0_tx_errors":  0,
+		"vnet0_tx_packets": 0,
+		"vnet1_rx":         0,
+		"vnet1_rx_drop":    0,
+		"vnet1_rx_errors":  0,
+		"vnet1_rx_packets": 0,
+		"vnet1_tx":         20,
+		"vnet1_tx_drop":    0,
+		"vnet1_tx_errors":  0,
+		"vnet1_tx_packets": 0,
+	})
+
+	resp, err = s.authRequest("GET", "/servers/sr1/diagnostics", nil, diagnosticsHeader())
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var structured ServerDiagnostics
+	assertJSON(c, resp, &structured)
+	c.Assert(structured.NumCPUs, gc.Equals, 1)
+	c.Assert(structured.NumDisks, gc.Equals, 0)
+	c.Assert(structured.NumNICs, gc.Equals, 2)
+	c.Assert(structured.CPUDetails, gc.DeepEquals, []CPUDiagnostics{{Id: 0, Time: 100, Utilisation: 15}})
+
+	err = s.service.SetServerDiagnostics("unknown", ServerDiagnostics{})
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: No such server \"unknown\"")
+}
+
+func (s *NovaHTTPSuite) TestGetServerDiagnosticsErrors(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusShutoff}
 	err := s.service.addServer(server)
 	c.Assert(err, gc.IsNil)
 	defer s.service.removeServer(server.Id)
-	for _, group := range groups {
-		err = s.service.addSecurityGroup(group)
-		c.Assert(err, gc.IsNil)
-		defer s.service.removeSecurityGroup(group.Id)
-		err = s.service.addServerSecurityGroup(server.Id, group.Id)
-		c.Assert(err, gc.IsNil)
-		defer s.service.removeServerSecurityGroup(server.Id, group.Id)
-	}
-	srvGroups = s.service.allServerSecurityGroups(server.Id)
-	var expected struct {
-		Groups []nova.SecurityGroup `json:"security_groups"`
-	}
-	resp, err := s.authRequest("GET", "/servers/"+server.Id+"/os-security-groups", nil, nil)
+
+	resp, err := s.authRequest("GET", "/servers/sr1/diagnostics", nil, nil)
 	c.Assert(err, gc.IsNil)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Groups, gc.DeepEquals, groups)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusConflict,
+		body: `{"conflictingRequest":{"message":"Cannot 'get_diagnostics' instance sr1 while it is in status SHUTOFF", "code":409}}`,
+	})
+	resp, err = s.authRequest("GET", "/servers/unknown/diagnostics", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp, err = s.authRequest("POST", "/servers/sr1/diagnostics", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusMethodNotAllowed)
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET")
 }
 
-func (s *NovaHTTPSuite) TestDeleteServerSecurityGroup(c *gc.C) {
-	group := nova.SecurityGroup{Id: "1", Name: "group"}
-	err := s.service.addSecurityGroup(group)
-	c.Assert(err, gc.IsNil)
-	defer s.service.removeSecurityGroup(group.Id)
-	server := nova.ServerDetail{Id: "sr1"}
-	err = s.service.addServer(server)
+func (s *NovaHTTPSuite) TestGetVNCConsole(c *gc.C) {
+	server := nova.ServerDetail{Id: "sr1", Status: nova.StatusActive}
+	err := s.service.addServer(server)
 	c.Assert(err, gc.IsNil)
 	defer s.service.removeServer(server.Id)
-	ok := s.service.hasServerSecurityGroup(server.Id, group.Id)
-	c.Assert(ok, gc.Equals, false)
-	err = s.service.addServerSecurityGroup(server.Id, group.Id)
+	req := map[string]interface{}{"os-getVNCConsole": map[string]string{"type": "novnc"}}
+	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
 	c.Assert(err, gc.IsNil)
-	var req struct {
-		Group struct {
-			Name string `json:"name"`
-		} `json:"removeSecurityGroup"`
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var result struct {
+		Console struct {
+			Type string `json:"type"`
+			URL  string `json:"url"`
+		} `json:"console"`
 	}
-	req.Group.Name = group.Name
-	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	assertJSON(c, resp, &result)
+	c.Assert(result.Console.Type, gc.Equals, "novnc")
+	consoleURL, err := url.Parse(result.Console.URL)
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	ok = s.service.hasServerSecurityGroup(server.Id, group.Id)
-	c.Assert(ok, gc.Equals, false)
+	c.Assert(consoleURL.Path, gc.Equals, "/vnc_auto.html")
+	c.Assert(consoleURL.Query().Get("token"), gc.Not(gc.Equals), "")
+	req = map[string]interface{}{"os-getVNCConsole": map[string]string{"type": "spice-html5"}}
+	resp, err = s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: 400,
+		body: `{"badRequest":{"message":"Invalid console type spice-html5", "code":400}}`,
+	})
 }
 
-func (s *NovaHTTPSuite) TestPostFloatingIP(c *gc.C) {
-	fip := nova.FloatingIP{Id: "1", IP: "10.0.0.1", Pool: "nova"}
-	c.Assert(s.service.allFloatingIPs(), gc.HasLen, 0)
-	var expected struct {
-		IP nova.FloatingIP `json:"floating_ip"`
+func (s *NovaHTTPSuite) TestListAvailabilityZonesDetail(c *gc.C) {
+	defer s.service.SetAvailabilityZones()
+	s.service.SetAvailabilityZones()
+	resp, err := s.jsonRequest("GET", "/os-availability-zone/detail", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, errNotFoundJSON)
+
+	zones := []nova.AvailabilityZone{{
+		Name:  "az1",
+		State: nova.AvailabilityZoneState{Available: true},
+		Hosts: map[string]map[string]nova.AvailabilityZoneService{
+			"compute1": {"nova-compute": {Available: true, Active: true}},
+			"compute2": {"nova-compute": {Available: false, Active: true}},
+		},
+	}, {
+		Name: "az2",
+	}}
+	s.service.SetAvailabilityZones(zones...)
+	var result struct {
+		Zones []nova.AvailabilityZone `json:"availabilityZoneInfo"`
 	}
-	resp, err := s.authRequest("POST", "/os-floating-ips", nil, nil)
+	resp, err = s.jsonRequest("GET", "/os-availability-zone/detail", nil, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.IP, gc.DeepEquals, fip)
-	err = s.service.removeFloatingIP(fip.Id)
+	assertJSON(c, resp, &result)
+	c.Assert(result.Zones, gc.DeepEquals, zones)
+
+	// The hosts are only listed in detail.
+	result.Zones = nil
+	resp, err = s.jsonRequest("GET", "/os-availability-zone", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &result)
+	c.Assert(result.Zones, gc.HasLen, 2)
+	c.Assert(result.Zones[0].Hosts, gc.IsNil)
+
+	resp, err = s.jsonRequest("GET", "/os-availability-zone/other", nil, nil)
 	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, errNotFoundJSON)
 }
 
-func (s *NovaHTTPSuite) TestGetFloatingIPs(c *gc.C) {
-	c.Assert(s.service.allFloatingIPs(), gc.HasLen, 0)
-	var expected struct {
-		IPs []nova.FloatingIP `json:"floating_ips"`
+func (s *NovaHTTPSuite) TestRunServerAvailabilityZone(c *gc.C) {
+	defer s.service.SetAvailabilityZones()
+	s.service.SetAvailabilityZones(
+		nova.AvailabilityZone{Name: "az1", State: nova.AvailabilityZoneState{Available: true}},
+		nova.AvailabilityZone{Name: "az2"},
+	)
+	for i, t := range []struct {
+		zone string
+		code int
+	}{
+		{"no-such-zone", http.StatusBadRequest},
+		{"az2", http.StatusBadRequest},
+		{"az1", http.StatusAccepted},
+	} {
+		c.Logf("test %d: zone %q", i, t.zone)
+		var req struct {
+			Server nova.RunServerOpts `json:"server"`
+		}
+		req.Server = nova.RunServerOpts{Name: "srv", FlavorId: "1", ImageId: "1", AvailabilityZone: t.zone}
+		resp, err := s.jsonRequest("POST", "/servers", req, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(resp.StatusCode, gc.Equals, t.code)
+		if t.code != http.StatusAccepted {
+			assertBody(c, resp, &errorResponse{
+				code: 400,
+				body: `{"badRequest":{"message":"The requested availability zone is not available", "code":400}}`,
+			})
+			continue
+		}
+		var result struct {
+			Server nova.Entity `json:"server"`
+		}
+		assertJSON(c, resp, &result)
+		server, err := s.service.server(result.Server.Id)
+		c.Assert(err, gc.IsNil)
+		c.Assert(server.AvailabilityZone, gc.Equals, "az1")
+		err = s.service.removeServer(server.Id)
+		c.Assert(err, gc.IsNil)
 	}
-	resp, err := s.authRequest("GET", "/os-floating-ips", nil, nil)
+}
+
+func (s *NovaHTTPSuite) createServerGroup(c *gc.C, name, policy string) ServerGroup {
+	var result struct {
+		ServerGroup ServerGroup `json:"server_group"`
+	}
+	body := map[string]interface{}{"server_group": map[string]interface{}{
+		"name":     name,
+		"policies": []string{policy},
+	}}
+	resp, err := s.jsonRequest("POST", "/os-server-groups", body, nil)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.IPs, gc.HasLen, 0)
-	fips := []nova.FloatingIP{
-		{Id: "1", IP: "1.2.3.4", Pool: "nova"},
-		{Id: "2", IP: "4.3.2.1", Pool: "nova"},
-	}
-	for _, fip := range fips {
-		err := s.service.addFloatingIP(fip)
-		defer s.service.removeFloatingIP(fip.Id)
-		c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &result)
+	return result.ServerGroup
+}
+
+// runServerInGroup starts a server with the given scheduler hints.
+func (s *NovaHTTPSuite) runServerInGroup(c *gc.C, hints map[string]string) *http.Response {
+	body := map[string]interface{}{
+		"server": map[string]string{
+			"name":      "srv",
+			"flavorRef": "1",
+			"imageRef":  "1",
+		},
+		"os:scheduler_hints": hints,
 	}
-	resp, err = s.authRequest("GET", "/os-floating-ips", nil, nil)
+	resp, err := s.jsonRequest("POST", "/servers", body, nil)
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expected)
-	if expected.IPs[0].Id != fips[0].Id {
-		expected.IPs[0], expected.IPs[1] = expected.IPs[1], expected.IPs[0]
+	return resp
+}
+
+func (s *NovaHTTPSuite) TestServerGroupTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("isolated-user", "secret", "isolated-tenant")
+	group, err := s.service.addServerGroup(other.TenantId, "private", []string{"affinity"})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServerGroup(group.Id)
+
+	// The group is neither listed nor found for other tenants.
+	var list struct {
+		ServerGroups []ServerGroup `json:"server_groups"`
 	}
-	c.Assert(expected.IPs, gc.DeepEquals, fips)
-	var expectedIP struct {
-		IP nova.FloatingIP `json:"floating_ip"`
+	resp, err := s.authRequest("GET", "/os-server-groups", nil, nil)
+	c.Assert(err, gc.IsNil)
+	assertJSON(c, resp, &list)
+	c.Assert(list.ServerGroups, gc.HasLen, 0)
+	for _, method := range []string{"GET", "DELETE"} {
+		resp, err = s.authRequest(method, "/os-server-groups/"+group.Id, nil, nil)
+		c.Assert(err, gc.IsNil)
+		assertBody(c, resp, &errorResponse{
+			code: http.StatusNotFound,
+			body: `{"itemNotFound":{"message":"Instance group ` + group.Id + ` could not be found.", "code":404}}`,
+		})
 	}
-	resp, err = s.authRequest("GET", "/os-floating-ips/1", nil, nil)
+	_, err = s.service.serverGroup(group.Id)
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
-	assertJSON(c, resp, &expectedIP)
-	c.Assert(expectedIP.IP, gc.DeepEquals, fips[0])
-}
 
-func (s *NovaHTTPSuite) TestDeleteFloatingIP(c *gc.C) {
-	fip := nova.FloatingIP{Id: "1", IP: "10.0.0.1", Pool: "nova"}
-	err := s.service.addFloatingIP(fip)
+	// Nor can servers be booted into it.
+	for _, hints := range []map[string]string{{"group": group.Id}, {"server_group": group.Id}, {"server_group": "private"}} {
+		resp := s.runServerInGroup(c, hints)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	}
+	found, err := s.service.serverGroup(group.Id)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeFloatingIP(fip.Id)
-	resp, err := s.authRequest("DELETE", "/os-floating-ips/1", nil, nil)
+	c.Assert(found.Members, gc.HasLen, 0)
+
+	url := s.service.endpointURL(true, "/os-server-groups")
+	resp, err = s.sendRequest("GET", url, nil, setHeader(authToken, other.Token))
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	_, err = s.service.floatingIP(fip.Id)
-	c.Assert(err, gc.NotNil)
+	assertJSON(c, resp, &list)
+	c.Assert(list.ServerGroups, gc.DeepEquals, []ServerGroup{*group})
 }
 
-func (s *NovaHTTPSuite) TestAddServerFloatingIP(c *gc.C) {
-	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
-	server := nova.ServerDetail{Id: "sr1"}
-	err := s.service.addFloatingIP(fip)
-	c.Assert(err, gc.IsNil)
-	defer s.service.removeFloatingIP(fip.Id)
-	err = s.service.addServer(server)
+func (s *NovaHTTPSuite) TestServerGroups(c *gc.C) {
+	group := s.createServerGroup(c, "group", "anti-affinity")
+	defer s.service.removeServerGroup(group.Id)
+	c.Assert(group.Id, gc.Not(gc.Equals), "")
+	c.Assert(group, gc.DeepEquals, ServerGroup{
+		Id:       group.Id,
+		Name:     "group",
+		Policies: []string{"anti-affinity"},
+		Members:  []string{},
+		Metadata: map[string]string{},
+		TenantId: s.service.TenantId,
+	})
+
+	var list struct {
+		ServerGroups []ServerGroup `json:"server_groups"`
+	}
+	resp, err := s.authRequest("GET", "/os-server-groups", nil, nil)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeServer(server.Id)
-	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, false)
-	var req struct {
-		AddFloatingIP struct {
-			Address string `json:"address"`
-		} `json:"addFloatingIp"`
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &list)
+	c.Assert(list.ServerGroups, gc.DeepEquals, []ServerGroup{group})
+
+	var show struct {
+		ServerGroup ServerGroup `json:"server_group"`
 	}
-	req.AddFloatingIP.Address = fip.IP
-	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
+	resp, err = s.authRequest("GET", "/os-server-groups/"+group.Id, nil, nil)
 	c.Assert(err, gc.IsNil)
-	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, true)
-	err = s.service.removeServerFloatingIP(server.Id, fip.Id)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &show)
+	c.Assert(show.ServerGroup, gc.DeepEquals, group)
+
+	resp, err = s.authRequest("DELETE", "/os-server-groups/"+group.Id, nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp, err = s.authRequest("GET", "/os-server-groups/"+group.Id, nil, nil)
 	c.Assert(err, gc.IsNil)
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusNotFound,
+		body: `{"itemNotFound":{"message":"Instance group ` + group.Id + ` could not be found.", "code":404}}`,
+	})
 }
 
-func (s *NovaHTTPSuite) TestRemoveServerFloatingIP(c *gc.C) {
-	fip := nova.FloatingIP{Id: "1", IP: "1.2.3.4"}
-	server := nova.ServerDetail{Id: "sr1"}
-	err := s.service.addFloatingIP(fip)
+func (s *NovaHTTPSuite) TestServerGroupInvalidPolicies(c *gc.C) {
+	for i, policies := range [][]string{nil, {"bogus"}, {"affinity", "anti-affinity"}} {
+		c.Logf("test %d: %v", i, policies)
+		body := map[string]interface{}{"server_group": map[string]interface{}{
+			"name":     "group",
+			"policies": policies,
+		}}
+		resp, err := s.jsonRequest("POST", "/os-server-groups", body, nil)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusBadRequest)
+	}
+	c.Assert(s.service.allServerGroups(), gc.HasLen, 0)
+}
+
+func (s *NovaHTTPSuite) TestRunServerInGroup(c *gc.C) {
+	group := s.createServerGroup(c, "group", "affinity")
+	defer s.service.removeServerGroup(group.Id)
+	var created struct {
+		Server struct {
+			Id string `json:"id"`
+		} `json:"server"`
+	}
+	var ids []string
+	for _, hints := range []map[string]string{{"group": group.Id}, {"server_group": "group"}} {
+		resp := s.runServerInGroup(c, hints)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+		assertJSON(c, resp, &created)
+		defer s.service.removeServer(created.Server.Id)
+		ids = append(ids, created.Server.Id)
+	}
+	found, err := s.service.serverGroup(group.Id)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeFloatingIP(fip.Id)
-	err = s.service.addServer(server)
+	c.Assert(found.Members, gc.DeepEquals, ids)
+
+	err = s.service.removeServer(ids[0])
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeServer(server.Id)
-	err = s.service.addServerFloatingIP(server.Id, fip.Id)
+	found, err = s.service.serverGroup(group.Id)
 	c.Assert(err, gc.IsNil)
-	defer s.service.removeServerFloatingIP(server.Id, fip.Id)
-	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, true)
-	var req struct {
-		RemoveFloatingIP struct {
-			Address string `json:"address"`
-		} `json:"removeFloatingIp"`
+	c.Assert(found.Members, gc.DeepEquals, ids[1:])
+
+	resp := s.runServerInGroup(c, map[string]string{"group": "missing"})
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusBadRequest,
+		body: `{"badRequest":{"message":"Invalid server group missing: no such group", "code":400}}`,
+	})
+}
+
+func (s *NovaHTTPSuite) TestRunServerInFullGroup(c *gc.C) {
+	s.service.SetHostCount(1)
+	defer s.service.SetHostCount(0)
+	group := s.createServerGroup(c, "group", "anti-affinity")
+	defer s.service.removeServerGroup(group.Id)
+	var created struct {
+		Server struct {
+			Id string `json:"id"`
+		} `json:"server"`
 	}
-	req.RemoveFloatingIP.Address = fip.IP
-	resp, err := s.jsonRequest("POST", "/servers/"+server.Id+"/action", req, nil)
-	c.Assert(err, gc.IsNil)
+	resp := s.runServerInGroup(c, map[string]string{"group": group.Id})
 	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
-	c.Assert(s.service.hasServerFloatingIP(server.Id, fip.IP), gc.Equals, false)
+	assertJSON(c, resp, &created)
+	defer s.service.removeServer(created.Server.Id)
+	servers := len(s.service.allServers(nil))
+
+	resp = s.runServerInGroup(c, map[string]string{"group": group.Id})
+	assertBody(c, resp, &errorResponse{
+		code: http.StatusConflict,
+		body: `{"conflictingRequest":{"message":"Cannot add server to anti-affinity server group ` + group.Id + `: every host is already in use by the group", "code":409}}`,
+	})
+	c.Assert(s.service.allServers(nil), gc.HasLen, servers)
+
+	// Affinity groups are not limited.
+	affinity := s.createServerGroup(c, "together", "affinity")
+	defer s.service.removeServerGroup(affinity.Id)
+	for i := 0; i < 2; i++ {
+		resp = s.runServerInGroup(c, map[string]string{"group": affinity.Id})
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+		assertJSON(c, resp, &created)
+		defer s.service.removeServer(created.Server.Id)
+	}
 }
 
-func (s *NovaHTTPSuite) TestListAvailabilityZones(c *gc.C) {
-	resp, err := s.jsonRequest("GET", "/os-availability-zone", nil, nil)
-	c.Assert(err, gc.IsNil)
-	assertBody(c, resp, errNotFoundJSON)
+func (s *NovaHTTPSuite) TestServerBuildDuration(c *gc.C) {
+	clk := clock.NewManualClock(time.Now())
+	s.service.Clock = clk
+	s.service.BuildDuration = 30 * time.Second
+	defer func() {
+		s.service.Clock = nil
+		s.service.BuildDuration = 0
+	}()
+	resp := s.runServerOnNetworks(c)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	var created struct {
+		Server struct {
+			Id string `json:"id"`
+		} `json:"server"`
+	}
+	assertJSON(c, resp, &created)
+	defer s.service.removeServer(created.Server.Id)
 
-	zones := []nova.AvailabilityZone{
-		{Name: "az1"},
-		{
-			Name: "az2", State: nova.AvailabilityZoneState{Available: true},
-		},
+	status := func() string {
+		var result struct {
+			Server nova.ServerDetail `json:"server"`
+		}
+		resp, err := s.authRequest("GET", "/servers/"+created.Server.Id, nil, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		assertJSON(c, resp, &result)
+		return result.Server.Status
 	}
-	s.service.SetAvailabilityZones(zones...)
-	resp, err = s.jsonRequest("GET", "/os-availability-zone", nil, nil)
-	c.Assert(err, gc.IsNil)
-	var expected struct {
-		Zones []nova.AvailabilityZone `json:"availabilityZoneInfo"`
+	polls := 0
+	for status() == nova.StatusBuild {
+		polls++
+		c.Assert(polls < 10, gc.Equals, true, gc.Commentf("server never became active"))
+		clk.Advance(10 * time.Second)
 	}
-	assertJSON(c, resp, &expected)
-	c.Assert(expected.Zones, gc.DeepEquals, zones)
+	c.Assert(polls, gc.Equals, 3)
+	c.Assert(status(), gc.Equals, nova.StatusActive)
 }
 
-func (s *NovaHTTPSSuite) SetUpSuite(c *gc.C) {
-	s.HTTPSuite.SetUpSuite(c)
-	identityDouble := identityservice.NewUserPass()
-	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
-	s.token = userInfo.Token
-	c.Assert(s.Server.URL[:8], gc.Equals, "https://")
-	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
+func (s *NovaHTTPSuite) showServer(c *gc.C, serverId string) nova.ServerDetail {
+	var result struct {
+		Server nova.ServerDetail `json:"server"`
+	}
+	resp, err := s.authRequest("GET", "/servers/"+serverId, nil, nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	assertJSON(c, resp, &result)
+	return result.Server
 }
 
-func (s *NovaHTTPSSuite) TearDownSuite(c *gc.C) {
-	s.HTTPSuite.TearDownSuite(c)
+func (s *NovaHTTPSuite) TestFailServerCreates(c *gc.C) {
+	fault := nova.ServerFault{Code: 500, Message: "Build of instance aborted", Details: "Traceback"}
+	s.service.FailServerCreates(2, fault)
+	defer s.service.FailServerCreates(0, nova.ServerFault{})
+	for i := 0; i < 2; i++ {
+		id := s.runServer(c, runServerRequest("1"))
+		defer s.service.removeServer(id)
+		server := s.showServer(c, id)
+		c.Assert(server.Status, gc.Equals, nova.StatusError)
+		c.Assert(server.Fault, gc.NotNil)
+		c.Assert(server.Fault.Code, gc.Equals, 500)
+		c.Assert(server.Fault.Message, gc.Equals, "Build of instance aborted")
+		c.Assert(server.Fault.Details, gc.Equals, "Traceback")
+		c.Assert(server.Fault.Created, gc.Equals, server.Created)
+	}
+	id := s.runServer(c, runServerRequest("1"))
+	defer s.service.removeServer(id)
+	server := s.showServer(c, id)
+	c.Assert(server.Status, gc.Equals, nova.StatusBuild)
+	c.Assert(server.Fault, gc.IsNil)
+
+	// Faults are only reported for failed servers.
+	s.service.FailServerCreates(1, nova.ServerFault{})
+	id = s.runServer(c, runServerRequest("1"))
+	defer s.service.removeServer(id)
+	c.Assert(s.showServer(c, id).Fault, gc.DeepEquals, &nova.ServerFault{
+		Code:    500,
+		Message: "No valid host was found. There are not enough hosts available.",
+		Created: s.showServer(c, id).Created,
+	})
+	err := s.service.SetServerStatus(id, nova.StatusActive)
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.showServer(c, id).Fault, gc.IsNil)
 }
 
-func (s *NovaHTTPSSuite) SetUpTest(c *gc.C) {
-	s.HTTPSuite.SetUpTest(c)
-	s.service.SetupHTTP(s.Mux)
+func (s *NovaHTTPSuite) TestFailServerCreatesOnlyCountsCreatedServers(c *gc.C) {
+	s.service.FailServerCreates(1, nova.ServerFault{})
+	defer s.service.FailServerCreates(0, nova.ServerFault{})
+	cleanup := s.service.RegisterControlPoint(
+		"addServer",
+		func(sc hook.ServiceControl, args ...interface{}) error {
+			return fmt.Errorf("add failed")
+		},
+	)
+	resp, err := s.jsonRequest("POST", "/servers", runServerRequest("1"), nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusInternalServerError)
+	cleanup()
+	// The failure is still to come.
+	id := s.runServer(c, runServerRequest("1"))
+	defer s.service.removeServer(id)
+	c.Assert(s.showServer(c, id).Status, gc.Equals, nova.StatusError)
 }
 
-func (s *NovaHTTPSSuite) TearDownTest(c *gc.C) {
-	s.HTTPSuite.TearDownTest(c)
+func (s *NovaHTTPSuite) TestFailServerCreatesWithBuildDuration(c *gc.C) {
+	clk := clock.NewManualClock(time.Now())
+	s.service.Clock = clk
+	s.service.BuildDuration = 30 * time.Second
+	defer func() {
+		s.service.Clock = nil
+		s.service.BuildDuration = 0
+	}()
+	s.service.FailServerCreates(1, nova.ServerFault{})
+	defer s.service.FailServerCreates(0, nova.ServerFault{})
+	failed := s.runServer(c, runServerRequest("1"))
+	defer s.service.removeServer(failed)
+	built := s.runServer(c, runServerRequest("1"))
+	defer s.service.removeServer(built)
+	server := s.showServer(c, failed)
+	c.Assert(server.Status, gc.Equals, nova.StatusBuild)
+	c.Assert(server.Fault, gc.IsNil)
+
+	clk.Advance(30 * time.Second)
+	server = s.showServer(c, failed)
+	c.Assert(server.Status, gc.Equals, nova.StatusError)
+	c.Assert(server.Fault, gc.NotNil)
+	c.Assert(server.Fault.Created, gc.Equals, server.Updated)
+	c.Assert(s.showServer(c, built).Status, gc.Equals, nova.StatusActive)
 }
 
-func (s *NovaHTTPSSuite) TestHasHTTPSServiceURL(c *gc.C) {
-	endpoints := s.service.Endpoints()
-	c.Assert(endpoints[0].PublicURL[:8], gc.Equals, "https://")
+func (s *NovaHTTPSuite) TestHypervisors(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	admin := identityDouble.AddUser("hypervisor-admin", "secret", "hypervisor-admin")
+	err := identityDouble.SetUserTenant("hypervisor-admin", admin.TenantId, "hypervisor-admin", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	s.service.SetHypervisors([]Hypervisor{
+		{Id: 2, HostName: "compute-2", Type: "QEMU", HostIP: "10.0.0.2", VCPUs: 4, MemoryMB: 8192, LocalGB: 100},
+		{Id: 1, HostName: "compute-1", Type: "QEMU", HostIP: "10.0.0.1", VCPUs: 8, MemoryMB: 16384, LocalGB: 200},
+		{Id: 3, HostName: "compute-3", Type: "QEMU", Status: HypervisorDisabled, VCPUs: 8},
+	})
+	defer s.service.SetHypervisors([]Hypervisor{defaultHypervisor})
+	err = s.service.addFlavor(nova.FlavorDetail{Id: "hv", Name: "hv", RAM: 1024, VCPUs: 2, Disk: 10})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeFlavor("hv")
+	// Servers are spread over the available hypervisors.
+	for _, id := range []string{"hv1", "hv2", "hv3"} {
+		err := s.service.addServer(nova.ServerDetail{Id: id, Status: nova.StatusActive, Flavor: nova.Entity{Id: "hv"}})
+		c.Assert(err, gc.IsNil)
+		defer s.service.removeServer(id)
+	}
+	// Servers which failed to build are not placed.
+	err = s.service.addServer(nova.ServerDetail{Id: "hv4", Status: nova.StatusError, Flavor: nova.Entity{Id: "hv"}})
+	c.Assert(err, gc.IsNil)
+	defer s.service.removeServer("hv4")
+	get := func(path string) *http.Response {
+		resp, err := s.sendRequest("GET", s.service.endpointURL(true, path), nil, setHeader(authToken, admin.Token))
+		c.Assert(err, gc.IsNil)
+		return resp
+	}
+
+	resp := get("/os-hypervisors")
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var list struct {
+		Hypervisors []hypervisorSummary `json:"hypervisors"`
+	}
+	assertJSON(c, resp, &list)
+	c.Assert(list.Hypervisors, gc.DeepEquals, []hypervisorSummary{
+		{1, "compute-1", "up", "enabled"},
+		{2, "compute-2", "up", "enabled"},
+		{3, "compute-3", "up", "disabled"},
+	})
+
+	resp = get("/os-hypervisors/detail")
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var details struct {
+		Hypervisors []map[string]interface{} `json:"hypervisors"`
+	}
+	assertJSON(c, resp, &details)
+	c.Assert(details.Hypervisors, gc.HasLen, 3)
+	hv1 := details.Hypervisors[0]
+	c.Check(hv1["hypervisor_hostname"], gc.Equals, "compute-1")
+	c.Check(hv1["hypervisor_type"], gc.Equals, "QEMU")
+	c.Check(hv1["host_ip"], gc.Equals, "10.0.0.1")
+	c.Check(hv1["vcpus"], gc.Equals, 8.0)
+	c.Check(hv1["vcpus_used"], gc.Equals, 4.0)
+	c.Check(hv1["memory_mb_used"], gc.Equals, 2048.0)
+	c.Check(hv1["free_ram_mb"], gc.Equals, 14336.0)
+	c.Check(hv1["local_gb_used"], gc.Equals, 20.0)
+	c.Check(hv1["free_disk_gb"], gc.Equals, 180.0)
+	c.Check(hv1["running_vms"], gc.Equals, 2.0)
+	c.Check(hv1["service"], gc.DeepEquals, map[string]interface{}{"host": "compute-1", "id": 1.0, "disabled_reason": nil})
+	c.Check(details.Hypervisors[1]["running_vms"], gc.Equals, 1.0)
+	c.Check(details.Hypervisors[2]["running_vms"], gc.Equals, 0.0)
+
+	// Usage follows the servers.
+	err = s.service.removeServer("hv1")
+	c.Assert(err, gc.IsNil)
+	resp = get("/os-hypervisors/1")
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var show struct {
+		Hypervisor map[string]interface{} `json:"hypervisor"`
+	}
+	assertJSON(c, resp, &show)
+	c.Check(show.Hypervisor["running_vms"], gc.Equals, 1.0)
+	c.Check(show.Hypervisor["vcpus_used"], gc.Equals, 2.0)
+
+	resp = get("/os-hypervisors/statistics")
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var stats struct {
+		Statistics map[string]int `json:"hypervisor_statistics"`
+	}
+	assertJSON(c, resp, &stats)
+	c.Check(stats.Statistics["count"], gc.Equals, 3)
+	c.Check(stats.Statistics["vcpus"], gc.Equals, 20)
+	c.Check(stats.Statistics["vcpus_used"], gc.Equals, 4)
+	c.Check(stats.Statistics["running_vms"], gc.Equals, 2)
+
+	resp = get("/os-hypervisors/9")
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNotFound)
+	resp.Body.Close()
+
+	resp = get("/os-hosts")
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var hosts struct {
+		Hosts []map[string]string `json:"hosts"`
+	}
+	assertJSON(c, resp, &hosts)
+	c.Assert(hosts.Hosts, gc.DeepEquals, []map[string]string{
+		{"host_name": "compute-1", "service": "compute", "zone": "nova"},
+		{"host_name": "compute-2", "service": "compute", "zone": "nova"},
+		{"host_name": "compute-3", "service": "compute", "zone": "nova"},
+	})
+}
+
+func (s *NovaHTTPSuite) TestHypervisorsRequireAdmin(c *gc.C) {
+	for _, path := range []string{"/os-hypervisors", "/os-hypervisors/detail", "/os-hypervisors/1", "/os-hypervisors/statistics", "/os-hosts"} {
+		c.Logf("path %s", path)
+		resp, err := s.authRequest("GET", path, nil, nil)
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusForbidden)
+		var body identityservice.ErrorWrapper
+		assertJSON(c, resp, &body)
+		c.Check(body.Error.Message, gc.Matches, `.*requires role "admin".*`)
+	}
+	// The restriction survives a Reset.
+	s.service.Reset()
+	resp, err := s.authRequest("GET", "/os-hypervisors", nil, nil)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusForbidden)
 }
@@ -1,11 +1,16 @@
 package openstackservice
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 
 	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testservices"
 	"gopkg.in/goose.v1/testservices/identityservice"
 	"gopkg.in/goose.v1/testservices/novaservice"
 	"gopkg.in/goose.v1/testservices/swiftservice"
@@ -20,21 +25,42 @@ type Openstack struct {
 
 // New creates an instance of a full Openstack service double.
 // An initial user with the specified credentials is registered with the identity service.
+// The identity service is mounted under the path of the credentials' URL, if any, as
+// it is in deployments which host Keystone under a path such as "/identity", and is
+// listed in the catalog at that URL.
 func New(cred *identity.Credentials, authMode identity.AuthMode) *Openstack {
 	var openstack Openstack
+	prefix := identityPathPrefix(cred.URL)
 	if authMode == identity.AuthKeyPair {
+		keyPair := identityservice.NewKeyPair()
+		keyPair.PathPrefix = prefix
 		openstack = Openstack{
-			Identity: identityservice.NewKeyPair(),
+			Identity: keyPair,
 		}
 	} else {
+		userPass := identityservice.NewUserPass()
+		userPass.AuthURI = cred.URL
+		userPass.PathPrefix = prefix
 		openstack = Openstack{
-			Identity: identityservice.NewUserPass(),
+			Identity: userPass,
 		}
 	}
 	userInfo := openstack.Identity.AddUser(cred.User, cred.Secrets, cred.TenantName)
 	if cred.TenantName == "" {
 		panic("Openstack service double requires a tenant to be specified.")
 	}
+	if prefix != "" {
+		openstack.Identity.AddService(identityservice.Service{
+			Name: "keystone",
+			Type: identityservice.ServiceTypeIdentity,
+			Endpoints: []identityservice.Endpoint{{
+				AdminURL:    cred.URL,
+				InternalURL: cred.URL,
+				PublicURL:   cred.URL,
+				Region:      cred.Region,
+			}},
+		})
+	}
 	openstack.Nova = novaservice.New(cred.URL, "v2", userInfo.TenantId, cred.Region, openstack.Identity)
 	// Create the swift service using only the region base so we emulate real world deployments.
 	regionParts := strings.Split(cred.Region, ".")
@@ -42,10 +68,7 @@ func New(cred *identity.Credentials, authMode identity.AuthMode) *Openstack {
 	openstack.Swift = swiftservice.New(cred.URL, "v1", userInfo.TenantId, baseRegion, openstack.Identity)
 	// Create container and add image metadata endpoint so that product-streams URLs are included
 	// in the keystone catalog.
-	err := openstack.Swift.AddContainer("imagemetadata")
-	if err != nil {
-		panic(fmt.Errorf("setting up image metadata container: %v", err))
-	}
+	openstack.addImageMetadataContainer()
 	url := openstack.Swift.Endpoints()[0].PublicURL
 	serviceDef := identityservice.Service{
 		Name: "simplestreams",
@@ -65,9 +88,174 @@ func New(cred *identity.Credentials, authMode identity.AuthMode) *Openstack {
 	return &openstack
 }
 
+// addImageMetadataContainer creates the container in which image
+// metadata is published, which real deployments provide.
+func (openstack *Openstack) addImageMetadataContainer() {
+	err := openstack.Swift.AddContainer("imagemetadata")
+	if err != nil {
+		panic(fmt.Errorf("setting up image metadata container: %v", err))
+	}
+}
+
+// Reset restores all the services to the state New leaves them in, so
+// that tests may share a double without interfering with each other:
+// the identity service discards the tokens it has issued, and Nova and
+// Swift discard their resources and injected failures (see the Reset
+// methods of each). Users, the service catalog and the services'
+// configuration are preserved, so clients need only authenticate
+// again.
+func (openstack *Openstack) Reset() {
+	if identity, ok := openstack.Identity.(identityservice.Resetter); ok {
+		identity.Reset()
+	}
+	openstack.Nova.Reset()
+	openstack.Swift.Reset()
+	openstack.addImageMetadataContainer()
+}
+
+// identityPathPrefix returns the path of the identity service's URL,
+// under which it is mounted, or "" if it is served from the root.
+func identityPathPrefix(identityURL string) string {
+	u, err := url.Parse(identityURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(u.Path, "/")
+}
+
 // SetupHTTP attaches all the needed handlers to provide the HTTP API for the Openstack service..
 func (openstack *Openstack) SetupHTTP(mux *http.ServeMux) {
 	openstack.Identity.SetupHTTP(mux)
 	openstack.Nova.SetupHTTP(mux)
 	openstack.Swift.SetupHTTP(mux)
 }
+
+// ServiceName returns the name of the service which handles r: "nova"
+// or "swift" for requests to their endpoints, and "identity" for any
+// other request.
+func (openstack *Openstack) ServiceName(r *http.Request) string {
+	for _, service := range []struct {
+		name      string
+		endpoints []identityservice.Endpoint
+	}{
+		{"nova", openstack.Nova.Endpoints()},
+		{"swift", openstack.Swift.Endpoints()},
+	} {
+		for _, endpoint := range service.endpoints {
+			u, err := url.Parse(endpoint.PublicURL)
+			if err != nil || u.Path == "" {
+				continue
+			}
+			if strings.HasPrefix(r.URL.Path, u.Path) {
+				return service.name
+			}
+		}
+	}
+	return "identity"
+}
+
+// Server is an Openstack service double with its own HTTP server,
+// on which all of its services are mounted.
+type Server struct {
+	*Openstack
+	// URL is the base URL of the HTTP server, which is also the
+	// identity service's URL unless it is mounted under a path.
+	URL string
+
+	server *httptest.Server
+	tracer *testservices.Tracer
+}
+
+// NewServer starts an HTTP server providing a full Openstack service
+// double. The URL of cred is set to the server's URL, so that cred may
+// be used to create clients, and all the service catalog entries refer
+// to the server. If cred's URL has a path, such as "/identity", it is
+// kept, and the identity service is mounted under it. An initial user
+// with the specified credentials is registered with the identity
+// service. Every response reports the id of its request, see
+// StartTrace. The server must be closed with Close when it is no
+// longer needed.
+func NewServer(cred *identity.Credentials, authMode identity.AuthMode) *Server {
+	return newServer(cred, authMode, httptest.NewServer)
+}
+
+// NewTLSServer is like NewServer, but the server uses TLS, so the URL
+// of cred and the service catalog entries are https URLs. Clients must
+// be configured to trust the server's certificate, see Certificate.
+func NewTLSServer(cred *identity.Credentials, authMode identity.AuthMode) *Server {
+	return newServer(cred, authMode, httptest.NewTLSServer)
+}
+
+// NewInvalidTLSServer is like NewTLSServer, but the server presents a
+// certificate which has expired, so that clients may be tested to
+// reject it even when they trust the certificate.
+func NewInvalidTLSServer(cred *identity.Credentials, authMode identity.AuthMode) *Server {
+	return newServer(cred, authMode, func(handler http.Handler) *httptest.Server {
+		server := httptest.NewUnstartedServer(handler)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{expiredCertificate()}}
+		server.StartTLS()
+		return server
+	})
+}
+
+// newServer starts a server, calling start to create its HTTP server.
+func newServer(cred *identity.Credentials, authMode identity.AuthMode, start func(http.Handler) *httptest.Server) *Server {
+	mux := http.NewServeMux()
+	s := &Server{}
+	s.tracer = testservices.NewTracer(mux, func(r *http.Request) string {
+		return s.ServiceName(r)
+	})
+	s.server = start(s.tracer)
+	s.URL = s.server.URL
+	cred.URL = s.URL + identityPathPrefix(cred.URL)
+	s.Openstack = New(cred, authMode)
+	s.SetupHTTP(mux)
+	return s
+}
+
+// StartTrace starts recording the requests made to the server,
+// discarding any already recorded.
+func (s *Server) StartTrace() {
+	s.tracer.StartTrace()
+}
+
+// StopTrace stops recording the requests made to the server.
+func (s *Server) StopTrace() {
+	s.tracer.StopTrace()
+}
+
+// Trace returns the requests recorded since StartTrace was called, in
+// the order they were handled.
+func (s *Server) Trace() []testservices.TraceEntry {
+	return s.tracer.Trace()
+}
+
+// Certificate returns the certificate presented by a server started
+// with NewTLSServer or NewInvalidTLSServer, or nil if the server does
+// not use TLS.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.server.Certificate()
+}
+
+// CertPool returns a certificate pool holding the server's
+// certificate, with which clients may be configured to trust the
+// server. It returns nil if the server does not use TLS.
+func (s *Server) CertPool() *x509.CertPool {
+	cert := s.Certificate()
+	if cert == nil {
+		return nil
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return pool
+}
+
+// AddUser registers a further user with the identity service.
+func (s *Server) AddUser(user, secret, tenant string) *identityservice.UserInfo {
+	return s.Identity.AddUser(user, secret, tenant)
+}
+
+// Close shuts down the HTTP server.
+func (s *Server) Close() {
+	s.server.Close()
+}
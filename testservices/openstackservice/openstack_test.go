@@ -0,0 +1,250 @@
+package openstackservice_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/client"
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/nova"
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/openstackservice"
+)
+
+type ServerSuite struct {
+	cred   *identity.Credentials
+	server *openstackservice.Server
+}
+
+var _ = gc.Suite(&ServerSuite{})
+
+func (s *ServerSuite) SetUpTest(c *gc.C) {
+	s.cred = &identity.Credentials{
+		User:       "fred",
+		Secrets:    "secret",
+		Region:     "some region",
+		TenantName: "tenant",
+	}
+	s.server = openstackservice.NewServer(s.cred, identity.AuthUserPass)
+}
+
+func (s *ServerSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+}
+
+func (s *ServerSuite) TestCatalogUsesServerURL(c *gc.C) {
+	c.Assert(s.cred.URL, gc.Equals, s.server.URL)
+	cl := client.NewClient(s.cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	for _, serviceType := range []string{"compute", "object-store", "product-streams"} {
+		serviceURL, err := cl.MakeServiceURL(serviceType, nil)
+		c.Assert(err, gc.IsNil)
+		c.Check(strings.HasPrefix(serviceURL, s.server.URL+"/"), gc.Equals, true, gc.Commentf("%s: %s", serviceType, serviceURL))
+	}
+}
+
+func (s *ServerSuite) TestIdentityPathPrefix(c *gc.C) {
+	cred := &identity.Credentials{
+		URL:        "http://unused.invalid/identity",
+		User:       "fred",
+		Secrets:    "secret",
+		Region:     "some region",
+		TenantName: "tenant",
+	}
+	server := openstackservice.NewServer(cred, identity.AuthUserPass)
+	defer server.Close()
+	c.Assert(cred.URL, gc.Equals, server.URL+"/identity")
+	cl := client.NewClient(cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	identityURL, err := cl.MakeServiceURL("identity", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(identityURL, gc.Equals, cred.URL)
+	computeURL, err := cl.MakeServiceURL("compute", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(strings.HasPrefix(computeURL, server.URL+"/"), gc.Equals, true)
+}
+
+func (s *ServerSuite) TestServicesMounted(c *gc.C) {
+	cl := client.NewClient(s.cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	for _, t := range []struct {
+		serviceType string
+		path        string
+	}{
+		{"compute", "flavors"},
+		{"object-store", "imagemetadata"},
+	} {
+		serviceURL, err := cl.MakeServiceURL(t.serviceType, []string{t.path})
+		c.Assert(err, gc.IsNil)
+		req, err := http.NewRequest("GET", serviceURL, nil)
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("X-Auth-Token", cl.Token())
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.StatusCode, gc.Equals, http.StatusOK, gc.Commentf("%s", serviceURL))
+	}
+}
+
+func (s *ServerSuite) TestAddUser(c *gc.C) {
+	userInfo := s.server.AddUser("jim", "secret2", "tenant2")
+	c.Assert(userInfo.Token, gc.Not(gc.Equals), "")
+	cred := *s.cred
+	cred.User = "jim"
+	cred.Secrets = "secret2"
+	cred.TenantName = "tenant2"
+	cl := client.NewClient(&cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	c.Assert(cl.TenantId(), gc.Equals, userInfo.TenantId)
+}
+
+func (s *ServerSuite) TestReset(c *gc.C) {
+	cl := client.NewClient(s.cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	err = s.server.Swift.AddContainer("test")
+	c.Assert(err, gc.IsNil)
+	s.server.Nova.FailServerCreates(1, nova.ServerFault{})
+	s.server.Reset()
+	c.Assert(s.server.Swift.HasContainer("test"), gc.Equals, false)
+	c.Assert(s.server.Swift.HasContainer("imagemetadata"), gc.Equals, true)
+
+	// The old token is no longer valid, but the user may authenticate
+	// again and use the services as before.
+	flavorsURL, err := cl.MakeServiceURL("compute", []string{"flavors"})
+	c.Assert(err, gc.IsNil)
+	get := func(token string) int {
+		req, err := http.NewRequest("GET", flavorsURL, nil)
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("X-Auth-Token", token)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	c.Assert(get(cl.Token()), gc.Equals, http.StatusUnauthorized)
+	cl = client.NewClient(s.cred, identity.AuthUserPass, nil)
+	err = cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	c.Assert(get(cl.Token()), gc.Equals, http.StatusOK)
+}
+
+func (s *ServerSuite) TestClose(c *gc.C) {
+	server := openstackservice.NewServer(&identity.Credentials{
+		User:       "fred",
+		Secrets:    "secret",
+		Region:     "some region",
+		TenantName: "tenant",
+	}, identity.AuthUserPass)
+	server.Close()
+	_, err := http.Get(server.URL)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *ServerSuite) TestTrace(c *gc.C) {
+	s.server.StartTrace()
+	cl := client.NewClient(s.cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	for _, serviceType := range []string{"compute", "object-store"} {
+		serviceURL, err := cl.MakeServiceURL(serviceType, []string{"missing"})
+		c.Assert(err, gc.IsNil)
+		req, err := http.NewRequest("GET", serviceURL, nil)
+		c.Assert(err, gc.IsNil)
+		req.Header.Set("X-Auth-Token", cl.Token())
+		req.Header.Set(testservices.RequestIdHeader, "req-"+serviceType)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Check(resp.Header.Get(testservices.RequestIdHeader), gc.Equals, "req-"+serviceType)
+	}
+	trace := s.server.Trace()
+	c.Assert(trace, gc.HasLen, 3)
+	c.Check(trace[0].Service, gc.Equals, "identity")
+	c.Check(trace[0].Method, gc.Equals, "POST")
+	c.Check(trace[0].Status, gc.Equals, http.StatusOK)
+	c.Check(trace[0].RequestId, gc.Not(gc.Equals), "")
+	c.Check(trace[1].Service, gc.Equals, "nova")
+	c.Check(trace[1].RequestId, gc.Equals, "req-compute")
+	c.Check(trace[1].Status, gc.Equals, http.StatusNotFound)
+	c.Check(trace[2].Service, gc.Equals, "swift")
+	c.Check(trace[2].RequestId, gc.Equals, "req-object-store")
+	c.Check(trace[2].Status, gc.Equals, http.StatusNotFound)
+}
+
+func (s *ServerSuite) TestCertificateWithoutTLS(c *gc.C) {
+	c.Assert(s.server.Certificate(), gc.IsNil)
+	c.Assert(s.server.CertPool(), gc.IsNil)
+}
+
+type TLSServerSuite struct {
+	cred *identity.Credentials
+}
+
+var _ = gc.Suite(&TLSServerSuite{})
+
+func (s *TLSServerSuite) SetUpTest(c *gc.C) {
+	s.cred = &identity.Credentials{
+		User:       "fred",
+		Secrets:    "secret",
+		Region:     "some region",
+		TenantName: "tenant",
+	}
+}
+
+// trustingClient returns an HTTP client which trusts the certificate
+// of the given server.
+func trustingClient(server *openstackservice.Server) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: server.CertPool()},
+	}}
+}
+
+func (s *TLSServerSuite) TestTLSServer(c *gc.C) {
+	server := openstackservice.NewTLSServer(s.cred, identity.AuthUserPass)
+	defer server.Close()
+	c.Assert(strings.HasPrefix(server.URL, "https://"), gc.Equals, true)
+	c.Assert(s.cred.URL, gc.Equals, server.URL)
+	c.Assert(server.Certificate(), gc.NotNil)
+
+	cl := client.NewNonValidatingClient(s.cred, identity.AuthUserPass, nil)
+	err := cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+	serviceURL, err := cl.MakeServiceURL("compute", []string{"flavors"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(strings.HasPrefix(serviceURL, server.URL+"/"), gc.Equals, true)
+
+	req, err := http.NewRequest("GET", serviceURL, nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("X-Auth-Token", cl.Token())
+	resp, err := trustingClient(server).Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+
+	// Clients which do not trust the certificate reject it.
+	_, err = http.Get(serviceURL)
+	c.Assert(err, gc.ErrorMatches, ".*certificate signed by unknown authority.*")
+}
+
+func (s *TLSServerSuite) TestInvalidTLSServer(c *gc.C) {
+	server := openstackservice.NewInvalidTLSServer(s.cred, identity.AuthUserPass)
+	defer server.Close()
+	c.Assert(strings.HasPrefix(server.URL, "https://"), gc.Equals, true)
+	c.Assert(server.Certificate().NotAfter.Before(time.Now()), gc.Equals, true)
+	_, err := trustingClient(server).Get(server.URL)
+	c.Assert(err, gc.ErrorMatches, ".*certificate has expired.*")
+
+	// Clients which do not validate certificates may still be used.
+	cl := client.NewNonValidatingClient(s.cred, identity.AuthUserPass, nil)
+	err = cl.Authenticate()
+	c.Assert(err, gc.IsNil)
+}
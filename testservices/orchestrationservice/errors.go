@@ -0,0 +1,111 @@
+// Heat double testing service - error responses
+
+package orchestrationservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// heatError is an error which is reported to clients in the format
+// used by Heat.
+type heatError struct {
+	code        int
+	kind        string
+	explanation string
+	message     string
+	// allowed holds the methods listed in the Allow header of a 405
+	// response.
+	allowed []string
+}
+
+func (e *heatError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.message)
+}
+
+func (e *heatError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp := struct {
+		Explanation string `json:"explanation"`
+		Code        int    `json:"code"`
+		Error       struct {
+			Message   string  `json:"message"`
+			Traceback *string `json:"traceback"`
+			Type      string  `json:"type"`
+		} `json:"error"`
+		Title string `json:"title"`
+	}{
+		Explanation: e.explanation,
+		Code:        e.code,
+		Title:       http.StatusText(e.code),
+	}
+	resp.Error.Message = e.message
+	resp.Error.Type = e.kind
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(e.allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(e.allowed, ", "))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(e.code)
+	w.Write(body)
+}
+
+// The explanations Heat gives for each kind of failure.
+const (
+	badRequestExplanation       = "The server could not comply with the request since it is either malformed or otherwise incorrect."
+	notFoundExplanation         = "The resource could not be found."
+	conflictExplanation         = "There was a conflict when trying to complete your request."
+	methodNotAllowedExplanation = "The method specified is not allowed for this resource."
+)
+
+func heatErrorf(code int, kind, explanation, message string, args ...interface{}) *heatError {
+	return &heatError{
+		code:        code,
+		kind:        kind,
+		explanation: explanation,
+		message:     fmt.Sprintf(message, args...),
+	}
+}
+
+func errBadRequest(message string) error {
+	return heatErrorf(http.StatusBadRequest, "HTTPBadRequest", badRequestExplanation, "%s", message)
+}
+
+func errInvalidTemplate(message string) error {
+	return heatErrorf(http.StatusBadRequest, "StackValidationFailed", badRequestExplanation, "%s", message)
+}
+
+func errInvalidStackName(name string) error {
+	return heatErrorf(http.StatusBadRequest, "StackValidationFailed", badRequestExplanation,
+		`Invalid stack name %s must contain only alphanumeric or "_-." characters, must start with alpha and must be 255 characters or less.`, name)
+}
+
+func errNotFound(path string) error {
+	return heatErrorf(http.StatusNotFound, "HTTPNotFound", notFoundExplanation, "The resource could not be found: %s", path)
+}
+
+func errMethodNotAllowed(method, path string, allowed ...string) error {
+	err := heatErrorf(http.StatusMethodNotAllowed, "HTTPMethodNotAllowed", methodNotAllowedExplanation, "Method %s is not allowed for %s", method, path)
+	err.allowed = allowed
+	return err
+}
+
+func errStackExists(name string) error {
+	return heatErrorf(http.StatusConflict, "StackExists", conflictExplanation, "The Stack (%s) already exists.", name)
+}
+
+func errStackNotFound(nameOrId string) error {
+	return heatErrorf(http.StatusNotFound, "EntityNotFound", notFoundExplanation, "The Stack (%s) could not be found.", nameOrId)
+}
+
+func errActionInProgress(name, action string) error {
+	return heatErrorf(http.StatusConflict, "ActionInProgress", conflictExplanation,
+		"Stack %s already has an action (%s) in progress.", name, action)
+}
@@ -0,0 +1,363 @@
+// Heat double testing service - internal direct API implementation
+
+package orchestrationservice
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+var _ testservices.HttpService = (*Heat)(nil)
+var _ identityservice.ServiceProvider = (*Heat)(nil)
+
+// The statuses of stacks. A stack's status is the action last taken
+// on it followed by the state of that action.
+const (
+	StatusCreateInProgress = "CREATE_IN_PROGRESS"
+	StatusCreateComplete   = "CREATE_COMPLETE"
+	StatusCreateFailed     = "CREATE_FAILED"
+	StatusUpdateInProgress = "UPDATE_IN_PROGRESS"
+	StatusUpdateComplete   = "UPDATE_COMPLETE"
+	StatusUpdateFailed     = "UPDATE_FAILED"
+)
+
+// timeFormat is the format of the times Heat reports.
+const timeFormat = "2006-01-02T15:04:05Z"
+
+// Link is a link to a stack.
+type Link struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
+}
+
+// Stack describes a Heat stack.
+type Stack struct {
+	Id              string            `json:"id"`
+	Name            string            `json:"stack_name"`
+	Description     string            `json:"description"`
+	Status          string            `json:"stack_status"`
+	StatusReason    string            `json:"stack_status_reason"`
+	CreationTime    string            `json:"creation_time"`
+	UpdatedTime     string            `json:"updated_time,omitempty"`
+	Parameters      map[string]string `json:"parameters"`
+	TimeoutMins     int               `json:"timeout_mins,omitempty"`
+	DisableRollback bool              `json:"disable_rollback"`
+	Links           []Link            `json:"links"`
+
+	// template holds the template the stack was last created or
+	// updated with.
+	template string
+	// tenantId holds the tenant which owns the stack. Heat lists it
+	// only for admins, as stack_owner, so it is not reported.
+	tenantId string
+}
+
+// Heat implements a OpenStack Heat (v1) testing service and contains
+// the service double's internal state.
+type Heat struct {
+	testservices.ServiceInstance
+	// Clock, if set, is used to tell when stack actions finish.
+	Clock identityservice.Clock
+	// ActionDuration is how long stacks take to be created or
+	// updated, after which their status changes from
+	// CREATE_IN_PROGRESS to CREATE_COMPLETE, or from
+	// UPDATE_IN_PROGRESS to UPDATE_COMPLETE. If zero, stacks are
+	// reported as complete by any request after the one which
+	// started the action.
+	ActionDuration time.Duration
+
+	mu            sync.Mutex // protects the remaining fields
+	stacks        map[string]Stack
+	actionStarted map[string]time.Time
+}
+
+// New creates an instance of the Heat object, given the parameters.
+func New(hostURL, versionPath, tenantId, region string, identityService identityservice.IdentityService) *Heat {
+	URL, err := url.Parse(hostURL)
+	if err != nil {
+		panic(err)
+	}
+	hostname := URL.Host
+	if !strings.HasSuffix(hostname, "/") {
+		hostname += "/"
+	}
+	heat := &Heat{
+		stacks:        make(map[string]Stack),
+		actionStarted: make(map[string]time.Time),
+		ServiceInstance: testservices.ServiceInstance{
+			IdentityService: identityService,
+			Scheme:          URL.Scheme,
+			Hostname:        hostname,
+			VersionPath:     versionPath,
+			TenantId:        tenantId,
+			Region:          region,
+		},
+	}
+	if identityService != nil {
+		identityService.RegisterServiceProvider("heat", identityservice.ServiceTypeOrchestration, heat)
+	}
+	return heat
+}
+
+// endpointURL returns the service endpoint URL, which includes the
+// API version and the tenant, followed by the given path.
+func (h *Heat) endpointURL(path string) string {
+	return h.Scheme + "://" + h.Hostname + h.VersionPath + "/" + h.TenantId + path
+}
+
+// Reset removes all stacks, along with everything set with the
+// ServiceInstance methods (see ServiceInstance.Reset). The exported
+// fields, such as Clock and ActionDuration, are preserved.
+func (h *Heat) Reset() {
+	h.ServiceInstance.Reset()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stacks = make(map[string]Stack)
+	h.actionStarted = make(map[string]time.Time)
+}
+
+func (h *Heat) Endpoints() []identityservice.Endpoint {
+	ep := identityservice.Endpoint{
+		AdminURL:    h.endpointURL(""),
+		InternalURL: h.endpointURL(""),
+		PublicURL:   h.endpointURL(""),
+		Region:      h.Region,
+	}
+	return []identityservice.Endpoint{ep}
+}
+
+// now returns the current time according to the service's clock.
+func (h *Heat) now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+	return h.Clock.Now()
+}
+
+// stackAction returns the action of a stack status, such as "CREATE",
+// and whether the action is still in progress.
+func stackAction(status string) (string, bool) {
+	i := strings.Index(status, "_")
+	if i < 0 {
+		return status, false
+	}
+	return status[:i], status[i+1:] == "IN_PROGRESS"
+}
+
+// finishActions completes the actions of all the stacks whose actions
+// have been in progress for at least ActionDuration. It must be called
+// with h.mu held.
+func (h *Heat) finishActions() {
+	now := h.now()
+	for stackId, started := range h.actionStarted {
+		if now.Before(started.Add(h.ActionDuration)) {
+			continue
+		}
+		delete(h.actionStarted, stackId)
+		stack, ok := h.stacks[stackId]
+		if !ok {
+			continue
+		}
+		action, inProgress := stackAction(stack.Status)
+		if !inProgress {
+			continue
+		}
+		stack.Status = action + "_COMPLETE"
+		stack.StatusReason = "Stack " + action + " completed successfully"
+		h.stacks[stackId] = stack
+	}
+}
+
+// startAction sets the status of a stack to show the given action is
+// in progress. It must be called with h.mu held.
+func (h *Heat) startAction(stack *Stack, action string) {
+	stack.Status = action + "_IN_PROGRESS"
+	stack.StatusReason = "Stack " + action + " started"
+	h.actionStarted[stack.Id] = h.now()
+}
+
+var stackNamePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_.-]{0,254}$`)
+
+// addStack stores a new stack, made from the given template, and
+// starts creating it.
+func (h *Heat) addStack(stack Stack, template string) (*Stack, error) {
+	if err := h.ProcessFunctionHook(h, &stack); err != nil {
+		return nil, err
+	}
+	if !stackNamePattern.MatchString(stack.Name) {
+		return nil, errInvalidStackName(stack.Name)
+	}
+	info, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+	if stack.tenantId == "" {
+		stack.tenantId = h.TenantId
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, existing := range h.stacks {
+		if existing.Id == stack.Id || existing.Name == stack.Name && existing.tenantId == stack.tenantId {
+			return nil, errStackExists(stack.Name)
+		}
+	}
+	if stack.Parameters == nil {
+		stack.Parameters = make(map[string]string)
+	}
+	stack.Description = info.description
+	stack.CreationTime = h.now().UTC().Format(timeFormat)
+	stack.Links = []Link{{Href: h.endpointURL("/stacks/" + stack.Name + "/" + stack.Id), Rel: "self"}}
+	stack.template = template
+	h.startAction(&stack, "CREATE")
+	h.stacks[stack.Id] = stack
+	return &stack, nil
+}
+
+// stack retrieves an existing stack, owned by the given tenant, by
+// name or id. Stack names are unique only within a tenant, so if
+// tenantId is empty the stack with the given id, or any stack with
+// the given name, is returned.
+func (h *Heat) stack(nameOrId, tenantId string) (*Stack, error) {
+	if err := h.ProcessFunctionHook(h, nameOrId, tenantId); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.finishActions()
+	stack, ok := h.findStack(nameOrId, tenantId)
+	if !ok {
+		return nil, errStackNotFound(nameOrId)
+	}
+	return &stack, nil
+}
+
+// findStack returns the stack owned by the given tenant with the given
+// name or id, or any tenant's stack if tenantId is empty. It must be
+// called with h.mu held.
+func (h *Heat) findStack(nameOrId, tenantId string) (Stack, bool) {
+	owned := func(stack Stack) bool {
+		return tenantId == "" || stack.tenantId == tenantId
+	}
+	if stack, ok := h.stacks[nameOrId]; ok && owned(stack) {
+		return stack, true
+	}
+	for _, stack := range h.stacks {
+		if stack.Name == nameOrId && owned(stack) {
+			return stack, true
+		}
+	}
+	return Stack{}, false
+}
+
+type stacksByName []Stack
+
+func (s stacksByName) Len() int {
+	return len(s)
+}
+
+func (s stacksByName) Less(i, j int) bool {
+	return s[i].Name < s[j].Name
+}
+
+func (s stacksByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// allStacks returns a list of the existing stacks owned by the given
+// tenant, ordered by name. If tenantId is empty, all stacks are
+// returned.
+func (h *Heat) allStacks(tenantId string) []Stack {
+	h.mu.Lock()
+	h.finishActions()
+	stacks := make([]Stack, 0, len(h.stacks))
+	for _, stack := range h.stacks {
+		if tenantId != "" && stack.tenantId != tenantId {
+			continue
+		}
+		stacks = append(stacks, stack)
+	}
+	h.mu.Unlock()
+	sort.Sort(stacksByName(stacks))
+	return stacks
+}
+
+// updateStack starts updating an existing stack with a new template
+// and parameters. Parameters which are not given keep their values.
+// A stack cannot be updated while an action is in progress.
+func (h *Heat) updateStack(stackId, template string, parameters map[string]string) error {
+	if err := h.ProcessFunctionHook(h, stackId, template, parameters); err != nil {
+		return err
+	}
+	info, err := parseTemplate(template)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.finishActions()
+	stack, ok := h.stacks[stackId]
+	if !ok {
+		return errStackNotFound(stackId)
+	}
+	if action, inProgress := stackAction(stack.Status); inProgress {
+		return errActionInProgress(stack.Name, action)
+	}
+	params := make(map[string]string)
+	for key, value := range stack.Parameters {
+		params[key] = value
+	}
+	for key, value := range parameters {
+		params[key] = value
+	}
+	stack.Parameters = params
+	stack.Description = info.description
+	stack.UpdatedTime = h.now().UTC().Format(timeFormat)
+	stack.template = template
+	h.startAction(&stack, "UPDATE")
+	h.stacks[stackId] = stack
+	return nil
+}
+
+// removeStack deletes an existing stack. Heat deletes the resources
+// of the stack in the background; the double has none, so the stack
+// is removed at once.
+func (h *Heat) removeStack(stackId string) error {
+	if err := h.ProcessFunctionHook(h, stackId); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.stacks[stackId]; !ok {
+		return errStackNotFound(stackId)
+	}
+	delete(h.stacks, stackId)
+	delete(h.actionStarted, stackId)
+	return nil
+}
+
+// SetStackStatus sets the status of an existing stack, and the reason
+// for it, so that tests can simulate failed actions. Any action in
+// progress is no longer completed when ActionDuration has passed.
+//
+// Note: this is implemented as a public method rather than as an
+// HTTP API because stack statuses are set by the Heat engine.
+func (h *Heat) SetStackStatus(stackId, status, reason string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stack, ok := h.stacks[stackId]
+	if !ok {
+		return errStackNotFound(stackId)
+	}
+	delete(h.actionStarted, stackId)
+	stack.Status = status
+	stack.StatusReason = reason
+	h.stacks[stackId] = stack
+	return nil
+}
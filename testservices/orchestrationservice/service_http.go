@@ -0,0 +1,258 @@
+// Heat double testing service - HTTP API implementation
+
+package orchestrationservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/goose.v1/testservices"
+)
+
+const authToken = "X-Auth-Token"
+
+// unauthorizedResponse is the verbatim body of a real Heat 401.
+const unauthorizedResponse = `{"error": {"message": "The request you have made requires authentication.", "code": 401, "title": "Unauthorized"}}`
+
+type heatHandler struct {
+	h      *Heat
+	method func(h *Heat, w http.ResponseWriter, r *http.Request) error
+	// methods holds the HTTP methods supported by the route, as
+	// reported in response to OPTIONS requests.
+	methods []string
+}
+
+func (h *heatHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.h.DelayResponse(r); err != nil {
+		// The client has given up on the request.
+		return
+	}
+	h.h.AddResponseHeaders(w)
+	if h.h.HandleOptions(w, r, h.methods...) {
+		return
+	}
+	// handle invalid X-Auth-Token header
+	token := r.Header.Get(authToken)
+	user, err := h.h.IdentityService.FindUser(token)
+	if err != nil || h.h.TokenExpired(token) {
+		w.Header().Set("Content-Type", "application/json")
+		writeResponse(w, http.StatusUnauthorized, []byte(unauthorizedResponse))
+		return
+	}
+	if err := h.h.CheckRole(r, user); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.h.CheckRateLimit(r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.h.LimitRequestBody(w, r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	err = h.method(h.h, w, r)
+	if err == nil {
+		return
+	}
+	resp, ok := err.(http.Handler)
+	if !ok {
+		resp = heatErrorf(http.StatusInternalServerError, "HTTPInternalServerError", "The server has either erred or is incapable of performing the requested operation.", "%s", err.Error())
+	}
+	resp.ServeHTTP(w, r)
+}
+
+func writeResponse(w http.ResponseWriter, code int, body []byte) {
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// sendJSON sends the specified response serialized as JSON.
+func sendJSON(code int, resp interface{}, w http.ResponseWriter, r *http.Request) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, code, data)
+	return nil
+}
+
+// handler returns an http.Handler for a route supporting the given
+// HTTP methods, served by the given method.
+func (h *Heat) handler(method func(h *Heat, w http.ResponseWriter, r *http.Request) error, methods ...string) http.Handler {
+	return &heatHandler{h, method, methods}
+}
+
+// stackRequest holds the fields of a request to create or update a
+// stack which the double uses. The template may be given as a JSON
+// object, or as a string holding JSON or YAML.
+type stackRequest struct {
+	StackName       string                 `json:"stack_name"`
+	Template        json.RawMessage        `json:"template"`
+	Parameters      map[string]interface{} `json:"parameters"`
+	TimeoutMins     int                    `json:"timeout_mins"`
+	DisableRollback *bool                  `json:"disable_rollback"`
+}
+
+// readStackRequest reads the body of a request to create or update a
+// stack, returning the request and its template.
+func readStackRequest(r *http.Request) (*stackRequest, string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	var req stackRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, "", errBadRequest("The JSON body is malformed.")
+	}
+	if len(req.Template) == 0 || string(req.Template) == "null" {
+		return nil, "", errBadRequest("No template specified")
+	}
+	template := string(req.Template)
+	if req.Template[0] == '"' {
+		if err := json.Unmarshal(req.Template, &template); err != nil {
+			return nil, "", errBadRequest("The JSON body is malformed.")
+		}
+	}
+	return &req, template, nil
+}
+
+// stackParameters returns the parameters of a request as strings, as
+// Heat reports them.
+func stackParameters(params map[string]interface{}) map[string]string {
+	if params == nil {
+		return nil
+	}
+	result := make(map[string]string)
+	for key, value := range params {
+		result[key] = fmt.Sprint(value)
+	}
+	return result
+}
+
+// handleStacks handles the stacks HTTP API. Stacks are addressed as
+// stacks/<name>/<id>; stacks/<name or id> redirects to that path for
+// GET requests, and otherwise refers to the same stack. Other tenants'
+// stacks are not found unless an admin asks for all tenants.
+func (h *Heat) handleStacks(w http.ResponseWriter, r *http.Request) error {
+	prefix := fmt.Sprintf("/%s/%s/stacks", h.VersionPath, h.TenantId)
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	user, err := h.IdentityService.FindUser(r.Header.Get(authToken))
+	if err != nil {
+		return err
+	}
+	scope, err := testservices.TenantScope(r, user)
+	if err != nil {
+		return errBadRequest(err.Error())
+	}
+	if rest == "" {
+		switch r.Method {
+		case "GET":
+			resp := struct {
+				Stacks []Stack `json:"stacks"`
+			}{h.allStacks(scope)}
+			return sendJSON(http.StatusOK, resp, w, r)
+		case "POST":
+			return h.createStack(user.TenantId, w, r)
+		}
+		return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "POST")
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) > 2 {
+		return errNotFound(r.URL.Path)
+	}
+	// An id identifies a stack even when names are shared by the
+	// stacks of several tenants, but Heat reports missing stacks by
+	// name.
+	stack, err := h.stack(parts[len(parts)-1], scope)
+	if e, ok := err.(*heatError); ok && e.code == http.StatusNotFound {
+		return errStackNotFound(parts[0])
+	} else if err != nil {
+		return err
+	}
+	if len(parts) == 2 && (stack.Name != parts[0] || stack.Id != parts[1]) {
+		return errStackNotFound(rest)
+	}
+	if len(parts) == 1 && r.Method == "GET" {
+		w.Header().Set("Location", stack.Links[0].Href)
+		writeResponse(w, http.StatusFound, nil)
+		return nil
+	}
+	switch r.Method {
+	case "GET":
+		resp := struct {
+			Stack Stack `json:"stack"`
+		}{*stack}
+		return sendJSON(http.StatusOK, resp, w, r)
+	case "PUT":
+		req, template, err := readStackRequest(r)
+		if err != nil {
+			return err
+		}
+		if err := h.updateStack(stack.Id, template, stackParameters(req.Parameters)); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusAccepted, nil)
+		return nil
+	case "DELETE":
+		if err := h.removeStack(stack.Id); err != nil {
+			return err
+		}
+		writeResponse(w, http.StatusNoContent, nil)
+		return nil
+	}
+	return errMethodNotAllowed(r.Method, r.URL.Path, "GET", "PUT", "DELETE")
+}
+
+// createStack handles a request to create a stack, which belongs to
+// the given tenant.
+func (h *Heat) createStack(tenantId string, w http.ResponseWriter, r *http.Request) error {
+	req, template, err := readStackRequest(r)
+	if err != nil {
+		return err
+	}
+	if req.StackName == "" {
+		return errBadRequest("No stack name specified")
+	}
+	id, err := h.NewID(testservices.ResourceStack)
+	if err != nil {
+		return err
+	}
+	stack := Stack{
+		Id:              id,
+		Name:            req.StackName,
+		Parameters:      stackParameters(req.Parameters),
+		TimeoutMins:     req.TimeoutMins,
+		DisableRollback: req.DisableRollback == nil || *req.DisableRollback,
+		tenantId:        tenantId,
+	}
+	created, err := h.addStack(stack, template)
+	if err != nil {
+		return err
+	}
+	resp := struct {
+		Stack struct {
+			Id    string `json:"id"`
+			Links []Link `json:"links"`
+		} `json:"stack"`
+	}{}
+	resp.Stack.Id = created.Id
+	resp.Stack.Links = created.Links
+	w.Header().Set("Location", created.Links[0].Href)
+	return sendJSON(http.StatusCreated, resp, w, r)
+}
+
+// SetupHTTP attaches all the needed handlers to provide the HTTP API.
+func (h *Heat) SetupHTTP(mux *http.ServeMux) {
+	path := fmt.Sprintf("/%s/%s/stacks", h.VersionPath, h.TenantId)
+	handler := h.handler((*Heat).handleStacks, "GET", "POST", "PUT", "DELETE")
+	mux.Handle(path, handler)
+	mux.Handle(path+"/", handler)
+}
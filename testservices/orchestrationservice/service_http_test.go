@@ -0,0 +1,286 @@
+// Heat double testing service - HTTP API tests
+
+package orchestrationservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+type HeatHTTPSuite struct {
+	httpsuite.HTTPSuite
+	service *Heat
+	token   string
+}
+
+var _ = gc.Suite(&HeatHTTPSuite{})
+
+func (s *HeatHTTPSuite) SetUpTest(c *gc.C) {
+	s.HTTPSuite.SetUpTest(c)
+	identityDouble := identityservice.NewUserPass()
+	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
+	s.token = userInfo.Token
+	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
+	identityDouble.SetupHTTP(s.Mux)
+	s.service.SetupHTTP(s.Mux)
+}
+
+// jsonRequest sends the given body, if any, as JSON to path, relative
+// to the service endpoint, using the suite's token. Redirects are not
+// followed.
+func (s *HeatHTTPSuite) jsonRequest(c *gc.C, method, path string, body interface{}) *http.Response {
+	var jsonBody []byte
+	if body != nil {
+		var err error
+		jsonBody, err = json.Marshal(body)
+		c.Assert(err, gc.IsNil)
+	}
+	req, err := http.NewRequest(method, s.service.endpointURL(path), bytes.NewReader(jsonBody))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(authToken, s.token)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	c.Assert(err, gc.IsNil)
+	return resp
+}
+
+func assertJSON(c *gc.C, resp *http.Response, code int, result interface{}) {
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, code)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json")
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	err = json.Unmarshal(body, result)
+	c.Assert(err, gc.IsNil)
+}
+
+func assertHeatError(c *gc.C, resp *http.Response, code int, kind, message string) {
+	var result struct {
+		Code  int
+		Title string
+		Error struct {
+			Type    string
+			Message string
+		}
+	}
+	assertJSON(c, resp, code, &result)
+	c.Assert(result.Code, gc.Equals, code)
+	c.Assert(result.Title, gc.Equals, http.StatusText(code))
+	c.Assert(result.Error.Type, gc.Equals, kind)
+	c.Assert(result.Error.Message, gc.Matches, message)
+}
+
+func (s *HeatHTTPSuite) createStack(c *gc.C, name string, template interface{}) (id string) {
+	var result struct {
+		Stack struct {
+			Id    string `json:"id"`
+			Links []Link `json:"links"`
+		} `json:"stack"`
+	}
+	body := map[string]interface{}{
+		"stack_name": name,
+		"template":   template,
+		"parameters": map[string]interface{}{"flavor": "m1.tiny", "count": 2},
+	}
+	resp := s.jsonRequest(c, "POST", "/stacks", body)
+	location := resp.Header.Get("Location")
+	assertJSON(c, resp, http.StatusCreated, &result)
+	c.Assert(result.Stack.Id, gc.Not(gc.Equals), "")
+	href := s.service.endpointURL("/stacks/" + name + "/" + result.Stack.Id)
+	c.Assert(result.Stack.Links, gc.DeepEquals, []Link{{Href: href, Rel: "self"}})
+	c.Assert(location, gc.Equals, href)
+	return result.Stack.Id
+}
+
+func (s *HeatHTTPSuite) TestUnauthorized(c *gc.C) {
+	s.token = "bad-token"
+	resp := s.jsonRequest(c, "GET", "/stacks", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusUnauthorized)
+}
+
+func (s *HeatHTTPSuite) TestCatalog(c *gc.C) {
+	creds := identity.Credentials{User: "fred", Secrets: "secret", URL: s.Server.URL + "/tokens"}
+	auth, err := (&identity.UserPass{}).Auth(&creds)
+	c.Assert(err, gc.IsNil)
+	c.Assert(auth.RegionServiceURLs[region]["orchestration"], gc.Equals, s.service.endpointURL(""))
+}
+
+func (s *HeatHTTPSuite) TestCreateListShowUpdateDeleteStack(c *gc.C) {
+	id := s.createStack(c, "web", hotTemplate)
+
+	var list struct {
+		Stacks []Stack `json:"stacks"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/stacks", nil), http.StatusOK, &list)
+	c.Assert(list.Stacks, gc.HasLen, 1)
+	c.Assert(list.Stacks[0].Id, gc.Equals, id)
+	c.Assert(list.Stacks[0].Status, gc.Equals, StatusCreateComplete)
+
+	var show struct {
+		Stack Stack `json:"stack"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/stacks/web/"+id, nil), http.StatusOK, &show)
+	c.Assert(show.Stack.Name, gc.Equals, "web")
+	c.Assert(show.Stack.Description, gc.Equals, "A test stack")
+	c.Assert(show.Stack.Status, gc.Equals, StatusCreateComplete)
+	c.Assert(show.Stack.Parameters, gc.DeepEquals, map[string]string{"flavor": "m1.tiny", "count": "2"})
+	c.Assert(show.Stack.DisableRollback, gc.Equals, true)
+
+	// Stacks may be looked up by name or id.
+	for _, nameOrId := range []string{"web", id} {
+		resp := s.jsonRequest(c, "GET", "/stacks/"+nameOrId, nil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusFound)
+		c.Assert(resp.Header.Get("Location"), gc.Equals, s.service.endpointURL("/stacks/web/"+id))
+	}
+
+	body := map[string]interface{}{
+		"template":   `{"heat_template_version": "2016-10-14", "description": "Updated"}`,
+		"parameters": map[string]string{"flavor": "m1.small"},
+	}
+	resp := s.jsonRequest(c, "PUT", "/stacks/web/"+id, body)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	assertJSON(c, s.jsonRequest(c, "GET", "/stacks/web/"+id, nil), http.StatusOK, &show)
+	c.Assert(show.Stack.Status, gc.Equals, StatusUpdateComplete)
+	c.Assert(show.Stack.Description, gc.Equals, "Updated")
+	c.Assert(show.Stack.Parameters, gc.DeepEquals, map[string]string{"flavor": "m1.small", "count": "2"})
+	c.Assert(show.Stack.UpdatedTime, gc.Not(gc.Equals), "")
+
+	resp = s.jsonRequest(c, "DELETE", "/stacks/web/"+id, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp = s.jsonRequest(c, "GET", "/stacks/web/"+id, nil)
+	assertHeatError(c, resp, http.StatusNotFound, "EntityNotFound", `The Stack \(web\) could not be found.`)
+}
+
+func (s *HeatHTTPSuite) TestCreateStackTemplateObject(c *gc.C) {
+	template := map[string]interface{}{
+		"heat_template_version": "2016-10-14",
+		"resources":             map[string]interface{}{},
+	}
+	id := s.createStack(c, "web", template)
+	stack, err := s.service.stack(id, "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Status, gc.Equals, StatusCreateComplete)
+}
+
+func (s *HeatHTTPSuite) TestCreateStackInvalid(c *gc.C) {
+	for i, t := range []struct {
+		body    interface{}
+		kind    string
+		message string
+	}{
+		{map[string]interface{}{"stack_name": "web"}, "HTTPBadRequest", "No template specified"},
+		{map[string]interface{}{"template": hotTemplate}, "HTTPBadRequest", "No stack name specified"},
+		{map[string]interface{}{"stack_name": "-web", "template": hotTemplate}, "StackValidationFailed", "Invalid stack name -web .*"},
+		{map[string]interface{}{"stack_name": "web", "template": "resources:\n\tserver: {}\n"}, "StackValidationFailed", "Error parsing template: .*"},
+		{map[string]interface{}{"stack_name": "web", "template": `{"resources": {}`}, "StackValidationFailed", "Error parsing template: .*"},
+		{map[string]interface{}{"stack_name": "web", "template": "resources: {}\n"}, "StackValidationFailed", "Template format version not found."},
+	} {
+		c.Logf("test %d: %v", i, t.body)
+		resp := s.jsonRequest(c, "POST", "/stacks", t.body)
+		assertHeatError(c, resp, http.StatusBadRequest, t.kind, t.message)
+	}
+	c.Assert(s.service.allStacks(""), gc.HasLen, 0)
+}
+
+func (s *HeatHTTPSuite) TestCreateStackExists(c *gc.C) {
+	s.createStack(c, "web", hotTemplate)
+	body := map[string]interface{}{"stack_name": "web", "template": hotTemplate}
+	resp := s.jsonRequest(c, "POST", "/stacks", body)
+	assertHeatError(c, resp, http.StatusConflict, "StackExists", `The Stack \(web\) already exists.`)
+}
+
+func (s *HeatHTTPSuite) TestUpdateStackInvalidTemplate(c *gc.C) {
+	id := s.createStack(c, "web", hotTemplate)
+	body := map[string]interface{}{"template": "not a template"}
+	resp := s.jsonRequest(c, "PUT", "/stacks/web/"+id, body)
+	assertHeatError(c, resp, http.StatusBadRequest, "StackValidationFailed", "Error parsing template: .*")
+}
+
+func (s *HeatHTTPSuite) TestStackNameAndIdMismatch(c *gc.C) {
+	id := s.createStack(c, "web", hotTemplate)
+	s.createStack(c, "db", hotTemplate)
+	resp := s.jsonRequest(c, "GET", "/stacks/db/"+id, nil)
+	assertHeatError(c, resp, http.StatusNotFound, "EntityNotFound", `The Stack \(db/.*\) could not be found.`)
+}
+
+// stackIds returns the ids of the stacks listed with the given query.
+func (s *HeatHTTPSuite) stackIds(c *gc.C, query string) []string {
+	var list struct {
+		Stacks []Stack `json:"stacks"`
+	}
+	assertJSON(c, s.jsonRequest(c, "GET", "/stacks"+query, nil), http.StatusOK, &list)
+	ids := []string{}
+	for _, stack := range list.Stacks {
+		ids = append(ids, stack.Id)
+	}
+	return ids
+}
+
+func (s *HeatHTTPSuite) TestTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("other", "secret", "other-tenant")
+	admin := identityDouble.AddUser("admin", "secret", "admin-tenant")
+	err := identityDouble.SetUserTenant("admin", admin.TenantId, "admin-tenant", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	id := s.createStack(c, "web", hotTemplate)
+	owner := s.token
+
+	s.token = other.Token
+	c.Assert(s.stackIds(c, ""), gc.DeepEquals, []string{})
+	c.Assert(s.stackIds(c, "?all_tenants=1"), gc.DeepEquals, []string{})
+	for _, path := range []string{"/stacks/web", "/stacks/" + id, "/stacks/web/" + id} {
+		resp := s.jsonRequest(c, "GET", path, nil)
+		assertHeatError(c, resp, http.StatusNotFound, "EntityNotFound", `The Stack \(.*\) could not be found.`)
+	}
+	resp := s.jsonRequest(c, "DELETE", "/stacks/web/"+id, nil)
+	assertHeatError(c, resp, http.StatusNotFound, "EntityNotFound", `The Stack \(web\) could not be found.`)
+	// Stack names need only be unique within a tenant.
+	otherId := s.createStack(c, "web", hotTemplate)
+	c.Assert(s.stackIds(c, ""), gc.DeepEquals, []string{otherId})
+
+	s.token = owner
+	c.Assert(s.stackIds(c, ""), gc.DeepEquals, []string{id})
+	resp = s.jsonRequest(c, "GET", "/stacks/web", nil)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("Location"), gc.Equals, s.service.endpointURL("/stacks/web/"+id))
+
+	s.token = admin.Token
+	c.Assert(s.stackIds(c, ""), gc.DeepEquals, []string{})
+	c.Assert(s.stackIds(c, "?all_tenants=1"), gc.HasLen, 2)
+	resp = s.jsonRequest(c, "DELETE", "/stacks/web/"+otherId+"?all_tenants=1", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusNoContent)
+	resp = s.jsonRequest(c, "GET", "/stacks?all_tenants=maybe", nil)
+	assertHeatError(c, resp, http.StatusBadRequest, "HTTPBadRequest", "Invalid value 'maybe' for all_tenants")
+}
+
+func (s *HeatHTTPSuite) TestMethodNotAllowed(c *gc.C) {
+	id := s.createStack(c, "web", hotTemplate)
+	for i, t := range []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"PUT", "/stacks", "GET, POST"},
+		{"POST", "/stacks/web/" + id, "GET, PUT, DELETE"},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.path)
+		resp := s.jsonRequest(c, t.method, t.path, nil)
+		c.Check(resp.Header.Get("Allow"), gc.Equals, t.allow)
+		assertHeatError(c, resp, http.StatusMethodNotAllowed, "HTTPMethodNotAllowed", "Method .* is not allowed for .*")
+	}
+}
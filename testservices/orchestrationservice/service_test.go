@@ -0,0 +1,177 @@
+// Heat double testing service - internal direct API tests
+
+package orchestrationservice
+
+import (
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/testing/clock"
+)
+
+type HeatSuite struct {
+	service *Heat
+}
+
+const (
+	versionPath = "v1"
+	hostname    = "http://example.com"
+	region      = "region"
+)
+
+const hotTemplate = `heat_template_version: 2016-10-14
+description: A test stack
+
+parameters:
+  flavor:
+    type: string
+resources: {}
+`
+
+var _ = gc.Suite(&HeatSuite{})
+
+func (s *HeatSuite) SetUpTest(c *gc.C) {
+	s.service = New(hostname, versionPath, "tenant", region, nil)
+}
+
+func (s *HeatSuite) addStack(c *gc.C, id, name string) *Stack {
+	stack, err := s.service.addStack(Stack{Id: id, Name: name}, hotTemplate)
+	c.Assert(err, gc.IsNil)
+	return stack
+}
+
+func (s *HeatSuite) TestEndpoints(c *gc.C) {
+	endpoints := s.service.Endpoints()
+	c.Assert(endpoints, gc.HasLen, 1)
+	c.Assert(endpoints[0].PublicURL, gc.Equals, "http://example.com/v1/tenant")
+	c.Assert(endpoints[0].Region, gc.Equals, region)
+}
+
+func (s *HeatSuite) TestAddGetRemoveStack(c *gc.C) {
+	added := s.addStack(c, "1", "web")
+	c.Assert(added.Status, gc.Equals, StatusCreateInProgress)
+	c.Assert(added.Description, gc.Equals, "A test stack")
+	c.Assert(added.Links, gc.DeepEquals, []Link{{Href: "http://example.com/v1/tenant/stacks/web/1", Rel: "self"}})
+	stack, err := s.service.stack("web", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Id, gc.Equals, "1")
+	c.Assert(stack.Status, gc.Equals, StatusCreateComplete)
+	c.Assert(stack.StatusReason, gc.Equals, "Stack CREATE completed successfully")
+	_, err = s.service.addStack(Stack{Id: "2", Name: "web"}, hotTemplate)
+	c.Assert(err, gc.ErrorMatches, `StackExists: The Stack \(web\) already exists.`)
+	err = s.service.removeStack("1")
+	c.Assert(err, gc.IsNil)
+	_, err = s.service.stack("1", "")
+	c.Assert(err, gc.ErrorMatches, `EntityNotFound: The Stack \(1\) could not be found.`)
+	err = s.service.removeStack("1")
+	c.Assert(err, gc.ErrorMatches, `EntityNotFound: The Stack \(1\) could not be found.`)
+}
+
+func (s *HeatSuite) TestAddStackInvalidName(c *gc.C) {
+	_, err := s.service.addStack(Stack{Id: "1", Name: "1st stack"}, hotTemplate)
+	c.Assert(err, gc.ErrorMatches, `StackValidationFailed: Invalid stack name 1st stack must contain .*`)
+}
+
+func (s *HeatSuite) TestAllStacksSorted(c *gc.C) {
+	s.addStack(c, "1", "web")
+	s.addStack(c, "2", "db")
+	s.addStack(c, "3", "cache")
+	stacks := s.service.allStacks("")
+	c.Assert(stacks, gc.HasLen, 3)
+	for i, stack := range stacks {
+		c.Assert(stack.Name, gc.Equals, []string{"cache", "db", "web"}[i])
+	}
+}
+
+func (s *HeatSuite) TestActionDuration(c *gc.C) {
+	clk := clock.NewManualClock(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	s.service.Clock = clk
+	s.service.ActionDuration = time.Minute
+	added := s.addStack(c, "1", "web")
+	c.Assert(added.CreationTime, gc.Equals, "2016-01-01T00:00:00Z")
+	clk.Advance(30 * time.Second)
+	stack, err := s.service.stack("1", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Status, gc.Equals, StatusCreateInProgress)
+	c.Assert(stack.StatusReason, gc.Equals, "Stack CREATE started")
+	err = s.service.updateStack("1", hotTemplate, nil)
+	c.Assert(err, gc.ErrorMatches, `ActionInProgress: Stack web already has an action \(CREATE\) in progress.`)
+
+	clk.Advance(30 * time.Second)
+	stack, err = s.service.stack("1", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Status, gc.Equals, StatusCreateComplete)
+
+	err = s.service.updateStack("1", hotTemplate, map[string]string{"flavor": "m1.small"})
+	c.Assert(err, gc.IsNil)
+	stack, err = s.service.stack("1", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Status, gc.Equals, StatusUpdateInProgress)
+	c.Assert(stack.UpdatedTime, gc.Equals, "2016-01-01T00:01:00Z")
+	c.Assert(stack.Parameters, gc.DeepEquals, map[string]string{"flavor": "m1.small"})
+	clk.Advance(time.Minute)
+	stack, err = s.service.stack("1", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Status, gc.Equals, StatusUpdateComplete)
+}
+
+func (s *HeatSuite) TestSetStackStatus(c *gc.C) {
+	s.service.ActionDuration = time.Hour
+	s.addStack(c, "1", "web")
+	err := s.service.SetStackStatus("1", StatusCreateFailed, "Resource CREATE failed: quota exceeded")
+	c.Assert(err, gc.IsNil)
+	stack, err := s.service.stack("1", "")
+	c.Assert(err, gc.IsNil)
+	c.Assert(stack.Status, gc.Equals, StatusCreateFailed)
+	c.Assert(stack.StatusReason, gc.Equals, "Resource CREATE failed: quota exceeded")
+	// Failed stacks may be updated.
+	err = s.service.updateStack("1", hotTemplate, nil)
+	c.Assert(err, gc.IsNil)
+	err = s.service.SetStackStatus("2", StatusCreateFailed, "")
+	c.Assert(err, gc.ErrorMatches, `EntityNotFound: The Stack \(2\) could not be found.`)
+}
+
+func (s *HeatSuite) TestParseTemplate(c *gc.C) {
+	for i, t := range []struct {
+		template    string
+		version     string
+		description string
+		err         string
+	}{
+		{hotTemplate, "2016-10-14", "A test stack", ""},
+		{"# comment\n---\nheat_template_version: '2015-04-30' # Kilo\ndescription: >\n  folded\n", "2015-04-30", "", ""},
+		{`{"AWSTemplateFormatVersion": "2010-09-09", "Description": "CFN", "Resources": {}}`, "2010-09-09", "CFN", ""},
+		{"heat_template_version: 2016-10-14\nresources:\n\tserver:\n", "", "", `Error parsing template: found character '\\t' that cannot start any token \(line 3\)`},
+		{"heat_template_version: 2016-10-14\nnot a key\n", "", "", `Error parsing template: could not find expected ':' \(line 2\)`},
+		// Templates which are not JSON may be YAML flow mappings.
+		{`{"heat_template_version": "2016-10-14",}`, "2016-10-14", "", ""},
+		{"{heat_template_version: 2015-04-30, description: 'Flow, style',\n resources: {server: {type: 'OS::Nova::Server'}}}", "2015-04-30", "Flow, style", ""},
+		{`{"heat_template_version": "2016-10-14"`, "", "", `Error parsing template: did not find expected ',' or '}'`},
+		{`{"resources": {}`, "", "", `Error parsing template: did not find expected ',' or '}'`},
+		{`{heat_template_version}`, "", "", `Error parsing template: could not find expected ':'`},
+		{"description: no version\n", "", "", "Template format version not found."},
+		{`{}`, "", "", "The template is not a JSON object or YAML mapping."},
+		{"", "", "", "The template is not a JSON object or YAML mapping."},
+	} {
+		c.Logf("test %d: %q", i, t.template)
+		info, err := parseTemplate(t.template)
+		if t.err != "" {
+			c.Check(err, gc.ErrorMatches, "StackValidationFailed: "+t.err)
+			continue
+		}
+		c.Assert(err, gc.IsNil)
+		c.Check(info.version, gc.Equals, t.version)
+		c.Check(info.description, gc.Equals, t.description)
+	}
+}
+
+func (s *HeatSuite) TestReset(c *gc.C) {
+	s.service.ActionDuration = time.Minute
+	s.addStack(c, "1", "web")
+	s.service.Reset()
+	c.Assert(s.service.allStacks(""), gc.HasLen, 0)
+	c.Assert(s.service.actionStarted, gc.HasLen, 0)
+	c.Assert(s.service.ActionDuration, gc.Equals, time.Minute)
+	s.addStack(c, "1", "web")
+}
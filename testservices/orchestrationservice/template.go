@@ -0,0 +1,184 @@
+// Heat double testing service - template validation
+
+package orchestrationservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The keys which give the format version of HOT and CFN templates. A
+// template must have one of them.
+var versionKeys = []string{
+	"heat_template_version",
+	"HeatTemplateFormatVersion",
+	"AWSTemplateFormatVersion",
+}
+
+// templateInfo holds the parts of a template the double reports.
+type templateInfo struct {
+	version     string
+	description string
+}
+
+// parseTemplate checks that a template, in JSON or YAML, is a
+// mapping which gives its format version, as Heat does before
+// creating or updating a stack. The double has no template engine,
+// so only the top level of YAML templates is checked, and resources
+// are not validated.
+func parseTemplate(template string) (*templateInfo, error) {
+	var top map[string]string
+	var err error
+	if strings.HasPrefix(strings.TrimSpace(template), "{") {
+		// A template which is not valid JSON may still be a YAML
+		// flow mapping, which Heat accepts too.
+		if top, err = parseJSONTemplate(template); err != nil {
+			top, err = parseYAMLFlowMapping(template)
+		}
+	} else {
+		top, err = parseYAMLTemplate(template)
+	}
+	if err != nil {
+		return nil, errInvalidTemplate(fmt.Sprintf("Error parsing template: %v", err))
+	}
+	if len(top) == 0 {
+		return nil, errInvalidTemplate("The template is not a JSON object or YAML mapping.")
+	}
+	info := &templateInfo{}
+	for _, key := range versionKeys {
+		if version, ok := top[key]; ok {
+			info.version = version
+			break
+		}
+	}
+	if info.version == "" {
+		return nil, errInvalidTemplate("Template format version not found.")
+	}
+	info.description = top["description"]
+	if info.description == "" {
+		info.description = top["Description"]
+	}
+	return info, nil
+}
+
+// parseJSONTemplate returns the top level keys of a JSON template,
+// mapped to their values if they are strings.
+func parseJSONTemplate(template string) (map[string]string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(template), &fields); err != nil {
+		return nil, err
+	}
+	top := make(map[string]string)
+	for key, value := range fields {
+		switch value := value.(type) {
+		case string:
+			top[key] = value
+		case float64:
+			top[key] = fmt.Sprint(value)
+		default:
+			top[key] = ""
+		}
+	}
+	return top, nil
+}
+
+// parseYAMLFlowMapping returns the top level keys of a YAML template
+// written as a flow mapping, such as "{heat_template_version:
+// 2016-10-14}", mapped to their values if they are scalars.
+func parseYAMLFlowMapping(template string) (map[string]string, error) {
+	body := strings.TrimSpace(template)
+	var entries []string
+	ok := strings.HasSuffix(body, "}")
+	if ok {
+		entries, ok = splitFlowEntries(body[1 : len(body)-1])
+	}
+	if !ok {
+		return nil, fmt.Errorf("did not find expected ',' or '}'")
+	}
+	top := make(map[string]string)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		colon := strings.Index(entry, ":")
+		if colon <= 0 {
+			return nil, fmt.Errorf("could not find expected ':'")
+		}
+		key := strings.Trim(strings.TrimSpace(entry[:colon]), `"'`)
+		value := strings.TrimSpace(entry[colon+1:])
+		if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
+			value = ""
+		}
+		top[key] = strings.Trim(value, `"'`)
+	}
+	return top, nil
+}
+
+// splitFlowEntries splits the body of a YAML flow mapping at the
+// commas which separate its entries, ignoring those in nested
+// collections and quoted strings. It reports whether the collections
+// and strings in the body are all closed.
+func splitFlowEntries(body string) ([]string, bool) {
+	var entries []string
+	var quote rune
+	depth, start := 0, 0
+	for i, c := range body {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth < 0 {
+				return nil, false
+			}
+		case c == ',' && depth == 0:
+			entries = append(entries, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(entries, body[start:]), depth == 0 && quote == 0
+}
+
+// parseYAMLTemplate returns the top level keys of a YAML template,
+// mapped to their values if they are scalars given on the same line.
+// It rejects the mistakes most often made in hand written templates:
+// tabs in indentation and top level lines which are not keys.
+func parseYAMLTemplate(template string) (map[string]string, error) {
+	top := make(map[string]string)
+	for i, line := range strings.Split(template, "\n") {
+		line = strings.TrimRight(line, "\r")
+		content := strings.TrimLeft(line, " \t")
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		if strings.ContainsRune(line[:len(line)-len(content)], '\t') {
+			return nil, fmt.Errorf("found character '\\t' that cannot start any token (line %d)", i+1)
+		}
+		if content != line || content == "---" || content == "..." {
+			// Nested content, or a document marker.
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon <= 0 || colon+1 < len(line) && line[colon+1] != ' ' {
+			return nil, fmt.Errorf("could not find expected ':' (line %d)", i+1)
+		}
+		key := strings.Trim(line[:colon], `"'`)
+		value := strings.TrimSpace(line[colon+1:])
+		if comment := strings.Index(value, " #"); comment >= 0 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			value = ""
+		}
+		top[key] = strings.Trim(value, `"'`)
+	}
+	return top, nil
+}
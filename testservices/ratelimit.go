@@ -0,0 +1,98 @@
+package testservices
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// rateLimit describes a number of requests which will be rejected
+// as over the rate limit.
+type rateLimit struct {
+	pattern    *regexp.Regexp
+	remaining  int
+	retryAfter time.Duration
+}
+
+// RateLimit declares that the next count requests whose URL path
+// matches the regular expression pattern are rejected with a 429
+// response, asking the client to retry after the given duration.
+// Once count requests have been rejected, matching requests are
+// processed as usual again. The returned function removes the
+// limit, whether or not it has been used up.
+func (s *ServiceInstance) RateLimit(pattern string, count int, retryAfter time.Duration) func() {
+	limit := &rateLimit{
+		pattern:    regexp.MustCompile(pattern),
+		remaining:  count,
+		retryAfter: retryAfter,
+	}
+	s.rateLimitMu.Lock()
+	defer s.rateLimitMu.Unlock()
+	s.rateLimits = append(s.rateLimits, limit)
+	return func() {
+		s.rateLimitMu.Lock()
+		defer s.rateLimitMu.Unlock()
+		limit.remaining = 0
+	}
+}
+
+// CheckRateLimit returns an error if the request matches a limit
+// declared with RateLimit which has not been used up, counting the
+// request against the limit. The returned error is an http.Handler
+// which serves a Nova style 429 response with a Retry-After header.
+func (s *ServiceInstance) CheckRateLimit(r *http.Request) error {
+	s.rateLimitMu.Lock()
+	defer s.rateLimitMu.Unlock()
+	var err error
+	limits := s.rateLimits[:0]
+	for _, limit := range s.rateLimits {
+		if err == nil && limit.remaining > 0 && limit.pattern.MatchString(r.URL.Path) {
+			limit.remaining--
+			err = &rateLimitError{limit.retryAfter}
+		}
+		if limit.remaining > 0 {
+			limits = append(limits, limit)
+		}
+	}
+	s.rateLimits = limits
+	return err
+}
+
+// rateLimitError is returned by CheckRateLimit when a request is
+// over the rate limit.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+// seconds returns the Retry-After value. Real services only use whole
+// seconds, but tests should not have to wait that long.
+func (e *rateLimitError) seconds() string {
+	return strconv.FormatFloat(e.retryAfter.Seconds(), 'f', -1, 64)
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("This request was rate-limited, retry after %ss.", e.seconds())
+}
+
+func (e *rateLimitError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var resp struct {
+		OverLimit struct {
+			Message    string `json:"message"`
+			Code       int    `json:"code"`
+			RetryAfter string `json:"retryAfter"`
+		} `json:"overLimit"`
+	}
+	resp.OverLimit.Message = "This request was rate-limited."
+	resp.OverLimit.Code = http.StatusTooManyRequests
+	resp.OverLimit.RetryAfter = e.seconds()
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", e.seconds())
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
@@ -0,0 +1,271 @@
+package testservices
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the values of headers and body fields which a
+// Recorder has been told to redact.
+const Redacted = "REDACTED"
+
+// DefaultRedactedHeaders holds the headers redacted by a new Recorder,
+// which carry credentials.
+var DefaultRedactedHeaders = []string{"X-Auth-Token", "X-Subject-Token", "X-Auth-Key", "Authorization"}
+
+// DefaultRedactedFields holds the JSON body fields redacted by a new
+// Recorder, which carry credentials.
+var DefaultRedactedFields = []string{"password", "secretKey", "apiKey"}
+
+// A RecordedRequest holds a request received by a Recorder.
+type RecordedRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// NewHTTPRequest returns a new request with the same method, URL,
+// headers and body as r. Redacted values are sent as recorded, so
+// requests carrying credentials must have them replaced before they
+// are replayed.
+func (r *RecordedRequest) NewHTTPRequest() (*http.Request, error) {
+	req, err := http.NewRequest(r.Method, r.URL, bytes.NewReader(r.Body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range r.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// A RecordedResponse holds the response to a request received by a
+// Recorder.
+type RecordedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// An Exchange holds a request received by a Recorder and the response
+// it produced. The response of a request whose connection was
+// hijacked, for instance to reset it, has a zero StatusCode.
+type Exchange struct {
+	Request  RecordedRequest
+	Response RecordedResponse
+}
+
+// Recorder is an http.Handler which records each request passed to
+// the handler it wraps, along with the response, so that tests can
+// see exactly what a client sent. Like a FaultInjector, it may wrap
+// the mux shared by the service doubles.
+type Recorder struct {
+	// RedactHeaders holds the names of the request and response
+	// headers whose values are replaced by Redacted in the record.
+	// It must not be changed while requests are being served.
+	RedactHeaders []string
+	// RedactFields holds the names of the fields, at any depth, of
+	// JSON request and response bodies whose values are replaced by
+	// Redacted in the record. Names are matched case-insensitively.
+	// It must not be changed while requests are being served.
+	RedactFields []string
+
+	handler http.Handler
+
+	mu        sync.Mutex // protects exchanges
+	exchanges []Exchange
+}
+
+// NewRecorder returns a Recorder which passes requests on to handler,
+// redacting DefaultRedactedHeaders and DefaultRedactedFields.
+func NewRecorder(handler http.Handler) *Recorder {
+	return &Recorder{
+		RedactHeaders: append([]string(nil), DefaultRedactedHeaders...),
+		RedactFields:  append([]string(nil), DefaultRedactedFields...),
+		handler:       handler,
+	}
+}
+
+// Exchanges returns the requests recorded so far, in the order their
+// responses were completed.
+func (rec *Recorder) Exchanges() []Exchange {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	exchanges := make([]Exchange, len(rec.exchanges))
+	copy(exchanges, rec.exchanges)
+	return exchanges
+}
+
+// Reset discards the requests recorded so far.
+func (rec *Recorder) Reset() {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.exchanges = nil
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "cannot read request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	url := *r.URL
+	if url.Host == "" {
+		url.Host = r.Host
+	}
+	if url.Scheme == "" {
+		url.Scheme = "http"
+		if r.TLS != nil {
+			url.Scheme = "https"
+		}
+	}
+	exchange := Exchange{
+		Request: RecordedRequest{
+			Method: r.Method,
+			URL:    url.String(),
+			Header: rec.redactHeader(r.Header),
+			Body:   rec.redactBody(body),
+		},
+	}
+	rw := &recordingResponseWriter{ResponseWriter: w}
+	rec.handler.ServeHTTP(rw, r)
+	if rw.header == nil && !rw.hijacked {
+		// The server sends an empty 200 response.
+		rw.snapshot(http.StatusOK)
+	}
+	exchange.Response = RecordedResponse{
+		StatusCode: rw.code,
+		Header:     rec.redactHeader(rw.header),
+		Body:       rec.redactBody(rw.body.Bytes()),
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.exchanges = append(rec.exchanges, exchange)
+}
+
+// redactHeader returns a copy of header with the values of
+// RedactHeaders redacted.
+func (rec *Recorder) redactHeader(header http.Header) http.Header {
+	result := make(http.Header, len(header))
+	for name, values := range header {
+		result[name] = append([]string(nil), values...)
+	}
+	for _, name := range rec.RedactHeaders {
+		values := result[http.CanonicalHeaderKey(name)]
+		for i := range values {
+			values[i] = Redacted
+		}
+	}
+	return result
+}
+
+// redactBody returns body with the values of RedactFields redacted,
+// if it is a JSON document containing any of them. Other bodies are
+// returned unchanged.
+func (rec *Recorder) redactBody(body []byte) []byte {
+	if len(body) == 0 || len(rec.RedactFields) == 0 {
+		return body
+	}
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body
+	}
+	if !rec.redactValue(doc) {
+		return body
+	}
+	redacted, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+// redactValue redacts the fields of v, a decoded JSON value, in place,
+// and reports whether any were found.
+func (rec *Recorder) redactValue(v interface{}) bool {
+	found := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if rec.isRedactedField(key) {
+				v[key] = Redacted
+				found = true
+			} else if rec.redactValue(value) {
+				found = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if rec.redactValue(value) {
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+func (rec *Recorder) isRedactedField(key string) bool {
+	for _, name := range rec.RedactFields {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingResponseWriter keeps a copy of the response written to it.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	code     int
+	header   http.Header
+	body     bytes.Buffer
+	hijacked bool
+}
+
+// snapshot records the status and headers of the response, which are
+// fixed once they have been written.
+func (w *recordingResponseWriter) snapshot(code int) {
+	w.code = code
+	w.header = make(http.Header)
+	for name, values := range w.ResponseWriter.Header() {
+		w.header[name] = append([]string(nil), values...)
+	}
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	if w.header == nil {
+		w.snapshot(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	if w.header == nil {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// Hijack allows handlers which reset connections, such as a
+// FaultInjector, to be wrapped.
+func (w *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.hijacked = true
+	return hijacker.Hijack()
+}
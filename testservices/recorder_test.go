@@ -0,0 +1,110 @@
+package testservices
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+)
+
+type RecorderSuite struct {
+	server   *httptest.Server
+	recorder *Recorder
+}
+
+var _ = gc.Suite(&RecorderSuite{})
+
+func (s *RecorderSuite) SetUpTest(c *gc.C) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tokens", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		c.Check(err, gc.IsNil)
+		c.Check(string(body), gc.Matches, `.*"password":"secret".*`)
+		w.Header().Set("X-Subject-Token", "token")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"token":{"id":"token","user":{"name":"fred"}}}`))
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {})
+	s.recorder = NewRecorder(NewFaultInjector(mux))
+	s.server = httptest.NewServer(s.recorder)
+}
+
+func (s *RecorderSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+}
+
+func (s *RecorderSuite) TestRecordsExchanges(c *gc.C) {
+	req, err := http.NewRequest("POST", s.server.URL+"/tokens?x=1",
+		strings.NewReader(`{"auth":{"passwordCredentials":{"username":"fred","password":"secret"}}}`))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("X-Auth-Token", "old-token")
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	resp, err = http.Get(s.server.URL + "/empty")
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+
+	exchanges := s.recorder.Exchanges()
+	c.Assert(exchanges, gc.HasLen, 2)
+	request := exchanges[0].Request
+	c.Check(request.Method, gc.Equals, "POST")
+	c.Check(request.URL, gc.Equals, s.server.URL+"/tokens?x=1")
+	c.Check(request.Header.Get("X-Auth-Token"), gc.Equals, Redacted)
+	c.Check(request.Header.Get("Content-Type"), gc.Equals, "application/json")
+	c.Check(string(request.Body), gc.Equals,
+		`{"auth":{"passwordCredentials":{"password":"REDACTED","username":"fred"}}}`)
+	response := exchanges[0].Response
+	c.Check(response.StatusCode, gc.Equals, http.StatusCreated)
+	c.Check(response.Header.Get("X-Subject-Token"), gc.Equals, Redacted)
+	c.Check(string(response.Body), gc.Equals, `{"token":{"id":"token","user":{"name":"fred"}}}`)
+
+	c.Check(exchanges[1].Request.Method, gc.Equals, "GET")
+	c.Check(exchanges[1].Response.StatusCode, gc.Equals, http.StatusOK)
+	c.Check(exchanges[1].Response.Body, gc.HasLen, 0)
+
+	s.recorder.Reset()
+	c.Assert(s.recorder.Exchanges(), gc.HasLen, 0)
+}
+
+func (s *RecorderSuite) TestConfigureRedaction(c *gc.C) {
+	s.recorder.RedactHeaders = nil
+	s.recorder.RedactFields = []string{"ID"}
+	req, err := http.NewRequest("POST", s.server.URL+"/tokens",
+		strings.NewReader(`{"password":"secret"}`))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("X-Auth-Token", "old-token")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+
+	exchanges := s.recorder.Exchanges()
+	c.Assert(exchanges, gc.HasLen, 1)
+	c.Check(exchanges[0].Request.Header.Get("X-Auth-Token"), gc.Equals, "old-token")
+	c.Check(string(exchanges[0].Request.Body), gc.Equals, `{"password":"secret"}`)
+	c.Check(string(exchanges[0].Response.Body), gc.Equals,
+		`{"token":{"id":"REDACTED","user":{"name":"fred"}}}`)
+}
+
+func (s *RecorderSuite) TestReplay(c *gc.C) {
+	s.recorder.RedactFields = nil
+	resp, err := http.Post(s.server.URL+"/tokens", "application/json",
+		strings.NewReader(`{"password":"secret"}`))
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	exchanges := s.recorder.Exchanges()
+	c.Assert(exchanges, gc.HasLen, 1)
+	req, err := exchanges[0].Request.NewHTTPRequest()
+	c.Assert(err, gc.IsNil)
+	resp, err = http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusCreated)
+	exchanges = s.recorder.Exchanges()
+	c.Assert(exchanges, gc.HasLen, 2)
+	c.Assert(exchanges[1].Request.Body, gc.DeepEquals, exchanges[0].Request.Body)
+}
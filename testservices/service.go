@@ -1,7 +1,12 @@
 package testservices
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
 	"gopkg.in/goose.v1/testservices/hook"
 	"gopkg.in/goose.v1/testservices/identityservice"
@@ -22,6 +27,143 @@ type ServiceInstance struct {
 	VersionPath     string
 	TenantId        string
 	Region          string
+	// MaxBodyBytes, if positive, is the size of the largest request
+	// body the service accepts. See LimitRequestBody.
+	MaxBodyBytes int64
+	// IDFactory, if set, makes the ids of the resources the service
+	// creates, given the type of each, such as ResourceServer, so
+	// that tests can predict them. See NewID and NewSequentialID.
+	IDFactory func(resourceType string) string
+
+	roleMu sync.Mutex // protects requiredRoles
+	// requiredRoles maps URL path prefixes to the role a user
+	// must hold to make requests to them.
+	requiredRoles map[string]string
+
+	rateLimitMu sync.Mutex // protects rateLimits
+	rateLimits  []*rateLimit
+
+	corsMu     sync.Mutex // protects corsPolicy
+	corsPolicy *CORSPolicy
+
+	expiryMu       sync.Mutex // protects expiringTokens
+	expiringTokens map[string]bool
+}
+
+// Reset removes everything set with the service's methods: control
+// hooks, required roles, rate limits, response delays, tokens due to
+// expire, response headers and the CORS policy. The exported fields,
+// which configure the service, are preserved. The doubles' own Reset
+// methods call it as well as discarding their resources.
+func (s *ServiceInstance) Reset() {
+	s.ControlHooks = nil
+	s.roleMu.Lock()
+	s.requiredRoles = nil
+	s.roleMu.Unlock()
+	s.corsMu.Lock()
+	s.corsPolicy = nil
+	s.corsMu.Unlock()
+	s.rateLimitMu.Lock()
+	s.rateLimits = nil
+	s.rateLimitMu.Unlock()
+	s.ClearResponseDelays()
+	s.expiryMu.Lock()
+	s.expiringTokens = nil
+	s.expiryMu.Unlock()
+	s.ClearResponseHeaders()
+}
+
+// RequireRole declares that requests whose URL path starts with
+// pathPrefix may only be made by users holding the named role.
+// Requests from other users are rejected with a 403. If role is
+// empty, any existing requirement for pathPrefix is removed.
+// The returned function removes the requirement.
+func (s *ServiceInstance) RequireRole(pathPrefix, role string) func() {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	if s.requiredRoles == nil {
+		s.requiredRoles = make(map[string]string)
+	}
+	if role == "" {
+		delete(s.requiredRoles, pathPrefix)
+	} else {
+		s.requiredRoles[pathPrefix] = role
+	}
+	return func() {
+		s.RequireRole(pathPrefix, "")
+	}
+}
+
+// CheckRole returns an error if the request is for a path which
+// requires a role that the user does not hold. A nil userInfo holds
+// no roles. The returned error is an http.Handler which serves a
+// Keystone style 403 response.
+func (s *ServiceInstance) CheckRole(r *http.Request, userInfo *identityservice.UserInfo) error {
+	s.roleMu.Lock()
+	defer s.roleMu.Unlock()
+	for prefix, role := range s.requiredRoles {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			continue
+		}
+		if userInfo == nil || !userInfo.HasRole(role) {
+			return &roleError{role}
+		}
+	}
+	return nil
+}
+
+// TenantScope returns the id of the tenant whose resources may be
+// seen by a request made with the given user's token, so that the
+// doubles isolate tenants as OpenStack does: the tenant the token is
+// scoped to, as reported by the identity service's FindUser, unless
+// the user is an admin who asks for the resources of all tenants with
+// the all_tenants query parameter, in which case it returns "". As in
+// Nova, the parameter may be given without a value, and it is ignored
+// for other users. An invalid value, or an unscoped token, is an
+// error.
+func TenantScope(r *http.Request, user *identityservice.UserInfo) (string, error) {
+	values, ok := r.URL.Query()["all_tenants"]
+	allTenants := false
+	if ok {
+		value := values[0]
+		var err error
+		if allTenants, err = strconv.ParseBool(value); value == "" {
+			allTenants = true
+		} else if err != nil {
+			return "", fmt.Errorf("Invalid value '%s' for all_tenants", value)
+		}
+	}
+	if allTenants && user.HasRole("admin") {
+		return "", nil
+	}
+	if user.TenantId == "" {
+		return "", fmt.Errorf("Token is not scoped to a tenant")
+	}
+	return user.TenantId, nil
+}
+
+// roleError is returned by CheckRole when a user lacks a required role.
+type roleError struct {
+	role string
+}
+
+func (e *roleError) Error() string {
+	return fmt.Sprintf("You are not authorized to perform the requested action: requires role %q.", e.role)
+}
+
+func (e *roleError) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := json.Marshal(identityservice.ErrorWrapper{
+		Error: identityservice.ErrorResponse{
+			Message: e.Error(),
+			Code:    http.StatusForbidden,
+			Title:   http.StatusText(http.StatusForbidden),
+		},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusForbidden)
+	w.Write(body)
 }
 
 // Internal Openstack errors.
@@ -0,0 +1,291 @@
+package testservices
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/testservices/hook"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+type ServiceSuite struct{}
+
+var _ = gc.Suite(&ServiceSuite{})
+
+func (s *ServiceSuite) TestCheckRole(c *gc.C) {
+	var service ServiceInstance
+	member := &identityservice.UserInfo{}
+	admin := &identityservice.UserInfo{Roles: []identityservice.RoleResponse{{Id: "1", Name: "admin"}}}
+	req, err := http.NewRequest("GET", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(service.CheckRole(req, member), gc.IsNil)
+	cleanup := service.RequireRole("/v2/tenant/flavors", "admin")
+	c.Assert(service.CheckRole(req, member), gc.NotNil)
+	c.Assert(service.CheckRole(req, nil), gc.NotNil)
+	c.Assert(service.CheckRole(req, admin), gc.IsNil)
+	// Roles granted in other tenants are not held.
+	otherAdmin := &identityservice.UserInfo{
+		TenantId: "1",
+		Roles:    []identityservice.RoleResponse{{Id: "1", Name: "admin", TenantId: "2"}},
+	}
+	c.Assert(service.CheckRole(req, otherAdmin), gc.NotNil)
+	cleanup()
+	c.Assert(service.CheckRole(req, member), gc.IsNil)
+}
+
+func (s *ServiceSuite) TestRoleErrorResponse(c *gc.C) {
+	var service ServiceInstance
+	service.RequireRole("/", "admin")
+	req, err := http.NewRequest("GET", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+	roleErr := service.CheckRole(req, nil)
+	c.Assert(roleErr, gc.ErrorMatches, `You are not authorized to perform the requested action: requires role "admin".`)
+
+	w := httptest.NewRecorder()
+	roleErr.(http.Handler).ServeHTTP(w, req)
+	c.Assert(w.Code, gc.Equals, http.StatusForbidden)
+	c.Assert(w.Header().Get("Content-Type"), gc.Equals, "application/json")
+	var body identityservice.ErrorWrapper
+	err = json.Unmarshal(w.Body.Bytes(), &body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(body.Error, gc.DeepEquals, identityservice.ErrorResponse{
+		Message: roleErr.Error(),
+		Code:    http.StatusForbidden,
+		Title:   "Forbidden",
+	})
+}
+
+func (s *ServiceSuite) TestConcurrentRolesAndCORSPolicy(c *gc.C) {
+	var service ServiceInstance
+	req, err := http.NewRequest("OPTIONS", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Origin", "http://app.example.com")
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			service.RequireRole("/v2/tenant/flavors", "admin")
+			service.SetCORSPolicy(&CORSPolicy{AllowedOrigins: []string{"*"}})
+			service.Reset()
+		}()
+		go func() {
+			defer wg.Done()
+			service.CheckRole(req, nil)
+			service.HandleOptions(httptest.NewRecorder(), req, "GET")
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *ServiceSuite) TestTenantScope(c *gc.C) {
+	member := &identityservice.UserInfo{TenantId: "tenant"}
+	admin := &identityservice.UserInfo{
+		TenantId: "admin-tenant",
+		Roles:    []identityservice.RoleResponse{{Id: "1", Name: "admin"}},
+	}
+	for i, t := range []struct {
+		user   *identityservice.UserInfo
+		query  string
+		scope  string
+		errMsg string
+	}{
+		{member, "", "tenant", ""},
+		{member, "?all_tenants=1", "tenant", ""},
+		{admin, "", "admin-tenant", ""},
+		{admin, "?all_tenants=1", "", ""},
+		{admin, "?all_tenants", "", ""},
+		{admin, "?all_tenants=false", "admin-tenant", ""},
+		{admin, "?all_tenants=maybe", "", "Invalid value 'maybe' for all_tenants"},
+	} {
+		c.Logf("test %d: %q", i, t.query)
+		req, err := http.NewRequest("GET", "http://example.com/v2/tenant/servers"+t.query, nil)
+		c.Assert(err, gc.IsNil)
+		scope, err := TenantScope(req, t.user)
+		if t.errMsg != "" {
+			c.Check(err, gc.ErrorMatches, t.errMsg)
+			continue
+		}
+		c.Check(err, gc.IsNil)
+		c.Check(scope, gc.Equals, t.scope)
+	}
+}
+
+func (s *ServiceSuite) TestCheckRateLimit(c *gc.C) {
+	var service ServiceInstance
+	servers, err := http.NewRequest("GET", "http://example.com/v2/tenant/servers", nil)
+	c.Assert(err, gc.IsNil)
+	flavors, err := http.NewRequest("GET", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+
+	c.Assert(service.CheckRateLimit(servers), gc.IsNil)
+	service.RateLimit("/servers$", 2, time.Second)
+	c.Assert(service.CheckRateLimit(flavors), gc.IsNil)
+	c.Assert(service.CheckRateLimit(servers), gc.NotNil)
+	c.Assert(service.CheckRateLimit(servers), gc.NotNil)
+	c.Assert(service.CheckRateLimit(servers), gc.IsNil)
+
+	cleanup := service.RateLimit("/tenant/", 5, time.Second)
+	c.Assert(service.CheckRateLimit(flavors), gc.NotNil)
+	cleanup()
+	c.Assert(service.CheckRateLimit(flavors), gc.IsNil)
+}
+
+func (s *ServiceSuite) TestRateLimitErrorResponse(c *gc.C) {
+	var service ServiceInstance
+	service.RateLimit("/", 1, 1500*time.Millisecond)
+	req, err := http.NewRequest("GET", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+	limitErr := service.CheckRateLimit(req)
+	c.Assert(limitErr, gc.ErrorMatches, `This request was rate-limited, retry after 1.5s.`)
+
+	w := httptest.NewRecorder()
+	limitErr.(http.Handler).ServeHTTP(w, req)
+	c.Assert(w.Code, gc.Equals, http.StatusTooManyRequests)
+	c.Assert(w.Header().Get("Content-Type"), gc.Equals, "application/json")
+	c.Assert(w.Header().Get("Retry-After"), gc.Equals, "1.5")
+	var body struct {
+		OverLimit struct {
+			Message    string
+			Code       int
+			RetryAfter string
+		}
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(body.OverLimit.Message, gc.Equals, "This request was rate-limited.")
+	c.Assert(body.OverLimit.Code, gc.Equals, http.StatusTooManyRequests)
+	c.Assert(body.OverLimit.RetryAfter, gc.Equals, "1.5")
+}
+
+func (s *ServiceSuite) TestHandleOptionsWithoutCORS(c *gc.C) {
+	var service ServiceInstance
+	req, err := http.NewRequest("OPTIONS", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Origin", "http://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	w := httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "POST"), gc.Equals, true)
+	c.Assert(w.Code, gc.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Allow"), gc.Equals, "GET, POST, OPTIONS")
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "")
+	c.Assert(w.Header().Get("Access-Control-Allow-Methods"), gc.Equals, "")
+
+	req.Method = "GET"
+	w = httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "POST"), gc.Equals, false)
+	c.Assert(w.Header(), gc.HasLen, 0)
+}
+
+func (s *ServiceSuite) TestHandleOptionsPreflight(c *gc.C) {
+	var service ServiceInstance
+	service.SetCORSPolicy(&CORSPolicy{
+		AllowedOrigins: []string{"http://app.example.com"},
+		MaxAge:         time.Hour,
+	})
+	req, err := http.NewRequest("OPTIONS", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Origin", "http://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "X-Auth-Token, Content-Type")
+	w := httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "POST"), gc.Equals, true)
+	c.Assert(w.Code, gc.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Allow"), gc.Equals, "GET, POST, OPTIONS")
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "http://app.example.com")
+	c.Assert(w.Header().Get("Access-Control-Allow-Methods"), gc.Equals, "GET, POST")
+	c.Assert(w.Header().Get("Access-Control-Allow-Headers"), gc.Equals, "X-Auth-Token, Content-Type")
+	c.Assert(w.Header().Get("Access-Control-Max-Age"), gc.Equals, "3600")
+
+	// Other origins are not allowed.
+	req.Header.Set("Origin", "http://other.example.com")
+	w = httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "POST"), gc.Equals, true)
+	c.Assert(w.Code, gc.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "")
+	c.Assert(w.Header().Get("Access-Control-Allow-Methods"), gc.Equals, "")
+}
+
+func (s *ServiceSuite) TestHandleOptionsExplicitPolicy(c *gc.C) {
+	var service ServiceInstance
+	service.SetCORSPolicy(&CORSPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+		AllowedHeaders: []string{"X-Auth-Token"},
+	})
+	req, err := http.NewRequest("OPTIONS", "http://example.com/v1/tenant/container", nil)
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Origin", "http://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Other")
+	w := httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "PUT"), gc.Equals, true)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "http://app.example.com")
+	c.Assert(w.Header().Get("Access-Control-Allow-Methods"), gc.Equals, "GET")
+	c.Assert(w.Header().Get("Access-Control-Allow-Headers"), gc.Equals, "X-Auth-Token")
+	c.Assert(w.Header().Get("Access-Control-Max-Age"), gc.Equals, "")
+
+	// Actual requests from allowed origins are marked as allowed,
+	// but otherwise handled as usual.
+	req.Method = "GET"
+	w = httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "PUT"), gc.Equals, false)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "http://app.example.com")
+	c.Assert(w.Header().Get("Allow"), gc.Equals, "")
+
+	service.SetCORSPolicy(nil)
+	w = httptest.NewRecorder()
+	c.Assert(service.HandleOptions(w, req, "GET", "PUT"), gc.Equals, false)
+	c.Assert(w.Header().Get("Access-Control-Allow-Origin"), gc.Equals, "")
+}
+
+func (s *ServiceSuite) TestTokenExpired(c *gc.C) {
+	identity := identityservice.NewUserPass()
+	userInfo := identity.AddUser("user", "secret", "tenant")
+	service := ServiceInstance{IdentityService: identity}
+	c.Assert(service.TokenExpired(userInfo.Token), gc.Equals, false)
+
+	service.ExpireTokenOnce(userInfo.Token)
+	c.Assert(service.TokenExpired("other-token"), gc.Equals, false)
+	c.Assert(service.TokenExpired(userInfo.Token), gc.Equals, true)
+	c.Assert(service.TokenExpired(userInfo.Token), gc.Equals, false)
+	// The token has been revoked, so it can no longer be used.
+	_, err := identity.FindUser(userInfo.Token)
+	c.Assert(err, gc.NotNil)
+
+	cleanup := service.ExpireTokenOnce("another-token")
+	cleanup()
+	c.Assert(service.TokenExpired("another-token"), gc.Equals, false)
+}
+
+func (s *ServiceSuite) TestReset(c *gc.C) {
+	service := ServiceInstance{TenantId: "tenant", MaxBodyBytes: 10}
+	service.RegisterControlPoint("foo", func(sc hook.ServiceControl, args ...interface{}) error {
+		return nil
+	})
+	service.RequireRole("/", "admin")
+	service.RateLimit("/", 1, time.Second)
+	service.SetResponseDelay(hook.FixedDelay(time.Hour))
+	service.ExpireTokenOnce("token")
+	service.SetResponseHeader("X-Foo", "bar")
+	req, err := http.NewRequest("GET", "http://example.com/v2/tenant/flavors", nil)
+	c.Assert(err, gc.IsNil)
+
+	service.Reset()
+	c.Assert(service.ControlHooks, gc.HasLen, 0)
+	c.Assert(service.CheckRole(req, nil), gc.IsNil)
+	c.Assert(service.CheckRateLimit(req), gc.IsNil)
+	c.Assert(service.DelayResponse(req), gc.IsNil)
+	c.Assert(service.TokenExpired("token"), gc.Equals, false)
+	w := httptest.NewRecorder()
+	service.AddResponseHeaders(w)
+	c.Assert(w.Header(), gc.HasLen, 0)
+	c.Assert(service.TenantId, gc.Equals, "tenant")
+	c.Assert(service.MaxBodyBytes, gc.Equals, int64(10))
+}
@@ -3,9 +3,12 @@
 package swiftservice
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,7 +18,38 @@ import (
 	"gopkg.in/goose.v1/testservices/identityservice"
 )
 
-type object map[string][]byte
+// defaultContentType is the content type of objects added without one.
+const defaultContentType = "application/octet-stream"
+
+// lastModifiedFormat is the format of the last_modified field of
+// container listings.
+const lastModifiedFormat = "2006-01-02T15:04:05.000000"
+
+// ObjectInfo holds the metadata of a stored object.
+type ObjectInfo struct {
+	// ETag holds the hex encoded MD5 checksum of the object's data.
+	ETag         string
+	ContentType  string
+	LengthBytes  int
+	LastModified time.Time
+	// Manifest holds the "<container>/<prefix>" naming the segments
+	// of a dynamic large object, or is empty for an ordinary object.
+	Manifest string
+	// Segments holds the "<container>/<object>" paths of the segments
+	// of a static large object, in order, or is empty for an object
+	// which is not one.
+	Segments []string
+	// Metadata holds the object's user metadata, reported in the
+	// X-Object-Meta-* headers. The keys omit the header prefix.
+	Metadata map[string]string
+}
+
+type storedObject struct {
+	ObjectInfo
+	data []byte
+}
+
+type object map[string]*storedObject
 
 var _ testservices.HttpService = (*Swift)(nil)
 var _ identityservice.ServiceProvider = (*Swift)(nil)
@@ -25,6 +59,13 @@ type Swift struct {
 
 	mu         sync.Mutex // protects the remaining fields
 	containers map[string]object
+	tempURLKey string
+	// accountMetadata holds the account's user metadata, reported in
+	// the X-Account-Meta-* headers. The keys omit the header prefix.
+	accountMetadata map[string]string
+	// versionsLocations maps the names of versioned containers to the
+	// containers holding the prior versions of their objects.
+	versionsLocations map[string]string
 }
 
 // New creates an instance of the Swift object, given the parameters.
@@ -38,7 +79,8 @@ func New(hostURL, versionPath, tenantId, region string, identityService identity
 		hostname += "/"
 	}
 	swift := &Swift{
-		containers: make(map[string]object),
+		containers:        make(map[string]object),
+		versionsLocations: make(map[string]string),
 		ServiceInstance: testservices.ServiceInstance{
 			IdentityService: identityService,
 			Scheme:          URL.Scheme,
@@ -54,6 +96,20 @@ func New(hostURL, versionPath, tenantId, region string, identityService identity
 	return swift
 }
 
+// Reset removes all containers and their objects, along with their
+// versions locations, and the account's metadata, including the temp
+// URL key, along with everything set with the ServiceInstance methods
+// (see ServiceInstance.Reset). The exported fields are preserved.
+func (s *Swift) Reset() {
+	s.ServiceInstance.Reset()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.containers = make(map[string]object)
+	s.versionsLocations = make(map[string]string)
+	s.tempURLKey = ""
+	s.accountMetadata = nil
+}
+
 func (s *Swift) endpointURL(path string) string {
 	ep := s.Scheme + "://" + s.Hostname + s.VersionPath + "/" + s.TenantId
 	if path != "" {
@@ -81,18 +137,144 @@ func (s *Swift) HasContainer(name string) bool {
 }
 
 // GetObject retrieves a given object from its container, returning
-// the object data or an error.
+// the object data or an error. The data of a dynamic or static large
+// object is the concatenation of its segments.
 func (s *Swift) GetObject(container, name string) ([]byte, error) {
 	if err := s.ProcessFunctionHook(s, container, name); err != nil {
 		return nil, err
 	}
+	obj, err := s.object(container, name)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := s.resolve(obj)
+	return data, nil
+}
+
+// GetObjectInfo retrieves the metadata of a given object. The length
+// of a dynamic or static large object is the total length of its
+// segments, and its ETag is the MD5 checksum of the concatenated
+// segment ETags.
+func (s *Swift) GetObjectInfo(container, name string) (*ObjectInfo, error) {
+	if err := s.ProcessFunctionHook(s, container, name); err != nil {
+		return nil, err
+	}
+	obj, err := s.object(container, name)
+	if err != nil {
+		return nil, err
+	}
+	_, info := s.resolve(obj)
+	info.Metadata = copyMetadata(info.Metadata)
+	info.Segments = append([]string(nil), info.Segments...)
+	return &info, nil
+}
+
+// resolve returns the data and metadata of the given object as they
+// are seen by clients. For a dynamic large object, these are derived
+// from the segments, which are the objects in the manifest's container
+// whose names start with its prefix, taken in name order. The data of
+// a static large object is that of the segments listed when it was
+// created, and its ETag is fixed at that time.
+func (s *Swift) resolve(obj *storedObject) ([]byte, ObjectInfo) {
+	info := obj.ObjectInfo
+	if len(info.Segments) > 0 {
+		data := s.staticData(obj, 1)
+		info.LengthBytes = len(data)
+		return data, info
+	}
+	if info.Manifest == "" {
+		return obj.data, info
+	}
+	container, prefix := info.Manifest, ""
+	if i := strings.Index(info.Manifest, "/"); i >= 0 {
+		container, prefix = info.Manifest[:i], info.Manifest[i+1:]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name, segment := range s.containers[container] {
+		// A manifest is never one of its own segments.
+		if segment != obj && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var data []byte
+	etags := md5.New()
+	// Segments contribute their own data, even if they are
+	// themselves manifests.
+	for _, name := range names {
+		segment := s.containers[container][name]
+		data = append(data, segment.data...)
+		etags.Write([]byte(segment.ETag))
+	}
+	info.ETag = hex.EncodeToString(etags.Sum(nil))
+	info.LengthBytes = len(data)
+	return data, info
+}
+
+// maxStaticDepth is how deeply static large objects may be nested, as
+// in Swift's default configuration.
+const maxStaticDepth = 10
+
+// staticData returns the data of a static large object, nested at the
+// given depth, which is the concatenation of the data of its segments.
+// Segments which are themselves static large objects contribute their
+// own segments, down to maxStaticDepth. Segments which have since been
+// removed contribute nothing.
+func (s *Swift) staticData(obj *storedObject, depth int) []byte {
+	var data []byte
+	for _, path := range obj.Segments {
+		container, name := splitSegmentPath(path)
+		segment, err := s.object(container, name)
+		if err != nil {
+			continue
+		}
+		if len(segment.Segments) > 0 && depth < maxStaticDepth {
+			data = append(data, s.staticData(segment, depth+1)...)
+		} else {
+			data = append(data, segment.data...)
+		}
+	}
+	return data
+}
+
+// splitSegmentPath splits the "<container>/<object>" path of a segment
+// of a static large object.
+func splitSegmentPath(path string) (container, name string) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// staticInfo returns the ETag and length of a static large object
+// made up of the given segments, which must exist.
+func (s *Swift) staticInfo(segments []string) (etag string, size int, err error) {
+	etags := md5.New()
+	for _, path := range segments {
+		container, name := splitSegmentPath(path)
+		segment, err := s.object(container, name)
+		if err != nil {
+			return "", 0, err
+		}
+		_, info := s.resolve(segment)
+		etags.Write([]byte(info.ETag))
+		size += info.LengthBytes
+	}
+	return hex.EncodeToString(etags.Sum(nil)), size, nil
+}
+
+// object returns the stored object with the given name.
+func (s *Swift) object(container, name string) (*storedObject, error) {
 	s.mu.Lock()
-	data, ok := s.containers[container][name]
+	obj, ok := s.containers[container][name]
 	s.mu.Unlock()
 	if !ok {
 		return nil, fmt.Errorf("no such object %q in container %q", name, container)
 	}
-	return data, nil
+	return obj, nil
 }
 
 // AddContainer creates a new container with the given name, if it
@@ -110,39 +292,78 @@ func (s *Swift) AddContainer(name string) error {
 	return nil
 }
 
-// ListContainer lists the objects in the given container.
-// params contains filtering attributes: prefix, delimiter, marker.
-// Only prefix is currently supported.
+// ListContainer lists the objects in the given container, sorted by
+// name. params contains filtering attributes, as the query parameters
+// of a container GET request: only names starting with prefix are
+// listed; names containing delimiter after the prefix are collapsed
+// into a single pseudo-directory entry, with only Subdir set, ending at
+// the delimiter; only entries after marker are listed, and at most
+// limit of them.
 func (s *Swift) ListContainer(name string, params map[string]string) ([]swift.ContainerContents, error) {
 	if err := s.ProcessFunctionHook(s, name); err != nil {
 		return nil, err
 	}
+	return s.listContainer(name, params)
+}
+
+// listContainer implements ListContainer, without calling its hook.
+func (s *Swift) listContainer(name string, params map[string]string) ([]swift.ContainerContents, error) {
 	if ok := s.HasContainer(name); !ok {
 		return nil, fmt.Errorf("no such container %q", name)
 	}
+	limit := -1
+	if value := params["limit"]; value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit %q", value)
+		}
+	}
 	s.mu.Lock()
 	items := s.containers[name]
 	s.mu.Unlock()
-	sorted := make([]string, 0, len(items))
 	prefix := params["prefix"]
+	delimiter := params["delimiter"]
+	marker := params["marker"]
+	// entries maps the name of each entry to be listed to whether it
+	// is a pseudo-directory.
+	entries := make(map[string]bool)
 	for filename := range items {
-		if prefix != "" && !strings.HasPrefix(filename, prefix) {
+		if !strings.HasPrefix(filename, prefix) {
+			continue
+		}
+		entry, subdir := filename, false
+		if delimiter != "" {
+			if i := strings.Index(filename[len(prefix):], delimiter); i >= 0 {
+				entry, subdir = filename[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if entry <= marker {
 			continue
 		}
-		sorted = append(sorted, filename)
+		entries[entry] = subdir
+	}
+	sorted := make([]string, 0, len(entries))
+	for entry := range entries {
+		sorted = append(sorted, entry)
 	}
 	sort.Strings(sorted)
+	if limit >= 0 && len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
 	contents := make([]swift.ContainerContents, len(sorted))
-	var i = 0
-	for _, filename := range sorted {
+	for i, entry := range sorted {
+		if entries[entry] {
+			contents[i] = swift.ContainerContents{Subdir: entry}
+			continue
+		}
+		obj := items[entry]
 		contents[i] = swift.ContainerContents{
-			Name:         filename,
-			Hash:         "", // not implemented
-			LengthBytes:  len(items[filename]),
-			ContentType:  "application/octet-stream",
-			LastModified: time.Now().Format("2006-01-02 15:04:05"), //not implemented
+			Name:         entry,
+			Hash:         obj.ETag,
+			LengthBytes:  obj.LengthBytes,
+			ContentType:  obj.ContentType,
+			LastModified: obj.LastModified.UTC().Format(lastModifiedFormat),
 		}
-		i++
 	}
 	return contents, nil
 }
@@ -150,11 +371,58 @@ func (s *Swift) ListContainer(name string, params map[string]string) ([]swift.Co
 // AddObject creates a new object with the given name in the specified
 // container, setting the object's data. It's an error if the object
 // already exists. If the container does not exist, it will be
-// created.
+// created. The object has the default content type,
+// application/octet-stream.
 func (s *Swift) AddObject(container, name string, data []byte) error {
 	if err := s.ProcessFunctionHook(s, container, name); err != nil {
 		return err
 	}
+	return s.AddObjectWithContentType(container, name, data, defaultContentType)
+}
+
+// AddObjectWithContentType creates a new object, as for AddObject,
+// with the given content type.
+func (s *Swift) AddObjectWithContentType(container, name string, data []byte, contentType string) error {
+	if err := s.ProcessFunctionHook(s, container, name, contentType); err != nil {
+		return err
+	}
+	return s.addObject(container, name, data, contentType, "", nil)
+}
+
+// AddManifestObject creates a dynamic large object, as for
+// AddObjectWithContentType, whose data is made up of the segments
+// named by manifest, given as "<container>/<prefix>". The segments
+// need not exist until the object is read. If contentType is empty,
+// the default content type is used.
+func (s *Swift) AddManifestObject(container, name, manifest, contentType string) error {
+	if err := s.ProcessFunctionHook(s, container, name, manifest, contentType); err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	return s.addObject(container, name, nil, contentType, manifest, nil)
+}
+
+// AddStaticManifestObject creates a static large object, as for
+// AddObjectWithContentType, whose data is made up of the given
+// segments, in order, each given as "<container>/<object>". Unlike
+// the segments of a dynamic large object, the segments must exist.
+// If contentType is empty, the default content type is used.
+func (s *Swift) AddStaticManifestObject(container, name string, segments []string, contentType string) error {
+	if err := s.ProcessFunctionHook(s, container, name, segments, contentType); err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return fmt.Errorf("static large object %q has no segments", name)
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	return s.addObject(container, name, nil, contentType, "", segments)
+}
+
+func (s *Swift) addObject(container, name string, data []byte, contentType, manifest string, segments []string) error {
 	if _, err := s.GetObject(container, name); err == nil {
 		return fmt.Errorf(
 			"object %q in container %q already exists",
@@ -166,12 +434,343 @@ func (s *Swift) AddObject(container, name string, data []byte) error {
 			return err
 		}
 	}
+	hash := md5.Sum(data)
+	etag, size := hex.EncodeToString(hash[:]), len(data)
+	if len(segments) > 0 {
+		var err error
+		if etag, size, err = s.staticInfo(segments); err != nil {
+			return err
+		}
+		segments = append([]string(nil), segments...)
+	}
+	s.mu.Lock()
+	s.containers[container][name] = &storedObject{
+		ObjectInfo: ObjectInfo{
+			ETag:         etag,
+			ContentType:  contentType,
+			LengthBytes:  size,
+			LastModified: time.Now(),
+			Manifest:     manifest,
+			Segments:     segments,
+		},
+		data: data,
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// SetObjectMetadata replaces the user metadata of an existing object,
+// as a POST to the object does.
+func (s *Swift) SetObjectMetadata(container, name string, metadata map[string]string) error {
+	if err := s.ProcessFunctionHook(s, container, name, metadata); err != nil {
+		return err
+	}
+	obj, err := s.object(container, name)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	obj.Metadata = copyMetadata(metadata)
+	s.mu.Unlock()
+	return nil
+}
+
+// CopyObject copies an existing object, as a COPY request or a PUT
+// with an X-Copy-From header does. The copy has the source object's
+// data, content type and user metadata, and replaces any object with
+// the destination name, which is archived if the destination container
+// is versioned. The destination container must exist. The data of a
+// dynamic or static large object is copied, rather than its manifest.
+func (s *Swift) CopyObject(srcContainer, srcName, dstContainer, dstName string) error {
+	if err := s.ProcessFunctionHook(s, srcContainer, srcName, dstContainer, dstName); err != nil {
+		return err
+	}
+	info, err := s.GetObjectInfo(srcContainer, srcName)
+	if err != nil {
+		return err
+	}
+	return s.copyObject(srcContainer, srcName, dstContainer, dstName, info.ContentType, info.Metadata)
+}
+
+// copyObject replaces the destination object with a copy of the data
+// of the source object, with the given content type and metadata.
+func (s *Swift) copyObject(srcContainer, srcName, dstContainer, dstName, contentType string, metadata map[string]string) error {
+	data, err := s.GetObject(srcContainer, srcName)
+	if err != nil {
+		return err
+	}
+	data = append([]byte(nil), data...)
+	if !s.HasContainer(dstContainer) {
+		return fmt.Errorf("no such container %q", dstContainer)
+	}
+	if _, err := s.object(dstContainer, dstName); err == nil {
+		if err := s.archiveObject(dstContainer, dstName); err != nil {
+			return err
+		}
+		if err := s.RemoveObject(dstContainer, dstName); err != nil {
+			return err
+		}
+	}
+	if err := s.addObject(dstContainer, dstName, data, contentType, "", nil); err != nil {
+		return err
+	}
+	if len(metadata) > 0 {
+		return s.SetObjectMetadata(dstContainer, dstName, metadata)
+	}
+	return nil
+}
+
+// SetVersionsLocation makes an existing container versioned, as
+// setting its X-Versions-Location header does: when an object in the
+// container is overwritten, the prior version is first copied into the
+// location container, and when an object is deleted, the most recent
+// prior version is restored. An empty location stops versioning the
+// container; versions already archived are kept.
+// Note: this is implemented as a public method rather than as an HTTP
+// API for convenience in tests.
+func (s *Swift) SetVersionsLocation(container, location string) error {
+	if err := s.ProcessFunctionHook(s, container, location); err != nil {
+		return err
+	}
+	if strings.Contains(location, "/") {
+		return fmt.Errorf("invalid versions location %q", location)
+	}
 	s.mu.Lock()
-	s.containers[container][name] = data
+	defer s.mu.Unlock()
+	if _, ok := s.containers[container]; !ok {
+		return fmt.Errorf("no such container %q", container)
+	}
+	if location == "" {
+		delete(s.versionsLocations, container)
+	} else {
+		s.versionsLocations[container] = location
+	}
+	return nil
+}
+
+// versionsLocation returns the container holding the prior versions
+// of the objects in the given container, or "" if it is not
+// versioned.
+func (s *Swift) versionsLocation(container string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.versionsLocations[container]
+}
+
+// versionsPrefix returns the prefix of the names under which the prior
+// versions of the named object are archived. As in Swift, it is the
+// length of the name, as three hex digits, followed by the name and a
+// slash, so that the versions of different objects never share a
+// prefix.
+func versionsPrefix(name string) string {
+	return fmt.Sprintf("%03x%s/", len(name), name)
+}
+
+// versionTimestamp formats t as a Swift timestamp, which names an
+// archived version of an object.
+func versionTimestamp(t time.Time) string {
+	return fmt.Sprintf("%016.05f", float64(t.UnixNano())/1e9)
+}
+
+// versionsContainerError is returned when a prior version of an
+// object cannot be archived because its container's versions location
+// does not exist.
+type versionsContainerError string
+
+func (e versionsContainerError) Error() string {
+	return fmt.Sprintf("no such versions container %q", string(e))
+}
+
+// archiveObject copies an existing object, if the container is
+// versioned, into its versions location, named after the time it was
+// last modified. If the versions container does not exist, a
+// versionsContainerError is returned.
+func (s *Swift) archiveObject(container, name string) error {
+	location := s.versionsLocation(container)
+	if location == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.containers[container][name]
+	if !ok {
+		return nil
+	}
+	versions, ok := s.containers[location]
+	if !ok {
+		return versionsContainerError(location)
+	}
+	archived := *obj
+	archived.Segments = append([]string(nil), obj.Segments...)
+	archived.Metadata = copyMetadata(obj.Metadata)
+	// Versions created within the resolution of a timestamp are
+	// named after successive timestamps.
+	t := obj.LastModified
+	versionName := versionsPrefix(name) + versionTimestamp(t)
+	for versions[versionName] != nil {
+		t = t.Add(10 * time.Microsecond)
+		versionName = versionsPrefix(name) + versionTimestamp(t)
+	}
+	versions[versionName] = &archived
+	return nil
+}
+
+// restoreVersion replaces an object in a versioned container with its
+// most recent prior version, which is removed from the versions
+// location. It reports whether there was a version to restore.
+func (s *Swift) restoreVersion(container, name string) (bool, error) {
+	location := s.versionsLocation(container)
+	if location == "" {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prefix := versionsPrefix(name)
+	latest := ""
+	for versionName := range s.containers[location] {
+		if strings.HasPrefix(versionName, prefix) && versionName > latest {
+			latest = versionName
+		}
+	}
+	objects, ok := s.containers[container]
+	if latest == "" || !ok {
+		return false, nil
+	}
+	// Swift restores the version by copying it, so the restored
+	// object is modified now.
+	restored := s.containers[location][latest]
+	restored.LastModified = time.Now()
+	objects[name] = restored
+	delete(s.containers[location], latest)
+	return true, nil
+}
+
+// SetTempURLKey sets the account key which signs TempURLs, as
+// setting the X-Account-Meta-Temp-URL-Key header does. TempURLs are
+// rejected while no key is set.
+// Note: this is implemented as a public method rather than as an HTTP
+// API for convenience in tests.
+func (s *Swift) SetTempURLKey(key string) {
+	s.mu.Lock()
+	s.tempURLKey = key
+	if key == "" {
+		delete(s.accountMetadata, tempURLKeyMetadata)
+	} else {
+		if s.accountMetadata == nil {
+			s.accountMetadata = make(map[string]string)
+		}
+		s.accountMetadata[tempURLKeyMetadata] = key
+	}
 	s.mu.Unlock()
+}
+
+// getTempURLKey returns the account key which signs TempURLs.
+func (s *Swift) getTempURLKey() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tempURLKey
+}
+
+// The keys of the account metadata items which configure the
+// service, as header names without the X-Account-Meta- prefix.
+const (
+	tempURLKeyMetadata = "Temp-Url-Key"
+	quotaBytesMetadata = "Quota-Bytes"
+)
+
+// AccountInfo describes the account's usage and metadata.
+type AccountInfo struct {
+	ContainerCount int
+	ObjectCount    int
+	BytesUsed      int
+	// Metadata holds the account's user metadata, reported in the
+	// X-Account-Meta-* headers. The keys omit the header prefix.
+	Metadata map[string]string
+}
+
+// GetAccountInfo returns the account's current usage and metadata.
+func (s *Swift) GetAccountInfo() *AccountInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := &AccountInfo{
+		ContainerCount: len(s.containers),
+		Metadata:       copyMetadata(s.accountMetadata),
+	}
+	for _, objects := range s.containers {
+		for _, obj := range objects {
+			info.ObjectCount++
+			info.BytesUsed += obj.LengthBytes
+		}
+	}
+	return info
+}
+
+// SetAccountMetadata updates the account's user metadata, as a POST
+// to the account does. Unlike object metadata, items not given are
+// left unchanged; an item given an empty value is removed. The
+// Temp-Url-Key item sets the key which signs TempURLs, and the
+// Quota-Bytes item limits the bytes the account's objects may use.
+func (s *Swift) SetAccountMetadata(metadata map[string]string) error {
+	if err := s.ProcessFunctionHook(s, metadata); err != nil {
+		return err
+	}
+	if value, ok := metadata[quotaBytesMetadata]; ok && value != "" {
+		if quota, err := strconv.Atoi(value); err != nil || quota < 0 {
+			return fmt.Errorf("invalid quota %q", value)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, value := range metadata {
+		if value == "" {
+			delete(s.accountMetadata, key)
+			continue
+		}
+		if s.accountMetadata == nil {
+			s.accountMetadata = make(map[string]string)
+		}
+		s.accountMetadata[key] = value
+	}
+	if key, ok := metadata[tempURLKeyMetadata]; ok {
+		s.tempURLKey = key
+	}
+	return nil
+}
+
+// checkQuota returns an error if replacing the named object, which
+// need not exist, with one of the given size would take the bytes
+// used by the account over its quota.
+func (s *Swift) checkQuota(container, name string, size int) error {
+	info := s.GetAccountInfo()
+	value, ok := info.Metadata[quotaBytesMetadata]
+	if !ok {
+		return nil
+	}
+	quota, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	used := info.BytesUsed + size
+	if existing, err := s.object(container, name); err == nil {
+		used -= existing.LengthBytes
+	}
+	if used > quota {
+		return fmt.Errorf("upload exceeds quota of %d bytes", quota)
+	}
 	return nil
 }
 
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		result[k] = v
+	}
+	return result
+}
+
 // RemoveContainer deletes an existing container with the given name.
 func (s *Swift) RemoveContainer(name string) error {
 	if err := s.ProcessFunctionHook(s, name); err != nil {
@@ -182,6 +781,7 @@ func (s *Swift) RemoveContainer(name string) error {
 	}
 	s.mu.Lock()
 	delete(s.containers, name)
+	delete(s.versionsLocations, name)
 	s.mu.Unlock()
 	return nil
 }
@@ -3,12 +3,23 @@
 package swiftservice
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/goose.v1/swift"
 )
 
 // verbatim real Swift responses
@@ -31,16 +42,432 @@ The request is accepted for processing.
 
 
 `
+	preconditionFailedResponse = `412 Precondition Failed
+
+A precondition for this request was not met.
+
+
+`
+	unprocessableResponse = `422 Unprocessable Entity
+
+Unable to process the contained instructions
+
+
+`
+	badDestinationResponse = `412 Precondition Failed
+
+Destination header must be of the form <container name>/<object name>
+
+
+`
+	badCopyFromResponse = `412 Precondition Failed
+
+X-Copy-From header must be of the form <container name>/<object name>
+
+
+`
+	methodNotAllowedResponse = `405 Method Not Allowed
+
+The method is not allowed for this resource.
+
+
+`
+	badManifestResponse = `400 Bad Request
+
+Manifest must be valid JSON.
+
+
+`
+	emptyManifestResponse = `400 Bad Request
+
+Manifest must have at least one segment.
+
+
+`
+	quotaExceededResponse = `413 Request Entity Too Large
+
+Upload exceeds quota.
+
+
+`
+	badVersionsLocationResponse = `412 Precondition Failed
+
+Container name cannot contain slashes
+
+
+`
+	serviceUnavailableResponse = `503 Service Unavailable
+
+The server is currently unavailable. Please try again at a later time.
+
+
+`
+	rangeNotSatisfiableResponse = `<html><h1>Requested Range Not Satisfiable</h1><p>The Range requested is not available.</p></html>`
+)
+
+// maxBulkDeletes is the largest number of paths a bulk delete request
+// may name, as in Swift's default configuration.
+const maxBulkDeletes = 10000
+
+// allowedMethods are the methods supported for containers and objects.
+var allowedMethods = []string{"GET", "HEAD", "POST", "PUT", "DELETE", "COPY"}
+
+func isAllowedMethod(method string) bool {
+	for _, allowed := range allowedMethods {
+		if method == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMethodNotAllowed responds to a request whose method is not one
+// of allowedMethods.
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
+	w.Header().Set("Content-Length", strconv.Itoa(len(methodNotAllowedResponse)))
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	w.Write([]byte(methodNotAllowedResponse))
+}
+
+// The prefixes of the headers holding an object's user metadata, and
+// naming metadata to remove.
+const (
+	objectMetaPrefix       = "X-Object-Meta-"
+	removeObjectMetaPrefix = "X-Remove-Object-Meta-"
+)
+
+// objectMetadata returns the object metadata given in a request's
+// headers. As in Swift, an item is removed by giving it an empty
+// value, or by naming it in an X-Remove-Object-Meta-* header.
+func objectMetadata(header http.Header) map[string]string {
+	return updateObjectMetadata(nil, header)
+}
+
+// updateObjectMetadata returns a copy of metadata updated with the
+// object metadata given in a request's headers, as objectMetadata
+// interprets them.
+func updateObjectMetadata(metadata map[string]string, header http.Header) map[string]string {
+	metadata = copyMetadata(metadata)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	for name := range header {
+		if key := strings.TrimPrefix(name, objectMetaPrefix); key != name && key != "" {
+			if value := header.Get(name); value != "" {
+				metadata[key] = value
+			} else {
+				delete(metadata, key)
+			}
+		}
+	}
+	for name := range header {
+		if key := strings.TrimPrefix(name, removeObjectMetaPrefix); key != name {
+			delete(metadata, key)
+		}
+	}
+	return metadata
+}
+
+// The prefixes of the headers holding the account's user metadata,
+// and naming metadata to remove.
+const (
+	accountMetaPrefix       = "X-Account-Meta-"
+	removeAccountMetaPrefix = "X-Remove-Account-Meta-"
 )
 
+// accountMetadata returns the changes to the account metadata given in
+// a request's headers. Items to be removed, by giving them an empty
+// value or by naming them in an X-Remove-Account-Meta-* header, have
+// empty values.
+func accountMetadata(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for name := range header {
+		if key := strings.TrimPrefix(name, accountMetaPrefix); key != name && key != "" {
+			metadata[key] = header.Get(name)
+		}
+	}
+	for name := range header {
+		if key := strings.TrimPrefix(name, removeAccountMetaPrefix); key != name && key != "" {
+			metadata[key] = ""
+		}
+	}
+	return metadata
+}
+
+// writeNotFound writes a 404 response. Like Swift, the response to a
+// HEAD request has no body.
+func writeNotFound(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "HEAD" {
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte(notFoundResponse))
+}
+
+// setContainerHeaders sets the response headers describing a
+// container with the given contents.
+func setContainerHeaders(w http.ResponseWriter, contents []swift.ContainerContents) {
+	bytesUsed := 0
+	for _, item := range contents {
+		bytesUsed += item.LengthBytes
+	}
+	w.Header().Set("X-Container-Object-Count", strconv.Itoa(len(contents)))
+	w.Header().Set("X-Container-Bytes-Used", strconv.Itoa(bytesUsed))
+}
+
+// marshalListing returns the JSON encoding of a container listing.
+// Like Swift, only the name of a pseudo-directory is given.
+func marshalListing(contents []swift.ContainerContents) ([]byte, error) {
+	type subdirEntry struct {
+		Subdir string `json:"subdir"`
+	}
+	entries := make([]interface{}, len(contents))
+	for i, item := range contents {
+		if item.Subdir != "" {
+			entries[i] = subdirEntry{item.Subdir}
+		} else {
+			entries[i] = item
+		}
+	}
+	return json.Marshal(entries)
+}
+
+// setAccountHeaders sets the response headers describing the account.
+func setAccountHeaders(w http.ResponseWriter, info *AccountInfo) {
+	w.Header().Set("X-Account-Container-Count", strconv.Itoa(info.ContainerCount))
+	w.Header().Set("X-Account-Object-Count", strconv.Itoa(info.ObjectCount))
+	w.Header().Set("X-Account-Bytes-Used", strconv.Itoa(info.BytesUsed))
+	for key, value := range info.Metadata {
+		w.Header().Set(accountMetaPrefix+key, value)
+	}
+}
+
+// accountContainer describes a container in an account listing.
+type accountContainer struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Bytes int    `json:"bytes"`
+}
+
+// listAccount returns the account's containers, sorted by name.
+func (s *Swift) listAccount() []accountContainer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	containers := make([]accountContainer, 0, len(s.containers))
+	for name, objects := range s.containers {
+		container := accountContainer{Name: name, Count: len(objects)}
+		for _, obj := range objects {
+			container.Bytes += obj.LengthBytes
+		}
+		containers = append(containers, container)
+	}
+	sort.Sort(containersByName(containers))
+	return containers
+}
+
+type containersByName []accountContainer
+
+func (c containersByName) Len() int           { return len(c) }
+func (c containersByName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+func (c containersByName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// handleAccount processes HTTP requests for the account.
+func (s *Swift) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.URL.Query()["bulk-delete"]; ok && (r.Method == "DELETE" || r.Method == "POST") {
+		s.handleBulkDelete(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		data, err := json.Marshal(s.listAccount())
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		setAccountHeaders(w, s.GetAccountInfo())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	case "HEAD":
+		setAccountHeaders(w, s.GetAccountInfo())
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusNoContent)
+	case "POST":
+		if err := s.SetAccountMetadata(accountMetadata(r.Header)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeMethodNotAllowed(w)
+	}
+}
+
+// bulkDeleteResponse is the summary of a bulk delete request which
+// Swift's bulk middleware returns. Each of the Errors holds a quoted
+// path and the status of the failure to delete it.
+type bulkDeleteResponse struct {
+	NumberDeleted  int        `json:"Number Deleted"`
+	NumberNotFound int        `json:"Number Not Found"`
+	ResponseStatus string     `json:"Response Status"`
+	ResponseBody   string     `json:"Response Body"`
+	Errors         [][]string `json:"Errors"`
+}
+
+// statusLine returns the status line text, e.g. "404 Not Found", of
+// the given status code.
+func statusLine(code int) string {
+	return fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
+
+// bulkDelete deletes each of the URL encoded "<container>/<object>"
+// or "<container>" paths, which may have a leading slash. As in
+// Swift, a container is only deleted if it is empty, and paths which
+// do not exist are counted rather than reported as errors.
+func (s *Swift) bulkDelete(paths []string) bulkDeleteResponse {
+	resp := bulkDeleteResponse{Errors: [][]string{}}
+	fail := func(path string, code int) {
+		resp.Errors = append(resp.Errors, []string{path, statusLine(code)})
+	}
+	for _, path := range paths {
+		name, err := url.PathUnescape(strings.TrimPrefix(path, "/"))
+		if err != nil {
+			fail(path, http.StatusBadRequest)
+			continue
+		}
+		parts := strings.SplitN(name, "/", 2)
+		container := parts[0]
+		if container == "" || (len(parts) == 2 && parts[1] == "") {
+			fail(path, http.StatusBadRequest)
+			continue
+		}
+		if !s.HasContainer(container) {
+			resp.NumberNotFound++
+			continue
+		}
+		if len(parts) == 1 {
+			if contents, err := s.listContainer(container, nil); err == nil && len(contents) > 0 {
+				fail(path, http.StatusConflict)
+				continue
+			}
+			err = s.RemoveContainer(container)
+		} else {
+			if _, err := s.object(container, parts[1]); err != nil {
+				resp.NumberNotFound++
+				continue
+			}
+			err = s.RemoveObject(container, parts[1])
+		}
+		if err != nil {
+			fail(path, http.StatusInternalServerError)
+			continue
+		}
+		resp.NumberDeleted++
+	}
+	return resp
+}
+
+// handleBulkDelete processes a bulk delete request, whose body holds
+// the newline separated paths to delete. Like Swift, it responds with
+// a 200 whose body summarises the deletions, as JSON if the client
+// accepts it and as plain text otherwise; the response status of the
+// request as a whole is given in the summary.
+func (s *Swift) handleBulkDelete(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	var paths []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	var resp bulkDeleteResponse
+	switch {
+	case len(paths) == 0:
+		resp = bulkDeleteResponse{
+			ResponseStatus: statusLine(http.StatusBadRequest),
+			ResponseBody:   "Invalid bulk delete.",
+			Errors:         [][]string{},
+		}
+	case len(paths) > maxBulkDeletes:
+		resp = bulkDeleteResponse{
+			ResponseStatus: statusLine(http.StatusRequestEntityTooLarge),
+			ResponseBody:   fmt.Sprintf("Maximum Bulk Deletes: %d per request", maxBulkDeletes),
+			Errors:         [][]string{},
+		}
+	default:
+		resp = s.bulkDelete(paths)
+		resp.ResponseStatus = statusLine(http.StatusOK)
+		for _, failure := range resp.Errors {
+			if strings.HasPrefix(failure[1], "5") {
+				resp.ResponseStatus = statusLine(http.StatusBadGateway)
+				break
+			}
+			resp.ResponseStatus = statusLine(http.StatusBadRequest)
+		}
+	}
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		data, err := json.Marshal(resp)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Number Deleted: %d\n", resp.NumberDeleted)
+	fmt.Fprintf(&buf, "Number Not Found: %d\n", resp.NumberNotFound)
+	fmt.Fprintf(&buf, "Response Body: %s\n", resp.ResponseBody)
+	fmt.Fprintf(&buf, "Response Status: %s\n", resp.ResponseStatus)
+	fmt.Fprintf(&buf, "Errors:\n")
+	for _, failure := range resp.Errors {
+		fmt.Fprintf(&buf, "%s, %s\n", failure[0], failure[1])
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// requestedVersionsLocation returns the versions location given by
+// the X-Versions-Location header of a container PUT or POST, which is
+// "" if the X-Remove-Versions-Location header is given or the location
+// is empty. It reports whether either header was given, and whether the
+// location is valid.
+func requestedVersionsLocation(header http.Header) (location string, given, ok bool) {
+	if _, remove := header["X-Remove-Versions-Location"]; remove {
+		return "", true, true
+	}
+	if _, set := header["X-Versions-Location"]; !set {
+		return "", false, true
+	}
+	location, err := url.PathUnescape(header.Get("X-Versions-Location"))
+	if err != nil || strings.Contains(location, "/") {
+		return "", true, false
+	}
+	return location, true, true
+}
+
 // handleContainers processes HTTP requests for container management.
 func (s *Swift) handleContainers(container string, w http.ResponseWriter, r *http.Request) {
 	var err error
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 	exists := s.HasContainer(container)
 	if !exists && r.Method != "PUT" {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(notFoundResponse))
+		writeNotFound(w, r)
 		return
 	}
 	switch r.Method {
@@ -56,16 +483,24 @@ func (s *Swift) handleContainers(container string, w http.ResponseWriter, r *htt
 			params[k] = urlParams.Get(k)
 		}
 		contents, err := s.ListContainer(container, params)
+		var all []swift.ContainerContents
 		var objdata []byte
 		if err == nil {
-			objdata, err = json.Marshal(contents)
+			all, err = s.listContainer(container, nil)
+		}
+		if err == nil {
+			objdata, err = marshalListing(contents)
 		}
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 		} else {
+			setContainerHeaders(w, all)
+			if location := s.versionsLocation(container); location != "" {
+				w.Header().Set("X-Versions-Location", location)
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 			w.WriteHeader(http.StatusOK)
-			w.Header().Set("Content-Type", "application/json; charset=UF-8")
 			w.Write([]byte(objdata))
 		}
 	case "DELETE":
@@ -87,34 +522,169 @@ func (s *Swift) handleContainers(container string, w http.ResponseWriter, r *htt
 		for k := range urlParams {
 			params[k] = urlParams.Get(k)
 		}
-		_, err = s.ListContainer(container, params)
+		contents, err := s.ListContainer(container, params)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 		} else {
+			setContainerHeaders(w, contents)
+			if location := s.versionsLocation(container); location != "" {
+				w.Header().Set("X-Versions-Location", location)
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 			w.WriteHeader(http.StatusOK)
-			w.Header().Set("Content-Type", "application/json; charset=UF-8")
 		}
-	case "PUT":
-		if exists {
-			w.WriteHeader(http.StatusAccepted)
-			w.Write([]byte(acceptedResponse))
-		} else {
+	case "PUT", "POST":
+		location, setLocation, ok := requestedVersionsLocation(r.Header)
+		if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(badVersionsLocationResponse))
+			return
+		}
+		status, body := http.StatusAccepted, acceptedResponse
+		if !exists {
 			if err = s.AddContainer(container); err != nil {
 				w.WriteHeader(http.StatusInternalServerError)
 				w.Write([]byte(err.Error()))
-			} else {
-				w.WriteHeader(http.StatusCreated)
-				w.Write([]byte(createdResponse))
+				return
 			}
+			status, body = http.StatusCreated, createdResponse
 		}
-	case "POST":
-		// [sodre]: we don't implement changing ACLs, so this always succeeds.
-		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte(createdResponse))
+		if setLocation {
+			if err = s.SetVersionsLocation(container, location); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				w.Write([]byte(err.Error()))
+				return
+			}
+		}
+		// [sodre]: we don't implement changing ACLs, so a POST
+		// otherwise always succeeds.
+		if r.Method == "POST" {
+			body = createdResponse
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
 	default:
-		panic("not implemented request type: " + r.Method)
+		writeMethodNotAllowed(w)
+	}
+}
+
+// setObjectHeaders sets the response headers describing an object.
+func setObjectHeaders(w http.ResponseWriter, info *ObjectInfo) {
+	w.Header().Set("ETag", info.ETag)
+	w.Header().Set("Content-Type", info.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(info.LengthBytes))
+	w.Header().Set("Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if info.Manifest != "" {
+		w.Header().Set("X-Object-Manifest", info.Manifest)
+	}
+	if len(info.Segments) > 0 {
+		w.Header().Set("X-Static-Large-Object", "True")
+	}
+	for key, value := range info.Metadata {
+		w.Header().Set(objectMetaPrefix+key, value)
+	}
+}
+
+// etagMatches reports whether the value of an If-Match or
+// If-None-Match header matches the given ETag.
+func etagMatches(header, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "*" || strings.Trim(value, `"`) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPreconditions evaluates the If-Match and If-None-Match headers
+// of an object request, where info is nil if the object does not
+// exist. It returns the status with which the request fails, or zero
+// if the request may proceed.
+func checkPreconditions(r *http.Request, info *ObjectInfo) int {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if info == nil || !etagMatches(ifMatch, info.ETag) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if info != nil && etagMatches(ifNoneMatch, info.ETag) {
+			if r.Method == "GET" || r.Method == "HEAD" {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	}
+	return 0
+}
+
+// parseRange parses the value of a Range header naming a single byte
+// range, "bytes=<first>-<last>", "bytes=<first>-" or "bytes=-<suffix
+// length>", of an object of the given size. It returns the offsets of
+// the first and last bytes of the range, and ok is false if the header
+// has another form, in which case Swift ignores it. If the range is
+// unsatisfiable, first is greater than last.
+func parseRange(header string, size int) (first, last int, ok bool) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") || strings.Contains(spec, ",") {
+		return 0, 0, false
 	}
+	parts := strings.SplitN(strings.TrimPrefix(spec, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	start, end := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	if start == "" {
+		suffix, err := strconv.Atoi(end)
+		if err != nil || suffix < 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		// An empty suffix is unsatisfiable.
+		return size - suffix, size - 1, true
+	}
+	first, err := strconv.Atoi(start)
+	if err != nil || first < 0 {
+		return 0, 0, false
+	}
+	last = size - 1
+	if end != "" {
+		if last, err = strconv.Atoi(end); err != nil || last < first {
+			return 0, 0, false
+		}
+		if last >= size {
+			last = size - 1
+		}
+	}
+	return first, last, true
+}
+
+// writeObject sends the contents of an object, or the byte range of
+// them asked for by the request's Range header.
+func writeObject(w http.ResponseWriter, r *http.Request, info *ObjectInfo, data []byte) {
+	setObjectHeaders(w, info)
+	first, last, ok := parseRange(r.Header.Get("Range"), len(data))
+	if !ok {
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+		return
+	}
+	if first > last {
+		w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(rangeNotSatisfiableResponse)))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		w.Write([]byte(rangeNotSatisfiableResponse))
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+	w.Header().Set("Content-Length", strconv.Itoa(last-first+1))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(data[first : last+1])
 }
 
 // handleObjects processes HTTP requests for object management.
@@ -122,24 +692,44 @@ func (s *Swift) handleObjects(container, object string, w http.ResponseWriter, r
 	var err error
 	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
 	if exists := s.HasContainer(container); !exists {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(notFoundResponse))
+		writeNotFound(w, r)
 		return
 	}
 	objdata, err := s.GetObject(container, object)
 	if err != nil && r.Method != "PUT" {
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte(notFoundResponse))
+		writeNotFound(w, r)
 		return
 	}
 	exists := err == nil
+	var info *ObjectInfo
+	if exists {
+		if info, err = s.GetObjectInfo(container, object); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	switch checkPreconditions(r, info) {
+	case http.StatusNotModified:
+		w.Header().Set("ETag", info.ETag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	case http.StatusPreconditionFailed:
+		w.WriteHeader(http.StatusPreconditionFailed)
+		w.Write([]byte(preconditionFailedResponse))
+		return
+	}
 	switch r.Method {
 	case "GET":
-		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json; charset=UF-8")
-		w.Write([]byte(objdata))
+		writeObject(w, r, info, objdata)
 	case "DELETE":
-		if err = s.RemoveObject(container, object); err != nil {
+		// Deleting an object in a versioned container restores its
+		// most recent prior version, if there is one.
+		restored, err := s.restoreVersion(container, object)
+		if err == nil && !restored {
+			err = s.RemoveObject(container, object)
+		}
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 		} else {
@@ -147,51 +737,335 @@ func (s *Swift) handleObjects(container, object string, w http.ResponseWriter, r
 			w.WriteHeader(http.StatusNoContent)
 		}
 	case "HEAD":
+		setObjectHeaders(w, info)
 		w.WriteHeader(http.StatusOK)
-		w.Header().Set("Content-Type", "application/json; charset=UF-8")
+	case "COPY":
+		dstContainer, dstObject, ok := parseObjectPath(r.Header.Get("Destination"))
+		if !ok {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(badDestinationResponse))
+			return
+		}
+		s.handleCopy(container, object, dstContainer, dstObject, w, r)
 	case "PUT":
+		if source := r.Header.Get("X-Copy-From"); source != "" {
+			srcContainer, srcObject, ok := parseObjectPath(source)
+			if !ok {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				w.Write([]byte(badCopyFromResponse))
+				return
+			}
+			s.handleCopy(srcContainer, srcObject, container, object, w, r)
+			return
+		}
 		bodydata, err := ioutil.ReadAll(r.Body)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 			return
 		}
+		hash := md5.Sum(bodydata)
+		etag := hex.EncodeToString(hash[:])
+		static := r.URL.Query().Get("multipart-manifest") == "put"
+		var segments []string
+		if static {
+			var status int
+			var body string
+			segments, etag, status, body = s.checkStaticManifest(bodydata)
+			if status != 0 {
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.WriteHeader(status)
+				w.Write([]byte(body))
+				return
+			}
+		}
+		// Swift compares the supplied checksum case-insensitively,
+		// and allows it to be quoted.
+		expected := strings.ToLower(strings.Trim(r.Header.Get("ETag"), `"`))
+		if expected != "" && expected != etag {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(unprocessableResponse))
+			return
+		}
+		size := len(bodydata)
+		if static || r.Header.Get("X-Object-Manifest") != "" {
+			size = 0
+		}
+		if err := s.checkQuota(container, object, size); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(quotaExceededResponse))
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = defaultContentType
+		}
 		if exists {
-			err = s.RemoveObject(container, object)
+			if err = s.archiveObject(container, object); err == nil {
+				err = s.RemoveObject(container, object)
+			}
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				w.Write([]byte(err.Error()))
+				writeArchiveError(w, err)
+				return
 			}
 		}
-		if err = s.AddObject(container, object, bodydata); err != nil {
+		if static {
+			err = s.AddStaticManifestObject(container, object, segments, contentType)
+		} else if manifest := r.Header.Get("X-Object-Manifest"); manifest != "" {
+			// The body of a manifest object is ignored.
+			err = s.AddManifestObject(container, object, manifest, contentType)
+		} else {
+			err = s.AddObjectWithContentType(container, object, bodydata, contentType)
+		}
+		if metadata := objectMetadata(r.Header); err == nil && len(metadata) > 0 {
+			err = s.SetObjectMetadata(container, object, metadata)
+		}
+		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			w.Write([]byte(err.Error()))
 		} else {
+			w.Header().Set("ETag", etag)
 			w.WriteHeader(http.StatusCreated)
 			w.Write([]byte(createdResponse))
 		}
+	case "POST":
+		if err = s.SetObjectMetadata(container, object, objectMetadata(r.Header)); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error()))
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(acceptedResponse))
+		}
 	default:
-		panic("not implemented request type: " + r.Method)
+		writeMethodNotAllowed(w)
+	}
+}
+
+// writeArchiveError writes the response to a request which failed to
+// replace an object. As in Swift, failing to archive the prior version
+// of an object because the versions container does not exist makes the
+// service unavailable.
+func writeArchiveError(w http.ResponseWriter, err error) {
+	if _, ok := err.(versionsContainerError); ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(serviceUnavailableResponse))
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(err.Error()))
+}
+
+// staticSegment is an entry in the manifest of a static large object,
+// as uploaded. The ETag and size are optional, and are checked against
+// the segment if given.
+type staticSegment struct {
+	Path      string  `json:"path"`
+	ETag      *string `json:"etag"`
+	SizeBytes *int    `json:"size_bytes"`
+}
+
+// checkStaticManifest parses the body of a request to create a static
+// large object, as Swift does, checking that each segment exists and
+// has the ETag and size given for it. It returns the paths of the
+// segments, as "<container>/<object>", and the ETag of the object. If
+// the manifest is invalid, it returns the status and body with which
+// the request fails instead.
+func (s *Swift) checkStaticManifest(body []byte) (segments []string, etag string, status int, errBody string) {
+	var manifest []staticSegment
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, "", http.StatusBadRequest, badManifestResponse
+	}
+	if len(manifest) == 0 {
+		return nil, "", http.StatusBadRequest, emptyManifestResponse
+	}
+	var problems []string
+	etags := md5.New()
+	for _, segment := range manifest {
+		container, name, ok := parseObjectPath(segment.Path)
+		if !ok {
+			problems = append(problems, segment.Path+", 404 Not Found")
+			continue
+		}
+		obj, err := s.object(container, name)
+		if err != nil {
+			problems = append(problems, segment.Path+", 404 Not Found")
+			continue
+		}
+		_, info := s.resolve(obj)
+		if segment.ETag != nil && *segment.ETag != "" && strings.ToLower(strings.Trim(*segment.ETag, `"`)) != info.ETag {
+			problems = append(problems, segment.Path+", Etag Mismatch")
+		}
+		if segment.SizeBytes != nil && *segment.SizeBytes != info.LengthBytes {
+			problems = append(problems, segment.Path+", Size Mismatch")
+		}
+		segments = append(segments, container+"/"+name)
+		etags.Write([]byte(info.ETag))
+	}
+	if len(problems) > 0 {
+		return nil, "", http.StatusUnprocessableEntity, "Errors:\n" + strings.Join(problems, "\n") + "\n"
+	}
+	return segments, hex.EncodeToString(etags.Sum(nil)), 0, ""
+}
+
+// parseObjectPath splits the value of a Destination or X-Copy-From
+// header, "<container>/<object>" with an optional leading slash, into
+// its container and object names, which may be URL encoded.
+func parseObjectPath(value string) (container, object string, ok bool) {
+	value, err := url.PathUnescape(strings.TrimPrefix(value, "/"))
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// handleCopy copies the source object to the destination, for a COPY
+// request or a PUT with X-Copy-From. As in Swift, the copy keeps the
+// source object's metadata, updated by any given in the request,
+// unless X-Fresh-Metadata is true, and its content type, unless the
+// request gives one. The request body is ignored.
+func (s *Swift) handleCopy(srcContainer, srcObject, dstContainer, dstObject string, w http.ResponseWriter, r *http.Request) {
+	info, err := s.GetObjectInfo(srcContainer, srcObject)
+	if err != nil || !s.HasContainer(dstContainer) {
+		writeNotFound(w, r)
+		return
+	}
+	if err := s.checkQuota(dstContainer, dstObject, info.LengthBytes); err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(quotaExceededResponse))
+		return
+	}
+	metadata := info.Metadata
+	if fresh, _ := strconv.ParseBool(r.Header.Get("X-Fresh-Metadata")); fresh {
+		metadata = nil
+	}
+	metadata = updateObjectMetadata(metadata, r.Header)
+	contentType := info.ContentType
+	if value := r.Header.Get("Content-Type"); value != "" {
+		contentType = value
+	}
+	err = s.copyObject(srcContainer, srcObject, dstContainer, dstObject, contentType, metadata)
+	if err != nil {
+		writeArchiveError(w, err)
+		return
+	}
+	copied, err := s.GetObjectInfo(dstContainer, dstObject)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("ETag", copied.ETag)
+	w.Header().Set("X-Copied-From", srcContainer+"/"+srcObject)
+	w.Header().Set("X-Copied-From-Last-Modified", info.LastModified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(createdResponse))
+}
+
+// isTempURL reports whether r is a request for a TempURL.
+func isTempURL(r *http.Request) bool {
+	query := r.URL.Query()
+	return query.Get("temp_url_sig") != "" || query.Get("temp_url_expires") != ""
+}
+
+// tempURLSignature returns the signature of a TempURL for the given
+// method, expiry time and path, as Swift computes it.
+func tempURLSignature(key, method, expires, path string) string {
+	mac := hmac.New(sha1.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%s", method, expires, path)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkTempURL reports whether r is a validly signed, unexpired
+// TempURL request. A signature for GET also allows HEAD.
+func (s *Swift) checkTempURL(r *http.Request) bool {
+	key := s.getTempURLKey()
+	if key == "" || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	query := r.URL.Query()
+	expires := query.Get("temp_url_expires")
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() >= expiresUnix {
+		return false
+	}
+	sig := []byte(query.Get("temp_url_sig"))
+	for _, method := range []string{r.Method, "GET"} {
+		expected := tempURLSignature(key, method, expires, r.URL.Path)
+		if hmac.Equal(sig, []byte(expected)) {
+			return true
+		}
 	}
+	return false
+}
+
+// newTransId returns a transaction id in the format Swift uses: "tx",
+// 21 random hex digits, and the time of the request in hex.
+func newTransId(now time.Time) string {
+	return fmt.Sprintf("tx%021x-%010x", rand.Int63(), now.Unix())
 }
 
 // ServeHTTP is the main entry point in the HTTP request processing.
 func (s *Swift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// TODO(wallyworld) - 2013-02-11 bug=1121682
-	// we need to support container ACLs so we can have pubic containers.
-	// For public containers, the token is not required to access the files. For now, if the request
-	// does not provide a token, we will let it through and assume a public container is being accessed.
-	token := r.Header.Get("X-Auth-Token")
-	_, err := s.IdentityService.FindUser(token)
-	if token != "" && err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+	if err := s.DelayResponse(r); err != nil {
+		// The client has given up on the request.
+		return
+	}
+	w.Header().Set("X-Trans-Id", newTransId(time.Now()))
+	s.AddResponseHeaders(w)
+	if s.HandleOptions(w, r, allowedMethods...) {
+		return
+	}
+	if isTempURL(r) {
+		// A TempURL is used in place of a token.
+		if !s.checkTempURL(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	} else {
+		// TODO(wallyworld) - 2013-02-11 bug=1121682
+		// we need to support container ACLs so we can have pubic containers.
+		// For public containers, the token is not required to access the files. For now, if the request
+		// does not provide a token, we will let it through and assume a public container is being accessed.
+		token := r.Header.Get("X-Auth-Token")
+		user, err := s.IdentityService.FindUser(token)
+		if token != "" && (err != nil || s.TokenExpired(token)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := s.CheckRole(r, user); err != nil {
+			err.(http.Handler).ServeHTTP(w, r)
+			return
+		}
+		// The account belongs to a single tenant, whose tokens
+		// alone may use it, unless they are an admin's.
+		if user != nil && user.TenantId != s.TenantId && !user.HasRole("admin") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+	if err := s.CheckRateLimit(r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := s.LimitRequestBody(w, r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if !isAllowedMethod(r.Method) {
+		writeMethodNotAllowed(w)
 		return
 	}
 	path := strings.TrimRight(r.URL.Path, "/")
 	path = strings.Trim(path, "/")
 	parts := strings.SplitN(path, "/", 4)
 	parts = parts[2:]
-	if len(parts) == 1 {
+	if len(parts) == 0 {
+		s.handleAccount(w, r)
+	} else if len(parts) == 1 {
 		container := parts[0]
 		s.handleContainers(container, w, r)
 	} else if len(parts) == 2 {
@@ -205,6 +1079,7 @@ func (s *Swift) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 // setupHTTP attaches all the needed handlers to provide the HTTP API.
 func (s *Swift) SetupHTTP(mux *http.ServeMux) {
-	path := fmt.Sprintf("/%s/%s/", s.VersionPath, s.TenantId)
+	path := fmt.Sprintf("/%s/%s", s.VersionPath, s.TenantId)
 	mux.Handle(path, s)
+	mux.Handle(path+"/", s)
 }
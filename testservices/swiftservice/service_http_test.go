@@ -4,15 +4,25 @@ package swiftservice
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	gc "gopkg.in/check.v1"
 
 	"gopkg.in/goose.v1/swift"
 	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/hook"
 	"gopkg.in/goose.v1/testservices/identityservice"
 )
 
@@ -35,9 +45,9 @@ var _ = gc.Suite(&SwiftHTTPSSuite{HTTPSuite: httpsuite.HTTPSuite{UseTLS: true}})
 func (s *SwiftHTTPSuite) SetUpSuite(c *gc.C) {
 	s.HTTPSuite.SetUpSuite(c)
 	identityDouble := identityservice.NewUserPass()
-	s.service = New(s.Server.URL, versionPath, tenantId, region, identityDouble)
 	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
 	s.token = userInfo.Token
+	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
 }
 
 func (s *SwiftHTTPSuite) SetUpTest(c *gc.C) {
@@ -60,6 +70,11 @@ func (s *SwiftHTTPSuite) sendRequest(c *gc.C, method, path string, body []byte,
 
 func (s *SwiftHTTPSuite) sendRequestWithParams(c *gc.C, method, path string, params map[string]string, body []byte,
 	expectedStatusCode int) (resp *http.Response) {
+	return s.sendRequestWithHeaders(c, method, path, params, nil, body, expectedStatusCode)
+}
+
+func (s *SwiftHTTPSuite) sendRequestWithHeaders(c *gc.C, method, path string, params map[string]string, headers http.Header,
+	body []byte, expectedStatusCode int) (resp *http.Response) {
 	var req *http.Request
 	var err error
 	URL := s.service.endpointURL(path)
@@ -76,10 +91,15 @@ func (s *SwiftHTTPSuite) sendRequestWithParams(c *gc.C, method, path string, par
 		req, err = http.NewRequest(method, URL, nil)
 	}
 	c.Assert(err, gc.IsNil)
+	for header, values := range headers {
+		for _, value := range values {
+			req.Header.Add(header, value)
+		}
+	}
 	if s.token != "" {
 		req.Header.Add("X-Auth-Token", s.token)
 	}
-	client := &http.DefaultClient
+	client := http.DefaultClient
 	resp, err = client.Do(req)
 	c.Assert(err, gc.IsNil)
 	c.Assert(resp.StatusCode, gc.Equals, expectedStatusCode)
@@ -127,6 +147,25 @@ func (s *SwiftHTTPSuite) removeObject(container, object string, c *gc.C) {
 	s.ensureNotObject(container, object, c)
 }
 
+func (s *SwiftHTTPSuite) TestResponseHeaders(c *gc.C) {
+	resp := s.sendRequest(c, "GET", "", nil, http.StatusOK)
+	c.Assert(resp.Header.Get("X-Trans-Id"), gc.Matches, "tx[0-9a-f]{21}-[0-9a-f]{10}")
+	other := s.sendRequest(c, "GET", "", nil, http.StatusOK)
+	c.Assert(other.Header.Get("X-Trans-Id"), gc.Not(gc.Equals), resp.Header.Get("X-Trans-Id"))
+
+	defer s.service.ClearResponseHeaders()
+	s.service.SetResponseHeader("X-Trans-Id", "tx-fixed")
+	s.service.SetResponseHeader("X-Openstack-Request-Id", "tx-fixed")
+	resp = s.sendRequest(c, "GET", "missing", nil, http.StatusNotFound)
+	c.Assert(resp.Header.Get("X-Trans-Id"), gc.Equals, "tx-fixed")
+	c.Assert(resp.Header.Get("X-Openstack-Request-Id"), gc.Equals, "tx-fixed")
+
+	s.service.SetResponseHeader("X-Trans-Id", "")
+	resp = s.sendRequest(c, "GET", "", nil, http.StatusOK)
+	_, ok := resp.Header["X-Trans-Id"]
+	c.Assert(ok, gc.Equals, false)
+}
+
 func (s *SwiftHTTPSuite) TestPUTContainerMissingCreated(c *gc.C) {
 	s.ensureNotContainer("test", c)
 
@@ -170,6 +209,30 @@ func (s *SwiftHTTPSuite) TestGETContainerExistsOK(c *gc.C) {
 	s.removeContainer("test", c)
 }
 
+func (s *SwiftHTTPSuite) TestGETContainerJSONFormat(c *gc.C) {
+	s.ensureContainer("test", c)
+	err := s.service.AddObjectWithContentType("test", "obj", []byte("test data"), "text/plain")
+	c.Assert(err, gc.IsNil)
+
+	resp := s.sendRequestWithParams(c, "GET", "test", map[string]string{"format": "json"}, nil, http.StatusOK)
+
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json; charset=utf-8")
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	var containerData []swift.ContainerContents
+	err = json.Unmarshal(body, &containerData)
+	c.Assert(err, gc.IsNil)
+	c.Assert(containerData, gc.HasLen, 1)
+	c.Assert(containerData[0].Name, gc.Equals, "obj")
+	c.Assert(containerData[0].Hash, gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	c.Assert(containerData[0].LengthBytes, gc.Equals, 9)
+	c.Assert(containerData[0].ContentType, gc.Equals, "text/plain")
+	c.Assert(containerData[0].LastModified, gc.Not(gc.Equals), "")
+
+	s.removeContainer("test", c)
+}
+
 func (s *SwiftHTTPSuite) TestGETContainerWithPrefix(c *gc.C) {
 	s.ensureContainer("test", c)
 	data := []byte("test data")
@@ -190,6 +253,66 @@ func (s *SwiftHTTPSuite) TestGETContainerWithPrefix(c *gc.C) {
 	s.removeContainer("test", c)
 }
 
+func (s *SwiftHTTPSuite) TestGETContainerWithDelimiter(c *gc.C) {
+	s.ensureContainer("test", c)
+	data := []byte("test data")
+	s.ensureObject("test", "dir/a", data, c)
+	s.ensureObject("test", "dir/b", data, c)
+	s.ensureObject("test", "other", data, c)
+
+	resp := s.sendRequestWithParams(c, "GET", "test", map[string]string{
+		"format":    "json",
+		"delimiter": "/",
+	}, nil, http.StatusOK)
+
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Container-Object-Count"), gc.Equals, "3")
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	var entries []map[string]interface{}
+	err = json.Unmarshal(body, &entries)
+	c.Assert(err, gc.IsNil)
+	c.Assert(entries, gc.HasLen, 2)
+	c.Assert(entries[0], gc.DeepEquals, map[string]interface{}{"subdir": "dir/"})
+	c.Assert(entries[1]["name"], gc.Equals, "other")
+
+	s.removeContainer("test", c)
+}
+
+func (s *SwiftHTTPSuite) TestGETContainerPaginated(c *gc.C) {
+	s.ensureContainer("test", c)
+	data := []byte("test data")
+	for _, name := range []string{"c", "a", "d", "b"} {
+		s.ensureObject("test", name, data, c)
+	}
+
+	var names []string
+	marker := ""
+	for {
+		resp := s.sendRequestWithParams(c, "GET", "test", map[string]string{
+			"format": "json",
+			"limit":  "3",
+			"marker": marker,
+		}, nil, http.StatusOK)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		var containerData []swift.ContainerContents
+		err = json.Unmarshal(body, &containerData)
+		c.Assert(err, gc.IsNil)
+		if len(containerData) == 0 {
+			break
+		}
+		for _, item := range containerData {
+			names = append(names, item.Name)
+		}
+		marker = names[len(names)-1]
+	}
+	c.Assert(names, gc.DeepEquals, []string{"a", "b", "c", "d"})
+
+	s.removeContainer("test", c)
+}
+
 func (s *SwiftHTTPSuite) TestDELETEContainerMissingNotFound(c *gc.C) {
 	s.ensureNotContainer("test", c)
 
@@ -227,6 +350,207 @@ func (s *SwiftHTTPSuite) TestPUTObjectExistsCreated(c *gc.C) {
 	s.removeContainer("test", c)
 }
 
+func (s *SwiftHTTPSuite) TestPUTObjectContentTypeAndETag(c *gc.C) {
+	s.ensureContainer("test", c)
+	s.ensureNotObject("test", "obj", c)
+
+	data := []byte("test data")
+	headers := http.Header{"Content-Type": {"text/plain"}}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, data, http.StatusCreated)
+	resp.Body.Close()
+
+	// md5 of "test data".
+	etag := "eb733a00c0c9d336e65691a37ab54293"
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+	info, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ETag, gc.Equals, etag)
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+	c.Assert(info.LengthBytes, gc.Equals, len(data))
+
+	resp = s.sendRequest(c, "GET", "test/obj", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain")
+	s.removeContainer("test", c)
+}
+
+func (s *SwiftHTTPSuite) TestPUTObjectETagMismatch(c *gc.C) {
+	s.ensureContainer("test", c)
+	s.ensureNotObject("test", "obj", c)
+
+	headers := http.Header{"ETag": {"0123456789abcdef0123456789abcdef"}}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, []byte("test data"), http.StatusUnprocessableEntity)
+	resp.Body.Close()
+
+	s.ensureNotObject("test", "obj", c)
+	s.removeContainer("test", c)
+}
+
+func (s *SwiftHTTPSuite) TestPUTObjectETagCaseInsensitive(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+
+	const etag = "eb733a00c0c9d336e65691a37ab54293"
+	for i, supplied := range []string{strings.ToUpper(etag), `"` + etag + `"`} {
+		c.Logf("test %d: ETag %s", i, supplied)
+		s.ensureNotObject("test", "obj", c)
+		headers := http.Header{"ETag": {supplied}}
+		resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, []byte("test data"), http.StatusCreated)
+		resp.Body.Close()
+		c.Check(resp.Header.Get("ETag"), gc.Equals, etag)
+
+		// The stored checksum is used by later conditional requests.
+		headers = http.Header{"If-None-Match": {etag}}
+		resp = s.sendRequestWithHeaders(c, "GET", "test/obj", nil, headers, nil, http.StatusNotModified)
+		resp.Body.Close()
+		s.removeObject("test", "obj", c)
+	}
+}
+
+func (s *SwiftHTTPSuite) TestDynamicLargeObject(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+
+	data := []byte("hello large world")
+	var etags string
+	for i, segment := range [][]byte{data[:6], data[6:12], data[12:]} {
+		path := fmt.Sprintf("test/segments/%03d", i)
+		resp := s.sendRequest(c, "PUT", path, segment, http.StatusCreated)
+		resp.Body.Close()
+		etags += resp.Header.Get("ETag")
+	}
+	headers := http.Header{
+		"X-Object-Manifest": {"test/segments/"},
+		"Content-Type":      {"text/plain"},
+	}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/big", nil, headers, []byte{}, http.StatusCreated)
+	resp.Body.Close()
+
+	hash := md5.Sum([]byte(etags))
+	etag := hex.EncodeToString(hash[:])
+	resp = s.sendRequest(c, "GET", "test/big", nil, http.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(body, gc.DeepEquals, data)
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+	c.Assert(resp.Header.Get("X-Object-Manifest"), gc.Equals, "test/segments/")
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain")
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, strconv.Itoa(len(data)))
+
+	resp = s.sendRequest(c, "HEAD", "test/big", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, strconv.Itoa(len(data)))
+
+	// The combined ETag is used by conditional requests.
+	headers = http.Header{"If-None-Match": {etag}}
+	resp = s.sendRequestWithHeaders(c, "GET", "test/big", nil, headers, nil, http.StatusNotModified)
+	resp.Body.Close()
+}
+
+func (s *SwiftHTTPSuite) TestStaticLargeObject(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+
+	data := []byte("hello large world")
+	var manifest []map[string]interface{}
+	var etags string
+	for i, segment := range [][]byte{data[:6], data[6:12], data[12:]} {
+		path := fmt.Sprintf("test/segments/%03d", i)
+		resp := s.sendRequest(c, "PUT", path, segment, http.StatusCreated)
+		resp.Body.Close()
+		etag := resp.Header.Get("ETag")
+		etags += etag
+		manifest = append(manifest, map[string]interface{}{
+			"path":       "/" + path,
+			"etag":       etag,
+			"size_bytes": len(segment),
+		})
+	}
+	// Neither the ETag nor the size of a segment need be given.
+	manifest[2] = map[string]interface{}{"path": "/test/segments/002"}
+	body, err := json.Marshal(manifest)
+	c.Assert(err, gc.IsNil)
+	hash := md5.Sum([]byte(etags))
+	etag := hex.EncodeToString(hash[:])
+	params := map[string]string{"multipart-manifest": "put"}
+	headers := http.Header{"Content-Type": {"text/plain"}}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/big", params, headers, body, http.StatusCreated)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+
+	resp = s.sendRequest(c, "GET", "test/big", nil, http.StatusOK)
+	got, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(got, gc.DeepEquals, data)
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+	c.Assert(resp.Header.Get("X-Static-Large-Object"), gc.Equals, "True")
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain")
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, strconv.Itoa(len(data)))
+
+	// Byte ranges span segments.
+	headers = http.Header{"Range": {"bytes=4-7"}}
+	resp = s.sendRequestWithHeaders(c, "GET", "test/big", nil, headers, nil, http.StatusPartialContent)
+	got, err = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(got), gc.Equals, "o la")
+
+	resp = s.sendRequest(c, "HEAD", "test/big", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, etag)
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, strconv.Itoa(len(data)))
+	c.Assert(resp.Header.Get("X-Static-Large-Object"), gc.Equals, "True")
+}
+
+func (s *SwiftHTTPSuite) TestStaticLargeObjectInvalidManifest(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	resp := s.sendRequest(c, "PUT", "test/segment", []byte("data"), http.StatusCreated)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+
+	params := map[string]string{"multipart-manifest": "put"}
+	for i, t := range []struct {
+		manifest string
+		status   int
+		body     string
+	}{{
+		manifest: `[{"path": "/test/segment", "etag": "0123", "size_bytes": 4}]`,
+		status:   http.StatusUnprocessableEntity,
+		body:     "Errors:\n/test/segment, Etag Mismatch\n",
+	}, {
+		manifest: `[{"path": "/test/segment", "etag": "` + etag + `", "size_bytes": 5}]`,
+		status:   http.StatusUnprocessableEntity,
+		body:     "Errors:\n/test/segment, Size Mismatch\n",
+	}, {
+		manifest: `[{"path": "/test/segment"}, {"path": "/test/missing"}, {"path": "/test/segment", "size_bytes": 1}]`,
+		status:   http.StatusUnprocessableEntity,
+		body:     "Errors:\n/test/missing, 404 Not Found\n/test/segment, Size Mismatch\n",
+	}, {
+		manifest: `[]`,
+		status:   http.StatusBadRequest,
+		body:     emptyManifestResponse,
+	}, {
+		manifest: `not json`,
+		status:   http.StatusBadRequest,
+		body:     badManifestResponse,
+	}} {
+		c.Logf("test %d: %s", i, t.manifest)
+		resp := s.sendRequestWithParams(c, "PUT", "test/big", params, []byte(t.manifest), t.status)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Check(string(body), gc.Equals, t.body)
+		// No object is created.
+		_, err = s.service.GetObject("test", "big")
+		c.Check(err, gc.NotNil)
+	}
+}
+
 func (s *SwiftHTTPSuite) TestPUTObjectContainerMissingNotFound(c *gc.C) {
 	s.ensureNotContainer("test", c)
 
@@ -304,6 +628,8 @@ func (s *SwiftHTTPSuite) TestHEADContainerExistsOK(c *gc.C) {
 
 	resp := s.sendRequest(c, "HEAD", "test", nil, http.StatusOK)
 	c.Assert(resp.Header.Get("Date"), gc.Not(gc.Equals), "")
+	c.Assert(resp.Header.Get("X-Container-Object-Count"), gc.Equals, "1")
+	c.Assert(resp.Header.Get("X-Container-Bytes-Used"), gc.Equals, "9")
 
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -329,6 +655,10 @@ func (s *SwiftHTTPSuite) TestHEADObjectExistsOK(c *gc.C) {
 
 	s.ensureObjectData("test", "obj", data, c)
 	c.Assert(resp.Header.Get("Date"), gc.Not(gc.Equals), "")
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/octet-stream")
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, "9")
+	c.Assert(resp.Header.Get("Last-Modified"), gc.Not(gc.Equals), "")
 
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -347,6 +677,576 @@ func (s *SwiftHTTPSuite) TestHEADObjectMissingNotFound(c *gc.C) {
 	s.removeContainer("test", c)
 }
 
+func (s *SwiftHTTPSuite) TestHEADMissingHasNoBody(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	for _, path := range []string{"test/obj", "other", "other/obj"} {
+		c.Logf("path %q", path)
+		resp := s.sendRequest(c, "HEAD", path, nil, http.StatusNotFound)
+		c.Check(resp.Header.Get("Content-Length"), gc.Equals, "0")
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Check(body, gc.HasLen, 0)
+	}
+}
+
+func (s *SwiftHTTPSuite) TestObjectMetadata(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	headers := http.Header{
+		"Content-Type":        {"text/plain"},
+		"X-Object-Meta-Color": {"blue"},
+		"X-Object-Meta-Size":  {"large"},
+	}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, []byte("test data"), http.StatusCreated)
+	resp.Body.Close()
+
+	resp = s.sendRequest(c, "HEAD", "test/obj", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Object-Meta-Color"), gc.Equals, "blue")
+	c.Assert(resp.Header.Get("X-Object-Meta-Size"), gc.Equals, "large")
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain")
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, "9")
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+
+	// POST replaces all the metadata.
+	headers = http.Header{"X-Object-Meta-Shape": {"round"}}
+	resp = s.sendRequestWithHeaders(c, "POST", "test/obj", nil, headers, nil, http.StatusAccepted)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "GET", "test/obj", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Object-Meta-Shape"), gc.Equals, "round")
+	c.Assert(resp.Header.Get("X-Object-Meta-Color"), gc.Equals, "")
+	info, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Shape": "round"})
+
+	resp = s.sendRequestWithHeaders(c, "POST", "test/missing", nil, headers, nil, http.StatusNotFound)
+	resp.Body.Close()
+}
+
+func (s *SwiftHTTPSuite) TestCopyObject(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	headers := http.Header{
+		"Content-Type":        {"text/plain"},
+		"X-Object-Meta-Color": {"blue"},
+		"X-Object-Meta-Size":  {"large"},
+	}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, []byte("test data"), http.StatusCreated)
+	resp.Body.Close()
+
+	// A PUT with X-Copy-From keeps the source's metadata, updated
+	// by any given in the request.
+	headers = http.Header{
+		"X-Copy-From":         {"/test/obj"},
+		"X-Object-Meta-Color": {"red"},
+		"X-Object-Meta-Size":  {""},
+	}
+	resp = s.sendRequestWithHeaders(c, "PUT", "test/copy1", nil, headers, nil, http.StatusCreated)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	c.Assert(resp.Header.Get("X-Copied-From"), gc.Equals, "test/obj")
+	data, err := s.service.GetObject("test", "copy1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "test data")
+	info, err := s.service.GetObjectInfo("test", "copy1")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Color": "red"})
+
+	// A COPY to Destination may replace the metadata altogether,
+	// and set a new content type.
+	headers = http.Header{
+		"Destination":         {"test/copy2"},
+		"Content-Type":        {"application/json"},
+		"X-Fresh-Metadata":    {"true"},
+		"X-Object-Meta-Shape": {"round"},
+	}
+	resp = s.sendRequestWithHeaders(c, "COPY", "test/obj", nil, headers, nil, http.StatusCreated)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("ETag"), gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	info, err = s.service.GetObjectInfo("test", "copy2")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ContentType, gc.Equals, "application/json")
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Shape": "round"})
+
+	// The source is unchanged.
+	info, err = s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Color": "blue", "Size": "large"})
+}
+
+func (s *SwiftHTTPSuite) TestVersionedContainer(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	err := s.service.AddContainer("versions")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("versions")
+	headers := http.Header{"X-Versions-Location": {"versions"}}
+	resp := s.sendRequestWithHeaders(c, "POST", "test", nil, headers, nil, http.StatusAccepted)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "HEAD", "test", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Versions-Location"), gc.Equals, "versions")
+
+	// Each overwrite archives the prior version.
+	for _, data := range []string{"one", "two", "three"} {
+		headers := http.Header{"Content-Type": {"text/plain"}, "X-Object-Meta-Data": {data}}
+		resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, []byte(data), http.StatusCreated)
+		resp.Body.Close()
+	}
+	headers = http.Header{"X-Copy-From": {"test/obj"}}
+	resp = s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, nil, http.StatusCreated)
+	resp.Body.Close()
+	contents, err := s.service.ListContainer("versions", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.HasLen, 3)
+	var versions []string
+	for _, item := range contents {
+		c.Check(item.Name, gc.Matches, `003obj/\d{10}\.\d{5}`)
+		data, err := s.service.GetObject("versions", item.Name)
+		c.Assert(err, gc.IsNil)
+		versions = append(versions, string(data))
+	}
+	c.Assert(versions, gc.DeepEquals, []string{"one", "two", "three"})
+	info, err := s.service.GetObjectInfo("versions", contents[0].Name)
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Data": "one"})
+
+	// Each delete restores the most recent prior version, until
+	// there are none left.
+	for _, data := range []string{"three", "two", "one"} {
+		resp := s.sendRequest(c, "DELETE", "test/obj", nil, http.StatusNoContent)
+		resp.Body.Close()
+		s.ensureObjectData("test", "obj", []byte(data), c)
+	}
+	contents, err = s.service.ListContainer("versions", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.HasLen, 0)
+	resp = s.sendRequest(c, "DELETE", "test/obj", nil, http.StatusNoContent)
+	resp.Body.Close()
+	s.ensureNotObject("test", "obj", c)
+
+	// Versioning may be turned off.
+	headers = http.Header{"X-Remove-Versions-Location": {"x"}}
+	resp = s.sendRequestWithHeaders(c, "POST", "test", nil, headers, nil, http.StatusAccepted)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "GET", "test", nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("X-Versions-Location"), gc.Equals, "")
+	for i := 0; i < 2; i++ {
+		resp := s.sendRequest(c, "PUT", "test/obj", []byte("data"), http.StatusCreated)
+		resp.Body.Close()
+	}
+	contents, err = s.service.ListContainer("versions", nil)
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.HasLen, 0)
+}
+
+func (s *SwiftHTTPSuite) TestVersionedContainerErrors(c *gc.C) {
+	headers := http.Header{"X-Versions-Location": {"versions/sub"}}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test", nil, headers, nil, http.StatusPreconditionFailed)
+	resp.Body.Close()
+	s.ensureNotContainer("test", c)
+
+	// Objects cannot be overwritten while the versions location does
+	// not exist.
+	headers = http.Header{"X-Versions-Location": {"missing"}}
+	resp = s.sendRequestWithHeaders(c, "PUT", "test", nil, headers, nil, http.StatusCreated)
+	resp.Body.Close()
+	defer s.removeContainer("test", c)
+	resp = s.sendRequest(c, "PUT", "test/obj", []byte("one"), http.StatusCreated)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "PUT", "test/obj", []byte("two"), http.StatusServiceUnavailable)
+	resp.Body.Close()
+	headers = http.Header{"Destination": {"test/obj"}}
+	resp = s.sendRequestWithHeaders(c, "COPY", "test/obj", nil, headers, nil, http.StatusServiceUnavailable)
+	resp.Body.Close()
+	s.ensureObjectData("test", "obj", []byte("one"), c)
+}
+
+func (s *SwiftHTTPSuite) TestCopyObjectErrors(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	resp := s.sendRequest(c, "PUT", "test/obj", []byte("test data"), http.StatusCreated)
+	resp.Body.Close()
+
+	headers := http.Header{"X-Copy-From": {"test/missing"}}
+	resp = s.sendRequestWithHeaders(c, "PUT", "test/copy", nil, headers, nil, http.StatusNotFound)
+	resp.Body.Close()
+	headers = http.Header{"Destination": {"missing/copy"}}
+	resp = s.sendRequestWithHeaders(c, "COPY", "test/obj", nil, headers, nil, http.StatusNotFound)
+	resp.Body.Close()
+	resp = s.sendRequestWithHeaders(c, "COPY", "test/missing", nil, http.Header{"Destination": {"test/copy"}}, nil, http.StatusNotFound)
+	resp.Body.Close()
+
+	for _, value := range []string{"", "test", "test/", "/obj"} {
+		c.Logf("Destination %q", value)
+		headers = http.Header{"Destination": {value}}
+		resp = s.sendRequestWithHeaders(c, "COPY", "test/obj", nil, headers, nil, http.StatusPreconditionFailed)
+		resp.Body.Close()
+	}
+	headers = http.Header{"X-Copy-From": {"obj"}}
+	resp = s.sendRequestWithHeaders(c, "PUT", "test/copy", nil, headers, nil, http.StatusPreconditionFailed)
+	resp.Body.Close()
+	_, err := s.service.GetObject("test", "copy")
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *SwiftHTTPSuite) bulkDelete(c *gc.C, body string, headers http.Header) *http.Response {
+	params := map[string]string{"bulk-delete": ""}
+	return s.sendRequestWithHeaders(c, "DELETE", "", params, headers, []byte(body), http.StatusOK)
+}
+
+func (s *SwiftHTTPSuite) TestBulkDelete(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	err := s.service.AddContainer("empty")
+	c.Assert(err, gc.IsNil)
+	s.ensureObject("test", "obj1", []byte("data1"), c)
+	s.ensureObject("test", "a b", []byte("data2"), c)
+	s.ensureObject("test", "kept", []byte("data3"), c)
+	defer s.removeObject("test", "kept", c)
+
+	body := "/test/obj1\ntest/a%20b\n/test/missing\nmissing/obj\n/empty\n/test\n"
+	resp := s.bulkDelete(c, body, http.Header{"Accept": {"application/json"}})
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "application/json; charset=utf-8")
+	var result bulkDeleteResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, gc.DeepEquals, bulkDeleteResponse{
+		NumberDeleted:  3,
+		NumberNotFound: 2,
+		ResponseStatus: "400 Bad Request",
+		Errors:         [][]string{{"/test", "409 Conflict"}},
+	})
+	s.ensureNotObject("test", "obj1", c)
+	s.ensureNotObject("test", "a b", c)
+	c.Assert(s.service.HasContainer("empty"), gc.Equals, false)
+	s.ensureObjectData("test", "kept", []byte("data3"), c)
+}
+
+func (s *SwiftHTTPSuite) TestBulkDeleteServerError(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj", []byte("data"), c)
+	defer s.removeObject("test", "obj", c)
+	cleanup := s.service.RegisterControlPoint("RemoveObject", func(sc hook.ServiceControl, args ...interface{}) error {
+		return fmt.Errorf("failed to remove %s", args[1])
+	})
+	resp := s.bulkDelete(c, "test/obj", http.Header{"Accept": {"application/json"}})
+	cleanup()
+	defer resp.Body.Close()
+	var result bulkDeleteResponse
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	c.Assert(err, gc.IsNil)
+	c.Assert(result, gc.DeepEquals, bulkDeleteResponse{
+		ResponseStatus: "502 Bad Gateway",
+		Errors:         [][]string{{"test/obj", "500 Internal Server Error"}},
+	})
+}
+
+func (s *SwiftHTTPSuite) TestBulkDeletePlainText(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj", []byte("data"), c)
+	resp := s.bulkDelete(c, "test/obj\ntest/missing", nil)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(resp.Header.Get("Content-Type"), gc.Equals, "text/plain; charset=utf-8")
+	c.Assert(string(body), gc.Equals, `Number Deleted: 1
+Number Not Found: 1
+Response Body: 
+Response Status: 200 OK
+Errors:
+`)
+	s.ensureNotObject("test", "obj", c)
+}
+
+func (s *SwiftHTTPSuite) TestBulkDeleteInvalid(c *gc.C) {
+	headers := http.Header{"Accept": {"application/json"}}
+	for _, test := range []struct {
+		body           string
+		responseStatus string
+		responseBody   string
+	}{{
+		body:           "\n",
+		responseStatus: "400 Bad Request",
+		responseBody:   "Invalid bulk delete.",
+	}, {
+		body:           strings.Repeat("test/obj\n", maxBulkDeletes+1),
+		responseStatus: "413 Request Entity Too Large",
+		responseBody:   "Maximum Bulk Deletes: 10000 per request",
+	}} {
+		resp := s.bulkDelete(c, test.body, headers)
+		var result bulkDeleteResponse
+		err := json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(result.ResponseStatus, gc.Equals, test.responseStatus)
+		c.Assert(result.ResponseBody, gc.Equals, test.responseBody)
+		c.Assert(result.NumberDeleted, gc.Equals, 0)
+	}
+}
+
+func (s *SwiftHTTPSuite) TestRemoveObjectMetadata(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	headers := http.Header{
+		"X-Object-Meta-Color": {"blue"},
+		"X-Object-Meta-Size":  {"large"},
+		"X-Object-Meta-Shape": {"round"},
+	}
+	resp := s.sendRequestWithHeaders(c, "PUT", "test/obj", nil, headers, []byte("test data"), http.StatusCreated)
+	resp.Body.Close()
+
+	// As in Swift, a POST replaces all the metadata, so the
+	// removed items are those given in the request.
+	headers = http.Header{
+		"X-Object-Meta-Color":        {"red"},
+		"X-Object-Meta-Size":         {""},
+		"X-Object-Meta-Shape":        {"square"},
+		"X-Remove-Object-Meta-Shape": {"x"},
+	}
+	resp = s.sendRequestWithHeaders(c, "POST", "test/obj", nil, headers, nil, http.StatusAccepted)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "GET", "test/obj", nil, http.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(body), gc.Equals, "test data")
+	info, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Color": "red"})
+	c.Assert(resp.Header.Get("X-Object-Meta-Color"), gc.Equals, "red")
+	c.Assert(resp.Header.Get("X-Object-Meta-Size"), gc.Equals, "")
+	c.Assert(resp.Header.Get("X-Object-Meta-Shape"), gc.Equals, "")
+}
+
+var conditionalRequestTests = []struct {
+	about    string
+	method   string
+	header   string
+	value    string
+	exists   bool
+	expected int
+}{{
+	about:    "PUT If-None-Match * with existing object",
+	method:   "PUT",
+	header:   "If-None-Match",
+	value:    "*",
+	exists:   true,
+	expected: http.StatusPreconditionFailed,
+}, {
+	about:    "PUT If-None-Match * with missing object",
+	method:   "PUT",
+	header:   "If-None-Match",
+	value:    "*",
+	expected: http.StatusCreated,
+}, {
+	about:    "PUT If-Match with current etag",
+	method:   "PUT",
+	header:   "If-Match",
+	value:    `"eb733a00c0c9d336e65691a37ab54293"`,
+	exists:   true,
+	expected: http.StatusCreated,
+}, {
+	about:    "PUT If-Match with stale etag",
+	method:   "PUT",
+	header:   "If-Match",
+	value:    "0123456789abcdef0123456789abcdef",
+	exists:   true,
+	expected: http.StatusPreconditionFailed,
+}, {
+	about:    "PUT If-Match with missing object",
+	method:   "PUT",
+	header:   "If-Match",
+	value:    "eb733a00c0c9d336e65691a37ab54293",
+	expected: http.StatusPreconditionFailed,
+}, {
+	about:    "GET If-None-Match with current etag",
+	method:   "GET",
+	header:   "If-None-Match",
+	value:    "0123456789abcdef0123456789abcdef, eb733a00c0c9d336e65691a37ab54293",
+	exists:   true,
+	expected: http.StatusNotModified,
+}, {
+	about:    "GET If-None-Match with stale etag",
+	method:   "GET",
+	header:   "If-None-Match",
+	value:    "0123456789abcdef0123456789abcdef",
+	exists:   true,
+	expected: http.StatusOK,
+}, {
+	about:    "HEAD If-None-Match *",
+	method:   "HEAD",
+	header:   "If-None-Match",
+	value:    "*",
+	exists:   true,
+	expected: http.StatusNotModified,
+}, {
+	about:    "GET If-Match with current etag",
+	method:   "GET",
+	header:   "If-Match",
+	value:    "eb733a00c0c9d336e65691a37ab54293",
+	exists:   true,
+	expected: http.StatusOK,
+}, {
+	about:    "GET If-Match with stale etag",
+	method:   "GET",
+	header:   "If-Match",
+	value:    "0123456789abcdef0123456789abcdef",
+	exists:   true,
+	expected: http.StatusPreconditionFailed,
+}}
+
+func (s *SwiftHTTPSuite) TestConditionalRequests(c *gc.C) {
+	data := []byte("test data")
+	newdata := []byte("new test data")
+	for i, t := range conditionalRequestTests {
+		c.Logf("test %d: %s", i, t.about)
+		s.ensureContainer("test", c)
+		if t.exists {
+			s.ensureObject("test", "obj", data, c)
+		}
+		var body []byte
+		if t.method == "PUT" {
+			body = newdata
+		}
+		headers := http.Header{t.header: {t.value}}
+		resp := s.sendRequestWithHeaders(c, t.method, "test/obj", nil, headers, body, t.expected)
+		resp.Body.Close()
+		switch {
+		case t.expected == http.StatusNotModified:
+			c.Assert(resp.Header.Get("ETag"), gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+		case t.method != "PUT":
+		case t.expected == http.StatusCreated:
+			s.ensureObjectData("test", "obj", newdata, c)
+		case t.exists:
+			s.ensureObjectData("test", "obj", data, c)
+		default:
+			s.ensureNotObject("test", "obj", c)
+		}
+		s.removeContainer("test", c)
+	}
+}
+
+var rangeRequestTests = []struct {
+	about        string
+	value        string
+	expected     int
+	body         string
+	contentRange string
+}{{
+	about:        "first and last bytes",
+	value:        "bytes=0-3",
+	expected:     http.StatusPartialContent,
+	body:         "test",
+	contentRange: "bytes 0-3/9",
+}, {
+	about:        "open ended range",
+	value:        "bytes=5-",
+	expected:     http.StatusPartialContent,
+	body:         "data",
+	contentRange: "bytes 5-8/9",
+}, {
+	about:        "suffix range",
+	value:        "bytes=-3",
+	expected:     http.StatusPartialContent,
+	body:         "ata",
+	contentRange: "bytes 6-8/9",
+}, {
+	about:        "last byte past the end",
+	value:        "bytes=5-100",
+	expected:     http.StatusPartialContent,
+	body:         "data",
+	contentRange: "bytes 5-8/9",
+}, {
+	about:        "suffix longer than the object",
+	value:        "bytes=-100",
+	expected:     http.StatusPartialContent,
+	body:         "test data",
+	contentRange: "bytes 0-8/9",
+}, {
+	about:        "first byte past the end",
+	value:        "bytes=9-",
+	expected:     http.StatusRequestedRangeNotSatisfiable,
+	body:         rangeNotSatisfiableResponse,
+	contentRange: "bytes */9",
+}, {
+	about:        "empty suffix",
+	value:        "bytes=-0",
+	expected:     http.StatusRequestedRangeNotSatisfiable,
+	body:         rangeNotSatisfiableResponse,
+	contentRange: "bytes */9",
+}, {
+	about:    "last byte before the first",
+	value:    "bytes=5-3",
+	expected: http.StatusOK,
+	body:     "test data",
+}, {
+	about:    "several ranges",
+	value:    "bytes=0-1,5-6",
+	expected: http.StatusOK,
+	body:     "test data",
+}, {
+	about:    "unknown unit",
+	value:    "lines=0-1",
+	expected: http.StatusOK,
+	body:     "test data",
+}}
+
+func (s *SwiftHTTPSuite) TestRangeRequests(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj", []byte("test data"), c)
+	defer s.removeObject("test", "obj", c)
+	for i, t := range rangeRequestTests {
+		c.Logf("test %d: %s", i, t.about)
+		headers := http.Header{"Range": {t.value}}
+		resp := s.sendRequestWithHeaders(c, "GET", "test/obj", nil, headers, nil, t.expected)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Check(string(body), gc.Equals, t.body)
+		c.Check(resp.Header.Get("Content-Range"), gc.Equals, t.contentRange)
+		c.Check(resp.Header.Get("Content-Length"), gc.Equals, strconv.Itoa(len(t.body)))
+		c.Check(resp.Header.Get("Accept-Ranges"), gc.Equals, "bytes")
+	}
+}
+
+func (s *SwiftHTTPSuite) TestOptions(c *gc.C) {
+	s.service.SetCORSPolicy(&testservices.CORSPolicy{AllowedOrigins: []string{"http://app.example.com"}})
+	defer s.service.SetCORSPolicy(nil)
+	headers := http.Header{
+		"Origin":                        {"http://app.example.com"},
+		"Access-Control-Request-Method": {"PUT"},
+	}
+	resp := s.sendRequestWithHeaders(c, "OPTIONS", "test/obj", nil, headers, nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, HEAD, POST, PUT, DELETE, COPY, OPTIONS")
+	c.Assert(resp.Header.Get("Access-Control-Allow-Origin"), gc.Equals, "http://app.example.com")
+	c.Assert(resp.Header.Get("Access-Control-Allow-Methods"), gc.Equals, "GET, HEAD, POST, PUT, DELETE, COPY")
+}
+
+func (s *SwiftHTTPSuite) TestMethodNotAllowed(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	for _, path := range []string{"test", "test/obj", "missing"} {
+		resp := s.sendRequest(c, "PATCH", path, nil, http.StatusMethodNotAllowed)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, gc.IsNil)
+		c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, HEAD, POST, PUT, DELETE, COPY")
+		c.Assert(string(body), gc.Equals, methodNotAllowedResponse)
+	}
+}
+
 func (s *SwiftHTTPSuite) TestUnauthorizedFails(c *gc.C) {
 	oldtoken := s.token
 	defer func() {
@@ -363,6 +1263,100 @@ func (s *SwiftHTTPSuite) TestUnauthorizedFails(c *gc.C) {
 	s.sendRequest(c, "DELETE", "test", nil, http.StatusUnauthorized)
 }
 
+func (s *SwiftHTTPSuite) TestOtherTenantForbidden(c *gc.C) {
+	oldtoken := s.token
+	defer func() {
+		s.token = oldtoken
+	}()
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("other", "secret", "other-tenant")
+	s.token = other.Token
+	s.sendRequest(c, "PUT", "test", nil, http.StatusForbidden)
+	s.sendRequest(c, "GET", "", nil, http.StatusForbidden)
+	c.Assert(s.service.HasContainer("test"), gc.Equals, false)
+
+	admin := identityDouble.AddUser("admin", "secret", "admin-tenant")
+	err := identityDouble.SetUserTenant("admin", admin.TenantId, "admin-tenant", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	s.token = admin.Token
+	s.sendRequest(c, "GET", "", nil, http.StatusOK)
+}
+
+// tempURLParams returns the query parameters of a TempURL for path,
+// signed with key as Swift signs them.
+func (s *SwiftHTTPSuite) tempURLParams(key, method, path string, expires time.Time) map[string]string {
+	URL, err := url.Parse(s.service.endpointURL(path))
+	if err != nil {
+		panic(err)
+	}
+	expiresUnix := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(method + "\n" + expiresUnix + "\n" + URL.Path))
+	return map[string]string{
+		"temp_url_sig":     hex.EncodeToString(mac.Sum(nil)),
+		"temp_url_expires": expiresUnix,
+	}
+}
+
+func (s *SwiftHTTPSuite) TestTempURL(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj", []byte("test data"), c)
+	s.service.SetTempURLKey("secret")
+	defer s.service.SetTempURLKey("")
+	oldtoken := s.token
+	defer func() {
+		s.token = oldtoken
+	}()
+	s.token = ""
+
+	expires := time.Now().Add(time.Hour)
+	params := s.tempURLParams("secret", "GET", "test/obj", expires)
+	resp := s.sendRequestWithParams(c, "GET", "test/obj", params, nil, http.StatusOK)
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(body), gc.Equals, "test data")
+	// A link for GET may also be used for HEAD.
+	resp = s.sendRequestWithParams(c, "HEAD", "test/obj", params, nil, http.StatusOK)
+	resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Length"), gc.Equals, "9")
+	// But not to change the object.
+	resp = s.sendRequestWithParams(c, "DELETE", "test/obj", params, nil, http.StatusUnauthorized)
+	resp.Body.Close()
+}
+
+func (s *SwiftHTTPSuite) TestTempURLInvalid(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj", []byte("test data"), c)
+	s.service.SetTempURLKey("secret")
+	defer s.service.SetTempURLKey("")
+
+	expires := time.Now().Add(time.Hour)
+	for i, params := range []map[string]string{
+		s.tempURLParams("wrong", "GET", "test/obj", expires),
+		s.tempURLParams("secret", "GET", "test/other", expires),
+		s.tempURLParams("secret", "PUT", "test/obj", expires),
+		s.tempURLParams("secret", "GET", "test/obj", time.Now().Add(-time.Second)),
+		{"temp_url_sig": s.tempURLParams("secret", "GET", "test/obj", expires)["temp_url_sig"]},
+		{"temp_url_sig": "bad", "temp_url_expires": "never"},
+	} {
+		c.Logf("test %d: %v", i, params)
+		// The suite's token does not make up for a bad link.
+		resp := s.sendRequestWithParams(c, "GET", "test/obj", params, nil, http.StatusUnauthorized)
+		resp.Body.Close()
+	}
+
+	// Links are rejected when no key is set.
+	params := s.tempURLParams("", "GET", "test/obj", expires)
+	s.service.SetTempURLKey("")
+	resp := s.sendRequestWithParams(c, "GET", "test/obj", params, nil, http.StatusUnauthorized)
+	resp.Body.Close()
+}
+
 func (s *SwiftHTTPSSuite) SetUpSuite(c *gc.C) {
 	s.HTTPSuite.SetUpSuite(c)
 	identityDouble := identityservice.NewUserPass()
@@ -389,3 +1383,89 @@ func (s *SwiftHTTPSSuite) TestHasHTTPSServiceURL(c *gc.C) {
 	endpoints := s.service.Endpoints()
 	c.Assert(endpoints[0].PublicURL[:8], gc.Equals, "https://")
 }
+
+func (s *SwiftHTTPSuite) TestAccountUsage(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj1", []byte("hello"), c)
+	s.ensureObject("test", "obj2", []byte("world!"), c)
+
+	resp := s.sendRequest(c, "HEAD", "", nil, http.StatusNoContent)
+	resp.Body.Close()
+	c.Check(resp.Header.Get("X-Account-Container-Count"), gc.Equals, "1")
+	c.Check(resp.Header.Get("X-Account-Object-Count"), gc.Equals, "2")
+	c.Check(resp.Header.Get("X-Account-Bytes-Used"), gc.Equals, "11")
+
+	resp = s.sendRequestWithParams(c, "GET", "", map[string]string{"format": "json"}, nil, http.StatusOK)
+	defer resp.Body.Close()
+	c.Check(resp.Header.Get("X-Account-Object-Count"), gc.Equals, "2")
+	var containers []struct {
+		Name  string
+		Count int
+		Bytes int
+	}
+	err := json.NewDecoder(resp.Body).Decode(&containers)
+	c.Assert(err, gc.IsNil)
+	c.Assert(containers, gc.HasLen, 1)
+	c.Check(containers[0].Name, gc.Equals, "test")
+	c.Check(containers[0].Count, gc.Equals, 2)
+	c.Check(containers[0].Bytes, gc.Equals, 11)
+}
+
+func (s *SwiftHTTPSuite) TestAccountMetadata(c *gc.C) {
+	defer s.service.SetAccountMetadata(map[string]string{"Color": "", "Size": ""})
+	resp := s.sendRequestWithHeaders(c, "POST", "", nil, http.Header{
+		"X-Account-Meta-Color": {"blue"},
+		"X-Account-Meta-Size":  {"large"},
+	}, nil, http.StatusNoContent)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "HEAD", "", nil, http.StatusNoContent)
+	resp.Body.Close()
+	c.Check(resp.Header.Get("X-Account-Meta-Color"), gc.Equals, "blue")
+	c.Check(resp.Header.Get("X-Account-Meta-Size"), gc.Equals, "large")
+
+	// Items not given are kept.
+	resp = s.sendRequestWithHeaders(c, "POST", "", nil, http.Header{
+		"X-Remove-Account-Meta-Size": {"x"},
+		"X-Account-Meta-Color":       {"red"},
+	}, nil, http.StatusNoContent)
+	resp.Body.Close()
+	c.Assert(s.service.GetAccountInfo().Metadata, gc.DeepEquals, map[string]string{"Color": "red"})
+}
+
+func (s *SwiftHTTPSuite) TestAccountMetadataTempURLKey(c *gc.C) {
+	defer s.service.SetTempURLKey("")
+	resp := s.sendRequestWithHeaders(c, "POST", "", nil, http.Header{
+		"X-Account-Meta-Temp-URL-Key": {"secret"},
+	}, nil, http.StatusNoContent)
+	resp.Body.Close()
+	c.Assert(s.service.getTempURLKey(), gc.Equals, "secret")
+}
+
+func (s *SwiftHTTPSuite) TestAccountQuota(c *gc.C) {
+	s.ensureContainer("test", c)
+	defer s.removeContainer("test", c)
+	s.ensureObject("test", "obj", []byte("12345"), c)
+	resp := s.sendRequestWithHeaders(c, "POST", "", nil, http.Header{
+		"X-Account-Meta-Quota-Bytes": {"10"},
+	}, nil, http.StatusNoContent)
+	resp.Body.Close()
+	defer s.service.SetAccountMetadata(map[string]string{"Quota-Bytes": ""})
+
+	resp = s.sendRequest(c, "PUT", "test/other", []byte("123456"), http.StatusRequestEntityTooLarge)
+	resp.Body.Close()
+	s.ensureNotObject("test", "other", c)
+	// Replacing an object only counts the change in size.
+	resp = s.sendRequest(c, "PUT", "test/obj", []byte("0123456789"), http.StatusCreated)
+	resp.Body.Close()
+	resp = s.sendRequest(c, "PUT", "test/other", []byte("x"), http.StatusRequestEntityTooLarge)
+	resp.Body.Close()
+}
+
+func (s *SwiftHTTPSuite) TestAccountInvalidQuota(c *gc.C) {
+	resp := s.sendRequestWithHeaders(c, "POST", "", nil, http.Header{
+		"X-Account-Meta-Quota-Bytes": {"lots"},
+	}, nil, http.StatusBadRequest)
+	resp.Body.Close()
+	c.Assert(s.service.GetAccountInfo().Metadata, gc.HasLen, 0)
+}
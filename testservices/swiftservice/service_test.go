@@ -4,8 +4,11 @@ package swiftservice
 
 import (
 	"fmt"
+	"strings"
 
 	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/swift"
 )
 
 type SwiftServiceSuite struct {
@@ -59,6 +62,25 @@ func (s *SwiftServiceSuite) TestAddGetRemoveObject(c *gc.C) {
 	c.Assert(ok, gc.Equals, false)
 }
 
+func (s *SwiftServiceSuite) TestGetObjectInfo(c *gc.C) {
+	_, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.ErrorMatches, `no such object "obj" in container "test"`)
+	err = s.service.AddObject("test", "obj", []byte("test data"))
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddObjectWithContentType("test", "text", []byte("test data"), "text/plain")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	info, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ETag, gc.Equals, "eb733a00c0c9d336e65691a37ab54293")
+	c.Assert(info.ContentType, gc.Equals, "application/octet-stream")
+	c.Assert(info.LengthBytes, gc.Equals, 9)
+	c.Assert(info.LastModified.IsZero(), gc.Equals, false)
+	info, err = s.service.GetObjectInfo("test", "text")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+}
+
 func (s *SwiftServiceSuite) TestRemoveContainerWithObjects(c *gc.C) {
 	ok := s.service.HasContainer("test")
 	c.Assert(ok, gc.Equals, false)
@@ -116,3 +138,278 @@ func (s *SwiftServiceSuite) TestListContainerWithPrefix(c *gc.C) {
 	err = s.service.RemoveContainer("test")
 	c.Assert(err, gc.IsNil)
 }
+
+func (s *SwiftServiceSuite) TestListContainerWithDelimiter(c *gc.C) {
+	data := []byte("test data")
+	for _, name := range []string{"b", "a/2", "a/1/x", "c/3", "a/1/y", "a"} {
+		err := s.service.AddObject("test", name, data)
+		c.Assert(err, gc.IsNil)
+	}
+	defer s.service.RemoveContainer("test")
+	names := func(contents []swift.ContainerContents) []string {
+		var names []string
+		for _, item := range contents {
+			if item.Subdir != "" {
+				c.Check(item.Name, gc.Equals, "")
+				names = append(names, "subdir:"+item.Subdir)
+			} else {
+				names = append(names, item.Name)
+			}
+		}
+		return names
+	}
+	for i, t := range []struct {
+		params   map[string]string
+		expected []string
+	}{{
+		params:   map[string]string{"delimiter": "/"},
+		expected: []string{"a", "subdir:a/", "b", "subdir:c/"},
+	}, {
+		params:   map[string]string{"prefix": "a/", "delimiter": "/"},
+		expected: []string{"subdir:a/1/", "a/2"},
+	}, {
+		params:   map[string]string{"prefix": "a/1/", "delimiter": "/"},
+		expected: []string{"a/1/x", "a/1/y"},
+	}, {
+		params:   map[string]string{"marker": "a/1/x"},
+		expected: []string{"a/1/y", "a/2", "b", "c/3"},
+	}, {
+		params:   map[string]string{"limit": "2"},
+		expected: []string{"a", "a/1/x"},
+	}, {
+		params:   map[string]string{"delimiter": "/", "marker": "a/", "limit": "1"},
+		expected: []string{"b"},
+	}, {
+		params:   map[string]string{"limit": "0"},
+		expected: nil,
+	}} {
+		c.Logf("test %d: %v", i, t.params)
+		contents, err := s.service.ListContainer("test", t.params)
+		c.Assert(err, gc.IsNil)
+		c.Check(names(contents), gc.DeepEquals, t.expected)
+	}
+}
+
+func (s *SwiftServiceSuite) TestListContainerInvalidLimit(c *gc.C) {
+	err := s.service.AddContainer("test")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	_, err = s.service.ListContainer("test", map[string]string{"limit": "lots"})
+	c.Assert(err, gc.ErrorMatches, `invalid limit "lots"`)
+}
+
+func (s *SwiftServiceSuite) TestGetAccountInfo(c *gc.C) {
+	err := s.service.AddObject("test", "obj", []byte("test data"))
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	err = s.service.AddContainer("empty")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("empty")
+	err = s.service.SetAccountMetadata(map[string]string{"Color": "blue"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.service.GetAccountInfo(), gc.DeepEquals, &AccountInfo{
+		ContainerCount: 2,
+		ObjectCount:    1,
+		BytesUsed:      9,
+		Metadata:       map[string]string{"Color": "blue"},
+	})
+}
+
+func (s *SwiftServiceSuite) TestManifestObject(c *gc.C) {
+	err := s.service.AddObject("segments", "big/002", []byte("large "))
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("segments")
+	err = s.service.AddObject("segments", "big/003", []byte("world"))
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddObject("segments", "big/001", []byte("hello "))
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddObject("segments", "other", []byte("not a segment"))
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddManifestObject("test", "big", "segments/big/", "text/plain")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	data, err := s.service.GetObject("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello large world")
+	info, err := s.service.GetObjectInfo("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Manifest, gc.Equals, "segments/big/")
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+	c.Assert(info.LengthBytes, gc.Equals, 17)
+	// The ETag is the MD5 checksum of the segments' ETags.
+	c.Assert(info.ETag, gc.Equals, "0cd747d6bbe1dceef0f7598ef1a82e05")
+}
+
+func (s *SwiftServiceSuite) TestManifestObjectWithoutSegments(c *gc.C) {
+	err := s.service.AddManifestObject("test", "big", "test/big", "")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	// The manifest matches its own name, but is not a segment.
+	data, err := s.service.GetObject("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(data, gc.HasLen, 0)
+	info, err := s.service.GetObjectInfo("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ContentType, gc.Equals, "application/octet-stream")
+	c.Assert(info.ETag, gc.Equals, "d41d8cd98f00b204e9800998ecf8427e")
+	// Segments may be uploaded after the manifest.
+	err = s.service.AddObject("test", "big/1", []byte("data"))
+	c.Assert(err, gc.IsNil)
+	data, err = s.service.GetObject("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "data")
+}
+
+func (s *SwiftServiceSuite) TestStaticManifestObject(c *gc.C) {
+	err := s.service.AddObject("segments", "2", []byte("large "))
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("segments")
+	err = s.service.AddObject("segments", "1", []byte("world"))
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddObject("test", "hello", []byte("hello "))
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	// Segments are taken in the order given, from any container.
+	segments := []string{"test/hello", "segments/2", "segments/1"}
+	err = s.service.AddStaticManifestObject("test", "big", segments, "text/plain")
+	c.Assert(err, gc.IsNil)
+	data, err := s.service.GetObject("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello large world")
+	info, err := s.service.GetObjectInfo("test", "big")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Segments, gc.DeepEquals, segments)
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+	c.Assert(info.LengthBytes, gc.Equals, 17)
+	c.Assert(info.ETag, gc.Equals, "0cd747d6bbe1dceef0f7598ef1a82e05")
+
+	// Static large objects may be segments of others.
+	err = s.service.AddStaticManifestObject("test", "bigger", []string{"test/big", "test/hello"}, "")
+	c.Assert(err, gc.IsNil)
+	data, err = s.service.GetObject("test", "bigger")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "hello large worldhello ")
+}
+
+func (s *SwiftServiceSuite) TestStaticManifestObjectMissingSegment(c *gc.C) {
+	err := s.service.AddObject("test", "1", []byte("data"))
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	err = s.service.AddStaticManifestObject("test", "big", []string{"test/1", "test/2"}, "")
+	c.Assert(err, gc.ErrorMatches, `no such object "2" in container "test"`)
+	err = s.service.AddStaticManifestObject("test", "big", nil, "")
+	c.Assert(err, gc.ErrorMatches, `static large object "big" has no segments`)
+	_, err = s.service.GetObject("test", "big")
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *SwiftServiceSuite) TestCopyObject(c *gc.C) {
+	err := s.service.CopyObject("test", "obj", "test", "copy")
+	c.Assert(err, gc.ErrorMatches, `no such object "obj" in container "test"`)
+	err = s.service.AddObjectWithContentType("test", "obj", []byte("test data"), "text/plain")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	err = s.service.SetObjectMetadata("test", "obj", map[string]string{"Color": "blue"})
+	c.Assert(err, gc.IsNil)
+	err = s.service.CopyObject("test", "obj", "test", "copy")
+	c.Assert(err, gc.IsNil)
+	data, err := s.service.GetObject("test", "copy")
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "test data")
+	src, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	info, err := s.service.GetObjectInfo("test", "copy")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.ETag, gc.Equals, src.ETag)
+	c.Assert(info.ContentType, gc.Equals, "text/plain")
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Color": "blue"})
+
+	err = s.service.CopyObject("test", "missing", "test", "copy")
+	c.Assert(err, gc.ErrorMatches, `no such object "missing" in container "test"`)
+	err = s.service.CopyObject("test", "obj", "missing", "copy")
+	c.Assert(err, gc.ErrorMatches, `no such container "missing"`)
+}
+
+func (s *SwiftServiceSuite) TestSetObjectMetadata(c *gc.C) {
+	err := s.service.SetObjectMetadata("test", "obj", map[string]string{"Color": "blue"})
+	c.Assert(err, gc.ErrorMatches, `no such object "obj" in container "test"`)
+	err = s.service.AddObject("test", "obj", []byte("test data"))
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	info, err := s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Metadata, gc.HasLen, 0)
+	metadata := map[string]string{"Color": "blue"}
+	err = s.service.SetObjectMetadata("test", "obj", metadata)
+	c.Assert(err, gc.IsNil)
+	// The service keeps its own copy of the metadata.
+	metadata["Color"] = "red"
+	info, err = s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Color": "blue"})
+	info.Metadata["Color"] = "green"
+	info, err = s.service.GetObjectInfo("test", "obj")
+	c.Assert(err, gc.IsNil)
+	c.Assert(info.Metadata, gc.DeepEquals, map[string]string{"Color": "blue"})
+}
+
+func (s *SwiftServiceSuite) TestReset(c *gc.C) {
+	service := New(hostname, versionPath, tenantId, region, nil)
+	err := service.AddContainer("test")
+	c.Assert(err, gc.IsNil)
+	err = service.AddObject("test", "obj", []byte("test data"))
+	c.Assert(err, gc.IsNil)
+	service.SetTempURLKey("key")
+	service.Reset()
+	c.Assert(service.HasContainer("test"), gc.Equals, false)
+	c.Assert(service.getTempURLKey(), gc.Equals, "")
+	c.Assert(service.accountMetadata, gc.HasLen, 0)
+	// The service may be used as before.
+	err = service.AddContainer("test")
+	c.Assert(err, gc.IsNil)
+	_, err = service.GetObject("test", "obj")
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *SwiftServiceSuite) TestSetVersionsLocation(c *gc.C) {
+	err := s.service.SetVersionsLocation("test", "versions")
+	c.Assert(err, gc.ErrorMatches, `no such container "test"`)
+	err = s.service.AddContainer("test")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("test")
+	err = s.service.SetVersionsLocation("test", "versions/sub")
+	c.Assert(err, gc.ErrorMatches, `invalid versions location "versions/sub"`)
+	err = s.service.SetVersionsLocation("test", "versions")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.service.versionsLocation("test"), gc.Equals, "versions")
+
+	// Copies over existing objects are archived.
+	err = s.service.AddContainer("versions")
+	c.Assert(err, gc.IsNil)
+	defer s.service.RemoveContainer("versions")
+	err = s.service.AddObject("test", "a", []byte("old"))
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddObject("test", "b", []byte("new"))
+	c.Assert(err, gc.IsNil)
+	err = s.service.CopyObject("test", "b", "test", "a")
+	c.Assert(err, gc.IsNil)
+	contents, err := s.service.ListContainer("versions", map[string]string{"prefix": versionsPrefix("a")})
+	c.Assert(err, gc.IsNil)
+	c.Assert(contents, gc.HasLen, 1)
+	data, err := s.service.GetObject("versions", contents[0].Name)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(data), gc.Equals, "old")
+
+	// Removing a container stops versioning it.
+	err = s.service.RemoveContainer("test")
+	c.Assert(err, gc.IsNil)
+	err = s.service.AddContainer("test")
+	c.Assert(err, gc.IsNil)
+	c.Assert(s.service.versionsLocation("test"), gc.Equals, "")
+}
+
+func (s *SwiftServiceSuite) TestVersionsPrefix(c *gc.C) {
+	c.Assert(versionsPrefix("obj"), gc.Equals, "003obj/")
+	c.Assert(versionsPrefix("a/b/c"), gc.Equals, "005a/b/c/")
+	c.Assert(versionsPrefix(strings.Repeat("x", 300)), gc.Equals, "12c"+strings.Repeat("x", 300)+"/")
+}
@@ -0,0 +1,47 @@
+package testservices
+
+// tokenRevoker is implemented by identity services which can revoke
+// the tokens they have issued.
+type tokenRevoker interface {
+	RevokeToken(token string)
+}
+
+// ExpireTokenOnce arranges for the next request to the service made
+// with the given token to be rejected with a 401, as if the token had
+// expired since the client last used it. The token is revoked with
+// the identity service at the same time, so the client must
+// re-authenticate, which issues it a fresh token that the service
+// accepts. This allows a client's transparent re-authentication and
+// retry to be tested. The returned function cancels the expiry if it
+// has not yet happened.
+func (s *ServiceInstance) ExpireTokenOnce(token string) func() {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	if s.expiringTokens == nil {
+		s.expiringTokens = make(map[string]bool)
+	}
+	s.expiringTokens[token] = true
+	return func() {
+		s.expiryMu.Lock()
+		defer s.expiryMu.Unlock()
+		delete(s.expiringTokens, token)
+	}
+}
+
+// TokenExpired reports whether a request made with the given token
+// should be rejected because the token was passed to ExpireTokenOnce.
+// Only the first such request is reported; the token is revoked with
+// the identity service, if it supports revocation, so later requests
+// with it fail to find a user as usual.
+func (s *ServiceInstance) TokenExpired(token string) bool {
+	s.expiryMu.Lock()
+	defer s.expiryMu.Unlock()
+	if !s.expiringTokens[token] {
+		return false
+	}
+	delete(s.expiringTokens, token)
+	if revoker, ok := s.IdentityService.(tokenRevoker); ok {
+		revoker.RevokeToken(token)
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package testservices
+
+import (
+	"bufio"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// RequestIdHeader is the header in which OpenStack services report the
+// id of each request, which clients may also send to choose the id.
+const RequestIdHeader = "X-Openstack-Request-Id"
+
+// A TraceEntry records a request handled by the service doubles.
+type TraceEntry struct {
+	// Service names the service double which handled the request.
+	Service   string
+	Method    string
+	Path      string
+	Status    int
+	RequestId string
+}
+
+// Tracer is an http.Handler which gives each request passed to the
+// handler it wraps a request id, as OpenStack services do, and can
+// record the requests so that tests may check the sequence of calls
+// a client made. A request's id is taken from its
+// X-Openstack-Request-Id header, or generated if it has none, and is
+// reported in the same header of the response. Like a FaultInjector,
+// it may wrap the mux shared by the service doubles.
+type Tracer struct {
+	handler     http.Handler
+	serviceName func(r *http.Request) string
+
+	mu        sync.Mutex // protects recording and entries
+	recording bool
+	entries   []TraceEntry
+}
+
+// NewTracer returns a Tracer which passes requests on to handler,
+// calling serviceName to find the name of the service handling each
+// one.
+func NewTracer(handler http.Handler, serviceName func(r *http.Request) string) *Tracer {
+	return &Tracer{handler: handler, serviceName: serviceName}
+}
+
+// StartTrace discards any requests already recorded and starts
+// recording requests. Requests are not recorded until it is called.
+func (t *Tracer) StartTrace() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recording = true
+	t.entries = nil
+}
+
+// StopTrace stops recording requests. The requests already recorded
+// are kept.
+func (t *Tracer) StopTrace() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recording = false
+}
+
+// Trace returns the requests recorded since StartTrace was called, in
+// the order their responses were completed.
+func (t *Tracer) Trace() []TraceEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entries := make([]TraceEntry, len(t.entries))
+	copy(entries, t.entries)
+	return entries
+}
+
+func (t *Tracer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Header.Get(RequestIdHeader)
+	if requestId == "" {
+		requestId = NewRequestID()
+		r.Header.Set(RequestIdHeader, requestId)
+	}
+	w.Header().Set(RequestIdHeader, requestId)
+	tw := &traceResponseWriter{ResponseWriter: w}
+	t.handler.ServeHTTP(tw, r)
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.recording {
+		return
+	}
+	var service string
+	if t.serviceName != nil {
+		service = t.serviceName(r)
+	}
+	t.entries = append(t.entries, TraceEntry{
+		Service:   service,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Status:    tw.code,
+		RequestId: requestId,
+	})
+}
+
+// NewRequestID returns a random request id in the form used by
+// OpenStack, "req-" followed by a UUID.
+func NewRequestID() string {
+	uuid := make([]byte, 16)
+	if _, err := rand.Read(uuid); err != nil {
+		panic(err)
+	}
+	return "req-" + formatUUID(uuid)
+}
+
+// traceResponseWriter records the status of the response written to
+// it.
+type traceResponseWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *traceResponseWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *traceResponseWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Hijack allows handlers which reset connections, such as a
+// FaultInjector, to be wrapped.
+func (w *traceResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
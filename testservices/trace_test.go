@@ -0,0 +1,89 @@
+package testservices
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	gc "gopkg.in/check.v1"
+)
+
+type TracerSuite struct {
+	server *httptest.Server
+	tracer *Tracer
+	// received holds the request id seen by the wrapped handler.
+	received string
+}
+
+var _ = gc.Suite(&TracerSuite{})
+
+func (s *TracerSuite) SetUpTest(c *gc.C) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.received = r.Header.Get(RequestIdHeader)
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+	s.tracer = NewTracer(handler, func(r *http.Request) string {
+		return "test"
+	})
+	s.server = httptest.NewServer(s.tracer)
+	s.received = ""
+}
+
+func (s *TracerSuite) TearDownTest(c *gc.C) {
+	s.server.Close()
+}
+
+func (s *TracerSuite) do(c *gc.C, method, path, requestId string) *http.Response {
+	req, err := http.NewRequest(method, s.server.URL+path, nil)
+	c.Assert(err, gc.IsNil)
+	if requestId != "" {
+		req.Header.Set(RequestIdHeader, requestId)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	resp.Body.Close()
+	return resp
+}
+
+func (s *TracerSuite) TestRequestIdGenerated(c *gc.C) {
+	resp := s.do(c, "GET", "/foo", "")
+	requestId := resp.Header.Get(RequestIdHeader)
+	c.Assert(strings.HasPrefix(requestId, "req-"), gc.Equals, true, gc.Commentf("%q", requestId))
+	c.Assert(requestId, gc.HasLen, len("req-")+36)
+	c.Assert(s.received, gc.Equals, requestId)
+	resp = s.do(c, "GET", "/foo", "")
+	c.Assert(resp.Header.Get(RequestIdHeader), gc.Not(gc.Equals), requestId)
+}
+
+func (s *TracerSuite) TestNewRequestID(c *gc.C) {
+	id := NewRequestID()
+	c.Assert(id, gc.Matches, "req-[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}")
+	c.Assert(NewRequestID(), gc.Not(gc.Equals), id)
+}
+
+func (s *TracerSuite) TestRequestIdEchoed(c *gc.C) {
+	resp := s.do(c, "GET", "/foo", "req-mine")
+	c.Assert(resp.Header.Get(RequestIdHeader), gc.Equals, "req-mine")
+	c.Assert(s.received, gc.Equals, "req-mine")
+}
+
+func (s *TracerSuite) TestTrace(c *gc.C) {
+	s.do(c, "GET", "/before", "")
+	c.Assert(s.tracer.Trace(), gc.HasLen, 0)
+	s.tracer.StartTrace()
+	s.do(c, "GET", "/foo", "req-1")
+	s.do(c, "DELETE", "/foo/bar", "req-2")
+	c.Assert(s.tracer.Trace(), gc.DeepEquals, []TraceEntry{
+		{Service: "test", Method: "GET", Path: "/foo", Status: http.StatusOK, RequestId: "req-1"},
+		{Service: "test", Method: "DELETE", Path: "/foo/bar", Status: http.StatusNoContent, RequestId: "req-2"},
+	})
+	s.tracer.StopTrace()
+	s.do(c, "GET", "/after", "")
+	c.Assert(s.tracer.Trace(), gc.HasLen, 2)
+	s.tracer.StartTrace()
+	c.Assert(s.tracer.Trace(), gc.HasLen, 0)
+}
@@ -0,0 +1,495 @@
+// Cinder double testing service - internal direct API implementation
+
+package volumeservice
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/goose.v1/cinder"
+	"gopkg.in/goose.v1/testservices"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+var _ testservices.HttpService = (*Cinder)(nil)
+var _ identityservice.ServiceProvider = (*Cinder)(nil)
+
+// Volume and snapshot status values used by the double.
+const (
+	StatusCreating  = "creating"
+	StatusAvailable = "available"
+	StatusInUse     = "in-use"
+	StatusError     = "error"
+)
+
+// Attachment records the attachment of a volume to a server. It is
+// stored in the Attachments field of cinder.Volume.
+type Attachment struct {
+	Id       string `json:"id"`
+	VolumeId string `json:"volume_id"`
+	ServerId string `json:"server_id"`
+	Device   string `json:"device"`
+}
+
+// Cinder implements a OpenStack Cinder (v2) testing service and
+// contains the service double's internal state.
+type Cinder struct {
+	testservices.ServiceInstance
+
+	mu             sync.Mutex // protects the remaining fields
+	volumes        map[string]cinder.Volume
+	nextVolumeId   int
+	snapshots      map[string]cinder.Snapshot
+	nextSnapshotId int
+}
+
+// New creates an instance of the Cinder object, given the parameters.
+func New(hostURL, versionPath, tenantId, region string, identityService identityservice.IdentityService) *Cinder {
+	URL, err := url.Parse(hostURL)
+	if err != nil {
+		panic(err)
+	}
+	hostname := URL.Host
+	if !strings.HasSuffix(hostname, "/") {
+		hostname += "/"
+	}
+	cinderService := &Cinder{
+		volumes:   make(map[string]cinder.Volume),
+		snapshots: make(map[string]cinder.Snapshot),
+		ServiceInstance: testservices.ServiceInstance{
+			IdentityService: identityService,
+			Scheme:          URL.Scheme,
+			Hostname:        hostname,
+			VersionPath:     versionPath,
+			TenantId:        tenantId,
+			Region:          region,
+		},
+	}
+	if identityService != nil {
+		identityService.RegisterServiceProvider("cinderv2", "volumev2", cinderService)
+	}
+	return cinderService
+}
+
+// endpointURL returns a versioned service endpoint URL from the given
+// path.
+func (c *Cinder) endpointURL(path string) string {
+	ep := c.Scheme + "://" + c.Hostname + c.VersionPath + "/" + c.TenantId
+	if path != "" {
+		ep += "/" + strings.TrimLeft(path, "/")
+	}
+	return ep
+}
+
+// Reset removes all volumes and snapshots, and restarts the numbering
+// of their ids, along with everything set with the ServiceInstance
+// methods (see ServiceInstance.Reset). The exported fields are
+// preserved.
+func (c *Cinder) Reset() {
+	c.ServiceInstance.Reset()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.volumes = make(map[string]cinder.Volume)
+	c.snapshots = make(map[string]cinder.Snapshot)
+	c.nextVolumeId = 0
+	c.nextSnapshotId = 0
+}
+
+func (c *Cinder) Endpoints() []identityservice.Endpoint {
+	ep := identityservice.Endpoint{
+		AdminURL:    c.endpointURL(""),
+		InternalURL: c.endpointURL(""),
+		PublicURL:   c.endpointURL(""),
+		Region:      c.Region,
+	}
+	return []identityservice.Endpoint{ep}
+}
+
+// newVolume builds a volume in the "creating" state from the given
+// creation parameters, allocating it a new id.
+func (c *Cinder) newVolume(args cinder.CreateVolumeVolumeParams) cinder.Volume {
+	c.mu.Lock()
+	id := c.NewSequentialID(testservices.ResourceVolume, &c.nextVolumeId)
+	c.mu.Unlock()
+	volume := cinder.Volume{
+		ID:                          id,
+		Name:                        args.Name,
+		Description:                 args.Description,
+		Size:                        args.Size,
+		AvailabilityZone:            args.AvailabilityZone,
+		VolumeType:                  args.VolumeType,
+		Bootable:                    strconv.FormatBool(args.Bootable),
+		Status:                      StatusCreating,
+		CreatedAt:                   time.Now().UTC().Format("2006-01-02T15:04:05.000000"),
+		Attachments:                 []interface{}{},
+		Os_Vol_Tenant_Attr_TenantID: c.TenantId,
+	}
+	if args.SnapshotId != "" {
+		volume.SnapshotID = args.SnapshotId
+	}
+	if args.SourceVolid != "" {
+		volume.SourceVolid = args.SourceVolid
+	}
+	url := "/volumes/" + id
+	volume.Links = append(volume.Links,
+		struct {
+			Href string `json:"href"`
+			Rel  string `json:"rel"`
+		}{c.endpointURL(url), "self"},
+	)
+	return volume
+}
+
+// addVolume stores a new volume.
+func (c *Cinder) addVolume(volume cinder.Volume) error {
+	if err := c.ProcessFunctionHook(c, &volume); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.volumes[volume.ID]; ok {
+		return testservices.NewVolumeAlreadyExistsError(volume.ID)
+	}
+	c.volumes[volume.ID] = volume
+	return nil
+}
+
+// volume retrieves an existing volume by id.
+func (c *Cinder) volume(volumeId string) (*cinder.Volume, error) {
+	if err := c.ProcessFunctionHook(c, volumeId); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	volume, ok := c.volumes[volumeId]
+	c.mu.Unlock()
+	if !ok {
+		return nil, testservices.NewVolumeNotFoundError(volumeId)
+	}
+	return &volume, nil
+}
+
+type volumesById []cinder.Volume
+
+func (v volumesById) Len() int {
+	return len(v)
+}
+
+func (v volumesById) Less(i, j int) bool {
+	return v[i].ID < v[j].ID
+}
+
+func (v volumesById) Swap(i, j int) {
+	v[i], v[j] = v[j], v[i]
+}
+
+// allVolumes returns a list of the existing volumes owned by the given
+// tenant, ordered by id. If tenantId is empty, all volumes are
+// returned.
+func (c *Cinder) allVolumes(tenantId string) []cinder.Volume {
+	c.mu.Lock()
+	volumes := make([]cinder.Volume, 0, len(c.volumes))
+	for _, volume := range c.volumes {
+		if tenantId != "" && volume.Os_Vol_Tenant_Attr_TenantID != tenantId {
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+	c.mu.Unlock()
+	sort.Sort(volumesById(volumes))
+	return volumes
+}
+
+// removeVolume deletes an existing volume. Volumes which are attached
+// to a server cannot be removed, nor can volumes with snapshots unless
+// cascade is true, when the snapshots are deleted too.
+func (c *Cinder) removeVolume(volumeId string, cascade bool) error {
+	if err := c.ProcessFunctionHook(c, volumeId, cascade); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	volume, ok := c.volumes[volumeId]
+	if !ok {
+		return testservices.NewVolumeNotFoundError(volumeId)
+	}
+	if volume.Status == StatusInUse {
+		return testservices.NewVolumeNotAvailableError(volumeId, volume.Status)
+	}
+	var dependents []string
+	for id, snapshot := range c.snapshots {
+		if snapshot.VolumeID == volumeId {
+			dependents = append(dependents, id)
+		}
+	}
+	if len(dependents) > 0 && !cascade {
+		return testservices.NewVolumeHasSnapshotsError(volumeId, len(dependents))
+	}
+	for _, id := range dependents {
+		delete(c.snapshots, id)
+	}
+	delete(c.volumes, volumeId)
+	return nil
+}
+
+// SetVolumeStatus sets the status of an existing volume.
+//
+// Note: this is implemented as a public method rather than as part
+// of the HTTP API so that tests can advance volumes through their
+// lifecycle, for example from "creating" to "available", at a time
+// of their choosing.
+func (c *Cinder) SetVolumeStatus(volumeId, status string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	volume, ok := c.volumes[volumeId]
+	if !ok {
+		return testservices.NewVolumeNotFoundError(volumeId)
+	}
+	volume.Status = status
+	c.volumes[volumeId] = volume
+	return nil
+}
+
+// VolumeStatus returns the status of an existing volume.
+func (c *Cinder) VolumeStatus(volumeId string) (string, error) {
+	volume, err := c.volume(volumeId)
+	if err != nil {
+		return "", err
+	}
+	return volume.Status, nil
+}
+
+// AttachVolume records the attachment of an available volume to the
+// given server, as the compute service does when the volume is
+// attached to one of its servers.
+func (c *Cinder) AttachVolume(volumeId, serverId, device string) error {
+	return c.attachVolume(volumeId, serverId, device)
+}
+
+// DetachVolume removes the attachment of an in-use volume, as the
+// compute service does when the volume is detached from its server.
+func (c *Cinder) DetachVolume(volumeId string) error {
+	return c.detachVolume(volumeId)
+}
+
+// AddSnapshot adds a volume snapshot, from which volumes may be
+// created. A snapshot without a status is available.
+//
+// Note: this is implemented as a public method rather than as part
+// of the HTTP API so that tests can provide snapshots, for servers
+// to boot from for example, without creating their volumes.
+func (c *Cinder) AddSnapshot(snapshot cinder.Snapshot) error {
+	if snapshot.Status == "" {
+		snapshot.Status = StatusAvailable
+	}
+	return c.addSnapshot(snapshot)
+}
+
+// newSnapshot builds a snapshot in the "creating" state of an
+// existing volume, allocating it a new id. As in Cinder, a volume
+// which is attached to a server may only be snapshotted if force is
+// true.
+func (c *Cinder) newSnapshot(args cinder.CreateSnapshotSnapshotParams) (cinder.Snapshot, error) {
+	volume, err := c.volume(args.VolumeId)
+	if err != nil {
+		return cinder.Snapshot{}, err
+	}
+	if volume.Status != StatusAvailable && !(volume.Status == StatusInUse && args.Force) {
+		return cinder.Snapshot{}, testservices.NewInvalidVolumeStatusError(volume.ID, volume.Status, StatusAvailable)
+	}
+	c.mu.Lock()
+	id := c.NewSequentialID(testservices.ResourceSnapshot, &c.nextSnapshotId)
+	c.mu.Unlock()
+	snapshot := cinder.Snapshot{
+		ID:          id,
+		Name:        args.Name,
+		Description: args.Description,
+		VolumeID:    volume.ID,
+		Size:        volume.Size,
+		Status:      StatusCreating,
+		CreatedAt:   time.Now().UTC().Format("2006-01-02T15:04:05.000000"),
+	}
+	snapshot.Os_Extended_Snapshot_Attributes_Progress = "0%"
+	snapshot.Os_Extended_Snapshot_Attributes_ProjectID = c.TenantId
+	return snapshot, nil
+}
+
+// addSnapshot stores a new snapshot.
+func (c *Cinder) addSnapshot(snapshot cinder.Snapshot) error {
+	if err := c.ProcessFunctionHook(c, snapshot); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.snapshots[snapshot.ID]; ok {
+		return testservices.NewSnapshotAlreadyExistsError(snapshot.ID)
+	}
+	c.snapshots[snapshot.ID] = snapshot
+	return nil
+}
+
+// snapshot retrieves an existing snapshot by id.
+func (c *Cinder) snapshot(snapshotId string) (*cinder.Snapshot, error) {
+	if err := c.ProcessFunctionHook(c, snapshotId); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	snapshot, ok := c.snapshots[snapshotId]
+	c.mu.Unlock()
+	if !ok {
+		return nil, testservices.NewSnapshotNotFoundError(snapshotId)
+	}
+	return &snapshot, nil
+}
+
+type snapshotsById []cinder.Snapshot
+
+func (s snapshotsById) Len() int           { return len(s) }
+func (s snapshotsById) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s snapshotsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// allSnapshots returns a list of the existing snapshots owned by the
+// given tenant, ordered by id. If tenantId is empty, all snapshots are
+// returned.
+func (c *Cinder) allSnapshots(tenantId string) []cinder.Snapshot {
+	c.mu.Lock()
+	snapshots := make([]cinder.Snapshot, 0, len(c.snapshots))
+	for _, snapshot := range c.snapshots {
+		if tenantId != "" && snapshot.Os_Extended_Snapshot_Attributes_ProjectID != tenantId {
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	c.mu.Unlock()
+	sort.Sort(snapshotsById(snapshots))
+	return snapshots
+}
+
+// removeSnapshot deletes an existing snapshot. As in Cinder, only
+// available snapshots and those which failed may be removed.
+func (c *Cinder) removeSnapshot(snapshotId string) error {
+	if err := c.ProcessFunctionHook(c, snapshotId); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot, ok := c.snapshots[snapshotId]
+	if !ok {
+		return testservices.NewSnapshotNotFoundError(snapshotId)
+	}
+	if snapshot.Status != StatusAvailable && snapshot.Status != StatusError {
+		return testservices.NewInvalidSnapshotStatusError(snapshotId, snapshot.Status)
+	}
+	delete(c.snapshots, snapshotId)
+	return nil
+}
+
+// SetSnapshotStatus sets the status of an existing snapshot. Setting
+// it to "available" completes the snapshot's progress.
+//
+// Note: this is implemented as a public method rather than as part
+// of the HTTP API so that tests can advance snapshots from "creating"
+// to "available" at a time of their choosing, as SetVolumeStatus
+// does for volumes.
+func (c *Cinder) SetSnapshotStatus(snapshotId, status string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot, ok := c.snapshots[snapshotId]
+	if !ok {
+		return testservices.NewSnapshotNotFoundError(snapshotId)
+	}
+	snapshot.Status = status
+	if status == StatusAvailable {
+		snapshot.Os_Extended_Snapshot_Attributes_Progress = "100%"
+	}
+	c.snapshots[snapshotId] = snapshot
+	return nil
+}
+
+// SnapshotStatus returns the status of an existing snapshot.
+func (c *Cinder) SnapshotStatus(snapshotId string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot, ok := c.snapshots[snapshotId]
+	if !ok {
+		return "", testservices.NewSnapshotNotFoundError(snapshotId)
+	}
+	return snapshot.Status, nil
+}
+
+// CreateVolumeFromSnapshot creates an available, bootable volume from
+// an existing snapshot, as the compute service does when a server is
+// booted from the snapshot, and returns the new volume's id. A size
+// of zero means the size of the snapshot.
+func (c *Cinder) CreateVolumeFromSnapshot(snapshotId string, size int) (string, error) {
+	c.mu.Lock()
+	snapshot, ok := c.snapshots[snapshotId]
+	c.mu.Unlock()
+	if !ok {
+		return "", testservices.NewSnapshotNotFoundError(snapshotId)
+	}
+	if size == 0 {
+		size = snapshot.Size
+	}
+	volume := c.newVolume(cinder.CreateVolumeVolumeParams{
+		Size:       size,
+		SnapshotId: snapshotId,
+		Bootable:   true,
+	})
+	volume.Status = StatusAvailable
+	if err := c.addVolume(volume); err != nil {
+		return "", err
+	}
+	return volume.ID, nil
+}
+
+// attachVolume records the attachment of an available volume to the
+// given server, moving the volume to the "in-use" state.
+func (c *Cinder) attachVolume(volumeId, serverId, device string) error {
+	if err := c.ProcessFunctionHook(c, volumeId, serverId, device); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	volume, ok := c.volumes[volumeId]
+	if !ok {
+		return testservices.NewVolumeNotFoundError(volumeId)
+	}
+	if volume.Status != StatusAvailable {
+		return testservices.NewInvalidVolumeStatusError(volumeId, volume.Status, StatusAvailable)
+	}
+	volume.Status = StatusInUse
+	volume.Attachments = []interface{}{Attachment{
+		Id:       fmt.Sprintf("%s-%s", volumeId, serverId),
+		VolumeId: volumeId,
+		ServerId: serverId,
+		Device:   device,
+	}}
+	c.volumes[volumeId] = volume
+	return nil
+}
+
+// detachVolume removes the attachment of an in-use volume, moving
+// the volume back to the "available" state.
+func (c *Cinder) detachVolume(volumeId string) error {
+	if err := c.ProcessFunctionHook(c, volumeId); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	volume, ok := c.volumes[volumeId]
+	if !ok {
+		return testservices.NewVolumeNotFoundError(volumeId)
+	}
+	if volume.Status != StatusInUse {
+		return testservices.NewInvalidVolumeStatusError(volumeId, volume.Status, StatusInUse)
+	}
+	volume.Status = StatusAvailable
+	volume.Attachments = []interface{}{}
+	c.volumes[volumeId] = volume
+	return nil
+}
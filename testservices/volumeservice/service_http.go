@@ -0,0 +1,403 @@
+// Cinder double testing service - HTTP API implementation
+
+package volumeservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/goose.v1/cinder"
+	"gopkg.in/goose.v1/testservices"
+)
+
+const authToken = "X-Auth-Token"
+
+// unauthorizedResponse is the verbatim body of a real Cinder 401.
+const unauthorizedResponse = `{"error": {"message": "The request you have made requires authentication.", "code": 401, "title": "Unauthorized"}}`
+
+type cinderHandler struct {
+	c      *Cinder
+	method func(c *Cinder, w http.ResponseWriter, r *http.Request) error
+	// methods holds the HTTP methods supported by the route, as
+	// reported in response to OPTIONS requests.
+	methods []string
+}
+
+func (h *cinderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := h.c.DelayResponse(r); err != nil {
+		// The client has given up on the request.
+		return
+	}
+	h.c.AddResponseHeaders(w)
+	if h.c.HandleOptions(w, r, h.methods...) {
+		return
+	}
+	// handle invalid X-Auth-Token header
+	token := r.Header.Get(authToken)
+	user, err := h.c.IdentityService.FindUser(token)
+	if err != nil || h.c.TokenExpired(token) {
+		w.Header().Set("Content-Type", "application/json")
+		writeResponse(w, http.StatusUnauthorized, []byte(unauthorizedResponse))
+		return
+	}
+	if err := h.c.CheckRole(r, user); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.c.CheckRateLimit(r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	if err := h.c.LimitRequestBody(w, r); err != nil {
+		err.(http.Handler).ServeHTTP(w, r)
+		return
+	}
+	err = h.method(h.c, w, r)
+	if err == nil {
+		return
+	}
+	serverError, ok := err.(*testservices.ServerError)
+	if !ok {
+		serverError = testservices.NewInternalServerError(err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, serverError.Code(), []byte(serverError.AsJSON()))
+}
+
+func writeResponse(w http.ResponseWriter, code int, body []byte) {
+	// workaround for https://code.google.com/p/go/issues/detail?id=4454
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// sendJSON sends the specified response serialized as JSON.
+func sendJSON(code int, resp interface{}, w http.ResponseWriter, r *http.Request) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, code, data)
+	return nil
+}
+
+// handler returns an http.Handler for a route supporting the given
+// HTTP methods, served by the given method.
+func (c *Cinder) handler(method func(c *Cinder, w http.ResponseWriter, r *http.Request) error, methods ...string) http.Handler {
+	return &cinderHandler{c, method, methods}
+}
+
+// resourcePath splits the part of the request path following the
+// collection name into its components, e.g. "/v2/tenant/volumes/1/action"
+// gives ["1", "action"] for the "volumes" collection.
+func (c *Cinder) resourcePath(r *http.Request, collection string) []string {
+	prefix := fmt.Sprintf("/%s/%s/%s", c.VersionPath, c.TenantId, collection)
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if rest == "" {
+		return nil
+	}
+	return strings.Split(rest, "/")
+}
+
+// tenantScope returns the tenant of the caller's token, and the tenant
+// whose resources the request may see, as returned by
+// testservices.TenantScope.
+func (c *Cinder) tenantScope(r *http.Request) (tenantId, scope string, err error) {
+	user, err := c.IdentityService.FindUser(r.Header.Get(authToken))
+	if err != nil {
+		return "", "", err
+	}
+	scope, err = testservices.TenantScope(r, user)
+	if err != nil {
+		return "", "", testservices.NewBadRequestError(err.Error())
+	}
+	return user.TenantId, scope, nil
+}
+
+// ownedVolume retrieves an existing volume owned by the tenant the
+// request is scoped to. Other tenants' volumes are not found.
+func (c *Cinder) ownedVolume(volumeId, scope string) (*cinder.Volume, error) {
+	volume, err := c.volume(volumeId)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" && volume.Os_Vol_Tenant_Attr_TenantID != scope {
+		return nil, testservices.NewVolumeNotFoundError(volumeId)
+	}
+	return volume, nil
+}
+
+// ownedSnapshot retrieves an existing snapshot owned by the tenant the
+// request is scoped to. Other tenants' snapshots are not found.
+func (c *Cinder) ownedSnapshot(snapshotId, scope string) (*cinder.Snapshot, error) {
+	snapshot, err := c.snapshot(snapshotId)
+	if err != nil {
+		return nil, err
+	}
+	if scope != "" && snapshot.Os_Extended_Snapshot_Attributes_ProjectID != scope {
+		return nil, testservices.NewSnapshotNotFoundError(snapshotId)
+	}
+	return snapshot, nil
+}
+
+// handleVolumes handles the volumes HTTP API. Each tenant sees only
+// its own volumes, unless an admin asks for all tenants.
+func (c *Cinder) handleVolumes(w http.ResponseWriter, r *http.Request) error {
+	parts := c.resourcePath(r, "volumes")
+	tenantId, scope, err := c.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	// allowed holds the methods supported by the resource, reported
+	// when the request's method is not one of them.
+	var allowed []string
+	switch {
+	case len(parts) == 0:
+		allowed = []string{"GET", "POST"}
+		switch r.Method {
+		case "GET":
+			return c.listVolumes(scope, w, r, false)
+		case "POST":
+			return c.createVolume(tenantId, w, r)
+		}
+	case len(parts) == 1 && parts[0] == "detail":
+		allowed = []string{"GET"}
+		if r.Method == "GET" {
+			return c.listVolumes(scope, w, r, true)
+		}
+	case len(parts) == 1:
+		allowed = []string{"GET", "DELETE"}
+		switch r.Method {
+		case "GET":
+			volume, err := c.ownedVolume(parts[0], scope)
+			if err != nil {
+				return err
+			}
+			resp := struct {
+				Volume cinder.Volume `json:"volume"`
+			}{*volume}
+			return sendJSON(http.StatusOK, resp, w, r)
+		case "DELETE":
+			// Like Cinder, a volume's snapshots are only deleted
+			// with it if the request cascades.
+			cascade, _ := strconv.ParseBool(r.URL.Query().Get("cascade"))
+			if _, err := c.ownedVolume(parts[0], scope); err != nil {
+				return err
+			}
+			if err := c.removeVolume(parts[0], cascade); err != nil {
+				return err
+			}
+			writeResponse(w, http.StatusAccepted, nil)
+			return nil
+		}
+	case len(parts) == 2 && parts[1] == "action":
+		allowed = []string{"POST"}
+		if r.Method == "POST" {
+			if _, err := c.ownedVolume(parts[0], scope); err != nil {
+				return err
+			}
+			return c.volumeAction(parts[0], w, r)
+		}
+	default:
+		return testservices.NewNotFoundError("The resource could not be found.")
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	return testservices.NewMethodNotAllowedError(r.Method)
+}
+
+// listVolumes sends either the summary or the detailed list of the
+// volumes within the scope of the request.
+func (c *Cinder) listVolumes(scope string, w http.ResponseWriter, r *http.Request, detail bool) error {
+	volumes := c.allVolumes(scope)
+	if !detail {
+		for i, volume := range volumes {
+			volumes[i] = cinder.Volume{
+				ID:    volume.ID,
+				Name:  volume.Name,
+				Links: volume.Links,
+			}
+		}
+	}
+	resp := struct {
+		Volumes []cinder.Volume `json:"volumes"`
+	}{volumes}
+	return sendJSON(http.StatusOK, resp, w, r)
+}
+
+// createVolume handles a request to create a volume, which belongs to
+// the given tenant.
+func (c *Cinder) createVolume(tenantId string, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Volume *cinder.CreateVolumeVolumeParams `json:"volume"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Volume == nil {
+		return testservices.NewBadRequestError("Malformed request body")
+	}
+	if req.Volume.Size <= 0 {
+		return testservices.NewBadRequestError("Invalid input received: volume size must be a positive integer")
+	}
+	volume := c.newVolume(*req.Volume)
+	volume.Os_Vol_Tenant_Attr_TenantID = tenantId
+	if err := c.addVolume(volume); err != nil {
+		return err
+	}
+	resp := struct {
+		Volume cinder.Volume `json:"volume"`
+	}{volume}
+	return sendJSON(http.StatusAccepted, resp, w, r)
+}
+
+// volumeAction handles the os-attach and os-detach volume actions.
+func (c *Cinder) volumeAction(volumeId string, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Attach *struct {
+			InstanceUUID string `json:"instance_uuid"`
+			Mountpoint   string `json:"mountpoint"`
+		} `json:"os-attach"`
+		Detach *struct{} `json:"os-detach"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return testservices.NewBadRequestError("Malformed request body")
+	}
+	switch {
+	case req.Attach != nil:
+		err = c.attachVolume(volumeId, req.Attach.InstanceUUID, req.Attach.Mountpoint)
+	case req.Detach != nil:
+		err = c.detachVolume(volumeId)
+	default:
+		return testservices.NewBadRequestError("Unsupported volume action")
+	}
+	if err != nil {
+		return err
+	}
+	writeResponse(w, http.StatusAccepted, nil)
+	return nil
+}
+
+// handleSnapshots handles the snapshots HTTP API. Snapshots are
+// isolated by tenant as volumes are.
+func (c *Cinder) handleSnapshots(w http.ResponseWriter, r *http.Request) error {
+	parts := c.resourcePath(r, "snapshots")
+	tenantId, scope, err := c.tenantScope(r)
+	if err != nil {
+		return err
+	}
+	var allowed []string
+	switch {
+	case len(parts) == 0:
+		allowed = []string{"GET", "POST"}
+		switch r.Method {
+		case "GET":
+			return c.listSnapshots(scope, w, r, false)
+		case "POST":
+			return c.createSnapshot(tenantId, scope, w, r)
+		}
+	case len(parts) == 1 && parts[0] == "detail":
+		allowed = []string{"GET"}
+		if r.Method == "GET" {
+			return c.listSnapshots(scope, w, r, true)
+		}
+	case len(parts) == 1:
+		allowed = []string{"GET", "DELETE"}
+		switch r.Method {
+		case "GET":
+			snapshot, err := c.ownedSnapshot(parts[0], scope)
+			if err != nil {
+				return err
+			}
+			resp := struct {
+				Snapshot cinder.Snapshot `json:"snapshot"`
+			}{*snapshot}
+			return sendJSON(http.StatusOK, resp, w, r)
+		case "DELETE":
+			if _, err := c.ownedSnapshot(parts[0], scope); err != nil {
+				return err
+			}
+			if err := c.removeSnapshot(parts[0]); err != nil {
+				return err
+			}
+			writeResponse(w, http.StatusAccepted, nil)
+			return nil
+		}
+	default:
+		return testservices.NewNotFoundError("The resource could not be found.")
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	return testservices.NewMethodNotAllowedError(r.Method)
+}
+
+// listSnapshots sends either the summary or the detailed list of the
+// snapshots within the scope of the request. Only the detailed list
+// includes the extended snapshot attributes.
+func (c *Cinder) listSnapshots(scope string, w http.ResponseWriter, r *http.Request, detail bool) error {
+	snapshots := c.allSnapshots(scope)
+	if !detail {
+		for i := range snapshots {
+			snapshots[i].Os_Extended_Snapshot_Attributes_Progress = ""
+			snapshots[i].Os_Extended_Snapshot_Attributes_ProjectID = ""
+		}
+	}
+	resp := struct {
+		Snapshots []cinder.Snapshot `json:"snapshots"`
+	}{snapshots}
+	return sendJSON(http.StatusOK, resp, w, r)
+}
+
+// createSnapshot handles a request to create a snapshot, which belongs
+// to the given tenant, of a volume within the scope of the request.
+func (c *Cinder) createSnapshot(tenantId, scope string, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var req struct {
+		Snapshot *cinder.CreateSnapshotSnapshotParams `json:"snapshot"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Snapshot == nil {
+		return testservices.NewBadRequestError("Malformed request body")
+	}
+	if req.Snapshot.VolumeId == "" {
+		return testservices.NewBadRequestError("Invalid input received: 'volume_id' is a required property")
+	}
+	if _, err := c.ownedVolume(req.Snapshot.VolumeId, scope); err != nil {
+		return err
+	}
+	snapshot, err := c.newSnapshot(*req.Snapshot)
+	if err != nil {
+		return err
+	}
+	snapshot.Os_Extended_Snapshot_Attributes_ProjectID = tenantId
+	if err := c.addSnapshot(snapshot); err != nil {
+		return err
+	}
+	resp := struct {
+		Snapshot cinder.Snapshot `json:"snapshot"`
+	}{snapshot}
+	return sendJSON(http.StatusAccepted, resp, w, r)
+}
+
+// SetupHTTP attaches all the needed handlers to provide the HTTP API.
+func (c *Cinder) SetupHTTP(mux *http.ServeMux) {
+	handlers := map[string]http.Handler{
+		"volumes":   c.handler((*Cinder).handleVolumes, "GET", "POST", "DELETE"),
+		"snapshots": c.handler((*Cinder).handleSnapshots, "GET", "POST", "DELETE"),
+	}
+	for collection, h := range handlers {
+		path := fmt.Sprintf("/%s/%s/%s", c.VersionPath, c.TenantId, collection)
+		mux.Handle(path, h)
+		mux.Handle(path+"/", h)
+	}
+}
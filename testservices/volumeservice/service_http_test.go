@@ -0,0 +1,372 @@
+// Cinder double testing service - HTTP API tests
+
+package volumeservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/cinder"
+	"gopkg.in/goose.v1/identity"
+	"gopkg.in/goose.v1/testing/httpsuite"
+	"gopkg.in/goose.v1/testservices/identityservice"
+)
+
+type CinderHTTPSuite struct {
+	httpsuite.HTTPSuite
+	service *Cinder
+	token   string
+	client  *cinder.Client
+}
+
+var _ = gc.Suite(&CinderHTTPSuite{})
+
+func (s *CinderHTTPSuite) SetUpTest(c *gc.C) {
+	s.HTTPSuite.SetUpTest(c)
+	identityDouble := identityservice.NewUserPass()
+	userInfo := identityDouble.AddUser("fred", "secret", "tenant")
+	s.token = userInfo.Token
+	s.service = New(s.Server.URL, versionPath, userInfo.TenantId, region, identityDouble)
+	identityDouble.SetupHTTP(s.Mux)
+	s.service.SetupHTTP(s.Mux)
+	serverURL, err := url.Parse(s.Server.URL)
+	c.Assert(err, gc.IsNil)
+	s.client = cinder.NewClient(userInfo.TenantId, func(req *http.Request) (*http.Response, error) {
+		// The generated client always uses https; redirect it to the double.
+		req.URL.Scheme = serverURL.Scheme
+		req.URL.Host = serverURL.Host
+		req.Host = serverURL.Host
+		req.Header.Set(authToken, s.token)
+		return http.DefaultClient.Do(req)
+	})
+}
+
+// jsonRequest sends the given body as JSON to path, relative to the
+// service endpoint, using the suite's token.
+func (s *CinderHTTPSuite) jsonRequest(c *gc.C, method, path string, body interface{}) *http.Response {
+	jsonBody, err := json.Marshal(body)
+	c.Assert(err, gc.IsNil)
+	req, err := http.NewRequest(method, s.service.endpointURL(path), bytes.NewReader(jsonBody))
+	c.Assert(err, gc.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(authToken, s.token)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, gc.IsNil)
+	return resp
+}
+
+func assertErrorResponse(c *gc.C, resp *http.Response, code int, expected string) {
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, code)
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, gc.IsNil)
+	c.Assert(string(body), gc.Equals, expected)
+}
+
+func (s *CinderHTTPSuite) TestUnauthorized(c *gc.C) {
+	s.token = "bad-token"
+	_, err := s.client.GetVolumesSimple()
+	c.Assert(err, gc.ErrorMatches, `invalid status \(401\): .*requires authentication.*`)
+}
+
+func (s *CinderHTTPSuite) TestCatalog(c *gc.C) {
+	creds := identity.Credentials{User: "fred", Secrets: "secret", URL: s.Server.URL + "/tokens"}
+	auth, err := (&identity.UserPass{}).Auth(&creds)
+	c.Assert(err, gc.IsNil)
+	c.Assert(auth.RegionServiceURLs[region]["volumev2"], gc.Equals, s.service.endpointURL(""))
+}
+
+func (s *CinderHTTPSuite) TestCreateGetDeleteVolume(c *gc.C) {
+	created, err := s.client.CreateVolume(cinder.CreateVolumeVolumeParams{Name: "vol", Size: 5})
+	c.Assert(err, gc.IsNil)
+	c.Assert(created.Volume.Name, gc.Equals, "vol")
+	c.Assert(created.Volume.Size, gc.Equals, 5)
+	c.Assert(created.Volume.Status, gc.Equals, StatusCreating)
+
+	got, err := s.client.GetVolume(created.Volume.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.Volume.ID, gc.Equals, created.Volume.ID)
+
+	err = s.client.DeleteVolume(created.Volume.ID)
+	c.Assert(err, gc.IsNil)
+	_, err = s.client.GetVolume(created.Volume.ID)
+	c.Assert(err, gc.ErrorMatches, `invalid status \(404\): .*could not be found.*`)
+}
+
+func (s *CinderHTTPSuite) TestCreateVolumeBadSize(c *gc.C) {
+	resp := s.jsonRequest(c, "POST", "/volumes", map[string]interface{}{
+		"volume": map[string]interface{}{"name": "vol"},
+	})
+	assertErrorResponse(c, resp, http.StatusBadRequest,
+		`{"badRequest":{"message":"Invalid input received: volume size must be a positive integer", "code":400}}`)
+}
+
+func (s *CinderHTTPSuite) TestListVolumes(c *gc.C) {
+	for _, name := range []string{"vol1", "vol2"} {
+		_, err := s.client.CreateVolume(cinder.CreateVolumeVolumeParams{Name: name, Size: 1})
+		c.Assert(err, gc.IsNil)
+	}
+	simple, err := s.client.GetVolumesSimple()
+	c.Assert(err, gc.IsNil)
+	c.Assert(simple.Volumes, gc.HasLen, 2)
+	c.Assert(simple.Volumes[0].Name, gc.Equals, "vol1")
+	c.Assert(simple.Volumes[0].Status, gc.Equals, "")
+	detail, err := s.client.GetVolumesDetail()
+	c.Assert(err, gc.IsNil)
+	c.Assert(detail.Volumes, gc.HasLen, 2)
+	c.Assert(detail.Volumes[1].Name, gc.Equals, "vol2")
+	c.Assert(detail.Volumes[1].Status, gc.Equals, StatusCreating)
+}
+
+func (s *CinderHTTPSuite) TestAttachDetachVolume(c *gc.C) {
+	created, err := s.client.CreateVolume(cinder.CreateVolumeVolumeParams{Size: 1})
+	c.Assert(err, gc.IsNil)
+	id := created.Volume.ID
+	attach := map[string]interface{}{
+		"os-attach": map[string]string{"instance_uuid": "server", "mountpoint": "/dev/vdb"},
+	}
+	resp := s.jsonRequest(c, "POST", "/volumes/"+id+"/action", attach)
+	assertErrorResponse(c, resp, http.StatusBadRequest,
+		`{"badRequest":{"message":"Invalid volume: volume 1 has status \"creating\", expected \"available\"", "code":400}}`)
+
+	err = s.service.SetVolumeStatus(id, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	resp = s.jsonRequest(c, "POST", "/volumes/"+id+"/action", attach)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	got, err := s.client.GetVolume(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.Volume.Status, gc.Equals, StatusInUse)
+	c.Assert(got.Volume.Attachments, gc.HasLen, 1)
+
+	resp = s.jsonRequest(c, "POST", "/volumes/"+id+"/action", map[string]interface{}{"os-detach": struct{}{}})
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	got, err = s.client.GetVolume(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.Volume.Status, gc.Equals, StatusAvailable)
+	c.Assert(got.Volume.Attachments, gc.HasLen, 0)
+}
+
+func (s *CinderHTTPSuite) TestDeleteAttachedVolume(c *gc.C) {
+	created, err := s.client.CreateVolume(cinder.CreateVolumeVolumeParams{Size: 1})
+	c.Assert(err, gc.IsNil)
+	id := created.Volume.ID
+	err = s.service.SetVolumeStatus(id, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	err = s.service.AttachVolume(id, "server", "/dev/vdb")
+	c.Assert(err, gc.IsNil)
+	resp := s.jsonRequest(c, "DELETE", "/volumes/"+id, nil)
+	assertErrorResponse(c, resp, http.StatusConflict,
+		`{"conflictingRequest":{"message":"Invalid volume: volume 1 status must be available, but current status is: in-use", "code":409}}`)
+	_, err = s.client.GetVolume(id)
+	c.Assert(err, gc.IsNil)
+
+	err = s.service.DetachVolume(id)
+	c.Assert(err, gc.IsNil)
+	resp = s.jsonRequest(c, "DELETE", "/volumes/"+id, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+}
+
+func (s *CinderHTTPSuite) TestMethodNotAllowed(c *gc.C) {
+	for i, t := range []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{"PUT", "volumes", "GET, POST"},
+		{"POST", "volumes/detail", "GET"},
+		{"PUT", "volumes/1", "GET, DELETE"},
+		{"GET", "volumes/1/action", "POST"},
+		{"PUT", "snapshots", "GET, POST"},
+		{"POST", "snapshots/detail", "GET"},
+		{"PUT", "snapshots/1", "GET, DELETE"},
+	} {
+		c.Logf("test %d: %s %s", i, t.method, t.path)
+		resp := s.jsonRequest(c, t.method, t.path, nil)
+		c.Assert(resp.Header.Get("Allow"), gc.Equals, t.allow)
+		assertErrorResponse(c, resp, http.StatusMethodNotAllowed,
+			`{"badMethod":{"message":"The method `+t.method+` is not allowed for this resource.", "code":405}}`)
+	}
+}
+
+func (s *CinderHTTPSuite) TestOptions(c *gc.C) {
+	for _, path := range []string{"volumes", "snapshots/1"} {
+		req, err := http.NewRequest("OPTIONS", s.service.endpointURL(path), nil)
+		c.Assert(err, gc.IsNil)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, gc.IsNil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+		c.Assert(resp.Header.Get("Allow"), gc.Equals, "GET, POST, DELETE, OPTIONS")
+	}
+}
+
+// createAvailableVolume creates a volume and makes it available.
+func (s *CinderHTTPSuite) createAvailableVolume(c *gc.C) string {
+	created, err := s.client.CreateVolume(cinder.CreateVolumeVolumeParams{Size: 2})
+	c.Assert(err, gc.IsNil)
+	err = s.service.SetVolumeStatus(created.Volume.ID, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	return created.Volume.ID
+}
+
+func (s *CinderHTTPSuite) TestCreateGetDeleteSnapshot(c *gc.C) {
+	volumeId := s.createAvailableVolume(c)
+	created, err := s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{
+		VolumeId:    volumeId,
+		Name:        "snap",
+		Description: "backup",
+	})
+	c.Assert(err, gc.IsNil)
+	id := created.Snapshot.ID
+	c.Assert(id, gc.Equals, "1")
+	c.Assert(created.Snapshot.Name, gc.Equals, "snap")
+	c.Assert(created.Snapshot.Description, gc.Equals, "backup")
+	c.Assert(created.Snapshot.VolumeID, gc.Equals, volumeId)
+	c.Assert(created.Snapshot.Size, gc.Equals, 2)
+	c.Assert(created.Snapshot.Status, gc.Equals, StatusCreating)
+
+	// A snapshot cannot be deleted while it is being created.
+	resp := s.jsonRequest(c, "DELETE", "/snapshots/"+id, nil)
+	assertErrorResponse(c, resp, http.StatusBadRequest,
+		`{"badRequest":{"message":"Invalid snapshot: Snapshot 1 status must be available or error, but current status is: creating", "code":400}}`)
+
+	err = s.service.SetSnapshotStatus(id, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	got, err := s.client.GetSnapshot(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(got.Snapshot.Status, gc.Equals, StatusAvailable)
+	c.Assert(got.Snapshot.Os_Extended_Snapshot_Attributes_Progress, gc.Equals, "100%")
+
+	err = s.client.DeleteSnapshot(id)
+	c.Assert(err, gc.IsNil)
+	_, err = s.client.GetSnapshot(id)
+	c.Assert(err, gc.ErrorMatches, `.*Snapshot 1 could not be found.*`)
+}
+
+func (s *CinderHTTPSuite) TestCreateSnapshotInvalid(c *gc.C) {
+	resp := s.jsonRequest(c, "POST", "/snapshots", map[string]interface{}{"snapshot": map[string]string{}})
+	assertErrorResponse(c, resp, http.StatusBadRequest,
+		`{"badRequest":{"message":"Invalid input received: 'volume_id' is a required property", "code":400}}`)
+	resp = s.jsonRequest(c, "POST", "/snapshots", map[string]interface{}{"snapshot": map[string]string{"volume_id": "42"}})
+	assertErrorResponse(c, resp, http.StatusNotFound,
+		`{"itemNotFound":{"message":"Volume 42 could not be found", "code":404}}`)
+
+	// Attached volumes may only be snapshotted by force.
+	volumeId := s.createAvailableVolume(c)
+	err := s.service.AttachVolume(volumeId, "server", "/dev/vdb")
+	c.Assert(err, gc.IsNil)
+	_, err = s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volumeId})
+	c.Assert(err, gc.ErrorMatches, `.*volume 1 has status \\"in-use\\", expected \\"available\\".*`)
+	_, err = s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volumeId, Force: true})
+	c.Assert(err, gc.IsNil)
+}
+
+func (s *CinderHTTPSuite) TestListSnapshots(c *gc.C) {
+	volumeId := s.createAvailableVolume(c)
+	for _, name := range []string{"snap1", "snap2"} {
+		_, err := s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volumeId, Name: name})
+		c.Assert(err, gc.IsNil)
+	}
+	simple, err := s.client.GetSnapshotsSimple()
+	c.Assert(err, gc.IsNil)
+	c.Assert(simple.Snapshots, gc.HasLen, 2)
+	c.Assert(simple.Snapshots[0].Name, gc.Equals, "snap1")
+	c.Assert(simple.Snapshots[1].Name, gc.Equals, "snap2")
+	c.Assert(simple.Snapshots[0].Os_Extended_Snapshot_Attributes_ProjectID, gc.Equals, "")
+	detail, err := s.client.GetSnapshotsDetail()
+	c.Assert(err, gc.IsNil)
+	c.Assert(detail.Snapshots, gc.HasLen, 2)
+	c.Assert(detail.Snapshots[0].Os_Extended_Snapshot_Attributes_ProjectID, gc.Equals, s.service.TenantId)
+}
+
+func (s *CinderHTTPSuite) TestDeleteVolumeWithSnapshots(c *gc.C) {
+	volumeId := s.createAvailableVolume(c)
+	created, err := s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volumeId})
+	c.Assert(err, gc.IsNil)
+	resp := s.jsonRequest(c, "DELETE", "/volumes/"+volumeId, nil)
+	assertErrorResponse(c, resp, http.StatusConflict,
+		`{"conflictingRequest":{"message":"Invalid volume: Volume 1 still has 1 dependent snapshots.", "code":409}}`)
+
+	// Cascading deletes the snapshots along with the volume.
+	resp = s.jsonRequest(c, "DELETE", "/volumes/"+volumeId+"?cascade=true", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusAccepted)
+	_, err = s.client.GetVolume(volumeId)
+	c.Assert(err, gc.NotNil)
+	_, err = s.client.GetSnapshot(created.Snapshot.ID)
+	c.Assert(err, gc.NotNil)
+}
+
+// volumeIds returns the ids of the volumes listed with the given query.
+func (s *CinderHTTPSuite) volumeIds(c *gc.C, query string) []string {
+	resp := s.jsonRequest(c, "GET", "/volumes"+query, nil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	var result struct {
+		Volumes []cinder.Volume `json:"volumes"`
+	}
+	err := json.NewDecoder(resp.Body).Decode(&result)
+	c.Assert(err, gc.IsNil)
+	ids := []string{}
+	for _, volume := range result.Volumes {
+		ids = append(ids, volume.ID)
+	}
+	return ids
+}
+
+func (s *CinderHTTPSuite) TestTenantIsolation(c *gc.C) {
+	identityDouble := s.service.IdentityService.(*identityservice.UserPass)
+	other := identityDouble.AddUser("other", "secret", "other-tenant")
+	admin := identityDouble.AddUser("admin", "secret", "admin-tenant")
+	err := identityDouble.SetUserTenant("admin", admin.TenantId, "admin-tenant", []identityservice.RoleResponse{
+		{Id: "1", Name: "admin"},
+	})
+	c.Assert(err, gc.IsNil)
+	volumeId := s.createAvailableVolume(c)
+	created, err := s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volumeId})
+	c.Assert(err, gc.IsNil)
+	snapshotId := created.Snapshot.ID
+	owner := s.token
+
+	s.token = other.Token
+	c.Assert(s.volumeIds(c, ""), gc.DeepEquals, []string{})
+	c.Assert(s.volumeIds(c, "?all_tenants=1"), gc.DeepEquals, []string{})
+	snapshots, err := s.client.GetSnapshotsDetail()
+	c.Assert(err, gc.IsNil)
+	c.Assert(snapshots.Snapshots, gc.HasLen, 0)
+	_, err = s.client.GetVolume(volumeId)
+	c.Assert(err, gc.ErrorMatches, `invalid status \(404\): .*Volume 1 could not be found.*`)
+	err = s.client.DeleteVolume(volumeId)
+	c.Assert(err, gc.ErrorMatches, `invalid status \(404\): .*Volume 1 could not be found.*`)
+	_, err = s.client.GetSnapshot(snapshotId)
+	c.Assert(err, gc.ErrorMatches, `.*Snapshot 1 could not be found.*`)
+	_, err = s.client.CreateSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volumeId})
+	c.Assert(err, gc.ErrorMatches, `invalid status \(404\): .*Volume 1 could not be found.*`)
+	resp := s.jsonRequest(c, "POST", "/volumes/"+volumeId+"/action", map[string]interface{}{"os-detach": map[string]string{}})
+	assertErrorResponse(c, resp, http.StatusNotFound,
+		`{"itemNotFound":{"message":"Volume 1 could not be found", "code":404}}`)
+	mine, err := s.client.CreateVolume(cinder.CreateVolumeVolumeParams{Size: 1})
+	c.Assert(err, gc.IsNil)
+	c.Assert(mine.Volume.Os_Vol_Tenant_Attr_TenantID, gc.Equals, other.TenantId)
+
+	s.token = owner
+	c.Assert(s.volumeIds(c, ""), gc.DeepEquals, []string{volumeId})
+
+	s.token = admin.Token
+	c.Assert(s.volumeIds(c, ""), gc.DeepEquals, []string{})
+	c.Assert(s.volumeIds(c, "?all_tenants=1"), gc.DeepEquals, []string{volumeId, mine.Volume.ID})
+	resp = s.jsonRequest(c, "GET", "/snapshots/"+snapshotId+"?all_tenants=1", nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, gc.Equals, http.StatusOK)
+	resp = s.jsonRequest(c, "GET", "/volumes?all_tenants=maybe", nil)
+	assertErrorResponse(c, resp, http.StatusBadRequest,
+		`{"badRequest":{"message":"Invalid value 'maybe' for all_tenants", "code":400}}`)
+}
@@ -0,0 +1,183 @@
+// Cinder double testing service - internal direct API tests
+
+package volumeservice
+
+import (
+	gc "gopkg.in/check.v1"
+
+	"gopkg.in/goose.v1/cinder"
+)
+
+type CinderSuite struct {
+	service *Cinder
+}
+
+const (
+	versionPath = "v2"
+	hostname    = "http://example.com"
+	region      = "region"
+)
+
+var _ = gc.Suite(&CinderSuite{})
+
+func (s *CinderSuite) SetUpTest(c *gc.C) {
+	s.service = New(hostname, versionPath, "tenant", region, nil)
+}
+
+func (s *CinderSuite) createVolume(c *gc.C, size int) cinder.Volume {
+	volume := s.service.newVolume(cinder.CreateVolumeVolumeParams{Name: "vol", Size: size})
+	err := s.service.addVolume(volume)
+	c.Assert(err, gc.IsNil)
+	return volume
+}
+
+func (s *CinderSuite) TestNewVolume(c *gc.C) {
+	volume := s.createVolume(c, 10)
+	c.Assert(volume.ID, gc.Equals, "1")
+	c.Assert(volume.Name, gc.Equals, "vol")
+	c.Assert(volume.Size, gc.Equals, 10)
+	c.Assert(volume.Status, gc.Equals, StatusCreating)
+	c.Assert(volume.Os_Vol_Tenant_Attr_TenantID, gc.Equals, "tenant")
+	c.Assert(volume.Links, gc.HasLen, 1)
+	c.Assert(volume.Links[0].Href, gc.Equals, "http://example.com/v2/tenant/volumes/1")
+}
+
+func (s *CinderSuite) TestAddVolumeTwice(c *gc.C) {
+	volume := s.createVolume(c, 1)
+	err := s.service.addVolume(volume)
+	c.Assert(err, gc.ErrorMatches, `conflictingRequest: A volume with id "1" already exists`)
+}
+
+func (s *CinderSuite) TestGetVolume(c *gc.C) {
+	volume := s.createVolume(c, 1)
+	found, err := s.service.volume(volume.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(*found, gc.DeepEquals, volume)
+}
+
+func (s *CinderSuite) TestGetMissingVolume(c *gc.C) {
+	_, err := s.service.volume("42")
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: Volume 42 could not be found")
+}
+
+func (s *CinderSuite) TestAllVolumes(c *gc.C) {
+	c.Assert(s.service.allVolumes(""), gc.HasLen, 0)
+	v1 := s.createVolume(c, 1)
+	v2 := s.createVolume(c, 2)
+	c.Assert(s.service.allVolumes(""), gc.DeepEquals, []cinder.Volume{v1, v2})
+}
+
+func (s *CinderSuite) TestRemoveVolume(c *gc.C) {
+	volume := s.createVolume(c, 1)
+	err := s.service.removeVolume(volume.ID, false)
+	c.Assert(err, gc.IsNil)
+	_, err = s.service.volume(volume.ID)
+	c.Assert(err, gc.NotNil)
+}
+
+func (s *CinderSuite) TestSetVolumeStatus(c *gc.C) {
+	volume := s.createVolume(c, 1)
+	err := s.service.SetVolumeStatus(volume.ID, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	found, err := s.service.volume(volume.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Status, gc.Equals, StatusAvailable)
+	err = s.service.SetVolumeStatus("42", StatusAvailable)
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: Volume 42 could not be found")
+}
+
+func (s *CinderSuite) TestAttachDetachVolume(c *gc.C) {
+	volume := s.createVolume(c, 1)
+	err := s.service.attachVolume(volume.ID, "server", "/dev/vdb")
+	c.Assert(err, gc.ErrorMatches, `badRequest: Invalid volume: volume 1 has status "creating", expected "available"`)
+	err = s.service.SetVolumeStatus(volume.ID, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	err = s.service.attachVolume(volume.ID, "server", "/dev/vdb")
+	c.Assert(err, gc.IsNil)
+	found, err := s.service.volume(volume.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Status, gc.Equals, StatusInUse)
+	c.Assert(found.Attachments, gc.DeepEquals, []interface{}{Attachment{
+		Id:       "1-server",
+		VolumeId: "1",
+		ServerId: "server",
+		Device:   "/dev/vdb",
+	}})
+	err = s.service.removeVolume(volume.ID, false)
+	c.Assert(err, gc.ErrorMatches, "conflictingRequest: Invalid volume: volume 1 status must be available, but current status is: in-use")
+
+	err = s.service.detachVolume(volume.ID)
+	c.Assert(err, gc.IsNil)
+	found, err = s.service.volume(volume.ID)
+	c.Assert(err, gc.IsNil)
+	c.Assert(found.Status, gc.Equals, StatusAvailable)
+	c.Assert(found.Attachments, gc.HasLen, 0)
+	err = s.service.detachVolume(volume.ID)
+	c.Assert(err, gc.ErrorMatches, `badRequest: Invalid volume: volume 1 has status "available", expected "in-use"`)
+}
+
+func (s *CinderSuite) TestAddSnapshot(c *gc.C) {
+	err := s.service.AddSnapshot(cinder.Snapshot{ID: "snap", Size: 5})
+	c.Assert(err, gc.IsNil)
+	status, err := s.service.SnapshotStatus("snap")
+	c.Assert(err, gc.IsNil)
+	c.Assert(status, gc.Equals, StatusAvailable)
+	err = s.service.AddSnapshot(cinder.Snapshot{ID: "snap"})
+	c.Assert(err, gc.ErrorMatches, `conflictingRequest: A snapshot with id "snap" already exists`)
+	_, err = s.service.SnapshotStatus("missing")
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: Snapshot missing could not be found.")
+}
+
+func (s *CinderSuite) TestCreateVolumeFromSnapshot(c *gc.C) {
+	err := s.service.AddSnapshot(cinder.Snapshot{ID: "snap", Size: 5})
+	c.Assert(err, gc.IsNil)
+	id, err := s.service.CreateVolumeFromSnapshot("snap", 0)
+	c.Assert(err, gc.IsNil)
+	volume, err := s.service.volume(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(volume.Size, gc.Equals, 5)
+	c.Assert(volume.SnapshotID, gc.Equals, "snap")
+	c.Assert(volume.Bootable, gc.Equals, "true")
+	c.Assert(volume.Status, gc.Equals, StatusAvailable)
+	id, err = s.service.CreateVolumeFromSnapshot("snap", 10)
+	c.Assert(err, gc.IsNil)
+	volume, err = s.service.volume(id)
+	c.Assert(err, gc.IsNil)
+	c.Assert(volume.Size, gc.Equals, 10)
+	_, err = s.service.CreateVolumeFromSnapshot("missing", 0)
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: Snapshot missing could not be found.")
+}
+
+func (s *CinderSuite) TestNewSnapshot(c *gc.C) {
+	volume := s.createVolume(c, 3)
+	_, err := s.service.newSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volume.ID})
+	c.Assert(err, gc.ErrorMatches, `badRequest: Invalid volume: volume 1 has status "creating", expected "available"`)
+	err = s.service.SetVolumeStatus(volume.ID, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	snapshot, err := s.service.newSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volume.ID, Name: "snap"})
+	c.Assert(err, gc.IsNil)
+	c.Assert(snapshot.ID, gc.Equals, "1")
+	c.Assert(snapshot.Name, gc.Equals, "snap")
+	c.Assert(snapshot.VolumeID, gc.Equals, volume.ID)
+	c.Assert(snapshot.Size, gc.Equals, 3)
+	c.Assert(snapshot.Status, gc.Equals, StatusCreating)
+	c.Assert(snapshot.Os_Extended_Snapshot_Attributes_ProjectID, gc.Equals, "tenant")
+	_, err = s.service.newSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: "42"})
+	c.Assert(err, gc.ErrorMatches, "itemNotFound: Volume 42 could not be found")
+}
+
+func (s *CinderSuite) TestReset(c *gc.C) {
+	volume := s.createVolume(c, 10)
+	err := s.service.SetVolumeStatus(volume.ID, StatusAvailable)
+	c.Assert(err, gc.IsNil)
+	snapshot, err := s.service.newSnapshot(cinder.CreateSnapshotSnapshotParams{VolumeId: volume.ID})
+	c.Assert(err, gc.IsNil)
+	err = s.service.addSnapshot(snapshot)
+	c.Assert(err, gc.IsNil)
+	s.service.Reset()
+	c.Assert(s.service.allVolumes(""), gc.HasLen, 0)
+	c.Assert(s.service.allSnapshots(""), gc.HasLen, 0)
+	// Ids are numbered from the start again.
+	volume = s.createVolume(c, 10)
+	c.Assert(volume.ID, gc.Equals, "1")
+}